@@ -0,0 +1,103 @@
+package stomp
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// pendingAck is the latest acknowledgement of a subscription that has
+// not been sent yet, see ConnOpt.AckBatch.
+type pendingAck struct {
+	f     *frame.Frame
+	m     *Message
+	count int
+	timer *time.Timer
+}
+
+// batchesAck reports whether the acknowledgement of m is batched.
+func (c *Conn) batchesAck(m *Message) bool {
+	return (c.ackBatchSize > 1 || c.ackBatchInterval > 0) &&
+		m.Subscription.AckMode() == AckClient
+}
+
+// batchAck records f, the ACK frame for m, as the latest
+// acknowledgement of the subscription of m, and sends it when the
+// batch is full.
+func (c *Conn) batchAck(ctx context.Context, f *frame.Frame, m *Message) error {
+	id := m.Subscription.Id()
+	c.ackMutex.Lock()
+	if c.pendingAcks == nil {
+		c.pendingAcks = make(map[string]*pendingAck)
+	}
+	p, ok := c.pendingAcks[id]
+	if !ok {
+		p = &pendingAck{}
+		c.pendingAcks[id] = p
+	}
+	p.f, p.m = f, m
+	p.count++
+	if c.ackBatchSize > 0 && p.count >= c.ackBatchSize {
+		c.removePendingAck(id)
+		c.ackMutex.Unlock()
+		return c.sendAckNackFrame(ctx, p.f, p.m)
+	}
+	if p.timer == nil && c.ackBatchInterval > 0 {
+		p.timer = time.AfterFunc(c.ackBatchInterval, func() {
+			if err := c.flushAck(context.Background(), id); err != nil {
+				c.log.Warningf("sending batched ACK for subscription %s failed: %v", id, err)
+			}
+		})
+	}
+	c.ackMutex.Unlock()
+	return nil
+}
+
+// removePendingAck forgets the pending acknowledgement of the
+// subscription with id, and returns it. The caller holds ackMutex.
+func (c *Conn) removePendingAck(id string) *pendingAck {
+	p, ok := c.pendingAcks[id]
+	if !ok {
+		return nil
+	}
+	delete(c.pendingAcks, id)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	return p
+}
+
+// flushAck sends the pending acknowledgement of the subscription with
+// id, if there is one.
+func (c *Conn) flushAck(ctx context.Context, id string) error {
+	c.ackMutex.Lock()
+	p := c.removePendingAck(id)
+	c.ackMutex.Unlock()
+	if p == nil {
+		return nil
+	}
+	return c.sendAckNackFrame(ctx, p.f, p.m)
+}
+
+// FlushAcks sends the acknowledgements that have been batched because
+// of the AckBatch connect option, without waiting for the batches to
+// fill up. Pending acknowledgements are also sent before a NACK frame
+// or an UNSUBSCRIBE frame for the same subscription, and before the
+// DISCONNECT frame. The first error is returned.
+func (c *Conn) FlushAcks() error {
+	c.ackMutex.Lock()
+	var ids []string
+	for id := range c.pendingAcks {
+		ids = append(ids, id)
+	}
+	c.ackMutex.Unlock()
+
+	var err error
+	for _, id := range ids {
+		if e := c.flushAck(context.Background(), id); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
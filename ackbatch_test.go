@@ -0,0 +1,94 @@
+package stomp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+
+	. "gopkg.in/check.v1"
+)
+
+// connectAckBatch connects with opts to a fake server that sends count
+// messages for the first SUBSCRIBE frame, and sends the frames that it
+// receives after that to frames.
+func connectAckBatch(c *C, count int, frames chan<- *frame.Frame, opts ...func(*Conn) error) *Conn {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		for i := 1; i <= count; i++ {
+			c.Assert(writer.Write(frame.New(frame.MESSAGE,
+				frame.Subscription, f.Header.Get(frame.Id),
+				frame.Ack, "m"+strconv.Itoa(i))), IsNil)
+		}
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				return
+			}
+			frames <- f
+			if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+				writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+			}
+		}
+	}()
+	conn, err := Connect(fc1, opts...)
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *StompSuite) Test_ack_batch_count(c *C) {
+	frames := make(chan *frame.Frame, 10)
+	conn := connectAckBatch(c, 7, frames, ConnOpt.AckBatch(3, 0))
+	sub, err := conn.Subscribe("/queue/test", AckClient)
+	c.Assert(err, IsNil)
+	for i := 0; i < 7; i++ {
+		c.Assert(conn.Ack(<-sub.C), IsNil)
+	}
+	c.Assert(sub.Unsubscribe(), IsNil)
+
+	// the pending ACK is sent before the UNSUBSCRIBE frame
+	for _, expected := range [][2]string{
+		{frame.ACK, "m3"}, {frame.ACK, "m6"}, {frame.ACK, "m7"}, {frame.UNSUBSCRIBE, sub.Id()},
+	} {
+		f := <-frames
+		c.Check(f.Command, Equals, expected[0])
+		c.Check(f.Header.Get(frame.Id), Equals, expected[1])
+	}
+	c.Assert(conn.Disconnect(), IsNil)
+}
+
+func (s *StompSuite) Test_ack_batch_interval(c *C) {
+	frames := make(chan *frame.Frame, 10)
+	conn := connectAckBatch(c, 3, frames, ConnOpt.AckBatch(0, 20*time.Millisecond))
+	sub, err := conn.Subscribe("/queue/test", AckClient)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Ack(<-sub.C), IsNil)
+	c.Assert(conn.Ack(<-sub.C), IsNil)
+
+	select {
+	case f := <-frames:
+		c.Check(f.Command, Equals, frame.ACK)
+		c.Check(f.Header.Get(frame.Id), Equals, "m2")
+	case <-time.After(5 * time.Second):
+		c.Fatal("batched ACK not sent")
+	}
+
+	// a NACK sends the pending ACK first
+	c.Assert(conn.Ack(<-sub.C), IsNil)
+	msg := &Message{Conn: conn, Subscription: sub, Header: frame.NewHeader(frame.Ack, "m4")}
+	c.Assert(conn.Nack(msg), IsNil)
+	f := <-frames
+	c.Check(f.Command+" "+f.Header.Get(frame.Id), Equals, "ACK m3")
+	f = <-frames
+	c.Check(f.Command+" "+f.Header.Get(frame.Id), Equals, "NACK m4")
+	c.Assert(conn.Disconnect(), IsNil)
+}
@@ -0,0 +1,86 @@
+package stomp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tokens of the capabilities header entry.
+const (
+	capWildcards    = "wildcards"
+	capSelectors    = "selectors"
+	capPersistence  = "persistence"
+	capMaxFrameSize = "max-frame-size="
+)
+
+// Capabilities are the optional features of a STOMP server, which it
+// advertises in the capabilities header entry of its CONNECTED frame,
+// as a comma-separated list such as
+//
+//	capabilities:wildcards,persistence,max-frame-size=1048576
+//
+// The header entry is not defined by the STOMP standard, so a client
+// connected to a server that does not send it knows of no capabilities.
+type Capabilities struct {
+	Wildcards    bool // subscriptions to destination patterns, such as /topic/orders.>
+	Selectors    bool // subscriptions with a selector header entry
+	Persistence  bool // messages sent to queues are kept in durable storage
+	MaxFrameSize int  // largest body, in bytes, of the frames that the server accepts, or zero if not advertised
+}
+
+// ParseCapabilities parses the value of a capabilities header entry.
+// Tokens that are not known are ignored, so that servers can advertise
+// capabilities that clients do not know about yet.
+func ParseCapabilities(value string) Capabilities {
+	var c Capabilities
+	for _, token := range strings.Split(value, ",") {
+		token = strings.TrimSpace(token)
+		switch {
+		case token == capWildcards:
+			c.Wildcards = true
+		case token == capSelectors:
+			c.Selectors = true
+		case token == capPersistence:
+			c.Persistence = true
+		case strings.HasPrefix(token, capMaxFrameSize):
+			if size, err := strconv.Atoi(token[len(capMaxFrameSize):]); err == nil && size > 0 {
+				c.MaxFrameSize = size
+			}
+		}
+	}
+	return c
+}
+
+// String returns the value of the capabilities header entry that
+// advertises the capabilities.
+func (c Capabilities) String() string {
+	var tokens []string
+	if c.Wildcards {
+		tokens = append(tokens, capWildcards)
+	}
+	if c.Selectors {
+		tokens = append(tokens, capSelectors)
+	}
+	if c.Persistence {
+		tokens = append(tokens, capPersistence)
+	}
+	if c.MaxFrameSize > 0 {
+		tokens = append(tokens, capMaxFrameSize+strconv.Itoa(c.MaxFrameSize))
+	}
+	return strings.Join(tokens, ",")
+}
+
+// Negotiated describes what a client and a server agreed on when the
+// client connected, as seen from one side of the connection.
+type Negotiated struct {
+	Version      Version      // Version of the STOMP protocol
+	Session      string       // Session identifier, if the server sent one
+	Server       string       // Server header entry of the CONNECTED frame, if any
+	Capabilities Capabilities // Optional features of the server
+
+	// Interval at which this side sends heart-beats, and interval at
+	// which it expects to receive them, zero if there are none.
+	SendHeartBeat    time.Duration
+	ReceiveHeartBeat time.Duration
+}
@@ -0,0 +1,20 @@
+package stomp
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type CapabilitiesSuite struct{}
+
+var _ = Suite(&CapabilitiesSuite{})
+
+func (s *CapabilitiesSuite) TestParseCapabilities(c *C) {
+	capabilities := ParseCapabilities("wildcards, persistence,max-frame-size=1024,transactions")
+	c.Check(capabilities, Equals, Capabilities{Wildcards: true, Persistence: true, MaxFrameSize: 1024})
+	c.Check(capabilities.String(), Equals, "wildcards,persistence,max-frame-size=1024")
+	c.Check(ParseCapabilities(capabilities.String()), Equals, capabilities)
+
+	c.Check(ParseCapabilities(""), Equals, Capabilities{})
+	c.Check(ParseCapabilities("selectors,max-frame-size=x"), Equals, Capabilities{Selectors: true})
+	c.Check(Capabilities{}.String(), Equals, "")
+}
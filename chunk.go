@@ -0,0 +1,199 @@
+package stomp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Header entries of the SEND frames that Conn.SendChunked sends for
+// each part of a large message.
+const (
+	ChunkIdHeader    = "chunk-id"    // identifies the message that the chunk is part of
+	ChunkIndexHeader = "chunk-index" // position of the chunk, starting at 0
+	ChunkTotalHeader = "chunk-total" // number of chunks of the message
+)
+
+// SendChunked sends a message like Conn.Send, but if the body is
+// longer than chunkSize, it is split into chunks of at most chunkSize
+// bytes, which are sent as separate SEND frames with the same header
+// entries and options, plus the chunk-id, chunk-index and chunk-total
+// header entries. A Reassembler puts the chunks together again on the
+// receiving side.
+//
+// This is meant for brokers that limit the size of frames. The chunks
+// are sent in order, but a broker may deliver them to different
+// subscribers of a queue, so every chunk of a message should reach
+// the same subscriber.
+func (c *Conn) SendChunked(destination, contentType string, body []byte, chunkSize int, opts ...func(*frame.Frame) error) error {
+	if chunkSize < 1 {
+		return ErrInvalidChunkSize
+	}
+	if len(body) <= chunkSize {
+		return c.Send(destination, contentType, body, opts...)
+	}
+
+	id, err := newChunkId()
+	if err != nil {
+		return err
+	}
+	total := (len(body) + chunkSize - 1) / chunkSize
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+		chunkOpts := append(opts[:len(opts):len(opts)],
+			SendOpt.Header(ChunkIdHeader, id),
+			SendOpt.Header(ChunkIndexHeader, strconv.Itoa(i)),
+			SendOpt.Header(ChunkTotalHeader, strconv.Itoa(total)))
+		if err := c.Send(destination, contentType, body[i*chunkSize:end], chunkOpts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newChunkId returns a random chunk id, so that the chunks of messages
+// from different clients are not mixed up.
+func newChunkId() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// A Reassembler puts together the messages sent by Conn.SendChunked
+// from their chunks. The zero value is ready to use, and a Reassembler
+// can be used by several goroutines at once.
+type Reassembler struct {
+	// MaxSize, if not zero, is the largest body that is reassembled.
+	// The chunks of a larger message are discarded.
+	MaxSize int
+
+	// Timeout, if not zero, is how long the chunks of an incomplete
+	// message are kept after the last of them arrived.
+	Timeout time.Duration
+
+	mutex   sync.Mutex
+	pending map[string]*chunkedMessage
+}
+
+// chunkedMessage holds the chunks of a message received so far.
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	last     time.Time
+}
+
+// Add adds a message received from the server. If msg is not a chunk,
+// it is returned unchanged. If it completes a message, the complete
+// message is returned; it has the header of the chunk that completed
+// it, without the chunk header entries, so that acknowledging it in
+// AckClient mode acknowledges all the chunks. Otherwise Add returns
+// nil, and the chunk is kept until the other chunks arrive.
+//
+// Add returns an error if msg carries one, if its chunk header entries
+// are invalid, or if the message is larger than MaxSize.
+func (r *Reassembler) Add(msg *Message) (*Message, error) {
+	if msg.Err != nil {
+		return nil, msg.Err
+	}
+	id, ok := msg.Header.Contains(ChunkIdHeader)
+	if !ok {
+		return msg, nil
+	}
+	index, err := strconv.Atoi(msg.Header.Get(ChunkIndexHeader))
+	if err != nil {
+		return nil, invalidHeader(ChunkIndexHeader, msg)
+	}
+	total, err := strconv.Atoi(msg.Header.Get(ChunkTotalHeader))
+	if err != nil || total < 1 {
+		return nil, invalidHeader(ChunkTotalHeader, msg)
+	}
+	if index < 0 || index >= total {
+		return nil, invalidHeader(ChunkIndexHeader, msg)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.expire(now)
+	if r.pending == nil {
+		r.pending = make(map[string]*chunkedMessage)
+	}
+	m, ok := r.pending[id]
+	if !ok {
+		m = &chunkedMessage{chunks: make([][]byte, total)}
+		r.pending[id] = m
+	}
+	if len(m.chunks) != total {
+		delete(r.pending, id)
+		return nil, invalidHeader(ChunkTotalHeader, msg)
+	}
+	m.last = now
+	if m.chunks[index] != nil {
+		// the chunk has been delivered again
+		return nil, nil
+	}
+	m.chunks[index] = msg.Body
+	if m.chunks[index] == nil {
+		m.chunks[index] = []byte{}
+	}
+	m.received++
+	m.size += len(msg.Body)
+	if r.MaxSize > 0 && m.size > r.MaxSize {
+		delete(r.pending, id)
+		return nil, newErrorMessage("chunked message " + id + " exceeds the maximum size")
+	}
+	if m.received < total {
+		return nil, nil
+	}
+	delete(r.pending, id)
+
+	body := make([]byte, 0, m.size)
+	for _, chunk := range m.chunks {
+		body = append(body, chunk...)
+	}
+	header := msg.Header.Clone()
+	header.Del(ChunkIdHeader)
+	header.Del(ChunkIndexHeader)
+	header.Del(ChunkTotalHeader)
+	header.Set(frame.ContentLength, strconv.Itoa(len(body)))
+	complete := *msg
+	complete.Header = header
+	complete.Body = body
+	return &complete, nil
+}
+
+// Pending returns the number of messages whose chunks have not all
+// arrived.
+func (r *Reassembler) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire(time.Now())
+	return len(r.pending)
+}
+
+// expire discards the incomplete messages whose last chunk arrived
+// longer than Timeout before now.
+func (r *Reassembler) expire(now time.Time) {
+	if r.Timeout <= 0 {
+		return
+	}
+	for id, m := range r.pending {
+		if now.Sub(m.last) > r.Timeout {
+			delete(r.pending, id)
+		}
+	}
+}
+
+func invalidHeader(name string, msg *Message) error {
+	return newErrorMessage("invalid header: " + name + ": " + msg.Header.Get(name))
+}
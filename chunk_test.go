@@ -0,0 +1,105 @@
+package stomp
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+// chunkMessage returns a message with the header and body of f.
+func chunkMessage(f *frame.Frame) *Message {
+	return &Message{
+		Destination: f.Header.Get(frame.Destination),
+		ContentType: f.Header.Get(frame.ContentType),
+		Header:      f.Header,
+		Body:        f.Body,
+	}
+}
+
+func (s *StompSuite) TestSendChunked(c *C) {
+	conn, rw := connectHelper(c, V12)
+	frames := make(chan *frame.Frame, 4)
+	go func() {
+		for i := 0; i < 4; i++ {
+			f, err := rw.Read()
+			c.Assert(err, IsNil)
+			frames <- f
+		}
+	}()
+
+	c.Assert(conn.SendChunked("/queue/blobs", "text/plain", []byte("0123456789"), 4,
+		SendOpt.Header("x-name", "digits")), IsNil)
+	c.Assert(conn.SendChunked("/queue/blobs", "text/plain", []byte("small"), 5), IsNil)
+	c.Check(conn.SendChunked("/queue/blobs", "", nil, 0), Equals, ErrInvalidChunkSize)
+
+	var chunks []*frame.Frame
+	for i := 0; i < 3; i++ {
+		f := <-frames
+		c.Check(f.Header.Get(ChunkIndexHeader), Equals, []string{"0", "1", "2"}[i])
+		c.Check(f.Header.Get(ChunkTotalHeader), Equals, "3")
+		c.Check(f.Header.Get(ChunkIdHeader), Matches, "[0-9a-f]{32}")
+		c.Check(f.Header.Get("x-name"), Equals, "digits")
+		chunks = append(chunks, f)
+	}
+	c.Check(chunks[1].Header.Get(ChunkIdHeader), Equals, chunks[0].Header.Get(ChunkIdHeader))
+	c.Check(string(chunks[2].Body), Equals, "89")
+	small := <-frames
+	_, ok := small.Header.Contains(ChunkIdHeader)
+	c.Check(ok, Equals, false)
+	conn.MustDisconnect()
+
+	// the chunks are reassembled in any order, and repeated chunks are
+	// ignored
+	r := &Reassembler{}
+	for _, i := range []int{2, 0, 2} {
+		msg, err := r.Add(chunkMessage(chunks[i]))
+		c.Assert(err, IsNil)
+		c.Check(msg, IsNil)
+	}
+	c.Check(r.Pending(), Equals, 1)
+	msg, err := r.Add(chunkMessage(chunks[1]))
+	c.Assert(err, IsNil)
+	c.Check(string(msg.Body), Equals, "0123456789")
+	c.Check(msg.Header.Get(frame.ContentLength), Equals, "10")
+	c.Check(msg.Header.Get("x-name"), Equals, "digits")
+	_, ok = msg.Header.Contains(ChunkIdHeader)
+	c.Check(ok, Equals, false)
+	c.Check(r.Pending(), Equals, 0)
+
+	// other messages are returned unchanged
+	other := chunkMessage(small)
+	msg, err = r.Add(other)
+	c.Assert(err, IsNil)
+	c.Check(msg, Equals, other)
+}
+
+func (s *StompSuite) TestReassemblerLimits(c *C) {
+	chunk := func(id, index, total, body string) *Message {
+		return &Message{
+			Header: frame.NewHeader(ChunkIdHeader, id,
+				ChunkIndexHeader, index, ChunkTotalHeader, total),
+			Body: []byte(body),
+		}
+	}
+
+	r := &Reassembler{MaxSize: 5}
+	_, err := r.Add(chunk("a", "0", "2", "abc"))
+	c.Assert(err, IsNil)
+	_, err = r.Add(chunk("a", "1", "2", "def"))
+	c.Check(err, ErrorMatches, "chunked message a exceeds the maximum size")
+	c.Check(r.Pending(), Equals, 0)
+
+	_, err = r.Add(chunk("b", "2", "2", ""))
+	c.Check(err, ErrorMatches, "invalid header: chunk-index: 2")
+	_, err = r.Add(chunk("b", "0", "x", ""))
+	c.Check(err, ErrorMatches, "invalid header: chunk-total: x")
+
+	r = &Reassembler{Timeout: time.Minute}
+	_, err = r.Add(chunk("c", "0", "2", "abc"))
+	c.Assert(err, IsNil)
+	c.Check(r.Pending(), Equals, 1)
+	r.pending["c"].last = time.Now().Add(-2 * time.Minute)
+	c.Check(r.Pending(), Equals, 0)
+}
@@ -0,0 +1,33 @@
+package stomp
+
+import (
+	"time"
+)
+
+// clock makes the timers of the heart-beat and read timeout logic of a
+// connection, so that tests can expire them without waiting.
+type clock interface {
+	NewTimer(d time.Duration) timer
+}
+
+// timer is the part of *time.Timer that a connection uses.
+type timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock makes timers with the time package.
+type realClock struct{}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) Chan() <-chan time.Time {
+	return t.C
+}
@@ -0,0 +1,96 @@
+package stomp
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock whose timers expire only when Advance is called.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeClockOpt is a connect option that makes a connection use clock.
+func fakeClockOpt(clock *fakeClock) func(*Conn) error {
+	return func(c *Conn) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), when: c.now.Add(d), armed: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, and expires the timers that
+// are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.armed && !t.when.After(c.now) {
+			t.armed = false
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+// WaitArmed waits until n timers are waiting to expire, so that a
+// connection has set up its timers before the time is advanced.
+// Returns false if that does not happen within a second.
+func (c *fakeClock) WaitArmed(n int) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mutex.Lock()
+		armed := 0
+		for _, t := range c.timers {
+			if t.armed {
+				armed++
+			}
+		}
+		c.mutex.Unlock()
+		if armed == n {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	ch    chan time.Time
+	when  time.Time
+	armed bool
+}
+
+func (t *fakeTimer) Chan() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	armed := t.armed
+	t.armed = false
+	return armed
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	armed := t.armed
+	t.when = t.clock.now.Add(d)
+	t.armed = true
+	return armed
+}
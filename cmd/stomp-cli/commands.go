@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// publish sends a message, and waits for the broker's receipt if
+// -receipt is given.
+func publish(args []string) error {
+	var cf connFlags
+	fs := newFlagSet("publish", &cf)
+	contentType := fs.String("content-type", "text/plain", "Content type of the message")
+	body := fs.String("body", "", "Body of the message, read from -file if empty")
+	file := fs.String("file", "", "File that the body is read from, standard input if empty or -")
+	receipt := fs.Bool("receipt", false, "Wait for the broker to confirm that it has received the message")
+	destination, err := parseDestination(fs, args)
+	if err != nil {
+		return err
+	}
+	data, err := readBody(*body, *file)
+	if err != nil {
+		return err
+	}
+
+	conn, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	opts := cf.sendOpts()
+	if *receipt {
+		opts = append(opts, stomp.SendOpt.Receipt)
+	}
+	if err := conn.Send(destination, *contentType, data, opts...); err != nil {
+		conn.MustDisconnect()
+		return err
+	}
+	return conn.Disconnect()
+}
+
+// subscribe prints the messages sent to a destination until -count
+// messages have arrived, or the program is interrupted.
+func subscribe(args []string) error {
+	var cf connFlags
+	fs := newFlagSet("subscribe", &cf)
+	ack := fs.String("ack", "auto", "Ack mode: auto, client or client-individual")
+	count := fs.Int("count", 0, "Number of messages to print before exiting, unlimited if 0")
+	headers := fs.Bool("headers", false, "Print the header entries of the messages")
+	destination, err := parseDestination(fs, args)
+	if err != nil {
+		return err
+	}
+	mode, err := parseAckMode(*ack)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	conn, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Disconnect()
+	sub, err := conn.Subscribe(destination, mode, cf.subscribeOpts()...)
+	if err != nil {
+		return err
+	}
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return stomp.ErrCompletedSubscription
+			}
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if err := printMessage(os.Stdout, msg, *headers); err != nil {
+				return err
+			}
+			if msg.ShouldAck() {
+				if err := msg.Ack(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return sub.Unsubscribe()
+		}
+	}
+	return sub.Unsubscribe()
+}
+
+// request sends a request and prints the reply.
+func request(args []string) error {
+	var cf connFlags
+	fs := newFlagSet("request", &cf)
+	contentType := fs.String("content-type", "text/plain", "Content type of the request")
+	body := fs.String("body", "", "Body of the request, read from -file if empty")
+	file := fs.String("file", "", "File that the body is read from, standard input if empty or -")
+	replyTo := fs.String("reply-to", "", "Destination of the reply, a temporary queue if empty")
+	wait := fs.Duration("wait", 30*time.Second, "How long to wait for the reply")
+	headers := fs.Bool("headers", false, "Print the header entries of the reply")
+	destination, err := parseDestination(fs, args)
+	if err != nil {
+		return err
+	}
+	data, err := readBody(*body, *file)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	ctx, cancelWait := context.WithTimeout(ctx, *wait)
+	defer cancelWait()
+	var opts []func(*stomp.Conn) error
+	if *replyTo != "" {
+		opts = append(opts, stomp.ConnOpt.ReplyTo(*replyTo))
+	}
+	conn, err := cf.dial(opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Disconnect()
+	sendOpts := append(cf.sendOpts(), stomp.SendOpt.Header(frame.ContentType, *contentType))
+	reply, err := conn.Request(ctx, destination, data, sendOpts...)
+	if err != nil {
+		return err
+	}
+	return printMessage(os.Stdout, reply, *headers)
+}
+
+// browserHeader is the header entry with which ActiveMQ browses a queue
+// instead of consuming its messages. The last message of a browse has
+// the header entry with the value "end".
+const browserHeader = "browser"
+
+// browse prints the messages waiting in a queue. The messages are
+// received in client-individual mode and not acknowledged, so that
+// brokers put them back in the queue when the connection is closed;
+// brokers that support it are also asked to only browse the queue.
+// Brokers that wait for a message to be acknowledged before delivering
+// the next one show only the first message.
+func browse(args []string) error {
+	var cf connFlags
+	fs := newFlagSet("browse", &cf)
+	count := fs.Int("count", 0, "Number of messages to print before exiting, unlimited if 0")
+	wait := fs.Duration("wait", 2*time.Second, "How long to wait for the next message before exiting")
+	headers := fs.Bool("headers", false, "Print the header entries of the messages")
+	destination, err := parseDestination(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	conn, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	// disconnecting puts the messages back in the queue
+	defer conn.Disconnect()
+	opts := append(cf.subscribeOpts(), stomp.SubscribeOpt.Header(browserHeader, "true"))
+	sub, err := conn.Subscribe(destination, stomp.AckClientIndividual, opts...)
+	if err != nil {
+		return err
+	}
+	idle := time.NewTimer(*wait)
+	defer idle.Stop()
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return stomp.ErrCompletedSubscription
+			}
+			if msg.Err != nil {
+				return msg.Err
+			}
+			if msg.Header.Get(browserHeader) == "end" {
+				return nil
+			}
+			if err := printMessage(os.Stdout, msg, *headers); err != nil {
+				return err
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(*wait)
+		case <-idle.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+func parseAckMode(mode string) (stomp.AckMode, error) {
+	switch mode {
+	case "auto":
+		return stomp.AckAuto, nil
+	case "client":
+		return stomp.AckClient, nil
+	case "client-individual":
+		return stomp.AckClientIndividual, nil
+	}
+	return stomp.AckAuto, fmt.Errorf("invalid ack mode %q", mode)
+}
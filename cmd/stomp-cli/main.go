@@ -0,0 +1,282 @@
+/*
+A command-line STOMP client, for operators who need to send messages to
+a broker, or look at what arrives on a destination.
+
+Usage:
+
+	stomp-cli publish [flags] destination
+	stomp-cli subscribe [flags] destination
+	stomp-cli request [flags] destination
+	stomp-cli browse [flags] destination
+	stomp-cli replay [flags] session-file
+
+The body of published messages and requests is read from the -body flag,
+from the file named by -file, or else from standard input. Received
+messages are written to standard output, preceded by their header
+entries if -headers is given.
+
+The replay command sends the client side of a session recorded by
+stompd -record-dir to a broker, to reproduce problems at the level of
+the protocol, and prints the bytes that the broker sends back.
+
+Run "stomp-cli <command> -help" for the flags of a command.
+*/
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	stomplog "github.com/go-stomp/stomp/v3/internal/log"
+)
+
+var commands = []struct {
+	name        string
+	description string
+	run         func(args []string) error
+}{
+	{"publish", "Send a message to a destination", publish},
+	{"subscribe", "Print the messages sent to a destination", subscribe},
+	{"request", "Send a request to a destination and print the reply", request},
+	{"browse", "Print the messages waiting in a queue without consuming them", browse},
+	{"replay", "Send the client side of a recorded session to a broker", replay},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] destination\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", command.name, command.description)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("stomp-cli: ")
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, command := range commands {
+		if command.name == os.Args[1] {
+			if err := command.run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	usage()
+}
+
+// headerFlag collects the header entries given with repeated -H flags.
+type headerFlag [][2]string
+
+func (h *headerFlag) String() string {
+	entries := make([]string, 0, len(*h))
+	for _, entry := range *h {
+		entries = append(entries, entry[0]+":"+entry[1])
+	}
+	return strings.Join(entries, ",")
+}
+
+func (h *headerFlag) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("header entry %q is not key:value", value)
+	}
+	*h = append(*h, [2]string{value[:i], value[i+1:]})
+	return nil
+}
+
+// connFlags are the flags that all commands have, which describe how
+// to connect to the broker.
+type connFlags struct {
+	addr          string
+	login         string
+	passcode      string
+	host          string
+	timeout       time.Duration
+	logLevel      string
+	header        headerFlag
+	useTLS        bool
+	tlsCA         string
+	tlsCert       string
+	tlsKey        string
+	tlsServerName string
+	tlsInsecure   bool
+}
+
+// newFlagSet returns the flag set of the command name, with the
+// connection flags already defined.
+func newFlagSet(name string, cf *connFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] destination\n\nFlags:\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cf.addr, "addr", "localhost:61613", "Address of the broker")
+	fs.StringVar(&cf.login, "login", "", "Login, not sent if empty")
+	fs.StringVar(&cf.passcode, "passcode", "", "Passcode")
+	fs.StringVar(&cf.host, "host", "", "Virtual host, taken from -addr if empty")
+	fs.DurationVar(&cf.timeout, "timeout", 10*time.Second, "How long to wait for the broker to connect and answer")
+	fs.StringVar(&cf.logLevel, "log-level", "warning", "Minimum level of the client's log entries")
+	fs.Var(&cf.header, "H", "Header entry key:value of sent frames, may be repeated")
+	fs.BoolVar(&cf.useTLS, "tls", false, "Connect with TLS")
+	fs.StringVar(&cf.tlsCA, "tls-ca", "", "PEM file of the CA certificates that the broker's certificate is checked against, the system's if empty")
+	fs.StringVar(&cf.tlsCert, "tls-cert", "", "PEM file of the client certificate")
+	fs.StringVar(&cf.tlsKey, "tls-key", "", "PEM file of the client certificate's key")
+	fs.StringVar(&cf.tlsServerName, "tls-server-name", "", "Name that the broker's certificate is checked against, taken from -addr if empty")
+	fs.BoolVar(&cf.tlsInsecure, "tls-insecure", false, "Do not check the broker's certificate")
+	return fs
+}
+
+// parseDestination parses the command line, which must leave exactly
+// the destination.
+func parseDestination(fs *flag.FlagSet, args []string) (string, error) {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", errors.New("expected a single destination")
+	}
+	return fs.Arg(0), nil
+}
+
+func (cf *connFlags) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         cf.tlsServerName,
+		InsecureSkipVerify: cf.tlsInsecure,
+	}
+	if cf.tlsCA != "" {
+		pem, err := ioutil.ReadFile(cf.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cf.tlsCA)
+		}
+	}
+	if cf.tlsCert != "" || cf.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(cf.tlsCert, cf.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dial connects to the broker, with opts added to the connect options
+// given by the flags.
+func (cf *connFlags) dial(opts ...func(*stomp.Conn) error) (*stomp.Conn, error) {
+	level, err := stomp.ParseLevel(cf.logLevel)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		stomp.ConnOpt.DialTimeout(cf.timeout),
+		stomp.ConnOpt.RcvReceiptTimeout(cf.timeout),
+		stomp.ConnOpt.DisconnectReceiptTimeout(cf.timeout),
+		stomp.ConnOpt.Logger(stomp.WithLevel(stomplog.StdLogger{}, level)))
+	if cf.login != "" {
+		opts = append(opts, stomp.ConnOpt.Login(cf.login, cf.passcode))
+	}
+	if cf.host != "" {
+		opts = append(opts, stomp.ConnOpt.Host(cf.host))
+	}
+	useTLS := cf.useTLS || cf.tlsCA != "" || cf.tlsCert != "" || cf.tlsServerName != "" || cf.tlsInsecure
+	if useTLS {
+		config, err := cf.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, stomp.ConnOpt.TLS(config))
+	}
+	return stomp.Dial("tcp", cf.addr, opts...)
+}
+
+// sendOpts returns the options that add the -H header entries to a
+// SEND frame.
+func (cf *connFlags) sendOpts() []func(*frame.Frame) error {
+	opts := make([]func(*frame.Frame) error, 0, len(cf.header))
+	for _, entry := range cf.header {
+		opts = append(opts, stomp.SendOpt.Header(entry[0], entry[1]))
+	}
+	return opts
+}
+
+// subscribeOpts returns the options that add the -H header entries to
+// a SUBSCRIBE frame.
+func (cf *connFlags) subscribeOpts() []func(*frame.Frame) error {
+	opts := make([]func(*frame.Frame) error, 0, len(cf.header))
+	for _, entry := range cf.header {
+		opts = append(opts, stomp.SubscribeOpt.Header(entry[0], entry[1]))
+	}
+	return opts
+}
+
+// readBody returns body if it is not empty, or else the contents of
+// the file, or of standard input if file is empty or "-".
+func readBody(body, file string) ([]byte, error) {
+	if body != "" {
+		return []byte(body), nil
+	}
+	if file == "" || file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// printMessage writes the body of msg to w, preceded by its header
+// entries and an empty line if headers is true.
+func printMessage(w io.Writer, msg *stomp.Message, headers bool) error {
+	if headers && msg.Header != nil {
+		for i := 0; i < msg.Header.Len(); i++ {
+			key, value := msg.Header.GetAt(i)
+			if _, err := fmt.Fprintf(w, "%s:%s\n", key, value); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(msg.Body); err != nil {
+		return err
+	}
+	if len(msg.Body) == 0 || msg.Body[len(msg.Body)-1] != '\n' {
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+	return nil
+}
+
+// interruptContext returns a context that is cancelled when the
+// program is interrupted, so that commands can disconnect cleanly.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
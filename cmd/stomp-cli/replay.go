@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/record"
+)
+
+// replay sends the client side of a session recorded by stompd
+// -record-dir to a broker, and prints what the broker sends back, or
+// writes it to a session file with -out so that it can be compared
+// with the recording.
+func replay(args []string) error {
+	var cf connFlags
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [flags] session-file\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cf.addr, "addr", "localhost:61613", "Address of the broker")
+	fs.DurationVar(&cf.timeout, "timeout", 10*time.Second, "How long to wait for the broker to connect")
+	fs.BoolVar(&cf.useTLS, "tls", false, "Connect with TLS")
+	fs.StringVar(&cf.tlsCA, "tls-ca", "", "PEM file of the CA certificates that the broker's certificate is checked against, the system's if empty")
+	fs.StringVar(&cf.tlsCert, "tls-cert", "", "PEM file of the client certificate")
+	fs.StringVar(&cf.tlsKey, "tls-key", "", "PEM file of the client certificate's key")
+	fs.StringVar(&cf.tlsServerName, "tls-server-name", "", "Name that the broker's certificate is checked against, taken from -addr if empty")
+	fs.BoolVar(&cf.tlsInsecure, "tls-insecure", false, "Do not check the broker's certificate")
+	var replayer record.Replayer
+	fs.Float64Var(&replayer.Speed, "speed", 1, "Pace of the replay relative to the recording, 2 for twice as fast")
+	fs.BoolVar(&replayer.NoDelay, "no-delay", false, "Send the recorded frames without waiting between them")
+	fs.DurationVar(&replayer.Linger, "linger", record.DefaultLinger, "How long to wait for the broker after the last frame is sent")
+	out := fs.String("out", "", "Session file to write the bytes received from the broker to, instead of printing them")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single session file")
+	}
+	if replayer.Speed <= 0 {
+		return errors.New("-speed must be positive")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	entries, err := record.ReadSession(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: cf.timeout}
+	if cf.useTLS || cf.tlsCA != "" || cf.tlsCert != "" || cf.tlsServerName != "" || cf.tlsInsecure {
+		config, err := cf.tlsConfig()
+		if err != nil {
+			return err
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", cf.addr, config)
+	} else {
+		conn, err = dialer.Dial("tcp", cf.addr)
+	}
+	if err != nil {
+		return err
+	}
+	received, err := replayer.Replay(conn, entries)
+
+	if *out == "" {
+		for _, e := range received {
+			os.Stdout.Write(e.Bytes())
+		}
+		return err
+	}
+	f, err = os.Create(*out)
+	if err != nil {
+		return err
+	}
+	w := record.NewWriter(f)
+	for _, e := range received {
+		w.Write(e)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+/*
+A load generator for STOMP brokers, which runs producers and consumers
+against a broker and reports the throughput and the latency of the
+messages, so that the performance of a broker can be tracked over
+time.
+
+Producer i sends to destination i modulo -destinations, and consumer i
+subscribes to destination i modulo -destinations, so for example
+
+	stompbench -producers 4 -consumers 4 -destinations 2 -size 1024 -rate 500
+
+runs two producers and two consumers on each of two queues. Messages
+sent to /queue/ destinations are shared between their consumers, and
+messages sent to /topic/ destinations are received by all of them.
+The latency of a message is the time from its send to its delivery, as
+measured by the clock of the host that stompbench runs on.
+
+The producers run for -duration, or until each one has sent -messages,
+and the consumers then wait up to -drain for the messages that are on
+their way. The report is printed as text, or as JSON with -json.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	stomplog "github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// sentHeader is the header entry with the time, in nanoseconds since
+// the Unix epoch, at which a message was sent.
+const sentHeader = "stompbench-sent"
+
+var addr = flag.String("addr", "localhost:61613", "Address of the broker")
+var login = flag.String("login", "", "Login, not sent if empty")
+var passcode = flag.String("passcode", "", "Passcode")
+var host = flag.String("host", "", "Virtual host, taken from -addr if empty")
+var producers = flag.Int("producers", 1, "Number of producer connections")
+var consumers = flag.Int("consumers", 1, "Number of consumer connections")
+var destinations = flag.Int("destinations", 1, "Number of destinations")
+var destinationPrefix = flag.String("destination", "/queue/stompbench-", "Prefix of the destination names, which end with their number")
+var size = flag.Int("size", 128, "Size of the message bodies in bytes")
+var rate = flag.Float64("rate", 0, "Messages per second sent by each producer, unlimited if 0")
+var ack = flag.String("ack", "auto", "Ack mode of the consumers: auto, client or client-individual")
+var receipt = flag.Bool("receipt", false, "Wait for the receipt of each message before sending the next one")
+var duration = flag.Duration("duration", 10*time.Second, "How long the producers run")
+var messages = flag.Int("messages", 0, "Number of messages sent by each producer, unlimited if 0")
+var drain = flag.Duration("drain", 5*time.Second, "How long the consumers wait for the messages on their way once the producers have stopped")
+var logLevel = flag.String("log-level", "warning", "Minimum level of the client's log entries")
+var jsonFlag = flag.Bool("json", false, "Print the report as JSON")
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("stompbench: ")
+	flag.Parse()
+
+	if *producers < 0 || *consumers < 0 || *destinations < 1 || *size < 0 || *rate < 0 || *messages < 0 {
+		log.Fatal("-producers, -consumers, -size, -rate and -messages cannot be negative, and -destinations must be positive")
+	}
+	ackMode, err := parseAckMode(*ack)
+	if err != nil {
+		log.Fatal(err)
+	}
+	level, err := stomp.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := &bench{
+		ackMode: ackMode,
+		logger:  stomp.WithLevel(stomplog.StdLogger{}, level),
+		sent:    make([]int64, *destinations),
+	}
+	report, err := b.run()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// bench is a run of the producers and consumers.
+type bench struct {
+	ackMode  stomp.AckMode
+	logger   stomp.Logger
+	sent     []int64 // messages sent to each destination
+	received int64   // messages received by all consumers
+	errors   int64   // failed sends and acknowledgements
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+	last      time.Time // when the last message was received
+}
+
+func destination(i int) string {
+	return *destinationPrefix + strconv.Itoa(i%*destinations)
+}
+
+func (b *bench) dial(opts ...func(*stomp.Conn) error) (*stomp.Conn, error) {
+	opts = append(opts, stomp.ConnOpt.Logger(b.logger))
+	if *login != "" {
+		opts = append(opts, stomp.ConnOpt.Login(*login, *passcode))
+	}
+	if *host != "" {
+		opts = append(opts, stomp.ConnOpt.Host(*host))
+	}
+	return stomp.Dial("tcp", *addr, opts...)
+}
+
+func (b *bench) run() (*report, error) {
+	// subscribe all consumers before the first message is sent
+	subscribers := make([]int, *destinations)
+	subs := make([]*stomp.Subscription, 0, *consumers)
+	conns := make([]*stomp.Conn, 0, *consumers)
+	defer func() {
+		for _, conn := range conns {
+			conn.Disconnect()
+		}
+	}()
+	for i := 0; i < *consumers; i++ {
+		conn, err := b.dial()
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+		sub, err := conn.Subscribe(destination(i), b.ackMode)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+		subscribers[i%*destinations]++
+	}
+
+	stop := make(chan struct{})
+	var consumersDone sync.WaitGroup
+	for _, sub := range subs {
+		consumersDone.Add(1)
+		go func(sub *stomp.Subscription) {
+			defer consumersDone.Done()
+			b.consume(sub, stop)
+		}(sub)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	body := []byte(strings.Repeat("x", *size))
+	var producersDone sync.WaitGroup
+	for i := 0; i < *producers; i++ {
+		var opts []func(*stomp.Conn) error
+		if *rate > 0 {
+			opts = append(opts, stomp.ConnOpt.SendRateLimit(*rate, 0))
+		}
+		conn, err := b.dial(opts...)
+		if err != nil {
+			cancel()
+			producersDone.Wait()
+			close(stop)
+			return nil, err
+		}
+		producersDone.Add(1)
+		go func(i int, conn *stomp.Conn) {
+			defer producersDone.Done()
+			b.produce(ctx, conn, i, body)
+			conn.Disconnect()
+		}(i, conn)
+	}
+	producersDone.Wait()
+	sendTime := time.Since(start)
+
+	// wait for the messages on their way
+	expected := int64(0)
+	for d, n := range b.sent {
+		switch {
+		case subscribers[d] == 0:
+		case strings.HasPrefix(destination(d), "/topic/"):
+			expected += n * int64(subscribers[d])
+		default:
+			expected += n
+		}
+	}
+	deadline := time.Now().Add(*drain)
+	for atomic.LoadInt64(&b.received) < expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	consumersDone.Wait()
+
+	return b.report(start, sendTime, expected), nil
+}
+
+// produce sends messages to the destination of producer i until ctx
+// is done or all messages have been sent.
+func (b *bench) produce(ctx context.Context, conn *stomp.Conn, i int, body []byte) {
+	dest := destination(i)
+	for n := 0; *messages == 0 || n < *messages; n++ {
+		if ctx.Err() != nil {
+			return
+		}
+		opts := []func(*frame.Frame) error{
+			stomp.SendOpt.Header(sentHeader, strconv.FormatInt(time.Now().UnixNano(), 10)),
+		}
+		if *receipt {
+			opts = append(opts, stomp.SendOpt.Receipt)
+		}
+		if err := conn.SendContext(ctx, dest, "application/octet-stream", body, opts...); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			atomic.AddInt64(&b.errors, 1)
+			continue
+		}
+		atomic.AddInt64(&b.sent[i%*destinations], 1)
+	}
+}
+
+// consume receives the messages of sub until stop is closed.
+func (b *bench) consume(sub *stomp.Subscription, stop <-chan struct{}) {
+	var latencies []time.Duration
+	var last time.Time
+	defer func() {
+		b.mutex.Lock()
+		b.latencies = append(b.latencies, latencies...)
+		if last.After(b.last) {
+			b.last = last
+		}
+		b.mutex.Unlock()
+	}()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if msg.Err != nil {
+				log.Printf("%s: %v", sub.Destination(), msg.Err)
+				return
+			}
+			last = time.Now()
+			if sent, err := strconv.ParseInt(msg.Header.Get(sentHeader), 10, 64); err == nil {
+				latencies = append(latencies, last.Sub(time.Unix(0, sent)))
+			}
+			atomic.AddInt64(&b.received, 1)
+			if shouldAck(msg) {
+				if err := msg.Ack(); err != nil {
+					atomic.AddInt64(&b.errors, 1)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// shouldAck returns true if msg is to be acknowledged. Brokers such as
+// this module's server do not ask for the messages of topics to be
+// acknowledged, in which case STOMP 1.2 messages have no ack header
+// entry.
+func shouldAck(msg *stomp.Message) bool {
+	if !msg.ShouldAck() {
+		return false
+	}
+	_, ok := msg.Header.Contains(frame.Ack)
+	return ok || msg.Conn.Version() != stomp.V12
+}
+
+func parseAckMode(mode string) (stomp.AckMode, error) {
+	switch mode {
+	case "auto":
+		return stomp.AckAuto, nil
+	case "client":
+		return stomp.AckClient, nil
+	case "client-individual":
+		return stomp.AckClientIndividual, nil
+	}
+	return stomp.AckAuto, fmt.Errorf("invalid ack mode %q", mode)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// report is the outcome of a run. Durations are in seconds in the
+// JSON encoding, so that reports can be compared by other programs.
+type report struct {
+	Producers    int    `json:"producers"`
+	Consumers    int    `json:"consumers"`
+	Destinations int    `json:"destinations"`
+	Size         int    `json:"size"`
+	Ack          string `json:"ack"`
+
+	Sent     int64 `json:"sent"`
+	Expected int64 `json:"expected"`
+	Received int64 `json:"received"`
+	Errors   int64 `json:"errors"`
+
+	SendSeconds    float64 `json:"send_seconds"`
+	ReceiveSeconds float64 `json:"receive_seconds"`
+	SendRate       float64 `json:"send_rate"`          // messages per second
+	ReceiveRate    float64 `json:"receive_rate"`       // messages per second
+	ReceiveBytes   float64 `json:"receive_bytes_rate"` // body bytes per second
+
+	Latency latencies `json:"latency_seconds"`
+}
+
+// latencies are the percentiles of the latency of the messages.
+type latencies struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func (b *bench) report(start time.Time, sendTime time.Duration, expected int64) *report {
+	r := &report{
+		Producers:    *producers,
+		Consumers:    *consumers,
+		Destinations: *destinations,
+		Size:         *size,
+		Ack:          *ack,
+		Expected:     expected,
+		Received:     atomic.LoadInt64(&b.received),
+		Errors:       atomic.LoadInt64(&b.errors),
+		SendSeconds:  sendTime.Seconds(),
+	}
+	for i := range b.sent {
+		r.Sent += atomic.LoadInt64(&b.sent[i])
+	}
+	if r.SendSeconds > 0 {
+		r.SendRate = float64(r.Sent) / r.SendSeconds
+	}
+	if !b.last.IsZero() {
+		r.ReceiveSeconds = b.last.Sub(start).Seconds()
+	}
+	if r.ReceiveSeconds > 0 {
+		r.ReceiveRate = float64(r.Received) / r.ReceiveSeconds
+		r.ReceiveBytes = r.ReceiveRate * float64(r.Size)
+	}
+
+	sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+	if n := len(b.latencies); n > 0 {
+		r.Latency = latencies{
+			Min: b.latencies[0].Seconds(),
+			P50: percentile(b.latencies, 50).Seconds(),
+			P90: percentile(b.latencies, 90).Seconds(),
+			P99: percentile(b.latencies, 99).Seconds(),
+			Max: b.latencies[n-1].Seconds(),
+		}
+	}
+	return r
+}
+
+// percentile returns the p-th percentile of sorted, which is not
+// empty, by the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func (r *report) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `producers %d, consumers %d, destinations %d, size %d bytes, ack %s
+sent      %d messages in %v (%.1f msg/s)
+received  %d of %d messages in %v (%.1f msg/s, %.1f KiB/s)
+errors    %d
+latency   min %v, p50 %v, p90 %v, p99 %v, max %v
+`,
+		r.Producers, r.Consumers, r.Destinations, r.Size, r.Ack,
+		r.Sent, seconds(r.SendSeconds).Round(time.Millisecond), r.SendRate,
+		r.Received, r.Expected, seconds(r.ReceiveSeconds).Round(time.Millisecond), r.ReceiveRate, r.ReceiveBytes/1024,
+		r.Errors,
+		seconds(r.Latency.Min), seconds(r.Latency.P50), seconds(r.Latency.P90), seconds(r.Latency.P99), seconds(r.Latency.Max))
+	return err
+}
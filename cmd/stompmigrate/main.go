@@ -0,0 +1,193 @@
+/*
+A tool that migrates the messages waiting on the destinations of a
+broker, such as ActiveMQ or RabbitMQ, to another broker over STOMP.
+
+Usage:
+
+	stompmigrate [flags] destination[=target]...
+
+Each destination is drained from the -source broker and its messages
+are sent to the -target broker, to the target destination if one is
+given, or else to the destination of the same name. A destination is
+drained when no message has arrived for -idle. The header entries of
+the messages are copied, except those set by the source broker for the
+delivery and those named by -drop-header, and the message-id of each
+message on the source broker is sent in its migrate-message-id header
+entry. Each message is acknowledged to the source broker once the
+target broker has received it, so no message is lost if the migration
+is interrupted, but the messages that were being migrated may be sent
+twice.
+
+For example, to move two queues from ActiveMQ, renaming one of them,
+fetching 100 messages at a time:
+
+	stompmigrate -source activemq:61613 -source-login admin -source-passcode admin \
+		-target localhost:61613 -H activemq.prefetchSize:100 \
+		/queue/orders /queue/invoices=/queue/billing.invoices
+
+Progress is reported every -progress, and a summary of each destination
+is printed once it has been drained.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	stomplog "github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/migrate"
+)
+
+var source = flag.String("source", "localhost:61613", "Address of the broker that messages are migrated from")
+var sourceLogin = flag.String("source-login", "", "Login on the source broker, not sent if empty")
+var sourcePasscode = flag.String("source-passcode", "", "Passcode on the source broker")
+var sourceHost = flag.String("source-host", "", "Virtual host of the source broker, taken from -source if empty")
+var target = flag.String("target", "", "Address of the broker that messages are migrated to")
+var targetLogin = flag.String("target-login", "", "Login on the target broker, not sent if empty")
+var targetPasscode = flag.String("target-passcode", "", "Passcode on the target broker")
+var targetHost = flag.String("target-host", "", "Virtual host of the target broker, taken from -target if empty")
+var idle = flag.Duration("idle", migrate.DefaultIdleTimeout, "How long to wait for the next message of a destination before it is drained")
+var limit = flag.Int("limit", 0, "Maximum number of messages migrated from each destination, unlimited if 0")
+var progressInterval = flag.Duration("progress", 5*time.Second, "How often progress is reported, never if 0")
+var timeout = flag.Duration("timeout", 10*time.Second, "How long to wait for the brokers to connect and answer")
+var logLevel = flag.String("log-level", "warning", "Minimum level of the clients' log entries")
+
+var subscribeHeader listFlag
+var dropHeader listFlag
+
+func init() {
+	flag.Var(&subscribeHeader, "H", "Header entry key:value of the SUBSCRIBE frames sent to the source broker, may be repeated")
+	flag.Var(&dropHeader, "drop-header", "Key of header entries that are not copied, may be repeated")
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("stompmigrate: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] destination[=target]...\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *target == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	destinations := make([]migrate.Destination, 0, flag.NArg())
+	for _, arg := range flag.Args() {
+		d := migrate.Destination{Source: arg}
+		if i := strings.Index(arg, "="); i >= 0 {
+			d = migrate.Destination{Source: arg[:i], Target: arg[i+1:]}
+		}
+		if d.Source == "" {
+			log.Fatalf("no source destination in %q", arg)
+		}
+		destinations = append(destinations, d)
+	}
+	subscribeOpts := make([]func(*frame.Frame) error, 0, len(subscribeHeader))
+	for _, entry := range subscribeHeader {
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			log.Fatalf("header entry %q is not key:value", entry)
+		}
+		subscribeOpts = append(subscribeOpts, stomp.SubscribeOpt.Header(entry[:i], entry[i+1:]))
+	}
+	level, err := stomp.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := stomp.WithLevel(stomplog.StdLogger{}, level)
+
+	sourceConn, err := dial(*source, *sourceLogin, *sourcePasscode, *sourceHost, logger)
+	if err != nil {
+		log.Fatalf("failed to connect to source broker: %v", err)
+	}
+	defer sourceConn.Disconnect()
+	targetConn, err := dial(*target, *targetLogin, *targetPasscode, *targetHost, logger)
+	if err != nil {
+		log.Fatalf("failed to connect to target broker: %v", err)
+	}
+	defer targetConn.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		log.Print("interrupted, stopping")
+		cancel()
+	}()
+
+	var reported time.Time
+	m := &migrate.Migrator{
+		Source:        sourceConn,
+		Target:        targetConn,
+		IdleTimeout:   *idle,
+		Limit:         *limit,
+		DropHeaders:   dropHeader,
+		SubscribeOpts: subscribeOpts,
+		Log:           logger,
+		Progress: func(p migrate.Progress) {
+			if p.Done {
+				log.Printf("%s: migrated %d messages (%d bytes) to %s in %v",
+					p.Source, p.Messages, p.Bytes, p.Target, p.Elapsed.Round(time.Millisecond))
+			} else if *progressInterval > 0 && time.Since(reported) >= *progressInterval {
+				log.Printf("%s: %d messages migrated (%.0f/s)",
+					p.Source, p.Messages, float64(p.Messages)/p.Elapsed.Seconds())
+			} else {
+				return
+			}
+			reported = time.Now()
+		},
+	}
+	results, err := m.Migrate(ctx, destinations...)
+	var total int
+	for _, p := range results {
+		total += p.Messages
+	}
+	if err != nil {
+		// the deferred disconnects are skipped by log.Fatalf
+		sourceConn.Disconnect()
+		targetConn.Disconnect()
+		log.Fatalf("%d messages migrated, failed to migrate %s: %v", total, results[len(results)-1].Source, err)
+	}
+	log.Printf("%d messages migrated from %d destinations", total, len(results))
+}
+
+// dial connects to a broker.
+func dial(addr, login, passcode, host string, logger stomp.Logger) (*stomp.Conn, error) {
+	opts := []func(*stomp.Conn) error{
+		stomp.ConnOpt.DialTimeout(*timeout),
+		stomp.ConnOpt.RcvReceiptTimeout(*timeout),
+		stomp.ConnOpt.DisconnectReceiptTimeout(*timeout),
+		stomp.ConnOpt.Logger(logger),
+	}
+	if login != "" {
+		opts = append(opts, stomp.ConnOpt.Login(login, passcode))
+	}
+	if host != "" {
+		opts = append(opts, stomp.ConnOpt.Host(host))
+	}
+	return stomp.Dial("tcp", addr, opts...)
+}
@@ -0,0 +1,117 @@
+package stomp
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Codec encodes values into message bodies of one content type, and
+// decodes them again. Codecs for other formats, such as protobuf or
+// msgpack, can be registered with RegisterCodec.
+type Codec interface {
+	// ContentType returns the value of the "content-type" header
+	// entry for bodies encoded by the codec, such as
+	// "application/json".
+	ContentType() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values as JSON with the encoding/json package.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return frame.JSONContentType
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{
+		frame.JSONContentType: JSONCodec,
+	}
+)
+
+// RegisterCodec makes codec available to Message.Decode for messages
+// with its content type, replacing any codec previously registered for
+// the same media type.
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[mediaType(codec.ContentType())] = codec
+}
+
+// lookupCodec returns the codec registered for the media type of
+// contentType.
+func lookupCodec(contentType string) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	codec, ok := codecs[mediaType(contentType)]
+	return codec, ok
+}
+
+// mediaType returns the media type of a content type in lower case,
+// without parameters such as the charset.
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// SendValue encodes v with codec and sends it to destination, with the
+// content type of the codec. See Conn.Send for the options.
+func SendValue(conn *Conn, codec Codec, destination string, v interface{}, opts ...func(*frame.Frame) error) error {
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.Send(destination, codec.ContentType(), body, opts...)
+}
+
+// SendJSON sends the JSON encoding of v to destination, with the
+// content type "application/json". See Conn.Send for the options.
+func SendJSON(conn *Conn, destination string, v interface{}, opts ...func(*frame.Frame) error) error {
+	return SendValue(conn, JSONCodec, destination, v, opts...)
+}
+
+// ReceiveJSON decodes the JSON body of msg into the value pointed to by
+// v, whatever the content type of msg. If msg carries an error, that
+// error is returned instead.
+func ReceiveJSON(msg *Message, v interface{}) error {
+	if msg.Err != nil {
+		return msg.Err
+	}
+	return JSONCodec.Unmarshal(msg.Body, v)
+}
+
+// Decode decodes the body of msg into the value pointed to by v, with
+// the codec registered for the content type of msg. If msg carries an
+// error, that error is returned instead.
+func (msg *Message) Decode(v interface{}) error {
+	if msg.Err != nil {
+		return msg.Err
+	}
+	codec, ok := lookupCodec(msg.ContentType)
+	if !ok {
+		return newErrorMessage("no codec for content type: " + msg.ContentType)
+	}
+	return codec.Unmarshal(msg.Body, v)
+}
@@ -0,0 +1,89 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+type order struct {
+	Id    int    `json:"id"`
+	Items string `json:"items"`
+}
+
+// textCodec encodes *string values as plain text.
+type textCodec struct{}
+
+func (textCodec) ContentType() string {
+	return "text/x-test; charset=utf-8"
+}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(fmt.Sprint(v)), nil
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*string)
+	if !ok {
+		return errors.New("not a *string")
+	}
+	*p = string(data)
+	return nil
+}
+
+func (s *StompSuite) TestSendJSON(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(frame.ContentType), Equals, "application/json")
+		c.Check(f.Header.Get("priority"), Equals, "9")
+		c.Check(string(f.Body), Equals, `{"id":1,"items":"apples"}`)
+	}()
+
+	err := SendJSON(conn, "/queue/orders", order{1, "apples"},
+		SendOpt.Header("priority", "9"))
+	c.Assert(err, IsNil)
+	<-stop
+	conn.MustDisconnect()
+
+	c.Check(SendJSON(conn, "/queue/orders", func() {}), NotNil)
+}
+
+func (s *StompSuite) TestReceiveJSON(c *C) {
+	msg := &Message{
+		ContentType: "application/json;charset=utf-8",
+		Body:        []byte(`{"id":2,"items":"pears"}`),
+	}
+	var o order
+	c.Assert(ReceiveJSON(msg, &o), IsNil)
+	c.Check(o, Equals, order{2, "pears"})
+	o = order{}
+	c.Assert(msg.Decode(&o), IsNil)
+	c.Check(o, Equals, order{2, "pears"})
+
+	msg = &Message{Err: ErrClosedUnexpectedly}
+	c.Check(ReceiveJSON(msg, &o), Equals, ErrClosedUnexpectedly)
+	c.Check(msg.Decode(&o), Equals, ErrClosedUnexpectedly)
+}
+
+func (s *StompSuite) TestRegisterCodec(c *C) {
+	msg := &Message{ContentType: "text/x-test", Body: []byte("hello")}
+	var text string
+	c.Check(msg.Decode(&text), ErrorMatches, "no codec for content type: text/x-test")
+
+	RegisterCodec(textCodec{})
+	defer func() {
+		codecsMutex.Lock()
+		delete(codecs, "text/x-test")
+		codecsMutex.Unlock()
+	}()
+	c.Assert(msg.Decode(&text), IsNil)
+	c.Check(text, Equals, "hello")
+}
@@ -0,0 +1,170 @@
+/*
+Package conformance contains protocol tests for STOMP servers, covering
+version negotiation, receipts, transactions, acknowledgement modes,
+heart-beats and ERROR frames. The tests can be run against any server
+address, so that this module's server and other brokers can be checked
+against the STOMP 1.1 and 1.2 specifications.
+
+In a Go test:
+
+	func TestConformance(t *testing.T) {
+		conformance.RunTests(t, conformance.Config{Addr: "localhost:61613"})
+	}
+
+The tests send frames and check the answers directly, rather than with
+the stomp client package, so that they see exactly what the server
+sends. Each test uses destinations of its own, whose names start with
+Config.DestinationPrefix, so that the tests do not interfere with each
+other or with other clients of the server.
+*/
+package conformance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// DefaultTimeout is how long a test waits for a frame from the server,
+// if Config.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultDestinationPrefix is the prefix of the destinations that the
+// tests use, if Config.DestinationPrefix is empty.
+const DefaultDestinationPrefix = "/queue/stomp-conformance-"
+
+// Config describes the server that the tests are run against.
+type Config struct {
+	Network string // Network of the server, "tcp" if empty
+	Addr    string // Address of the server
+
+	// Dial, if not nil, is used to connect to the server instead of
+	// Network and Addr, for example to connect through TLS.
+	Dial func() (net.Conn, error)
+
+	Host     string // Value of the "host" header entry, Addr if empty
+	Login    string // Login sent in CONNECT frames, if not empty
+	Passcode string // Passcode sent in CONNECT frames
+
+	// DestinationPrefix is the prefix of the queue destinations
+	// that the tests use, DefaultDestinationPrefix if empty.
+	DestinationPrefix string
+
+	// Timeout is how long a test waits for a frame from the server,
+	// DefaultTimeout if zero. Heart-beat intervals longer than Timeout
+	// are not tested.
+	Timeout time.Duration
+}
+
+// A Test is a conformance test.
+type Test struct {
+	Name        string
+	Description string
+	run         func(t *tester) error
+}
+
+// A Result is the outcome of a test.
+type Result struct {
+	Name    string
+	Err     error  // Why the test failed, nil if it passed or was skipped
+	Skipped string // Why the test was skipped, empty if it ran
+}
+
+// errSkip is returned by tests that cannot run against the server.
+type errSkip struct {
+	reason string
+}
+
+func (e errSkip) Error() string {
+	return "skipped: " + e.reason
+}
+
+func skip(format string, args ...interface{}) error {
+	return errSkip{fmt.Sprintf(format, args...)}
+}
+
+// Tests returns the conformance tests.
+func Tests() []Test {
+	return append([]Test(nil), tests...)
+}
+
+// Run runs the tests against the server described by config, one after
+// the other, and returns their results.
+func Run(config Config) []Result {
+	results := make([]Result, 0, len(tests))
+	for _, test := range tests {
+		results = append(results, test.Run(config))
+	}
+	return results
+}
+
+// RunTests runs the tests against the server described by config as
+// subtests of t.
+func RunTests(t *testing.T, config Config) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			result := test.Run(config)
+			if result.Skipped != "" {
+				t.Skip(result.Skipped)
+			}
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+		})
+	}
+}
+
+// Run runs the test against the server described by config.
+func (test Test) Run(config Config) Result {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.Host == "" {
+		config.Host = config.Addr
+	}
+	if config.DestinationPrefix == "" {
+		config.DestinationPrefix = DefaultDestinationPrefix
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	t := &tester{config: config}
+	err := test.run(t)
+	t.closeAll()
+
+	result := Result{Name: test.Name}
+	var s errSkip
+	if errors.As(err, &s) {
+		result.Skipped = s.reason
+	} else {
+		result.Err = err
+	}
+	return result
+}
+
+// tester holds the connections opened by a test.
+type tester struct {
+	config Config
+	conns  []*conn
+}
+
+// destination returns a new destination for the test.
+func (t *tester) destination() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return t.config.DestinationPrefix + hex.EncodeToString(b[:])
+}
+
+func (t *tester) closeAll() {
+	for _, c := range t.conns {
+		c.nc.Close()
+	}
+}
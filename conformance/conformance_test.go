@@ -0,0 +1,52 @@
+package conformance
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/stomptest"
+)
+
+func TestServer(t *testing.T) {
+	srv := stomptest.NewServer(&server.Server{HeartBeat: 100 * time.Millisecond})
+	defer srv.Close()
+
+	RunTests(t, Config{
+		Dial: func() (net.Conn, error) {
+			return srv.Listener.Dial()
+		},
+	})
+}
+
+func TestRun(t *testing.T) {
+	srv := stomptest.NewServer(&server.Server{HeartBeat: 100 * time.Millisecond})
+	defer srv.Close()
+
+	results := Run(Config{
+		Dial: func() (net.Conn, error) {
+			return srv.Listener.Dial()
+		},
+		// too short for the heart-beats to be tested
+		Timeout: 150 * time.Millisecond,
+	})
+	if len(results) != len(Tests()) {
+		t.Fatalf("%d results for %d tests", len(results), len(Tests()))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.Name, result.Err)
+		}
+		if skipped := result.Skipped != ""; skipped != (result.Name == "heart-beats") {
+			t.Errorf("%s: skipped %q", result.Name, result.Skipped)
+		}
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	result := Tests()[0].Run(Config{Network: "unix", Addr: "/nonexistent/stomp.sock"})
+	if result.Err == nil {
+		t.Fatal("test passed without a server")
+	}
+}
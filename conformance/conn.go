@@ -0,0 +1,216 @@
+package conformance
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// timeoutError is returned when no frame arrives within the timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("no frame received within %v", e.timeout)
+}
+
+// conn is a connection to the server under test.
+type conn struct {
+	t       *tester
+	nc      net.Conn
+	reader  *frame.Reader
+	writer  *frame.Writer
+	pending []*frame.Frame // frames read while waiting for others
+	receipt int
+}
+
+// dial opens a connection to the server, without sending a frame.
+func (t *tester) dial() (*conn, error) {
+	var nc net.Conn
+	var err error
+	if t.config.Dial != nil {
+		nc, err = t.config.Dial()
+	} else {
+		nc, err = net.DialTimeout(t.config.Network, t.config.Addr, t.config.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		t:      t,
+		nc:     nc,
+		reader: frame.NewReader(nc),
+		writer: frame.NewWriter(nc),
+	}
+	// bodies may contain null bytes
+	c.writer.SetContentLength(true)
+	t.conns = append(t.conns, c)
+	return c, nil
+}
+
+// connectFrame returns a frame with command that connects to the
+// server, with the header entries in headers, which replace the
+// defaults.
+func (t *tester) connectFrame(command string, headers ...string) *frame.Frame {
+	f := frame.New(command,
+		frame.AcceptVersion, "1.2",
+		frame.Host, t.config.Host,
+		frame.HeartBeat, "0,0")
+	if t.config.Login != "" {
+		f.Header.Add(frame.Login, t.config.Login)
+		f.Header.Add(frame.Passcode, t.config.Passcode)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Header.Set(headers[i], headers[i+1])
+	}
+	return f
+}
+
+// connect opens a connection to the server and sends a CONNECT frame
+// with the header entries in headers, which replace the defaults, and
+// returns the connection and the CONNECTED frame.
+func (t *tester) connect(headers ...string) (*conn, *frame.Frame, error) {
+	c, err := t.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.send(t.connectFrame(frame.CONNECT, headers...)); err != nil {
+		return nil, nil, err
+	}
+	f, err := c.await(frame.CONNECTED, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.writer.SetVersion(f.Header.Get(frame.Version))
+	return c, f, nil
+}
+
+// send writes a frame to the server.
+func (c *conn) send(f *frame.Frame) error {
+	c.nc.SetWriteDeadline(time.Now().Add(c.t.config.Timeout))
+	return c.writer.Write(f)
+}
+
+// read returns the next frame from the server, or nil for a
+// heart-beat, failing if none arrives within the timeout.
+func (c *conn) read() (*frame.Frame, error) {
+	c.nc.SetReadDeadline(time.Now().Add(c.t.config.Timeout))
+	f, err := c.reader.Read()
+	if err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil, timeoutError{c.t.config.Timeout}
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// await returns the first frame with command for which match, if not
+// nil, returns true. Other frames are kept for later calls, except
+// for ERROR frames, which fail the wait.
+func (c *conn) await(command string, match func(f *frame.Frame) bool) (*frame.Frame, error) {
+	for i, f := range c.pending {
+		if f.Command == command && (match == nil || match(f)) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return f, nil
+		}
+	}
+	for {
+		f, err := c.read()
+		if err != nil {
+			return nil, fmt.Errorf("waiting for %s frame: %v", command, err)
+		}
+		if f == nil {
+			continue
+		}
+		if f.Command == command && (match == nil || match(f)) {
+			return f, nil
+		}
+		if f.Command == frame.ERROR {
+			return nil, fmt.Errorf("waiting for %s frame: received ERROR frame: %s",
+				command, f.Header.Get(frame.Message))
+		}
+		c.pending = append(c.pending, f)
+	}
+}
+
+// request sends a frame with a receipt header entry, and waits for the
+// RECEIPT frame.
+func (c *conn) request(f *frame.Frame) error {
+	c.receipt++
+	id := "receipt-" + strconv.Itoa(c.receipt)
+	f.Header.Set(frame.Receipt, id)
+	if err := c.send(f); err != nil {
+		return err
+	}
+	_, err := c.await(frame.RECEIPT, func(r *frame.Frame) bool {
+		return r.Header.Get(frame.ReceiptId) == id
+	})
+	return err
+}
+
+// message waits for the next MESSAGE frame.
+func (c *conn) message() (*frame.Frame, error) {
+	return c.await(frame.MESSAGE, nil)
+}
+
+// disconnect sends a DISCONNECT frame, waits for its receipt, and
+// closes the connection.
+func (c *conn) disconnect() error {
+	err := c.request(frame.New(frame.DISCONNECT))
+	c.nc.Close()
+	return err
+}
+
+// closed checks that the server closes the connection, after sending
+// any number of heart-beats.
+func (c *conn) closed() error {
+	for {
+		f, err := c.read()
+		if err != nil {
+			if _, ok := err.(timeoutError); ok {
+				return fmt.Errorf("connection not closed: %v", err)
+			}
+			return nil
+		}
+		if f != nil {
+			return fmt.Errorf("received %s frame instead of the connection being closed", f.Command)
+		}
+	}
+}
+
+// subscribe subscribes to destination with the ack mode, and waits
+// until the server confirms the subscription.
+func (c *conn) subscribe(id, destination, ack string) error {
+	return c.request(frame.New(frame.SUBSCRIBE,
+		frame.Id, id,
+		frame.Destination, destination,
+		frame.Ack, ack))
+}
+
+// sendMessage sends a message with body to destination, and waits
+// until the server has received it.
+func (c *conn) sendMessage(destination, body string, headers ...string) error {
+	f := frame.New(frame.SEND, frame.Destination, destination)
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Header.Add(headers[i], headers[i+1])
+	}
+	f.Body = []byte(body)
+	return c.request(f)
+}
+
+// expectBody waits for the next MESSAGE frame, and checks its body.
+func (c *conn) expectBody(body string) (*frame.Frame, error) {
+	f, err := c.message()
+	if err != nil {
+		return nil, err
+	}
+	if string(f.Body) != body {
+		return nil, fmt.Errorf("received message %q, expected %q", f.Body, body)
+	}
+	return f, nil
+}
@@ -0,0 +1,390 @@
+package conformance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+var tests = []Test{
+	{
+		Name:        "connect",
+		Description: "CONNECT is answered by CONNECTED with the highest common version",
+		run:         testConnect,
+	},
+	{
+		Name:        "version-negotiation",
+		Description: "the version is chosen from the accept-version header entry",
+		run:         testVersionNegotiation,
+	},
+	{
+		Name:        "unsupported-version",
+		Description: "an ERROR frame answers a CONNECT frame without a supported version",
+		run:         testUnsupportedVersion,
+	},
+	{
+		Name:        "stomp-command",
+		Description: "STOMP frames connect like CONNECT frames",
+		run:         testStompCommand,
+	},
+	{
+		Name:        "receipts",
+		Description: "frames with a receipt header entry are answered by RECEIPT frames",
+		run:         testReceipts,
+	},
+	{
+		Name:        "send-receive",
+		Description: "messages are delivered with their header entries and body",
+		run:         testSendReceive,
+	},
+	{
+		Name:        "transaction-commit",
+		Description: "messages sent in a transaction are delivered once it is committed",
+		run:         testTransactionCommit,
+	},
+	{
+		Name:        "transaction-abort",
+		Description: "messages sent in an aborted transaction are discarded",
+		run:         testTransactionAbort,
+	},
+	{
+		Name:        "ack-client",
+		Description: "messages not acknowledged in client mode are delivered again",
+		run:         testAckClient,
+	},
+	{
+		Name:        "ack-client-individual",
+		Description: "messages not acknowledged in client-individual mode are delivered again",
+		run:         testAckClientIndividual,
+	},
+	{
+		Name:        "heart-beats",
+		Description: "the server sends heart-beats at the negotiated interval",
+		run:         testHeartBeats,
+	},
+	{
+		Name:        "error-frame",
+		Description: "an invalid frame is answered by an ERROR frame, and the connection is closed",
+		run:         testErrorFrame,
+	},
+}
+
+func testConnect(t *tester) error {
+	c, f, err := t.connect(frame.AcceptVersion, "1.1,1.2")
+	if err != nil {
+		return err
+	}
+	if version := f.Header.Get(frame.Version); version != "1.2" {
+		return fmt.Errorf("version %q, expected 1.2", version)
+	}
+	return c.disconnect()
+}
+
+func testVersionNegotiation(t *tester) error {
+	c, f, err := t.connect(frame.AcceptVersion, "1.1")
+	if err != nil {
+		return err
+	}
+	if version := f.Header.Get(frame.Version); version != "1.1" {
+		return fmt.Errorf("version %q, expected 1.1", version)
+	}
+	return c.disconnect()
+}
+
+func testUnsupportedVersion(t *tester) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	if err := c.send(t.connectFrame(frame.CONNECT, frame.AcceptVersion, "99.0")); err != nil {
+		return err
+	}
+	f, err := c.read()
+	for err == nil && f == nil {
+		f, err = c.read()
+	}
+	if err != nil {
+		return err
+	}
+	if f.Command != frame.ERROR {
+		return fmt.Errorf("received %s frame, expected ERROR", f.Command)
+	}
+	return c.closed()
+}
+
+func testStompCommand(t *tester) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	if err := c.send(t.connectFrame(frame.STOMP)); err != nil {
+		return err
+	}
+	if _, err := c.await(frame.CONNECTED, nil); err != nil {
+		return err
+	}
+	return c.disconnect()
+}
+
+func testReceipts(t *tester) error {
+	c, _, err := t.connect()
+	if err != nil {
+		return err
+	}
+	destination := t.destination()
+	if err := c.subscribe("1", destination, "auto"); err != nil {
+		return fmt.Errorf("SUBSCRIBE: %v", err)
+	}
+	if err := c.sendMessage(destination, "hello"); err != nil {
+		return fmt.Errorf("SEND: %v", err)
+	}
+	if _, err := c.expectBody("hello"); err != nil {
+		return err
+	}
+	if err := c.request(frame.New(frame.UNSUBSCRIBE, frame.Id, "1")); err != nil {
+		return fmt.Errorf("UNSUBSCRIBE: %v", err)
+	}
+	if err := c.disconnect(); err != nil {
+		return fmt.Errorf("DISCONNECT: %v", err)
+	}
+	return nil
+}
+
+func testSendReceive(t *tester) error {
+	c, _, err := t.connect()
+	if err != nil {
+		return err
+	}
+	destination := t.destination()
+	if err := c.subscribe("1", destination, "auto"); err != nil {
+		return err
+	}
+	// the value needs escaping in STOMP 1.2
+	value := "a:b\\c\nd"
+	if err := c.sendMessage(destination, "hello\x00world",
+		frame.ContentType, "text/plain", "x-custom", value); err != nil {
+		return err
+	}
+	f, err := c.expectBody("hello\x00world")
+	if err != nil {
+		return err
+	}
+	for _, check := range [][2]string{
+		{frame.Destination, destination},
+		{frame.Subscription, "1"},
+		{frame.ContentType, "text/plain"},
+		{"x-custom", value},
+	} {
+		if got := f.Header.Get(check[0]); got != check[1] {
+			return fmt.Errorf("MESSAGE header entry %s is %q, expected %q", check[0], got, check[1])
+		}
+	}
+	if f.Header.Get(frame.MessageId) == "" {
+		return fmt.Errorf("MESSAGE frame has no message-id header entry")
+	}
+	return c.disconnect()
+}
+
+func testTransactionCommit(t *tester) error {
+	consumer, producer, destination, err := connectPair(t)
+	if err != nil {
+		return err
+	}
+	if err := producer.request(frame.New(frame.BEGIN, frame.Transaction, "tx1")); err != nil {
+		return fmt.Errorf("BEGIN: %v", err)
+	}
+	if err := producer.sendMessage(destination, "in transaction", frame.Transaction, "tx1"); err != nil {
+		return err
+	}
+	// delivered before the message sent in the transaction
+	if err := producer.sendMessage(destination, "marker"); err != nil {
+		return err
+	}
+	if _, err := consumer.expectBody("marker"); err != nil {
+		return err
+	}
+	if err := producer.request(frame.New(frame.COMMIT, frame.Transaction, "tx1")); err != nil {
+		return fmt.Errorf("COMMIT: %v", err)
+	}
+	if _, err := consumer.expectBody("in transaction"); err != nil {
+		return err
+	}
+	return disconnectAll(consumer, producer)
+}
+
+func testTransactionAbort(t *tester) error {
+	consumer, producer, destination, err := connectPair(t)
+	if err != nil {
+		return err
+	}
+	if err := producer.request(frame.New(frame.BEGIN, frame.Transaction, "tx1")); err != nil {
+		return fmt.Errorf("BEGIN: %v", err)
+	}
+	if err := producer.sendMessage(destination, "aborted", frame.Transaction, "tx1"); err != nil {
+		return err
+	}
+	if err := producer.request(frame.New(frame.ABORT, frame.Transaction, "tx1")); err != nil {
+		return fmt.Errorf("ABORT: %v", err)
+	}
+	if err := producer.sendMessage(destination, "marker"); err != nil {
+		return err
+	}
+	if _, err := consumer.expectBody("marker"); err != nil {
+		return err
+	}
+	return disconnectAll(consumer, producer)
+}
+
+func testAckClient(t *tester) error {
+	return testAck(t, "client")
+}
+
+func testAckClientIndividual(t *tester) error {
+	return testAck(t, "client-individual")
+}
+
+// testAck sends three messages to a queue, receives the first two in
+// an ack mode, acknowledges the first one and disconnects, and then
+// checks that the other two are delivered to the next subscriber.
+// Servers may hold back messages until earlier ones are acknowledged,
+// so the second message is not received before the first one has been
+// acknowledged.
+func testAck(t *tester, mode string) error {
+	producer, _, err := t.connect()
+	if err != nil {
+		return err
+	}
+	destination := t.destination()
+	for _, body := range []string{"m1", "m2", "m3"} {
+		if err := producer.sendMessage(destination, body); err != nil {
+			return err
+		}
+	}
+
+	consumer, _, err := t.connect()
+	if err != nil {
+		return err
+	}
+	if err := consumer.subscribe("1", destination, mode); err != nil {
+		return err
+	}
+	f, err := consumer.expectBody("m1")
+	if err != nil {
+		return err
+	}
+	id, ok := f.Header.Contains(frame.Ack)
+	if !ok {
+		return fmt.Errorf("MESSAGE frame in %s mode has no ack header entry", mode)
+	}
+	if err := consumer.request(frame.New(frame.ACK, frame.Id, id)); err != nil {
+		return fmt.Errorf("ACK: %v", err)
+	}
+	if _, err := consumer.expectBody("m2"); err != nil {
+		return err
+	}
+	if err := consumer.disconnect(); err != nil {
+		return err
+	}
+
+	consumer, _, err = t.connect()
+	if err != nil {
+		return err
+	}
+	if err := consumer.subscribe("1", destination, "auto"); err != nil {
+		return err
+	}
+	// the marker follows the messages that were not acknowledged
+	if err := producer.sendMessage(destination, "marker"); err != nil {
+		return err
+	}
+	for _, body := range []string{"m2", "m3", "marker"} {
+		if _, err := consumer.expectBody(body); err != nil {
+			return err
+		}
+	}
+	return disconnectAll(consumer, producer)
+}
+
+func testHeartBeats(t *tester) error {
+	c, f, err := t.connect(frame.HeartBeat, "0,100")
+	if err != nil {
+		return err
+	}
+	sx, _, err := frame.ParseHeartBeat(f.Header.Get(frame.HeartBeat))
+	if err != nil {
+		return fmt.Errorf("CONNECTED frame: %v", err)
+	}
+	if sx == 0 {
+		return skip("the server does not send heart-beats")
+	}
+	if sx < 100*time.Millisecond {
+		return fmt.Errorf("heart-beat interval %v is shorter than the 100ms asked for", sx)
+	}
+	if 2*sx > t.config.Timeout {
+		return skip("the heart-beat interval %v is too long to test", sx)
+	}
+
+	// two heart-beats arrive within three intervals
+	deadline := time.Now().Add(3 * sx)
+	for received := 0; received < 2; {
+		c.nc.SetReadDeadline(deadline)
+		f, err := c.reader.Read()
+		if err != nil {
+			return fmt.Errorf("waiting for heart-beats every %v: %v", sx, err)
+		}
+		if f == nil {
+			received++
+		}
+	}
+	return c.disconnect()
+}
+
+func testErrorFrame(t *tester) error {
+	c, _, err := t.connect()
+	if err != nil {
+		return err
+	}
+	// a SEND frame must have a destination
+	if err := c.send(frame.New(frame.SEND, frame.Receipt, "bad")); err != nil {
+		return err
+	}
+	f, err := c.await(frame.ERROR, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Header.Contains(frame.Message); !ok {
+		return fmt.Errorf("ERROR frame has no message header entry")
+	}
+	if id, ok := f.Header.Contains(frame.ReceiptId); ok && id != "bad" {
+		return fmt.Errorf("ERROR frame has receipt-id %q, expected %q", id, "bad")
+	}
+	return c.closed()
+}
+
+// connectPair connects a consumer subscribed to a new destination, and
+// a producer.
+func connectPair(t *tester) (consumer, producer *conn, destination string, err error) {
+	consumer, _, err = t.connect()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	destination = t.destination()
+	if err := consumer.subscribe("1", destination, "auto"); err != nil {
+		return nil, nil, "", err
+	}
+	producer, _, err = t.connect()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return consumer, producer, destination, nil
+}
+
+func disconnectAll(conns ...*conn) error {
+	for _, c := range conns {
+		if err := c.disconnect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
 package stomp
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
@@ -39,35 +43,98 @@ type Conn struct {
 	version                   Version
 	session                   string
 	server                    string
+	capabilities              Capabilities
+	sendHeartBeat             time.Duration // negotiated heart-beat intervals, see Negotiated
+	receiveHeartBeat          time.Duration
 	readTimeout               time.Duration
 	writeTimeout              time.Duration
 	msgSendTimeout            time.Duration
 	rcvReceiptTimeout         time.Duration
 	disconnectReceiptTimeout  time.Duration
 	unsubscribeReceiptTimeout time.Duration
+	listenConcurrency         int
+	onHeartbeatMissed         func(missed int)
+	onConnectionLost          func(err error)
+	replyTo                   string
+	replyMutex                sync.Mutex
+	replies                   map[string]chan *Message // nil until the reply subscription is active
+	ackBatchSize              int
+	ackBatchInterval          time.Duration
+	ackMutex                  sync.Mutex
+	pendingAcks               map[string]*pendingAck // batched acknowledgements by subscription id
 	hbGracePeriodMultiplier   float64
 	closed                    bool
+	disconnecting             int32 // set when the client closes the connection
+	frameLogging              int32 // set when frames are logged
+	interceptors              []Interceptor
+	sendLimiter               *sendLimiter // nil unless sends are throttled
+	clock                     clock        // makes the heart-beat timers
 	closeMutex                *sync.Mutex
+	done                      chan struct{}
 	options                   *connOptions
 	log                       Logger
+	tracer                    Tracer
 }
 
 type writeRequest struct {
-	Frame *frame.Frame      // frame to send
-	C     chan *frame.Frame // response channel
+	Frame    *frame.Frame      // frame to send
+	C        chan *frame.Frame // response channel
+	Deadline time.Time         // time after which the receipt is no longer waited for, if not zero
+}
+
+// Interval at which the processLoop forgets receipts that are no
+// longer waited for.
+var receiptReapInterval = time.Second
+
+// Returns the deadline of a receipt that is waited for until timeout,
+// or the zero time if timeout is not greater than zero.
+func receiptDeadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
 }
 
 // Dial creates a network connection to a STOMP server and performs
 // the STOMP connect protocol sequence. The network endpoint of the
 // STOMP server is specified by network and addr. STOMP protocol
 // options can be specified in opts.
+//
+// The addr can also be a failover URI listing several brokers, see
+// FailoverScheme, in which case the first broker that can be reached
+// is connected to, or a ws:// or wss:// URL, see DialWebSocket.
 func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
-	c, err := net.Dial(network, addr)
+	if isWebSocketURL(addr) {
+		return DialWebSocket(addr, opts...)
+	}
+	if strings.HasPrefix(addr, FailoverScheme) {
+		failover, err := ParseFailover(addr)
+		if err != nil {
+			return nil, err
+		}
+		failover.Network = network
+		return failover.Dial(opts...)
+	}
+
+	// The options are applied again by Connect; here they are only
+	// needed to set up the network connection.
+	options, err := newConnOptions(&Conn{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := options.dialer().Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	// The remote address of a connection through a proxy is the
+	// address of the proxy, not of the server.
+	remote := c.RemoteAddr().String()
+	if options.ProxyURL != "" {
+		remote = addr
+	}
+	host, _, err := net.SplitHostPort(remote)
 	if err != nil {
 		c.Close()
 		return nil, err
@@ -80,6 +147,23 @@ func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
 	return Connect(c, opts...)
 }
 
+// DialTLS creates a TLS connection to a STOMP server over TCP, also
+// known as stomp+ssl, and performs the STOMP connect protocol sequence.
+// The config specifies the root CAs to trust, the client certificates
+// to present and so on; a nil config uses the default configuration.
+// If the config does not specify a ServerName, it is taken from addr.
+func DialTLS(addr string, config *tls.Config, opts ...func(*Conn) error) (*Conn, error) {
+	opts = append([]func(*Conn) error{ConnOpt.TLS(config)}, opts...)
+	return Dial("tcp", addr, opts...)
+}
+
+// A Dialer creates the network connections used by stomp.Dial.
+// Both *net.Dialer and *tls.Dialer implement Dialer, and so can
+// dialers for proxies and other transports.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
 // Connect creates a STOMP connection and performs the STOMP connect
 // protocol sequence. The connection to the STOMP server has already
 // been created by the program. The opts parameter provides the
@@ -91,6 +175,7 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 	c := &Conn{
 		conn:       conn,
 		closeMutex: &sync.Mutex{},
+		done:       make(chan struct{}),
 	}
 
 	options, err := newConnOptions(c, opts)
@@ -99,6 +184,12 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 	}
 
 	c.log = options.Logger
+	c.tracer = options.Tracer
+	if c.clock == nil {
+		// not set by a test
+		c.clock = realClock{}
+	}
+	c.interceptors = options.Interceptors
 
 	if options.ReadBufferSize > 0 {
 		reader = frame.NewReaderSize(conn, options.ReadBufferSize)
@@ -143,17 +234,26 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 		return nil, err
 	}
 
+	if err = c.interceptOutbound(connectFrame); err != nil {
+		return nil, err
+	}
+
 	err = writer.Write(connectFrame)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := reader.Read()
+	// skip any heart-beats or padding sent before the CONNECTED frame
+	response, err := reader.ReadFrame()
+	for err == frame.ErrHeartBeat {
+		response, err = reader.ReadFrame()
+	}
 	if err != nil {
 		return nil, err
 	}
-	if response == nil {
-		return nil, errors.New("unexpected empty frame")
+
+	if err = c.interceptInbound(response); err != nil {
+		return nil, err
 	}
 
 	if response.Command != frame.CONNECTED {
@@ -162,6 +262,7 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 
 	c.server = response.Header.Get(frame.Server)
 	c.session = response.Header.Get(frame.Session)
+	c.capabilities = ParseCapabilities(response.Header.Get(frame.Capabilities))
 
 	if versionString := response.Header.Get(frame.Version); versionString != "" {
 		version := Version(versionString)
@@ -176,9 +277,10 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 		// no version in the response, so assume version 1.0
 		c.version = V10
 	}
+	writer.SetVersion(string(c.version))
 
 	if heartBeat, ok := response.Header.Contains(frame.HeartBeat); ok {
-		readTimeout, writeTimeout, err := frame.ParseHeartBeat(heartBeat)
+		sx, sy, err := frame.ParseHeartBeat(heartBeat)
 		if err != nil {
 			return nil, Error{
 				Message: err.Error(),
@@ -186,12 +288,9 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 			}
 		}
 
-		if readTimeout < options.ReadTimeout {
-			readTimeout = options.ReadTimeout
-		}
-
-		c.readTimeout = readTimeout
-		c.writeTimeout = writeTimeout
+		c.writeTimeout, c.readTimeout = frame.NegotiateHeartBeat(
+			options.WriteTimeout, options.ReadTimeout, sx, sy)
+		c.sendHeartBeat, c.receiveHeartBeat = c.writeTimeout, c.readTimeout
 
 		if c.readTimeout > 0 {
 			// Add time to the read timeout to account for time
@@ -209,6 +308,14 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 	c.rcvReceiptTimeout = options.RcvReceiptTimeout
 	c.disconnectReceiptTimeout = options.DisconnectReceiptTimeout
 	c.unsubscribeReceiptTimeout = options.UnsubscribeReceiptTimeout
+	c.listenConcurrency = options.ListenConcurrency
+	c.onHeartbeatMissed = options.OnHeartbeatMissed
+	c.onConnectionLost = options.OnConnectionLost
+	c.replyTo = options.ReplyTo
+	c.ackBatchSize = options.AckBatchSize
+	c.ackBatchInterval = options.AckBatchInterval
+	c.SetFrameLogging(options.FrameLogging)
+	c.sendLimiter = newSendLimiter(options.SendMessagesPerSecond, options.SendBytesPerSecond)
 
 	if options.ResponseHeadersCallback != nil {
 		options.ResponseHeadersCallback(response.Header)
@@ -243,6 +350,22 @@ func (c *Conn) Server() string {
 	return c.server
 }
 
+// Negotiated returns what the client and the STOMP server agreed on
+// during the connect sequence, including the capabilities that the
+// server advertised, so that applications can adapt to the server.
+// The heart-beat intervals are those negotiated, before the
+// HeartBeatError connect option is applied.
+func (c *Conn) Negotiated() Negotiated {
+	return Negotiated{
+		Version:          c.version,
+		Session:          c.session,
+		Server:           c.server,
+		Capabilities:     c.capabilities,
+		SendHeartBeat:    c.sendHeartBeat,
+		ReceiveHeartBeat: c.receiveHeartBeat,
+	}
+}
+
 // readLoop is a goroutine that reads frames from the
 // reader and places them onto a channel for processing
 // by the processLoop goroutine
@@ -263,20 +386,51 @@ func processLoop(c *Conn, writer *frame.Writer) {
 	channels := make(map[string]chan *frame.Frame)
 
 	var readTimeoutChannel <-chan time.Time
-	var readTimer *time.Timer
+	var readTimer timer
 	var writeTimeoutChannel <-chan time.Time
-	var writeTimer *time.Timer
-
+	var writeTimer timer
+	var missedChannel <-chan time.Time
+	var missedTimer timer
+	var missed int
+
+	// deadlines of the receipts in channels that are waited for
+	// with a timeout
+	deadlines := make(map[string]time.Time)
+	var reapChannel <-chan time.Time
+	var reapTicker *time.Ticker
+	defer func() {
+		if reapTicker != nil {
+			reapTicker.Stop()
+		}
+	}()
+
+	// lost is the error that ended the connection, unless the client
+	// closed it
+	var lost error
+	defer func() {
+		if lost != nil && c.onConnectionLost != nil {
+			c.onConnectionLost(lost)
+		}
+	}()
+	defer close(c.done)
 	defer c.MustDisconnect()
 
 	for {
 		if c.readTimeout > 0 && readTimer == nil {
-			readTimer = time.NewTimer(time.Duration(float64(c.readTimeout) * c.hbGracePeriodMultiplier))
-			readTimeoutChannel = readTimer.C
+			readTimer = c.clock.NewTimer(time.Duration(float64(c.readTimeout) * c.hbGracePeriodMultiplier))
+			readTimeoutChannel = readTimer.Chan()
+		}
+		if c.readTimeout > 0 && c.onHeartbeatMissed != nil && missedTimer == nil {
+			missedTimer = c.clock.NewTimer(c.readTimeout)
+			missedChannel = missedTimer.Chan()
+		}
+		if len(deadlines) > 0 && reapTicker == nil {
+			reapTicker = time.NewTicker(receiptReapInterval)
+			reapChannel = reapTicker.C
 		}
 		if c.writeTimeout > 0 && writeTimer == nil {
-			writeTimer = time.NewTimer(c.writeTimeout)
-			writeTimeoutChannel = writeTimer.C
+			writeTimer = c.clock.NewTimer(c.writeTimeout)
+			writeTimeoutChannel = writeTimer.Chan()
 		}
 
 		select {
@@ -284,13 +438,38 @@ func processLoop(c *Conn, writer *frame.Writer) {
 			// read timeout, close the connection
 			err := newErrorMessage("read timeout")
 			sendError(channels, err)
+			lost = c.lostError(err)
 			return
 
+		case now := <-reapChannel:
+			// forget the receipts that are no longer waited for
+			for id, deadline := range deadlines {
+				if now.After(deadline) {
+					delete(deadlines, id)
+					delete(channels, id)
+					c.log.Debugf("receipt timed out: %s", id)
+				}
+			}
+			if len(deadlines) == 0 {
+				reapTicker.Stop()
+				reapTicker = nil
+				reapChannel = nil
+			}
+
+		case <-missedChannel:
+			// nothing received for a heart-beat interval, but still
+			// within the grace period
+			missed++
+			c.onHeartbeatMissed(missed)
+			missedTimer.Reset(c.readTimeout)
+
 		case <-writeTimeoutChannel:
 			// write timeout, send a heart-beat frame
-			err := writer.Write(nil)
+			c.logFrame("sent", nil)
+			err := writer.WriteHeartBeat()
 			if err != nil {
 				sendError(channels, err)
+				lost = c.lostError(err)
 				return
 			}
 			writeTimer = nil
@@ -303,29 +482,47 @@ func processLoop(c *Conn, writer *frame.Writer) {
 				readTimer = nil
 				readTimeoutChannel = nil
 			}
+			if missedTimer != nil {
+				missedTimer.Stop()
+				missedTimer = nil
+				missedChannel = nil
+				missed = 0
+			}
 
 			if !ok {
 				err := newErrorMessage("connection closed")
 				sendError(channels, err)
+				lost = c.lostError(err)
 				return
 			}
 
+			c.logFrame("received", f)
 			if f == nil {
 				// heart-beat received
 				continue
 			}
 
+			if err := c.interceptInbound(f); err != nil {
+				sendError(channels, err)
+				lost = c.lostError(err)
+				return
+			}
+
 			switch f.Command {
 			case frame.RECEIPT:
 				if id, ok := f.Header.Contains(frame.ReceiptId); ok {
 					if ch, ok := channels[id]; ok {
 						ch <- f
 						delete(channels, id)
+						delete(deadlines, id)
 						close(ch)
+					} else {
+						c.log.Debugf("ignored RECEIPT: %s", id)
 					}
 				} else {
 					err := &Error{Message: "missing receipt-id", Frame: f}
 					sendError(channels, err)
+					lost = c.lostError(err)
 					return
 				}
 
@@ -335,6 +532,7 @@ func processLoop(c *Conn, writer *frame.Writer) {
 					ch <- f
 					close(ch)
 				}
+				lost = c.lostError(newError(f))
 
 				c.closeMutex.Lock()
 				defer c.closeMutex.Unlock()
@@ -368,6 +566,9 @@ func processLoop(c *Conn, writer *frame.Writer) {
 				if receipt, ok := req.Frame.Header.Contains(frame.Receipt); ok {
 					// remember the channel for this receipt
 					channels[receipt] = req.C
+					if !req.Deadline.IsZero() {
+						deadlines[receipt] = req.Deadline
+					}
 				}
 			}
 
@@ -406,9 +607,16 @@ func processLoop(c *Conn, writer *frame.Writer) {
 
 			// frame to send, if enabled
 			if sendFrame {
+				if err := c.interceptOutbound(req.Frame); err != nil {
+					sendError(channels, err)
+					lost = c.lostError(err)
+					return
+				}
+				c.logFrame("sent", req.Frame)
 				err := writer.Write(req.Frame)
 				if err != nil {
 					sendError(channels, err)
+					lost = c.lostError(err)
 					return
 				}
 			}
@@ -416,6 +624,50 @@ func processLoop(c *Conn, writer *frame.Writer) {
 	}
 }
 
+// lostError returns err, or nil if the client is closing the
+// connection.
+func (c *Conn) lostError(err error) error {
+	if atomic.LoadInt32(&c.disconnecting) != 0 {
+		return nil
+	}
+	return err
+}
+
+// SetFrameLogging turns the logging of the frames sent and received
+// on the connection on or off. The frames are logged at the debug
+// level, with their header entries but not their bodies. It can be
+// called at any time; see also ConnOpt.FrameLogging.
+func (c *Conn) SetFrameLogging(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&c.frameLogging, value)
+}
+
+// logFrame logs a frame that has been sent or received, if frame
+// logging is on. A nil frame is a heart-beat.
+func (c *Conn) logFrame(action string, f *frame.Frame) {
+	if atomic.LoadInt32(&c.frameLogging) == 0 {
+		return
+	}
+	if f == nil {
+		c.log.Debugf("%s heart-beat", action)
+		return
+	}
+	fields := []Field{{CommandField, f.Command}}
+	if destination, ok := f.Header.Contains(frame.Destination); ok {
+		fields = append(fields, Field{DestinationField, destination})
+	}
+	var entries []string
+	f.Header.Range(func(key, value string) bool {
+		entries = append(entries, key+":"+value)
+		return true
+	})
+	WithFields(c.log, fields...).Debugf("%s %s frame [%s], %d byte body",
+		action, f.Command, strings.Join(entries, ", "), len(f.Body))
+}
+
 // Send an error to all receipt channels.
 func sendError(m map[string]chan *frame.Frame, err error) {
 	frame := frame.New(frame.ERROR, frame.Message, err.Error())
@@ -431,25 +683,42 @@ func sendError(m map[string]chan *frame.Frame, err error) {
 // with the STOMP server is closed and any further attempt to write
 // to the server will fail.
 func (c *Conn) Disconnect() error {
+	return c.DisconnectContext(context.Background())
+}
+
+// DisconnectContext is like Disconnect, but stops waiting for the
+// RECEIPT frame when ctx is done, in which case the connection is
+// closed and the error of ctx is returned.
+func (c *Conn) DisconnectContext(ctx context.Context) error {
+	if err := c.FlushAcks(); err != nil {
+		c.log.Warningf("sending batched ACKs failed: %v", err)
+	}
+
 	c.closeMutex.Lock()
 	defer c.closeMutex.Unlock()
 	if c.closed {
 		return nil
 	}
 
-	ch := make(chan *frame.Frame)
-	c.writeCh <- writeRequest{
-		Frame: frame.New(frame.DISCONNECT, frame.Receipt, allocateId()),
-		C:     ch,
+	ch := make(chan *frame.Frame, 1)
+	request := writeRequest{
+		Frame:    frame.New(frame.DISCONNECT, frame.Receipt, allocateId()),
+		C:        ch,
+		Deadline: receiptDeadline(c.disconnectReceiptTimeout),
 	}
+	err := sendDataToWriteChContext(ctx, c.writeCh, request, 0)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.disconnecting, 1)
 
-	err := readReceiptWithTimeout(ch, c.disconnectReceiptTimeout, ErrDisconnectReceiptTimeout)
+	err = readReceiptContext(ctx, ch, c.disconnectReceiptTimeout, ErrDisconnectReceiptTimeout)
 	if err == nil {
 		c.closed = true
 		return c.conn.Close()
 	}
 
-	if err == ErrDisconnectReceiptTimeout {
+	if err == ErrDisconnectReceiptTimeout || err == ctx.Err() {
 		c.closed = true
 		_ = c.conn.Close()
 	}
@@ -468,6 +737,7 @@ func (c *Conn) MustDisconnect() error {
 	}
 
 	// just close writeCh
+	atomic.StoreInt32(&c.disconnecting, 1)
 	close(c.writeCh)
 
 	c.closed = true
@@ -484,6 +754,20 @@ func (c *Conn) MustDisconnect() error {
 // Any number of options can be specified in opts. See the examples for usage. Options include whether
 // to receive a RECEIPT, should the content-length be suppressed, and sending custom header entries.
 func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	return c.SendContext(context.Background(), destination, contentType, body, opts...)
+}
+
+// SendContext is like Send, but stops waiting for space on the write
+// channel, or for the RECEIPT frame if one was requested, when ctx is
+// done, in which case the error of ctx is returned. The message may
+// still be delivered after the wait for its receipt has stopped.
+func (c *Conn) SendContext(ctx context.Context, destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	// wait for the rate limit before locking, so that throttled sends
+	// do not hold up disconnecting
+	if err := c.sendLimiter.wait(ctx, len(body)); err != nil {
+		return err
+	}
+
 	c.closeMutex.Lock()
 	defer c.closeMutex.Unlock()
 	if c.closed {
@@ -495,19 +779,48 @@ func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*
 		return err
 	}
 
+	span := StartSpan(c.tracer, "send", f.Header, destination)
+	err = c.sendMessage(ctx, f)
+	span.End(err)
+	return err
+}
+
+// SendWithReceipt is like Send, but always requests a RECEIPT frame
+// from the server and waits for it, so that a nil error means that the
+// server has received the message. The RECEIPT frame is matched to the
+// message by its receipt-id header entry. If the server answers with
+// an ERROR frame instead, the error describes it; if no answer arrives
+// within the RcvReceiptTimeout connect option, ErrMsgReceiptTimeout is
+// returned.
+func (c *Conn) SendWithReceipt(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	return c.SendWithReceiptContext(context.Background(), destination, contentType, body, opts...)
+}
+
+// SendWithReceiptContext is like SendWithReceipt, but also stops
+// waiting when ctx is done. See SendContext.
+func (c *Conn) SendWithReceiptContext(ctx context.Context, destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	opts = append(opts[:len(opts):len(opts)], SendOpt.Receipt)
+	return c.SendContext(ctx, destination, contentType, body, opts...)
+}
+
+// Passes a SEND frame to the write channel, and waits for its
+// receipt if it has a receipt header entry.
+func (c *Conn) sendMessage(ctx context.Context, f *frame.Frame) error {
 	if _, ok := f.Header.Contains(frame.Receipt); ok {
-		// receipt required
+		// receipt required; the channel is buffered so that a receipt
+		// that is no longer waited for does not block the processLoop
 		request := writeRequest{
-			Frame: f,
-			C:     make(chan *frame.Frame),
+			Frame:    f,
+			C:        make(chan *frame.Frame, 1),
+			Deadline: receiptDeadline(c.rcvReceiptTimeout),
 		}
 
-		err := sendDataToWriteChWithTimeout(c.writeCh, request, c.msgSendTimeout)
+		err := sendDataToWriteChContext(ctx, c.writeCh, request, c.msgSendTimeout)
 		if err != nil {
 			return err
 		}
 
-		err = readReceiptWithTimeout(request.C, c.rcvReceiptTimeout, ErrMsgReceiptTimeout)
+		err = readReceiptContext(ctx, request.C, c.rcvReceiptTimeout, ErrMsgReceiptTimeout)
 		if err != nil {
 			return err
 		}
@@ -515,7 +828,7 @@ func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*
 		// no receipt required
 		request := writeRequest{Frame: f}
 
-		err := sendDataToWriteChWithTimeout(c.writeCh, request, c.msgSendTimeout)
+		err := sendDataToWriteChContext(ctx, c.writeCh, request, c.msgSendTimeout)
 		if err != nil {
 			return err
 		}
@@ -525,14 +838,24 @@ func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*
 }
 
 func readReceiptWithTimeout(responseChan chan *frame.Frame, timeout time.Duration, timeoutErr error) error {
+	return readReceiptContext(context.Background(), responseChan, timeout, timeoutErr)
+}
+
+// Waits for the response to a frame sent with a receipt header entry,
+// until the timeout, if greater than zero, expires or ctx is done.
+func readReceiptContext(ctx context.Context, responseChan chan *frame.Frame, timeout time.Duration, timeoutErr error) error {
 	var timeoutChan <-chan time.Time
 	if timeout > 0 {
-		timeoutChan = time.After(timeout)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
 	}
 
 	select {
 	case <-timeoutChan:
 		return timeoutErr
+	case <-ctx.Done():
+		return ctx.Err()
 	case response := <-responseChan:
 		if response.Command != frame.RECEIPT {
 			return newError(response)
@@ -542,17 +865,29 @@ func readReceiptWithTimeout(responseChan chan *frame.Frame, timeout time.Duratio
 }
 
 func sendDataToWriteChWithTimeout(ch chan writeRequest, request writeRequest, timeout time.Duration) error {
-	if timeout <= 0 {
-		ch <- request
-		return nil
+	return sendDataToWriteChContext(context.Background(), ch, request, timeout)
+}
+
+// Passes a request to the write channel, waiting for space until the
+// timeout, if greater than zero, expires or ctx is done.
+func sendDataToWriteChContext(ctx context.Context, ch chan writeRequest, request writeRequest, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
 	}
 
-	timer := time.NewTimer(timeout)
 	select {
-	case <-timer.C:
+	case <-timeoutChan:
 		return ErrMsgSendTimeout
+	case <-ctx.Done():
+		return ctx.Err()
 	case ch <- request:
-		timer.Stop()
 		return nil
 	}
 }
@@ -580,6 +915,10 @@ func createSendFrame(destination, contentType string, body []byte, opts []func(*
 }
 
 func (c *Conn) sendFrame(f *frame.Frame) error {
+	return c.sendFrameContext(context.Background(), f)
+}
+
+func (c *Conn) sendFrameContext(ctx context.Context, f *frame.Frame) error {
 	// Lock our mutex, but don't close it via defer
 	// If the frame requests a receipt then we want to release the lock before
 	// we block on the response, otherwise we can end up deadlocking
@@ -593,11 +932,15 @@ func (c *Conn) sendFrame(f *frame.Frame) error {
 	if _, ok := f.Header.Contains(frame.Receipt); ok {
 		// receipt required
 		request := writeRequest{
-			Frame: f,
-			C:     make(chan *frame.Frame),
+			Frame:    f,
+			C:        make(chan *frame.Frame, 1),
+			Deadline: receiptDeadline(c.rcvReceiptTimeout),
 		}
 
-		c.writeCh <- request
+		if err := sendDataToWriteChContext(ctx, c.writeCh, request, 0); err != nil {
+			c.closeMutex.Unlock()
+			return err
+		}
 
 		// Now that we've written to the writeCh channel we can release the
 		// close mutex while we wait for our response
@@ -605,14 +948,18 @@ func (c *Conn) sendFrame(f *frame.Frame) error {
 
 		var response *frame.Frame
 
-		if c.writeTimeout > 0 {
-			select {
-			case response, ok = <-request.C:
-			case <-time.After(c.writeTimeout):
-				ok = false
-			}
-		} else {
-			response, ok = <-request.C
+		var timeoutChan <-chan time.Time
+		if !request.Deadline.IsZero() {
+			timer := time.NewTimer(time.Until(request.Deadline))
+			defer timer.Stop()
+			timeoutChan = timer.C
+		}
+		select {
+		case response, ok = <-request.C:
+		case <-timeoutChan:
+			return ErrReceiptTimeout
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 
 		if ok {
@@ -625,7 +972,10 @@ func (c *Conn) sendFrame(f *frame.Frame) error {
 	} else {
 		// no receipt required
 		request := writeRequest{Frame: f}
-		c.writeCh <- request
+		if err := sendDataToWriteChContext(ctx, c.writeCh, request, 0); err != nil {
+			c.closeMutex.Unlock()
+			return err
+		}
 
 		// Unlock the mutex now that we're written to the write channel
 		c.closeMutex.Unlock()
@@ -639,6 +989,13 @@ func (c *Conn) sendFrame(f *frame.Frame) error {
 // will be received by this subscription. A subscription has a channel
 // on which the calling program can receive messages.
 func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Frame) error) (*Subscription, error) {
+	return c.SubscribeContext(context.Background(), destination, ack, opts...)
+}
+
+// SubscribeContext is like Subscribe, but stops waiting for space on
+// the write channel when ctx is done, in which case the error of ctx is
+// returned and no subscription is created.
+func (c *Conn) SubscribeContext(ctx context.Context, destination string, ack AckMode, opts ...func(*frame.Frame) error) (*Subscription, error) {
 	c.closeMutex.Lock()
 	defer c.closeMutex.Unlock()
 	if c.closed {
@@ -662,6 +1019,7 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 		}
 	}
 
+	capacity, overflow := subscriptionSettings(subscribeFrame)
 	replyTo, replyToSet := subscribeFrame.Header.Contains(ReplyToHeader)
 
 	if replyToSet {
@@ -688,15 +1046,19 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 		destination:               destination,
 		conn:                      c,
 		ackMode:                   ack,
-		C:                         make(chan *Message, 16),
+		C:                         make(chan *Message, capacity),
 		closeMutex:                closeMutex,
 		closeCond:                 sync.NewCond(closeMutex),
 		unsubscribeReceiptTimeout: c.unsubscribeReceiptTimeout,
+		overflow:                  overflow,
+		done:                      make(chan struct{}),
 	}
-	go sub.readLoop(ch)
 
 	// TODO is this safe? There is no check if writeCh is actually open.
-	c.writeCh <- request
+	if err := sendDataToWriteChContext(ctx, c.writeCh, request, 0); err != nil {
+		return nil, err
+	}
+	go sub.readLoop(ch)
 	return sub, nil
 }
 
@@ -706,13 +1068,23 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 // If the message was received on a subscription with AckMode == AckAuto,
 // then no operation is performed.
 func (c *Conn) Ack(m *Message) error {
+	return c.AckContext(context.Background(), m)
+}
+
+// AckContext is like Ack, but stops waiting for space on the write
+// channel, or for the RECEIPT frame if one was requested, when ctx is
+// done, in which case the error of ctx is returned.
+func (c *Conn) AckContext(ctx context.Context, m *Message) error {
 	f, err := c.createAckNackFrame(m, true)
 	if err != nil {
 		return err
 	}
 
 	if f != nil {
-		return c.sendFrame(f)
+		if c.batchesAck(m) {
+			return c.batchAck(ctx, f, m)
+		}
+		return c.sendAckNackFrame(ctx, f, m)
 	}
 	return nil
 }
@@ -721,17 +1093,45 @@ func (c *Conn) Ack(m *Message) error {
 // by the client. Returns an error if the STOMP version does not
 // support the NACK message.
 func (c *Conn) Nack(m *Message) error {
+	return c.NackContext(context.Background(), m)
+}
+
+// NackContext is like Nack, but stops waiting when ctx is done.
+// See AckContext.
+func (c *Conn) NackContext(ctx context.Context, m *Message) error {
 	f, err := c.createAckNackFrame(m, false)
 	if err != nil {
 		return err
 	}
 
 	if f != nil {
-		return c.sendFrame(f)
+		if c.batchesAck(m) {
+			// the pending ACK is for earlier messages
+			if err := c.flushAck(ctx, m.Subscription.Id()); err != nil {
+				return err
+			}
+		}
+		return c.sendAckNackFrame(ctx, f, m)
 	}
 	return nil
 }
 
+// Sends an ACK or NACK frame for a message. If the connection has a
+// tracer, the frame carries the context of a span that is a child of
+// the message's span.
+func (c *Conn) sendAckNackFrame(ctx context.Context, f *frame.Frame, m *Message) error {
+	if c.tracer == nil {
+		return c.sendFrameContext(ctx, f)
+	}
+	if sc := SpanContextFromHeader(m.Header); sc.IsValid() {
+		SetSpanContext(f.Header, sc)
+	}
+	span := StartSpan(c.tracer, strings.ToLower(f.Command), f.Header, m.Destination)
+	err := c.sendFrameContext(ctx, f)
+	span.End(err)
+	return err
+}
+
 // Begin is used to start a transaction. Transactions apply to sending
 // and acknowledging. Any messages sent or acknowledged during a transaction
 // will be processed atomically by the STOMP server based on the transaction.
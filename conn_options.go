@@ -1,7 +1,9 @@
 package stomp
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -29,6 +31,22 @@ type connOptions struct {
 	ReadBufferSize, WriteBufferSize           int
 	ResponseHeadersCallback                   func(*frame.Header)
 	Logger                                    Logger
+	Tracer                                    Tracer
+	DialTimeout                               time.Duration
+	Dialer                                    Dialer
+	ProxyURL                                  string
+	TLSConfig                                 *tls.Config
+	ListenConcurrency                         int
+	OnHeartbeatMissed                         func(missed int)
+	OnConnectionLost                          func(err error)
+	ReplyTo                                   string
+	FrameLogging                              bool
+	AckBatchSize                              int
+	AckBatchInterval                          time.Duration
+	Interceptors                              []Interceptor
+	SendMessagesPerSecond                     float64
+	SendBytesPerSecond                        float64
+	ClientId                                  string
 }
 
 func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error) {
@@ -42,6 +60,8 @@ func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error)
 		RcvReceiptTimeout:              DefaultRcvReceiptTimeout,
 		DisconnectReceiptTimeout:       DefaultDisconnectReceiptTimeout,
 		UnsubscribeReceiptTimeout:      DefaultUnsubscribeReceiptTimeout,
+		ListenConcurrency:              1,
+		ReplyTo:                        defaultReplyTo,
 		Logger:                         log.StdLogger{},
 	}
 
@@ -69,6 +89,29 @@ func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error)
 	return co, nil
 }
 
+// netDialer returns the Dialer that creates the network connections
+// for stomp.Dial, before any TLS handshake.
+func (co *connOptions) netDialer() Dialer {
+	var d Dialer = co.Dialer
+	if d == nil {
+		d = &net.Dialer{Timeout: co.DialTimeout}
+	}
+	if co.ProxyURL != "" {
+		// the URL has been checked by the Proxy option
+		d, _ = NewProxyDialer(co.ProxyURL, d)
+	}
+	return d
+}
+
+// dialer returns the Dialer to use for stomp.Dial.
+func (co *connOptions) dialer() Dialer {
+	d := co.netDialer()
+	if co.TLSConfig != nil {
+		return &tlsDialer{forward: d, config: co.TLSConfig}
+	}
+	return d
+}
+
 func (co *connOptions) NewFrame() (*frame.Frame, error) {
 	f := frame.New(co.FrameCommand)
 	if co.Host != "" {
@@ -91,6 +134,11 @@ func (co *connOptions) NewFrame() (*frame.Frame, error) {
 	// accept-version
 	f.Header.Set(frame.AcceptVersion, strings.Join(co.AcceptVersions, ","))
 
+	// client-id, for durable subscriptions
+	if co.ClientId != "" {
+		f.Header.Set(ClientIdHeader, co.ClientId)
+	}
+
 	// custom header entries -- note that these do not override
 	// header values already set as they are added to the end of
 	// the header array
@@ -151,6 +199,9 @@ var ConnOpt struct {
 	// RcvReceiptTimeout is a connect option that allows the client to specify
 	// how long to wait for a receipt in the Conn.Send function. This helps
 	// avoid deadlocks. If this is not specified, the default is 30 seconds.
+	// The same timeout applies to the other frames sent with a receipt
+	// header entry, such as those of Transaction.Send, which then return
+	// ErrReceiptTimeout. Zero means no timeout.
 	RcvReceiptTimeout func(rcvReceiptTimeout time.Duration) func(*Conn) error
 
 	// DisconnectReceiptTimeout is a connect option that allows the client to specify
@@ -198,6 +249,98 @@ var ConnOpt struct {
 
 	// Logger lets you provide a callback function that sets the logger used by a connection
 	Logger func(logger Logger) func(*Conn) error
+
+	// Tracer is a connect option that starts a span for each message
+	// sent, received, acknowledged or negatively acknowledged by the
+	// connection, and propagates the context of the span in the
+	// "traceparent" and "tracestate" header entries of the message.
+	Tracer func(tracer Tracer) func(*Conn) error
+
+	// DialTimeout is a connect option that limits how long stomp.Dial
+	// waits for the network connection to be established. It has no
+	// effect on stomp.Connect. Zero, the default, means no timeout.
+	DialTimeout func(timeout time.Duration) func(*Conn) error
+
+	// Dialer is a connect option that specifies the Dialer used by
+	// stomp.Dial to create the network connection, for example for a
+	// custom transport. When a Dialer is specified, the DialTimeout
+	// option has no effect. The TLS and Proxy options still apply.
+	Dialer func(dialer Dialer) func(*Conn) error
+
+	// Proxy is a connect option that makes stomp.Dial connect through
+	// a SOCKS5 or HTTP CONNECT proxy, see stomp.NewProxyDialer. The
+	// proxy itself is dialed with the Dialer option, if specified.
+	Proxy func(proxyURL string) func(*Conn) error
+
+	// TLS is a connect option that makes stomp.Dial create a TLS
+	// connection with the given configuration. A nil config uses the
+	// default configuration. See also stomp.DialTLS.
+	TLS func(config *tls.Config) func(*Conn) error
+
+	// ListenConcurrency is a connect option that specifies how many
+	// goroutines Subscription.Listen uses to call its handler. The
+	// default is 1, which handles the messages of a subscription in
+	// order. Values less than 1 are treated as 1.
+	ListenConcurrency func(n int) func(*Conn) error
+
+	// OnHeartbeatMissed is a connect option that specifies a function
+	// to call each time a heart-beat interval passes without anything
+	// received from the server, with the number of consecutive intervals
+	// missed. The connection is only closed when the read timeout,
+	// multiplied by the HeartBeatGracePeriodMultiplier, has passed, so the
+	// function is called before that only if the multiplier is greater
+	// than 1. The function is called from the goroutine that processes
+	// frames, and should return quickly.
+	OnHeartbeatMissed func(callback func(missed int)) func(*Conn) error
+
+	// OnConnectionLost is a connect option that specifies a function to
+	// call once the connection has been closed because of a missed
+	// heart-beat, a network error or an ERROR frame from the server.
+	// The function is not called when the client disconnects.
+	OnConnectionLost func(callback func(err error)) func(*Conn) error
+
+	// ReplyTo is a connect option that specifies the destination on
+	// which Conn.Request receives replies. The default is the temporary
+	// queue "/temp-queue/replies", which brokers such as RabbitMQ create
+	// for the connection. Other destinations are subscribed to, and
+	// should not be shared with other connections.
+	ReplyTo func(destination string) func(*Conn) error
+
+	// FrameLogging is a connect option that turns on the logging of the
+	// frames sent and received on the connection, at the debug level.
+	// It can be turned on and off later with Conn.SetFrameLogging.
+	FrameLogging func(enabled bool) func(*Conn) error
+
+	// AckBatch is a connect option that batches the acknowledgements of
+	// messages received on subscriptions with AckClient mode. Because an
+	// ACK frame in that mode acknowledges all earlier messages of the
+	// subscription too, only the latest acknowledgement is sent, once
+	// count messages have been acknowledged or interval has passed since
+	// the first of them, whichever comes first. A zero count or interval
+	// disables that trigger. See also Conn.FlushAcks.
+	AckBatch func(count int, interval time.Duration) func(*Conn) error
+
+	// Interceptor is a connect option that adds an interceptor of the
+	// frames sent and received on the connection, including the
+	// CONNECT and CONNECTED frames. It can be used more than once; the
+	// first interceptor added sees received frames first and sent
+	// frames last.
+	Interceptor func(i Interceptor) func(*Conn) error
+
+	// SendRateLimit is a connect option that limits the rate at which
+	// messages are sent, to stay below the rate limits of a broker and
+	// to smooth bursts. Sends wait until they are within messages per
+	// second and body bytes per second on average, with bursts of up to
+	// one second allowed; a zero rate is not limited. Sends in
+	// transactions are limited too. Waiting stops with the context of
+	// SendContext.
+	SendRateLimit func(messages, bytes float64) func(*Conn) error
+
+	// ClientId is a connect option that sets the "client-id" header
+	// entry of the CONNECT frame, which ActiveMQ and Artemis use with
+	// the name of a durable subscription to identify it. See
+	// Conn.SubscribeDurable.
+	ClientId func(id string) func(*Conn) error
 }
 
 func init() {
@@ -329,6 +472,47 @@ func init() {
 		}
 	}
 
+	ConnOpt.Tracer = func(tracer Tracer) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.Tracer = tracer
+			return nil
+		}
+	}
+
+	ConnOpt.DialTimeout = func(timeout time.Duration) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.DialTimeout = timeout
+			return nil
+		}
+	}
+
+	ConnOpt.Dialer = func(dialer Dialer) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.Dialer = dialer
+			return nil
+		}
+	}
+
+	ConnOpt.Proxy = func(proxyURL string) func(*Conn) error {
+		return func(c *Conn) error {
+			if _, err := NewProxyDialer(proxyURL, nil); err != nil {
+				return err
+			}
+			c.options.ProxyURL = proxyURL
+			return nil
+		}
+	}
+
+	ConnOpt.TLS = func(config *tls.Config) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.TLSConfig = config
+			if config == nil {
+				c.options.TLSConfig = &tls.Config{}
+			}
+			return nil
+		}
+	}
+
 	ConnOpt.Logger = func(log Logger) func(*Conn) error {
 		return func(c *Conn) error {
 			if log != nil {
@@ -338,4 +522,75 @@ func init() {
 			return nil
 		}
 	}
+
+	ConnOpt.ListenConcurrency = func(n int) func(*Conn) error {
+		return func(c *Conn) error {
+			if n < 1 {
+				n = 1
+			}
+			c.options.ListenConcurrency = n
+			return nil
+		}
+	}
+
+	ConnOpt.OnHeartbeatMissed = func(callback func(missed int)) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.OnHeartbeatMissed = callback
+			return nil
+		}
+	}
+
+	ConnOpt.OnConnectionLost = func(callback func(err error)) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.OnConnectionLost = callback
+			return nil
+		}
+	}
+
+	ConnOpt.ReplyTo = func(destination string) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.ReplyTo = destination
+			return nil
+		}
+	}
+
+	ConnOpt.FrameLogging = func(enabled bool) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.FrameLogging = enabled
+			return nil
+		}
+	}
+
+	ConnOpt.AckBatch = func(count int, interval time.Duration) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.AckBatchSize = count
+			c.options.AckBatchInterval = interval
+			return nil
+		}
+	}
+
+	ConnOpt.Interceptor = func(i Interceptor) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.Interceptors = append(c.options.Interceptors, i)
+			return nil
+		}
+	}
+
+	ConnOpt.SendRateLimit = func(messages, bytes float64) func(*Conn) error {
+		return func(c *Conn) error {
+			if messages < 0 || bytes < 0 {
+				return ErrInvalidSendRate
+			}
+			c.options.SendMessagesPerSecond = messages
+			c.options.SendBytesPerSecond = bytes
+			return nil
+		}
+	}
+
+	ConnOpt.ClientId = func(id string) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.ClientId = id
+			return nil
+		}
+	}
 }
@@ -3,6 +3,8 @@ package stomp
 import (
 	"fmt"
 	"io"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
@@ -59,6 +61,63 @@ func (s *StompSuite) Test_conn_option_set_logger(c *C) {
 	c.Assert(conn.log, Equals, mockLogger)
 }
 
+func (s *StompSuite) Test_connect_skips_heart_beats(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		defer fc2.Close()
+
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		f1, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f1.Command, Equals, "CONNECT")
+		// padding before the CONNECTED frame
+		_, err = fc2.Write([]byte("\n\r\n\n"))
+		c.Assert(err, IsNil)
+		err = writer.Write(frame.New("CONNECTED", "version", "1.2", "session", "s1"))
+		c.Assert(err, IsNil)
+		reader.Read()
+	}()
+
+	conn, err := Connect(fc1)
+	c.Assert(err, IsNil)
+	c.Check(conn.Session(), Equals, "s1")
+	conn.MustDisconnect()
+	fc1.Close()
+}
+
+func (s *StompSuite) Test_dial_with_timeout(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		rw, err := l.Accept()
+		c.Assert(err, IsNil)
+		defer rw.Close()
+
+		reader := frame.NewReader(rw)
+		writer := frame.NewWriter(rw)
+		f1, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f1.Command, Equals, "CONNECT")
+		c.Check(f1.Header.Get("host"), Equals, "127.0.0.1")
+		c.Check(f1.Header.Get("login"), Equals, "guest")
+		c.Check(f1.Header.Get("x-custom"), Equals, "value")
+		err = writer.Write(frame.New("CONNECTED", "version", "1.2"))
+		c.Assert(err, IsNil)
+		reader.Read()
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(),
+		ConnOpt.DialTimeout(time.Second),
+		ConnOpt.Login("guest", "guest"),
+		ConnOpt.Header("x-custom", "value"))
+	c.Assert(err, IsNil)
+	c.Check(conn.Version(), Equals, V12)
+	conn.MustDisconnect()
+}
+
 func (s *StompSuite) Test_unsuccessful_connect(c *C) {
 	fc1, fc2 := testutil.NewFakeConn(c)
 	stop := make(chan struct{})
@@ -784,3 +843,319 @@ func (s *StompSuite) Test_ZeroTimeout(c *C) {
 
 	c.Assert(err, IsNil)
 }
+
+func (s *StompSuite) Test_send_with_receipt_error(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		defer fc2.Close()
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		receipt, ok := f.Header.Contains(frame.Receipt)
+		c.Check(ok, Equals, true)
+		c.Assert(writer.Write(frame.New(frame.ERROR,
+			frame.ReceiptId, receipt,
+			frame.Message, "queue full")), IsNil)
+	}()
+
+	conn, err := Connect(fc1)
+	c.Assert(err, IsNil)
+	err = conn.SendWithReceipt("/queue/test", "text/plain", []byte("hello"))
+	c.Assert(err, FitsTypeOf, Error{})
+	c.Check(err.(Error).Message, Equals, "queue full")
+	c.Check(err.(Error).Frame.Header.Get(frame.ReceiptId), Not(Equals), "")
+}
+
+func (s *StompSuite) Test_send_with_receipt_timeout(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		defer fc2.Close()
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		// never answer
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := Connect(fc1, ConnOpt.RcvReceiptTimeout(20*time.Millisecond))
+	c.Assert(err, IsNil)
+	err = conn.SendWithReceipt("/queue/test", "text/plain", []byte("hello"))
+	c.Check(err, Equals, ErrMsgReceiptTimeout)
+	conn.MustDisconnect()
+}
+
+func (s *StompSuite) Test_transaction_send_with_receipt(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+		begin, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(begin.Command, Equals, frame.BEGIN)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(frame.Transaction), Equals, begin.Header.Get(frame.Transaction))
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.COMMIT)
+		c.Check(f.Header.Get(frame.Transaction), Equals, begin.Header.Get(frame.Transaction))
+	}()
+
+	conn, err := Connect(fc1)
+	c.Assert(err, IsNil)
+	tx := conn.Begin()
+	c.Assert(tx.SendWithReceipt("/queue/test", "text/plain", []byte("hello")), IsNil)
+	c.Assert(tx.Commit(), IsNil)
+	c.Check(tx.SendWithReceipt("/queue/test", "", nil), Equals, ErrCompletedTransaction)
+	<-done
+	conn.MustDisconnect()
+}
+
+func (s *StompSuite) Test_message_ack_nack(c *C) {
+	for _, version := range []Version{V11, V12} {
+		conn, rw := connectHelper(c, version)
+		stop := make(chan struct{})
+
+		go func() {
+			defer func() {
+				rw.Close()
+				close(stop)
+			}()
+
+			f, err := rw.Read()
+			c.Assert(err, IsNil)
+			c.Assert(f.Command, Equals, frame.SUBSCRIBE)
+			id := f.Header.Get(frame.Id)
+			for _, messageId := range []string{"m1", "m2"} {
+				msg := frame.New(frame.MESSAGE,
+					frame.Subscription, id,
+					frame.MessageId, messageId,
+					frame.Destination, "/queue/test")
+				if version == V12 {
+					msg.Header.Add(frame.Ack, "ack-"+messageId)
+				}
+				c.Assert(rw.Write(msg), IsNil)
+			}
+
+			for _, command := range []string{frame.ACK, frame.NACK} {
+				f, err = rw.Read()
+				c.Assert(err, IsNil)
+				c.Check(f.Command, Equals, command)
+				messageId := "m1"
+				if command == frame.NACK {
+					messageId = "m2"
+				}
+				if version == V12 {
+					c.Check(f.Header.Get(frame.Id), Equals, "ack-"+messageId)
+				} else {
+					c.Check(f.Header.Get(frame.Subscription), Equals, id)
+					c.Check(f.Header.Get(frame.MessageId), Equals, messageId)
+				}
+			}
+		}()
+
+		sub, err := conn.Subscribe("/queue/test", AckClientIndividual)
+		c.Assert(err, IsNil)
+		c.Assert((<-sub.C).Ack(), IsNil)
+		c.Assert((<-sub.C).Nack(), IsNil)
+		<-stop
+		conn.MustDisconnect()
+	}
+
+	conn, rw := connectHelper(c, V10)
+	msg := &Message{
+		Conn:         conn,
+		Subscription: &Subscription{ackMode: AckClient},
+		Header:       frame.NewHeader(frame.MessageId, "m1"),
+	}
+	c.Check(msg.Nack(), Equals, ErrNackNotSupported)
+	conn.MustDisconnect()
+	rw.Close()
+
+	c.Check((&Message{}).Ack(), Equals, ErrNotReceivedMessage)
+	c.Check((&Message{}).Nack(), Equals, ErrNotReceivedMessage)
+}
+
+func (s *StompSuite) Test_heart_beat_callbacks(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		// the server promises heart-beats, but never sends any
+		c.Assert(writer.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2", frame.HeartBeat, "50,0")), IsNil)
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	clock := &fakeClock{}
+	missed := make(chan int, 10)
+	lost := make(chan error, 1)
+	conn, err := Connect(fc1,
+		fakeClockOpt(clock),
+		ConnOpt.HeartBeat(0, time.Millisecond),
+		ConnOpt.HeartBeatError(time.Millisecond),
+		ConnOpt.HeartBeatGracePeriodMultiplier(3.5),
+		ConnOpt.OnHeartbeatMissed(func(n int) { missed <- n }),
+		ConnOpt.OnConnectionLost(func(err error) { lost <- err }))
+	c.Assert(err, IsNil)
+	c.Assert(conn.readTimeout, Equals, 51*time.Millisecond)
+
+	// three intervals fit in the grace period of 178.5ms
+	for i := 1; i <= 3; i++ {
+		c.Assert(clock.WaitArmed(2), Equals, true)
+		clock.Advance(51 * time.Millisecond)
+		c.Check(<-missed, Equals, i)
+	}
+	c.Assert(clock.WaitArmed(2), Equals, true)
+	clock.Advance(26 * time.Millisecond)
+	c.Check(<-lost, ErrorMatches, "read timeout")
+	<-conn.done
+	c.Check(len(missed), Equals, 0)
+	c.Check(conn.Send("/queue/test", "", nil), Equals, ErrAlreadyClosed)
+}
+
+func (s *StompSuite) Test_connection_lost_not_reported_on_disconnect(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+	}()
+
+	lost := make(chan error, 1)
+	conn, err := Connect(fc1, ConnOpt.OnConnectionLost(func(err error) { lost <- err }))
+	c.Assert(err, IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-conn.done
+	select {
+	case err = <-lost:
+		c.Errorf("connection lost reported: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *StompSuite) Test_frame_logging(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				return
+			}
+			if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+				c.Assert(writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt)), IsNil)
+			}
+		}
+	}()
+
+	resetId()
+	log := &testLogger{}
+	conn, err := Connect(fc1, ConnOpt.Logger(log), ConnOpt.FrameLogging(true))
+	c.Assert(err, IsNil)
+	c.Assert(conn.SendWithReceipt("/queue/a", "text/plain", []byte("hello")), IsNil)
+	conn.SetFrameLogging(false)
+	c.Assert(conn.Send("/queue/b", "text/plain", []byte("hello")), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	<-conn.done
+
+	c.Check(log.entries, DeepEquals, []string{
+		"DEBUG sent SEND frame [content-length:5, destination:/queue/a, content-type:text/plain, receipt:1], 5 byte body command=SEND destination=/queue/a",
+		"DEBUG received RECEIPT frame [receipt-id:1], 0 byte body command=RECEIPT",
+	})
+}
+
+func (s *StompSuite) Test_receipt_timeout(c *C) {
+	defer func(interval time.Duration) { receiptReapInterval = interval }(receiptReapInterval)
+	receiptReapInterval = 5 * time.Millisecond
+
+	fc1, fc2 := testutil.NewFakeConn(c)
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+		// the receipts of the transaction frame and the message are
+		// sent too late
+		var receipts []string
+		for _, command := range []string{frame.BEGIN, frame.SEND, frame.SEND} {
+			f, err := reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, command)
+			if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+				receipts = append(receipts, receipt)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		for _, receipt := range receipts {
+			c.Assert(writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt)), IsNil)
+		}
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+	}()
+
+	log := &testLogger{}
+	conn, err := Connect(fc1, ConnOpt.RcvReceiptTimeout(20*time.Millisecond),
+		ConnOpt.Logger(WithLevel(log, LevelDebug)))
+	c.Assert(err, IsNil)
+	tx, err := conn.BeginWithError()
+	c.Assert(err, IsNil)
+	c.Check(tx.Send("/queue/test", "", nil, SendOpt.Receipt), Equals, ErrReceiptTimeout)
+	c.Check(conn.SendWithReceipt("/queue/test", "", nil), Equals, ErrMsgReceiptTimeout)
+
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+	<-conn.done
+	var ignored int
+	for _, entry := range log.entries {
+		if strings.HasPrefix(entry, "DEBUG ignored RECEIPT") {
+			ignored++
+		}
+	}
+	c.Check(ignored, Equals, 2)
+}
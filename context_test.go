@@ -0,0 +1,96 @@
+package stomp
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestSendWithReceiptContext(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+		// the receipt of the first message is sent late, after the
+		// second message has been received
+		first, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(first.Command, Equals, frame.SEND)
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, first.Header.Get(frame.Receipt))), IsNil)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+	}()
+
+	conn, err := Connect(fc1)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = conn.SendWithReceiptContext(ctx, "/queue/test", "text/plain", []byte("first"))
+	cancel()
+	c.Check(err, Equals, context.DeadlineExceeded)
+
+	// the late receipt does not block the connection
+	err = conn.SendWithReceipt("/queue/test", "text/plain", []byte("second"))
+	c.Check(err, IsNil)
+
+	c.Assert(conn.DisconnectContext(context.Background()), IsNil)
+	<-stop
+}
+
+func (s *StompSuite) TestContextCanceled(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		// never answer the DISCONNECT frame
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := Connect(fc1)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Check(conn.SendContext(ctx, "/queue/test", "", nil), Equals, context.Canceled)
+	_, err = conn.SubscribeContext(ctx, "/queue/test", AckAuto)
+	c.Check(err, Equals, context.Canceled)
+	msg := &Message{
+		Conn:         conn,
+		Subscription: &Subscription{ackMode: AckClient},
+		Header:       frame.NewHeader(frame.Ack, "ack-1"),
+	}
+	c.Check(conn.AckContext(ctx, msg), Equals, context.Canceled)
+	c.Check(conn.NackContext(ctx, msg), Equals, context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c.Check(conn.DisconnectContext(ctx), Equals, context.DeadlineExceeded)
+	c.Check(conn.Send("/queue/test", "", nil), Equals, ErrAlreadyClosed)
+}
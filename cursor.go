@@ -0,0 +1,164 @@
+package stomp
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Header entries used by replayable destinations, where the broker
+// retains messages and assigns each one a sequence number.
+const (
+	// Sequence number of a MESSAGE frame.
+	SequenceHeader = "seq"
+
+	// Sequence number of the first message to deliver, in a SUBSCRIBE
+	// frame. Messages with lower sequence numbers are not delivered.
+	FromSequenceHeader = "from-seq"
+
+	// Time of the first message to deliver, in a SUBSCRIBE frame, in
+	// milliseconds since the Unix epoch. Messages sent to the
+	// destination earlier are not delivered.
+	FromTimeHeader = "from-time"
+)
+
+// A CursorStore persists the sequence numbers of the last messages
+// processed by named cursors.
+type CursorStore interface {
+	// LoadCursor returns the last sequence number saved for the cursor.
+	// If no sequence number has been saved, ok is false.
+	LoadCursor(name string) (seq uint64, ok bool, err error)
+
+	// SaveCursor saves the last sequence number for the cursor.
+	SaveCursor(name string, seq uint64) error
+}
+
+// A Cursor keeps track of the last message processed by a subscription
+// to a replayable destination, so that a new subscription can resume
+// where the previous one left off, for example after reconnecting.
+//
+// Pass the cursor to Conn.Subscribe with SubscribeOpt.Cursor, and call
+// Commit once each message has been processed.
+type Cursor struct {
+	Name  string      // Name of the cursor in the store
+	Store CursorStore // Persists the cursor
+
+	mutex sync.Mutex
+}
+
+// Commit records that the message has been processed. Commit only
+// moves the cursor forward, so committing a message with a lower
+// sequence number than the last one committed has no effect.
+func (c *Cursor) Commit(msg *Message) error {
+	text, ok := msg.Header.Contains(SequenceHeader)
+	if !ok {
+		return missingHeader(SequenceHeader)
+	}
+	seq, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return ErrInvalidFrameFormat
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	last, ok, err := c.Store.LoadCursor(c.Name)
+	if err != nil {
+		return err
+	}
+	if ok && seq <= last {
+		return nil
+	}
+	return c.Store.SaveCursor(c.Name, seq)
+}
+
+// Returns the sequence number of the first message to deliver, or
+// false if no message has been committed.
+func (c *Cursor) next() (uint64, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	last, ok, err := c.Store.LoadCursor(c.Name)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return last + 1, true, nil
+}
+
+// MemoryCursorStore is a CursorStore that keeps cursors in memory,
+// so cursors survive reconnecting but not restarting the program.
+// The zero value is ready to use.
+type MemoryCursorStore struct {
+	mutex   sync.Mutex
+	cursors map[string]uint64
+}
+
+// LoadCursor implements the CursorStore interface.
+func (s *MemoryCursorStore) LoadCursor(name string) (uint64, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seq, ok := s.cursors[name]
+	return seq, ok, nil
+}
+
+// SaveCursor implements the CursorStore interface.
+func (s *MemoryCursorStore) SaveCursor(name string, seq uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cursors == nil {
+		s.cursors = make(map[string]uint64)
+	}
+	s.cursors[name] = seq
+	return nil
+}
+
+// FileCursorStore is a CursorStore that keeps each cursor in a file
+// in a directory, which must already exist.
+type FileCursorStore struct {
+	Dir string
+}
+
+// LoadCursor implements the CursorStore interface.
+func (s FileCursorStore) LoadCursor(name string) (uint64, bool, error) {
+	data, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return seq, true, nil
+}
+
+// SaveCursor implements the CursorStore interface. The file is
+// replaced atomically, so a crash never leaves a partial cursor.
+func (s FileCursorStore) SaveCursor(name string, seq uint64) error {
+	tmp, err := ioutil.TempFile(s.Dir, ".cursor-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strconv.FormatUint(seq, 10) + "\n")
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s FileCursorStore) path(name string) string {
+	return filepath.Join(s.Dir, url.PathEscape(name)+".cursor")
+}
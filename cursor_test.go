@@ -0,0 +1,55 @@
+package stomp
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+func newSequenceMessage(seq string) *Message {
+	return &Message{Header: frame.NewHeader(SequenceHeader, seq)}
+}
+
+func (s *StompSuite) TestCursorCommit(c *C) {
+	cursor := &Cursor{Name: "orders", Store: &MemoryCursorStore{}}
+
+	f := frame.New(frame.SUBSCRIBE)
+	c.Assert(SubscribeOpt.Cursor(cursor)(f), IsNil)
+	_, ok := f.Header.Contains(FromSequenceHeader)
+	c.Check(ok, Equals, false)
+
+	c.Assert(cursor.Commit(newSequenceMessage("41")), IsNil)
+	c.Assert(cursor.Commit(newSequenceMessage("40")), IsNil)
+	c.Check(cursor.Commit(&Message{Header: frame.NewHeader()}), NotNil)
+	c.Check(cursor.Commit(newSequenceMessage("x")), Equals, ErrInvalidFrameFormat)
+
+	f = frame.New(frame.SUBSCRIBE)
+	c.Assert(SubscribeOpt.Cursor(cursor)(f), IsNil)
+	c.Check(f.Header.Get(FromSequenceHeader), Equals, "42")
+
+	c.Check(SubscribeOpt.Cursor(cursor)(frame.New(frame.SEND)), Equals, ErrInvalidCommand)
+}
+
+func (s *StompSuite) TestFileCursorStore(c *C) {
+	dir, err := ioutil.TempDir("", "stomp-cursor")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	store := FileCursorStore{Dir: dir}
+	_, ok, err := store.LoadCursor("a/b")
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+
+	c.Assert(store.SaveCursor("a/b", 7), IsNil)
+	c.Assert(store.SaveCursor("a/b", 8), IsNil)
+	seq, ok, err := FileCursorStore{Dir: dir}.LoadCursor("a/b")
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(seq, Equals, uint64(8))
+
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 1)
+}
@@ -0,0 +1,51 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Header entries that brokers use for durable subscriptions. Each
+// broker ignores the entries meant for the others.
+const (
+	ClientIdHeader                 = "client-id"                 // CONNECT frame, identifies the owner of durable subscriptions
+	DurableHeader                  = "durable"                   // RabbitMQ
+	AutoDeleteHeader               = "auto-delete"               // RabbitMQ
+	ActiveMQSubscriptionNameHeader = "activemq.subscriptionName" // ActiveMQ Classic
+	DurableSubscriptionNameHeader  = "durable-subscription-name" // ActiveMQ Artemis
+)
+
+// setDurableHeader sets the header entries that make a subscription
+// durable under name.
+func setDurableHeader(h *frame.Header, name string) {
+	h.Set(DurableHeader, "true")
+	h.Set(AutoDeleteHeader, "false")
+	h.Set(ActiveMQSubscriptionNameHeader, name)
+	h.Set(DurableSubscriptionNameHeader, name)
+}
+
+// SubscribeDurable creates or resumes the durable subscription with
+// name to the topic destination. A durable subscription keeps the
+// messages sent to the topic while the client is not subscribed, and
+// delivers them when it subscribes again with the same name.
+//
+// The name is also the id of the subscription, so only one
+// subscription with a name can be active on a connection. ActiveMQ
+// and Artemis identify durable subscriptions by the client id as well,
+// which is set with the ClientId connect option. The server of this
+// module does not keep messages for durable subscriptions, and treats
+// them as ordinary subscriptions.
+//
+// Unsubscribe deactivates the subscription, so that the broker keeps
+// its messages; unsubscribing with SubscribeOpt.Durable removes it.
+func (c *Conn) SubscribeDurable(destination, name string, ack AckMode, opts ...func(*frame.Frame) error) (*Subscription, error) {
+	opts = append(opts[:len(opts):len(opts)], SubscribeOpt.Durable(name))
+	return c.Subscribe(destination, ack, opts...)
+}
+
+// SubscribeDurable creates or resumes the durable subscription with
+// name, like Conn.SubscribeDurable, and resumes it with the same name
+// each time the connection is restored.
+func (rc *ReconnectingConn) SubscribeDurable(destination, name string, ack AckMode, opts ...func(*frame.Frame) error) (*ReconnectingSubscription, error) {
+	opts = append(opts[:len(opts):len(opts)], SubscribeOpt.Durable(name))
+	return rc.Subscribe(destination, ack, opts...)
+}
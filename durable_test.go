@@ -0,0 +1,47 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_subscribe_durable(c *C) {
+	frames := make(chan *frame.Frame, 10)
+	conn, err := Connect(serveInterceptor(c, frames), ConnOpt.ClientId("client-1"))
+	c.Assert(err, IsNil)
+	f := <-frames
+	c.Check(f.Command, Equals, frame.CONNECT)
+	c.Check(f.Header.Get(ClientIdHeader), Equals, "client-1")
+
+	sub, err := conn.SubscribeDurable("/topic/test", "audit", AckClient)
+	c.Assert(err, IsNil)
+	c.Check(sub.Id(), Equals, "audit")
+	f = <-frames
+	c.Check(f.Command, Equals, frame.SUBSCRIBE)
+	c.Check(f.Header.Get(frame.Id), Equals, "audit")
+	c.Check(f.Header.Get(DurableHeader), Equals, "true")
+	c.Check(f.Header.Get(AutoDeleteHeader), Equals, "false")
+	c.Check(f.Header.Get(ActiveMQSubscriptionNameHeader), Equals, "audit")
+	c.Check(f.Header.Get(DurableSubscriptionNameHeader), Equals, "audit")
+
+	// unsubscribing with the option removes the durable subscription
+	c.Assert(sub.Unsubscribe(SubscribeOpt.Durable("audit")), IsNil)
+	f = <-frames
+	c.Check(f.Command, Equals, frame.UNSUBSCRIBE)
+	c.Check(f.Header.Get(frame.Id), Equals, "audit")
+	c.Check(f.Header.Get(ActiveMQSubscriptionNameHeader), Equals, "audit")
+
+	_, err = conn.SubscribeDurable("/topic/test", "", AckClient)
+	c.Check(err, Equals, ErrInvalidDurableName)
+	c.Assert(conn.Disconnect(), IsNil)
+}
+
+func (s *StompSuite) Test_reconnecting_subscribe_durable(c *C) {
+	rc := &ReconnectingConn{Network: "tcp", Addr: "127.0.0.1:1"}
+	rs, err := rc.SubscribeDurable("/topic/test", "audit", AckClient)
+	c.Assert(err, IsNil)
+
+	// the name is the id the subscription is replayed with
+	c.Check(rs.Id(), Equals, "audit")
+}
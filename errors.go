@@ -18,9 +18,19 @@ var (
 	ErrAlreadyClosed             = newErrorMessage("connection already closed")
 	ErrMsgSendTimeout            = newErrorMessage("msg send timeout")
 	ErrMsgReceiptTimeout         = newErrorMessage("msg receipt timeout")
+	ErrReceiptTimeout            = newErrorMessage("receipt timeout")
 	ErrDisconnectReceiptTimeout  = newErrorMessage("disconnect receipt timeout")
 	ErrUnsubscribeReceiptTimeout = newErrorMessage("unsubscribe receipt timeout")
 	ErrNilOption                 = newErrorMessage("nil option")
+	ErrNotConnected              = newErrorMessage("not connected")
+	ErrNoBrokers                 = newErrorMessage("no broker addresses")
+	ErrInvalidCapacity           = newErrorMessage("invalid subscription capacity")
+	ErrInvalidOverflowPolicy     = newErrorMessage("invalid overflow policy")
+	ErrInvalidPriority           = newErrorMessage("invalid priority")
+	ErrInvalidChunkSize          = newErrorMessage("invalid chunk size")
+	ErrInvalidSendRate           = newErrorMessage("invalid send rate")
+	ErrInvalidDurableName        = newErrorMessage("invalid durable subscription name")
+	ErrReconnectTimeout          = newErrorMessage("reconnect timeout")
 )
 
 // StompError implements the Error interface, and provides
@@ -0,0 +1,232 @@
+package stomp
+
+import (
+	"crypto/tls"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailoverScheme is the scheme of a failover URI, which lists the
+// addresses of several brokers, for example:
+//
+//	failover:(tcp://broker1:61613,tcp://broker2:61613)?randomize=false
+//
+// The parentheses are optional. The query parameters are "randomize",
+// "initialReconnectDelay" and "maxReconnectDelay", with the delays in
+// milliseconds. Pass a failover URI as the address to stomp.Dial, or
+// parse it with ParseFailover.
+const FailoverScheme = "failover:"
+
+// A Failover dials the first reachable broker in a list of brokers,
+// such as a highly available pair. A broker that cannot be reached is
+// not tried again until a delay chosen by its own Backoff has passed,
+// so a broker that is down does not slow down connecting to the others.
+//
+// The zero value tries the brokers in order with the default delays.
+// A Failover is safe for concurrent use, and is most useful when it is
+// kept for the life of the program, for example as the Failover field
+// of a ReconnectingConn.
+type Failover struct {
+	Network   string        // Network of addresses without a scheme, "tcp" if empty
+	Addrs     []string      // Addresses of the brokers, as "host:port", "tcp://host:port", "ssl://host:port" or "ws://host/path"
+	Randomize bool          // Try the brokers in random order
+	MinDelay  time.Duration // Minimum delay before trying a broker again, DefaultReconnectMinDelay if zero
+	MaxDelay  time.Duration // Maximum delay before trying a broker again, DefaultReconnectMaxDelay if zero
+	TLSConfig *tls.Config   // Configuration for "ssl://" and "stomp+ssl://" addresses, the default if nil
+
+	mutex sync.Mutex
+	hosts map[string]*failoverHost
+	rand  *rand.Rand
+}
+
+// failoverHost is the state of a broker that could not be reached.
+type failoverHost struct {
+	backoff Backoff
+	retryAt time.Time
+}
+
+// ParseFailover parses a failover URI. A plain address, without the
+// "failover:" scheme, is accepted as a list with a single broker.
+// Unlike the zero Failover, brokers are tried in random order unless
+// the URI specifies "randomize=false".
+func ParseFailover(uri string) (*Failover, error) {
+	f := &Failover{Randomize: true}
+	if !strings.HasPrefix(uri, FailoverScheme) {
+		f.Addrs = []string{uri}
+		return f, nil
+	}
+
+	list := strings.TrimPrefix(uri, FailoverScheme)
+	query := ""
+	if strings.HasPrefix(list, "(") {
+		end := strings.Index(list, ")")
+		if end < 0 {
+			return nil, newErrorMessage("invalid failover URI: missing ')': " + uri)
+		}
+		list, query = list[1:end], strings.TrimPrefix(list[end+1:], "?")
+	} else if i := strings.LastIndex(list, "?"); i >= 0 {
+		list, query = list[:i], list[i+1:]
+	}
+
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			f.Addrs = append(f.Addrs, addr)
+		}
+	}
+	if len(f.Addrs) == 0 {
+		return nil, ErrNoBrokers
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, newErrorMessage("invalid failover URI: " + err.Error())
+	}
+	for key := range values {
+		value := values.Get(key)
+		switch key {
+		case "randomize":
+			f.Randomize, err = strconv.ParseBool(value)
+		case "initialReconnectDelay":
+			f.MinDelay, err = parseMillis(value)
+		case "maxReconnectDelay":
+			f.MaxDelay, err = parseMillis(value)
+		default:
+			return nil, newErrorMessage("invalid failover URI: unknown parameter: " + key)
+		}
+		if err != nil {
+			return nil, newErrorMessage("invalid failover URI: " + key + ": " + err.Error())
+		}
+	}
+	return f, nil
+}
+
+func parseMillis(value string) (time.Duration, error) {
+	ms, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Dial connects to the first broker that can be reached, as stomp.Dial
+// does for a single broker. Brokers that recently could not be reached
+// are tried last, once their delay has passed. If no broker can be
+// reached, the error from the last attempt is returned.
+func (f *Failover) Dial(opts ...func(*Conn) error) (*Conn, error) {
+	if len(f.Addrs) == 0 {
+		return nil, ErrNoBrokers
+	}
+
+	var err error
+	for _, addr := range f.order() {
+		if wait := f.retryAt(addr).Sub(time.Now()); wait > 0 {
+			time.Sleep(wait)
+		}
+		network, address, secure := f.split(addr)
+		dialOpts := opts
+		if secure {
+			dialOpts = append([]func(*Conn) error{ConnOpt.TLS(f.TLSConfig)}, opts...)
+		}
+		var conn *Conn
+		conn, err = Dial(network, address, dialOpts...)
+		f.record(addr, err)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// order returns the addresses in the order to try them: the brokers
+// that are not waiting for a delay to pass, in the configured order,
+// followed by the others, the earliest to be ready first.
+func (f *Failover) order() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	addrs := append([]string(nil), f.Addrs...)
+	if f.Randomize {
+		if f.rand == nil {
+			f.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		f.rand.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+	}
+
+	at := func(addr string) time.Time {
+		if h := f.hosts[addr]; h != nil {
+			return h.retryAt
+		}
+		return time.Time{}
+	}
+	now := time.Now()
+	sort.SliceStable(addrs, func(i, j int) bool {
+		ti, tj := at(addrs[i]), at(addrs[j])
+		if !ti.After(now) && !tj.After(now) {
+			return false
+		}
+		return ti.Before(tj)
+	})
+	return addrs
+}
+
+// retryAt returns the time before which addr should not be dialed.
+func (f *Failover) retryAt(addr string) time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if h := f.hosts[addr]; h != nil {
+		return h.retryAt
+	}
+	return time.Time{}
+}
+
+// record updates the delay of addr after an attempt to dial it.
+func (f *Failover) record(addr string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err == nil {
+		delete(f.hosts, addr)
+		return
+	}
+	if f.hosts == nil {
+		f.hosts = make(map[string]*failoverHost)
+	}
+	h := f.hosts[addr]
+	if h == nil {
+		h = &failoverHost{backoff: Backoff{Min: f.MinDelay, Max: f.MaxDelay}}
+		f.hosts[addr] = h
+	}
+	h.retryAt = time.Now().Add(h.backoff.Next())
+}
+
+// split returns the network and address to dial for addr, and
+// whether to use TLS.
+func (f *Failover) split(addr string) (network, address string, secure bool) {
+	network = f.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if isWebSocketURL(addr) {
+		// stomp.Dial dials the whole URL
+		return network, addr, false
+	}
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, addr = addr[:i], addr[i+3:]
+	}
+	switch network {
+	case "ssl", "tls", "stomp+ssl":
+		network, secure = "tcp", true
+	}
+	return network, strings.TrimSuffix(addr, "/"), secure
+}
+
+// String returns the addresses of the brokers, separated by commas.
+func (f *Failover) String() string {
+	return strings.Join(f.Addrs, ",")
+}
@@ -0,0 +1,102 @@
+package stomp
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestParseFailover(c *C) {
+	f, err := ParseFailover("failover:(tcp://a:61613, tcp://b:61613)?randomize=false&initialReconnectDelay=10&maxReconnectDelay=2000")
+	c.Assert(err, IsNil)
+	c.Check(f.Addrs, DeepEquals, []string{"tcp://a:61613", "tcp://b:61613"})
+	c.Check(f.Randomize, Equals, false)
+	c.Check(f.MinDelay, Equals, 10*time.Millisecond)
+	c.Check(f.MaxDelay, Equals, 2*time.Second)
+
+	f, err = ParseFailover("failover:a:61613,b:61613")
+	c.Assert(err, IsNil)
+	c.Check(f.Addrs, DeepEquals, []string{"a:61613", "b:61613"})
+	c.Check(f.Randomize, Equals, true)
+
+	f, err = ParseFailover("a:61613")
+	c.Assert(err, IsNil)
+	c.Check(f.Addrs, DeepEquals, []string{"a:61613"})
+
+	_, err = ParseFailover("failover:()")
+	c.Check(err, Equals, ErrNoBrokers)
+	_, err = ParseFailover("failover:(tcp://a:61613")
+	c.Check(err, ErrorMatches, "invalid failover URI: missing.*")
+	_, err = ParseFailover("failover:(tcp://a:61613)?timeout=1")
+	c.Check(err, ErrorMatches, "invalid failover URI: unknown parameter: timeout")
+	_, err = ParseFailover("failover:(tcp://a:61613)?randomize=maybe")
+	c.Check(err, ErrorMatches, "invalid failover URI: randomize: .*")
+
+	network, address, secure := (&Failover{}).split("tcp4://a:61613/")
+	c.Check(network, Equals, "tcp4")
+	c.Check(address, Equals, "a:61613")
+	c.Check(secure, Equals, false)
+	network, address, secure = (&Failover{Network: "unix"}).split("/tmp/stomp")
+	c.Check(network, Equals, "unix")
+	c.Check(address, Equals, "/tmp/stomp")
+	c.Check(secure, Equals, false)
+	network, address, secure = (&Failover{}).split("stomp+ssl://a:61614")
+	c.Check(network, Equals, "tcp")
+	c.Check(address, Equals, "a:61614")
+	c.Check(secure, Equals, true)
+}
+
+func (s *StompSuite) TestFailoverDial(c *C) {
+	// an address that refuses connections
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	go func() {
+		for {
+			rw, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer rw.Close()
+				reader := frame.NewReader(rw)
+				writer := frame.NewWriter(rw)
+				if _, err := reader.Read(); err != nil {
+					return
+				}
+				writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+				reader.Read()
+			}()
+		}
+	}()
+
+	f := &Failover{
+		Addrs:    []string{"tcp://" + deadAddr, l.Addr().String()},
+		MinDelay: time.Hour,
+		MaxDelay: time.Hour,
+	}
+	conn, err := f.Dial()
+	c.Assert(err, IsNil)
+	conn.MustDisconnect()
+
+	// the dead broker is waiting for its delay, so it is tried last
+	c.Check(f.retryAt("tcp://"+deadAddr).After(time.Now()), Equals, true)
+	c.Check(f.order(), DeepEquals, []string{l.Addr().String(), "tcp://" + deadAddr})
+
+	conn, err = Dial("tcp", "failover:(tcp://"+deadAddr+",tcp://"+l.Addr().String()+")")
+	c.Assert(err, IsNil)
+	conn.MustDisconnect()
+
+	_, err = Dial("tcp", "failover:(tcp://"+deadAddr+")")
+	c.Check(err, NotNil)
+	_, err = (&Failover{}).Dial()
+	c.Check(err, Equals, ErrNoBrokers)
+}
@@ -0,0 +1,29 @@
+package frame
+
+import (
+	"bytes"
+)
+
+// MarshalBinary encodes the frame as it is written to a STOMP 1.2
+// connection, so that it can be kept outside of memory, such as in a
+// database, and decoded again by UnmarshalBinary. As when writing the
+// frame, a body that contains a null byte, or is streamed by
+// BodyReader, requires a content-length header entry.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Write(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a frame encoded by MarshalBinary, replacing
+// the command, header and body of the frame.
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	decoded, err := NewReader(bytes.NewReader(data)).ReadFrame()
+	if err != nil {
+		return err
+	}
+	*f = *decoded
+	return nil
+}
@@ -0,0 +1,29 @@
+package frame
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type BinarySuite struct{}
+
+var _ = Suite(&BinarySuite{})
+
+func (s *BinarySuite) TestRoundTrip(c *C) {
+	f := New(SEND, Destination, "/queue/a", "key", "a:b\nc", "key", "2", ContentLength, "3")
+	f.Body = []byte{0xff, 0, 1}
+	b, err := f.MarshalBinary()
+	c.Assert(err, IsNil)
+
+	var f2 Frame
+	c.Assert(f2.UnmarshalBinary(b), IsNil)
+	c.Check(f2.Command, Equals, SEND)
+	c.Check(f2.Header.GetAll("key"), DeepEquals, []string{"a:b\nc", "2"})
+	c.Check(f2.Body, DeepEquals, []byte{0xff, 0, 1})
+
+	f.Header.Del(ContentLength)
+	_, err = f.MarshalBinary()
+	c.Check(err, Equals, ErrNullInBody)
+
+	c.Check(f2.UnmarshalBinary([]byte("\n")), Equals, ErrHeartBeat)
+	c.Check(f2.UnmarshalBinary([]byte("SEND\n")), NotNil)
+}
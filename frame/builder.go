@@ -0,0 +1,121 @@
+package frame
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrEmptyDestination = errors.New("empty destination")
+	ErrEmptyHeaderKey   = errors.New("empty header key")
+	ErrContentLength    = errors.New("content-length is set from the body")
+)
+
+// A Builder builds a frame one part at a time, as an alternative to
+// New, whose header entries are passed as an even number of strings.
+// The methods of a builder return the builder, so that calls can be
+// chained:
+//
+//	f, err := frame.Build(frame.SEND).
+//		Dest("/queue/a").
+//		Header("priority", "5").
+//		BodyString(`{"id":1}`).
+//		ContentTypeJSON().
+//		Frame()
+//
+// Each part is validated when it is added, and the first error is
+// returned by Frame, after which further parts are ignored.
+type Builder struct {
+	f   *Frame
+	err error
+}
+
+// Build starts building a frame with the specified command, which must
+// be one of the STOMP frame commands.
+func Build(command string) *Builder {
+	b := &Builder{f: New(command)}
+	if _, ok := commandString([]byte(command)); !ok {
+		b.err = ErrInvalidCommand
+	}
+	return b
+}
+
+// Dest sets the "destination" header entry.
+func (b *Builder) Dest(destination string) *Builder {
+	if destination == "" {
+		return b.fail(ErrEmptyDestination)
+	}
+	return b.set(Destination, destination)
+}
+
+// Header adds a header entry. The "content-length" header entry cannot
+// be added, as it is set from the body.
+func (b *Builder) Header(key, value string) *Builder {
+	switch key {
+	case "":
+		return b.fail(ErrEmptyHeaderKey)
+	case ContentLength:
+		return b.fail(ErrContentLength)
+	}
+	if b.err == nil {
+		b.f.Header.Add(key, value)
+	}
+	return b
+}
+
+// Body sets the body, and the "content-length" header entry to its
+// length.
+func (b *Builder) Body(body []byte) *Builder {
+	if b.err == nil {
+		b.f.Body = body
+		b.f.Header.Set(ContentLength, strconv.Itoa(len(body)))
+	}
+	return b
+}
+
+// BodyString sets the body to a string, as Body does.
+func (b *Builder) BodyString(body string) *Builder {
+	return b.Body([]byte(body))
+}
+
+// ContentType sets the "content-type" header entry.
+func (b *Builder) ContentType(contentType string) *Builder {
+	return b.set(ContentType, contentType)
+}
+
+// ContentTypeJSON sets the "content-type" header entry to
+// "application/json".
+func (b *Builder) ContentTypeJSON() *Builder {
+	return b.set(ContentType, JSONContentType)
+}
+
+// ContentTypeText sets the "content-type" header entry to
+// "text/plain; charset=utf-8".
+func (b *Builder) ContentTypeText() *Builder {
+	return b.set(ContentType, TextContentType)
+}
+
+// Frame returns the frame built, or the first error found while
+// building it.
+func (b *Builder) Frame() (*Frame, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.f, nil
+}
+
+// Sets a header entry, replacing an entry with the same key.
+func (b *Builder) set(key, value string) *Builder {
+	if b.err == nil {
+		b.f.Header.Set(key, value)
+	}
+	return b
+}
+
+// Records the first error found.
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
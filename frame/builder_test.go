@@ -0,0 +1,49 @@
+package frame
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type BuilderSuite struct{}
+
+var _ = Suite(&BuilderSuite{})
+
+func (s *BuilderSuite) TestBuild(c *C) {
+	f, err := Build(SEND).
+		Dest("/queue/a").
+		Header("k", "v").
+		BodyString("x").
+		ContentTypeJSON().
+		Frame()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, SEND)
+	c.Check(f.Header.Get(Destination), Equals, "/queue/a")
+	c.Check(f.Header.Get("k"), Equals, "v")
+	c.Check(f.Header.Get(ContentLength), Equals, "1")
+	c.Check(f.Header.Get(ContentType), Equals, "application/json")
+	c.Check(string(f.Body), Equals, "x")
+
+	// setting the body again replaces the content-length
+	f, err = Build(SEND).Dest("/queue/a").BodyString("x").Body([]byte("hello")).Frame()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.GetAll(ContentLength), DeepEquals, []string{"5"})
+	c.Check(string(f.Body), Equals, "hello")
+}
+
+func (s *BuilderSuite) TestInvalid(c *C) {
+	for _, t := range []struct {
+		b   *Builder
+		err error
+	}{
+		{Build("SENT").Dest("/queue/a"), ErrInvalidCommand},
+		{Build(SEND).Dest(""), ErrEmptyDestination},
+		{Build(SEND).Header("", "v"), ErrEmptyHeaderKey},
+		{Build(SEND).Header(ContentLength, "5"), ErrContentLength},
+		// the first error is returned
+		{Build(SEND).Dest("").Header("", "v"), ErrEmptyDestination},
+	} {
+		f, err := t.b.Frame()
+		c.Check(f, IsNil)
+		c.Check(err, Equals, t.err)
+	}
+}
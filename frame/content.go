@@ -0,0 +1,116 @@
+package frame
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Content types set by SetTextBody and SetJSONBody.
+const (
+	TextContentType = "text/plain; charset=utf-8"
+	JSONContentType = "application/json"
+)
+
+// ErrUnsupportedCharset is returned by BodyAsString for a body in a
+// character set that it cannot decode.
+var ErrUnsupportedCharset = errors.New("unsupported charset")
+
+// ContentType returns the media type of the "content-type" header
+// entry, such as "text/plain", and its charset parameter, both in
+// lower case. Both are empty if the header entry is missing, and the
+// charset is empty if the parameter is missing. If the header entry
+// cannot be parsed, its value is returned as the media type.
+func (f *Frame) ContentType() (mediaType, charset string) {
+	if f.Header == nil {
+		return "", ""
+	}
+	contentType := f.Header.Get(ContentType)
+	if contentType == "" {
+		return "", ""
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType, ""
+	}
+	return mediaType, strings.ToLower(params["charset"])
+}
+
+// BodyAsString returns the body decoded from the charset of the
+// "content-type" header entry. The charsets "utf-8", "us-ascii",
+// "iso-8859-1" and "utf-16" with its "utf-16be" and "utf-16le"
+// variants are supported, and the body is taken to be UTF-8 if the
+// charset is missing, as STOMP 1.2 specifies for text types. Returns
+// ErrUnsupportedCharset for any other charset.
+func (f *Frame) BodyAsString() (string, error) {
+	_, charset := f.ContentType()
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(f.Body), nil
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(f.Body))
+		for i, b := range f.Body {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case "utf-16", "utf-16be", "utf-16le":
+		return decodeUTF16(f.Body, charset == "utf-16le"), nil
+	}
+	return "", ErrUnsupportedCharset
+}
+
+// SetTextBody sets the body to s, the "content-type" header entry to
+// "text/plain; charset=utf-8" and the "content-length" header entry to
+// the length of the body.
+func (f *Frame) SetTextBody(s string) {
+	f.setBody([]byte(s), TextContentType)
+}
+
+// SetJSONBody sets the body to the JSON encoding of v, the
+// "content-type" header entry to "application/json" and the
+// "content-length" header entry to the length of the body. The frame
+// is not changed if v cannot be encoded.
+func (f *Frame) SetJSONBody(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f.setBody(body, JSONContentType)
+	return nil
+}
+
+func (f *Frame) setBody(body []byte, contentType string) {
+	if f.Header == nil {
+		f.Header = &Header{}
+	}
+	f.Body = body
+	f.BodyReader = nil
+	f.pooledBody = nil
+	f.Header.Set(ContentType, contentType)
+	f.Header.Set(ContentLength, strconv.Itoa(len(body)))
+}
+
+// Decodes UTF-16, which is big-endian unless little is true or a byte
+// order mark says otherwise. A trailing odd byte is ignored.
+func decodeUTF16(b []byte, little bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xfe && b[1] == 0xff:
+			b, little = b[2:], false
+		case b[0] == 0xff && b[1] == 0xfe:
+			b, little = b[2:], true
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if little {
+			units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+		} else {
+			units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		}
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,66 @@
+package frame
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type ContentSuite struct{}
+
+var _ = Suite(&ContentSuite{})
+
+func (s *ContentSuite) TestContentType(c *C) {
+	f := New(SEND)
+	mediaType, charset := f.ContentType()
+	c.Check(mediaType, Equals, "")
+	c.Check(charset, Equals, "")
+
+	f.Header.Set(ContentType, "Text/Plain; Charset=ISO-8859-1")
+	mediaType, charset = f.ContentType()
+	c.Check(mediaType, Equals, "text/plain")
+	c.Check(charset, Equals, "iso-8859-1")
+}
+
+func (s *ContentSuite) TestBodyAsString(c *C) {
+	for _, t := range []struct {
+		contentType string
+		body        []byte
+		text        string
+	}{
+		{"", []byte("héllo"), "héllo"},
+		{"text/plain;charset=utf-8", []byte("héllo"), "héllo"},
+		{"text/plain;charset=iso-8859-1", []byte("h\xe9llo"), "héllo"},
+		{"text/plain;charset=utf-16", []byte("\x00h\x00\xe9"), "hé"},
+		{"text/plain;charset=utf-16", []byte("\xff\xfeh\x00\xe9\x00"), "hé"},
+		{"text/plain;charset=utf-16le", []byte("h\x00\xe9\x00"), "hé"},
+	} {
+		f := New(SEND, ContentType, t.contentType)
+		f.Body = t.body
+		text, err := f.BodyAsString()
+		c.Check(err, IsNil)
+		c.Check(text, Equals, t.text, Commentf("%s", t.contentType))
+	}
+
+	f := New(SEND, ContentType, "text/plain;charset=koi8-r")
+	_, err := f.BodyAsString()
+	c.Check(err, Equals, ErrUnsupportedCharset)
+}
+
+func (s *ContentSuite) TestSetBody(c *C) {
+	f := &Frame{Command: SEND}
+	f.SetTextBody("héllo")
+	c.Check(f.Header.Get(ContentType), Equals, TextContentType)
+	c.Check(f.Header.Get(ContentLength), Equals, "6")
+	text, err := f.BodyAsString()
+	c.Check(err, IsNil)
+	c.Check(text, Equals, "héllo")
+
+	c.Assert(f.SetJSONBody(map[string]int{"a": 1}), IsNil)
+	c.Check(f.Header.Get(ContentType), Equals, JSONContentType)
+	c.Check(f.Header.Get(ContentLength), Equals, "7")
+	c.Check(f.Header.Len(), Equals, 2)
+	c.Check(string(f.Body), Equals, `{"a":1}`)
+
+	// the frame is unchanged if the value cannot be encoded
+	c.Check(f.SetJSONBody(make(chan int)), NotNil)
+	c.Check(string(f.Body), Equals, `{"a":1}`)
+}
@@ -1,8 +1,8 @@
 package frame
 
 import (
+	"bytes"
 	"strings"
-	"unsafe"
 )
 
 var (
@@ -12,6 +12,12 @@ var (
 		"\n", "\\n",
 		":", "\\c",
 	)
+	// STOMP 1.1 does not define an escape sequence for carriage return
+	replacerForEncodeValue11 = strings.NewReplacer(
+		"\\", "\\\\",
+		"\n", "\\n",
+		":", "\\c",
+	)
 	replacerForUnencodeValue = strings.NewReplacer(
 		"\\r", "\r",
 		"\\n", "\n",
@@ -20,15 +26,13 @@ var (
 	)
 )
 
-// Reduce one allocation on copying bytes to string
-func bytesToString(b []byte) string {
-	/* #nosec G103 */
-	return *(*string)(unsafe.Pointer(&b))
-}
-
-// Unencodes a header value using STOMP value encoding
+// Unencodes a header value using STOMP value encoding. The value is
+// copied, so b can be reused afterwards.
 // TODO: return error if invalid sequences found (eg "\t")
 func unencodeValue(b []byte) (string, error) {
-	s := replacerForUnencodeValue.Replace(bytesToString(b))
+	if bytes.IndexByte(b, '\\') < 0 {
+		return string(b), nil
+	}
+	s := replacerForUnencodeValue.Replace(string(b))
 	return s, nil
 }
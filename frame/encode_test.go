@@ -13,3 +13,13 @@ func (s *EncodeSuite) TestUnencodeValue(c *C) {
 	c.Check(err, IsNil)
 	c.Check(val, Equals, "Contains\r\nNewLine and : colon and \\ backslash")
 }
+
+func (s *EncodeSuite) TestUnencodeValueCopies(c *C) {
+	for _, text := range []string{"plain value", `invalid \t escape`} {
+		b := []byte(text)
+		val, err := unencodeValue(b)
+		c.Check(err, IsNil)
+		copy(b, "xxxxxxxxxx")
+		c.Check(val, Equals, text)
+	}
+}
@@ -4,6 +4,90 @@ import (
 	"errors"
 )
 
+// An ErrorCode classifies a ProtocolError.
+type ErrorCode int
+
+const (
+	CodeInvalidCommand ErrorCode = iota + 1 // The command is not a STOMP command
+	CodeInvalidFrame                        // The frame is malformed
+	CodeInvalidHeader                       // A header entry has an invalid value
+	CodeMissingHeader                       // A required header entry is missing
+	CodeTooLarge                            // The frame, or a part of it, exceeds a limit
+	CodeUnexpectedBody                      // The frame has a body that it must not have
+)
+
+// A ProtocolError is returned for a frame that breaks the STOMP
+// protocol, when it is read or validated. The errors of this package
+// that are protocol errors, such as ErrInvalidCommand, are of this
+// type, and errors.Is reports whether an error is one of them, even
+// if it describes the frame in more detail. Use errors.As to get the
+// code, and branch on the category of the error:
+//
+//	var pe *frame.ProtocolError
+//	if errors.As(err, &pe) && pe.Code == frame.CodeTooLarge {
+//		...
+//	}
+type ProtocolError struct {
+	Code    ErrorCode
+	Message string // Describes the error, and is returned by Error
+	Command string // Command of the frame, if known
+	Header  string // Key of the offending header entry, if any
+	Err     error  // Underlying error, if any
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a ProtocolError with the same code and
+// message, and with the same command and header key if target has
+// them, so that an error describing a frame matches the error value
+// that it was made from.
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code && t.Message == e.Message &&
+		(t.Command == "" || t.Command == e.Command) &&
+		(t.Header == "" || t.Header == e.Header)
+}
+
+// Returns a copy of the error for a frame with the specified command.
+func (e *ProtocolError) withCommand(command string) *ProtocolError {
+	c := *e
+	c.Command = command
+	return &c
+}
+
 var (
-	ErrInvalidHeartBeat = errors.New("invalid heart-beat")
+	ErrInvalidCommand        = &ProtocolError{Code: CodeInvalidCommand, Message: "invalid command"}
+	ErrInvalidFrameFormat    = &ProtocolError{Code: CodeInvalidFrame, Message: "invalid frame format"}
+	ErrInvalidHeartBeat      = &ProtocolError{Code: CodeInvalidHeader, Message: "invalid heart-beat", Header: HeartBeat}
+	ErrInvalidContentLength  = &ProtocolError{Code: CodeInvalidHeader, Message: "invalid content-length", Header: ContentLength}
+	ErrBodyTooLarge          = &ProtocolError{Code: CodeTooLarge, Message: "frame body too large"}
+	ErrHeaderTooLarge        = &ProtocolError{Code: CodeTooLarge, Message: "frame header too large"}
+	ErrTooManyHeaders        = &ProtocolError{Code: CodeTooLarge, Message: "too many frame header entries"}
+	ErrHeaderLineTooLong     = &ProtocolError{Code: CodeTooLarge, Message: "frame header line too long"}
+	ErrUnexpectedBody        = &ProtocolError{Code: CodeUnexpectedBody, Message: "frame must not have a body"}
+	ErrContentLengthMismatch = &ProtocolError{Code: CodeInvalidHeader, Message: "content-length does not match body", Header: ContentLength}
+
+	// ErrHeartBeat is returned by ReadFrame when a heart-beat is read
+	// instead of a frame. It is not a protocol error.
+	ErrHeartBeat = errors.New("heart-beat")
 )
+
+// Returns the error for a frame without a required header entry.
+func missingHeader(command, key string) *ProtocolError {
+	return &ProtocolError{
+		Code:    CodeMissingHeader,
+		Message: "missing header: " + key,
+		Command: command,
+		Header:  key,
+	}
+}
@@ -3,6 +3,16 @@ Package frame provides functionality for manipulating STOMP frames.
 */
 package frame
 
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Bytes of the body rendered by String.
+const stringMaxBody = 256
+
 // A Frame represents a STOMP frame. A frame consists of a command
 // followed by a collection of header entries, and then an optional
 // body.
@@ -10,6 +20,15 @@ type Frame struct {
 	Command string
 	Header  *Header
 	Body    []byte
+
+	// If not nil, the body is streamed from BodyReader instead of
+	// being held in Body, so that a large body need not be held in
+	// memory. The length of the body is given by the "content-length"
+	// header entry. See Reader.SetStreamThreshold and Writer.Write.
+	BodyReader io.Reader
+
+	// the buffer of Body, if it was taken from the body pool
+	pooledBody *[]byte
 }
 
 // New creates a new STOMP frame with the specified command and headers.
@@ -23,10 +42,18 @@ func New(command string, headers ...string) *Frame {
 	return f
 }
 
-// Clone creates a deep copy of the frame and its header. The cloned
-// frame shares the body with the original frame.
+// Clone creates a deep copy of the frame: the command, the header and
+// the body are copied, so the copy shares nothing with the original
+// frame, and either can be modified, or passed to another goroutine,
+// without affecting the other. Frames that are sent to several clients,
+// such as messages sent to a topic, are cloned for each client, as the
+// server sets header entries such as "message-id" for each client.
+//
+// A body streamed by BodyReader cannot be copied without reading it, so
+// the copy shares the BodyReader, and only one of the frames can be
+// written.
 func (f *Frame) Clone() *Frame {
-	fc := &Frame{Command: f.Command}
+	fc := &Frame{Command: f.Command, BodyReader: f.BodyReader}
 	if f.Header != nil {
 		fc.Header = f.Header.Clone()
 	}
@@ -36,3 +63,63 @@ func (f *Frame) Clone() *Frame {
 	}
 	return fc
 }
+
+// Size returns the approximate number of bytes of memory held by the
+// frame: the lengths of its command, header keys and values, and body.
+// A body streamed by BodyReader is not counted.
+func (f *Frame) Size() int {
+	n := len(f.Command) + len(f.Body)
+	if f.Header != nil {
+		f.Header.Range(func(key, value string) bool {
+			n += len(key) + len(value)
+			return true
+		})
+	}
+	return n
+}
+
+// Dump renders the frame for logs and error messages: the command, and
+// the header entries escaped as in a STOMP 1.2 frame, one per line,
+// followed by an empty line and the body quoted as a Go string literal,
+// so that the text is printable whatever the body holds. Only the first
+// maxBody bytes of the body are rendered, followed by the number of
+// bytes left out, and the body is left out if maxBody is zero or less.
+// The value of a "passcode" header entry is hidden, and a body streamed
+// by BodyReader is not read.
+func (f *Frame) Dump(maxBody int) string {
+	var b strings.Builder
+	b.WriteString(f.Command)
+	b.WriteByte(newline)
+	if f.Header != nil {
+		f.Header.Range(func(key, value string) bool {
+			if key == Passcode {
+				value = "***"
+			}
+			replacerForEncodeValue.WriteString(&b, key)
+			b.WriteByte(colon)
+			replacerForEncodeValue.WriteString(&b, value)
+			b.WriteByte(newline)
+			return true
+		})
+	}
+	b.WriteByte(newline)
+	switch {
+	case f.BodyReader != nil:
+		b.WriteString("(streamed body)")
+	case len(f.Body) == 0:
+	case maxBody <= 0:
+		fmt.Fprintf(&b, "(%d bytes)", len(f.Body))
+	case len(f.Body) > maxBody:
+		b.WriteString(strconv.Quote(string(f.Body[:maxBody])))
+		fmt.Fprintf(&b, "... (%d more bytes)", len(f.Body)-maxBody)
+	default:
+		b.WriteString(strconv.Quote(string(f.Body)))
+	}
+	return b.String()
+}
+
+// String renders the frame as Dump does, with up to 256 bytes of the
+// body.
+func (f *Frame) String() string {
+	return f.Dump(stringMaxBody)
+}
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -65,3 +66,46 @@ func (s *FrameSuite) TestClone(c *C) {
 		c.Check(f1.Body[i], Equals, f2.Body[i])
 	}
 }
+
+func (s *FrameSuite) TestCloneIsIndependent(c *C) {
+	f1 := New(MESSAGE, Destination, "/topic/a", MessageId, "1")
+	f1.Body = []byte("hello")
+	f2 := f1.Clone()
+
+	f2.Header.Set(MessageId, "2")
+	f2.Header.Add(Subscription, "sub-1")
+	f2.Body[0] = 'j'
+	c.Check(f1.Header.Get(MessageId), Equals, "1")
+	c.Check(f1.Header.Len(), Equals, 2)
+	c.Check(string(f1.Body), Equals, "hello")
+
+	f1.Header.Set(Destination, "/topic/b")
+	c.Check(f2.Header.Get(Destination), Equals, "/topic/a")
+	c.Check(string(f2.Body), Equals, "jello")
+}
+
+func (s *FrameSuite) TestSize(c *C) {
+	c.Check((&Frame{Command: SEND}).Size(), Equals, 4)
+	f := New(SEND, "destination", "/queue/a")
+	f.Body = []byte("hello")
+	c.Check(f.Size(), Equals, 4+11+8+5)
+}
+
+func (s *FrameSuite) TestDump(c *C) {
+	f := New(CONNECT, Login, "guest", Passcode, "secret")
+	c.Check(f.String(), Equals, "CONNECT\nlogin:guest\npasscode:***\n\n")
+
+	f = New(SEND, Destination, "/queue/a", "key", "a:b\nc")
+	f.Body = []byte("hello\x00\"world\"")
+	c.Check(f.String(), Equals,
+		"SEND\ndestination:/queue/a\nkey:a\\cb\\nc\n\n\"hello\\x00\\\"world\\\"\"")
+	c.Check(f.Dump(5), Equals,
+		"SEND\ndestination:/queue/a\nkey:a\\cb\\nc\n\n\"hello\"... (8 more bytes)")
+	c.Check(f.Dump(0), Equals,
+		"SEND\ndestination:/queue/a\nkey:a\\cb\\nc\n\n(13 bytes)")
+
+	f.Body = nil
+	f.BodyReader = strings.NewReader("hello")
+	c.Check(f.Dump(5), Equals,
+		"SEND\ndestination:/queue/a\nkey:a\\cb\\nc\n\n(streamed body)")
+}
@@ -2,6 +2,7 @@ package frame
 
 import (
 	"strconv"
+	"time"
 )
 
 // STOMP header names. Some of the header
@@ -31,6 +32,20 @@ const (
 	Message       = "message"
 )
 
+// Header names that are not defined by the STOMP standard,
+// but are widely supported by brokers.
+const (
+	Expires     = "expires"     // time the message expires, milliseconds since the Unix epoch
+	Persistent  = "persistent"  // "true" if the message should be kept in durable storage
+	Redelivered = "redelivered" // "true" if the message might have been delivered before
+	Priority    = "priority"    // priority of the message, higher values first
+	Selector    = "selector"    // SQL-92 expression that filters the messages of a subscription
+
+	// Optional features of the server, in CONNECTED frames, see
+	// stomp.Capabilities
+	Capabilities = "capabilities"
+)
+
 // A Header represents the header part of a STOMP frame.
 // The header in a STOMP frame consists of a list of header entries.
 // Each header entry is a key/value pair of strings.
@@ -38,10 +53,13 @@ const (
 // Normally a STOMP header only has one header entry for a given key, but
 // the STOMP standard does allow for multiple header entries with the same
 // key. In this case, the first header entry contains the value, and any
-// subsequent header entries with the same key are ignored.
+// subsequent header entries with the same key are ignored by Get and
+// Contains. The entries keep their order, and repeated entries are
+// kept, so that a frame passes through a server or client unchanged.
+// Use GetAll or Range to see every entry.
 //
 // Example header containing 6 header entries. Note that the second
-// header entry with the key "comment" would be ignored.
+// header entry with the key "comment" would be ignored by Get.
 //
 //	login:scott
 //	passcode:tiger
@@ -82,9 +100,10 @@ func (h *Header) AddHeader(header *Header) {
 	}
 }
 
-// Set replaces the value of any existing header entry with the specified key.
-// If there is no existing header entry with the specified key, a new
-// header entry is added.
+// Set replaces the value of the first header entry with the specified
+// key, which is the value that is significant, keeping its position.
+// Any repeated entries with the key are kept. If there is no existing
+// header entry with the specified key, a new header entry is added.
 func (h *Header) Set(key, value string) {
 	if i, ok := h.index(key); ok {
 		h.slice[i+1] = value
@@ -121,6 +140,16 @@ func (h *Header) GetAt(index int) (key, value string) {
 	return h.slice[index], h.slice[index+1]
 }
 
+// Range calls fn for each header entry in order, including repeated
+// entries, until fn returns false.
+func (h *Header) Range(fn func(key, value string) bool) {
+	for i := 0; i < len(h.slice); i += 2 {
+		if !fn(h.slice[i], h.slice[i+1]) {
+			return
+		}
+	}
+}
+
 // Contains gets the first value associated with the given key,
 // and also returns a bool indicating whether the header entry
 // exists.
@@ -174,6 +203,28 @@ func (h *Header) ContentLength() (value int, ok bool, err error) {
 	return value, ok, nil
 }
 
+// Expires returns the time of the "expires" header entry. If the
+// "expires" header is missing or zero, then ok is false, meaning
+// that the message does not expire. If the "expires" entry is present
+// but is not a valid integer then err is non-nil.
+func (h *Header) Expires() (value time.Time, ok bool, err error) {
+	text, ok := h.Contains(Expires)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	ms, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if ms == 0 {
+		return time.Time{}, false, nil
+	}
+
+	value = time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+	return value, true, nil
+}
+
 // Returns the index of a header key in Headers, and a bool to indicate
 // whether it was found or not.
 func (h *Header) index(key string) (int, bool) {
@@ -1,6 +1,9 @@
 package frame
 
 import (
+	"bytes"
+	"time"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -17,6 +20,34 @@ func (s *FrameSuite) TestHeaderGetSetAddDel(c *C) {
 	c.Assert(h.Get("xxx"), Equals, "")
 }
 
+func (s *FrameSuite) TestHeaderRange(c *C) {
+	h := NewHeader("xxx", "1", "yyy", "2", "xxx", "3")
+	h.Set("xxx", "4")
+	var entries []string
+	h.Range(func(key, value string) bool {
+		entries = append(entries, key+":"+value)
+		return true
+	})
+	c.Check(entries, DeepEquals, []string{"xxx:4", "yyy:2", "xxx:3"})
+
+	entries = nil
+	h.Range(func(key, value string) bool {
+		entries = append(entries, key+":"+value)
+		return false
+	})
+	c.Check(entries, DeepEquals, []string{"xxx:4"})
+
+	c.Check(h.GetAll("xxx"), DeepEquals, []string{"4", "3"})
+	c.Check(h.GetAll("zzz"), IsNil)
+
+	// order and repeated entries survive encoding
+	var buf bytes.Buffer
+	c.Assert(NewWriter(&buf).Write(&Frame{Command: SEND, Header: h}), IsNil)
+	f, err := NewReader(&buf).Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header, DeepEquals, h)
+}
+
 func (s *FrameSuite) TestHeaderClone(c *C) {
 	h := Header{}
 	h.Set("xxx", "yyy")
@@ -67,3 +98,26 @@ func (s *FrameSuite) TestLit(c *C) {
 		Body:    []byte{1, 2, 3, 4},
 	}
 }
+
+func (s *FrameSuite) TestHeaderExpires(c *C) {
+	h := NewHeader()
+	_, ok, err := h.Expires()
+	c.Check(ok, Equals, false)
+	c.Check(err, IsNil)
+
+	h.Set(Expires, "0")
+	_, ok, err = h.Expires()
+	c.Check(ok, Equals, false)
+	c.Check(err, IsNil)
+
+	h.Set(Expires, "1500000000123")
+	value, ok, err := h.Expires()
+	c.Check(ok, Equals, true)
+	c.Check(err, IsNil)
+	c.Check(value.Equal(time.Unix(1500000000, 123000000)), Equals, true)
+
+	h.Set(Expires, "tomorrow")
+	_, ok, err = h.Expires()
+	c.Check(ok, Equals, true)
+	c.Check(err, NotNil)
+}
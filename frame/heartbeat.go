@@ -42,3 +42,29 @@ func ParseHeartBeat(heartBeat string) (time.Duration, time.Duration, error) {
 	return time.Duration(value1) * time.Millisecond,
 		time.Duration(value2) * time.Millisecond, nil
 }
+
+// NegotiateHeartBeat applies the rules of the STOMP specification to
+// the heart-beat header entries "cx,cy" of a CONNECT frame and "sx,sy"
+// of the CONNECTED frame that answers it, where cx and sx are the
+// intervals at which the client and the server can send heart-beats,
+// and cy and sy the intervals at which they want to receive them. It
+// returns the intervals at which the client must send heart-beats to
+// the server, and the server to the client, which are zero if none are
+// sent, and otherwise the longer of what one side can send and what
+// the other wants to receive.
+func NegotiateHeartBeat(clientCx, clientCy, serverCx, serverCy time.Duration) (clientToServer, serverToClient time.Duration) {
+	return negotiateHeartBeat(clientCx, serverCy), negotiateHeartBeat(serverCx, clientCy)
+}
+
+// Returns the interval of heart-beats sent by one side that can send
+// them every send and received by the other side that wants them every
+// receive.
+func negotiateHeartBeat(send, receive time.Duration) time.Duration {
+	if send == 0 || receive == 0 {
+		return 0
+	}
+	if send > receive {
+		return send
+	}
+	return receive
+}
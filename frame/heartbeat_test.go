@@ -75,3 +75,24 @@ func (s *FrameSuite) TestParseHeartBeat(c *C) {
 		}
 	}
 }
+
+func (s *FrameSuite) TestNegotiateHeartBeat(c *C) {
+	ms := time.Millisecond
+	for _, t := range []struct {
+		cx, cy, sx, sy time.Duration
+		toServer       time.Duration
+		toClient       time.Duration
+	}{
+		{0, 0, 0, 0, 0, 0},
+		{100 * ms, 200 * ms, 0, 0, 0, 0},
+		{0, 0, 100 * ms, 200 * ms, 0, 0},
+		{100 * ms, 200 * ms, 300 * ms, 50 * ms, 100 * ms, 300 * ms},
+		{100 * ms, 200 * ms, 50 * ms, 300 * ms, 300 * ms, 200 * ms},
+		{100 * ms, 0, 50 * ms, 300 * ms, 300 * ms, 0},
+		{0, 200 * ms, 50 * ms, 300 * ms, 0, 200 * ms},
+	} {
+		toServer, toClient := NegotiateHeartBeat(t.cx, t.cy, t.sx, t.sy)
+		c.Check(toServer, Equals, t.toServer, Commentf("%v", t))
+		c.Check(toClient, Equals, t.toClient, Commentf("%v", t))
+	}
+}
@@ -0,0 +1,84 @@
+package frame
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrStreamedBody is returned when marshaling a frame whose body is
+// streamed by BodyReader, which would have to be read to be marshaled.
+var ErrStreamedBody = errors.New("frame body is streamed")
+
+// The JSON representation of a frame.
+type jsonFrame struct {
+	Command    string      `json:"command"`
+	Header     [][2]string `json:"header"`                // Header entries in order, as key and value pairs
+	Body       *string     `json:"body,omitempty"`        // Body, if it is text
+	BodyBase64 []byte      `json:"body_base64,omitempty"` // Body, if it is not text
+}
+
+// MarshalJSON encodes the frame as a JSON object with the command, the
+// header entries in order as an array of key and value pairs, including
+// repeated keys, and the body. The body is a string in the "body" field
+// if the "content-type" header entry names a text type, such as
+// "text/plain" or "application/json", or is missing, and the body is
+// valid UTF-8. Otherwise it is encoded in base64 in the "body_base64"
+// field. Returns ErrStreamedBody if the body is streamed by BodyReader.
+func (f *Frame) MarshalJSON() ([]byte, error) {
+	if f.BodyReader != nil {
+		return nil, ErrStreamedBody
+	}
+	jf := jsonFrame{Command: f.Command, Header: [][2]string{}}
+	if f.Header != nil {
+		f.Header.Range(func(key, value string) bool {
+			jf.Header = append(jf.Header, [2]string{key, value})
+			return true
+		})
+	}
+	if len(f.Body) > 0 {
+		if f.isText() {
+			body := string(f.Body)
+			jf.Body = &body
+		} else {
+			jf.BodyBase64 = f.Body
+		}
+	}
+	return json.Marshal(jf)
+}
+
+// UnmarshalJSON decodes a frame encoded by MarshalJSON, replacing the
+// command, header and body of the frame.
+func (f *Frame) UnmarshalJSON(data []byte) error {
+	var jf jsonFrame
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return err
+	}
+	*f = Frame{Command: jf.Command, Header: &Header{}}
+	for _, entry := range jf.Header {
+		f.Header.Add(entry[0], entry[1])
+	}
+	switch {
+	case jf.Body != nil:
+		f.Body = []byte(*jf.Body)
+	case jf.BodyBase64 != nil:
+		f.Body = jf.BodyBase64
+	}
+	return nil
+}
+
+// Reports whether the body of the frame is text, according to its
+// "content-type" header entry, and is valid UTF-8.
+func (f *Frame) isText() bool {
+	switch mediaType, charset := f.ContentType(); {
+	case charset != "" && charset != "utf-8" && charset != "us-ascii":
+		return false
+	case mediaType == "",
+		strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"):
+		return utf8.Valid(f.Body)
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package frame
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type JSONSuite struct{}
+
+var _ = Suite(&JSONSuite{})
+
+func (s *JSONSuite) TestText(c *C) {
+	f := New(SEND, Destination, "/queue/a", "key", "1", "key", "2",
+		ContentType, "application/json; charset=utf-8")
+	f.Body = []byte(`{"a":1}`)
+	b, err := json.Marshal(f)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `{"command":"SEND","header":[["destination","/queue/a"],`+
+		`["key","1"],["key","2"],["content-type","application/json; charset=utf-8"]],`+
+		`"body":"{\"a\":1}"}`)
+
+	var f2 Frame
+	c.Assert(json.Unmarshal(b, &f2), IsNil)
+	c.Check(f2.Command, Equals, SEND)
+	c.Check(f2.Header.GetAll("key"), DeepEquals, []string{"1", "2"})
+	c.Check(f2.Header.Len(), Equals, 4)
+	c.Check(string(f2.Body), Equals, `{"a":1}`)
+
+	// a body without a content-type is text if it is valid UTF-8
+	f = &Frame{Command: MESSAGE, Body: []byte("héllo")}
+	b, err = json.Marshal(f)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `{"command":"MESSAGE","header":[],"body":"héllo"}`)
+}
+
+func (s *JSONSuite) TestBinary(c *C) {
+	for _, f := range []*Frame{
+		New(SEND, ContentType, "application/octet-stream"),
+		New(SEND, ContentType, "text/plain"),
+		New(SEND),
+	} {
+		f.Body = []byte{0xff, 0, 1}
+		b, err := json.Marshal(f)
+		c.Assert(err, IsNil)
+		c.Check(strings.Contains(string(b), `"body_base64":"/wAB"`), Equals, true, Commentf("%s", b))
+
+		var f2 Frame
+		c.Assert(json.Unmarshal(b, &f2), IsNil)
+		c.Check(f2.Body, DeepEquals, []byte{0xff, 0, 1})
+	}
+}
+
+func (s *JSONSuite) TestStreamed(c *C) {
+	f := New(SEND)
+	f.BodyReader = strings.NewReader("hello")
+	_, err := json.Marshal(f)
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,104 @@
+package frame
+
+import (
+	"sync"
+)
+
+// Header entries of a released frame beyond this many are not kept
+// for reuse, so that one large frame does not hold on to memory.
+const maxPooledHeaderEntries = 32
+
+// Frames that have been released and can be reused.
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return &Frame{Header: &Header{}}
+	},
+}
+
+// Acquire returns a frame with the specified command and headers, as
+// New does, reusing the storage of a frame that has been released if
+// one is available. Frames read by a Reader are acquired.
+//
+// The caller owns the frame, and may call Release once neither it nor
+// anything that it has passed the frame to refers to the frame or its
+// header. Once a frame is passed to code that does not promise to
+// release it, such as a queue, it must not be released, and it can
+// be left to the garbage collector instead.
+func Acquire(command string, headers ...string) *Frame {
+	f := framePool.Get().(*Frame)
+	f.Command = command
+	for index := 0; index < len(headers); index += 2 {
+		f.Header.Add(headers[index], headers[index+1])
+	}
+	return f
+}
+
+// Release returns a frame to be reused by Acquire. Neither the frame
+// nor its header may be used afterwards. The body is not reused, as
+// it might be shared with other frames, unless it was read by a Reader
+// with the PooledBodies option, in which case it may not be used
+// afterwards either.
+func (f *Frame) Release() {
+	if f.pooledBody != nil {
+		putBody(f.pooledBody)
+	}
+	h := f.Header
+	if h == nil || cap(h.slice) > 2*maxPooledHeaderEntries {
+		h = &Header{}
+	} else {
+		for i := range h.slice {
+			h.slice[i] = ""
+		}
+		h.slice = h.slice[:0]
+	}
+	*f = Frame{Header: h}
+	framePool.Put(f)
+}
+
+// Bodies of frames read with the PooledBodies option are taken from
+// pools of buffers whose capacities are powers of two, from
+// 1<<minPooledBodyBits to 1<<maxPooledBodyBits bytes. Larger bodies
+// are allocated for each frame, so that one large frame does not hold
+// on to memory.
+const (
+	minPooledBodyBits = 6
+	maxPooledBodyBits = 20
+)
+
+var bodyPools [maxPooledBodyBits - minPooledBodyBits + 1]sync.Pool
+
+// Returns the index of the pool of buffers that can hold size bytes,
+// or -1 if there is none.
+func bodyPoolIndex(size int) int {
+	bits := minPooledBodyBits
+	for 1<<uint(bits) < size {
+		bits++
+		if bits > maxPooledBodyBits {
+			return -1
+		}
+	}
+	return bits - minPooledBodyBits
+}
+
+// Returns a buffer of the specified length, from a pool if possible.
+func getBody(size int) *[]byte {
+	i := bodyPoolIndex(size)
+	if i < 0 {
+		b := make([]byte, size)
+		return &b
+	}
+	if p, ok := bodyPools[i].Get().(*[]byte); ok {
+		*p = (*p)[:size]
+		return p
+	}
+	b := make([]byte, size, 1<<uint(i+minPooledBodyBits))
+	return &b
+}
+
+// Returns a buffer to its pool, if it has one.
+func putBody(p *[]byte) {
+	c := cap(*p)
+	if i := bodyPoolIndex(c); i >= 0 && c == 1<<uint(i+minPooledBodyBits) {
+		bodyPools[i].Put(p)
+	}
+}
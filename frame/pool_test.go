@@ -0,0 +1,81 @@
+package frame
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PoolSuite struct{}
+
+var _ = Suite(&PoolSuite{})
+
+func (s *PoolSuite) TestAcquireRelease(c *C) {
+	f := Acquire(SEND, Destination, "/queue/a", ContentType, "text/plain")
+	f.Body = []byte("body")
+	c.Check(f.Command, Equals, SEND)
+	c.Check(f.Header.Len(), Equals, 2)
+	c.Check(f.Header.Get(Destination), Equals, "/queue/a")
+	f.Release()
+	c.Check(f.Command, Equals, "")
+	c.Check(f.Header.Len(), Equals, 0)
+	c.Check(f.Body, IsNil)
+
+	// an acquired frame never has entries of a released frame
+	for i := 0; i < 10; i++ {
+		f = Acquire(ACK, Id, "1")
+		c.Check(f.Header.Len(), Equals, 1)
+		c.Check(f.Body, IsNil)
+		f.Release()
+	}
+
+	// a frame with many header entries is not kept whole
+	f = Acquire(SEND)
+	for i := 0; i < 2*maxPooledHeaderEntries; i++ {
+		f.Header.Add("key", "value")
+	}
+	h := f.Header
+	f.Release()
+	c.Check(f.Header, Not(Equals), h)
+}
+
+func (s *PoolSuite) TestPooledBodies(c *C) {
+	text := "SEND\ncontent-length:5\n\nhello\x00SEND\n\nworld\x00"
+	reader := NewReader(strings.NewReader(text+text), PooledBodies())
+	for i := 0; i < 4; i++ {
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(len(f.Body), Equals, 5)
+		c.Check(cap(f.Body), Equals, 1<<minPooledBodyBits)
+		c.Check(f.pooledBody, NotNil)
+		f.Release()
+		c.Check(f.Body, IsNil)
+		c.Check(f.pooledBody, IsNil)
+	}
+
+	// a body too large for the pools is allocated as usual
+	p := getBody(1<<maxPooledBodyBits + 1)
+	c.Check(len(*p), Equals, 1<<maxPooledBodyBits+1)
+	c.Check(bodyPoolIndex(1<<maxPooledBodyBits), Equals, maxPooledBodyBits-minPooledBodyBits)
+	c.Check(bodyPoolIndex(1<<maxPooledBodyBits+1), Equals, -1)
+}
+
+func (s *PoolSuite) TestBodyBuffer(c *C) {
+	buf := make([]byte, 64)
+	sizes := []int{}
+	reader := NewReader(strings.NewReader("SEND\ncontent-length:5\n\nhello\x00SEND\n\nhi\x00"),
+		BodyBuffer(func(size int) []byte {
+			sizes = append(sizes, size)
+			return buf[:size]
+		}))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "hello")
+	c.Check(&f.Body[0], Equals, &buf[0])
+	f.Release()
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "hi")
+	c.Check(sizes, DeepEquals, []int{5, 2})
+	c.Check(string(buf[:5]), Equals, "hillo")
+}
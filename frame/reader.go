@@ -3,8 +3,11 @@ package frame
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"io"
+	"io/ioutil"
+	"time"
 )
 
 const (
@@ -15,68 +18,307 @@ const (
 	nullByte   = byte(0)
 )
 
-var (
-	ErrInvalidCommand     = errors.New("invalid command")
-	ErrInvalidFrameFormat = errors.New("invalid frame format")
+const (
+	// DefaultMaxHeaders is the number of header entries that a frame
+	// read by a Reader may have, unless set with MaxHeaders.
+	DefaultMaxHeaders = 1000
+
+	// DefaultMaxHeaderLineSize is the length that the command and
+	// header lines of a frame read by a Reader may have, unless set
+	// with MaxHeaderLineSize.
+	DefaultMaxHeaderLineSize = 64 * 1024
 )
 
+// returned by readSlice when the limit is exceeded
+var errSliceTooLong = errors.New("slice too long")
+
 // The Reader type reads STOMP frames from an underlying io.Reader.
-// The reader is buffered, and the size of the buffer is the maximum
-// size permitted for the STOMP frame command and header section.
-// A STOMP frame is rejected if its command and header section exceed
-// the buffer size.
+// The reader is buffered, and lines of the command and header section
+// are parsed in place in the buffer when they fit, so that reading a
+// frame allocates little more than its header values and body.
 type Reader struct {
-	reader *bufio.Reader
+	src           io.Reader // the underlying io.Reader
+	reader        *bufio.Reader
+	line          []byte                // holds lines that do not fit in the buffer
+	threshold     int                   // bodies longer than this are streamed, if positive
+	body          *bodyReader           // streams the body of the last frame read, if any
+	maxBodySize   int                   // zero for no limit
+	maxHeaderSize int                   // zero for no limit
+	maxHeaders    int                   // zero for no limit
+	maxLineSize   int                   // zero for no limit
+	alloc         func(size int) []byte // allocates bodies, nil for make
+	pooled        bool                  // bodies are taken from the body pool
+	lastSlice     int                   // length of the last slice read by readSlice
+	logger        *wireLogger           // logs the frames read, if not nil
+}
+
+// A ReaderOption configures a Reader created by NewReader.
+type ReaderOption func(*readerOptions)
+
+type readerOptions struct {
+	bufferSize    int
+	maxBodySize   int
+	maxHeaderSize int
+	maxHeaders    int
+	maxLineSize   int
+	alloc         func(size int) []byte
+	pooled        bool
+}
+
+// ReaderBufferSize sets the size of the underlying buffer of a Reader,
+// which is 4096 bytes by default. Lines of the command and header
+// section that fit in the buffer are parsed without being copied.
+func ReaderBufferSize(size int) ReaderOption {
+	return func(o *readerOptions) {
+		o.bufferSize = size
+	}
+}
+
+// MaxBodySize limits the body of the frames read to size bytes,
+// whether it is read into memory or streamed. Read returns
+// ErrBodyTooLarge for a frame with a larger body. There is no limit
+// by default, or if size is zero or less.
+func MaxBodySize(size int) ReaderOption {
+	return func(o *readerOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// MaxHeaderSize limits the command and header section of the frames
+// read to size bytes, including the line endings. Read returns
+// ErrHeaderTooLarge for a frame with a larger header. There is no
+// limit by default, or if size is zero or less.
+func MaxHeaderSize(size int) ReaderOption {
+	return func(o *readerOptions) {
+		o.maxHeaderSize = size
+	}
+}
+
+// MaxHeaders limits the number of header entries of the frames read
+// to count. Read returns ErrTooManyHeaders for a frame with more. The
+// limit is DefaultMaxHeaders by default, and there is none if count
+// is zero or less.
+func MaxHeaders(count int) ReaderOption {
+	return func(o *readerOptions) {
+		o.maxHeaders = count
+	}
+}
+
+// MaxHeaderLineSize limits the command line and each header line of
+// the frames read to size bytes, including the line ending. Read
+// returns ErrHeaderLineTooLong for a frame with a longer line. The
+// limit is DefaultMaxHeaderLineSize by default, and there is none if
+// size is zero or less.
+func MaxHeaderLineSize(size int) ReaderOption {
+	return func(o *readerOptions) {
+		o.maxLineSize = size
+	}
+}
+
+// BodyBuffer makes the reader call alloc for the body of every frame
+// read, other than a streamed body, with the length of the body. The
+// body is read into the slice returned, which must have that length,
+// so that the caller can supply buffers that it manages itself. The
+// frame does not own the buffer, and Release does not reuse it.
+func BodyBuffer(alloc func(size int) []byte) ReaderOption {
+	return func(o *readerOptions) {
+		o.alloc = alloc
+		o.pooled = false
+	}
 }
 
-// NewReader creates a Reader with the default underlying buffer size.
-func NewReader(reader io.Reader) *Reader {
-	return NewReaderSize(reader, bufferSize)
+// PooledBodies makes the reader take the bodies of the frames read
+// from a pool of buffers, instead of allocating a body for each frame.
+// Release returns the body of such a frame to the pool, so the body
+// of a released frame must no longer be used, any more than its
+// header, and must not have been passed to code that keeps it, such
+// as a queue. Frames whose bodies are kept are simply not released.
+func PooledBodies() ReaderOption {
+	return func(o *readerOptions) {
+		o.alloc = nil
+		o.pooled = true
+	}
+}
+
+// NewReader creates a Reader configured by opts. The header entries
+// and header lines of the frames read are limited by default, so that
+// a peer cannot exhaust memory with an endless header section.
+func NewReader(reader io.Reader, opts ...ReaderOption) *Reader {
+	o := readerOptions{
+		bufferSize:  bufferSize,
+		maxHeaders:  DefaultMaxHeaders,
+		maxLineSize: DefaultMaxHeaderLineSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := &Reader{
+		src:    reader,
+		reader: bufio.NewReaderSize(reader, o.bufferSize),
+		alloc:  o.alloc,
+		pooled: o.pooled,
+	}
+	if o.maxBodySize > 0 {
+		r.maxBodySize = o.maxBodySize
+	}
+	if o.maxHeaderSize > 0 {
+		r.maxHeaderSize = o.maxHeaderSize
+	}
+	if o.maxHeaders > 0 {
+		r.maxHeaders = o.maxHeaders
+	}
+	if o.maxLineSize > 0 {
+		r.maxLineSize = o.maxLineSize
+	}
+	return r
 }
 
 // NewReaderSize creates a Reader with an underlying bufferSize
 // of the specified size.
 func NewReaderSize(reader io.Reader, bufferSize int) *Reader {
-	return &Reader{reader: bufio.NewReaderSize(reader, bufferSize)}
+	return NewReader(reader, ReaderBufferSize(bufferSize))
+}
+
+// SetStreamThreshold makes the reader stream the bodies of frames that
+// have a "content-length" header entry greater than threshold bytes,
+// instead of reading them into memory. The BodyReader of such a frame
+// reads the body from the input, and is only valid until the next call
+// to Read, which discards whatever has not been read of the body. If
+// threshold is zero or less, which is the default, bodies are never
+// streamed.
+func (r *Reader) SetStreamThreshold(threshold int) {
+	r.threshold = threshold
+}
+
+// ReadFrame reads a STOMP frame from the input as Read does, but
+// returns ErrHeartBeat instead of a nil frame when it reads a
+// heart-beat, so that every frame returned without an error is a
+// frame.
+func (r *Reader) ReadFrame() (*Frame, error) {
+	f, err := r.Read()
+	if f == nil && err == nil {
+		err = ErrHeartBeat
+	}
+	return f, err
+}
+
+// Implemented by network connections, such as net.Conn, whose reads
+// can be interrupted.
+type readDeadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// ReadContext reads a STOMP frame from the input as Read does, and
+// returns ctx.Err() if ctx is done before the frame has been read. If
+// the underlying io.Reader has a SetReadDeadline method, as net.Conn
+// does, the read is interrupted by setting the read deadline to a time
+// in the past, which is left set. Otherwise the read goes on in the
+// background, and the frame that it reads is lost. Either way, the
+// reader must not be used again once ReadContext has returned the
+// error of ctx, as it may have read part of a frame.
+func (r *Reader) ReadContext(ctx context.Context) (*Frame, error) {
+	if ctx.Done() == nil {
+		// the context is never done
+		return r.Read()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if ds, ok := r.src.(readDeadlineSetter); ok {
+		stop := make(chan struct{})
+		interrupted := make(chan bool, 1)
+		go func() {
+			select {
+			case <-ctx.Done():
+				ds.SetReadDeadline(time.Unix(1, 0))
+				interrupted <- true
+			case <-stop:
+				interrupted <- false
+			}
+		}()
+		f, err := r.Read()
+		close(stop)
+		if <-interrupted {
+			return nil, ctx.Err()
+		}
+		return f, err
+	}
+
+	type result struct {
+		f   *Frame
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		f, err := r.Read()
+		ch <- result{f, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.f, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 // Read a STOMP frame from the input. If the input contains one
 // or more heart-beat characters and no frame, then nil will
 // be returned for the frame. Calling programs should always check
-// for a nil frame.
+// for a nil frame. A run of end-of-line characters between frames,
+// which some peers send as padding, is read as a single heart-beat
+// as far as it has been received.
 func (r *Reader) Read() (*Frame, error) {
-	commandSlice, err := r.readLine()
+	f, err := r.read()
+	if r.logger != nil {
+		r.logger.logFrame(f, err)
+	}
+	return f, err
+}
+
+func (r *Reader) read() (*Frame, error) {
+	if r.body != nil {
+		// skip the rest of the last frame's streamed body
+		body := r.body
+		r.body = nil
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			return nil, err
+		}
+	}
+
+	// bytes of the header section that may still be read, if limited
+	headerLimit := r.maxHeaderSize
+
+	commandSlice, err := r.readLine(headerLimit)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(commandSlice) == 0 {
 		// received a heart-beat newline char (or cr-lf)
+		r.skipEOL()
 		return nil, nil
 	}
 
-	f := New(string(commandSlice))
-	//println("RX:", f.Command)
-	switch f.Command {
 	// TODO(jpj): Is it appropriate to perform validation on the
 	// command at this point. Probably better to validate higher up,
 	// this way this type can be useful for any other non-STOMP protocols
 	// which happen to use the same frame format.
-	case CONNECT, STOMP, SEND, SUBSCRIBE,
-		UNSUBSCRIBE, ACK, NACK, BEGIN,
-		COMMIT, ABORT, DISCONNECT, CONNECTED,
-		MESSAGE, RECEIPT, ERROR:
-		// valid command
-	default:
+	command, ok := commandString(commandSlice)
+	if !ok {
 		return nil, ErrInvalidCommand
 	}
+	f := Acquire(command)
+	//println("RX:", f.Command)
+	headerLimit = r.remainingHeader(headerLimit)
 
 	// read headers
 	for {
-		headerSlice, err := r.readLine()
+		headerSlice, err := r.readLine(headerLimit)
 		if err != nil {
 			return nil, err
 		}
+		headerLimit = r.remainingHeader(headerLimit)
 
 		if len(headerSlice) == 0 {
 			// empty line means end of headers
@@ -89,9 +331,12 @@ func (r *Reader) Read() (*Frame, error) {
 			return nil, ErrInvalidFrameFormat
 		}
 
-		name, err := unencodeValue(headerSlice[0:index])
-		if err != nil {
-			return nil, err
+		name, ok := headerName(headerSlice[0:index])
+		if !ok {
+			name, err = unencodeValue(headerSlice[0:index])
+			if err != nil {
+				return nil, err
+			}
 		}
 		value, err := unencodeValue(headerSlice[index+1:])
 		if err != nil {
@@ -100,48 +345,161 @@ func (r *Reader) Read() (*Frame, error) {
 
 		//println("   ", name, ":", value)
 
+		if r.maxHeaders > 0 && f.Header.Len() >= r.maxHeaders {
+			return nil, ErrTooManyHeaders
+		}
 		f.Header.Add(name, value)
 	}
 
 	// get content length from the headers
 	if contentLength, ok, err := f.Header.ContentLength(); err != nil {
 		// happens if the content is malformed
-		return nil, err
+		e := ErrInvalidContentLength.withCommand(command)
+		e.Err = err
+		return nil, e
+	} else if ok && r.maxBodySize > 0 && contentLength > r.maxBodySize {
+		return nil, ErrBodyTooLarge
+	} else if ok && r.threshold > 0 && contentLength > r.threshold {
+		// the body is read by the caller
+		r.body = &bodyReader{r: r, remaining: contentLength}
+		f.BodyReader = r.body
 	} else if ok {
 		// content length specified in the header, so use that
-		f.Body = make([]byte, contentLength)
-		for bytesRead := 0; bytesRead < contentLength; {
-			n, err := r.reader.Read(f.Body[bytesRead:contentLength])
-			if err != nil {
-				return nil, err
+		r.allocBody(f, contentLength)
+		if _, err := io.ReadFull(r.reader, f.Body); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
 			}
-			bytesRead += n
-		}
-
-		// read the next byte and verify that it is a null byte
-		terminator, err := r.reader.ReadByte()
-		if err != nil {
 			return nil, err
 		}
-		if terminator != 0 {
-			return nil, ErrInvalidFrameFormat
+		if err := r.readNull(); err != nil {
+			return nil, err
 		}
 	} else {
-		f.Body, err = r.reader.ReadBytes(nullByte)
+		limit := 0
+		if r.maxBodySize > 0 {
+			// allow for the null byte
+			limit = r.maxBodySize + 1
+		}
+		body, err := r.readSlice(nullByte, limit)
+		if err == errSliceTooLong {
+			err = ErrBodyTooLarge
+		}
 		if err != nil {
 			return nil, err
 		}
 		// remove trailing null
-		f.Body = f.Body[0 : len(f.Body)-1]
+		r.allocBody(f, len(body)-1)
+		copy(f.Body, body)
 	}
 
 	// pass back frame
 	return f, nil
 }
 
-// read one line from input and strip off terminating LF or terminating CR-LF
-func (r *Reader) readLine() (line []byte, err error) {
-	line, err = r.reader.ReadBytes(newline)
+// Sets the body of a frame to a buffer of the specified size.
+func (r *Reader) allocBody(f *Frame, size int) {
+	switch {
+	case r.alloc != nil:
+		f.Body = r.alloc(size)[:size]
+	case r.pooled && size > 0:
+		f.pooledBody = getBody(size)
+		f.Body = *f.pooledBody
+	default:
+		f.Body = make([]byte, size)
+	}
+}
+
+// read the byte that follows a body of known length, and verify that it
+// is a null byte.
+func (r *Reader) readNull() error {
+	terminator, err := r.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if terminator != 0 {
+		return ErrInvalidFrameFormat
+	}
+	return nil
+}
+
+// Streams the body of a frame from the input of a Reader.
+type bodyReader struct {
+	r         *Reader
+	remaining int   // bytes of the body not yet read
+	err       error // returned by every read once set
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.remaining == 0 {
+		b.err = b.r.readNull()
+		if b.err == nil {
+			b.err = io.EOF
+		}
+		return 0, b.err
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.reader.Read(p)
+	b.remaining -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	b.err = err
+	return n, err
+}
+
+// Skips the end-of-line characters that have been received and
+// buffered, without waiting for more input.
+func (r *Reader) skipEOL() {
+	for r.reader.Buffered() > 0 {
+		b, _ := r.reader.Peek(r.reader.Buffered())
+		switch {
+		case b[0] == newline:
+			r.reader.Discard(1)
+		case b[0] == cr && len(b) > 1 && b[1] == newline:
+			r.reader.Discard(2)
+		default:
+			return
+		}
+	}
+}
+
+// Returns what remains of the limit of the header section after the
+// last line read, which was limited by limit if it is positive.
+func (r *Reader) remainingHeader(limit int) int {
+	if limit <= 0 {
+		return limit
+	}
+	limit -= r.lastSlice
+	if limit <= 0 {
+		// a line of any length counts as too long now
+		limit = -1
+	}
+	return limit
+}
+
+// read one line from input and strip off terminating LF or terminating CR-LF.
+// The line is only valid until the next read. The line with its
+// terminator may not be longer than the line size limit of the reader,
+// nor than headerLimit bytes if it is positive, and if headerLimit is
+// negative, nothing more may be read.
+func (r *Reader) readLine(headerLimit int) (line []byte, err error) {
+	if headerLimit < 0 {
+		return nil, ErrHeaderTooLarge
+	}
+	limit, tooLong := headerLimit, ErrHeaderTooLarge
+	if r.maxLineSize > 0 && (limit == 0 || r.maxLineSize < limit) {
+		limit, tooLong = r.maxLineSize, ErrHeaderLineTooLong
+	}
+	line, err = r.readSlice(newline, limit)
+	if err == errSliceTooLong {
+		err = tooLong
+	}
 	if err != nil {
 		return
 	}
@@ -155,3 +513,123 @@ func (r *Reader) readLine() (line []byte, err error) {
 
 	return
 }
+
+// read input up to and including delim. The slice refers to the buffer
+// of the reader if it fits, and otherwise to r.line, and is only valid
+// until the next read. If limit is positive, errSliceTooLong is returned
+// once more than limit bytes have been read without finding delim.
+func (r *Reader) readSlice(delim byte, limit int) ([]byte, error) {
+	slice, err := r.reader.ReadSlice(delim)
+	r.lastSlice = len(slice)
+	if limit > 0 && len(slice) > limit {
+		return nil, errSliceTooLong
+	}
+	if err != bufio.ErrBufferFull {
+		return slice, err
+	}
+	r.line = append(r.line[:0], slice...)
+	for err == bufio.ErrBufferFull {
+		slice, err = r.reader.ReadSlice(delim)
+		r.line = append(r.line, slice...)
+		r.lastSlice = len(r.line)
+		if limit > 0 && len(r.line) > limit {
+			return nil, errSliceTooLong
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.line, nil
+}
+
+// Returns the command of a frame, without allocating a string,
+// and false if it is not a valid command.
+func commandString(b []byte) (string, bool) {
+	// the compiler does not allocate for string(b) in a switch
+	switch string(b) {
+	case CONNECT:
+		return CONNECT, true
+	case STOMP:
+		return STOMP, true
+	case SEND:
+		return SEND, true
+	case SUBSCRIBE:
+		return SUBSCRIBE, true
+	case UNSUBSCRIBE:
+		return UNSUBSCRIBE, true
+	case ACK:
+		return ACK, true
+	case NACK:
+		return NACK, true
+	case BEGIN:
+		return BEGIN, true
+	case COMMIT:
+		return COMMIT, true
+	case ABORT:
+		return ABORT, true
+	case DISCONNECT:
+		return DISCONNECT, true
+	case CONNECTED:
+		return CONNECTED, true
+	case MESSAGE:
+		return MESSAGE, true
+	case RECEIPT:
+		return RECEIPT, true
+	case ERROR:
+		return ERROR, true
+	}
+	return "", false
+}
+
+// Returns the name of a header entry without allocating a string if
+// it is one of the names defined by the STOMP specification or this
+// package, and false otherwise.
+func headerName(b []byte) (string, bool) {
+	switch string(b) {
+	case ContentLength:
+		return ContentLength, true
+	case ContentType:
+		return ContentType, true
+	case Receipt:
+		return Receipt, true
+	case AcceptVersion:
+		return AcceptVersion, true
+	case Host:
+		return Host, true
+	case Version:
+		return Version, true
+	case Login:
+		return Login, true
+	case Passcode:
+		return Passcode, true
+	case HeartBeat:
+		return HeartBeat, true
+	case Session:
+		return Session, true
+	case Server:
+		return Server, true
+	case Destination:
+		return Destination, true
+	case Id:
+		return Id, true
+	case Ack:
+		return Ack, true
+	case Transaction:
+		return Transaction, true
+	case ReceiptId:
+		return ReceiptId, true
+	case Subscription:
+		return Subscription, true
+	case MessageId:
+		return MessageId, true
+	case Message:
+		return Message, true
+	case Expires:
+		return Expires, true
+	case Persistent:
+		return Persistent, true
+	case Redelivered:
+		return Redelivered, true
+	}
+	return "", false
+}
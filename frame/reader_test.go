@@ -1,9 +1,14 @@
 package frame
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
+	"net"
 	"strings"
+	"testing"
 	"testing/iotest"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -99,6 +104,88 @@ func (s *ReaderSuite) TestSendWithContentLength(c *C) {
 	c.Assert(err, Equals, io.EOF)
 }
 
+func (s *ReaderSuite) TestCRLF(c *C) {
+	reader := NewReader(strings.NewReader("\r\nSEND\r\ndestination:xxx\r\nreceipt:1\r\n\r\nbody\x00\r\n\r\n" +
+		"SEND\r\ndestination:yyy\r\ncontent-length:2\r\n\r\n\r\n\x00"))
+
+	// heart-beats may be CR-LF
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f, IsNil)
+
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, SEND)
+	c.Check(f.Header.Len(), Equals, 2)
+	c.Check(f.Header.Get(Destination), Equals, "xxx")
+	c.Check(f.Header.Get(Receipt), Equals, "1")
+	c.Check(string(f.Body), Equals, "body")
+
+	// consecutive heart-beats are read as one
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f, IsNil)
+
+	// the body is not altered
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(Destination), Equals, "yyy")
+	c.Check(string(f.Body), Equals, "\r\n")
+}
+
+func (s *ReaderSuite) TestStreamBody(c *C) {
+	body := strings.Repeat("0123456789", 1000)
+	input := "SEND\ndestination:xxx\ncontent-length:10000\n\n" + body + "\x00" +
+		"SEND\ndestination:yyy\ncontent-length:10000\n\n" + body + "\x00" +
+		"SEND\ndestination:zzz\ncontent-length:5\n\nsmall\x00"
+	reader := NewReader(strings.NewReader(input))
+	reader.SetStreamThreshold(100)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Body, IsNil)
+	c.Assert(f.BodyReader, NotNil)
+	b, err := ioutil.ReadAll(f.BodyReader)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, body)
+
+	// a body that is not read is skipped
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get("destination"), Equals, "yyy")
+	p := make([]byte, 10)
+	_, err = io.ReadFull(f.BodyReader, p)
+	c.Assert(err, IsNil)
+
+	// a body within the threshold is not streamed
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get("destination"), Equals, "zzz")
+	c.Check(f.BodyReader, IsNil)
+	c.Check(string(f.Body), Equals, "small")
+
+	f, err = reader.Read()
+	c.Check(f, IsNil)
+	c.Check(err, Equals, io.EOF)
+}
+
+func (s *ReaderSuite) TestStreamBodyMissingNull(c *C) {
+	reader := NewReader(strings.NewReader("SEND\ncontent-length:5\n\n01234\n"))
+	reader.SetStreamThreshold(1)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(f.BodyReader)
+	c.Check(err, Equals, ErrInvalidFrameFormat)
+
+	reader = NewReader(strings.NewReader("SEND\ncontent-length:5\n\n012"))
+	reader.SetStreamThreshold(1)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(f.BodyReader)
+	c.Check(err, Equals, io.ErrUnexpectedEOF)
+}
+
 func (s *ReaderSuite) TestInvalidCommand(c *C) {
 	reader := NewReader(strings.NewReader("sEND\ndestination:xxx\ncontent-length:5\n\n\x00\x01\x02\x03\x04\x00"))
 
@@ -118,23 +205,226 @@ func (s *ReaderSuite) TestMissingNull(c *C) {
 }
 
 func (s *ReaderSuite) TestSubscribeWithoutId(c *C) {
-	c.Skip("TODO: implement validate")
-
 	reader := NewReader(strings.NewReader("SUBSCRIBE\ndestination:xxx\nIId:7\n\n\x00"))
 
 	frame, err := reader.Read()
-	c.Check(frame, IsNil)
+	c.Assert(err, IsNil)
+	c.Check(frame.Validate(Lenient), IsNil)
+	err = frame.Validate(Strict)
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "missing header: id")
 }
 
 func (s *ReaderSuite) TestUnsubscribeWithoutId(c *C) {
-	c.Skip("TODO: implement validate")
-
 	reader := NewReader(strings.NewReader("UNSUBSCRIBE\nIId:7\n\n\x00"))
 
 	frame, err := reader.Read()
-	c.Check(frame, IsNil)
-	c.Assert(err, NotNil)
-	c.Check(err.Error(), Equals, "missing header: id")
+	c.Assert(err, IsNil)
+	for _, mode := range []ValidationMode{Lenient, Strict} {
+		err = frame.Validate(mode)
+		c.Assert(err, NotNil)
+		c.Check(err.Error(), Equals, "missing header: id")
+	}
+}
+
+func (s *ReaderSuite) TestLongLines(c *C) {
+	value := strings.Repeat("x", 100)
+	text := "SEND\ndestination:" + value + "\nlong\\cname:" + value + "\n\n" + value + "\x00"
+
+	reader := NewReaderSize(strings.NewReader(text+text), 16)
+	for i := 0; i < 2; i++ {
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f, NotNil)
+		c.Check(f.Command, Equals, SEND)
+		c.Check(f.Header.Get(Destination), Equals, value)
+		c.Check(f.Header.Get("long:name"), Equals, value)
+		c.Check(string(f.Body), Equals, value)
+	}
+}
+
+func (s *ReaderSuite) TestLimits(c *C) {
+	// the header section is 27 bytes, the body 5
+	text := "SEND\ndestination:/queue/a\n\nhello\x00"
+	withLength := "SEND\ncontent-length:5\n\nhello\x00"
+
+	for _, size := range []int{8, 4096} {
+		// within the limits
+		reader := NewReader(strings.NewReader(text+withLength),
+			ReaderBufferSize(size), MaxHeaderSize(27), MaxBodySize(5))
+		for i := 0; i < 2; i++ {
+			f, err := reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(string(f.Body), Equals, "hello")
+		}
+
+		// the header is too large
+		reader = NewReader(strings.NewReader(text), ReaderBufferSize(size), MaxHeaderSize(26))
+		_, err := reader.Read()
+		c.Check(err, Equals, ErrHeaderTooLarge)
+		reader = NewReader(strings.NewReader(text), ReaderBufferSize(size), MaxHeaderSize(3))
+		_, err = reader.Read()
+		c.Check(err, Equals, ErrHeaderTooLarge)
+
+		// the body is too large, with or without a content-length
+		reader = NewReader(strings.NewReader(text), ReaderBufferSize(size), MaxBodySize(4))
+		_, err = reader.Read()
+		c.Check(err, Equals, ErrBodyTooLarge)
+		reader = NewReader(strings.NewReader(withLength), ReaderBufferSize(size), MaxBodySize(4))
+		_, err = reader.Read()
+		c.Check(err, Equals, ErrBodyTooLarge)
+	}
+
+	// streamed bodies are limited too
+	reader := NewReader(strings.NewReader(withLength), MaxBodySize(4))
+	reader.SetStreamThreshold(1)
+	_, err := reader.Read()
+	c.Check(err, Equals, ErrBodyTooLarge)
+}
+
+func (s *ReaderSuite) TestHeaderLimits(c *C) {
+	text := "SEND\na:1\nb:2\nc:3\n\n\x00"
+
+	reader := NewReader(strings.NewReader(text), MaxHeaders(3), MaxHeaderLineSize(5))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Len(), Equals, 3)
+
+	reader = NewReader(strings.NewReader(text), MaxHeaders(2))
+	_, err = reader.Read()
+	c.Check(err, Equals, ErrTooManyHeaders)
+
+	// the command line counts as well as the header lines
+	reader = NewReader(strings.NewReader(text), MaxHeaderLineSize(4))
+	_, err = reader.Read()
+	c.Check(err, Equals, ErrHeaderLineTooLong)
+	reader = NewReader(strings.NewReader("SEND\nlong:value\n\n\x00"), MaxHeaderLineSize(8))
+	_, err = reader.Read()
+	c.Check(err, Equals, ErrHeaderLineTooLong)
+
+	// the tighter of the limits on lines and on the header applies
+	reader = NewReader(strings.NewReader(text), MaxHeaderLineSize(5), MaxHeaderSize(8))
+	_, err = reader.Read()
+	c.Check(err, Equals, ErrHeaderTooLarge)
+
+	// the header is limited by default
+	endless := io.MultiReader(strings.NewReader("SEND\n"), &repeatReader{data: []byte("a:1\n")})
+	_, err = NewReader(endless).Read()
+	c.Check(err, Equals, ErrTooManyHeaders)
+	endless = io.MultiReader(strings.NewReader("SEND\na:"), &repeatReader{data: []byte("x")})
+	_, err = NewReader(endless).Read()
+	c.Check(err, Equals, ErrHeaderLineTooLong)
+
+	// unless the limits are removed
+	long := "SEND\na:" + strings.Repeat("x", DefaultMaxHeaderLineSize) + "\n\n\x00"
+	reader = NewReader(strings.NewReader(long), MaxHeaderLineSize(0))
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(len(f.Header.Get("a")), Equals, DefaultMaxHeaderLineSize)
+}
+
+func (s *ReaderSuite) TestPadding(c *C) {
+	text := "SEND\n\nhello\x00\n\n\r\n\nSEND\n\nworld\x00\n"
+	reader := NewReader(strings.NewReader(text))
+	f, err := reader.ReadFrame()
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "hello")
+
+	// the padding between frames is read as one heart-beat
+	f, err = reader.ReadFrame()
+	c.Check(f, IsNil)
+	c.Check(err, Equals, ErrHeartBeat)
+	f, err = reader.ReadFrame()
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "world")
+
+	f, err = reader.Read()
+	c.Check(f, IsNil)
+	c.Check(err, IsNil)
+	_, err = reader.ReadFrame()
+	c.Check(err, Equals, io.EOF)
+
+	// padding received in pieces is read as a heart-beat per piece
+	reader = NewReader(iotest.OneByteReader(strings.NewReader("\n\r\nSEND\n\n\x00")))
+	for i := 0; i < 2; i++ {
+		_, err = reader.ReadFrame()
+		c.Check(err, Equals, ErrHeartBeat)
+	}
+	f, err = reader.ReadFrame()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, SEND)
+}
+
+func (s *ReaderSuite) TestReadContext(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f, err := NewReader(strings.NewReader("SEND\n\nhello\x00")).ReadContext(ctx)
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "hello")
+
+	// a read from a connection is interrupted by its read deadline
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = NewReader(server).ReadContext(ctx)
+	c.Check(err, Equals, context.DeadlineExceeded)
+
+	// and a read from any other io.Reader is abandoned
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = NewReader(pr).ReadContext(ctx)
+	c.Check(err, Equals, context.Canceled)
+
+	// a context that is already done fails at once
+	_, err = NewReader(strings.NewReader("SEND\n\n\x00")).ReadContext(ctx)
+	c.Check(err, Equals, context.Canceled)
+}
+
+// An io.Reader that repeats its data forever.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+func benchmarkReader(b *testing.B, text string, opts ...ReaderOption) {
+	reader := NewReader(&repeatReader{data: []byte(text)}, opts...)
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := reader.Read()
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Release()
+	}
+}
+
+func BenchmarkReaderSend(b *testing.B) {
+	benchmarkReader(b, "SEND\ndestination:/queue/orders\ncontent-type:text/plain\n"+
+		"receipt:77\nx-order-id:1234567\n\n{\"order\":1234567,\"qty\":3}\x00")
+}
+
+func BenchmarkReaderSendContentLength(b *testing.B) {
+	benchmarkReader(b, "SEND\ndestination:/queue/orders\ncontent-type:text/plain\n"+
+		"content-length:25\nx-order-id:1234567\n\n{\"order\":1234567,\"qty\":3}\x00")
+}
+
+func BenchmarkReaderSendPooledBodies(b *testing.B) {
+	benchmarkReader(b, "SEND\ndestination:/queue/orders\ncontent-type:text/plain\n"+
+		"content-length:25\nx-order-id:1234567\n\n{\"order\":1234567,\"qty\":3}\x00", PooledBodies())
+}
+
+func BenchmarkReaderAck(b *testing.B) {
+	benchmarkReader(b, "ACK\nid:1234\ntransaction:tx1\n\n\x00")
 }
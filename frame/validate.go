@@ -0,0 +1,121 @@
+package frame
+
+import (
+	"unicode/utf8"
+)
+
+// A ValidationMode chooses how strictly Frame.Validate applies the
+// STOMP specification.
+type ValidationMode int
+
+const (
+	// Lenient checks only that frames have the header entries without
+	// which they cannot be handled, and accepts the header entries of
+	// earlier versions of STOMP in place of those of STOMP 1.2, as
+	// well as the quirks of common clients, such as bodies on frames
+	// that should have none.
+	Lenient ValidationMode = iota
+
+	// Strict enforces STOMP 1.2: the command must be one of the STOMP
+	// commands, every required header entry must be present, only
+	// SEND, MESSAGE and ERROR frames may have a body, a text body must
+	// have a "content-type" header entry, and a "content-length"
+	// header entry must match the length of the body.
+	Strict
+)
+
+// String returns "lenient" or "strict".
+func (m ValidationMode) String() string {
+	if m == Strict {
+		return "strict"
+	}
+	return "lenient"
+}
+
+// Header entries required by STOMP 1.2, by command.
+var requiredHeaders = map[string][]string{
+	CONNECT:     {AcceptVersion, Host},
+	STOMP:       {AcceptVersion, Host},
+	CONNECTED:   {Version},
+	SEND:        {Destination},
+	SUBSCRIBE:   {Destination, Id},
+	UNSUBSCRIBE: {Id},
+	ACK:         {Id},
+	NACK:        {Id},
+	BEGIN:       {Transaction},
+	COMMIT:      {Transaction},
+	ABORT:       {Transaction},
+	MESSAGE:     {Destination, MessageId, Subscription},
+	RECEIPT:     {ReceiptId},
+}
+
+// Validate returns nil if the frame is valid in the specified mode, or
+// a *ProtocolError if it is not. A body streamed by BodyReader is not
+// checked.
+func (f *Frame) Validate(mode ValidationMode) error {
+	h := f.Header
+	if h == nil {
+		h = &Header{}
+	}
+	if mode != Strict {
+		return validateLenient(f.Command, h)
+	}
+
+	if _, ok := commandString([]byte(f.Command)); !ok {
+		return ErrInvalidCommand.withCommand(f.Command)
+	}
+	for _, key := range requiredHeaders[f.Command] {
+		if _, ok := h.Contains(key); !ok {
+			return missingHeader(f.Command, key)
+		}
+	}
+	if f.BodyReader != nil {
+		return nil
+	}
+	if len(f.Body) > 0 {
+		switch f.Command {
+		case SEND, MESSAGE, ERROR:
+		default:
+			return ErrUnexpectedBody.withCommand(f.Command)
+		}
+		if _, ok := h.Contains(ContentType); !ok && utf8.Valid(f.Body) {
+			return missingHeader(f.Command, ContentType)
+		}
+	}
+	if length, ok, err := h.ContentLength(); err != nil {
+		e := ErrInvalidContentLength.withCommand(f.Command)
+		e.Err = err
+		return e
+	} else if ok && length != len(f.Body) {
+		return ErrContentLengthMismatch.withCommand(f.Command)
+	}
+	return nil
+}
+
+// Checks the header entries without which a frame cannot be handled.
+func validateLenient(command string, h *Header) error {
+	switch command {
+	case SEND, SUBSCRIBE:
+		return requireHeader(command, h, Destination)
+	case UNSUBSCRIBE:
+		// STOMP 1.0 permits the destination in place of the id
+		return requireHeader(command, h, Id, Destination)
+	case ACK, NACK:
+		// STOMP 1.0 and 1.1 identify the message by its message-id
+		return requireHeader(command, h, Id, MessageId)
+	case BEGIN, COMMIT, ABORT:
+		return requireHeader(command, h, Transaction)
+	}
+	return nil
+}
+
+// Returns nil if the header has one of the keys, and the error for
+// the first of them otherwise.
+func requireHeader(command string, h *Header, keys ...string) error {
+	for _, key := range keys {
+		if _, ok := h.Contains(key); ok {
+			return nil
+		}
+	}
+	return missingHeader(command, keys[0])
+}
@@ -0,0 +1,94 @@
+package frame
+
+import (
+	"errors"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ValidateSuite struct{}
+
+var _ = Suite(&ValidateSuite{})
+
+func (s *ValidateSuite) TestValid(c *C) {
+	send := New(SEND, Destination, "/queue/a", ContentType, "text/plain", ContentLength, "5")
+	send.Body = []byte("hello")
+	binary := New(SEND, Destination, "/queue/a")
+	binary.Body = []byte{0xff, 0xfe}
+	for _, f := range []*Frame{
+		New(CONNECT, AcceptVersion, "1.2", Host, "h"),
+		New(SUBSCRIBE, Destination, "/queue/a", Id, "1"),
+		New(UNSUBSCRIBE, Id, "1"),
+		New(ACK, Id, "1"),
+		New(BEGIN, Transaction, "tx"),
+		New(DISCONNECT),
+		send,
+		binary,
+	} {
+		for _, mode := range []ValidationMode{Lenient, Strict} {
+			c.Check(f.Validate(mode), IsNil, Commentf("%s %d", f.Command, mode))
+		}
+	}
+}
+
+func (s *ValidateSuite) TestInvalid(c *C) {
+	textBody := New(SEND, Destination, "/queue/a")
+	textBody.Body = []byte("hello")
+	wrongLength := New(SEND, Destination, "/queue/a", ContentType, "text/plain", ContentLength, "4")
+	wrongLength.Body = []byte("hello")
+	beginBody := New(BEGIN, Transaction, "tx")
+	beginBody.Body = []byte{0}
+
+	for _, t := range []struct {
+		f       *Frame
+		strict  error
+		lenient error
+	}{
+		{New("SENT"), ErrInvalidCommand, nil},
+		{New(SEND), missingHeader("", Destination), missingHeader("", Destination)},
+		{New(CONNECT, AcceptVersion, "1.2"), missingHeader("", Host), nil},
+		{New(SUBSCRIBE, Id, "1"), missingHeader("", Destination), missingHeader("", Destination)},
+		{New(UNSUBSCRIBE, Destination, "/queue/a"), missingHeader("", Id), nil},
+		{New(ACK, MessageId, "1"), missingHeader("", Id), nil},
+		{New(NACK), missingHeader("", Id), missingHeader("", Id)},
+		{New(COMMIT), missingHeader("", Transaction), missingHeader("", Transaction)},
+		{textBody, missingHeader("", ContentType), nil},
+		{wrongLength, ErrContentLengthMismatch, nil},
+		{beginBody, ErrUnexpectedBody, nil},
+	} {
+		for mode, want := range map[ValidationMode]error{Strict: t.strict, Lenient: t.lenient} {
+			err := t.f.Validate(mode)
+			if want == nil {
+				c.Check(err, IsNil, Commentf("%s", t.f))
+			} else {
+				c.Check(errors.Is(err, want), Equals, true, Commentf("%s: %v", t.f, err))
+			}
+		}
+	}
+}
+
+func (s *ValidateSuite) TestProtocolError(c *C) {
+	err := New(SUBSCRIBE, Destination, "/queue/a").Validate(Strict)
+	var pe *ProtocolError
+	c.Assert(errors.As(err, &pe), Equals, true)
+	c.Check(pe.Code, Equals, CodeMissingHeader)
+	c.Check(pe.Command, Equals, SUBSCRIBE)
+	c.Check(pe.Header, Equals, Id)
+	c.Check(err.Error(), Equals, "missing header: id")
+	c.Check(errors.Is(err, missingHeader(SUBSCRIBE, Id)), Equals, true)
+	c.Check(errors.Is(err, missingHeader(SEND, Id)), Equals, false)
+	c.Check(errors.Is(err, missingHeader("", Destination)), Equals, false)
+
+	// the underlying error of an invalid content-length is kept
+	f := New(SEND, Destination, "/queue/a", ContentLength, "x")
+	err = f.Validate(Strict)
+	c.Check(errors.Is(err, ErrInvalidContentLength), Equals, true)
+	c.Assert(errors.As(err, &pe), Equals, true)
+	c.Check(pe.Command, Equals, SEND)
+	c.Check(pe.Unwrap(), NotNil)
+
+	_, err = NewReader(strings.NewReader("SEND\ncontent-length:x\n\n\x00")).Read()
+	c.Check(errors.Is(err, ErrInvalidContentLength), Equals, true)
+	c.Check(errors.Is(err, ErrInvalidFrameFormat), Equals, false)
+}
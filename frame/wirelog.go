@@ -0,0 +1,117 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A DumpFormat chooses how a logging Reader or Writer renders the raw
+// bytes that it reads or writes.
+type DumpFormat int
+
+const (
+	// DumpEscaped renders bytes as a quoted Go string, which shows the
+	// line endings and null bytes of frames.
+	DumpEscaped DumpFormat = iota
+
+	// DumpHex renders bytes as a hex dump, with an offset and the
+	// printable characters on each line, as hexdump -C does.
+	DumpHex
+)
+
+// Bytes of the body of a frame rendered in a log.
+const wireLogMaxBody = 256
+
+// Logs the raw bytes and the frames read or written by a Reader or
+// Writer. Each entry is written with one call to the io.Writer of the
+// log, so that a Reader and a Writer can share it.
+type wireLogger struct {
+	out    io.Writer
+	prefix string // "<" for bytes read, ">" for bytes written
+	format DumpFormat
+}
+
+// Logs raw bytes.
+func (l *wireLogger) logBytes(p []byte) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %d bytes", l.prefix, len(p))
+	if l.format == DumpHex {
+		b.WriteString(":\n")
+		b.WriteString(hex.Dump(p))
+	} else {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(string(p)))
+		b.WriteByte('\n')
+	}
+	l.out.Write(b.Bytes())
+}
+
+// Logs a frame that has been parsed or is about to be written, or the
+// error that prevented a frame from being read. Nothing is logged for
+// a heart-beat, which shows in the raw bytes.
+func (l *wireLogger) logFrame(f *Frame, err error) {
+	var b bytes.Buffer
+	switch {
+	case err != nil:
+		fmt.Fprintf(&b, "%s error: %v\n", l.prefix, err)
+	case f == nil:
+		return
+	default:
+		for _, line := range strings.Split(f.Dump(wireLogMaxBody), "\n") {
+			fmt.Fprintf(&b, "%s| %s\n", l.prefix, line)
+		}
+	}
+	l.out.Write(b.Bytes())
+}
+
+// Passes bytes through to or from the underlying io.Reader or
+// io.Writer, and logs them.
+type wireLogStream struct {
+	r      io.Reader
+	w      io.Writer
+	logger *wireLogger
+}
+
+func (s *wireLogStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.logger.logBytes(p[:n])
+	}
+	return n, err
+}
+
+func (s *wireLogStream) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if n > 0 {
+		s.logger.logBytes(p[:n])
+	}
+	return n, err
+}
+
+// NewLoggingReader creates a Reader configured by opts, as NewReader
+// does, which writes a log of the raw bytes that it reads from reader,
+// rendered in the specified format, and of the frames that it parses
+// from them, to log. It is meant for debugging interoperability with
+// other clients and brokers, and is slow.
+func NewLoggingReader(reader io.Reader, log io.Writer, format DumpFormat, opts ...ReaderOption) *Reader {
+	logger := &wireLogger{out: log, prefix: "<", format: format}
+	r := NewReader(&wireLogStream{r: reader, logger: logger}, opts...)
+	r.src = reader
+	r.logger = logger
+	return r
+}
+
+// NewLoggingWriter creates a Writer, as NewWriter does, which writes a
+// log of the frames that it writes, and of the raw bytes that it
+// writes to writer, rendered in the specified format, to log. The raw
+// bytes are logged when the buffer of the Writer is flushed.
+func NewLoggingWriter(writer io.Writer, log io.Writer, format DumpFormat) *Writer {
+	logger := &wireLogger{out: log, prefix: ">", format: format}
+	w := NewWriter(&wireLogStream{w: writer, logger: logger})
+	w.logger = logger
+	return w
+}
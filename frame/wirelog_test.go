@@ -0,0 +1,47 @@
+package frame
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type WireLogSuite struct{}
+
+var _ = Suite(&WireLogSuite{})
+
+func (s *WireLogSuite) TestReader(c *C) {
+	var log bytes.Buffer
+	reader := NewLoggingReader(strings.NewReader("\nSEND\nk:v\n\nhi\x00"), &log, DumpEscaped)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f, IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, SEND)
+	_, err = reader.Read()
+	c.Check(log.String(), Equals,
+		"< 14 bytes \"\\nSEND\\nk:v\\n\\nhi\\x00\"\n"+
+			"<| SEND\n"+
+			"<| k:v\n"+
+			"<| \n"+
+			"<| \"hi\"\n"+
+			"< error: EOF\n")
+}
+
+func (s *WireLogSuite) TestWriter(c *C) {
+	var log, out bytes.Buffer
+	writer := NewLoggingWriter(&out, &log, DumpHex)
+	f := New(SEND, "k", "v")
+	f.Body = []byte("hi")
+	c.Assert(writer.Write(f), IsNil)
+	c.Check(out.String(), Equals, "SEND\nk:v\n\nhi\x00")
+	c.Check(log.String(), Equals,
+		">| SEND\n"+
+			">| k:v\n"+
+			">| \n"+
+			">| \"hi\"\n"+
+			"> 13 bytes:\n"+
+			"00000000  53 45 4e 44 0a 6b 3a 76  0a 0a 68 69 00           |SEND.k:v..hi.|\n")
+}
@@ -2,9 +2,23 @@ package frame
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// ErrMissingContentLength is returned when a frame with a BodyReader is
+// written without a valid "content-length" header entry.
+var ErrMissingContentLength = errors.New("streamed body requires content-length header")
+
+// ErrNullInBody is returned when a frame whose body contains a null
+// byte is written without a "content-length" header entry, as the body
+// would be cut short at the null byte when the frame is read.
+var ErrNullInBody = errors.New("body with null byte requires content-length header")
+
 // slices used to write frames
 var (
 	colonSlice   = []byte{58}     // colon ':'
@@ -13,9 +27,17 @@ var (
 	nullSlice    = []byte{0}      // null character
 )
 
-// Writes STOMP frames to an underlying io.Writer.
+// Writes STOMP frames to an underlying io.Writer. The methods of a
+// Writer may be called by several goroutines at once, such as one that
+// writes frames and one that writes heart-beats, and each frame is
+// written whole before anything else is.
 type Writer struct {
-	writer *bufio.Writer
+	mutex   sync.Mutex
+	writer  *bufio.Writer
+	eol     []byte            // ends each line, and is the heart-beat
+	encoder *strings.Replacer // escapes header keys and values, nil for none
+	length  bool              // writes the content-length of every body
+	logger  *wireLogger       // logs the frames written, if not nil
 }
 
 // Creates a new Writer object, which writes to an underlying io.Writer.
@@ -24,36 +46,138 @@ func NewWriter(writer io.Writer) *Writer {
 }
 
 func NewWriterSize(writer io.Writer, bufferSize int) *Writer {
-	return &Writer{writer: bufio.NewWriterSize(writer, bufferSize)}
+	return &Writer{
+		writer:  bufio.NewWriterSize(writer, bufferSize),
+		eol:     newlineSlice,
+		encoder: replacerForEncodeValue,
+	}
+}
+
+// SetVersion sets the version of the STOMP protocol negotiated with the
+// peer, such as "1.1", which determines how header keys and values are
+// escaped. STOMP 1.0 defines no escaping, so they are written as they
+// are, and a newline in them cannot be represented. STOMP 1.1 escapes
+// backslash, newline and colon, and STOMP 1.2 carriage return as well.
+// The header entries of CONNECT and CONNECTED frames are never escaped,
+// as they are exchanged before the version has been negotiated. Until
+// the version is set, header entries are escaped as for STOMP 1.2.
+func (w *Writer) SetVersion(version string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch version {
+	case "1.0":
+		w.encoder = nil
+	case "1.1":
+		w.encoder = replacerForEncodeValue11
+	default:
+		w.encoder = replacerForEncodeValue
+	}
+}
+
+// SetCRLF makes the writer end lines, and write heart-beats, with a
+// carriage return and line feed (CR-LF), as STOMP 1.2 permits, if crlf
+// is true, and with a line feed (LF) alone, which is the default and is
+// understood by every version of STOMP, if crlf is false.
+func (w *Writer) SetCRLF(crlf bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if crlf {
+		w.eol = crlfSlice
+	} else {
+		w.eol = newlineSlice
+	}
 }
 
-// Write the contents of a frame to the underlying io.Writer.
+// SetContentLength makes the writer compute the "content-length"
+// header entry of every frame with a body, and write it in place of
+// any entry of the frame, if always is true. If always is false, which
+// is the default, the header entries of frames are written as they
+// are, and a frame whose body contains a null byte and that has no
+// "content-length" header entry is rejected with ErrNullInBody, rather
+// than being written in a form that would be read back truncated.
+func (w *Writer) SetContentLength(always bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.length = always
+}
+
+// Write the contents of a frame to the underlying io.Writer. If the
+// frame has a BodyReader, the number of bytes given by the frame's
+// "content-length" header entry are copied from it, and an error is
+// returned if it has fewer.
 func (w *Writer) Write(f *Frame) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.buffer(f); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// WriteHeartBeat writes a heart-beat to the underlying io.Writer,
+// together with any frames that have been buffered. If a frame is
+// being written by another goroutine, the heart-beat is written once
+// the frame has been.
+func (w *Writer) WriteHeartBeat() error {
+	return w.Write(nil)
+}
+
+// Buffer writes the contents of a frame to the buffer of the writer,
+// which is written to the underlying io.Writer when it is full or when
+// Flush is called. Buffering several frames and then flushing them
+// writes them to the network together, instead of one at a time.
+func (w *Writer) Buffer(f *Frame) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buffer(f)
+}
+
+func (w *Writer) buffer(f *Frame) error {
 	var err error
 
+	if w.logger != nil && f != nil {
+		w.logger.logFrame(f, nil)
+	}
+
 	if f == nil {
-		// nil frame means send a heart-beat LF
-		_, err = w.writer.Write(newlineSlice)
+		// nil frame means send a heart-beat LF, or CR-LF
+		_, err = w.writer.Write(w.eol)
 		if err != nil {
 			return err
 		}
 	} else {
+		// the body of a frame written with a content-length
+		computed := w.length && f.BodyReader == nil && len(f.Body) > 0
+		if !computed && f.BodyReader == nil && bytes.IndexByte(f.Body, nullByte) >= 0 {
+			if f.Header == nil || f.Header.Get(ContentLength) == "" {
+				return ErrNullInBody
+			}
+		}
+
 		_, err = w.writer.Write([]byte(f.Command))
 		if err != nil {
 			return err
 		}
 
-		_, err = w.writer.Write(newlineSlice)
+		_, err = w.writer.Write(w.eol)
 		if err != nil {
 			return err
 		}
 
 		//println("TX:", f.Command)
 		if f.Header != nil {
+			encoder := w.encoder
+			switch f.Command {
+			case CONNECT, STOMP, CONNECTED:
+				encoder = nil
+			}
 			for i := 0; i < f.Header.Len(); i++ {
 				key, value := f.Header.GetAt(i)
 				//println("   ", key, ":", value)
-				_, err = replacerForEncodeValue.WriteString(w.writer, key)
+				if computed && key == ContentLength {
+					continue
+				}
+				err = w.writeEncoded(encoder, key)
 				if err != nil {
 					return err
 				}
@@ -61,27 +185,39 @@ func (w *Writer) Write(f *Frame) error {
 				if err != nil {
 					return err
 				}
-				_, err = replacerForEncodeValue.WriteString(w.writer, value)
+				err = w.writeEncoded(encoder, value)
 				if err != nil {
 					return err
 				}
-				_, err = w.writer.Write(newlineSlice)
+				_, err = w.writer.Write(w.eol)
 				if err != nil {
 					return err
 				}
 			}
 		}
+		if computed {
+			_, err = w.writer.WriteString(ContentLength + ":" + strconv.Itoa(len(f.Body)))
+			if err != nil {
+				return err
+			}
+			_, err = w.writer.Write(w.eol)
+			if err != nil {
+				return err
+			}
+		}
 
-		_, err = w.writer.Write(newlineSlice)
+		_, err = w.writer.Write(w.eol)
 		if err != nil {
 			return err
 		}
 
-		if len(f.Body) > 0 {
+		if f.BodyReader != nil {
+			err = w.copyBody(f)
+		} else if len(f.Body) > 0 {
 			_, err = w.writer.Write(f.Body)
-			if err != nil {
-				return err
-			}
+		}
+		if err != nil {
+			return err
 		}
 
 		// write the final null (0) byte
@@ -91,10 +227,49 @@ func (w *Writer) Write(f *Frame) error {
 		}
 	}
 
-	err = w.writer.Flush()
-	if err != nil {
-		return err
+	return nil
+}
+
+// Copies the streamed body of a frame. The body is passed through to
+// the underlying io.Writer once the buffer is full.
+func (w *Writer) copyBody(f *Frame) error {
+	if f.Header == nil {
+		return ErrMissingContentLength
+	}
+	length, ok, err := f.Header.ContentLength()
+	if !ok || err != nil {
+		return ErrMissingContentLength
+	}
+	_, err = io.CopyN(w.writer, f.BodyReader, int64(length))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
+	return err
+}
 
-	return nil
+// Flush writes any buffered frames to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Flush()
+}
+
+// Buffered returns the number of bytes of frames that have been
+// buffered and not yet written to the underlying io.Writer.
+func (w *Writer) Buffered() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Buffered()
+}
+
+// Writes a header key or value, escaped by encoder, or as it is if
+// encoder is nil.
+func (w *Writer) writeEncoded(encoder *strings.Replacer, s string) error {
+	var err error
+	if encoder == nil {
+		_, err = w.writer.WriteString(s)
+	} else {
+		_, err = encoder.WriteString(w.writer, s)
+	}
+	return err
 }
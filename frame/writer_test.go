@@ -2,6 +2,8 @@ package frame
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -46,3 +48,149 @@ func writeToBufferAndCheck(c *C, frameText string) {
 	c.Check(newFrameText, Equals, frameText)
 	c.Check(b.String(), Equals, frameText)
 }
+
+func (s *WriterSuite) TestStreamBody(c *C) {
+	body := strings.Repeat("0123456789", 1000)
+	f := New(SEND, Destination, "/queue/a", ContentLength, "10000")
+	f.BodyReader = strings.NewReader(body)
+	var b bytes.Buffer
+	c.Assert(NewWriter(&b).Write(f), IsNil)
+	c.Check(b.String(), Equals, "SEND\ndestination:/queue/a\ncontent-length:10000\n\n"+body+"\x00")
+
+	// the body is read back as written
+	reader := NewReader(&b)
+	reader.SetStreamThreshold(100)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	read, err := ioutil.ReadAll(f.BodyReader)
+	c.Assert(err, IsNil)
+	c.Check(string(read), Equals, body)
+
+	// the content-length is required, and the reader must supply it
+	f = New(SEND, Destination, "/queue/a")
+	f.BodyReader = strings.NewReader(body)
+	c.Check(NewWriter(&b).Write(f), Equals, ErrMissingContentLength)
+	f.Header.Set(ContentLength, "20000")
+	f.BodyReader = strings.NewReader(body)
+	c.Check(NewWriter(&b).Write(f), Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *WriterSuite) TestCRLF(c *C) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.SetCRLF(true)
+	f := New(SEND, Destination, "/queue/a")
+	f.Body = []byte("body\n")
+	c.Assert(w.Write(f), IsNil)
+	c.Assert(w.Write(nil), IsNil)
+	c.Check(b.String(), Equals, "SEND\r\ndestination:/queue/a\r\n\r\nbody\n\x00\r\n")
+
+	// the frame is read back as written
+	reader := NewReader(&b)
+	read, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(read.Header.Get(Destination), Equals, "/queue/a")
+	c.Check(string(read.Body), Equals, "body\n")
+
+	b.Reset()
+	w.SetCRLF(false)
+	c.Assert(w.Write(nil), IsNil)
+	c.Check(b.String(), Equals, "\n")
+}
+
+func (s *WriterSuite) TestVersion(c *C) {
+	f := New(SEND, "key:a", "value\\b\r\nc")
+	write := func(w *Writer, f *Frame) string {
+		var b bytes.Buffer
+		w.writer.Reset(&b)
+		c.Assert(w.Write(f), IsNil)
+		return b.String()
+	}
+	w := NewWriter(nil)
+	c.Check(write(w, f), Equals, "SEND\nkey\\ca:value\\\\b\\r\\nc\n\n\x00")
+
+	w.SetVersion("1.0")
+	c.Check(write(w, f), Equals, "SEND\nkey:a:value\\b\r\nc\n\n\x00")
+
+	w.SetVersion("1.1")
+	c.Check(write(w, f), Equals, "SEND\nkey\\ca:value\\\\b\r\\nc\n\n\x00")
+
+	w.SetVersion("1.2")
+	c.Check(write(w, f), Equals, "SEND\nkey\\ca:value\\\\b\\r\\nc\n\n\x00")
+
+	// the header entries of CONNECT and CONNECTED frames are not escaped
+	f = New(CONNECTED, "session", "a:b")
+	c.Check(write(w, f), Equals, "CONNECTED\nsession:a:b\n\n\x00")
+	f = New(CONNECT, "login", "a\\b")
+	c.Check(write(w, f), Equals, "CONNECT\nlogin:a\\b\n\n\x00")
+}
+
+func (s *WriterSuite) TestContentLength(c *C) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+
+	// a body with a null byte needs a content-length
+	f := New(SEND, Destination, "/queue/a")
+	f.Body = []byte("a\x00b")
+	c.Check(w.Write(f), Equals, ErrNullInBody)
+	c.Check(b.Len(), Equals, 0)
+	c.Check(w.Write(&Frame{Command: SEND, Body: []byte{0}}), Equals, ErrNullInBody)
+
+	// unless it is computed
+	w.SetContentLength(true)
+	c.Assert(w.Write(f), IsNil)
+	c.Check(b.String(), Equals, "SEND\ndestination:/queue/a\ncontent-length:3\n\na\x00b\x00")
+
+	// a wrong content-length of the frame is replaced
+	b.Reset()
+	f.Header.Add(ContentLength, "1")
+	c.Assert(w.Write(f), IsNil)
+	c.Check(b.String(), Equals, "SEND\ndestination:/queue/a\ncontent-length:3\n\na\x00b\x00")
+	read, err := NewReader(&b).Read()
+	c.Assert(err, IsNil)
+	c.Check(read.Body, DeepEquals, f.Body)
+
+	// and a frame without a body is written as it is
+	b.Reset()
+	c.Assert(w.Write(New(SEND, Destination, "/queue/a")), IsNil)
+	c.Check(b.String(), Equals, "SEND\ndestination:/queue/a\n\n\x00")
+}
+
+func (s *WriterSuite) TestConcurrentHeartBeats(c *C) {
+	var b bytes.Buffer
+	w := NewWriterSize(&b, 16)
+	body := strings.Repeat("x", 1000)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.Check(w.WriteHeartBeat(), IsNil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		f := New(SEND, Destination, "/queue/a")
+		f.Body = []byte(body)
+		c.Check(w.Write(f), IsNil)
+	}
+	<-done
+
+	// the heart-beats are written between whole frames
+	reader := NewReader(&b)
+	frames, heartBeats := 0, 0
+	for {
+		f, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err == ErrHeartBeat {
+			heartBeats++
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(string(f.Body), Equals, body)
+		frames++
+	}
+	c.Check(frames, Equals, 100)
+	c.Check(heartBeats > 0, Equals, true)
+}
@@ -4,5 +4,7 @@ go 1.15
 
 require (
 	github.com/golang/mock v1.6.0
+	github.com/lib/pq v1.9.0
+	go.etcd.io/bbolt v1.3.6
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
 )
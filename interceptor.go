@@ -0,0 +1,53 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// An Interceptor inspects, modifies or rejects the frames sent to and
+// received from the server, for example to add authentication tokens
+// or trace header entries, or to record the traffic. Interceptors are
+// added with ConnOpt.Interceptor, and work like the interceptors of
+// the server, see the Interceptor type of the server/client package.
+//
+// The methods are called on the go-routine that processes the frames
+// of the connection, so should return quickly and must not call
+// methods of the connection that wait for frames to be sent. They are
+// also called with the CONNECT and CONNECTED frames, before Connect
+// returns. Heart-beats are not passed to interceptors.
+type Interceptor interface {
+	// Inbound is called with each frame received from the server,
+	// before it is processed, and can modify the frame. If Inbound
+	// returns an error, the connection is closed, and the error is
+	// returned to the callers waiting for the server.
+	Inbound(c *Conn, f *frame.Frame) error
+
+	// Outbound is called with each frame sent to the server, before
+	// it is written, and can modify the frame. If Outbound returns an
+	// error, the frame is not sent and the connection is closed, as
+	// if writing the frame had failed.
+	Outbound(c *Conn, f *frame.Frame) error
+}
+
+// Passes a frame received from the server to the interceptors, in
+// order, until one returns an error.
+func (c *Conn) interceptInbound(f *frame.Frame) error {
+	for _, i := range c.interceptors {
+		if err := i.Inbound(c, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Passes a frame sent to the server to the interceptors, in reverse
+// order, so that the first interceptor sees inbound frames first and
+// outbound frames last.
+func (c *Conn) interceptOutbound(f *frame.Frame) error {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		if err := c.interceptors[i].Outbound(c, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
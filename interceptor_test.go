@@ -0,0 +1,114 @@
+package stomp
+
+import (
+	"errors"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+
+	. "gopkg.in/check.v1"
+)
+
+// recordingInterceptor records the frames that it sees in calls, adds
+// a header entry to them, and rejects the frames with reject as their
+// command.
+type recordingInterceptor struct {
+	name   string
+	calls  *[]string
+	reject string
+}
+
+func (i recordingInterceptor) Inbound(c *Conn, f *frame.Frame) error {
+	return i.intercept("in", f)
+}
+
+func (i recordingInterceptor) Outbound(c *Conn, f *frame.Frame) error {
+	return i.intercept("out", f)
+}
+
+func (i recordingInterceptor) intercept(direction string, f *frame.Frame) error {
+	*i.calls = append(*i.calls, i.name+" "+direction+" "+f.Command)
+	if f.Command == i.reject {
+		return errors.New(i.name + " rejected " + f.Command)
+	}
+	f.Header.Add("x-"+i.name, direction)
+	return nil
+}
+
+// serveInterceptor accepts a connection and answers frames that ask for
+// a receipt, sending the frames that it receives to frames.
+func serveInterceptor(c *C, frames chan<- *frame.Frame) *testutil.FakeConn {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		defer close(frames)
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				return
+			}
+			frames <- f
+			switch {
+			case f.Command == frame.CONNECT:
+				writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+			case f.Header.Get(frame.Receipt) != "":
+				writer.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+			}
+		}
+	}()
+	return fc1
+}
+
+func (s *StompSuite) Test_interceptors(c *C) {
+	var calls []string
+	frames := make(chan *frame.Frame, 10)
+	conn, err := Connect(serveInterceptor(c, frames),
+		ConnOpt.Interceptor(recordingInterceptor{name: "a", calls: &calls}),
+		ConnOpt.Interceptor(recordingInterceptor{name: "b", calls: &calls}))
+	c.Assert(err, IsNil)
+
+	f := <-frames
+	c.Check(f.Command, Equals, frame.CONNECT)
+	c.Check(f.Header.Get("x-a"), Equals, "out")
+	c.Check(f.Header.Get("x-b"), Equals, "out")
+
+	c.Assert(conn.SendWithReceipt("/queue/test", "text/plain", []byte("hello")), IsNil)
+	f = <-frames
+	c.Check(f.Command, Equals, frame.SEND)
+	c.Check(f.Header.Get("x-a"), Equals, "out")
+	c.Check(f.Header.Get("x-b"), Equals, "out")
+	c.Assert(conn.Disconnect(), IsNil)
+
+	c.Check(calls[:6], DeepEquals, []string{
+		"b out CONNECT", "a out CONNECT",
+		"a in CONNECTED", "b in CONNECTED",
+		"b out SEND", "a out SEND",
+	})
+}
+
+func (s *StompSuite) Test_interceptor_rejects_frame(c *C) {
+	var calls []string
+	frames := make(chan *frame.Frame, 10)
+	conn, err := Connect(serveInterceptor(c, frames),
+		ConnOpt.Interceptor(recordingInterceptor{name: "a", calls: &calls, reject: frame.SEND}))
+	c.Assert(err, IsNil)
+	c.Check((<-frames).Command, Equals, frame.CONNECT)
+
+	err = conn.SendWithReceipt("/queue/test", "text/plain", []byte("hello"))
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "a rejected SEND")
+	<-conn.done
+
+	// the connection is closed without sending the frame
+	_, ok := <-frames
+	c.Check(ok, Equals, false)
+}
+
+func (s *StompSuite) Test_interceptor_rejects_connected(c *C) {
+	var calls []string
+	frames := make(chan *frame.Frame, 10)
+	_, err := Connect(serveInterceptor(c, frames),
+		ConnOpt.Interceptor(recordingInterceptor{name: "a", calls: &calls, reject: frame.CONNECTED}))
+	c.Check(err, ErrorMatches, "a rejected CONNECTED")
+}
@@ -1,5 +1,10 @@
 package stomp
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Logger interface {
 	Debugf(format string, value ...interface{})
 	Infof(format string, value ...interface{})
@@ -11,3 +16,187 @@ type Logger interface {
 	Warning(message string)
 	Error(message string)
 }
+
+// A Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with a name, which is one of "debug",
+// "info", "warning" (or "warn") and "error", ignoring case.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(name)
+	if name == "warn" {
+		return LevelWarning, nil
+	}
+	for l, n := range levelNames {
+		if n == name {
+			return Level(l), nil
+		}
+	}
+	return 0, fmt.Errorf("stomp: unknown log level %q", name)
+}
+
+// Keys of the fields that the client and server attach to the entries
+// that they log.
+const (
+	ComponentField   = "component"   // part of the server that logged the entry
+	RemoteAddrField  = "remote_addr" // network address of the peer
+	LoginField       = "login"       // login of an authenticated client
+	DestinationField = "destination" // destination of a frame or subscription
+	CommandField     = "command"     // command of a frame
+)
+
+// A Field is a key and value attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// A FieldLogger is a Logger that attaches fields to its entries itself.
+// An adapter for a structured logging library, such as log/slog, zap
+// or logrus, implements WithFields with the library's With or
+// WithFields method, so that the fields are recorded as attributes.
+type FieldLogger interface {
+	Logger
+
+	// WithFields returns a logger that attaches fields to each entry.
+	WithFields(fields ...Field) Logger
+}
+
+// WithFields returns a logger that attaches fields to the entries
+// logged with log. If log is a FieldLogger, its WithFields method is
+// called. Otherwise the fields are appended to each message in the
+// form key=value.
+func WithFields(log Logger, fields ...Field) Logger {
+	if len(fields) == 0 {
+		return log
+	}
+	if fl, ok := log.(FieldLogger); ok {
+		return fl.WithFields(fields...)
+	}
+	return &fieldLogger{log: log, suffix: formatFields(fields)}
+}
+
+// Formats fields as key=value pairs, each preceded by a space. Values
+// are quoted if they are empty or contain spaces, quotes or equals
+// signs.
+func formatFields(fields []Field) string {
+	var b strings.Builder
+	for _, f := range fields {
+		value := fmt.Sprint(f.Value)
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		b.WriteString(" " + f.Key + "=" + value)
+	}
+	return b.String()
+}
+
+// A logger that appends formatted fields to the messages of another.
+type fieldLogger struct {
+	log    Logger
+	suffix string
+}
+
+func (l *fieldLogger) WithFields(fields ...Field) Logger {
+	return &fieldLogger{log: l.log, suffix: l.suffix + formatFields(fields)}
+}
+
+func (l *fieldLogger) Debugf(format string, value ...interface{}) {
+	l.log.Debug(fmt.Sprintf(format, value...) + l.suffix)
+}
+
+func (l *fieldLogger) Infof(format string, value ...interface{}) {
+	l.log.Info(fmt.Sprintf(format, value...) + l.suffix)
+}
+
+func (l *fieldLogger) Warningf(format string, value ...interface{}) {
+	l.log.Warning(fmt.Sprintf(format, value...) + l.suffix)
+}
+
+func (l *fieldLogger) Errorf(format string, value ...interface{}) {
+	l.log.Error(fmt.Sprintf(format, value...) + l.suffix)
+}
+
+func (l *fieldLogger) Debug(message string)   { l.log.Debug(message + l.suffix) }
+func (l *fieldLogger) Info(message string)    { l.log.Info(message + l.suffix) }
+func (l *fieldLogger) Warning(message string) { l.log.Warning(message + l.suffix) }
+func (l *fieldLogger) Error(message string)   { l.log.Error(message + l.suffix) }
+
+// WithLevel returns a logger that discards the entries logged with log
+// whose level is below level.
+func WithLevel(log Logger, level Level) Logger {
+	if level <= LevelDebug {
+		return log
+	}
+	return &levelLogger{log: log, level: level}
+}
+
+// A logger that discards entries below a level.
+type levelLogger struct {
+	log   Logger
+	level Level
+}
+
+func (l *levelLogger) WithFields(fields ...Field) Logger {
+	return &levelLogger{log: WithFields(l.log, fields...), level: l.level}
+}
+
+func (l *levelLogger) Debugf(format string, value ...interface{}) {
+	if l.level <= LevelDebug {
+		l.log.Debugf(format, value...)
+	}
+}
+
+func (l *levelLogger) Infof(format string, value ...interface{}) {
+	if l.level <= LevelInfo {
+		l.log.Infof(format, value...)
+	}
+}
+
+func (l *levelLogger) Warningf(format string, value ...interface{}) {
+	if l.level <= LevelWarning {
+		l.log.Warningf(format, value...)
+	}
+}
+
+func (l *levelLogger) Errorf(format string, value ...interface{}) {
+	l.log.Errorf(format, value...)
+}
+
+func (l *levelLogger) Debug(message string) {
+	if l.level <= LevelDebug {
+		l.log.Debug(message)
+	}
+}
+
+func (l *levelLogger) Info(message string) {
+	if l.level <= LevelInfo {
+		l.log.Info(message)
+	}
+}
+
+func (l *levelLogger) Warning(message string) {
+	if l.level <= LevelWarning {
+		l.log.Warning(message)
+	}
+}
+
+func (l *levelLogger) Error(message string) {
+	l.log.Error(message)
+}
@@ -0,0 +1,87 @@
+package stomp
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type LoggerSuite struct{}
+
+var _ = Suite(&LoggerSuite{})
+
+func (s *LoggerSuite) TestWithFields(c *C) {
+	log := &testLogger{}
+	l := WithFields(log, Field{RemoteAddrField, "127.0.0.1:1234"}, Field{LoginField, "guest"})
+	l = WithFields(l, Field{DestinationField, "/queue/a b"}, Field{CommandField, ""})
+	l.Warningf("failed: %d", 1)
+	l.Error("closed")
+	c.Check(log.entries, DeepEquals, []string{
+		`WARNING failed: 1 remote_addr=127.0.0.1:1234 login=guest destination="/queue/a b" command=""`,
+		`ERROR closed remote_addr=127.0.0.1:1234 login=guest destination="/queue/a b" command=""`,
+	})
+	c.Check(WithFields(log), Equals, Logger(log))
+
+	// a FieldLogger attaches fields itself
+	fl := &testFieldLogger{}
+	WithLevel(fl, LevelInfo).(FieldLogger).WithFields(Field{LoginField, "guest"}).Info("connected")
+	c.Check(fl.fields, DeepEquals, []Field{{LoginField, "guest"}})
+	c.Check(fl.entries, DeepEquals, []string{"INFO connected"})
+}
+
+func (s *LoggerSuite) TestWithLevel(c *C) {
+	log := &testLogger{}
+	l := WithLevel(log, LevelWarning)
+	l.Debug("debug")
+	l.Infof("%s", "info")
+	l.Warningf("%s", "warning")
+	l.Error("error")
+	c.Check(log.entries, DeepEquals, []string{"WARNING warning", "ERROR error"})
+	c.Check(WithLevel(log, LevelDebug), Equals, Logger(log))
+}
+
+func (s *LoggerSuite) TestParseLevel(c *C) {
+	for name, level := range map[string]Level{
+		"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarning, "Warning": LevelWarning, "error": LevelError,
+	} {
+		l, err := ParseLevel(name)
+		c.Check(err, IsNil)
+		c.Check(l, Equals, level)
+	}
+	_, err := ParseLevel("verbose")
+	c.Check(err, NotNil)
+	c.Check(LevelWarning.String(), Equals, "warning")
+}
+
+// A logger that records the entries logged.
+type testLogger struct {
+	entries []string
+}
+
+func (l *testLogger) Debugf(format string, value ...interface{}) {
+	l.Debug(fmt.Sprintf(format, value...))
+}
+func (l *testLogger) Infof(format string, value ...interface{}) {
+	l.Info(fmt.Sprintf(format, value...))
+}
+func (l *testLogger) Warningf(format string, value ...interface{}) {
+	l.Warning(fmt.Sprintf(format, value...))
+}
+func (l *testLogger) Errorf(format string, value ...interface{}) {
+	l.Error(fmt.Sprintf(format, value...))
+}
+func (l *testLogger) Debug(message string)   { l.entries = append(l.entries, "DEBUG "+message) }
+func (l *testLogger) Info(message string)    { l.entries = append(l.entries, "INFO "+message) }
+func (l *testLogger) Warning(message string) { l.entries = append(l.entries, "WARNING "+message) }
+func (l *testLogger) Error(message string)   { l.entries = append(l.entries, "ERROR "+message) }
+
+// A FieldLogger that records the fields attached to it.
+type testFieldLogger struct {
+	testLogger
+	fields []Field
+}
+
+func (l *testFieldLogger) WithFields(fields ...Field) Logger {
+	l.fields = append(l.fields, fields...)
+	return l
+}
@@ -49,6 +49,26 @@ func (msg *Message) ShouldAck() bool {
 	return msg.Subscription.AckMode() != AckAuto
 }
 
+// Ack acknowledges the message to the STOMP server that sent it, with
+// the header entries required by the negotiated version of the STOMP
+// protocol. See Conn.Ack.
+func (msg *Message) Ack() error {
+	if msg.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return msg.Conn.Ack(msg)
+}
+
+// Nack negatively acknowledges the message to the STOMP server that
+// sent it, with the header entries required by the negotiated version
+// of the STOMP protocol. See Conn.Nack.
+func (msg *Message) Nack() error {
+	if msg.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return msg.Conn.Nack(msg)
+}
+
 func (msg *Message) Read(p []byte) (int, error) {
 	if len(msg.Body) == 0 {
 		return 0, io.EOF
@@ -0,0 +1,226 @@
+/*
+Package migrate moves the messages waiting on a source broker, such as
+ActiveMQ or RabbitMQ, to another broker over STOMP, so that a system
+can be migrated from one broker to another without losing messages.
+
+A Migrator subscribes to each destination on the source broker with
+client-individual acknowledgement, sends each message it receives to
+the target broker with a receipt, and acknowledges the message to the
+source broker once the target broker has received it. A destination is
+drained when no message has arrived for the idle timeout. A message may
+be sent twice if the migration stops between the receipt and the
+acknowledgement, but it is never lost. Every message sent carries a
+MessageIdHeader header, so that consumers can discard duplicates.
+
+The header entries of the messages are copied, except those that the
+source broker sets when it delivers a message, such as message-id and
+subscription.
+*/
+package migrate
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// DefaultIdleTimeout is how long a Migrator waits for the next message
+// of a destination before it considers the destination drained.
+const DefaultIdleTimeout = 5 * time.Second
+
+// Header added to each message sent to the target broker. The value is
+// the message-id of the message on the source broker.
+const MessageIdHeader = "migrate-message-id"
+
+// Header entries of MESSAGE frames that are set by the source broker
+// for the delivery, so are not copied.
+var deliveryHeaders = map[string]bool{
+	frame.Destination:   true,
+	frame.MessageId:     true,
+	frame.Subscription:  true,
+	frame.Ack:           true,
+	frame.ContentLength: true,
+	frame.ContentType:   true, // passed to Send
+	frame.Redelivered:   true,
+	MessageIdHeader:     true, // replaced, if migrated before
+}
+
+// ErrSubscriptionClosed is returned when the source broker ends the
+// subscription to a destination before it has been drained.
+var ErrSubscriptionClosed = errors.New("migrate: subscription closed by source broker")
+
+// A Destination is a destination to migrate, and the destination of the
+// target broker that its messages are sent to.
+type Destination struct {
+	Source string
+	Target string // Source if empty
+}
+
+// Progress describes the migration of a destination.
+type Progress struct {
+	Destination
+	Messages int           // Messages migrated
+	Bytes    int64         // Bytes in the bodies of the messages migrated
+	Elapsed  time.Duration // Since the migration of the destination started
+	Done     bool          // True once the destination has been drained, or the limit reached
+}
+
+// A Migrator moves messages from a source broker to a target broker.
+type Migrator struct {
+	Source *stomp.Conn // Connection to the broker that messages are taken from
+	Target *stomp.Conn // Connection to the broker that messages are sent to
+
+	// How long to wait for the next message of a destination before
+	// it is drained, DefaultIdleTimeout if zero.
+	IdleTimeout time.Duration
+
+	// Maximum number of messages migrated from each destination,
+	// unlimited if zero.
+	Limit int
+
+	// Keys of header entries that are not copied, in addition to those
+	// set by the source broker for the delivery.
+	DropHeaders []string
+
+	// Options of the SUBSCRIBE frames sent to the source broker, for
+	// example to set the prefetch size of ActiveMQ or RabbitMQ.
+	SubscribeOpts []func(*frame.Frame) error
+
+	// Called after each message is migrated, and once the migration of
+	// a destination is done. Can be nil.
+	Progress func(Progress)
+
+	Log stomp.Logger // If nil, the standard logger is used
+}
+
+// Migrate migrates the destinations in order, and returns the progress
+// of each destination migrated, including the one that failed if an
+// error occurred.
+func (m *Migrator) Migrate(ctx context.Context, destinations ...Destination) ([]Progress, error) {
+	results := make([]Progress, 0, len(destinations))
+	for _, d := range destinations {
+		p, err := m.MigrateDestination(ctx, d)
+		results = append(results, p)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// MigrateDestination migrates the messages of a destination until it
+// has been drained, the limit has been reached, or ctx is done, in which
+// case the error of ctx is returned. Messages that have been received
+// but not migrated are left to the source broker, which delivers them
+// again to its next subscriber.
+func (m *Migrator) MigrateDestination(ctx context.Context, d Destination) (Progress, error) {
+	if d.Target == "" {
+		d.Target = d.Source
+	}
+	p := Progress{Destination: d}
+	logger := m.Log
+	if logger == nil {
+		logger = log.StdLogger{}
+	}
+	idle := m.IdleTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+
+	start := time.Now()
+	sub, err := m.Source.SubscribeContext(ctx, d.Source, stomp.AckClientIndividual, m.SubscribeOpts...)
+	if err != nil {
+		return p, err
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil && err != stomp.ErrCompletedSubscription {
+			logger.Warningf("migrate: failed to unsubscribe from %s: %v", d.Source, err)
+		}
+	}()
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for m.Limit == 0 || p.Messages < m.Limit {
+		var msg *stomp.Message
+		select {
+		case <-ctx.Done():
+			p.Elapsed = time.Since(start)
+			return p, ctx.Err()
+		case <-timer.C:
+			p.Elapsed = time.Since(start)
+			p.Done = true
+			m.report(p)
+			return p, nil
+		case msg = <-sub.C:
+		}
+		if msg == nil {
+			p.Elapsed = time.Since(start)
+			if err := sub.Err(); err != nil {
+				return p, err
+			}
+			return p, ErrSubscriptionClosed
+		}
+		if msg.Err != nil {
+			p.Elapsed = time.Since(start)
+			return p, msg.Err
+		}
+
+		if err := m.Target.SendWithReceiptContext(ctx, d.Target, msg.ContentType, msg.Body, m.sendOpts(msg)...); err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+		if err := m.Source.AckContext(ctx, msg); err != nil {
+			p.Elapsed = time.Since(start)
+			return p, err
+		}
+		p.Messages++
+		p.Bytes += int64(len(msg.Body))
+		p.Elapsed = time.Since(start)
+		m.report(p)
+
+		if !timer.Stop() {
+			<-timer.C
+		}
+		timer.Reset(idle)
+	}
+	p.Done = true
+	m.report(p)
+	return p, nil
+}
+
+// sendOpts returns the options that copy the header entries of msg to
+// the SEND frame.
+func (m *Migrator) sendOpts(msg *stomp.Message) []func(*frame.Frame) error {
+	var opts []func(*frame.Frame) error
+	if msg.Header != nil {
+		msg.Header.Range(func(key, value string) bool {
+			if !deliveryHeaders[key] && !m.dropped(key) {
+				opts = append(opts, stomp.SendOpt.Header(key, value))
+			}
+			return true
+		})
+		if id := msg.Header.Get(frame.MessageId); id != "" {
+			opts = append(opts, stomp.SendOpt.Header(MessageIdHeader, id))
+		}
+	}
+	return opts
+}
+
+func (m *Migrator) dropped(key string) bool {
+	for _, drop := range m.DropHeaders {
+		if key == drop {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Migrator) report(p Progress) {
+	if m.Progress != nil {
+		m.Progress(p)
+	}
+}
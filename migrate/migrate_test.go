@@ -0,0 +1,144 @@
+package migrate
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server"
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MigrateSuite struct {
+	source, target *server.Server
+}
+
+var _ = Suite(&MigrateSuite{})
+
+func (s *MigrateSuite) SetUpTest(c *C) {
+	s.source = &server.Server{Addr: "127.0.0.1:0"}
+	c.Assert(s.source.Start(), IsNil)
+	s.target = &server.Server{Addr: "127.0.0.1:0"}
+	c.Assert(s.target.Start(), IsNil)
+}
+
+func (s *MigrateSuite) TearDownTest(c *C) {
+	s.source.Shutdown()
+	s.target.Shutdown()
+}
+
+func dial(c *C, srv *server.Server) *stomp.Conn {
+	conn, err := stomp.Dial("tcp", srv.Addrs()[0].String())
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *MigrateSuite) TestMigrate(c *C) {
+	source := dial(c, s.source)
+	defer source.Disconnect()
+	target := dial(c, s.target)
+	defer target.Disconnect()
+	for i := 0; i < 3; i++ {
+		c.Assert(source.Send("/queue/orders", "text/plain", []byte(strconv.Itoa(i)),
+			stomp.SendOpt.Receipt,
+			stomp.SendOpt.Header("order", strconv.Itoa(i)),
+			stomp.SendOpt.Header("secret", "x")), IsNil)
+	}
+	c.Assert(source.Send("/queue/empty", "", nil, stomp.SendOpt.Receipt), IsNil)
+
+	var progress []Progress
+	m := &Migrator{
+		Source:      source,
+		Target:      target,
+		IdleTimeout: 100 * time.Millisecond,
+		DropHeaders: []string{"secret"},
+		Progress:    func(p Progress) { progress = append(progress, p) },
+	}
+	results, err := m.Migrate(context.Background(),
+		Destination{Source: "/queue/orders", Target: "/queue/migrated"},
+		Destination{Source: "/queue/empty"})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 2)
+	c.Check(results[0].Messages, Equals, 3)
+	c.Check(results[0].Bytes, Equals, int64(3))
+	c.Check(results[0].Done, Equals, true)
+	c.Check(results[1].Destination, Equals, Destination{Source: "/queue/empty", Target: "/queue/empty"})
+	c.Check(results[1].Messages, Equals, 1)
+	c.Check(progress, HasLen, 6)
+	c.Check(progress[2].Messages, Equals, 3)
+	c.Check(progress[2].Done, Equals, false)
+	c.Check(progress[3].Done, Equals, true)
+
+	sub, err := target.Subscribe("/queue/migrated", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		msg := receive(c, sub)
+		c.Check(string(msg.Body), Equals, strconv.Itoa(i))
+		c.Check(msg.ContentType, Equals, "text/plain")
+		c.Check(msg.Header.Get("order"), Equals, strconv.Itoa(i))
+		c.Check(msg.Header.Get(MessageIdHeader), Not(Equals), "")
+		c.Check(msg.Header.GetAll(frame.Destination), DeepEquals, []string{"/queue/migrated"})
+		_, ok := msg.Header.Contains("secret")
+		c.Check(ok, Equals, false)
+	}
+
+	// the source queue has been drained
+	results, err = m.Migrate(context.Background(), Destination{Source: "/queue/orders"})
+	c.Assert(err, IsNil)
+	c.Check(results[0].Messages, Equals, 0)
+}
+
+func (s *MigrateSuite) TestLimit(c *C) {
+	source := dial(c, s.source)
+	defer source.Disconnect()
+	target := dial(c, s.target)
+	defer target.Disconnect()
+	for i := 0; i < 3; i++ {
+		c.Assert(source.Send("/queue/a", "text/plain", []byte(strconv.Itoa(i)), stomp.SendOpt.Receipt), IsNil)
+	}
+
+	m := &Migrator{Source: source, Target: target, IdleTimeout: 100 * time.Millisecond, Limit: 2}
+	p, err := m.MigrateDestination(context.Background(), Destination{Source: "/queue/a"})
+	c.Assert(err, IsNil)
+	c.Check(p.Messages, Equals, 2)
+	c.Check(p.Done, Equals, true)
+
+	// the message left is migrated next time
+	m.Limit = 0
+	p, err = m.MigrateDestination(context.Background(), Destination{Source: "/queue/a"})
+	c.Assert(err, IsNil)
+	c.Check(p.Messages, Equals, 1)
+}
+
+func (s *MigrateSuite) TestCancel(c *C) {
+	source := dial(c, s.source)
+	defer source.Disconnect()
+	target := dial(c, s.target)
+	defer target.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m := &Migrator{Source: source, Target: target}
+	_, err := m.MigrateDestination(ctx, Destination{Source: "/queue/a"})
+	c.Check(err, Equals, context.Canceled)
+}
+
+func receive(c *C, sub *stomp.Subscription) *stomp.Message {
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		return msg
+	case <-time.After(5 * time.Second):
+		c.Fatal("no message received")
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package stomp
+
+import (
+	"strconv"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// The functions in this file return options that apply to the frames
+// of both Conn.Send and Conn.Subscribe, where the header entry makes
+// sense for the frame. They are an alternative to SendOpt and
+// SubscribeOpt for header entries that brokers define.
+
+// WithHeader returns an option that adds a header entry to a SEND,
+// SUBSCRIBE or UNSUBSCRIBE frame.
+func WithHeader(key, value string) func(*frame.Frame) error {
+	return func(f *frame.Frame) error {
+		switch f.Command {
+		case frame.SEND, frame.SUBSCRIBE, frame.UNSUBSCRIBE:
+			f.Header.Add(key, value)
+			return nil
+		}
+		return ErrInvalidCommand
+	}
+}
+
+// WithPersistent returns an option that sets the "persistent" header
+// entry of a SEND frame, which asks the broker to keep the message in
+// durable storage until it is delivered, or not to.
+func WithPersistent(persistent bool) func(*frame.Frame) error {
+	return func(f *frame.Frame) error {
+		if f.Command != frame.SEND {
+			return ErrInvalidCommand
+		}
+		f.Header.Set(frame.Persistent, strconv.FormatBool(persistent))
+		return nil
+	}
+}
+
+// WithPriority returns an option that sets the "priority" header entry
+// of a SEND frame. Brokers deliver messages with higher priorities
+// first; most accept priorities from 0 to 9.
+func WithPriority(priority int) func(*frame.Frame) error {
+	return func(f *frame.Frame) error {
+		if f.Command != frame.SEND {
+			return ErrInvalidCommand
+		}
+		if priority < 0 {
+			return ErrInvalidPriority
+		}
+		f.Header.Set(frame.Priority, strconv.Itoa(priority))
+		return nil
+	}
+}
+
+// WithSelector returns an option that sets the "selector" header entry
+// of a SUBSCRIBE frame, so that the broker only delivers the messages
+// whose header entries match the SQL-92 expression, such as
+// "priority > 4 AND region = 'eu'".
+func WithSelector(expr string) func(*frame.Frame) error {
+	return func(f *frame.Frame) error {
+		if f.Command != frame.SUBSCRIBE {
+			return ErrInvalidCommand
+		}
+		f.Header.Set(frame.Selector, expr)
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestOptions(c *C) {
+	send := frame.New(frame.SEND)
+	subscribe := frame.New(frame.SUBSCRIBE)
+	ack := frame.New(frame.ACK)
+
+	c.Assert(WithHeader("x-region", "eu")(send), IsNil)
+	c.Assert(WithHeader("x-region", "eu")(subscribe), IsNil)
+	c.Check(WithHeader("x-region", "eu")(ack), Equals, ErrInvalidCommand)
+
+	c.Assert(WithPersistent(true)(send), IsNil)
+	c.Assert(WithPriority(7)(send), IsNil)
+	c.Check(WithPriority(-1)(send), Equals, ErrInvalidPriority)
+	c.Check(WithPriority(7)(subscribe), Equals, ErrInvalidCommand)
+	c.Check(WithPersistent(true)(subscribe), Equals, ErrInvalidCommand)
+	c.Check(send.Header.Get("x-region"), Equals, "eu")
+	c.Check(send.Header.Get(frame.Persistent), Equals, "true")
+	c.Check(send.Header.Get(frame.Priority), Equals, "7")
+	c.Assert(WithPersistent(false)(send), IsNil)
+	c.Check(send.Header.Get(frame.Persistent), Equals, "false")
+
+	c.Assert(WithSelector("priority > 4")(subscribe), IsNil)
+	c.Check(WithSelector("priority > 4")(send), Equals, ErrInvalidCommand)
+	c.Check(subscribe.Header.Get(frame.Selector), Equals, "priority > 4")
+	c.Check(subscribe.Header.Get("x-region"), Equals, "eu")
+}
+
+func (s *StompSuite) TestOptionsOnConn(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(frame.Priority), Equals, "9")
+		c.Check(f.Header.Get(frame.Persistent), Equals, "true")
+		f, err = rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		c.Check(f.Header.Get(frame.Selector), Equals, "priority = 9")
+		c.Check(f.Header.Get("x-region"), Equals, "eu")
+	}()
+
+	c.Assert(conn.Send("/queue/a", "", nil, WithPriority(9), WithPersistent(true)), IsNil)
+	_, err := conn.Subscribe("/queue/a", AckAuto, WithSelector("priority = 9"), WithHeader("x-region", "eu"))
+	c.Assert(err, IsNil)
+	<-stop
+	conn.MustDisconnect()
+}
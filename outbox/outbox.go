@@ -0,0 +1,159 @@
+/*
+Package outbox implements the transactional outbox pattern for publishing
+messages to a STOMP broker.
+
+An application that needs to update its database and publish a message
+atomically writes the message to an outbox table in the same database
+transaction as its other changes. A Relay reads the outbox table in order
+and publishes each message to the broker, recording the id of the last
+message published. Because the offset is recorded after the broker has
+acknowledged the message, a message may be published more than once if
+the relay stops between publishing and recording the offset, but it is
+never lost. Every published message carries an IdHeader header, so that
+consumers can discard duplicates.
+*/
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Default relay parameters.
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+)
+
+// Header added to each message published by a relay. The value is the
+// id of the message in the outbox, and is the same each time a message
+// is published.
+const IdHeader = "outbox-id"
+
+// A Message is a message waiting in an outbox to be published.
+type Message struct {
+	Id          int64         // Position in the outbox, assigned by the store
+	Destination string        // Destination to publish to
+	ContentType string        // Content type of the body
+	Header      *frame.Header // Additional headers, can be nil
+	Body        []byte        // Message body
+}
+
+// A Store provides access to the outbox and to the offset of the last
+// message published from it.
+type Store interface {
+	// Fetch returns up to limit messages with an id greater than after,
+	// in ascending order of id.
+	Fetch(ctx context.Context, after int64, limit int) ([]Message, error)
+
+	// Offset returns the id of the last message published, or zero if
+	// no message has been published.
+	Offset(ctx context.Context) (int64, error)
+
+	// SaveOffset records the id of the last message published.
+	SaveOffset(ctx context.Context, id int64) error
+}
+
+// A Publisher sends messages to the broker. It is implemented by
+// *stomp.Conn.
+type Publisher interface {
+	Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error
+}
+
+// A Relay publishes the messages in an outbox to the broker, in order.
+type Relay struct {
+	Store        Store         // Outbox to relay messages from
+	Publisher    Publisher     // Publishes messages to the broker
+	BatchSize    int           // Maximum messages fetched at once, DefaultBatchSize if zero
+	PollInterval time.Duration // Delay when the outbox is empty, DefaultPollInterval if zero
+	Log          stomp.Logger  // If nil, the standard logger is used
+}
+
+// Run publishes messages from the outbox until the context is done,
+// polling for new messages when the outbox is empty. Errors are logged
+// and the relay retries after the poll interval. Run returns the
+// context's error.
+func (r *Relay) Run(ctx context.Context) error {
+	pollInterval := r.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	logger := r.Log
+	if logger == nil {
+		logger = log.StdLogger{}
+	}
+
+	for {
+		n, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Errorf("outbox: relay failed: %v", err)
+		}
+		if err == nil && n == r.batchSize() {
+			// there may be more messages waiting
+			continue
+		}
+
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RelayBatch publishes the next batch of messages from the outbox, and
+// returns the number of messages published. Each message is published
+// with a receipt, and the offset is saved once the broker has received
+// the message. If an error occurs, messages published before the error
+// are not published again by the next call.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	offset, err := r.Store.Offset(ctx)
+	if err != nil {
+		return 0, err
+	}
+	messages, err := r.Store.Fetch(ctx, offset, r.batchSize())
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range messages {
+		if err = ctx.Err(); err != nil {
+			return i, err
+		}
+		if err = r.publish(m); err != nil {
+			return i, err
+		}
+		if err = r.Store.SaveOffset(ctx, m.Id); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+func (r *Relay) publish(m Message) error {
+	opts := []func(*frame.Frame) error{
+		stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(IdHeader, strconv.FormatInt(m.Id, 10)),
+	}
+	if m.Header != nil {
+		for i := 0; i < m.Header.Len(); i++ {
+			key, value := m.Header.GetAt(i)
+			opts = append(opts, stomp.SendOpt.Header(key, value))
+		}
+	}
+	return r.Publisher.Send(m.Destination, m.ContentType, m.Body, opts...)
+}
+
+func (r *Relay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return r.BatchSize
+}
@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type OutboxSuite struct{}
+
+var _ = Suite(&OutboxSuite{})
+
+type fakeStore struct {
+	messages []Message
+	offset   int64
+}
+
+func (s *fakeStore) Fetch(ctx context.Context, after int64, limit int) ([]Message, error) {
+	var messages []Message
+	for _, m := range s.messages {
+		if m.Id > after && len(messages) < limit {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (s *fakeStore) Offset(ctx context.Context) (int64, error) {
+	return s.offset, nil
+}
+
+func (s *fakeStore) SaveOffset(ctx context.Context, id int64) error {
+	s.offset = id
+	return nil
+}
+
+type fakePublisher struct {
+	sent   []*frame.Frame
+	failAt int // fail when this many frames have been sent, if non-zero
+}
+
+func (p *fakePublisher) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	if p.failAt > 0 && len(p.sent) == p.failAt {
+		return errors.New("broker unavailable")
+	}
+	f := frame.New(frame.SEND,
+		frame.Destination, destination,
+		frame.ContentType, contentType)
+	f.Body = body
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return err
+		}
+	}
+	p.sent = append(p.sent, f)
+	return nil
+}
+
+func newFakeStore(count int) *fakeStore {
+	store := &fakeStore{}
+	for i := 1; i <= count; i++ {
+		store.messages = append(store.messages, Message{
+			Id:          int64(i * 10),
+			Destination: "/queue/orders",
+			ContentType: "text/plain",
+			Header:      frame.NewHeader("order", string(rune('a'+i))),
+			Body:        []byte{byte(i)},
+		})
+	}
+	return store
+}
+
+func (s *OutboxSuite) TestRelayBatch(c *C) {
+	store := newFakeStore(5)
+	publisher := &fakePublisher{}
+	relay := &Relay{Store: store, Publisher: publisher, BatchSize: 3}
+
+	n, err := relay.RelayBatch(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 3)
+	c.Check(store.offset, Equals, int64(30))
+
+	n, err = relay.RelayBatch(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 2)
+	c.Check(store.offset, Equals, int64(50))
+
+	n, err = relay.RelayBatch(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 0)
+
+	c.Assert(publisher.sent, HasLen, 5)
+	f := publisher.sent[1]
+	c.Check(f.Header.Get(frame.Destination), Equals, "/queue/orders")
+	c.Check(f.Header.Get(IdHeader), Equals, "20")
+	c.Check(f.Header.Get("order"), Equals, "c")
+	c.Check(f.Header.Get(frame.Receipt), Not(Equals), "")
+	c.Check(f.Body, DeepEquals, []byte{2})
+}
+
+func (s *OutboxSuite) TestRelayResumesAfterError(c *C) {
+	store := newFakeStore(4)
+	publisher := &fakePublisher{failAt: 2}
+	relay := &Relay{Store: store, Publisher: publisher}
+
+	n, err := relay.RelayBatch(context.Background())
+	c.Check(err, ErrorMatches, "broker unavailable")
+	c.Check(n, Equals, 2)
+	c.Check(store.offset, Equals, int64(20))
+
+	// the messages already published are not published again
+	publisher.failAt = 0
+	n, err = relay.RelayBatch(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 2)
+	c.Assert(publisher.sent, HasLen, 4)
+	for i, f := range publisher.sent {
+		c.Check(f.Body, DeepEquals, []byte{byte(i + 1)})
+	}
+}
+
+func (s *OutboxSuite) TestRun(c *C) {
+	store := newFakeStore(3)
+	publisher := &fakePublisher{}
+	relay := &Relay{Store: store, Publisher: publisher, BatchSize: 2, PollInterval: time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := relay.Run(ctx)
+	c.Check(err, Equals, context.DeadlineExceeded)
+	c.Check(publisher.sent, HasLen, 3)
+	c.Check(store.offset, Equals, int64(30))
+}
+
+func (s *OutboxSuite) TestEncodeHeader(c *C) {
+	h, err := decodeHeader(encodeHeader(nil))
+	c.Assert(err, IsNil)
+	c.Check(h, IsNil)
+
+	h, err = decodeHeader(encodeHeader(frame.NewHeader("b", "x&y=z", "a", "1", "b", "2")))
+	c.Assert(err, IsNil)
+	c.Check(h, DeepEquals, frame.NewHeader("a", "1", "b", "x&y=z", "b", "2"))
+}
+
+func (s *OutboxSuite) TestPlaceholders(c *C) {
+	store := &SQLStore{}
+	c.Check(store.placeholders(1, 3), Equals, "?, ?, ?")
+	store.Placeholder = DollarPlaceholder
+	c.Check(store.placeholders(1, 3), Equals, "$1, $2, $3")
+}
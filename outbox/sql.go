@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Default table names used by SQLStore.
+const (
+	DefaultTable       = "stomp_outbox"
+	DefaultOffsetTable = "stomp_outbox_offset"
+)
+
+// QuestionPlaceholder returns "?" for every query parameter, as used
+// by MySQL and SQLite drivers.
+func QuestionPlaceholder(n int) string {
+	return "?"
+}
+
+// DollarPlaceholder returns "$1", "$2", etc. for the query parameters,
+// as used by PostgreSQL drivers.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// An Execer executes SQL statements. It is implemented by *sql.DB
+// and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLStore is a Store for an outbox table in an SQL database. The
+// tables are expected to have the following columns, where the id
+// column is assigned in ascending order by the database when a
+// row is inserted:
+//
+//	CREATE TABLE stomp_outbox (
+//		id           BIGINT PRIMARY KEY AUTO_INCREMENT,
+//		destination  VARCHAR(255) NOT NULL,
+//		content_type VARCHAR(255) NOT NULL,
+//		headers      TEXT NOT NULL,
+//		body         BLOB
+//	);
+//
+//	CREATE TABLE stomp_outbox_offset (
+//		relay   VARCHAR(255) PRIMARY KEY,
+//		last_id BIGINT NOT NULL
+//	);
+//
+// The headers column contains the additional message headers in URL
+// query encoding. Messages are normally added with Insert, and rows
+// that have been published can be deleted by the application.
+type SQLStore struct {
+	DB          *sql.DB
+	Table       string             // Outbox table, DefaultTable if empty
+	OffsetTable string             // Offset table, DefaultOffsetTable if empty
+	Relay       string             // Name of the relay in the offset table, "default" if empty
+	Placeholder func(n int) string // Query parameter placeholders, QuestionPlaceholder if nil
+}
+
+// Insert adds a message to the outbox. Pass the application's
+// transaction as tx, so that the message is only published if the
+// transaction commits. The Id field of the message is ignored.
+func (s *SQLStore) Insert(ctx context.Context, tx Execer, m Message) error {
+	query := "INSERT INTO " + s.table() +
+		" (destination, content_type, headers, body) VALUES (" +
+		s.placeholders(1, 4) + ")"
+	_, err := tx.ExecContext(ctx, query,
+		m.Destination, m.ContentType, encodeHeader(m.Header), m.Body)
+	return err
+}
+
+// Fetch implements the Store interface.
+func (s *SQLStore) Fetch(ctx context.Context, after int64, limit int) ([]Message, error) {
+	query := "SELECT id, destination, content_type, headers, body FROM " + s.table() +
+		" WHERE id > " + s.placeholder(1) +
+		" ORDER BY id LIMIT " + strconv.Itoa(limit)
+	rows, err := s.DB.QueryContext(ctx, query, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		var headers string
+		err = rows.Scan(&m.Id, &m.Destination, &m.ContentType, &headers, &m.Body)
+		if err != nil {
+			return nil, err
+		}
+		if m.Header, err = decodeHeader(headers); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Offset implements the Store interface.
+func (s *SQLStore) Offset(ctx context.Context) (int64, error) {
+	query := "SELECT last_id FROM " + s.offsetTable() +
+		" WHERE relay = " + s.placeholder(1)
+	var id int64
+	err := s.DB.QueryRowContext(ctx, query, s.relay()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// SaveOffset implements the Store interface.
+func (s *SQLStore) SaveOffset(ctx context.Context, id int64) error {
+	query := "UPDATE " + s.offsetTable() +
+		" SET last_id = " + s.placeholder(1) +
+		" WHERE relay = " + s.placeholder(2)
+	result, err := s.DB.ExecContext(ctx, query, id, s.relay())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// first offset saved by this relay
+	query = "INSERT INTO " + s.offsetTable() +
+		" (last_id, relay) VALUES (" + s.placeholders(1, 2) + ")"
+	_, err = s.DB.ExecContext(ctx, query, id, s.relay())
+	return err
+}
+
+func (s *SQLStore) table() string {
+	if s.Table == "" {
+		return DefaultTable
+	}
+	return s.Table
+}
+
+func (s *SQLStore) offsetTable() string {
+	if s.OffsetTable == "" {
+		return DefaultOffsetTable
+	}
+	return s.OffsetTable
+}
+
+func (s *SQLStore) relay() string {
+	if s.Relay == "" {
+		return "default"
+	}
+	return s.Relay
+}
+
+func (s *SQLStore) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return QuestionPlaceholder(n)
+	}
+	return s.Placeholder(n)
+}
+
+// Returns a comma-separated list of placeholders from first to last.
+func (s *SQLStore) placeholders(first, last int) string {
+	var list []string
+	for n := first; n <= last; n++ {
+		list = append(list, s.placeholder(n))
+	}
+	return strings.Join(list, ", ")
+}
+
+func encodeHeader(h *frame.Header) string {
+	values := url.Values{}
+	if h != nil {
+		for i := 0; i < h.Len(); i++ {
+			key, value := h.GetAt(i)
+			values.Add(key, value)
+		}
+	}
+	return values.Encode()
+}
+
+func decodeHeader(s string) (*frame.Header, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := frame.NewHeader()
+	for _, key := range keys {
+		for _, value := range values[key] {
+			h.Add(key, value)
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,320 @@
+package stomp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// PoolSelection determines which connection of a Pool is used for a
+// send.
+type PoolSelection int
+
+// Possible selections of a Pool.
+const (
+	RoundRobin PoolSelection = iota // Use the connections in turn
+	LeastBusy                       // Use the connection with the fewest sends in progress
+)
+
+// DefaultPoolHealthCheckInterval is the interval at which a Pool looks
+// for closed connections, if Pool.HealthCheckInterval is not set.
+const DefaultPoolHealthCheckInterval = 5 * time.Second
+
+// A Pool maintains several client connections to a STOMP server, for
+// programs that send messages at high rates from many goroutines.
+// Connections that are closed, for example because heart-beats from
+// the server stopped, are replaced in the background.
+//
+// Set the exported fields and then call Connect. A Pool is meant for
+// sending; subscriptions should use a connection of their own.
+type Pool struct {
+	Network   string              // Network of the server, passed to Dial
+	Addr      string              // Address of the server, passed to Dial
+	Options   []func(*Conn) error // Connect options, passed to Dial
+	Size      int                 // Number of connections, 1 if less than 1
+	Selection PoolSelection       // How connections are chosen for sends
+	Log       Logger              // Logger, the standard logger if nil
+
+	// Failover, if not nil, is used to dial the brokers it lists
+	// instead of Network and Addr.
+	Failover *Failover
+
+	// HealthCheckInterval is the interval at which closed connections
+	// are looked for and replaced, DefaultPoolHealthCheckInterval if
+	// zero. A connection that fails during a send is replaced at once.
+	HealthCheckInterval time.Duration
+
+	mutex   sync.Mutex
+	conns   []*pooledConn // nil entries are waiting to be replaced
+	next    int
+	closed  bool
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// pooledConn is a connection of a Pool.
+type pooledConn struct {
+	conn *Conn
+	busy int32 // number of sends in progress
+}
+
+// alive reports whether the connection is still open.
+func (pc *pooledConn) alive() bool {
+	select {
+	case <-pc.conn.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Connect dials all the connections of the pool. If any of them
+// fails, the others are closed again and the error is returned.
+func (p *Pool) Connect() error {
+	if p.Log == nil {
+		p.Log = log.StdLogger{}
+	}
+	size := p.Size
+	if size < 1 {
+		size = 1
+	}
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrAlreadyClosed
+	}
+	if p.stop != nil {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.mutex.Unlock()
+
+	conns := make([]*pooledConn, 0, size)
+	for len(conns) < size {
+		conn, err := p.dial()
+		if err != nil {
+			for _, pc := range conns {
+				pc.conn.MustDisconnect()
+			}
+			return err
+		}
+		conns = append(conns, &pooledConn{conn: conn})
+	}
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		for _, pc := range conns {
+			pc.conn.MustDisconnect()
+		}
+		return ErrAlreadyClosed
+	}
+	p.conns = conns
+	p.wake = make(chan struct{}, 1)
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	p.mutex.Unlock()
+
+	go p.run()
+	return nil
+}
+
+// Do calls fn with a connection of the pool, chosen according to
+// Selection. Returns ErrNotConnected if no connection is open, and
+// ErrAlreadyClosed if the pool has been disconnected.
+func (p *Pool) Do(fn func(conn *Conn) error) error {
+	pc, err := p.get()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt32(&pc.busy, 1)
+	defer atomic.AddInt32(&pc.busy, -1)
+	err = fn(pc.conn)
+	if err != nil && !pc.alive() {
+		p.check()
+	}
+	return err
+}
+
+// Send sends a message on a connection of the pool. See Conn.Send.
+func (p *Pool) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	return p.Do(func(conn *Conn) error {
+		return conn.Send(destination, contentType, body, opts...)
+	})
+}
+
+// SendWithReceipt sends a message on a connection of the pool and
+// waits for the server to acknowledge it. See Conn.SendWithReceipt.
+func (p *Pool) SendWithReceipt(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	return p.Do(func(conn *Conn) error {
+		return conn.SendWithReceipt(destination, contentType, body, opts...)
+	})
+}
+
+// Disconnect stops replacing connections and disconnects all the
+// connections of the pool. The first error is returned.
+func (p *Pool) Disconnect() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	stopped := p.stopped
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.mutex.Unlock()
+
+	// wait for a connection being dialed to be added
+	if stopped != nil {
+		<-stopped
+	}
+
+	p.mutex.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mutex.Unlock()
+
+	var err error
+	for _, pc := range conns {
+		if pc == nil {
+			continue
+		}
+		if e := pc.conn.Disconnect(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// get returns an open connection chosen according to Selection.
+func (p *Pool) get() (*pooledConn, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil, ErrAlreadyClosed
+	}
+
+	var best *pooledConn
+	n := len(p.conns)
+	for i := 0; i < n; i++ {
+		index := (p.next + i) % n
+		pc := p.conns[index]
+		if pc == nil || !pc.alive() {
+			continue
+		}
+		if p.Selection == RoundRobin {
+			p.next = (index + 1) % n
+			return pc, nil
+		}
+		if best == nil || atomic.LoadInt32(&pc.busy) < atomic.LoadInt32(&best.busy) {
+			best = pc
+		}
+	}
+	if best == nil {
+		p.checkLocked()
+		return nil, ErrNotConnected
+	}
+	// start with the next connection, to spread sends among
+	// connections that are equally busy
+	if n > 0 {
+		p.next = (p.next + 1) % n
+	}
+	return best, nil
+}
+
+// check makes the background goroutine replace closed connections
+// without waiting for the next health check.
+func (p *Pool) check() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.checkLocked()
+}
+
+func (p *Pool) checkLocked() {
+	if p.wake == nil {
+		return
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run replaces closed connections until the pool is disconnected.
+func (p *Pool) run() {
+	defer close(p.stopped)
+	interval := p.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultPoolHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+		p.replace()
+	}
+}
+
+// replace dials new connections in place of the closed ones.
+func (p *Pool) replace() {
+	p.mutex.Lock()
+	var dead []int
+	for i, pc := range p.conns {
+		if pc == nil || !pc.alive() {
+			dead = append(dead, i)
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, i := range dead {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		if !globalReconnectBudget.acquire(p.stop) {
+			return
+		}
+		conn, err := p.dial()
+		globalReconnectBudget.release(err == nil)
+		if err != nil {
+			p.Log.Warningf("pool: replacing connection to %s failed: %v", p.addr(), err)
+			p.mutex.Lock()
+			p.conns[i] = nil
+			p.mutex.Unlock()
+			continue
+		}
+		p.mutex.Lock()
+		p.conns[i] = &pooledConn{conn: conn}
+		p.mutex.Unlock()
+		p.Log.Infof("pool: replaced connection to %s", p.addr())
+	}
+}
+
+func (p *Pool) dial() (*Conn, error) {
+	if p.Failover != nil {
+		return p.Failover.Dial(p.Options...)
+	}
+	return Dial(p.Network, p.Addr, p.Options...)
+}
+
+// addr returns the address of the server, for logging.
+func (p *Pool) addr() string {
+	if p.Failover != nil {
+		return p.Failover.String()
+	}
+	return p.Addr
+}
@@ -0,0 +1,136 @@
+package stomp
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+
+	. "gopkg.in/check.v1"
+)
+
+// servePool accepts connections on l and answers them as a STOMP
+// server, giving each connection its number as the session. The
+// connections are sent to accepted, and the sessions of SEND frames to
+// sends.
+func servePool(c *C, l net.Listener, accepted chan<- net.Conn, sends chan<- string) {
+	for i := 1; ; i++ {
+		rw, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- rw
+		go func(rw net.Conn, session string) {
+			defer rw.Close()
+			reader := frame.NewReader(rw)
+			writer := frame.NewWriter(rw)
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+			writer.Write(frame.New(frame.CONNECTED,
+				frame.Version, "1.2", frame.Session, session))
+			for {
+				f, err := reader.Read()
+				if err != nil {
+					return
+				}
+				switch f.Command {
+				case frame.SEND:
+					sends <- session
+				case frame.DISCONNECT:
+					writer.Write(frame.New(frame.RECEIPT,
+						frame.ReceiptId, f.Header.Get(frame.Receipt)))
+					return
+				}
+			}
+		}(rw, strconv.Itoa(i))
+	}
+}
+
+func (s *StompSuite) TestPool(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	accepted := make(chan net.Conn, 10)
+	sends := make(chan string, 10)
+	go servePool(c, l, accepted, sends)
+
+	p := &Pool{
+		Network:             "tcp",
+		Addr:                l.Addr().String(),
+		Size:                2,
+		HealthCheckInterval: 10 * time.Millisecond,
+		Log:                 WithLevel(log.StdLogger{}, LevelError),
+	}
+	c.Assert(p.Connect(), IsNil)
+	first := <-accepted
+	<-accepted
+
+	// the connections are used in turn
+	var sessions []string
+	for i := 0; i < 4; i++ {
+		c.Assert(p.Do(func(conn *Conn) error {
+			sessions = append(sessions, conn.Session())
+			return nil
+		}), IsNil)
+	}
+	c.Check(sessions, DeepEquals, []string{"1", "2", "1", "2"})
+	c.Assert(p.Send("/queue/test", "", nil), IsNil)
+	c.Check(<-sends, Equals, "1")
+
+	// a closed connection is replaced
+	first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		c.Fatal("connection not replaced")
+	}
+	session := func() string {
+		var session string
+		err := p.Do(func(conn *Conn) error {
+			session = conn.Session()
+			return nil
+		})
+		c.Assert(err, IsNil)
+		return session
+	}
+	// until the replacement has been added, the other connection is used
+	deadline := time.Now().Add(5 * time.Second)
+	for session() != "3" {
+		c.Assert(time.Now().Before(deadline), Equals, true)
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(session(), Equals, "2")
+	c.Check(session(), Equals, "3")
+
+	c.Assert(p.Disconnect(), IsNil)
+	c.Check(p.Send("/queue/test", "", nil), Equals, ErrAlreadyClosed)
+	c.Check(p.Connect(), Equals, ErrAlreadyClosed)
+}
+
+func (s *StompSuite) TestPoolLeastBusy(c *C) {
+	conns := make([]*pooledConn, 3)
+	for i := range conns {
+		conns[i] = &pooledConn{conn: &Conn{done: make(chan struct{})}}
+	}
+	conns[0].busy = 2
+	conns[1].busy = 1
+	conns[2].busy = 3
+	p := &Pool{Selection: LeastBusy, conns: conns}
+	pc, err := p.get()
+	c.Assert(err, IsNil)
+	c.Check(pc, Equals, conns[1])
+
+	// closed connections are skipped
+	close(conns[1].conn.done)
+	pc, err = p.get()
+	c.Assert(err, IsNil)
+	c.Check(pc, Equals, conns[0])
+
+	close(conns[0].conn.done)
+	close(conns[2].conn.done)
+	_, err = p.get()
+	c.Check(err, Equals, ErrNotConnected)
+}
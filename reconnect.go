@@ -0,0 +1,178 @@
+package stomp
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Default values for reconnect backoff.
+const (
+	DefaultReconnectMinDelay = 100 * time.Millisecond
+	DefaultReconnectMaxDelay = 30 * time.Second
+)
+
+// DefaultReconnectTimeout is the time allowed for each attempt to
+// reconnect, unless ReconnectingConn.AttemptTimeout is set.
+const DefaultReconnectTimeout = 30 * time.Second
+
+// Backoff calculates the delay between successive reconnect attempts.
+// It implements the "decorrelated jitter" algorithm, where each delay
+// is chosen at random between Min and three times the previous delay,
+// capped at Max. Randomizing the delay prevents many clients that lost
+// their connection at the same time from reconnecting in lock-step
+// against a recovering broker.
+//
+// The zero value is ready to use with the default delays. A Backoff
+// is safe for concurrent use.
+type Backoff struct {
+	Min time.Duration // Minimum delay, DefaultReconnectMinDelay if zero
+	Max time.Duration // Maximum delay, DefaultReconnectMaxDelay if zero
+
+	mutex sync.Mutex
+	prev  time.Duration
+	rand  *rand.Rand
+}
+
+// Next returns the delay to wait before the next reconnect attempt.
+func (b *Backoff) Next() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	min, max := b.Min, b.Max
+	if min <= 0 {
+		min = DefaultReconnectMinDelay
+	}
+	if max <= 0 {
+		max = DefaultReconnectMaxDelay
+	}
+	if max < min {
+		max = min
+	}
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	prev := b.prev
+	if prev < min {
+		prev = min
+	}
+	upper := prev * 3
+	if upper > max || upper < prev {
+		// capped, or overflowed
+		upper = max
+	}
+
+	delay := min
+	if upper > min {
+		delay += time.Duration(b.rand.Int63n(int64(upper - min)))
+	}
+	b.prev = delay
+	return delay
+}
+
+// Reset causes the next delay to start from Min again. Call Reset
+// after a successful reconnect.
+func (b *Backoff) Reset() {
+	b.mutex.Lock()
+	b.prev = 0
+	b.mutex.Unlock()
+}
+
+// ReconnectStats contains process-wide counters describing reconnect
+// activity across all client connections.
+type ReconnectStats struct {
+	Attempts   uint64 // Number of reconnect attempts started
+	Successes  uint64 // Number of reconnect attempts that succeeded
+	Failures   uint64 // Number of reconnect attempts that failed
+	Throttled  uint64 // Number of attempts that had to wait for the reconnect budget
+	InProgress int64  // Number of reconnect attempts currently in progress
+}
+
+// reconnectBudget limits the number of reconnect attempts that can be
+// in progress at the same time across the whole process.
+type reconnectBudget struct {
+	mutex sync.Mutex
+	freed chan struct{} // closed when room may have been made, nil if no attempt waits
+	max   int
+	inUse int
+	stats ReconnectStats
+}
+
+var globalReconnectBudget = newReconnectBudget()
+
+func newReconnectBudget() *reconnectBudget {
+	return &reconnectBudget{}
+}
+
+// SetMaxConcurrentReconnects limits the number of reconnect attempts
+// that can be in progress at the same time across all client connections
+// in this process. Additional attempts wait until an attempt completes.
+// A value of zero or less (the default) means no limit.
+func SetMaxConcurrentReconnects(n int) {
+	b := globalReconnectBudget
+	b.mutex.Lock()
+	b.max = n
+	b.wake()
+	b.mutex.Unlock()
+}
+
+// ReconnectMetrics returns a snapshot of the process-wide reconnect counters.
+func ReconnectMetrics() ReconnectStats {
+	b := globalReconnectBudget
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stats := b.stats
+	stats.InProgress = int64(b.inUse)
+	return stats
+}
+
+// acquire blocks until a reconnect attempt is permitted by the budget,
+// and returns true, or until stop is closed, and returns false.
+func (b *reconnectBudget) acquire(stop <-chan struct{}) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.max > 0 && b.inUse >= b.max {
+		b.stats.Throttled++
+		for b.max > 0 && b.inUse >= b.max {
+			if b.freed == nil {
+				b.freed = make(chan struct{})
+			}
+			freed := b.freed
+			b.mutex.Unlock()
+			select {
+			case <-freed:
+			case <-stop:
+				b.mutex.Lock()
+				return false
+			}
+			b.mutex.Lock()
+		}
+	}
+	b.stats.Attempts++
+	b.inUse++
+	return true
+}
+
+// release records the outcome of a reconnect attempt and makes room
+// for another attempt.
+func (b *reconnectBudget) release(success bool) {
+	b.mutex.Lock()
+	b.inUse--
+	if success {
+		b.stats.Successes++
+	} else {
+		b.stats.Failures++
+	}
+	b.wake()
+	b.mutex.Unlock()
+}
+
+// wake lets the attempts waiting for the budget check it again.
+// Called with the mutex held.
+func (b *reconnectBudget) wake() {
+	if b.freed != nil {
+		close(b.freed)
+		b.freed = nil
+	}
+}
@@ -0,0 +1,58 @@
+package stomp
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestBackoffWithinBounds(c *C) {
+	b := &Backoff{Min: 10 * time.Millisecond, Max: 200 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		d := b.Next()
+		c.Assert(d >= b.Min, Equals, true)
+		c.Assert(d <= b.Max, Equals, true)
+	}
+	b.Reset()
+	c.Assert(b.Next() <= 30*time.Millisecond, Equals, true)
+}
+
+func (s *StompSuite) TestReconnectBudget(c *C) {
+	b := newReconnectBudget()
+	b.max = 1
+	c.Assert(b.acquire(nil), Equals, true)
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- b.acquire(nil)
+	}()
+
+	select {
+	case <-acquired:
+		c.Fatal("expected second attempt to wait for budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.release(false)
+	c.Check(<-acquired, Equals, true)
+
+	// an attempt waiting for the budget gives up when stopped
+	stop := make(chan struct{})
+	go func() {
+		acquired <- b.acquire(stop)
+	}()
+	select {
+	case <-acquired:
+		c.Fatal("expected third attempt to wait for budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(stop)
+	c.Check(<-acquired, Equals, false)
+	b.release(true)
+
+	c.Check(b.stats.Attempts, Equals, uint64(2))
+	c.Check(b.stats.Throttled, Equals, uint64(2))
+	c.Check(b.stats.Successes, Equals, uint64(1))
+	c.Check(b.stats.Failures, Equals, uint64(1))
+	c.Check(b.inUse, Equals, 0)
+}
@@ -0,0 +1,532 @@
+package stomp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// ConnState describes the state of a ReconnectingConn.
+type ConnState int
+
+// Possible states of a ReconnectingConn.
+const (
+	StateConnecting   ConnState = iota // Dialing the server for the first time
+	StateConnected                     // Connected to the server
+	StateReconnecting                  // Connection lost, dialing the server again
+	StateClosed                        // Disconnected by the calling program
+)
+
+// String returns a string representation of the connection state.
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// A ReconnectingConn is a client connection to a STOMP server that
+// dials the server again whenever the connection is lost, waiting
+// between attempts as directed by Backoff. Once connected again, the
+// active subscriptions are replayed with the same ids, destinations,
+// acknowledgement modes and options, and messages continue to arrive
+// on the same channels.
+//
+// Set the exported fields and then call Connect. Messages sent while
+// the connection is being restored fail with ErrNotConnected; it is up
+// to the calling program to send them again.
+type ReconnectingConn struct {
+	Network string              // Network of the server, passed to Dial
+	Addr    string              // Address of the server, passed to Dial
+	Options []func(*Conn) error // Connect options, passed to Dial
+	Backoff *Backoff            // Delays between attempts to connect, the default delays if nil
+	Log     Logger              // Logger, the standard logger if nil
+
+	// AttemptTimeout limits how long each attempt to reconnect can
+	// take, DefaultReconnectTimeout if zero. An attempt holds a place
+	// in the budget set by SetMaxConcurrentReconnects while it lasts.
+	AttemptTimeout time.Duration
+
+	// Failover, if not nil, is used to dial the brokers it lists
+	// instead of Network and Addr.
+	Failover *Failover
+
+	// OnStateChange, if not nil, is called whenever the state of the
+	// connection changes. When the connection is lost, err describes
+	// the failure. Calls are made one at a time, in order.
+	OnStateChange func(state ConnState, err error)
+
+	mutex   sync.Mutex
+	conn    *Conn
+	state   ConnState
+	subs    []*ReconnectingSubscription
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Connect dials the server and, if successful, keeps the connection
+// open until Disconnect is called. An error is returned if the first
+// attempt to connect fails; the calling program can call Connect again.
+func (rc *ReconnectingConn) Connect() error {
+	if rc.Log == nil {
+		rc.Log = log.StdLogger{}
+	}
+	if rc.Backoff == nil {
+		rc.Backoff = &Backoff{}
+	}
+
+	rc.mutex.Lock()
+	if rc.state == StateClosed {
+		rc.mutex.Unlock()
+		return ErrAlreadyClosed
+	}
+	if rc.stop != nil {
+		rc.mutex.Unlock()
+		return nil
+	}
+	rc.mutex.Unlock()
+
+	rc.notify(StateConnecting, nil)
+	conn, err := rc.dial()
+	if err != nil {
+		return err
+	}
+
+	rc.mutex.Lock()
+	if rc.state == StateClosed {
+		rc.mutex.Unlock()
+		conn.MustDisconnect()
+		return ErrAlreadyClosed
+	}
+	rc.stop = make(chan struct{})
+	rc.stopped = make(chan struct{})
+	err = rc.attach(conn)
+	rc.mutex.Unlock()
+	if err != nil {
+		conn.MustDisconnect()
+	}
+
+	go rc.run(conn)
+	return nil
+}
+
+// State returns the current state of the connection.
+func (rc *ReconnectingConn) State() ConnState {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.state
+}
+
+// Conn returns the current connection to the server, or nil if the
+// connection is being restored.
+func (rc *ReconnectingConn) Conn() *Conn {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.conn
+}
+
+// Send sends a message on the current connection. See Conn.Send.
+// Returns ErrNotConnected if the connection is being restored.
+func (rc *ReconnectingConn) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	conn, err := rc.current()
+	if err != nil {
+		return err
+	}
+	return conn.Send(destination, contentType, body, opts...)
+}
+
+// Subscribe creates a subscription that is replayed each time the
+// connection is restored. See Conn.Subscribe. If the connection is
+// being restored, the subscription is created once it has been.
+func (rc *ReconnectingConn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Frame) error) (*ReconnectingSubscription, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.state == StateClosed {
+		return nil, ErrAlreadyClosed
+	}
+
+	rs := &ReconnectingSubscription{
+		destination: destination,
+		ackMode:     ack,
+		opts:        opts,
+		rc:          rc,
+		stop:        make(chan struct{}),
+	}
+
+	// Find out the id and the channel capacity now, so that they stay
+	// the same when the subscription is replayed.
+	f := frame.New(frame.SUBSCRIBE)
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+	capacity, _ := subscriptionSettings(f)
+	rs.C = make(chan *Message, capacity)
+	if id, ok := f.Header.Contains(ReplyToHeader); ok {
+		rs.id = id
+	} else if id, ok := f.Header.Contains(frame.Id); ok {
+		rs.id = id
+	} else {
+		rs.id = allocateId()
+	}
+
+	if rc.conn != nil {
+		if err := rs.attach(rc.conn); err != nil {
+			return nil, err
+		}
+	}
+	rc.subs = append(rc.subs, rs)
+	return rs, nil
+}
+
+// Ack acknowledges a message on the connection that received it.
+// See Conn.Ack.
+func (rc *ReconnectingConn) Ack(m *Message) error {
+	if m.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return m.Conn.Ack(m)
+}
+
+// Nack negatively acknowledges a message on the connection that
+// received it. See Conn.Nack.
+func (rc *ReconnectingConn) Nack(m *Message) error {
+	if m.Conn == nil {
+		return ErrNotReceivedMessage
+	}
+	return m.Conn.Nack(m)
+}
+
+// Disconnect stops restoring the connection, disconnects from the
+// server and closes the channels of all subscriptions.
+func (rc *ReconnectingConn) Disconnect() error {
+	rc.mutex.Lock()
+	if rc.state == StateClosed {
+		rc.mutex.Unlock()
+		return nil
+	}
+	rc.state = StateClosed
+	conn := rc.conn
+	rc.conn = nil
+	subs := rc.subs
+	rc.subs = nil
+	stopped := rc.stopped
+	if rc.stop != nil {
+		close(rc.stop)
+	}
+	rc.mutex.Unlock()
+
+	var err error
+	if conn != nil {
+		err = conn.Disconnect()
+	}
+	if stopped != nil {
+		<-stopped
+	}
+	for _, rs := range subs {
+		rs.close()
+	}
+	rc.notify(StateClosed, nil)
+	return err
+}
+
+// current returns the current connection, or an error if there is none.
+func (rc *ReconnectingConn) current() (*Conn, error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	switch {
+	case rc.state == StateClosed:
+		return nil, ErrAlreadyClosed
+	case rc.conn == nil:
+		return nil, ErrNotConnected
+	}
+	return rc.conn, nil
+}
+
+// attach makes conn the current connection and replays the
+// subscriptions on it. Called with the mutex held.
+func (rc *ReconnectingConn) attach(conn *Conn) error {
+	rc.conn = conn
+	rc.state = StateConnected
+	for _, rs := range rc.subs {
+		if err := rs.attach(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run waits for the connection to be lost and restores it, until
+// Disconnect is called.
+func (rc *ReconnectingConn) run(conn *Conn) {
+	defer close(rc.stopped)
+	rc.notify(StateConnected, nil)
+
+	for {
+		select {
+		case <-conn.done:
+		case <-rc.stop:
+			return
+		}
+
+		rc.mutex.Lock()
+		if rc.state == StateClosed {
+			rc.mutex.Unlock()
+			return
+		}
+		rc.conn = nil
+		rc.state = StateReconnecting
+		rc.mutex.Unlock()
+
+		rc.Log.Warningf("connection to %s lost, reconnecting", rc.addr())
+		rc.notify(StateReconnecting, ErrClosedUnexpectedly)
+
+		conn = rc.reconnect()
+		if conn == nil {
+			return
+		}
+		rc.Log.Infof("reconnected to %s", rc.addr())
+		rc.notify(StateConnected, nil)
+	}
+}
+
+// reconnect dials the server until it succeeds or Disconnect is
+// called, in which case it returns nil.
+func (rc *ReconnectingConn) reconnect() *Conn {
+	for {
+		select {
+		case <-time.After(rc.Backoff.Next()):
+		case <-rc.stop:
+			return nil
+		}
+
+		if !globalReconnectBudget.acquire(rc.stop) {
+			return nil
+		}
+		conn, err := rc.dialWithin(rc.attemptTimeout())
+		if err == ErrAlreadyClosed {
+			globalReconnectBudget.release(false)
+			return nil
+		}
+		if err == nil {
+			rc.mutex.Lock()
+			if rc.state == StateClosed {
+				rc.mutex.Unlock()
+				globalReconnectBudget.release(true)
+				conn.MustDisconnect()
+				return nil
+			}
+			err = rc.attach(conn)
+			if err != nil {
+				rc.conn = nil
+				rc.state = StateReconnecting
+			}
+			rc.mutex.Unlock()
+			if err != nil {
+				conn.MustDisconnect()
+			}
+		}
+		globalReconnectBudget.release(err == nil)
+
+		if err == nil {
+			rc.Backoff.Reset()
+			return conn
+		}
+		rc.Log.Warningf("reconnecting to %s: %v", rc.addr(), err)
+	}
+}
+
+// dial connects to the server, or to one of the Failover brokers.
+func (rc *ReconnectingConn) dial() (*Conn, error) {
+	if rc.Failover != nil {
+		return rc.Failover.Dial(rc.Options...)
+	}
+	return Dial(rc.Network, rc.Addr, rc.Options...)
+}
+
+// dialWithin dials the server like dial, but gives up once timeout has
+// passed or Disconnect is called, in which case it returns
+// ErrReconnectTimeout or ErrAlreadyClosed. A connection established
+// after giving up is disconnected.
+func (rc *ReconnectingConn) dialWithin(timeout time.Duration) (*Conn, error) {
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := rc.dial()
+		ch <- result{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-timer.C:
+		err = ErrReconnectTimeout
+	case <-rc.stop:
+		err = ErrAlreadyClosed
+	}
+	go func() {
+		if r := <-ch; r.conn != nil {
+			r.conn.MustDisconnect()
+		}
+	}()
+	return nil, err
+}
+
+// attemptTimeout returns the time allowed for each attempt to reconnect.
+func (rc *ReconnectingConn) attemptTimeout() time.Duration {
+	if rc.AttemptTimeout > 0 {
+		return rc.AttemptTimeout
+	}
+	return DefaultReconnectTimeout
+}
+
+// addr returns the address of the server, for logging.
+func (rc *ReconnectingConn) addr() string {
+	if rc.Failover != nil {
+		return rc.Failover.String()
+	}
+	return rc.Addr
+}
+
+// notify calls the state change callback, if any.
+func (rc *ReconnectingConn) notify(state ConnState, err error) {
+	if rc.OnStateChange != nil {
+		rc.OnStateChange(state, err)
+	}
+}
+
+// A ReconnectingSubscription is a subscription created by
+// ReconnectingConn.Subscribe. Messages are received on the C channel
+// across reconnects; the channel is closed when the subscription is
+// unsubscribed or the connection is disconnected.
+type ReconnectingSubscription struct {
+	C           chan *Message
+	id          string
+	destination string
+	ackMode     AckMode
+	opts        []func(*frame.Frame) error
+	rc          *ReconnectingConn
+	sub         *Subscription
+	stop        chan struct{}
+	wg          sync.WaitGroup
+	closeOnce   sync.Once
+}
+
+// Id returns the id of the subscription, which is the same on every
+// connection.
+func (rs *ReconnectingSubscription) Id() string {
+	return rs.id
+}
+
+// Destination returns the destination of the subscription.
+func (rs *ReconnectingSubscription) Destination() string {
+	return rs.destination
+}
+
+// AckMode returns the acknowledgement mode of the subscription.
+func (rs *ReconnectingSubscription) AckMode() AckMode {
+	return rs.ackMode
+}
+
+// Unsubscribe stops replaying the subscription, unsubscribes from the
+// current connection, if any, and closes the C channel.
+func (rs *ReconnectingSubscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
+	rc := rs.rc
+	rc.mutex.Lock()
+	found := false
+	for i, other := range rc.subs {
+		if other == rs {
+			rc.subs = append(rc.subs[:i], rc.subs[i+1:]...)
+			found = true
+			break
+		}
+	}
+	sub := rs.sub
+	rc.mutex.Unlock()
+	if !found {
+		return ErrCompletedSubscription
+	}
+
+	var err error
+	if sub != nil && sub.Active() {
+		err = sub.Unsubscribe(opts...)
+	}
+	rs.close()
+	return err
+}
+
+// attach subscribes on conn and forwards its messages to C. Called
+// with the connection mutex held.
+func (rs *ReconnectingSubscription) attach(conn *Conn) error {
+	opts := append(rs.opts[:len(rs.opts):len(rs.opts)], SubscribeOpt.Id(rs.id))
+	sub, err := conn.Subscribe(rs.destination, rs.ackMode, opts...)
+	if err != nil {
+		return err
+	}
+	rs.sub = sub
+	rs.wg.Add(1)
+	go rs.forward(sub)
+	return nil
+}
+
+// forward passes messages from sub to C until sub is closed. Errors
+// are not passed on: they mean that the connection is being lost, and
+// the subscription is replayed once it has been restored.
+func (rs *ReconnectingSubscription) forward(sub *Subscription) {
+	defer rs.wg.Done()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if msg.Err != nil {
+				continue
+			}
+			select {
+			case rs.C <- msg:
+			case <-rs.stop:
+				go drain(sub.C)
+				return
+			}
+		case <-rs.stop:
+			go drain(sub.C)
+			return
+		}
+	}
+}
+
+// drain discards messages until ch is closed, so that the subscription
+// that sends them is not blocked.
+func drain(ch chan *Message) {
+	for range ch {
+	}
+}
+
+// close stops forwarding messages and closes C.
+func (rs *ReconnectingSubscription) close() {
+	rs.closeOnce.Do(func() {
+		close(rs.stop)
+		rs.wg.Wait()
+		close(rs.C)
+	})
+}
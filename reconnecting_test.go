@@ -0,0 +1,160 @@
+package stomp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestConnStateString(c *C) {
+	c.Check(StateConnecting.String(), Equals, "connecting")
+	c.Check(StateConnected.String(), Equals, "connected")
+	c.Check(StateReconnecting.String(), Equals, "reconnecting")
+	c.Check(StateClosed.String(), Equals, "closed")
+	c.Check(ConnState(99).String(), Equals, "unknown")
+}
+
+func (s *StompSuite) TestReconnectingConn(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	subscribes := make(chan *frame.Frame, 2)
+	go func() {
+		// the first connection is dropped after the message is sent,
+		// the second one stays open until the client disconnects
+		for i := 0; i < 2; i++ {
+			rw, err := l.Accept()
+			if err != nil {
+				return
+			}
+			reader := frame.NewReader(rw)
+			writer := frame.NewWriter(rw)
+			f, err := reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, frame.CONNECT)
+			c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+			f, err = reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, frame.SUBSCRIBE)
+			subscribes <- f
+			c.Assert(writer.Write(frame.New(frame.MESSAGE,
+				frame.Subscription, f.Header.Get(frame.Id),
+				frame.Destination, "/queue/test",
+				frame.MessageId, fmt.Sprintf("m%d", i+1))), IsNil)
+
+			if i == 0 {
+				rw.Close()
+				continue
+			}
+			f, err = reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, frame.DISCONNECT)
+			c.Assert(writer.Write(frame.New(frame.RECEIPT,
+				frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+			rw.Close()
+		}
+	}()
+
+	states := make(chan ConnState, 10)
+	rc := &ReconnectingConn{
+		Network: "tcp",
+		Addr:    l.Addr().String(),
+		Backoff: &Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond},
+		Log:     WithLevel(log.StdLogger{}, LevelError),
+		OnStateChange: func(state ConnState, err error) {
+			if state == StateReconnecting {
+				c.Check(err, Equals, ErrClosedUnexpectedly)
+			}
+			states <- state
+		},
+	}
+	c.Assert(rc.Connect(), IsNil)
+
+	sub, err := rc.Subscribe("/queue/test", AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Check(msg.Header.Get(frame.MessageId), Equals, "m1")
+	msg = <-sub.C
+	c.Check(msg.Header.Get(frame.MessageId), Equals, "m2")
+	c.Check(rc.State(), Equals, StateConnected)
+
+	first, second := <-subscribes, <-subscribes
+	c.Check(first.Header.Get(frame.Id), Equals, sub.Id())
+	c.Check(second.Header.Get(frame.Id), Equals, sub.Id())
+	c.Check(second.Header.Get(frame.Destination), Equals, "/queue/test")
+	c.Check(second.Header.Get(frame.Ack), Equals, "client-individual")
+
+	c.Assert(rc.Disconnect(), IsNil)
+	_, ok := <-sub.C
+	c.Check(ok, Equals, false)
+	c.Check(rc.Send("/queue/test", "", nil), Equals, ErrAlreadyClosed)
+
+	expected := []ConnState{StateConnecting, StateConnected, StateReconnecting, StateConnected, StateClosed}
+	for _, state := range expected {
+		c.Check(<-states, Equals, state)
+	}
+}
+
+func (s *StompSuite) TestReconnectAttemptTimeout(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	// the first connection is dropped once connected, later ones are
+	// accepted but never answered
+	held := make(chan net.Conn, 100)
+	go func() {
+		rw, err := l.Accept()
+		if err != nil {
+			return
+		}
+		reader := frame.NewReader(rw)
+		writer := frame.NewWriter(rw)
+		_, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+		rw.Close()
+		for {
+			rw, err := l.Accept()
+			if err != nil {
+				return
+			}
+			held <- rw
+		}
+	}()
+	defer func() {
+		for len(held) > 0 {
+			(<-held).Close()
+		}
+	}()
+
+	failures := ReconnectMetrics().Failures
+	rc := &ReconnectingConn{
+		Network:        "tcp",
+		Addr:           l.Addr().String(),
+		Backoff:        &Backoff{Min: time.Millisecond, Max: 5 * time.Millisecond},
+		Log:            WithLevel(log.StdLogger{}, LevelError),
+		AttemptTimeout: 20 * time.Millisecond,
+	}
+	c.Assert(rc.Connect(), IsNil)
+
+	// attempts that time out give up their place in the budget
+	for start := time.Now(); ReconnectMetrics().Failures < failures+2; {
+		c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Check(rc.State(), Equals, StateReconnecting)
+
+	// an attempt in progress does not hold up Disconnect
+	start := time.Now()
+	c.Assert(rc.Disconnect(), IsNil)
+	c.Check(time.Since(start) < time.Second, Equals, true)
+}
@@ -0,0 +1,134 @@
+package stomp
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// CorrelationIdHeader is the header entry that Conn.Request uses to
+// match a reply with its request. A server that answers a request
+// copies it to the reply.
+const CorrelationIdHeader = "correlation-id"
+
+// TempQueuePrefix is the prefix of temporary queue destinations, which
+// brokers such as RabbitMQ create on demand for the connection that
+// sends a message with such a "reply-to" header entry. Replies to them
+// arrive without a SUBSCRIBE frame.
+const TempQueuePrefix = "/temp-queue/"
+
+// defaultReplyTo is the destination of replies to Conn.Request if the
+// ReplyTo connect option is not used.
+const defaultReplyTo = TempQueuePrefix + "replies"
+
+// Request sends a message to destination and waits for the reply,
+// which is a message with the same "correlation-id" header entry.
+// The "reply-to" header entry of the request is the destination set
+// by the ReplyTo connect option, which the connection subscribes to
+// when Request is first called.
+//
+// Request returns the error of ctx if it is done before the reply
+// arrives, and an error if the reply subscription ends. The options
+// are the same as for Conn.Send; the content type can be set with
+// SendOpt.Header.
+func (c *Conn) Request(ctx context.Context, destination string, body []byte, opts ...func(*frame.Frame) error) (*Message, error) {
+	replyTo, err := c.replySubscription(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id := allocateId()
+	ch := make(chan *Message, 1)
+	c.replyMutex.Lock()
+	if c.replies == nil {
+		// the reply subscription has just ended
+		c.replyMutex.Unlock()
+		return nil, ErrCompletedSubscription
+	}
+	c.replies[id] = ch
+	c.replyMutex.Unlock()
+	defer func() {
+		c.replyMutex.Lock()
+		delete(c.replies, id)
+		c.replyMutex.Unlock()
+	}()
+
+	opts = append(opts,
+		SendOpt.Header(ReplyToHeader, replyTo),
+		SendOpt.Header(CorrelationIdHeader, id))
+	if err := c.SendContext(ctx, destination, "", body, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Err != nil {
+			return nil, msg.Err
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// replySubscription subscribes to the destination of replies, if that
+// has not been done yet, and returns the destination.
+func (c *Conn) replySubscription(ctx context.Context) (string, error) {
+	c.replyMutex.Lock()
+	defer c.replyMutex.Unlock()
+	if c.replies != nil {
+		return c.replyTo, nil
+	}
+
+	var opts []func(*frame.Frame) error
+	if strings.HasPrefix(c.replyTo, TempQueuePrefix) {
+		opts = append(opts, SubscribeOpt.Header(ReplyToHeader, c.replyTo))
+	}
+	sub, err := c.SubscribeContext(ctx, c.replyTo, AckAuto, opts...)
+	if err != nil {
+		return "", err
+	}
+	c.replies = make(map[string]chan *Message)
+	go c.dispatchReplies(sub)
+	return c.replyTo, nil
+}
+
+// dispatchReplies passes the messages of the reply subscription to the
+// waiting requests, until the subscription ends.
+func (c *Conn) dispatchReplies(sub *Subscription) {
+	for msg := range sub.C {
+		if msg.Err != nil {
+			c.failReplies(msg)
+			return
+		}
+		id := msg.Header.Get(CorrelationIdHeader)
+		c.replyMutex.Lock()
+		ch, ok := c.replies[id]
+		c.replyMutex.Unlock()
+		if !ok {
+			c.log.Infof("ignored reply with correlation-id: %s", id)
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			// a reply has already been received
+		}
+	}
+	c.failReplies(sub.subscriptionErrorMessage("reply subscription ended"))
+}
+
+// failReplies passes msg, which carries an error, to all waiting
+// requests, and forgets the reply subscription.
+func (c *Conn) failReplies(msg *Message) {
+	c.replyMutex.Lock()
+	defer c.replyMutex.Unlock()
+	for _, ch := range c.replies {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	c.replies = nil
+}
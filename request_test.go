@@ -0,0 +1,90 @@
+package stomp
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/testutil"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestRequest(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		// no SUBSCRIBE frame is sent for a temporary queue
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(frame.Destination), Equals, "/queue/ping")
+		c.Check(f.Header.Get(ReplyToHeader), Equals, "/temp-queue/replies")
+		id := f.Header.Get(CorrelationIdHeader)
+		c.Check(id, Not(Equals), "")
+		for _, correlationId := range []string{"unknown", id} {
+			reply := frame.New(frame.MESSAGE,
+				frame.Subscription, "/temp-queue/replies",
+				CorrelationIdHeader, correlationId)
+			reply.Body = []byte("pong " + correlationId)
+			c.Assert(rw.Write(reply), IsNil)
+		}
+
+		// the second request is not answered
+		f, err = rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+	}()
+
+	msg, err := conn.Request(context.Background(), "/queue/ping", []byte("ping"))
+	c.Assert(err, IsNil)
+	c.Check(string(msg.Body), Equals, "pong "+msg.Header.Get(CorrelationIdHeader))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = conn.Request(ctx, "/queue/ping", []byte("ping"))
+	c.Check(err, Equals, context.DeadlineExceeded)
+	<-stop
+	conn.MustDisconnect()
+}
+
+func (s *StompSuite) TestRequestReplyTo(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		c.Check(f.Header.Get(frame.Destination), Equals, "/queue/replies")
+		subscription := f.Header.Get(frame.Id)
+
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(ReplyToHeader), Equals, "/queue/replies")
+		c.Check(f.Header.Get(frame.ContentType), Equals, "text/plain")
+		c.Assert(writer.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, subscription,
+			CorrelationIdHeader, f.Header.Get(CorrelationIdHeader))), IsNil)
+
+		// the connection fails while a request is waiting
+		_, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(writer.Write(frame.New(frame.ERROR, frame.Message, "failed")), IsNil)
+	}()
+
+	conn, err := Connect(fc1, ConnOpt.ReplyTo("/queue/replies"))
+	c.Assert(err, IsNil)
+	_, err = conn.Request(context.Background(), "/queue/ping", nil,
+		SendOpt.Header(frame.ContentType, "text/plain"))
+	c.Assert(err, IsNil)
+
+	_, err = conn.Request(context.Background(), "/queue/ping", nil)
+	c.Check(err, ErrorMatches, "failed")
+}
@@ -23,6 +23,11 @@ var SendOpt struct {
 	// can be specified multiple times if multiple custom header entries
 	// are required.
 	Header func(key, value string) func(*frame.Frame) error
+
+	// Persistent specifies that the server should keep the message in
+	// durable storage until it is delivered. Servers that do not support
+	// durable storage ignore this option.
+	Persistent func(*frame.Frame) error
 }
 
 func init() {
@@ -52,4 +57,12 @@ func init() {
 			return nil
 		}
 	}
+
+	SendOpt.Persistent = func(f *frame.Frame) error {
+		if f.Command != frame.SEND {
+			return ErrInvalidCommand
+		}
+		f.Header.Set(frame.Persistent, "true")
+		return nil
+	}
 }
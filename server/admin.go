@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Errors returned by administrative operations.
+var (
+	ErrNotServing = errors.New("server is not serving")
+	ErrNotQueue   = errors.New("destination is not a queue")
+	ErrNoClient   = errors.New("no such client connection")
+	ErrNoMessage  = errors.New("no such message")
+)
+
+// PauseQueue stops the dispatch of messages from a queue to its
+// subscribers. Messages sent to the queue while it is paused are
+// accepted and stored, and are delivered once the queue is resumed.
+// This is useful during consumer maintenance windows.
+func (s *Server) PauseQueue(destination string) error {
+	if !isQueueDestination(destination) {
+		return ErrNotQueue
+	}
+	return s.call(func(proc *requestProcessor) error {
+		proc.qm.Find(destination).Pause()
+		return nil
+	})
+}
+
+// ResumeQueue resumes the dispatch of messages from a queue that
+// was paused by PauseQueue.
+func (s *Server) ResumeQueue(destination string) error {
+	if !isQueueDestination(destination) {
+		return ErrNotQueue
+	}
+	return s.call(func(proc *requestProcessor) error {
+		return proc.qm.Find(destination).Resume()
+	})
+}
+
+// QueuePaused reports whether dispatch of messages from a queue has
+// been paused by PauseQueue.
+func (s *Server) QueuePaused(destination string) (bool, error) {
+	if !isQueueDestination(destination) {
+		return false, ErrNotQueue
+	}
+	var paused bool
+	err := s.call(func(proc *requestProcessor) error {
+		paused = proc.qm.Find(destination).Paused()
+		return nil
+	})
+	return paused, err
+}
+
+// DeleteMessage removes the message with a "message-id" header from
+// a queue, before it is sent to a subscriber. Returns ErrNoMessage if
+// the queue has no such message.
+func (s *Server) DeleteMessage(destination, messageId string) error {
+	if !isQueueDestination(destination) {
+		return ErrNotQueue
+	}
+	return s.call(func(proc *requestProcessor) error {
+		removed, err := proc.qm.Find(destination).Remove(func(f *frame.Frame) bool {
+			return f.Header.Get(frame.MessageId) == messageId
+		})
+		if err == nil && len(removed) == 0 {
+			err = ErrNoMessage
+		}
+		// nothing refers to a message once it has left the queue
+		for _, f := range removed {
+			f.Release()
+		}
+		return err
+	})
+}
+
+// MoveMessages moves up to limit messages, or all of them if limit is
+// zero or less, from the head of a queue to another destination, and
+// returns the number of messages moved. This is useful to send the
+// messages of a dead letter queue back to where they came from once
+// their consumer has been fixed. If to is empty, each message is moved
+// to the destination in its "original-destination" header, which is
+// removed, and messages without one stay in the queue.
+func (s *Server) MoveMessages(from, to string, limit int) (int, error) {
+	if !isQueueDestination(from) {
+		return 0, ErrNotQueue
+	}
+	var moved int
+	err := s.call(func(proc *requestProcessor) error {
+		removed, err := proc.qm.Find(from).Remove(func(f *frame.Frame) bool {
+			if limit > 0 && moved == limit {
+				return false
+			}
+			if to == "" && f.Header.Get(OriginalDestinationHeader) == "" {
+				return false
+			}
+			moved++
+			return true
+		})
+		for _, f := range removed {
+			destination := to
+			if destination == "" {
+				destination = f.Header.Get(OriginalDestinationHeader)
+				f.Header.Del(OriginalDestinationHeader)
+			} else if _, ok := f.Header.Contains(OriginalDestinationHeader); !ok {
+				f.Header.Set(OriginalDestinationHeader, from)
+			}
+			f.Header.Set(frame.Destination, destination)
+			proc.touch(destination)
+			if isQueueDestination(destination) {
+				if err := proc.qm.Find(destination).Enqueue(f); err != nil {
+					proc.destinationLog(destination).Errorf("move from %s failed: %v", from, err)
+					continue
+				}
+				proc.stored(destination)
+			} else {
+				proc.tm.Enqueue(destination, f)
+			}
+		}
+		return err
+	})
+	return moved, err
+}
+
+// DisconnectClient closes the connection of a STOMP client, which
+// is identified by its id, as returned by Connections, or by its
+// remote address. The client's subscriptions are removed and the
+// messages that it has not acknowledged are requeued, as when a client
+// goes away, so that operators can disconnect stuck or abusive
+// clients. Returns ErrNoClient if no connected client matches.
+func (s *Server) DisconnectClient(client string) error {
+	return s.call(func(proc *requestProcessor) error {
+		for c := range proc.clients {
+			if c.Id() == client || c.RemoteAddr().String() == client {
+				proc.log.Infof("disconnecting client %s (%s)", c.Id(), c.RemoteAddr())
+				// the client might have gone away already
+				c.Close()
+				return nil
+			}
+		}
+		return ErrNoClient
+	})
+}
+
+// Runs fn on the request processor go-routine, so that it has
+// exclusive access to the processor's destinations, and waits
+// for it to complete.
+func (s *Server) call(fn func(proc *requestProcessor) error) error {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return ErrNotServing
+	}
+
+	result := make(chan error, 1)
+	select {
+	case proc.calls <- func() { result <- fn(proc) }:
+		return <-result
+	case <-proc.stopped:
+		return ErrNotServing
+	}
+}
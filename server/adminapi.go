@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Realm of the HTTP basic authentication challenge of the admin API.
+const adminRealm = "stomp admin"
+
+// Names of the protocol features in the admin API's configuration.
+var featureNames = []struct {
+	feature Feature
+	name    string
+}{
+	{FeatureTransactions, "transactions"},
+	{FeatureNack, "nack"},
+	{FeatureSubscribe, "subscribe"},
+	{FeatureSend, "send"},
+}
+
+// The configuration of a server, as served by the admin API.
+type adminConfig struct {
+	Addr                   string   `json:"addr"`
+	HeartBeat              string   `json:"heart_beat"`
+	Authentication         bool     `json:"authentication"`
+	DisabledFeatures       []string `json:"disabled_features"`
+	QueueStorage           string   `json:"queue_storage"`
+	Validation             string   `json:"validation"`
+	HonorPersistentHeader  bool     `json:"honor_persistent_header"`
+	MaxMemoryMessages      int      `json:"max_memory_messages"`
+	PageDir                string   `json:"page_dir"`
+	SnapshotFile           string   `json:"snapshot_file"`
+	IdleDestinationTimeout string   `json:"idle_destination_timeout"`
+	CompactInterval        string   `json:"compact_interval"`
+	SyncInterval           string   `json:"sync_interval"`
+	Clustered              bool     `json:"clustered"`
+}
+
+// A connected client with its subscriptions, as served by the admin API.
+type adminConnection struct {
+	Connection    ConnectionInfo     `json:"connection"`
+	Subscriptions []SubscriptionInfo `json:"subscriptions"`
+}
+
+// AdminHandler returns an HTTP handler for the admin API, which serves
+// JSON descriptions of the server for dashboards and operations
+// tooling. The paths are relative to the handler, which can be mounted
+// below a prefix with http.StripPrefix:
+//
+//	GET /connections    connected clients, see Connections
+//	GET /connections/{id or remote address}
+//	                    a client with its subscriptions, see Connection
+//	DELETE /connections/{id or remote address}
+//	                    disconnect a client, see DisconnectClient
+//	GET /subscriptions  subscriptions of connected clients, see Subscriptions
+//	GET /queues         queues with their depth and rates, see Queues
+//	GET /messages?destination=D[&limit=N]
+//	                    headers of messages waiting in a queue, see QueueMessages
+//	DELETE /messages?destination=D&id=ID
+//	                    delete a message from a queue, see DeleteMessage
+//	POST /messages/move?from=D[&to=T][&limit=N]
+//	                    move messages between destinations, see MoveMessages
+//	GET /policies       destination policies
+//	GET /config         configuration of the server
+//	POST /reload        reload the configuration, see Server.OnReload
+//	GET /wirelog        connections and destinations whose frames are logged
+//	POST /wirelog?connection=ID or ?destination=PATTERN
+//	                    start logging frames, see SetConnectionWireLogging
+//	DELETE /wirelog?connection=ID or ?destination=PATTERN
+//	                    stop logging frames
+//
+// Requests must carry HTTP basic authentication credentials accepted
+// by auth, which is separate from the authenticator of STOMP clients
+// so that operators need not be STOMP users. If auth is nil, requests
+// are not authenticated, and the handler should only be served on a
+// private network.
+func (s *Server) AdminHandler(auth Authenticator) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/connections", adminGet(func() (interface{}, error) {
+		return s.Connections()
+	}))
+	mux.HandleFunc("/connections/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodDelete) {
+			return
+		}
+		client := strings.TrimPrefix(r.URL.Path, "/connections/")
+		if r.Method != http.MethodDelete {
+			info, subs, err := s.Connection(client)
+			if subs == nil {
+				subs = []SubscriptionInfo{}
+			}
+			writeAdminJSON(w, adminConnection{info, subs}, err)
+			return
+		}
+		if err := s.DisconnectClient(client); err != nil {
+			writeAdminJSON(w, nil, err)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.Handle("/subscriptions", adminGet(func() (interface{}, error) {
+		return s.Subscriptions()
+	}))
+	mux.Handle("/queues", adminGet(func() (interface{}, error) {
+		return s.Queues()
+	}))
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodDelete) {
+			return
+		}
+		query := r.URL.Query()
+		destination := query.Get("destination")
+		if r.Method == http.MethodDelete {
+			if err := s.DeleteMessage(destination, query.Get("id")); err != nil {
+				writeAdminJSON(w, nil, err)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+		limit, ok := adminLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+		messages, err := s.QueueMessages(destination, limit)
+		if messages == nil {
+			messages = []MessageInfo{}
+		}
+		writeAdminJSON(w, messages, err)
+	})
+	mux.HandleFunc("/messages/move", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		query := r.URL.Query()
+		limit, ok := adminLimit(w, query.Get("limit"))
+		if !ok {
+			return
+		}
+		moved, err := s.MoveMessages(query.Get("from"), query.Get("to"), limit)
+		writeAdminJSON(w, map[string]int{"moved": moved}, err)
+	})
+	mux.Handle("/policies", adminGet(func() (interface{}, error) {
+		policies := s.policies()
+		if policies == nil {
+			return []DestinationPolicy{}, nil
+		}
+		return policies, nil
+	}))
+	mux.Handle("/config", adminGet(func() (interface{}, error) {
+		return s.adminConfig(), nil
+	}))
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		if s.OnReload == nil {
+			http.Error(w, "reloading is not supported", http.StatusNotImplemented)
+			return
+		}
+		if err := s.OnReload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/wirelog", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			writeAdminJSON(w, s.WireLogging(), nil)
+			return
+		}
+		enabled := r.Method == http.MethodPost
+		query := r.URL.Query()
+		switch {
+		case query.Get("connection") != "":
+			if err := s.SetConnectionWireLogging(query.Get("connection"), enabled); err != nil {
+				writeAdminJSON(w, nil, err)
+				return
+			}
+		case query.Get("destination") != "":
+			s.SetDestinationWireLogging(query.Get("destination"), enabled)
+		default:
+			http.Error(w, "connection or destination required", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return adminAuth(auth, mux)
+}
+
+// Returns a handler that passes requests carrying HTTP basic
+// authentication credentials accepted by auth to h. If auth is nil,
+// all requests are passed to h.
+func adminAuth(auth Authenticator, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth != nil {
+			login, passcode, ok := r.BasicAuth()
+			if !ok || !auth.Authenticate(login, passcode) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", adminRealm))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns the configuration of the server.
+func (s *Server) adminConfig() adminConfig {
+	config := adminConfig{
+		Addr:                   s.Addr,
+		HeartBeat:              s.HeartBeat.String(),
+		Authentication:         s.authenticator() != nil,
+		DisabledFeatures:       []string{},
+		QueueStorage:           "memory",
+		Validation:             s.Validation.String(),
+		HonorPersistentHeader:  s.HonorPersistentHeader,
+		MaxMemoryMessages:      s.MaxMemoryMessages,
+		PageDir:                s.PageDir,
+		SnapshotFile:           s.SnapshotFile,
+		IdleDestinationTimeout: s.IdleDestinationTimeout.String(),
+		CompactInterval:        s.CompactInterval.String(),
+		SyncInterval:           s.SyncInterval.String(),
+		Clustered:              s.Shard != nil,
+	}
+	if config.Addr == "" {
+		config.Addr = DefaultAddr
+	}
+	if s.HeartBeat == 0 {
+		config.HeartBeat = DefaultHeartBeat.String()
+	}
+	if s.SyncInterval == 0 {
+		config.SyncInterval = DefaultSyncInterval.String()
+	}
+	for _, f := range featureNames {
+		if s.disabledFeatures()&f.feature != 0 {
+			config.DisabledFeatures = append(config.DisabledFeatures, f.name)
+		}
+	}
+	if s.QueueStorage != nil {
+		config.QueueStorage = fmt.Sprintf("%T", s.QueueStorage)
+	}
+	return config
+}
+
+// Returns a handler for GET requests, which responds with the value
+// returned by fn.
+func adminGet(fn func() (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			v, err := fn()
+			writeAdminJSON(w, v, err)
+		}
+	})
+}
+
+// Reports whether the method of a request is one of methods, and
+// responds with an error if it is not.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// Parses the limit parameter of an admin API request, which is zero
+// if it is absent, and responds with an error if it is not a number.
+func adminLimit(w http.ResponseWriter, value string) (int, bool) {
+	if value == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// Writes a value in the response to an admin API request, or an error
+// response if err is not nil.
+func writeAdminJSON(w http.ResponseWriter, v interface{}, err error) {
+	switch err {
+	case nil:
+	case ErrNotServing:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case ErrNotQueue:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case ErrNoClient, ErrNoMessage:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type AdminAPISuite struct{}
+
+var _ = Suite(&AdminAPISuite{})
+
+func (s *AdminAPISuite) TestAdminHandler(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{
+		DisabledFeatures: FeatureNack,
+		Policies:         []DestinationPolicy{{Pattern: "/queue/work", MinConsumers: 1}},
+	}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	handler := serv.AdminHandler(testAuthenticator{})
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.AcceptVersion(stomp.V11), stomp.ConnOpt.Login("guest", "guest"))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	for _, body := range []string{"one", "two", "three"} {
+		c.Assert(conn.Send("/queue/work", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+	sub, err := conn.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	receive(c, sub)
+	// wait for the subscription to be processed
+	c.Assert(conn.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+
+	get := func(path string, v interface{}) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("user", "secret")
+		handler.ServeHTTP(w, r)
+		if w.Code == http.StatusOK {
+			c.Check(w.Header().Get("Content-Type"), Equals, "application/json")
+			c.Assert(json.Unmarshal(w.Body.Bytes(), v), IsNil)
+		}
+		return w.Code
+	}
+
+	var conns []ConnectionInfo
+	c.Assert(get("/connections", &conns), Equals, http.StatusOK)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns[0].Login, Equals, "guest")
+	c.Check(conns[0].Version, Equals, "1.1")
+	c.Check(conns[0].Subscriptions, Equals, 1)
+	c.Check(conns[0].RemoteAddr, Not(Equals), "")
+
+	var subs []SubscriptionInfo
+	c.Assert(get("/subscriptions", &subs), Equals, http.StatusOK)
+	c.Assert(subs, HasLen, 1)
+	c.Check(subs[0].ConnectionId, Equals, conns[0].Id)
+	c.Check(subs[0].Destination, Equals, "/queue/work")
+	c.Check(subs[0].Ack, Equals, "client-individual")
+
+	var detail struct {
+		Connection    ConnectionInfo
+		Subscriptions []SubscriptionInfo
+	}
+	c.Assert(get("/connections/"+conns[0].Id, &detail), Equals, http.StatusOK)
+	c.Check(detail.Connection.Id, Equals, conns[0].Id)
+	c.Check(detail.Subscriptions, DeepEquals, subs)
+	c.Check(get("/connections/unknown", &detail), Equals, http.StatusNotFound)
+
+	var queues []QueueInfo
+	c.Assert(get("/queues", &queues), Equals, http.StatusOK)
+	c.Assert(queues, HasLen, 2)
+	c.Check(queues[1].Destination, Equals, "/queue/work")
+	c.Check(queues[1].Depth, Equals, 2)
+	c.Check(queues[1].Consumers, Equals, 1)
+	c.Check(queues[1].Enqueued, Equals, uint64(3))
+	c.Check(queues[1].Dispatched, Equals, uint64(1))
+	c.Check(queues[1].EnqueueRate, Equals, 3.0/60)
+
+	var messages []MessageInfo
+	c.Assert(get("/messages?destination=/queue/work", &messages), Equals, http.StatusOK)
+	c.Assert(messages, HasLen, 2)
+	c.Check(messages[0].BodySize, Equals, len("two"))
+	c.Check(headerValues(messages[0], "destination"), DeepEquals, []string{"/queue/work"})
+	c.Assert(get("/messages?destination=/queue/work&limit=1", &messages), Equals, http.StatusOK)
+	c.Check(messages, HasLen, 1)
+	c.Check(get("/messages?destination=/queue/work&limit=x", nil), Equals, http.StatusBadRequest)
+	c.Check(get("/messages?destination=/topic/work", nil), Equals, http.StatusBadRequest)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.SetBasicAuth("user", "secret")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	c.Check(request("DELETE", "/messages?destination=/queue/work&id="+messages[0].MessageId).Code,
+		Equals, http.StatusNoContent)
+	c.Check(request("DELETE", "/messages?destination=/queue/work&id="+messages[0].MessageId).Code,
+		Equals, http.StatusNotFound)
+	c.Check(request("GET", "/messages/move?from=/queue/work&to=/queue/moved").Code,
+		Equals, http.StatusMethodNotAllowed)
+	w := request("POST", "/messages/move?from=/queue/work&to=/queue/moved")
+	c.Check(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Equals, "{\n  \"moved\": 1\n}\n")
+	c.Assert(get("/messages?destination=/queue/moved", &messages), Equals, http.StatusOK)
+	c.Assert(messages, HasLen, 1)
+	c.Check(headerValues(messages[0], OriginalDestinationHeader), DeepEquals, []string{"/queue/work"})
+
+	var policies []DestinationPolicy
+	c.Assert(get("/policies", &policies), Equals, http.StatusOK)
+	c.Check(policies, DeepEquals, serv.Policies)
+
+	var config map[string]interface{}
+	c.Assert(get("/config", &config), Equals, http.StatusOK)
+	c.Check(config["addr"], Equals, DefaultAddr)
+	c.Check(config["disabled_features"], DeepEquals, []interface{}{"nack"})
+	c.Check(config["queue_storage"], Equals, "memory")
+	c.Check(config["validation"], Equals, "lenient")
+
+	c.Check(get("/unknown", nil), Equals, http.StatusNotFound)
+
+	// requests without valid credentials are refused
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/connections", nil))
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+	c.Check(w.Header().Get("WWW-Authenticate"), Equals, `Basic realm="stomp admin"`)
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/connections", nil)
+	r.SetBasicAuth("user", "secret")
+	handler.ServeHTTP(w, r)
+	c.Check(w.Code, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *AdminAPISuite) TestDisconnectClient(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	handler := serv.AdminHandler(nil)
+	request := func(method, path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	// a client that does not acknowledge its message
+	stuck, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	sub, err := stuck.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	c.Assert(stuck.Send("/queue/work", "text/plain", []byte("one"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, "one")
+
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(request("POST", "/connections/"+conns[0].Id), Equals, http.StatusMethodNotAllowed)
+	c.Check(request("DELETE", "/connections/"+conns[0].Id), Equals, http.StatusNoContent)
+	c.Check(request("DELETE", "/connections/unknown"), Equals, http.StatusNotFound)
+
+	// the message is requeued for another client
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	sub, err = conn.Subscribe("/queue/work", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, "one")
+
+	// clients can also be identified by their remote address
+	conns, err = serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(serv.DisconnectClient(conns[0].RemoteAddr), IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conns) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		conns, err = serv.Connections()
+		c.Assert(err, IsNil)
+	}
+	c.Check(conns, HasLen, 0)
+	c.Check(serv.DisconnectClient("127.0.0.1:1"), Equals, ErrNoClient)
+}
+
+func (s *AdminAPISuite) TestDebugHandler(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	handler := serv.DebugHandler(testAuthenticator{})
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	c.Assert(conn.Send("/queue/work", "text/plain", []byte("one"), stomp.SendOpt.Receipt), IsNil)
+
+	get := func(path string, login string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth(login, "secret")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	c.Check(get("/debug/vars", "guest").Code, Equals, http.StatusUnauthorized)
+
+	w := get("/debug/vars", "user")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var vars struct {
+		Cmdline []string
+		Stomp   debugVars
+	}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &vars), IsNil)
+	c.Check(vars.Cmdline, Not(HasLen), 0)
+	c.Check(vars.Stomp.Serving, Equals, true)
+	c.Check(vars.Stomp.Connections, Equals, 1)
+	c.Check(vars.Stomp.QueueDepths, DeepEquals, map[string]int{"/queue/work": 1})
+	c.Check(vars.Stomp.Goroutines > 0, Equals, true)
+
+	w = get("/debug/pprof/goroutine?debug=1", "user")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Matches, "(?s)goroutine profile: .*processLoop.*")
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Advisory messages are sent by the server to topics whose names start
+// with AdvisoryTopicPrefix, to notify interested clients about events
+// that occur within the server. Clients receive advisory messages by
+// subscribing to the advisory topic, like any other topic.
+const AdvisoryTopicPrefix = "/topic/advisory."
+
+// Advisory topics.
+const (
+	// A destination has been removed because it was idle.
+	// See Server.IdleDestinationTimeout.
+	DestinationRemovedAdvisory = AdvisoryTopicPrefix + "destination.removed"
+
+	// A destination has had fewer consumers than required by its
+	// destination policy for longer than the grace period, while
+	// messages were waiting. See DestinationPolicy.MinConsumers.
+	ConsumerShortageAdvisory = AdvisoryTopicPrefix + "consumer.shortage"
+
+	// A destination that had a consumer shortage now has the
+	// number of consumers required by its destination policy.
+	ConsumersRestoredAdvisory = AdvisoryTopicPrefix + "consumer.restored"
+
+	// The number of subscriptions to a destination made by clients of
+	// the server has changed. Subscriptions forwarded by other brokers
+	// are not counted. A client that subscribes to this advisory is
+	// first sent the number of subscriptions to each destination that
+	// has any, so that other brokers can forward messages only to the
+	// destinations for which they have consumers.
+	DemandAdvisory = AdvisoryTopicPrefix + "demand"
+
+	// The server is draining before it shuts down: queues no longer
+	// dispatch messages, and clients should acknowledge the messages
+	// that they have received, and then disconnect. See Server.Drain.
+	ShutdownAdvisory = AdvisoryTopicPrefix + "shutdown"
+)
+
+// Headers in advisory messages.
+const (
+	// Name of the destination that the advisory refers to.
+	AdvisoryDestinationHeader = "advisory-destination"
+
+	// Number of consumers of the destination.
+	ConsumerCountHeader = "consumer-count"
+
+	// Milliseconds that the server waits for acknowledgements before
+	// it shuts down.
+	DrainTimeoutHeader = "drain-timeout"
+)
+
+// Sends an advisory message about a destination to an advisory topic,
+// or about the whole server if destination is empty. The headers
+// contain additional header entries for the message.
+func (proc *requestProcessor) advise(advisory, destination string, headers ...string) {
+	f := frame.New(frame.MESSAGE, frame.Destination, advisory)
+	if destination != "" {
+		f.Header.Add(AdvisoryDestinationHeader, destination)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Header.Add(headers[i], headers[i+1])
+	}
+	proc.tm.Enqueue(advisory, f)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Default archive parameters.
+const (
+	DefaultArchiveBatchSize     = 100
+	DefaultArchiveFlushInterval = time.Second
+)
+
+// An ArchiveRecord describes a message that has been successfully
+// delivered to, and acknowledged by, a client.
+type ArchiveRecord struct {
+	MessageId   string        // Value of the message-id header when delivered
+	Destination string        // Destination the message was sent to
+	Header      *frame.Header // Header of the MESSAGE frame
+	Body        []byte        // Message body, nil unless ArchiveConfig.IncludeBody is set
+	Acked       time.Time     // Time the acknowledgement was processed
+	RequestId   string        // Correlation id of the client frame that acknowledged the message
+}
+
+// ArchiveSink is the interface for receiving messages that have been
+// acknowledged, so that they can be streamed to long-term storage for
+// replay or compliance purposes without being kept in the broker.
+//
+// Archive is called from a single go-routine with batches of records
+// in the order that they were acknowledged. If Archive returns an error,
+// the error is logged and the batch is discarded. If the sink falls so
+// far behind that the buffered records fill four batches, further
+// records are dropped and logged until the sink catches up.
+type ArchiveSink interface {
+	Archive(records []ArchiveRecord) error
+}
+
+// ArchiveConfig specifies how acknowledged messages are archived.
+// Only messages sent to queues are archived; messages sent to topics
+// are never acknowledged.
+type ArchiveConfig struct {
+	Sink          ArchiveSink   // Receives batches of archive records
+	BatchSize     int           // Maximum records per batch, DefaultArchiveBatchSize if zero
+	FlushInterval time.Duration // Maximum time a record waits before being archived, DefaultArchiveFlushInterval if zero
+	IncludeBody   bool          // Include message bodies in archive records
+}
+
+// archiver batches archive records and passes them to the archive sink
+// on its own go-routine, so that a slow sink does not hold up the
+// request processor. Records that do not fit in its buffer are dropped.
+type archiver struct {
+	config  ArchiveConfig
+	log     stomp.Logger
+	ch      chan ArchiveRecord
+	done    chan struct{}
+	dropped uint64 // records dropped because the buffer was full
+}
+
+func newArchiver(config ArchiveConfig, log stomp.Logger) *archiver {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultArchiveBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultArchiveFlushInterval
+	}
+	a := &archiver{
+		config: config,
+		log:    log,
+		ch:     make(chan ArchiveRecord, config.BatchSize*4),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Add an acknowledged MESSAGE frame to the archive. The record takes a
+// copy of the frame's header and body, because the frame is released
+// once the acknowledgement has been processed. If the buffer is full,
+// the record is dropped.
+func (a *archiver) Add(f *frame.Frame, requestId string) {
+	record := ArchiveRecord{
+		RequestId:   requestId,
+		MessageId:   f.Header.Get(frame.MessageId),
+		Destination: f.Header.Get(frame.Destination),
+		Header:      f.Header.Clone(),
+		Acked:       time.Now(),
+	}
+	if a.config.IncludeBody {
+		record.Body = append([]byte(nil), f.Body...)
+	}
+	select {
+	case a.ch <- record:
+	default:
+		n := atomic.AddUint64(&a.dropped, 1)
+		a.log.Warningf("[%s] archive buffer full, record for message %s dropped (%d dropped in all)",
+			requestId, record.MessageId, n)
+	}
+}
+
+// Dropped returns the number of records dropped because the sink
+// could not keep up.
+func (a *archiver) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Stop archiving. Any pending records are passed to the sink
+// before Stop returns.
+func (a *archiver) Stop() {
+	close(a.ch)
+	<-a.done
+}
+
+func (a *archiver) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]ArchiveRecord, 0, a.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := a.config.Sink.Archive(batch); err != nil {
+			a.log.Errorf("archive failed for %d records: %v", len(batch), err)
+		}
+		batch = make([]ArchiveRecord, 0, a.config.BatchSize)
+	}
+
+	for {
+		select {
+		case record, ok := <-a.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= a.config.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
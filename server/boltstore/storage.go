@@ -0,0 +1,630 @@
+/*
+Package boltstore provides persistent queue storage for the STOMP server,
+backed by an embedded Bolt key-value database.
+
+Frames in a queue survive restarts of the server. Frames that have been
+sent to a client but not acknowledged when the server stops are returned
+to the head of their queue when the storage is next opened, with the
+"redelivered:true" header.
+
+Frames that exceed the retention settings of the storage are discarded
+when the storage is compacted, which also returns the space freed in
+the database file to the file system.
+*/
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the database file in the data directory.
+const FileName = "queues.db"
+
+// Default interval between writes to disk for SyncPeriodic.
+const DefaultSyncInterval = time.Second
+
+// SyncMode determines when changes to the storage are written to disk.
+type SyncMode int
+
+// Sync modes.
+const (
+	// Changes are written to disk before each storage operation
+	// returns. This is the safest and slowest mode.
+	SyncAlways SyncMode = iota
+
+	// Changes are written to disk at regular intervals. Changes made
+	// since the last write are lost if the operating system crashes.
+	SyncPeriodic
+
+	// Changes are written to disk when the operating system decides
+	// to. Use only if losing messages is acceptable.
+	SyncNever
+)
+
+// Options for opening storage.
+type Options struct {
+	// Directory containing the database file. It is created
+	// if it does not exist.
+	Dir string
+
+	// Determines when changes are written to disk.
+	Sync SyncMode
+
+	// Interval between writes to disk if Sync is SyncPeriodic.
+	// If zero, DefaultSyncInterval is used.
+	SyncInterval time.Duration
+
+	// Limits on the frames kept in each queue, which are applied when
+	// the storage is compacted.
+	Retention queue.Retention
+}
+
+// Prefixes of the bucket names for each queue.
+var (
+	pendingPrefix = []byte("q:") // frames waiting to be sent
+	unackedPrefix = []byte("u:") // frames sent but not acknowledged
+)
+
+// Keys of frames added to the tail of a queue start at this value,
+// leaving room for frames added to the head of the queue.
+const tailOffset = 1 << 63
+
+// Errors returned by storage operations.
+var (
+	ErrClosed  = errors.New("storage is closed")
+	ErrInBatch = errors.New("cannot compact storage during a batch")
+)
+
+// Returned if a value in the database is not a valid frame.
+var errCorruptValue = errors.New("corrupt frame in storage")
+
+// Storage is a persistent implementation of the queue storage
+// interface. Each queue is kept in a bucket whose keys are sequence
+// numbers that determine the order of the frames.
+//
+// Storage is not safe for concurrent use, which matches the way the
+// server uses queue storage.
+type Storage struct {
+	opts     Options
+	db       *bolt.DB
+	inflight map[*frame.Frame]uint64 // keys of frames waiting for acknowledgement
+	done     chan struct{}
+	wg       sync.WaitGroup
+	batch    *bolt.Tx // transaction for the current batch
+	batchErr error    // first error in the current batch
+	depth    int      // depth of nested batches
+}
+
+// Open the storage in the data directory specified by opts.
+func Open(opts Options) (*Storage, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := openDB(opts, filepath.Join(opts.Dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		opts:     opts,
+		db:       db,
+		inflight: make(map[*frame.Frame]uint64),
+	}
+	if err = s.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.startSync()
+	return s, nil
+}
+
+func openDB(opts Options, path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = opts.Sync != SyncAlways
+	return db, nil
+}
+
+// Returns frames that were not acknowledged before the storage was
+// closed to the head of their queues, in their original order.
+func (s *Storage) recover() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, unackedPrefix) {
+				names = append(names, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			queue := string(name[len(unackedPrefix):])
+			pending, err := tx.CreateBucketIfNotExists(bucketName(pendingPrefix, queue))
+			if err != nil {
+				return err
+			}
+			c := tx.Bucket(name).Cursor()
+			for k, v := c.Last(); k != nil; k, v = c.Prev() {
+				f, added, err := decodeValue(v)
+				if err != nil {
+					return err
+				}
+				f.Header.Set(frame.Redelivered, "true")
+				if v, err = encodeValue(f, added); err != nil {
+					return err
+				}
+				if err = pending.Put(encodeKey(headKey(pending)), v); err != nil {
+					return err
+				}
+			}
+			if err = tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Starts writing changes to disk at intervals, if required
+// by the sync mode.
+func (s *Storage) startSync() {
+	s.done = make(chan struct{})
+	if s.opts.Sync == SyncPeriodic {
+		interval := s.opts.SyncInterval
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		s.wg.Add(1)
+		go s.syncLoop(interval)
+	}
+}
+
+func (s *Storage) stopSync() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Storage) syncLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// errors are reported by the next write to the database
+			_ = s.db.Sync()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close the storage, writing any outstanding changes to disk.
+func (s *Storage) Close() error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	if s.batch != nil {
+		s.batch.Rollback()
+		s.batch = nil
+	}
+	s.stopSync()
+
+	err := s.db.Sync()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	s.db = nil
+	return err
+}
+
+// Ping checks that the database can be read.
+func (s *Storage) Ping() error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// Enqueue adds a frame to the tail of the queue.
+func (s *Storage) Enqueue(queue string, f *frame.Frame) error {
+	value, err := encodeValue(f, time.Now())
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketName(pendingPrefix, queue))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(encodeKey(tailOffset+seq), value)
+	})
+}
+
+// Requeue adds a frame to the head of the queue. If the frame was
+// dequeued from this storage, it is no longer waiting for acknowledgement.
+func (s *Storage) Requeue(queue string, f *frame.Frame) error {
+	return s.update(func(tx *bolt.Tx) error {
+		// a frame that was dequeued keeps the time it was first added
+		added := time.Now()
+		if key, ok := s.inflight[f]; ok {
+			if b := tx.Bucket(bucketName(unackedPrefix, queue)); b != nil {
+				if v := b.Get(encodeKey(key)); v != nil {
+					added = decodeTime(v)
+				}
+			}
+		}
+		value, err := encodeValue(f, added)
+		if err != nil {
+			return err
+		}
+		if err := s.deleteUnacked(tx, queue, f); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(bucketName(pendingPrefix, queue))
+		if err != nil {
+			return err
+		}
+		return b.Put(encodeKey(headKey(b)), value)
+	})
+}
+
+// Dequeue removes the frame at the head of the queue. The frame is kept
+// until it is acknowledged, so that it can be recovered if the storage
+// is closed first. Returns nil if the queue is empty.
+func (s *Storage) Dequeue(queue string) (*frame.Frame, error) {
+	var f *frame.Frame
+	var key uint64
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName(pendingPrefix, queue))
+		if b == nil {
+			return nil
+		}
+		k, v := b.Cursor().First()
+		if k == nil {
+			return nil
+		}
+		var err error
+		if f, _, err = decodeValue(v); err != nil {
+			return err
+		}
+		unacked, err := tx.CreateBucketIfNotExists(bucketName(unackedPrefix, queue))
+		if err != nil {
+			return err
+		}
+		if err = unacked.Put(k, v); err != nil {
+			return err
+		}
+		key = decodeKey(k)
+		return b.Delete(k)
+	})
+	if err != nil || f == nil {
+		return nil, err
+	}
+	s.inflight[f] = key
+	return f, nil
+}
+
+// Ack removes a frame dequeued from the queue once it has been
+// acknowledged. Frames not dequeued from this storage are ignored.
+func (s *Storage) Ack(queue string, f *frame.Frame) error {
+	if _, ok := s.inflight[f]; !ok {
+		return nil
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return s.deleteUnacked(tx, queue, f)
+	})
+}
+
+// Len returns the number of frames in the queue, not including frames
+// that are waiting for acknowledgement.
+func (s *Storage) Len(queue string) int {
+	var n int
+	_ = s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucketName(pendingPrefix, queue)); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+// Iterate calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false.
+func (s *Storage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	return s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName(pendingPrefix, queue))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			f, _, err := decodeValue(v)
+			if err != nil {
+				return err
+			}
+			if !fn(f) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Start has no effect, as the storage is ready to use once opened.
+func (s *Storage) Start() {
+}
+
+// Sync writes the changes made to the storage to disk. This is only
+// required if the sync mode is not SyncAlways. During a batch, changes
+// are not written until the batch ends.
+func (s *Storage) Sync() error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	return s.db.Sync()
+}
+
+// Stop closes the storage.
+func (s *Storage) Stop() {
+	_ = s.Close()
+}
+
+// Compact discards frames that exceed the retention settings of the
+// storage, from the head of each queue. It then copies the database
+// to a new file, which returns the space freed by removed frames to
+// the file system. Must not be called during a batch.
+func (s *Storage) Compact() (queue.CompactStats, error) {
+	var stats queue.CompactStats
+	if s.db == nil {
+		return stats, ErrClosed
+	}
+	if s.depth > 0 {
+		return stats, ErrInBatch
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !bytes.HasPrefix(name, pendingPrefix) {
+				return nil
+			}
+			n, err := s.applyRetention(b)
+			stats.Discarded += n
+			return err
+		})
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ReclaimedBytes, err = s.copyDB()
+	return stats, err
+}
+
+// Deletes frames from the head of a queue for as long as they
+// exceed the retention settings. Returns the number deleted.
+func (s *Storage) applyRetention(b *bolt.Bucket) (int, error) {
+	var size int64
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		f, _, err := decodeValue(v)
+		if err != nil {
+			return 0, err
+		}
+		size += int64(len(f.Body))
+	}
+
+	now := time.Now()
+	var n int
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		f, added, err := decodeValue(v)
+		if err != nil {
+			return n, err
+		}
+		if !s.opts.Retention.Exceeded(added, size, now) {
+			break
+		}
+		if err = b.Delete(k); err != nil {
+			return n, err
+		}
+		size -= int64(len(f.Body))
+		n++
+	}
+	return n, nil
+}
+
+// Copies the database to a new file, which replaces the database
+// file. Returns the reduction in the size of the file.
+func (s *Storage) copyDB() (int64, error) {
+	path := s.db.Path()
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := openDB(s.opts, tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if err = bolt.Compact(dst, s.db, 0); err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	before, err := fileSize(path)
+	if err != nil {
+		return 0, err
+	}
+	after, err := fileSize(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// the sync loop must not use the database while it is replaced
+	s.stopSync()
+	if err = s.db.Close(); err != nil {
+		s.db = nil
+		return 0, err
+	}
+	renameErr := os.Rename(tmpPath, path)
+	if s.db, err = openDB(s.opts, path); err != nil {
+		// the storage cannot be used, so behave as if it were closed
+		s.db = nil
+		return 0, err
+	}
+	s.startSync()
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return 0, renameErr
+	}
+	return before - after, nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// BeginBatch starts a batch of changes, which are made in a single
+// database transaction that is committed by the matching call to
+// EndBatch. If the server crashes first, none of the changes are kept.
+func (s *Storage) BeginBatch() {
+	s.depth++
+}
+
+// EndBatch ends a batch of changes, and commits them if it is
+// the outermost batch. If any change in the batch failed, the
+// batch is rolled back and the first error is returned.
+func (s *Storage) EndBatch() error {
+	if s.depth == 0 {
+		return nil
+	}
+	s.depth--
+	if s.depth > 0 || s.batch == nil {
+		return nil
+	}
+
+	tx, err := s.batch, s.batchErr
+	s.batch, s.batchErr = nil, nil
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) update(fn func(tx *bolt.Tx) error) error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	if s.depth == 0 {
+		return s.db.Update(fn)
+	}
+
+	if s.batch == nil {
+		tx, err := s.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		s.batch = tx
+	}
+	if s.batchErr != nil {
+		return s.batchErr
+	}
+	s.batchErr = fn(s.batch)
+	return s.batchErr
+}
+
+func (s *Storage) view(fn func(tx *bolt.Tx) error) error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	if s.batch != nil {
+		// include the changes made in the batch so far
+		return fn(s.batch)
+	}
+	return s.db.View(fn)
+}
+
+// Deletes a frame that is waiting for acknowledgement.
+func (s *Storage) deleteUnacked(tx *bolt.Tx, queue string, f *frame.Frame) error {
+	key, ok := s.inflight[f]
+	if !ok {
+		return nil
+	}
+	delete(s.inflight, f)
+	if b := tx.Bucket(bucketName(unackedPrefix, queue)); b != nil {
+		return b.Delete(encodeKey(key))
+	}
+	return nil
+}
+
+func bucketName(prefix []byte, queue string) []byte {
+	return append(append([]byte(nil), prefix...), queue...)
+}
+
+// Returns the key for a frame added to the head of the queue.
+func headKey(b *bolt.Bucket) uint64 {
+	if k, _ := b.Cursor().First(); k != nil {
+		return decodeKey(k) - 1
+	}
+	// the queue is empty, so any key below the next sequence will do
+	return tailOffset + b.Sequence()
+}
+
+func encodeKey(key uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, key)
+	return b
+}
+
+func decodeKey(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// Encodes a frame, preceded by the time it was added to the queue.
+func encodeValue(f *frame.Frame, added time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(encodeKey(uint64(added.UnixNano())))
+	if err := frame.NewWriter(&buf).Write(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValue(b []byte) (*frame.Frame, time.Time, error) {
+	if len(b) < 8 {
+		return nil, time.Time{}, errCorruptValue
+	}
+	f, err := frame.NewReader(bytes.NewReader(b[8:])).Read()
+	return f, decodeTime(b), err
+}
+
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
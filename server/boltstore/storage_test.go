@@ -0,0 +1,179 @@
+package boltstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/queue/queuetest"
+	. "gopkg.in/check.v1"
+)
+
+func TestBoltStore(t *testing.T) {
+	TestingT(t)
+}
+
+type StorageSuite struct{}
+
+var _ = Suite(&StorageSuite{})
+
+// Storage implements the queue storage interfaces.
+var (
+	_ queue.CompactStorage = (*Storage)(nil)
+	_ queue.SyncStorage    = (*Storage)(nil)
+	_ queue.PingStorage    = (*Storage)(nil)
+)
+
+func (s *StorageSuite) TestOrder(c *C) {
+	st, err := Open(Options{Dir: c.MkDir()})
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	// requeue to an empty queue
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("0")), IsNil)
+	c.Assert(st.Enqueue("/queue/other", queuetest.NewFrame("other")), IsNil)
+
+	c.Check(st.Len("/queue/test"), Equals, 4)
+	var bodies []string
+	err = st.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return len(bodies) < 3
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"0", "1", "2"})
+
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "0")
+	c.Check(f.Header.Get(frame.Destination), Equals, "/queue/test")
+	c.Check(st.Len("/queue/test"), Equals, 3)
+
+	// a frame that could not be sent goes back to the head
+	c.Assert(st.Requeue("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"0", "1", "2", "3"})
+	c.Check(st.Len("/queue/test"), Equals, 0)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/missing"), HasLen, 0)
+}
+
+func (s *StorageSuite) TestReopen(c *C) {
+	dir := c.MkDir()
+	st, err := Open(Options{Dir: dir, Sync: SyncPeriodic})
+	c.Assert(err, IsNil)
+
+	for _, body := range []string{"1", "2", "3", "4"} {
+		c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+
+	// acknowledged frames are removed, unacknowledged frames are kept
+	f1, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f2, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f3, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(st.Ack("/queue/test", f2), IsNil)
+	c.Assert(st.Ack("/queue/test", queuetest.NewFrame("unknown")), IsNil)
+	c.Check(string(f1.Body)+string(f3.Body), Equals, "13")
+	c.Assert(st.Close(), IsNil)
+	c.Check(st.Close(), Equals, ErrClosed)
+
+	_, err = st.Dequeue("/queue/test")
+	c.Check(err, Equals, ErrClosed)
+
+	st, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer st.Close()
+	c.Check(st.Len("/queue/test"), Equals, 3)
+
+	// unacknowledged frames might have been delivered
+	var redelivered []string
+	c.Assert(st.Iterate("/queue/test", func(f *frame.Frame) bool {
+		redelivered = append(redelivered, f.Header.Get(frame.Redelivered))
+		return true
+	}), IsNil)
+	c.Check(redelivered, DeepEquals, []string{"true", "true", ""})
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"1", "3", "4"})
+}
+
+func (s *StorageSuite) TestBatch(c *C) {
+	dir := c.MkDir()
+	st, err := Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+
+	st.BeginBatch()
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	st.BeginBatch()
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(st.Ack("/queue/test", f), IsNil)
+	c.Assert(st.EndBatch(), IsNil)
+	c.Check(st.Len("/queue/test"), Equals, 1)
+	c.Assert(st.EndBatch(), IsNil)
+
+	// a batch that has not ended when the storage is closed is discarded
+	st.BeginBatch()
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(st.Close(), IsNil)
+
+	st, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer st.Close()
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"2"})
+}
+
+func (s *StorageSuite) TestCompact(c *C) {
+	dir := c.MkDir()
+	st, err := Open(Options{Dir: dir, Sync: SyncNever, Retention: queue.Retention{MaxBytes: 3000}})
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	body := strings.Repeat("x", 1000)
+	for i := 0; i < 200; i++ {
+		c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("last")), IsNil)
+
+	// frames waiting for acknowledgement are not discarded
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	stats, err := st.Compact()
+	c.Assert(err, IsNil)
+	c.Check(stats.Discarded, Equals, 197)
+	c.Check(stats.ReclaimedBytes > 0, Equals, true)
+	c.Check(st.Len("/queue/test"), Equals, 3)
+
+	// the storage can be used after compaction
+	c.Assert(st.Requeue("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{body, body, body, "last"})
+
+	st.BeginBatch()
+	_, err = st.Compact()
+	c.Check(err, Equals, ErrInBatch)
+	c.Assert(st.EndBatch(), IsNil)
+}
+
+func (s *StorageSuite) TestRetentionMaxAge(c *C) {
+	st, err := Open(Options{Dir: c.MkDir(), Retention: queue.Retention{MaxAge: 50 * time.Millisecond}})
+	c.Assert(err, IsNil)
+	defer st.Close()
+
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+
+	// a requeued frame keeps the time that it was first added
+	c.Assert(st.Requeue("/queue/test", f), IsNil)
+	stats, err := st.Compact()
+	c.Assert(err, IsNil)
+	c.Check(stats.Discarded, Equals, 2)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"3"})
+}
@@ -0,0 +1,447 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Header entries of STOMP messages that are translated to and from the
+// properties of AMQP messages. Other header entries are translated to
+// and from AMQP application properties.
+const (
+	AMQPMessageIdHeader = "amqp-message-id" // message-id property
+	CorrelationIdHeader = "correlation-id"  // correlation-id property
+	ReplyToHeader       = "reply-to"        // reply-to property, translated like a destination
+	SubjectHeader       = "subject"         // subject property
+	PriorityHeader      = "priority"        // priority field of the AMQP header
+)
+
+// An AMQPMessage is an AMQP 1.0 message, with the parts that the bridge
+// translates to and from STOMP messages.
+type AMQPMessage struct {
+	// Fields of the header section.
+	Durable  bool
+	Priority uint8
+	TTL      time.Duration // zero if the message does not expire
+
+	// Fields of the properties section.
+	MessageId          string
+	To                 string
+	Subject            string
+	ReplyTo            string
+	CorrelationId      string
+	ContentType        string
+	AbsoluteExpiryTime time.Time // zero if the message does not expire
+	CreationTime       time.Time
+
+	// Application properties. Values of types other than string are
+	// translated to STOMP header entries with fmt.Sprint.
+	ApplicationProperties map[string]interface{}
+
+	// Message body, as a single data section.
+	Data []byte
+}
+
+// AMQPConn is a connection to an AMQP 1.0 broker, such as Azure
+// Service Bus or ActiveMQ Artemis. The bridge does not implement AMQP
+// itself: implement AMQPConn with the AMQP client library of your choice,
+// such as github.com/Azure/go-amqp.
+type AMQPConn interface {
+	// NewSender opens a link for sending messages to an address.
+	NewSender(address string) (AMQPSender, error)
+
+	// NewReceiver opens a link for receiving messages from an address.
+	NewReceiver(address string) (AMQPReceiver, error)
+
+	// Close the connection, which causes calls to the methods of its
+	// senders and receivers to return an error.
+	Close() error
+}
+
+// AMQPSender sends messages over an AMQP link.
+type AMQPSender interface {
+	// Send a message, returning once the broker has accepted it.
+	Send(msg *AMQPMessage) error
+}
+
+// AMQPReceiver receives messages over an AMQP link.
+type AMQPReceiver interface {
+	// Receive waits for the next message.
+	Receive() (*AMQPMessage, error)
+
+	// Accept settles a message, once it has been forwarded.
+	Accept(msg *AMQPMessage) error
+}
+
+// AddressOf returns the AMQP address that corresponds to a STOMP
+// destination, which is the destination without a "/queue/" or
+// "/topic/" prefix.
+func AddressOf(destination string) string {
+	for _, prefix := range []string{"/queue/", "/topic/"} {
+		if strings.HasPrefix(destination, prefix) {
+			return destination[len(prefix):]
+		}
+	}
+	return destination
+}
+
+// DestinationOf returns the STOMP queue destination that corresponds to
+// an AMQP address. Addresses that start with "/" are used unchanged.
+func DestinationOf(address string) string {
+	if strings.HasPrefix(address, "/") {
+		return address
+	}
+	return "/queue/" + address
+}
+
+// An AMQPRule forwards messages between a STOMP destination on the
+// local broker and an AMQP address on the remote broker.
+type AMQPRule struct {
+	// Outbound rules forward messages from Destination to Address,
+	// and inbound rules forward messages from Address to Destination.
+	Direction Direction
+
+	// STOMP destination on the local broker.
+	Destination string
+
+	// AMQP address on the remote broker. If empty,
+	// AddressOf(Destination) is used.
+	Address string
+}
+
+func (r AMQPRule) address() string {
+	if r.Address == "" {
+		return AddressOf(r.Destination)
+	}
+	return r.Address
+}
+
+// An AMQPBridge forwards messages between a local STOMP broker and a
+// remote AMQP 1.0 broker according to its rules. A message is only
+// acknowledged once the other broker has accepted it, so messages from
+// queues are delivered at least once even if a connection fails.
+//
+// The "bridge-via" header is kept in an application property with
+// the same name, so that bridges can prevent loops across STOMP and
+// AMQP brokers. The exported fields must not be changed once Run has
+// been called.
+type AMQPBridge struct {
+	Name    string                   // Identifies the bridge in the "bridge-via" header, required
+	Local   Endpoint                 // STOMP broker that the bridge runs alongside
+	Dial    func() (AMQPConn, error) // Connects to the AMQP broker, required
+	Rules   []AMQPRule               // Forwarding rules
+	MaxHops int                      // Maximum number of bridges that can forward a message, DefaultMaxHops if zero
+	Backoff *stomp.Backoff           // Delays between attempts to connect, the default delays if nil
+	Log     stomp.Logger             // Logger, the standard logger if nil
+
+	// Stores outbound messages while the AMQP broker is unreachable,
+	// if not nil. Otherwise outbound messages are left on the STOMP
+	// broker while the bridge is disconnected.
+	Spool *Spool
+
+	runner runner
+}
+
+// Run connects to both brokers and forwards messages until Stop is
+// called, connecting again whenever a connection fails. Returns
+// ErrStopped once the bridge has stopped.
+func (b *AMQPBridge) Run() error {
+	if b.Name == "" {
+		return ErrNoName
+	}
+	if b.Log == nil {
+		b.Log = log.StdLogger{}
+	}
+	if b.Backoff == nil {
+		b.Backoff = &stomp.Backoff{}
+	}
+	if b.Spool != nil {
+		return b.runner.run(b.Name, b.Backoff, b.Log, b.spooledSession)
+	}
+	return b.runner.run(b.Name, b.Backoff, b.Log, b.session)
+}
+
+// Stop the bridge, disconnecting from both brokers. Messages being
+// forwarded when the bridge stops are redelivered by the broker
+// that they came from.
+func (b *AMQPBridge) Stop() {
+	b.runner.shutdown()
+}
+
+// Connects to both brokers and forwards messages until a connection
+// fails or the bridge is stopped.
+func (b *AMQPBridge) session() error {
+	local, err := b.Local.dial()
+	if err != nil {
+		return err
+	}
+	remote, err := b.Dial()
+	if err != nil {
+		local.MustDisconnect()
+		return err
+	}
+	if !b.runner.start(local.MustDisconnect, remote.Close) {
+		return ErrStopped
+	}
+	defer b.runner.end()
+
+	errs := make(chan error, len(b.Rules))
+	for _, rule := range b.Rules {
+		if rule.Direction == Inbound {
+			err = b.forwardInbound(rule, remote, local, errs)
+		} else {
+			err = b.forwardOutbound(rule, local, remote, errs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	b.Backoff.Reset()
+	b.Log.Infof("bridge %s: connected to AMQP broker", b.Name)
+
+	// the first failure ends the session, which stops the other rules
+	return <-errs
+}
+
+// Connects to the STOMP broker and spools its outbound messages, and
+// connects to the AMQP broker whenever it is reachable to forward the
+// spooled messages and inbound messages, until the STOMP connection
+// fails or the bridge is stopped.
+func (b *AMQPBridge) spooledSession() error {
+	local, err := b.Local.dial()
+	if err != nil {
+		return err
+	}
+	if !b.runner.start(local.MustDisconnect) {
+		return ErrStopped
+	}
+	defer b.runner.end()
+
+	localErrs := make(chan error, len(b.Rules))
+	addresses := make(map[string]string)
+	for _, rule := range b.Rules {
+		if rule.Direction == Outbound {
+			if err := b.Spool.fill(local, rule.Destination, localErrs); err != nil {
+				return err
+			}
+			addresses[rule.Destination] = rule.address()
+		}
+	}
+
+	return b.runner.drain(b.Name, b.Spool, b.Backoff, b.Log, localErrs, len(b.Rules),
+		func(remoteErrs chan<- error) (func(string, *stomp.Message) error, func() error, error) {
+			remote, err := b.Dial()
+			if err != nil {
+				return nil, nil, err
+			}
+			senders := make(map[string]AMQPSender)
+			for _, rule := range b.Rules {
+				if rule.Direction == Inbound {
+					err = b.forwardInbound(rule, remote, local, remoteErrs)
+				} else {
+					senders[rule.Destination], err = remote.NewSender(rule.address())
+				}
+				if err != nil {
+					remote.Close()
+					return nil, nil, err
+				}
+			}
+			b.Log.Infof("bridge %s: connected to AMQP broker", b.Name)
+			send := func(destination string, msg *stomp.Message) error {
+				sender, ok := senders[destination]
+				if !ok {
+					b.Log.Warningf("bridge %s: discarded spooled message from %s, which has no rule", b.Name, destination)
+					return nil
+				}
+				return b.send(msg, sender, addresses[destination])
+			}
+			return send, remote.Close, nil
+		})
+}
+
+// Forwards messages from a STOMP destination to an AMQP address.
+func (b *AMQPBridge) forwardOutbound(rule AMQPRule, source *stomp.Conn, remote AMQPConn, errs chan<- error) error {
+	sender, err := remote.NewSender(rule.address())
+	if err != nil {
+		return err
+	}
+	sub, err := source.Subscribe(rule.Destination, stomp.AckClientIndividual)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				errs <- msg.Err
+				return
+			}
+			if err := b.send(msg, sender, rule.address()); err != nil {
+				errs <- err
+				return
+			}
+			if err := source.Ack(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- stomp.ErrClosedUnexpectedly
+	}()
+	return nil
+}
+
+// Translates a message and sends it to an AMQP address, unless the
+// message has already passed through this bridge, or through too many
+// bridges.
+func (b *AMQPBridge) send(msg *stomp.Message, sender AMQPSender, address string) error {
+	via := parseVia(msg.Header.Get(ViaHeader))
+	if !forwardable(b.Name, b.MaxHops, via, msg.Destination, b.Log) {
+		return nil
+	}
+	m, err := ToAMQP(msg)
+	if err != nil {
+		// the message would never translate, so it is not retried
+		b.Log.Errorf("bridge %s: discarded message to %s: %v", b.Name, msg.Destination, err)
+		return nil
+	}
+	m.To = address
+	m.ApplicationProperties[ViaHeader] = strings.Join(append(via, b.Name), ",")
+	return sender.Send(m)
+}
+
+// Forwards messages from an AMQP address to a STOMP destination.
+func (b *AMQPBridge) forwardInbound(rule AMQPRule, remote AMQPConn, target *stomp.Conn, errs chan<- error) error {
+	receiver, err := remote.NewReceiver(rule.address())
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			m, err := receiver.Receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			text, _ := m.ApplicationProperties[ViaHeader].(string)
+			via := parseVia(text)
+			if forwardable(b.Name, b.MaxHops, via, rule.Destination, b.Log) {
+				opts := append(FromAMQP(m), stomp.SendOpt.Receipt,
+					stomp.SendOpt.Header(ViaHeader, strings.Join(append(via, b.Name), ",")))
+				if err := target.Send(rule.Destination, m.ContentType, m.Data, opts...); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err := receiver.Accept(m); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// ToAMQP translates a STOMP message to an AMQP message. The "bridge-via"
+// header is copied to the application properties like any other header.
+func ToAMQP(msg *stomp.Message) (*AMQPMessage, error) {
+	m := &AMQPMessage{
+		ContentType:           msg.ContentType,
+		Data:                  msg.Body,
+		ApplicationProperties: make(map[string]interface{}),
+	}
+	expires, ok, err := msg.Header.Expires()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		m.AbsoluteExpiryTime = expires
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < msg.Header.Len(); i++ {
+		key, value := msg.Header.GetAt(i)
+		if seen[key] {
+			// only the first entry with a key is used
+			continue
+		}
+		seen[key] = true
+		switch key {
+		case frame.Destination:
+			m.To = AddressOf(value)
+		case frame.Persistent:
+			m.Durable = value == "true"
+		case PriorityHeader:
+			priority, err := strconv.ParseUint(value, 10, 8)
+			if err != nil {
+				return nil, err
+			}
+			m.Priority = uint8(priority)
+		case AMQPMessageIdHeader:
+			m.MessageId = value
+		case CorrelationIdHeader:
+			m.CorrelationId = value
+		case ReplyToHeader:
+			m.ReplyTo = AddressOf(value)
+		case SubjectHeader:
+			m.Subject = value
+		case frame.MessageId, frame.Subscription, frame.Ack, frame.ContentLength,
+			frame.ContentType, frame.Expires, frame.Redelivered:
+			// not part of the message
+		default:
+			m.ApplicationProperties[key] = value
+		}
+	}
+	return m, nil
+}
+
+// FromAMQP translates the properties of an AMQP message to options that
+// set the header entries of a STOMP SEND frame. The content type and
+// body are passed to stomp.Conn.Send separately.
+func FromAMQP(m *AMQPMessage) []func(*frame.Frame) error {
+	var opts []func(*frame.Frame) error
+	add := func(key, value string) {
+		if value != "" {
+			opts = append(opts, stomp.SendOpt.Header(key, value))
+		}
+	}
+
+	if m.Durable {
+		add(frame.Persistent, "true")
+	}
+	if m.Priority != 0 {
+		add(PriorityHeader, strconv.Itoa(int(m.Priority)))
+	}
+	expires := m.AbsoluteExpiryTime
+	if expires.IsZero() && m.TTL > 0 {
+		expires = time.Now().Add(m.TTL)
+	}
+	if !expires.IsZero() {
+		add(frame.Expires, strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10))
+	}
+	add(AMQPMessageIdHeader, m.MessageId)
+	add(CorrelationIdHeader, m.CorrelationId)
+	if m.ReplyTo != "" {
+		add(ReplyToHeader, DestinationOf(m.ReplyTo))
+	}
+	add(SubjectHeader, m.Subject)
+
+	keys := make([]string, 0, len(m.ApplicationProperties))
+	for key := range m.ApplicationProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := m.ApplicationProperties[key]; key != ViaHeader && value != nil {
+			add(key, fmt.Sprint(value))
+		}
+	}
+	return opts
+}
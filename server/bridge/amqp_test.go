@@ -0,0 +1,206 @@
+package bridge
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type AMQPSuite struct{}
+
+var _ = Suite(&AMQPSuite{})
+
+var errAMQPClosed = errors.New("amqp connection closed")
+
+// An AMQP broker that keeps a channel of messages for each address.
+type fakeAMQP struct {
+	mutex     sync.Mutex
+	addresses map[string]chan *AMQPMessage
+	accepted  chan *AMQPMessage
+}
+
+func newFakeAMQP() *fakeAMQP {
+	return &fakeAMQP{
+		addresses: make(map[string]chan *AMQPMessage),
+		accepted:  make(chan *AMQPMessage, 10),
+	}
+}
+
+func (a *fakeAMQP) address(name string) chan *AMQPMessage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	ch, ok := a.addresses[name]
+	if !ok {
+		ch = make(chan *AMQPMessage, 10)
+		a.addresses[name] = ch
+	}
+	return ch
+}
+
+func (a *fakeAMQP) Dial() (AMQPConn, error) {
+	return &fakeAMQPConn{broker: a, closed: make(chan struct{})}, nil
+}
+
+type fakeAMQPConn struct {
+	broker *fakeAMQP
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *fakeAMQPConn) NewSender(address string) (AMQPSender, error) {
+	return &fakeAMQPLink{conn: c, ch: c.broker.address(address)}, nil
+}
+
+func (c *fakeAMQPConn) NewReceiver(address string) (AMQPReceiver, error) {
+	return &fakeAMQPLink{conn: c, ch: c.broker.address(address)}, nil
+}
+
+func (c *fakeAMQPConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+type fakeAMQPLink struct {
+	conn *fakeAMQPConn
+	ch   chan *AMQPMessage
+}
+
+func (l *fakeAMQPLink) Send(msg *AMQPMessage) error {
+	select {
+	case l.ch <- msg:
+		return nil
+	case <-l.conn.closed:
+		return errAMQPClosed
+	}
+}
+
+func (l *fakeAMQPLink) Receive() (*AMQPMessage, error) {
+	select {
+	case msg := <-l.ch:
+		return msg, nil
+	case <-l.conn.closed:
+		return nil, errAMQPClosed
+	}
+}
+
+func (l *fakeAMQPLink) Accept(msg *AMQPMessage) error {
+	l.conn.broker.accepted <- msg
+	return nil
+}
+
+func (s *AMQPSuite) TestAddresses(c *C) {
+	c.Check(AddressOf("/queue/orders"), Equals, "orders")
+	c.Check(AddressOf("/topic/prices"), Equals, "prices")
+	c.Check(AddressOf("orders"), Equals, "orders")
+	c.Check(DestinationOf("orders"), Equals, "/queue/orders")
+	c.Check(DestinationOf("/topic/prices"), Equals, "/topic/prices")
+}
+
+func (s *AMQPSuite) TestTranslate(c *C) {
+	msg := &stomp.Message{
+		ContentType: "text/plain",
+		Body:        []byte("hello"),
+		Header: frame.NewHeader(
+			frame.Destination, "/queue/orders",
+			frame.MessageId, "42",
+			frame.Persistent, "true",
+			frame.Expires, "1500",
+			PriorityHeader, "7",
+			CorrelationIdHeader, "corr",
+			ReplyToHeader, "/queue/replies",
+			"x-custom", "value",
+			"x-custom", "ignored"),
+	}
+	m, err := ToAMQP(msg)
+	c.Assert(err, IsNil)
+	c.Check(m.To, Equals, "orders")
+	c.Check(m.Durable, Equals, true)
+	c.Check(m.Priority, Equals, uint8(7))
+	c.Check(m.AbsoluteExpiryTime.Equal(time.Unix(1, 500*int64(time.Millisecond))), Equals, true)
+	c.Check(m.CorrelationId, Equals, "corr")
+	c.Check(m.ReplyTo, Equals, "replies")
+	c.Check(m.ContentType, Equals, "text/plain")
+	c.Check(string(m.Data), Equals, "hello")
+	c.Check(m.ApplicationProperties, DeepEquals, map[string]interface{}{"x-custom": "value"})
+
+	// and back again
+	f := frame.New(frame.SEND)
+	for _, opt := range FromAMQP(m) {
+		c.Assert(opt(f), IsNil)
+	}
+	c.Check(f.Header.Get(frame.Persistent), Equals, "true")
+	c.Check(f.Header.Get(frame.Expires), Equals, "1500")
+	c.Check(f.Header.Get(PriorityHeader), Equals, "7")
+	c.Check(f.Header.Get(CorrelationIdHeader), Equals, "corr")
+	c.Check(f.Header.Get(ReplyToHeader), Equals, "/queue/replies")
+	c.Check(f.Header.Get("x-custom"), Equals, "value")
+
+	msg.Header.Set(PriorityHeader, "high")
+	_, err = ToAMQP(msg)
+	c.Check(err, NotNil)
+}
+
+func (s *AMQPSuite) TestForward(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	broker := newFakeAMQP()
+
+	b := &AMQPBridge{
+		Name:  "test",
+		Local: endpoint(localAddr),
+		Dial:  broker.Dial,
+		Rules: []AMQPRule{
+			{Direction: Outbound, Destination: "/queue/out", Address: "outgoing"},
+			{Direction: Inbound, Destination: "/queue/in"},
+		},
+		Log: nopLogger{},
+	}
+	done := make(chan error)
+	go func() { done <- b.Run() }()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+
+	err := local.Send("/queue/out", "text/plain", []byte("to amqp"),
+		stomp.SendOpt.Header("x-custom", "value"))
+	c.Assert(err, IsNil)
+	select {
+	case m := <-broker.address("outgoing"):
+		c.Check(string(m.Data), Equals, "to amqp")
+		c.Check(m.To, Equals, "outgoing")
+		c.Check(m.ApplicationProperties["x-custom"], Equals, "value")
+		c.Check(m.ApplicationProperties[ViaHeader], Equals, "test")
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not sent to AMQP broker")
+	}
+
+	broker.address("in") <- &AMQPMessage{
+		ContentType:           "text/plain",
+		Data:                  []byte("from amqp"),
+		Subject:               "greeting",
+		ApplicationProperties: map[string]interface{}{"count": 3, ViaHeader: "other"},
+	}
+	sub, err := local.Subscribe("/queue/in", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "from amqp")
+	c.Check(msg.Header.Get(SubjectHeader), Equals, "greeting")
+	c.Check(msg.Header.Get("count"), Equals, "3")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "other,test")
+	c.Check(string((<-broker.accepted).Data), Equals, "from amqp")
+
+	// a message that has passed through the bridge is accepted
+	// without being forwarded again
+	broker.address("in") <- &AMQPMessage{
+		Data:                  []byte("looped"),
+		ApplicationProperties: map[string]interface{}{ViaHeader: "test"},
+	}
+	c.Check(string((<-broker.accepted).Data), Equals, "looped")
+
+	b.Stop()
+	c.Check(<-done, Equals, ErrStopped)
+}
@@ -0,0 +1,311 @@
+/*
+Package bridge forwards messages between a local STOMP broker and a
+remote STOMP broker, so that brokers can be joined in hub-and-spoke and
+other topologies.
+
+A bridge connects to both brokers as a STOMP client. Each forwarding
+rule subscribes to a destination on one broker and sends the messages
+it receives to a destination on the other broker. A message is only
+acknowledged once the other broker has sent a RECEIPT for it, so
+messages from queues are delivered at least once even if a connection
+fails. Messages from topics are lost while the bridge is disconnected.
+
+If either connection fails, the bridge disconnects from both brokers
+and connects again, waiting between attempts as determined by
+stomp.Backoff.
+
+A Bridge, AMQPBridge or KafkaSink with a Spool instead stays connected
+to the local broker while the remote broker is unreachable, storing
+outbound messages in the spool file, including those sent to topics.
+Once the remote broker is reachable again, the spooled messages are
+forwarded in the order they were received, before any newer messages.
+
+Each message forwarded by a bridge has the bridge's name appended to its
+"bridge-via" header. A bridge never forwards a message that it has
+forwarded before, or that has already been forwarded by MaxHops bridges,
+so that messages do not loop between brokers that forward to each other.
+
+A DemandBridge links two brokers in both directions without rules,
+forwarding the messages sent to a destination only while the other
+broker has consumers of it.
+
+An AMQPBridge works in the same way, but forwards messages to and from
+an AMQP 1.0 broker, translating STOMP header entries to and from AMQP
+message properties. The AMQP connection is provided by the caller.
+
+A KafkaSink forwards messages from STOMP destinations to Kafka topics,
+and a KafkaSource forwards records from Kafka topics to STOMP
+destinations. The Kafka producer and consumer are provided by the caller.
+*/
+package bridge
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Header added to forwarded messages, containing a comma-separated
+// list of the names of the bridges that have forwarded the message.
+const ViaHeader = "bridge-via"
+
+// Default maximum number of bridges that can forward a message.
+const DefaultMaxHops = 8
+
+// Errors returned by the bridge.
+var (
+	ErrNoName  = errors.New("bridge: name is required")
+	ErrStopped = errors.New("bridge: stopped")
+)
+
+// Direction in which a rule forwards messages.
+type Direction int
+
+const (
+	// Forward messages from the local broker to the remote broker.
+	Outbound Direction = iota
+
+	// Forward messages from the remote broker to the local broker.
+	Inbound
+)
+
+// A Rule forwards the messages sent to a destination on one broker
+// to a destination on the other broker.
+type Rule struct {
+	Direction Direction
+
+	// Destination to subscribe to, on the local broker for outbound
+	// rules and on the remote broker for inbound rules.
+	Source string
+
+	// Destination to send messages to, on the other broker. If empty,
+	// messages are sent to a destination with the same name as Source.
+	Target string
+}
+
+// Returns the destination that a rule sends messages to.
+func (rule Rule) target() string {
+	if rule.Target == "" {
+		return rule.Source
+	}
+	return rule.Target
+}
+
+// An Endpoint specifies how to connect to a broker.
+type Endpoint struct {
+	Network string                    // Network for stomp.Dial, "tcp" if empty
+	Addr    string                    // Address of the broker
+	Options []func(*stomp.Conn) error // Connection options, such as login details
+}
+
+func (e Endpoint) dial() (*stomp.Conn, error) {
+	network := e.Network
+	if network == "" {
+		network = "tcp"
+	}
+	return stomp.Dial(network, e.Addr, e.Options...)
+}
+
+// A Bridge forwards messages between a local and a remote broker
+// according to its rules. The exported fields must not be changed
+// once Run has been called.
+type Bridge struct {
+	Name    string         // Identifies the bridge in the "bridge-via" header, required
+	Local   Endpoint       // Broker that the bridge runs alongside
+	Remote  Endpoint       // Broker that messages are forwarded to and from
+	Rules   []Rule         // Forwarding rules
+	MaxHops int            // Maximum number of bridges that can forward a message, DefaultMaxHops if zero
+	Backoff *stomp.Backoff // Delays between attempts to connect, the default delays if nil
+	Log     stomp.Logger   // Logger, the standard logger if nil
+
+	// Stores outbound messages while the remote broker is unreachable,
+	// if not nil. Otherwise outbound messages are left on the local
+	// broker while the bridge is disconnected.
+	Spool *Spool
+
+	runner runner
+}
+
+// Run connects to both brokers and forwards messages until Stop is
+// called, connecting again whenever a connection fails. Returns
+// ErrStopped once the bridge has stopped.
+func (b *Bridge) Run() error {
+	if b.Name == "" {
+		return ErrNoName
+	}
+	if b.Log == nil {
+		b.Log = log.StdLogger{}
+	}
+	if b.Backoff == nil {
+		b.Backoff = &stomp.Backoff{}
+	}
+	if b.Spool != nil {
+		return b.runner.run(b.Name, b.Backoff, b.Log, b.spooledSession)
+	}
+	return b.runner.run(b.Name, b.Backoff, b.Log, b.session)
+}
+
+// Stop the bridge, disconnecting from both brokers. Messages being
+// forwarded when the bridge stops are redelivered by the broker
+// that they came from.
+func (b *Bridge) Stop() {
+	b.runner.shutdown()
+}
+
+// Connects to both brokers and forwards messages until a connection
+// fails or the bridge is stopped.
+func (b *Bridge) session() error {
+	local, err := b.Local.dial()
+	if err != nil {
+		return err
+	}
+	remote, err := b.Remote.dial()
+	if err != nil {
+		local.MustDisconnect()
+		return err
+	}
+	if !b.runner.start(local.MustDisconnect, remote.MustDisconnect) {
+		return ErrStopped
+	}
+	defer b.runner.end()
+
+	errs := make(chan error, len(b.Rules))
+	for _, rule := range b.Rules {
+		source, target := local, remote
+		if rule.Direction == Inbound {
+			source, target = remote, local
+		}
+		if err := b.forward(rule, source, target, errs); err != nil {
+			return err
+		}
+	}
+	b.Backoff.Reset()
+	b.Log.Infof("bridge %s: connected to %s", b.Name, b.Remote.Addr)
+
+	// the first failure ends the session, which stops the other rules
+	return <-errs
+}
+
+// Connects to the local broker and spools its outbound messages, and
+// connects to the remote broker whenever it is reachable to forward the
+// spooled messages and inbound messages, until the local connection
+// fails or the bridge is stopped.
+func (b *Bridge) spooledSession() error {
+	local, err := b.Local.dial()
+	if err != nil {
+		return err
+	}
+	if !b.runner.start(local.MustDisconnect) {
+		return ErrStopped
+	}
+	defer b.runner.end()
+
+	localErrs := make(chan error, len(b.Rules))
+	targets := make(map[string]string)
+	for _, rule := range b.Rules {
+		if rule.Direction == Outbound {
+			if err := b.Spool.fill(local, rule.Source, localErrs); err != nil {
+				return err
+			}
+			targets[rule.Source] = rule.target()
+		}
+	}
+
+	return b.runner.drain(b.Name, b.Spool, b.Backoff, b.Log, localErrs, len(b.Rules),
+		func(remoteErrs chan<- error) (func(string, *stomp.Message) error, func() error, error) {
+			remote, err := b.Remote.dial()
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, rule := range b.Rules {
+				if rule.Direction == Inbound {
+					if err := b.forward(rule, remote, local, remoteErrs); err != nil {
+						remote.MustDisconnect()
+						return nil, nil, err
+					}
+				}
+			}
+			b.Log.Infof("bridge %s: connected to %s", b.Name, b.Remote.Addr)
+			send := func(source string, msg *stomp.Message) error {
+				target, ok := targets[source]
+				if !ok {
+					b.Log.Warningf("bridge %s: discarded spooled message from %s, which has no rule", b.Name, source)
+					return nil
+				}
+				return b.send(msg, remote, target)
+			}
+			return send, remote.MustDisconnect, nil
+		})
+}
+
+// Subscribes to the source destination of a rule, and forwards its
+// messages on a separate go-routine, which sends the error that
+// stops it to errs.
+func (b *Bridge) forward(rule Rule, source, target *stomp.Conn, errs chan<- error) error {
+	sub, err := source.Subscribe(rule.Source, stomp.AckClientIndividual)
+	if err != nil {
+		return err
+	}
+	destination := rule.target()
+
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				errs <- msg.Err
+				return
+			}
+			if err := b.send(msg, target, destination); err != nil {
+				errs <- err
+				return
+			}
+			if err := source.Ack(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- stomp.ErrClosedUnexpectedly
+	}()
+	return nil
+}
+
+// Headers of a MESSAGE frame that are not copied to the forwarded message.
+var skipHeaders = map[string]bool{
+	frame.Destination:   true,
+	frame.MessageId:     true,
+	frame.Subscription:  true,
+	frame.Ack:           true,
+	frame.ContentLength: true,
+	frame.ContentType:   true,
+	frame.Receipt:       true,
+	frame.Transaction:   true,
+	frame.Redelivered:   true,
+	ViaHeader:           true,
+}
+
+// Sends a message to the target broker and waits for the receipt,
+// unless the message has already passed through this bridge, or
+// through too many bridges.
+func (b *Bridge) send(msg *stomp.Message, target *stomp.Conn, destination string) error {
+	via := parseVia(msg.Header.Get(ViaHeader))
+	if !forwardable(b.Name, b.MaxHops, via, msg.Destination, b.Log) {
+		return nil
+	}
+	return sendVia(msg, target, destination, append(via, b.Name))
+}
+
+// Sends a copy of a message to the target broker with the names of
+// the bridges in via, and waits for the receipt.
+func sendVia(msg *stomp.Message, target *stomp.Conn, destination string, via []string) error {
+	opts := []func(*frame.Frame) error{stomp.SendOpt.Receipt}
+	for i := 0; i < msg.Header.Len(); i++ {
+		key, value := msg.Header.GetAt(i)
+		if !skipHeaders[key] {
+			opts = append(opts, stomp.SendOpt.Header(key, value))
+		}
+	}
+	opts = append(opts, stomp.SendOpt.Header(ViaHeader, strings.Join(via, ",")))
+	return target.Send(destination, msg.ContentType, msg.Body, opts...)
+}
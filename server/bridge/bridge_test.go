@@ -0,0 +1,189 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+	. "gopkg.in/check.v1"
+)
+
+func TestBridge(t *testing.T) {
+	TestingT(t)
+}
+
+type BridgeSuite struct{}
+
+var _ = Suite(&BridgeSuite{})
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, value ...interface{})   {}
+func (nopLogger) Infof(format string, value ...interface{})    {}
+func (nopLogger) Warningf(format string, value ...interface{}) {}
+func (nopLogger) Errorf(format string, value ...interface{})   {}
+func (nopLogger) Debug(message string)                         {}
+func (nopLogger) Info(message string)                          {}
+func (nopLogger) Warning(message string)                       {}
+func (nopLogger) Error(message string)                         {}
+
+// Starts a server, and returns its address.
+func startServer(c *C, addr string) (string, func()) {
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	go (&server.Server{Log: nopLogger{}}).Serve(l)
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// The server's ACK handling requires STOMP 1.1.
+func endpoint(addr string) Endpoint {
+	return Endpoint{Addr: addr, Options: []func(*stomp.Conn) error{stomp.ConnOpt.AcceptVersion(stomp.V11)}}
+}
+
+func dial(c *C, addr string) *stomp.Conn {
+	conn, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func receive(c *C, sub *stomp.Subscription) *stomp.Message {
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		return msg
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not received")
+	}
+	return nil
+}
+
+func (s *BridgeSuite) TestForward(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	remoteAddr, stopRemote := startServer(c, "127.0.0.1:0")
+	defer stopRemote()
+
+	b := &Bridge{
+		Name:   "test",
+		Local:  endpoint(localAddr),
+		Remote: endpoint(remoteAddr),
+		Rules: []Rule{
+			{Direction: Outbound, Source: "/queue/out", Target: "/queue/in"},
+			{Direction: Inbound, Source: "/queue/back"},
+		},
+		Log: nopLogger{},
+	}
+	done := make(chan error)
+	go func() { done <- b.Run() }()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	remote := dial(c, remoteAddr)
+	defer remote.Disconnect()
+
+	err := local.Send("/queue/out", "text/plain", []byte("hello"),
+		stomp.SendOpt.Header("x-custom", "value"))
+	c.Assert(err, IsNil)
+	sub, err := remote.Subscribe("/queue/in", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "hello")
+	c.Check(msg.ContentType, Equals, "text/plain")
+	c.Check(msg.Header.Get("x-custom"), Equals, "value")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "test")
+
+	err = remote.Send("/queue/back", "text/plain", []byte("reply"),
+		stomp.SendOpt.Header(ViaHeader, "spoke"))
+	c.Assert(err, IsNil)
+	sub, err = local.Subscribe("/queue/back", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = receive(c, sub)
+	c.Check(string(msg.Body), Equals, "reply")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "spoke,test")
+
+	b.Stop()
+	c.Check(<-done, Equals, ErrStopped)
+}
+
+func (s *BridgeSuite) TestLoopPrevention(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	remoteAddr, stopRemote := startServer(c, "127.0.0.1:0")
+	defer stopRemote()
+
+	b := &Bridge{
+		Name:    "test",
+		Local:   endpoint(localAddr),
+		Remote:  endpoint(remoteAddr),
+		Rules:   []Rule{{Direction: Outbound, Source: "/queue/out"}},
+		MaxHops: 2,
+		Log:     nopLogger{},
+	}
+	go b.Run()
+	defer b.Stop()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	remote := dial(c, remoteAddr)
+	defer remote.Disconnect()
+
+	// already forwarded by this bridge, and by too many bridges
+	for _, via := range []string{"other,test", "a,b"} {
+		err := local.Send("/queue/out", "text/plain", []byte(via),
+			stomp.SendOpt.Header(ViaHeader, via))
+		c.Assert(err, IsNil)
+	}
+	err := local.Send("/queue/out", "text/plain", []byte("forwarded"),
+		stomp.SendOpt.Header(ViaHeader, "a"))
+	c.Assert(err, IsNil)
+
+	sub, err := remote.Subscribe("/queue/out", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "forwarded")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "a,test")
+}
+
+func (s *BridgeSuite) TestReconnect(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+
+	// find an address that nothing is listening on yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	remoteAddr := l.Addr().String()
+	l.Close()
+
+	b := &Bridge{
+		Name:    "test",
+		Local:   endpoint(localAddr),
+		Remote:  endpoint(remoteAddr),
+		Rules:   []Rule{{Direction: Outbound, Source: "/queue/out"}},
+		Backoff: &stomp.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond},
+		Log:     nopLogger{},
+	}
+	go b.Run()
+	defer b.Stop()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	err = local.Send("/queue/out", "text/plain", []byte("waiting"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	time.Sleep(50 * time.Millisecond)
+
+	_, stopRemote := startServer(c, remoteAddr)
+	defer stopRemote()
+	remote := dial(c, remoteAddr)
+	defer remote.Disconnect()
+	sub, err := remote.Subscribe("/queue/out", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "waiting")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "test")
+}
+
+func (s *BridgeSuite) TestNoName(c *C) {
+	c.Check((&Bridge{}).Run(), Equals, ErrNoName)
+}
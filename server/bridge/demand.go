@@ -0,0 +1,224 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/wildcard"
+)
+
+// A DemandBridge links a local and a remote broker, forwarding the
+// messages sent to a destination on one broker to the other broker
+// only while the other broker has consumers of the destination. The
+// consumers of each broker are learned from its DemandAdvisory
+// topic, so both brokers must be servers from the server package.
+//
+// Messages sent to topics that have already been forwarded by a bridge
+// are not forwarded again, and the subscriptions of a DemandBridge are
+// not counted as consumers, so brokers that are linked by demand bridges
+// must be linked in a full mesh: a consumer only receives the messages
+// sent to the brokers that its own broker is linked to. Messages from
+// queues are moved to the other broker, and compete with the consumers
+// of the local broker.
+//
+// The exported fields must not be changed once Run has been called.
+type DemandBridge struct {
+	Name   string   // Identifies the bridge in the "bridge-via" header, required
+	Local  Endpoint // Broker that the bridge runs alongside
+	Remote Endpoint // Broker that messages are forwarded to and from
+
+	// Patterns of the destinations to forward, which can contain the
+	// wildcards of the wildcard package. If empty, all destinations
+	// are forwarded.
+	Destinations []string
+
+	Backoff *stomp.Backoff // Delays between attempts to connect, the default delays if nil
+	Log     stomp.Logger   // Logger, the standard logger if nil
+
+	runner runner
+}
+
+// Run connects to both brokers and forwards messages for which there
+// is demand until Stop is called, connecting again whenever a
+// connection fails. Returns ErrStopped once the bridge has stopped.
+func (b *DemandBridge) Run() error {
+	if b.Name == "" {
+		return ErrNoName
+	}
+	if b.Log == nil {
+		b.Log = log.StdLogger{}
+	}
+	if b.Backoff == nil {
+		b.Backoff = &stomp.Backoff{}
+	}
+	return b.runner.run(b.Name, b.Backoff, b.Log, b.session)
+}
+
+// Stop the bridge, disconnecting from both brokers.
+func (b *DemandBridge) Stop() {
+	b.runner.shutdown()
+}
+
+// Connects to both brokers and forwards messages in both directions
+// until a connection fails or the bridge is stopped.
+func (b *DemandBridge) session() error {
+	local, err := b.Local.dial()
+	if err != nil {
+		return err
+	}
+	remote, err := b.Remote.dial()
+	if err != nil {
+		local.MustDisconnect()
+		return err
+	}
+	if !b.runner.start(local.MustDisconnect, remote.MustDisconnect) {
+		return ErrStopped
+	}
+	defer b.runner.end()
+
+	// the first failure ends the session; later failures are discarded
+	errs := make(chan error, 1)
+	outbound := &demand{b: b, source: local, target: remote, errs: errs}
+	if err := outbound.watch(remote); err != nil {
+		return err
+	}
+	inbound := &demand{b: b, source: remote, target: local, errs: errs}
+	if err := inbound.watch(local); err != nil {
+		return err
+	}
+	b.Backoff.Reset()
+	b.Log.Infof("bridge %s: connected to %s", b.Name, b.Remote.Addr)
+	return <-errs
+}
+
+// Reports whether messages sent to a destination are forwarded.
+func (b *DemandBridge) forwards(destination string) bool {
+	if len(b.Destinations) == 0 {
+		return true
+	}
+	for _, pattern := range b.Destinations {
+		if wildcard.Match(pattern, destination) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwards messages from the source broker to the target broker for
+// the destinations that have consumers on the target broker.
+type demand struct {
+	b      *DemandBridge
+	source *stomp.Conn
+	target *stomp.Conn
+	errs   chan<- error
+	subs   map[string]*stomp.Subscription // subscriptions to the source broker, by destination
+}
+
+func (d *demand) fail(err error) {
+	select {
+	case d.errs <- err:
+	default:
+	}
+}
+
+// Subscribes to the consumer count advisories of the target broker,
+// and follows its demand on a separate go-routine.
+func (d *demand) watch(target *stomp.Conn) error {
+	sub, err := target.Subscribe(server.DemandAdvisory, stomp.AckAuto)
+	if err != nil {
+		return err
+	}
+	d.subs = make(map[string]*stomp.Subscription)
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				d.fail(msg.Err)
+				return
+			}
+			destination := msg.Header.Get(server.AdvisoryDestinationHeader)
+			count, err := strconv.Atoi(msg.Header.Get(server.ConsumerCountHeader))
+			if err != nil || !d.b.forwards(destination) {
+				continue
+			}
+			if err := d.update(destination, count); err != nil {
+				d.fail(err)
+				return
+			}
+		}
+		d.fail(stomp.ErrClosedUnexpectedly)
+	}()
+	return nil
+}
+
+// Subscribes to a destination on the source broker when the target
+// broker has consumers of it, and unsubscribes when it has none.
+func (d *demand) update(destination string, count int) error {
+	sub, subscribed := d.subs[destination]
+	switch {
+	case count > 0 && !subscribed:
+		ack := stomp.AckAuto
+		if strings.HasPrefix(destination, server.QueuePrefix) {
+			ack = stomp.AckClientIndividual
+		}
+		sub, err := d.source.Subscribe(destination, ack,
+			stomp.SubscribeOpt.Header(client.ForwardedHeader, d.b.Name))
+		if err != nil {
+			return err
+		}
+		d.subs[destination] = sub
+		go d.forward(sub)
+		d.b.Log.Debugf("bridge %s: forwarding %s", d.b.Name, destination)
+	case count == 0 && subscribed:
+		delete(d.subs, destination)
+		d.b.Log.Debugf("bridge %s: stopped forwarding %s", d.b.Name, destination)
+		return sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Reports whether a message should be forwarded, and returns the names
+// of the bridges that have forwarded it. Messages sent to a topic that
+// have been forwarded by a bridge have already reached the brokers that
+// it is linked to. Messages from a queue are moved rather than copied,
+// so they are forwarded until they reach a broker with a consumer, or
+// have been forwarded by DefaultMaxHops bridges.
+func (d *demand) forwardable(msg *stomp.Message) ([]string, bool) {
+	via := parseVia(msg.Header.Get(ViaHeader))
+	if !strings.HasPrefix(msg.Destination, server.QueuePrefix) {
+		return via, len(via) == 0
+	}
+	if len(via) >= DefaultMaxHops {
+		d.b.Log.Warningf("bridge %s: discarded message to %s forwarded by %d bridges",
+			d.b.Name, msg.Destination, len(via))
+		return via, false
+	}
+	return via, true
+}
+
+// Forwards the messages of a subscription to the target broker, until
+// the subscription ends.
+func (d *demand) forward(sub *stomp.Subscription) {
+	for msg := range sub.C {
+		if msg.Err != nil {
+			d.fail(msg.Err)
+			return
+		}
+		if via, ok := d.forwardable(msg); ok {
+			if err := sendVia(msg, d.target, msg.Destination, append(via, d.b.Name)); err != nil {
+				d.fail(err)
+				return
+			}
+		}
+		if err := d.source.Ack(msg); err != nil {
+			d.fail(err)
+			return
+		}
+	}
+	if sub.Active() {
+		d.fail(stomp.ErrClosedUnexpectedly)
+	}
+}
@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type DemandSuite struct{}
+
+var _ = Suite(&DemandSuite{})
+
+// Sends messages to a topic until one is received by a subscription.
+// Messages are only forwarded once the bridge has learned of demand.
+func publishUntilReceived(c *C, conn *stomp.Conn, sub *stomp.Subscription, topic string) *stomp.Message {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.Assert(conn.Send(topic, "text/plain", []byte("published"), stomp.SendOpt.Receipt), IsNil)
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			return msg
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	c.Fatal("message not received")
+	return nil
+}
+
+func (s *DemandSuite) TestForwardOnDemand(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	remoteAddr, stopRemote := startServer(c, "127.0.0.1:0")
+	defer stopRemote()
+
+	b := &DemandBridge{
+		Name:         "test",
+		Local:        endpoint(localAddr),
+		Remote:       endpoint(remoteAddr),
+		Destinations: []string{"/topic/>", "/queue/work"},
+		Log:          nopLogger{},
+	}
+	done := make(chan error)
+	go func() { done <- b.Run() }()
+	defer func() {
+		b.Stop()
+		c.Check(<-done, Equals, ErrStopped)
+	}()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	remote := dial(c, remoteAddr)
+	defer remote.Disconnect()
+
+	// a topic message published on one broker reaches subscribers on
+	// both brokers once, and is not forwarded back
+	localSub, err := local.Subscribe("/topic/news", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	remoteSub, err := remote.Subscribe("/topic/news", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := publishUntilReceived(c, local, remoteSub, "/topic/news")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "test")
+	time.Sleep(100 * time.Millisecond)
+	for len(localSub.C) > 0 {
+		msg = <-localSub.C
+		c.Check(msg.Header.Get(ViaHeader), Equals, "")
+	}
+
+	// queue messages are moved to the broker with a consumer
+	c.Assert(remote.Send("/queue/work", "text/plain", []byte("job"), stomp.SendOpt.Receipt), IsNil)
+	work, err := local.Subscribe("/queue/work", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = receive(c, work)
+	c.Check(string(msg.Body), Equals, "job")
+}
@@ -0,0 +1,410 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Header entries added to the messages that a KafkaSource sends to the
+// local broker, identifying the Kafka record that they came from.
+const (
+	KafkaTopicHeader     = "kafka-topic"
+	KafkaPartitionHeader = "kafka-partition"
+	KafkaOffsetHeader    = "kafka-offset"
+)
+
+// Default header for the key of a Kafka record.
+const DefaultKafkaKeyHeader = "kafka-key"
+
+// A KafkaHeader is a header of a Kafka record.
+type KafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+// A KafkaRecord is a record of a Kafka topic, with the parts that the
+// connectors translate to and from STOMP messages.
+type KafkaRecord struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte // nil if the record has no key
+	Value     []byte
+	Headers   []KafkaHeader
+}
+
+// KafkaProducer writes records to Kafka topics. The connectors do not
+// implement the Kafka protocol themselves: implement KafkaProducer and
+// KafkaConsumer with the Kafka client library of your choice.
+type KafkaProducer interface {
+	// Produce writes a record, returning once the brokers have
+	// acknowledged it.
+	Produce(record *KafkaRecord) error
+
+	// Close the producer.
+	Close() error
+}
+
+// KafkaConsumer reads records from Kafka topics, as a member of a
+// consumer group.
+type KafkaConsumer interface {
+	// Fetch waits for the next record.
+	Fetch() (*KafkaRecord, error)
+
+	// Commit the offset of a record, once it has been forwarded.
+	Commit(record *KafkaRecord) error
+
+	// Close the consumer, which causes Fetch to return an error.
+	Close() error
+}
+
+// A KafkaRule forwards messages between a STOMP destination on the
+// local broker and a Kafka topic.
+type KafkaRule struct {
+	// STOMP destination on the local broker.
+	Destination string
+
+	// Kafka topic. If empty, AddressOf(Destination) is used.
+	Topic string
+
+	// Header entry that holds the key of a record. A KafkaSink uses
+	// the value of the header entry as the key of the records that it
+	// produces, and records have no key if the entry is missing. A
+	// KafkaSource sets the header entry to the key of the records that
+	// it consumes. If empty, DefaultKafkaKeyHeader is used.
+	KeyHeader string
+}
+
+func (r KafkaRule) topic() string {
+	if r.Topic == "" {
+		return AddressOf(r.Destination)
+	}
+	return r.Topic
+}
+
+func (r KafkaRule) keyHeader() string {
+	if r.KeyHeader == "" {
+		return DefaultKafkaKeyHeader
+	}
+	return r.KeyHeader
+}
+
+// A KafkaSink forwards the messages sent to STOMP destinations on the
+// local broker to Kafka topics. A message is only acknowledged once the
+// Kafka brokers have acknowledged its record, so messages from queues
+// are delivered at least once even if a connection fails.
+//
+// Header entries of messages become headers of records, and the
+// "bridge-via" header is kept so that bridges can prevent loops. The
+// exported fields must not be changed once Run has been called.
+type KafkaSink struct {
+	Name    string                        // Identifies the sink in the "bridge-via" header, required
+	Local   Endpoint                      // STOMP broker that the sink runs alongside
+	Dial    func() (KafkaProducer, error) // Creates a Kafka producer, required
+	Rules   []KafkaRule                   // Forwarding rules
+	MaxHops int                           // Maximum number of bridges that can forward a message, DefaultMaxHops if zero
+	Backoff *stomp.Backoff                // Delays between attempts to connect, the default delays if nil
+	Log     stomp.Logger                  // Logger, the standard logger if nil
+
+	// Stores messages while Kafka is unreachable, if not nil. Otherwise
+	// messages are left on the broker while the sink is disconnected.
+	Spool *Spool
+
+	runner runner
+}
+
+// Run connects to the broker and forwards messages until Stop is
+// called, connecting again whenever a connection fails. Returns
+// ErrStopped once the sink has stopped.
+func (s *KafkaSink) Run() error {
+	if s.Name == "" {
+		return ErrNoName
+	}
+	if s.Log == nil {
+		s.Log = log.StdLogger{}
+	}
+	if s.Backoff == nil {
+		s.Backoff = &stomp.Backoff{}
+	}
+	if s.Spool != nil {
+		return s.runner.run(s.Name, s.Backoff, s.Log, s.spooledSession)
+	}
+	return s.runner.run(s.Name, s.Backoff, s.Log, s.session)
+}
+
+// Stop the sink, disconnecting from the broker and closing the Kafka
+// producer. Messages being forwarded when the sink stops are
+// redelivered by the broker.
+func (s *KafkaSink) Stop() {
+	s.runner.shutdown()
+}
+
+// Connects to the broker and forwards messages until a connection
+// fails or the sink is stopped.
+func (s *KafkaSink) session() error {
+	local, err := s.Local.dial()
+	if err != nil {
+		return err
+	}
+	producer, err := s.Dial()
+	if err != nil {
+		local.MustDisconnect()
+		return err
+	}
+	if !s.runner.start(local.MustDisconnect, producer.Close) {
+		return ErrStopped
+	}
+	defer s.runner.end()
+
+	errs := make(chan error, len(s.Rules))
+	for _, rule := range s.Rules {
+		if err := s.forward(rule, local, producer, errs); err != nil {
+			return err
+		}
+	}
+	s.Backoff.Reset()
+	s.Log.Infof("bridge %s: producing to Kafka", s.Name)
+
+	// the first failure ends the session, which stops the other rules
+	return <-errs
+}
+
+// Connects to the broker and spools its messages, and creates a Kafka
+// producer whenever Kafka is reachable to forward the spooled messages,
+// until the connection to the broker fails or the sink is stopped.
+func (s *KafkaSink) spooledSession() error {
+	local, err := s.Local.dial()
+	if err != nil {
+		return err
+	}
+	if !s.runner.start(local.MustDisconnect) {
+		return ErrStopped
+	}
+	defer s.runner.end()
+
+	localErrs := make(chan error, len(s.Rules))
+	rules := make(map[string]KafkaRule)
+	for _, rule := range s.Rules {
+		if err := s.Spool.fill(local, rule.Destination, localErrs); err != nil {
+			return err
+		}
+		rules[rule.Destination] = rule
+	}
+
+	return s.runner.drain(s.Name, s.Spool, s.Backoff, s.Log, localErrs, 0,
+		func(chan<- error) (func(string, *stomp.Message) error, func() error, error) {
+			producer, err := s.Dial()
+			if err != nil {
+				return nil, nil, err
+			}
+			s.Log.Infof("bridge %s: producing to Kafka", s.Name)
+			send := func(destination string, msg *stomp.Message) error {
+				rule, ok := rules[destination]
+				if !ok {
+					s.Log.Warningf("bridge %s: discarded spooled message from %s, which has no rule", s.Name, destination)
+					return nil
+				}
+				return s.produce(msg, producer, rule)
+			}
+			return send, producer.Close, nil
+		})
+}
+
+// Forwards messages from a STOMP destination to a Kafka topic.
+func (s *KafkaSink) forward(rule KafkaRule, source *stomp.Conn, producer KafkaProducer, errs chan<- error) error {
+	sub, err := source.Subscribe(rule.Destination, stomp.AckClientIndividual)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				errs <- msg.Err
+				return
+			}
+			if err := s.produce(msg, producer, rule); err != nil {
+				errs <- err
+				return
+			}
+			if err := source.Ack(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- stomp.ErrClosedUnexpectedly
+	}()
+	return nil
+}
+
+// Translates a message and produces it to the topic of a rule, unless
+// the message has already passed through this sink, or through too
+// many bridges.
+func (s *KafkaSink) produce(msg *stomp.Message, producer KafkaProducer, rule KafkaRule) error {
+	via := parseVia(msg.Header.Get(ViaHeader))
+	if !forwardable(s.Name, s.MaxHops, via, msg.Destination, s.Log) {
+		return nil
+	}
+	record := ToKafka(msg, rule.keyHeader())
+	record.Topic = rule.topic()
+	record.Headers = append(record.Headers,
+		KafkaHeader{Key: ViaHeader, Value: []byte(strings.Join(append(via, s.Name), ","))})
+	return producer.Produce(record)
+}
+
+// A KafkaSource forwards the records of Kafka topics to STOMP
+// destinations on the local broker. The offset of a record is only
+// committed once the broker has sent a RECEIPT for its message, so
+// records are delivered at least once even if a connection fails.
+//
+// Headers of records become header entries of messages, and the
+// "bridge-via" header is kept so that bridges can prevent loops. The
+// exported fields must not be changed once Run has been called.
+type KafkaSource struct {
+	Name    string                                       // Identifies the source in the "bridge-via" header, required
+	Local   Endpoint                                     // STOMP broker that the source runs alongside
+	Dial    func(topics []string) (KafkaConsumer, error) // Creates a Kafka consumer for topics, required
+	Rules   []KafkaRule                                  // Forwarding rules
+	MaxHops int                                          // Maximum number of bridges that can forward a message, DefaultMaxHops if zero
+	Backoff *stomp.Backoff                               // Delays between attempts to connect, the default delays if nil
+	Log     stomp.Logger                                 // Logger, the standard logger if nil
+
+	runner runner
+}
+
+// Run connects to the broker and forwards records until Stop is
+// called, connecting again whenever a connection fails. Returns
+// ErrStopped once the source has stopped.
+func (s *KafkaSource) Run() error {
+	if s.Name == "" {
+		return ErrNoName
+	}
+	if s.Log == nil {
+		s.Log = log.StdLogger{}
+	}
+	if s.Backoff == nil {
+		s.Backoff = &stomp.Backoff{}
+	}
+	return s.runner.run(s.Name, s.Backoff, s.Log, s.session)
+}
+
+// Stop the source, disconnecting from the broker and closing the
+// Kafka consumer. Records being forwarded when the source stops are
+// fetched again from their committed offsets.
+func (s *KafkaSource) Stop() {
+	s.runner.shutdown()
+}
+
+// Connects to the broker and forwards records until a connection
+// fails or the source is stopped.
+func (s *KafkaSource) session() error {
+	rules := make(map[string]KafkaRule)
+	var topics []string
+	for _, rule := range s.Rules {
+		if _, ok := rules[rule.topic()]; !ok {
+			topics = append(topics, rule.topic())
+		}
+		rules[rule.topic()] = rule
+	}
+
+	local, err := s.Local.dial()
+	if err != nil {
+		return err
+	}
+	consumer, err := s.Dial(topics)
+	if err != nil {
+		local.MustDisconnect()
+		return err
+	}
+	if !s.runner.start(local.MustDisconnect, consumer.Close) {
+		return ErrStopped
+	}
+	defer s.runner.end()
+	s.Backoff.Reset()
+	s.Log.Infof("bridge %s: consuming from Kafka", s.Name)
+
+	for {
+		record, err := consumer.Fetch()
+		if err != nil {
+			return err
+		}
+		rule, ok := rules[record.Topic]
+		if !ok {
+			s.Log.Warningf("bridge %s: discarded record from unexpected topic %s", s.Name, record.Topic)
+		} else if err := s.send(record, rule, local); err != nil {
+			return err
+		}
+		if err := consumer.Commit(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Sends a record to the local broker and waits for the receipt, unless
+// it has already passed through this bridge, or through too many bridges.
+func (s *KafkaSource) send(record *KafkaRecord, rule KafkaRule, target *stomp.Conn) error {
+	var via []string
+	for _, h := range record.Headers {
+		if h.Key == ViaHeader {
+			via = parseVia(string(h.Value))
+			break
+		}
+	}
+	if !forwardable(s.Name, s.MaxHops, via, rule.Destination, s.Log) {
+		return nil
+	}
+	opts := append(FromKafka(record, rule.keyHeader()), stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(ViaHeader, strings.Join(append(via, s.Name), ",")))
+	return target.Send(rule.Destination, "", record.Value, opts...)
+}
+
+// ToKafka translates a STOMP message to a Kafka record. The value of
+// the keyHeader header entry becomes the key of the record, and the
+// content type and other header entries of the message become headers
+// of the record, apart from "bridge-via" and the entries that describe
+// the delivery of the message. The topic of the record is not set.
+func ToKafka(msg *stomp.Message, keyHeader string) *KafkaRecord {
+	record := &KafkaRecord{Value: msg.Body}
+	if key, ok := msg.Header.Contains(keyHeader); ok {
+		record.Key = []byte(key)
+	}
+	if msg.ContentType != "" {
+		record.Headers = append(record.Headers,
+			KafkaHeader{Key: frame.ContentType, Value: []byte(msg.ContentType)})
+	}
+	for i := 0; i < msg.Header.Len(); i++ {
+		key, value := msg.Header.GetAt(i)
+		if key != keyHeader && !skipHeaders[key] {
+			record.Headers = append(record.Headers, KafkaHeader{Key: key, Value: []byte(value)})
+		}
+	}
+	return record
+}
+
+// FromKafka translates a Kafka record to options that set the header
+// entries of a STOMP SEND frame. The key of the record is set in the
+// keyHeader header entry, and the headers of the record, apart from
+// "bridge-via", become header entries. The record's topic, partition
+// and offset are set in the KafkaTopicHeader, KafkaPartitionHeader and
+// KafkaOffsetHeader header entries. The body is passed to
+// stomp.Conn.Send separately.
+func FromKafka(record *KafkaRecord, keyHeader string) []func(*frame.Frame) error {
+	opts := []func(*frame.Frame) error{
+		stomp.SendOpt.Header(KafkaTopicHeader, record.Topic),
+		stomp.SendOpt.Header(KafkaPartitionHeader, strconv.FormatInt(int64(record.Partition), 10)),
+		stomp.SendOpt.Header(KafkaOffsetHeader, strconv.FormatInt(record.Offset, 10)),
+	}
+	if record.Key != nil {
+		opts = append(opts, stomp.SendOpt.Header(keyHeader, string(record.Key)))
+	}
+	for _, h := range record.Headers {
+		if h.Key != ViaHeader && h.Key != keyHeader {
+			opts = append(opts, stomp.SendOpt.Header(h.Key, string(h.Value)))
+		}
+	}
+	return opts
+}
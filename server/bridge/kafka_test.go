@@ -0,0 +1,193 @@
+package bridge
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type KafkaSuite struct{}
+
+var _ = Suite(&KafkaSuite{})
+
+var errKafkaClosed = errors.New("kafka client closed")
+
+// A Kafka cluster with channels for produced, fetched and committed records.
+type fakeKafka struct {
+	produced  chan *KafkaRecord
+	fetched   chan *KafkaRecord
+	committed chan *KafkaRecord
+	topics    chan []string
+}
+
+func newFakeKafka() *fakeKafka {
+	return &fakeKafka{
+		produced:  make(chan *KafkaRecord, 10),
+		fetched:   make(chan *KafkaRecord, 10),
+		committed: make(chan *KafkaRecord, 10),
+		topics:    make(chan []string, 1),
+	}
+}
+
+func (k *fakeKafka) DialProducer() (KafkaProducer, error) {
+	return &fakeKafkaClient{kafka: k, closed: make(chan struct{})}, nil
+}
+
+func (k *fakeKafka) DialConsumer(topics []string) (KafkaConsumer, error) {
+	k.topics <- topics
+	return &fakeKafkaClient{kafka: k, closed: make(chan struct{})}, nil
+}
+
+type fakeKafkaClient struct {
+	kafka  *fakeKafka
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *fakeKafkaClient) Produce(record *KafkaRecord) error {
+	select {
+	case c.kafka.produced <- record:
+		return nil
+	case <-c.closed:
+		return errKafkaClosed
+	}
+}
+
+func (c *fakeKafkaClient) Fetch() (*KafkaRecord, error) {
+	select {
+	case record := <-c.kafka.fetched:
+		return record, nil
+	case <-c.closed:
+		return nil, errKafkaClosed
+	}
+}
+
+func (c *fakeKafkaClient) Commit(record *KafkaRecord) error {
+	c.kafka.committed <- record
+	return nil
+}
+
+func (c *fakeKafkaClient) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (s *KafkaSuite) TestTranslate(c *C) {
+	msg := &stomp.Message{
+		ContentType: "application/json",
+		Body:        []byte("{}"),
+		Header: frame.NewHeader(
+			frame.Destination, "/queue/orders",
+			frame.MessageId, "42",
+			"order-id", "1234",
+			"x-custom", "value"),
+	}
+	record := ToKafka(msg, "order-id")
+	c.Check(string(record.Key), Equals, "1234")
+	c.Check(string(record.Value), Equals, "{}")
+	c.Check(record.Headers, DeepEquals, []KafkaHeader{
+		{Key: frame.ContentType, Value: []byte("application/json")},
+		{Key: "x-custom", Value: []byte("value")},
+	})
+
+	// no key header entry
+	c.Check(ToKafka(msg, "customer-id").Key, IsNil)
+
+	record.Topic, record.Partition, record.Offset = "orders", 3, 99
+	f := frame.New(frame.SEND)
+	for _, opt := range FromKafka(record, "order-id") {
+		c.Assert(opt(f), IsNil)
+	}
+	c.Check(f.Header.Get(KafkaTopicHeader), Equals, "orders")
+	c.Check(f.Header.Get(KafkaPartitionHeader), Equals, "3")
+	c.Check(f.Header.Get(KafkaOffsetHeader), Equals, "99")
+	c.Check(f.Header.Get("order-id"), Equals, "1234")
+	c.Check(f.Header.Get(frame.ContentType), Equals, "application/json")
+	c.Check(f.Header.Get("x-custom"), Equals, "value")
+}
+
+func (s *KafkaSuite) TestSink(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	kafka := newFakeKafka()
+
+	sink := &KafkaSink{
+		Name:  "sink",
+		Local: endpoint(localAddr),
+		Dial:  kafka.DialProducer,
+		Rules: []KafkaRule{{Destination: "/queue/orders", KeyHeader: "order-id"}},
+		Log:   nopLogger{},
+	}
+	done := make(chan error)
+	go func() { done <- sink.Run() }()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	err := local.Send("/queue/orders", "text/plain", []byte("order"),
+		stomp.SendOpt.Header("order-id", "1234"))
+	c.Assert(err, IsNil)
+	select {
+	case record := <-kafka.produced:
+		c.Check(record.Topic, Equals, "orders")
+		c.Check(string(record.Key), Equals, "1234")
+		c.Check(string(record.Value), Equals, "order")
+		c.Check(record.Headers[len(record.Headers)-1], DeepEquals,
+			KafkaHeader{Key: ViaHeader, Value: []byte("sink")})
+	case <-time.After(5 * time.Second):
+		c.Fatal("record not produced")
+	}
+
+	sink.Stop()
+	c.Check(<-done, Equals, ErrStopped)
+}
+
+func (s *KafkaSuite) TestSource(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	kafka := newFakeKafka()
+
+	source := &KafkaSource{
+		Name:  "source",
+		Local: endpoint(localAddr),
+		Dial:  kafka.DialConsumer,
+		Rules: []KafkaRule{{Destination: "/queue/payments", Topic: "payments"}},
+		Log:   nopLogger{},
+	}
+	done := make(chan error)
+	go func() { done <- source.Run() }()
+	c.Check(<-kafka.topics, DeepEquals, []string{"payments"})
+
+	kafka.fetched <- &KafkaRecord{
+		Topic:   "payments",
+		Offset:  7,
+		Key:     []byte("abc"),
+		Value:   []byte("paid"),
+		Headers: []KafkaHeader{{Key: ViaHeader, Value: []byte("other")}},
+	}
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	sub, err := local.Subscribe("/queue/payments", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "paid")
+	c.Check(msg.Header.Get(DefaultKafkaKeyHeader), Equals, "abc")
+	c.Check(msg.Header.Get(KafkaOffsetHeader), Equals, "7")
+	c.Check(msg.Header.Get(ViaHeader), Equals, "other,source")
+	c.Check((<-kafka.committed).Offset, Equals, int64(7))
+
+	// a record that has passed through the source is committed
+	// without being forwarded again
+	kafka.fetched <- &KafkaRecord{
+		Topic:   "payments",
+		Offset:  8,
+		Headers: []KafkaHeader{{Key: ViaHeader, Value: []byte("source")}},
+	}
+	c.Check((<-kafka.committed).Offset, Equals, int64(8))
+
+	source.Stop()
+	c.Check(<-done, Equals, ErrStopped)
+}
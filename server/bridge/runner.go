@@ -0,0 +1,210 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// Runs the sessions of a bridge, starting a new session whenever one
+// fails, until the bridge is stopped.
+type runner struct {
+	mutex   sync.Mutex
+	stop    chan struct{}  // closed when the bridge is stopped
+	closers []func() error // close the connections of the current session
+	remote  func() error   // closes the remote connection of a spooled session, nil if not connected
+}
+
+// Calls session until the bridge is stopped, waiting between calls as
+// determined by backoff. A session should call start once it has
+// connected, and return when a connection fails.
+func (r *runner) run(name string, backoff *stomp.Backoff, log stomp.Logger, session func() error) error {
+	stop := r.stopChannel()
+	for {
+		err := session()
+		select {
+		case <-stop:
+			return ErrStopped
+		default:
+		}
+		log.Errorf("bridge %s: %v", name, err)
+
+		select {
+		case <-stop:
+			return ErrStopped
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+// Records the functions that close the connections of the current
+// session, so that they can be closed when the bridge is stopped.
+// If the bridge has already been stopped, the connections are closed
+// and false is returned.
+func (r *runner) start(closers ...func() error) bool {
+	stop := r.stopChannel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	select {
+	case <-stop:
+		for _, close := range closers {
+			close()
+		}
+		r.closers = nil
+		return false
+	default:
+		r.closers = closers
+		return true
+	}
+}
+
+// Closes the connections of the current session.
+func (r *runner) end() {
+	r.mutex.Lock()
+	closers := r.closers
+	if r.remote != nil {
+		closers = append(closers, r.remote)
+	}
+	r.closers, r.remote = nil, nil
+	r.mutex.Unlock()
+	for _, close := range closers {
+		close()
+	}
+}
+
+// Records the function that closes the remote connection of a spooled
+// session. If the bridge has already been stopped, the connection is
+// closed and false is returned.
+func (r *runner) setRemote(close func() error) bool {
+	stop := r.stopChannel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	select {
+	case <-stop:
+		close()
+		return false
+	default:
+		r.remote = close
+		return true
+	}
+}
+
+// Closes the remote connection of a spooled session.
+func (r *runner) closeRemote() {
+	r.mutex.Lock()
+	close := r.remote
+	r.remote = nil
+	r.mutex.Unlock()
+	if close != nil {
+		close()
+	}
+}
+
+// Connects to the remote broker with connect and forwards the messages
+// in a spool with the function that it returns, connecting again
+// whenever the remote connection fails, until an error is received
+// from localErrs or the bridge is stopped. Connect is passed a channel
+// with room for n errors, to which the remote connection reports
+// failures. Messages are spooled while the remote broker is
+// unreachable, so a failed remote connection does not end the session.
+func (r *runner) drain(name string, spool *Spool, backoff *stomp.Backoff, log stomp.Logger,
+	localErrs <-chan error, n int, connect func(remoteErrs chan<- error) (func(string, *stomp.Message) error, func() error, error)) error {
+	stop := r.stopChannel()
+	for {
+		remoteErrs := make(chan error, n+1)
+		send, disconnect, err := connect(remoteErrs)
+		if err == nil {
+			if !r.setRemote(disconnect) {
+				return ErrStopped
+			}
+			backoff.Reset()
+			done := make(chan struct{})
+			drained := make(chan struct{})
+			go func() {
+				spool.drain(send, done, remoteErrs)
+				close(drained)
+			}()
+			localFailed := false
+			select {
+			case err = <-localErrs:
+				localFailed = true
+			case err = <-remoteErrs:
+			}
+			// closing the remote connection stops a send in progress
+			r.closeRemote()
+			close(done)
+			<-drained
+			if localFailed {
+				return err
+			}
+		}
+		log.Errorf("bridge %s: %v (%d messages spooled)", name, err, spool.Len())
+
+		select {
+		case err := <-localErrs:
+			return err
+		case <-stop:
+			return ErrStopped
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+// Stops the bridge, closing the connections of the current session.
+func (r *runner) shutdown() {
+	stop := r.stopChannel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	select {
+	case <-stop:
+	default:
+		close(stop)
+	}
+	for _, close := range r.closers {
+		close()
+	}
+	if r.remote != nil {
+		r.remote()
+		r.remote = nil
+	}
+}
+
+func (r *runner) stopChannel() chan struct{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+	}
+	return r.stop
+}
+
+// Returns the names of the bridges that have forwarded a message,
+// from the value of its "bridge-via" header.
+func parseVia(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, ",")
+}
+
+// Reports whether the bridge called name should forward a message that
+// has been forwarded by the bridges in via. It should not if it has
+// forwarded the message before, or if maxHops bridges have.
+func forwardable(name string, maxHops int, via []string, destination string, log stomp.Logger) bool {
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	for _, n := range via {
+		if n == name {
+			return false
+		}
+	}
+	if len(via) >= maxHops {
+		log.Warningf("bridge %s: discarded message to %s forwarded by %d bridges",
+			name, destination, len(via))
+		return false
+	}
+	return true
+}
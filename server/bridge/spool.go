@@ -0,0 +1,197 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the bucket containing spooled messages.
+var spoolBucket = []byte("spool")
+
+// Header entry of a spooled message that identifies the rule that
+// spooled it. It is removed when the message is read from the spool.
+const spoolRuleHeader = "bridge-spool-rule"
+
+// A Spool stores the outbound messages of a bridge in a file while the
+// remote broker is unreachable, so that they can be forwarded in the
+// order they were received once the bridge has connected to it. A
+// message is acknowledged to the local broker once it has been written
+// to the spool, and removed from the spool once the remote broker has
+// accepted it.
+//
+// A spool must only be used by one bridge at a time.
+type Spool struct {
+	db     *bolt.DB
+	notify chan struct{} // signalled when a message is added
+}
+
+// OpenSpool opens the spool stored in the file at path, creating it
+// if it does not exist. Messages left in the spool when it was last
+// closed are forwarded first.
+func OpenSpool(path string) (*Spool, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(spoolBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Spool{db: db, notify: make(chan struct{}, 1)}, nil
+}
+
+// Close the spool. It must not be closed while a bridge is using it.
+func (s *Spool) Close() error {
+	return s.db.Close()
+}
+
+// Len returns the number of messages in the spool.
+func (s *Spool) Len() int {
+	n := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(spoolBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Adds a message received by the rule identified by rule to the
+// tail of the spool.
+func (s *Spool) put(rule string, msg *stomp.Message) error {
+	f := frame.New(frame.MESSAGE)
+	f.Header = msg.Header.Clone()
+	f.Header.Set(spoolRuleHeader, rule)
+	f.Body = msg.Body
+	var buf bytes.Buffer
+	if err := frame.NewWriter(&buf).Write(f); err != nil {
+		return err
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spoolBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns the message at the head of the spool with its key and the
+// rule that spooled it, or a nil message if the spool is empty.
+func (s *Spool) first() (key []byte, rule string, msg *stomp.Message, err error) {
+	var value []byte
+	err = s.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(spoolBucket).Cursor().First()
+		if k != nil {
+			key = append([]byte(nil), k...)
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || key == nil {
+		return nil, "", nil, err
+	}
+	f, err := frame.NewReader(bytes.NewReader(value)).Read()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	rule = f.Header.Get(spoolRuleHeader)
+	f.Header.Del(spoolRuleHeader)
+	msg = &stomp.Message{
+		Destination: f.Header.Get(frame.Destination),
+		ContentType: f.Header.Get(frame.ContentType),
+		Header:      f.Header,
+		Body:        f.Body,
+	}
+	return key, rule, msg, nil
+}
+
+// Removes the message with a key from the spool.
+func (s *Spool) remove(key []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(spoolBucket).Delete(key)
+	})
+}
+
+// Sends the messages in the spool with send, in order, waiting for
+// more messages when it is empty, until done is closed. The error that
+// stops it is sent to errs.
+func (s *Spool) drain(send func(rule string, msg *stomp.Message) error, done <-chan struct{}, errs chan<- error) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		key, rule, msg, err := s.first()
+		if err != nil {
+			errs <- err
+			return
+		}
+		if msg == nil {
+			select {
+			case <-done:
+				return
+			case <-s.notify:
+			}
+			continue
+		}
+		if err := send(rule, msg); err != nil {
+			errs <- err
+			return
+		}
+		if err := s.remove(key); err != nil {
+			errs <- err
+			return
+		}
+	}
+}
+
+// Subscribes to a destination on the local broker, and adds its
+// messages to the spool on a separate go-routine, acknowledging each
+// message once it has been added. The error that stops it is sent to
+// errs.
+func (s *Spool) fill(local *stomp.Conn, destination string, errs chan<- error) error {
+	sub, err := local.Subscribe(destination, stomp.AckClientIndividual)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				errs <- msg.Err
+				return
+			}
+			if err := s.put(destination, msg); err != nil {
+				errs <- err
+				return
+			}
+			if err := local.Ack(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- stomp.ErrClosedUnexpectedly
+	}()
+	return nil
+}
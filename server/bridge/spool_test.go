@@ -0,0 +1,162 @@
+package bridge
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type SpoolSuite struct{}
+
+var _ = Suite(&SpoolSuite{})
+
+func (s *SpoolSuite) TestOrder(c *C) {
+	path := filepath.Join(c.MkDir(), "spool.db")
+	spool, err := OpenSpool(path)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"one", "two"} {
+		msg := &stomp.Message{
+			Destination: "/topic/events",
+			ContentType: "text/plain",
+			Header:      frame.NewHeader(frame.Destination, "/topic/events", frame.ContentType, "text/plain", "custom", body),
+			Body:        []byte(body),
+		}
+		c.Assert(spool.put("/topic/>", msg), IsNil)
+	}
+	c.Assert(spool.Close(), IsNil)
+
+	// messages are kept when the spool is reopened
+	spool, err = OpenSpool(path)
+	c.Assert(err, IsNil)
+	defer spool.Close()
+	c.Check(spool.Len(), Equals, 2)
+	for _, body := range []string{"one", "two"} {
+		key, rule, msg, err := spool.first()
+		c.Assert(err, IsNil)
+		c.Check(rule, Equals, "/topic/>")
+		c.Check(msg.Destination, Equals, "/topic/events")
+		c.Check(msg.ContentType, Equals, "text/plain")
+		c.Check(msg.Header.Get("custom"), Equals, body)
+		_, ok := msg.Header.Contains(spoolRuleHeader)
+		c.Check(ok, Equals, false)
+		c.Check(string(msg.Body), Equals, body)
+		c.Assert(spool.remove(key), IsNil)
+	}
+	_, _, msg, err := spool.first()
+	c.Check(msg, IsNil)
+	c.Check(err, IsNil)
+}
+
+func (s *SpoolSuite) TestRemoteOutage(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+
+	// reserve an address for the remote broker, which is not running yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	remoteAddr := l.Addr().String()
+	l.Close()
+
+	spool, err := OpenSpool(filepath.Join(c.MkDir(), "spool.db"))
+	c.Assert(err, IsNil)
+	defer spool.Close()
+	b := &Bridge{
+		Name:    "test",
+		Local:   endpoint(localAddr),
+		Remote:  endpoint(remoteAddr),
+		Rules:   []Rule{{Direction: Outbound, Source: "/queue/out", Target: "/queue/in"}},
+		Backoff: &stomp.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond},
+		Log:     nopLogger{},
+		Spool:   spool,
+	}
+	done := make(chan error)
+	go func() { done <- b.Run() }()
+	defer func() {
+		b.Stop()
+		c.Check(<-done, Equals, ErrStopped)
+	}()
+
+	// messages are taken from the local broker while the remote
+	// broker is unreachable
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	bodies := []string{"one", "two", "three"}
+	for _, body := range bodies {
+		c.Assert(local.Send("/queue/out", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() < len(bodies) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(spool.Len(), Equals, len(bodies))
+
+	// and forwarded in order once it is reachable
+	_, stopRemote := startServer(c, remoteAddr)
+	defer stopRemote()
+	remote := dial(c, remoteAddr)
+	defer remote.Disconnect()
+	sub, err := remote.Subscribe("/queue/in", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for _, body := range bodies {
+		msg := receive(c, sub)
+		c.Check(string(msg.Body), Equals, body)
+		c.Check(msg.Header.Get(ViaHeader), Equals, "test")
+	}
+	c.Assert(local.Send("/queue/out", "text/plain", []byte("four"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, "four")
+}
+
+func (s *SpoolSuite) TestKafkaOutage(c *C) {
+	localAddr, stopLocal := startServer(c, "127.0.0.1:0")
+	defer stopLocal()
+	kafka := newFakeKafka()
+	reachable := make(chan struct{})
+
+	spool, err := OpenSpool(filepath.Join(c.MkDir(), "spool.db"))
+	c.Assert(err, IsNil)
+	defer spool.Close()
+	sink := &KafkaSink{
+		Name:  "sink",
+		Local: endpoint(localAddr),
+		Dial: func() (KafkaProducer, error) {
+			select {
+			case <-reachable:
+				return kafka.DialProducer()
+			default:
+				return nil, errors.New("kafka unreachable")
+			}
+		},
+		Rules:   []KafkaRule{{Destination: "/queue/orders"}},
+		Backoff: &stomp.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond},
+		Log:     nopLogger{},
+		Spool:   spool,
+	}
+	done := make(chan error)
+	go func() { done <- sink.Run() }()
+
+	local := dial(c, localAddr)
+	defer local.Disconnect()
+	c.Assert(local.Send("/queue/orders", "text/plain", []byte("order"), stomp.SendOpt.Receipt), IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(spool.Len(), Equals, 1)
+
+	close(reachable)
+	select {
+	case record := <-kafka.produced:
+		c.Check(record.Topic, Equals, "orders")
+		c.Check(string(record.Value), Equals, "order")
+	case <-time.After(5 * time.Second):
+		c.Fatal("record not produced")
+	}
+
+	sink.Stop()
+	c.Check(<-done, Equals, ErrStopped)
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/metrics"
 )
 
 // Contains information the client package needs from the
@@ -20,6 +22,66 @@ type Config interface {
 	// maximum permitted values.
 	HeartBeat() time.Duration
 
+	// Reports whether frames with the specified command are permitted
+	// for this connection. Frames that are not permitted are rejected
+	// with an ERROR frame.
+	Permitted(command string) bool
+
 	// Logger provides the logger for a client
 	Logger() stomp.Logger
+
+	// Metrics provides the measurements recorded for a client, or
+	// nil if measurements are not recorded.
+	Metrics() *metrics.Metrics
+
+	// Tracer starts the spans of messages dispatched to and
+	// acknowledged by a client, or is nil if spans are not recorded.
+	Tracer() stomp.Tracer
+
+	// Interceptors inspect the frames received from and sent to a
+	// client. Inbound frames are passed to them in order, and
+	// outbound frames in reverse order.
+	Interceptors() []Interceptor
+
+	// MaxPendingWrites is the number of frames that can wait to be
+	// written to a client before senders are held up, or the slow
+	// consumer policy of a subscription applies. If this returns
+	// zero, DefaultMaxPendingWrites is used.
+	MaxPendingWrites() int
+
+	// MaxPendingReads is the number of frames read from a client that
+	// can wait to be processed before reading from the client stops.
+	// If this returns zero, DefaultMaxPendingReads is used.
+	MaxPendingReads() int
+
+	// HeartBeatGracePeriodMultiplier is multiplied by the negotiated
+	// interval of the heart-beats sent by a client to give the time
+	// that the client may be silent before the connection is closed,
+	// so that heart-beats that are a little late are tolerated. If
+	// this returns zero or less, DefaultHeartBeatGracePeriodMultiplier
+	// is used.
+	HeartBeatGracePeriodMultiplier() float64
+
+	// Validation is the mode in which the frames received from a
+	// client are validated, see frame.Frame.Validate. Frames from
+	// clients of versions before STOMP 1.2 are validated leniently.
+	Validation() frame.ValidationMode
+
+	// Memory counts the bytes of the frames held for a client, and
+	// holds up the client while it sends messages and the server
+	// holds too much, or is nil if memory is not counted.
+	Memory() *MemoryMeter
+
+	// Faults makes the frames and heart-beats of a client fail, for
+	// testing, or is nil if no faults are injected.
+	Faults() FaultInjector
+
+	// ServerName identifies the server in the server header entry of
+	// the CONNECTED frame, as name/version.
+	ServerName() string
+
+	// Capabilities are the optional features of the server, which are
+	// advertised to clients in the CONNECTED frame. The frames read
+	// from a client are limited to the MaxFrameSize.
+	Capabilities() stomp.Capabilities
 }
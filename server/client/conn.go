@@ -5,41 +5,107 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/metrics"
 )
 
-// Maximum number of pending frames allowed to a client.
-// before a disconnect occurs. If the client cannot keep
-// up with the server, we do not want the server to backlog
-// pending frames indefinitely.
-const maxPendingWrites = 16
-
-// Maximum number of pending frames allowed before the read
-// go routine starts blocking.
-const maxPendingReads = 16
+// Default maximum number of frames waiting to be written to a
+// client, if Config.MaxPendingWrites returns zero. If the client
+// cannot keep up with the server, we do not want the server to
+// backlog pending frames indefinitely, so senders are held up or
+// the subscription's slow consumer policy applies.
+const DefaultMaxPendingWrites = 16
+
+// Default maximum number of frames read from a client and waiting to
+// be processed before the read go routine starts blocking, if
+// Config.MaxPendingReads returns zero.
+const DefaultMaxPendingReads = 16
+
+// Default multiplier of the interval of the heart-beats sent by a
+// client, which gives the time that the client may be silent before
+// the connection is closed, if Config.HeartBeatGracePeriodMultiplier
+// returns zero.
+const DefaultHeartBeatGracePeriodMultiplier = 2.0
+
+// Time that the server waits for a client to close its connection
+// after the client has sent a DISCONNECT frame.
+const disconnectLinger = time.Second
+
+// Longest time that the receipts of the frames received before a
+// DISCONNECT frame are waited for, before the DISCONNECT receipt is sent.
+const pendingReceiptsTimeout = 10 * time.Second
+
+// The last connection id allocated.
+var lastConnId uint64
+
+// A frame on the write channel, with the number of bytes counted by the
+// memory meter while it waits there. The size is taken before the frame
+// is passed to the processLoop go-routine, which owns the frame from
+// then on and adds headers to it.
+type heldFrame struct {
+	frame *frame.Frame
+	size  int64
+}
 
 // Represents a connection with the STOMP client.
+//
+// Channel ownership: the readChannel is written to and closed by the
+// readLoop go-routine only. The subChannel and writeChannel are written to
+// by the upper layer and are never closed; instead the done channel is
+// closed when the connection starts shutting down, and from then on no
+// more frames are accepted from the upper layer. All other state is owned
+// by the processLoop go-routine.
 type Conn struct {
-	config         Config
-	rw             net.Conn                            // Network connection to client
-	writer         *frame.Writer                       // Writes STOMP frames directly to the network connection
-	requestChannel chan Request                        // For sending requests to upper layer
-	subChannel     chan *Subscription                  // Receives subscription messages for client
-	writeChannel   chan *frame.Frame                   // Receives unacknowledged (topic) messages for client
-	readChannel    chan *frame.Frame                   // Receives frames from the client
-	stateFunc      func(c *Conn, f *frame.Frame) error // State processing function
-	writeTimeout   time.Duration                       // Heart beat write timeout
-	version        stomp.Version                       // Negotiated STOMP protocol version
-	closed         bool                                // Is the connection closed
-	txStore        *txStore                            // Stores transactions in progress
-	lastMsgId      uint64                              // last message-id value
-	subList        *SubscriptionList                   // List of subscriptions requiring acknowledgement
-	subs           map[string]*Subscription            // All subscriptions, keyed by id
-	validator      stomp.Validator                     // For validating STOMP frames
-	log            stomp.Logger
+	id                string // Identifies the connection while the process runs
+	config            Config
+	rw                net.Conn                            // Network connection to client
+	writer            *frame.Writer                       // Writes STOMP frames directly to the network connection
+	requestChannel    chan Request                        // For sending requests to upper layer
+	subChannel        chan *Subscription                  // Receives subscription messages for client
+	writeChannel      chan heldFrame                      // Receives unacknowledged (topic) messages for client
+	abortChannel      chan *Subscription                  // Receives subscriptions removed because the client is a slow consumer
+	slowChannel       chan struct{}                       // Signalled when the client is disconnected as a slow consumer
+	readChannel       chan *frame.Frame                   // Receives frames from the client
+	stateFunc         func(c *Conn, f *frame.Frame) error // State processing function
+	writeTimeout      time.Duration                       // Heart beat write timeout
+	readHeartBeat     time.Duration                       // Negotiated interval of the client's heart-beats
+	wrote             bool                                // Written to since the heart-beat timer was reset, used only by processLoop
+	version           stomp.Version                       // Negotiated STOMP protocol version
+	capabilities      stomp.Capabilities                  // Advertised to the client, see Config.Capabilities
+	done              chan struct{}                       // Closed when the connection is shutting down
+	cleanedUp         chan struct{}                       // Closed when the connection has been cleaned up
+	closeOnce         sync.Once                           // Ensures done is closed only once
+	closeConnOnce     sync.Once                           // Ensures the network connection is closed only once
+	closeMutex        sync.RWMutex                        // Held for reading while sending to subChannel, writeChannel
+	closed            bool                                // Is the connection closed, protected by closeMutex
+	txStore           *txStore                            // Stores transactions in progress
+	lastMsgId         uint64                              // sequence number of the last message-id
+	msgIdPrefix       string                              // prefix of message-ids, followed by the sequence number
+	subList           *SubscriptionList                   // List of subscriptions requiring acknowledgement
+	subs              map[string]*Subscription            // All subscriptions, keyed by id
+	validator         stomp.Validator                     // For validating STOMP frames
+	requestId         string                              // Correlation id of the client frame being processed
+	receiptMutex      sync.Mutex                          // Protects receipts
+	receipts          []string                            // Receipts from the upper layer waiting to be sent
+	receiptReady      chan struct{}                       // Signalled when receipts are added
+	pendingReceipts   int                                 // Receipts requested from the upper layer and not sent yet, used only by processLoop
+	disconnectReceipt string                              // Receipt of the DISCONNECT frame, used only by processLoop
+	timeoutChannel    chan time.Duration                  // Passes the negotiated interval of the client's heart-beats to readLoop
+	metrics           *metrics.Metrics                    // Records measurements, nil if none are recorded
+	tracer            stomp.Tracer                        // Starts spans of messages, nil if none are recorded
+	interceptors      []Interceptor                       // Inspect frames received from and sent to the client
+	faults            FaultInjector                       // Makes frames and heart-beats fail, nil if none
+	log               stomp.Logger                        // Attaches the remote address, and login once connected
+	login             string                              // Login of the client, set before ConnectedOp
+	connectedAt       time.Time                           // When the client connected, set before ConnectedOp
+	stats             *connStats                          // Counts activity, read by any go-routine
+	memory            *MemoryMeter                        // Counts frames held in memory, nil if none are counted
 }
 
 // Creates a new client connection. The config parameter contains
@@ -48,29 +114,251 @@ type Conn struct {
 // the client. All client requests are sent via the ch channel to the
 // upper layer.
 func NewConn(config Config, rw net.Conn, ch chan Request) *Conn {
+	maxPendingWrites := config.MaxPendingWrites()
+	if maxPendingWrites <= 0 {
+		maxPendingWrites = DefaultMaxPendingWrites
+	}
+	maxPendingReads := config.MaxPendingReads()
+	if maxPendingReads <= 0 {
+		maxPendingReads = DefaultMaxPendingReads
+	}
 	c := &Conn{
+		id:             strconv.FormatUint(atomic.AddUint64(&lastConnId, 1), 10),
 		config:         config,
 		rw:             rw,
 		requestChannel: ch,
 		subChannel:     make(chan *Subscription, maxPendingWrites),
-		writeChannel:   make(chan *frame.Frame, maxPendingWrites),
+		writeChannel:   make(chan heldFrame, maxPendingWrites),
 		readChannel:    make(chan *frame.Frame, maxPendingReads),
-		txStore:        &txStore{},
+		done:           make(chan struct{}),
+		cleanedUp:      make(chan struct{}),
+		receiptReady:   make(chan struct{}, 1),
+		timeoutChannel: make(chan time.Duration, 1),
+		abortChannel:   make(chan *Subscription, maxPendingWrites),
+		slowChannel:    make(chan struct{}, 1),
+		txStore:        &txStore{memory: config.Memory()},
 		subList:        NewSubscriptionList(),
 		subs:           make(map[string]*Subscription),
-		log:            config.Logger(),
+		metrics:        config.Metrics(),
+		tracer:         config.Tracer(),
+		interceptors:   config.Interceptors(),
+		faults:         config.Faults(),
+		log:            stomp.WithFields(config.Logger(), stomp.Field{Key: stomp.RemoteAddrField, Value: rw.RemoteAddr()}),
+		stats:          &connStats{},
+		memory:         config.Memory(),
+		capabilities:   config.Capabilities(),
 	}
+	c.msgIdPrefix = IdPrefix + "-" + c.id + "-"
+	c.rw = &countingConn{Conn: rw, stats: c.stats}
 	go c.readLoop()
 	go c.processLoop()
 	return c
 }
 
+// Id returns the id of the connection, which is unique among the
+// connections accepted by the process.
+func (c *Conn) Id() string {
+	return c.id
+}
+
+// RemoteAddr returns the network address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.rw.RemoteAddr()
+}
+
+// The following methods describe the client once it has connected,
+// and can be called by the upper layer after it has received the
+// ConnectedOp request for the connection.
+
+// Login returns the login of the client, or an empty string if the
+// client did not send one.
+func (c *Conn) Login() string {
+	return c.login
+}
+
+// Version returns the version of the STOMP protocol negotiated with
+// the client.
+func (c *Conn) Version() stomp.Version {
+	return c.version
+}
+
+// ConnectedAt returns the time that the client connected.
+func (c *Conn) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// Negotiated returns what the server agreed on with the client, as
+// sent in the CONNECTED frame. The session identifier is the id of
+// the connection.
+func (c *Conn) Negotiated() stomp.Negotiated {
+	return stomp.Negotiated{
+		Version:          c.version,
+		Session:          c.id,
+		Server:           c.config.ServerName(),
+		Capabilities:     c.capabilities,
+		SendHeartBeat:    c.writeTimeout,
+		ReceiveHeartBeat: c.readHeartBeat,
+	}
+}
+
 // Write a frame to the connection without requiring
-// any acknowledgement.
+// any acknowledgement. If the connection is closed, the
+// frame is discarded.
 func (c *Conn) Send(f *frame.Frame) {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return
+	}
+
 	// Place the frame on the write channel. If the
-	// write channel is full, the caller will block.
-	c.writeChannel <- f
+	// write channel is full, the caller will block
+	// until there is room or the connection closes.
+	held := c.hold(f)
+	select {
+	case c.writeChannel <- held:
+	case <-c.done:
+		c.memory.Add(-held.size)
+	}
+}
+
+// TrySend writes a frame to the connection without requiring any
+// acknowledgement, like Send, but does not wait for room. It returns
+// ErrBufferFull if the client has not read enough of the frames sent
+// to it for the frame to be queued, and ErrConnectionClosed if the
+// connection is closed, in which cases the frame is discarded. This
+// lets the caller decide what to do about a slow client instead of
+// being held up by it.
+func (c *Conn) TrySend(f *frame.Frame) error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	held := c.hold(f)
+	select {
+	case c.writeChannel <- held:
+		return nil
+	case <-c.done:
+		c.memory.Add(-held.size)
+		return ErrConnectionClosed
+	default:
+		c.memory.Add(-held.size)
+		return ErrBufferFull
+	}
+}
+
+// Counts a frame about to be placed on the write channel with the
+// memory meter.
+func (c *Conn) hold(f *frame.Frame) heldFrame {
+	held := heldFrame{frame: f}
+	if c.memory != nil {
+		held.size = int64(f.Size())
+		c.memory.Add(held.size)
+	}
+	return held
+}
+
+// Counts the frame of a subscription about to be placed on the
+// subscription channel with the memory meter.
+func (c *Conn) holdSubscription(sub *Subscription) {
+	sub.size = 0
+	if c.memory != nil {
+		sub.size = int64(sub.frame.Size())
+		c.memory.Add(sub.size)
+	}
+}
+
+// SendReceipt sends a RECEIPT frame to the client once the upper layer
+// has processed a request with a receipt. Unlike Send, it never blocks,
+// so that a client that is slow to read cannot hold up the upper layer.
+// Has no effect if receipt is empty or the connection is closed.
+func (c *Conn) SendReceipt(receipt string) {
+	if receipt == "" {
+		return
+	}
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return
+	}
+
+	c.receiptMutex.Lock()
+	c.receipts = append(c.receipts, receipt)
+	c.receiptMutex.Unlock()
+	select {
+	case c.receiptReady <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// Pass a subscription with a frame requiring acknowledgement to the
+// processing loop. Returns an error if the connection is closed, in
+// which case the frame has not been accepted and remains the
+// responsibility of the caller.
+func (c *Conn) sendSubscription(sub *Subscription) error {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	c.holdSubscription(sub)
+	select {
+	case c.subChannel <- sub:
+		return nil
+	case <-c.done:
+		c.memory.Add(-sub.size)
+		return ErrConnectionClosed
+	}
+}
+
+// Sends a request to the upper layer. The request is tagged with the
+// correlation id of the client frame being processed, if any.
+func (c *Conn) request(r Request) {
+	r.Id = c.requestId
+	if r.Receipt != "" {
+		c.pendingReceipts++
+	}
+	c.requestChannel <- r
+}
+
+// Marks the connection as closed. Once this function returns, the
+// upper layer cannot place any more frames on the subscription
+// channel or the write channel.
+func (c *Conn) shutdown() {
+	// closing the done channel unblocks any go-routine
+	// waiting to send to the connection
+	c.closeOnce.Do(func() { close(c.done) })
+
+	// wait for any sends in progress to complete
+	c.closeMutex.Lock()
+	c.closed = true
+	c.closeMutex.Unlock()
+}
+
+// Close closes the network connection to the client without sending
+// an ERROR frame, so that a client that has stopped reading frames is
+// disconnected straight away. The connection is then cleaned up as if
+// the client had gone away: its subscriptions are unsubscribed and
+// the messages that it has not acknowledged are requeued. Close can be
+// called from any go-routine, and more than once.
+func (c *Conn) Close() error {
+	var err error
+	c.closeConnOnce.Do(func() { err = c.rw.Close() })
+	return err
+}
+
+// Done returns a channel that is closed once the connection has been
+// cleaned up after it closed, that is, when the requests to unsubscribe
+// its subscriptions, to requeue the messages that the client had not
+// acknowledged, and the DisconnectedOp request have been passed to the
+// upper layer. As the requests are sent on the request channel, the
+// upper layer must keep receiving requests while it waits.
+func (c *Conn) Done() <-chan struct{} {
+	return c.cleanedUp
 }
 
 // Send and ERROR message to the client. The client
@@ -89,6 +377,11 @@ func (c *Conn) SendError(err error) {
 func (c *Conn) sendErrorImmediately(err error, f *frame.Frame) {
 	errorFrame := frame.New(frame.ERROR,
 		frame.Message, err.Error())
+	if err, ok := err.(DetailedError); ok {
+		errorFrame.Body = []byte(err.Details())
+		errorFrame.Header.Add(frame.ContentType, "text/plain")
+		errorFrame.Header.Add(frame.ContentLength, strconv.Itoa(len(errorFrame.Body)))
+	}
 
 	// Include a receipt-id header if the frame that prompted the error had
 	// a receipt header (as suggested by the STOMP protocol spec).
@@ -97,16 +390,82 @@ func (c *Conn) sendErrorImmediately(err error, f *frame.Frame) {
 			errorFrame.Header.Add(frame.ReceiptId, receipt)
 		}
 	}
+	if c.requestId != "" {
+		errorFrame.Header.Add(RequestIdHeader, c.requestId)
+	}
 
 	// send the frame to the client, ignore any error condition
 	// because we are about to close the connection anyway
-	_ = c.sendImmediately(errorFrame)
+	if c.sendImmediately(errorFrame) == nil {
+		_ = c.writer.Flush()
+	}
 }
 
 // Sends a STOMP frame to the client immediately, does not push onto the
-// write channel to be processed in turn.
+// write channel to be processed in turn. The frame is buffered, and the
+// process loop flushes the buffer once no more frames are ready to be
+// sent, so that a batch of frames is written to the network at once.
 func (c *Conn) sendImmediately(f *frame.Frame) error {
-	return c.writer.Write(f)
+	if f != nil {
+		if err := c.interceptOutbound(f); err != nil {
+			c.frameLog(f).Warningf("frame rejected by interceptor: %v", err)
+			return err
+		}
+	}
+	if drop, err := c.injectFault(false, f); drop {
+		return err
+	}
+	if err := c.writer.Buffer(f); err != nil {
+		return err
+	}
+	c.wrote = true
+	if f != nil {
+		c.metrics.FrameSent(f.Command)
+		c.stats.frameWritten()
+	}
+	return nil
+}
+
+// Sends a frame to the client immediately. A MESSAGE frame is sent
+// as part of a dispatch span, whose context replaces the context of
+// the enqueue span in the frame, and a traced MESSAGE frame is given
+// the time that it was sent.
+func (c *Conn) sendMessage(f *frame.Frame) error {
+	if f.Command != frame.MESSAGE {
+		return c.sendImmediately(f)
+	}
+	if _, ok := f.Header.Contains(MessageTraceHeader); ok {
+		f.Header.Set(TraceDispatchedHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	}
+	span := stomp.StartSpan(c.tracer, "dispatch", f.Header, f.Header.Get(frame.Destination))
+	err := c.sendImmediately(f)
+	span.End(err)
+	return err
+}
+
+// Starts an ack or nack span for a frame acknowledged by the client.
+// The span is a child of the span carried by the ACK or NACK frame,
+// or by the acknowledged frame if the client did not send a span
+// context.
+func (c *Conn) startAckSpan(name string, ack, msg *frame.Frame) stomp.Span {
+	var h *frame.Header
+	if c.tracer != nil {
+		h = ack.Header.Clone()
+		if !stomp.SpanContextFromHeader(h).IsValid() {
+			h = msg.Header.Clone()
+		}
+	}
+	return stomp.StartSpan(c.tracer, name, h, msg.Header.Get(frame.Destination))
+}
+
+// Returns the logger of the connection, which attaches the command
+// and any destination of a frame to entries.
+func (c *Conn) frameLog(f *frame.Frame) stomp.Logger {
+	fields := []stomp.Field{{Key: stomp.CommandField, Value: f.Command}}
+	if destination, ok := f.Header.Contains(frame.Destination); ok {
+		fields = append(fields, stomp.Field{Key: stomp.DestinationField, Value: destination})
+	}
+	return stomp.WithFields(c.log, fields...)
 }
 
 // Go routine for reading bytes from a client and assembling into
@@ -115,22 +474,29 @@ func (c *Conn) sendImmediately(f *frame.Frame) error {
 // processLoop go-routine. This keeps all processing of frames for
 // this connection on the one go-routine and avoids race conditions.
 func (c *Conn) readLoop() {
-	reader := frame.NewReader(c.rw)
+	// the process loop attaches the login to c.log once connected
+	log := c.log
+	live := &livenessReader{conn: c.rw}
+	reader := frame.NewReader(live, frame.MaxBodySize(c.capabilities.MaxFrameSize))
 	expectingConnect := true
-	readTimeout := time.Duration(0)
+	multiplier := c.config.HeartBeatGracePeriodMultiplier()
+	if multiplier <= 0 {
+		multiplier = DefaultHeartBeatGracePeriodMultiplier
+	}
 	for {
-		if readTimeout == time.Duration(0) {
-			// infinite timeout
-			c.rw.SetReadDeadline(time.Time{})
-		} else {
-			c.rw.SetReadDeadline(time.Now().Add(readTimeout * 2))
+		f, err := reader.ReadFrame()
+		if err == frame.ErrHeartBeat {
+			c.injectFault(true, nil)
+			continue
 		}
-		f, err := reader.Read()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && live.timeout > 0 {
+				c.metrics.HeartBeatTimeout()
+			}
 			if err == io.EOF {
-				c.log.Errorf("connection closed: %s", c.rw.RemoteAddr())
+				log.Error("connection closed")
 			} else {
-				c.log.Errorf("read failed: %v : %s", err, c.rw.RemoteAddr())
+				log.Errorf("read failed: %v", err)
 			}
 
 			// Close the read channel so that the processing loop will
@@ -141,44 +507,73 @@ func (c *Conn) readLoop() {
 			return
 		}
 
-		if f == nil {
-			// if the frame is nil, then it is a heartbeat
+		c.metrics.FrameReceived(f.Command)
+		c.stats.frameRead()
+		if drop, _ := c.injectFault(true, f); drop {
 			continue
 		}
 
-		// If we are expecting a CONNECT or STOMP command, extract
-		// the heart-beat header and work out the read timeout.
-		// Note that the processing loop will duplicate this to
-		// some extent, but letting this go-routine work out its own
-		// read timeout means no synchronization is necessary.
-		if expectingConnect {
-			// Expecting a CONNECT or STOMP command, get the heart-beat
-			cx, _, err := getHeartBeat(f)
-
-			// Ignore the error condition and treat as no read timeout.
-			// The processing loop will handle the error again and
-			// process correctly.
-			if err == nil {
-				// Minimum value as per server config. If the client
-				// has requested shorter periods than this value, the
-				// server will insist on the longer time period.
-				min := asMilliseconds(c.config.HeartBeat(), maxHeartBeat)
-
-				// apply a minimum heartbeat
-				if cx > 0 && cx < min {
-					cx = min
-				}
+		// Hold up a producer while the server holds too much in
+		// memory. Nothing is read from the client meanwhile, so the
+		// read deadline starts again once the wait is over.
+		if f.Command == frame.SEND && c.memory.Exceeded() {
+			log.Info("flow control: memory limit exceeded, waiting")
+			c.memory.wait(c.done)
+			live.setTimeout(live.timeout)
+		}
 
-				readTimeout = time.Duration(cx) * time.Millisecond
+		// Add the frame to the read channel. Note that this will block
+		// if we are reading from the client quicker than the server
+		// can process frames.
+		select {
+		case c.readChannel <- f:
+		case <-c.done:
+			// the processing loop has finished, so there is
+			// no point reading any more frames
+			close(c.readChannel)
+			return
+		}
 
+		// The processing loop negotiates the heart-beat when it
+		// handles the CONNECT or STOMP frame, and passes back the
+		// interval of the client's heart-beats, or finishes if the
+		// client could not connect.
+		if expectingConnect {
+			select {
+			case readTimeout := <-c.timeoutChannel:
+				// allow for heart-beats that are a little late
+				live.setTimeout(time.Duration(float64(readTimeout) * multiplier))
 				expectingConnect = false
+			case <-c.done:
+				close(c.readChannel)
+				return
 			}
 		}
+	}
+}
 
-		// Add the frame to the read channel. Note that this will block
-		// if we are reading from the client quicker than the server
-		// can process frames.
-		c.readChannel <- f
+// Reads from the network connection of a client. Once the read
+// timeout has been negotiated, the read deadline is extended whenever
+// anything is received, so that the client is alive as long as it
+// sends anything, not only complete frames or heart-beats.
+type livenessReader struct {
+	conn    net.Conn
+	timeout time.Duration // zero for no read deadline
+}
+
+func (r *livenessReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if n > 0 && r.timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	}
+	return n, err
+}
+
+// Sets the time that the client may be silent, starting now.
+func (r *livenessReader) setTimeout(timeout time.Duration) {
+	r.timeout = timeout
+	if timeout > 0 {
+		r.conn.SetReadDeadline(time.Now().Add(timeout))
 	}
 }
 
@@ -190,35 +585,54 @@ func (c *Conn) processLoop() {
 	c.writer = frame.NewWriter(c.rw)
 	c.stateFunc = connecting
 
+	// The heart-beat timer is created once the write timeout has been
+	// negotiated, and is reset whenever anything has been written to
+	// the client, so that a heart-beat is only written when nothing
+	// else has been for the timeout.
 	var timerChannel <-chan time.Time
 	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
 	for {
-		if c.writeTimeout > 0 && timer == nil {
-			timer = time.NewTimer(c.writeTimeout)
-			timerChannel = timer.C
+		if c.writeTimeout > 0 {
+			if timer == nil {
+				timer = time.NewTimer(c.writeTimeout)
+				timerChannel = timer.C
+			} else if c.wrote {
+				if !timer.Stop() {
+					// discard the expiry if it has not been received
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(c.writeTimeout)
+			}
+			c.wrote = false
 		}
 
-		select {
-		case f, ok := <-c.writeChannel:
-			if !ok {
-				// write channel has been closed, so
-				// exit go-routine (after cleaning up)
+		// flush the frames sent since the last flush, unless there
+		// are more frames ready to be sent with them
+		if c.writer.Buffered() > 0 && len(c.writeChannel) == 0 && len(c.subChannel) == 0 {
+			if err := c.writer.Flush(); err != nil {
 				return
 			}
+		}
 
+		select {
+		case held := <-c.writeChannel:
 			// have a frame to the client with
 			// no acknowledgement required (topic)
-
-			// stop the heart-beat timer
-			if timer != nil {
-				timer.Stop()
-				timer = nil
-			}
+			c.memory.Add(-held.size)
+			f := held.frame
 
 			c.allocateMessageId(f, nil)
 
 			// write the frame to the client
-			err := c.writer.Write(f)
+			err := c.sendMessage(f)
 			if err != nil {
 				// if there is an error writing to
 				// the client, there is not much
@@ -231,6 +645,20 @@ func (c *Conn) processLoop() {
 			// frame, we disconnect
 			if f.Command == frame.ERROR {
 				// sent an ERROR frame, so disconnect
+				_ = c.writer.Flush()
+				return
+			}
+
+		case sub := <-c.abortChannel:
+			c.abortSubscription(sub)
+
+		case <-c.slowChannel:
+			c.log.Warning("slow consumer: disconnecting")
+			c.sendErrorImmediately(slowConsumer, nil)
+			return
+
+		case <-c.receiptReady:
+			if c.sendReceipts() != nil {
 				return
 			}
 
@@ -241,83 +669,112 @@ func (c *Conn) processLoop() {
 				return
 			}
 
-			// Just received a frame from the client.
+			// Just received a frame from the client. Allocate a
+			// correlation id, which is passed with any requests to
+			// the upper layer and included in any resulting ERROR frame.
+			c.requestId = NewId()
+
 			// Validate the frame, checking for mandatory
 			// headers and prohibited headers.
 			if c.validator != nil {
 				err := c.validator.Validate(f)
 				if err != nil {
-					c.log.Warningf("validation failed for %s frame: %v", f.Command, err)
+					c.frameLog(f).Warningf("[%s] validation failed: %v", c.requestId, err)
 					c.sendErrorImmediately(err, f)
 					return
 				}
 			}
 
+			if err := c.interceptInbound(f); err != nil {
+				c.frameLog(f).Warningf("[%s] rejected by interceptor: %v", c.requestId, err)
+				c.sendErrorImmediately(err, f)
+				return
+			}
+
+			// SEND frames are passed to the upper layer, and frames
+			// with a transaction are kept until it is committed, so
+			// only other frames are released once handled
+			release := f.Command != frame.SEND
+			if _, ok := f.Header.Contains(frame.Transaction); ok {
+				release = false
+			}
+
 			// Pass to the appropriate function for handling
 			// according to the current state of the connection.
 			err := c.stateFunc(c, f)
 			if err != nil {
+				c.frameLog(f).Warningf("[%s] frame failed: %v", c.requestId, err)
 				c.sendErrorImmediately(err, f)
 				return
 			}
-
-		case sub, ok := <-c.subChannel:
-			if !ok {
-				// subscription channel has been closed,
-				// so exit go-routine (after cleaning up)
+			c.stats.setTransactions(c.txStore.Len())
+			c.requestId = ""
+			disconnect := f.Command == frame.DISCONNECT
+			if release {
+				f.Release()
+			}
+			if disconnect {
+				if c.awaitReceipts() != nil {
+					return
+				}
+				if c.disconnectReceipt != "" {
+					rf := frame.Acquire(frame.RECEIPT, frame.ReceiptId, c.disconnectReceipt)
+					if c.sendImmediately(rf) != nil {
+						return
+					}
+					rf.Release()
+				}
+				c.closeAfterDisconnect()
 				return
 			}
 
+		case sub := <-c.subChannel:
 			// have a frame to the client which requires
 			// acknowledgement to the upper layer
-
-			// stop the heart-beat timer
-			if timer != nil {
-				timer.Stop()
-				timer = nil
-			}
+			c.memory.Add(-sub.size)
 
 			// there is the possibility that the subscription
 			// has been unsubscribed just prior to receiving
 			// this, so we check
-			if _, ok = c.subs[sub.id]; ok {
+			if _, ok := c.subs[sub.id]; ok {
 				// allocate a message-id, note that the
 				// subscription id has already been set
 				c.allocateMessageId(sub.frame, sub)
 
 				// write the frame to the client
-				err := c.writer.Write(sub.frame)
+				err := c.sendMessage(sub.frame)
 				if err != nil {
 					// if there is an error writing to
 					// the client, there is not much
 					// point trying to send an ERROR frame,
-					// so just exit go-routine (after cleaning up)
+					// so just exit go-routine (after cleaning up).
+					// Keep track of the frame so that it is requeued.
+					c.subList.Add(sub)
 					return
 				}
+				sub.sent = time.Now()
+				c.metrics.Dispatched(sub.sent.Sub(sub.dispatch))
 
 				if sub.ack == frame.AckAuto {
 					// subscription does not require acknowledgement,
-					// so send the subscription back the upper layer
-					// straight away
+					// so the frame is considered acknowledged as soon as
+					// it is sent; send the subscription back the upper
+					// layer straight away
+					c.request(Request{Op: AckOp, Sub: sub, Frame: sub.frame})
 					sub.frame = nil
-					c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
+					c.request(Request{Op: SubscribeOp, Sub: sub})
 				} else {
 					// subscription requires acknowledgement
 					c.subList.Add(sub)
 				}
 			} else {
 				// Subscription no longer exists, requeue
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+				c.request(Request{Op: RequeueOp, Frame: sub.frame})
 			}
 
-		case _ = <-timerChannel:
-			// stop the heart-beat timer
-			if timer != nil {
-				timer.Stop()
-				timer = nil
-			}
+		case <-timerChannel:
 			// write a heart-beat
-			err := c.writer.Write(nil)
+			err := c.sendImmediately(nil)
 			if err != nil {
 				return
 			}
@@ -329,79 +786,78 @@ func (c *Conn) processLoop() {
 // unsubscribing all subscriptions with the upper layer, and
 // re-queueing all unacknowledged messages to the upper layer.
 func (c *Conn) cleanupConn() {
+	// Stop accepting frames from the upper layer. After this
+	// the subscription channel and write channel will not
+	// receive any more frames.
+	c.shutdown()
+
+	// Closing the network connection will cause the read loop
+	// to terminate if it is waiting for input from the client.
+	c.Close()
+
 	// clean up any pending transactions
 	c.txStore.Init()
-
-	c.discardWriteChannelFrames()
+	c.stats.setTransactions(0)
+
+	// Collect every frame that needs to be requeued, oldest first.
+	// Frames sent to the client but not acknowledged are older than
+	// the frames still waiting on the subscription channel. They are
+	// taken from the subscription list before the subscriptions are
+	// passed to the upper layer below, which checks which list they
+	// are in.
+	var frames []*frame.Frame
+	for sub := c.subList.Get(); sub != nil; sub = c.subList.Get() {
+		frames = append(frames, sub.frame)
+		sub.frame = nil
+	}
 
 	// Unsubscribe every subscription known to the upper layer.
-	// This should be done before cleaning up the subscription
-	// channel. If we requeued messages before doing this,
-	// we might end up getting them back again.
+	// This should be done before requeueing any messages.
+	// If we requeued messages before doing this, we might end
+	// up getting them back again.
 	for _, sub := range c.subs {
 		// Note that we only really need to send a request if the
 		// subscription does not have a frame, but for simplicity
 		// all subscriptions are unsubscribed from the upper layer.
-		c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
+		c.request(Request{Op: UnsubscribeOp, Sub: sub})
 	}
 
 	// Clear out the map of subscriptions
 	c.subs = nil
 
-	// Every subscription requiring acknowledgement has a frame
-	// that needs to be requeued in the upper layer
-	for sub := c.subList.Get(); sub != nil; sub = c.subList.Get() {
-		c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
-	}
-
-	// empty the subscription and write queue
-	c.discardWriteChannelFrames()
-	c.cleanupSubChannel()
-
-	// Tell the upper layer we are now disconnected
-	c.requestChannel <- Request{Op: DisconnectedOp, Conn: c}
-
-	// empty the subscription and write queue one more time
-	c.discardWriteChannelFrames()
-	c.cleanupSubChannel()
-
-	// Should not hurt to call this if it is already closed?
-	c.rw.Close()
-}
-
-// Discard anything on the write channel. These frames
-// do not get acknowledged, and are either topic MESSAGE
-// frames or ERROR frames.
-func (c *Conn) discardWriteChannelFrames() {
 	for finished := false; !finished; {
 		select {
-		case _, ok := <-c.writeChannel:
-			if !ok {
-				finished = true
-			}
-
+		case sub := <-c.subChannel:
+			c.memory.Add(-sub.size)
+			frames = append(frames, sub.frame)
+			sub.frame = nil
 		default:
 			finished = true
 		}
 	}
-}
 
-func (c *Conn) cleanupSubChannel() {
-	// Read the subscription channel until it is empty.
-	// Each frame should be requeued to the upper layer.
+	// Requeue the newest frame first. Requeued frames are placed
+	// at the head of the queue, so this preserves the original
+	// order of the frames in each queue.
+	for i := len(frames) - 1; i >= 0; i-- {
+		c.request(Request{Op: RequeueOp, Frame: frames[i]})
+	}
+
+	// Discard anything on the write channel. These frames
+	// do not get acknowledged, and are either topic MESSAGE
+	// frames or ERROR frames.
 	for finished := false; !finished; {
 		select {
-		case sub, ok := <-c.subChannel:
-			if !ok {
-				finished = true
-			} else {
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
-			}
-
+		case held := <-c.writeChannel:
+			c.memory.Add(-held.size)
 		default:
 			finished = true
 		}
 	}
+
+	// Tell the upper layer we are now disconnected
+	c.request(Request{Op: DisconnectedOp, Conn: c})
+	close(c.cleanedUp)
 }
 
 // Send a frame to the client, allocating necessary headers prior.
@@ -409,7 +865,7 @@ func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) {
 	if f.Command == frame.MESSAGE || f.Command == frame.ACK {
 		// allocate the value of message-id for this frame
 		c.lastMsgId++
-		messageId := strconv.FormatUint(c.lastMsgId, 10)
+		messageId := c.msgIdPrefix + strconv.FormatUint(c.lastMsgId, 10)
 		f.Header.Set(frame.MessageId, messageId)
 		f.Header.Set(frame.Id, messageId)
 
@@ -420,9 +876,66 @@ func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) {
 		} else {
 			f.Header.Set(frame.Ack, messageId)
 		}
+
+		// remember the message-id so that acknowledgements
+		// can be matched with the subscription
+		if sub != nil {
+			sub.msgId = c.lastMsgId
+		}
 	}
 }
 
+// Returns the sequence number of the message-id acknowledged by an
+// ACK or NACK frame. The headers that identify the message depend on
+// the protocol version: STOMP 1.2 frames have an "id" header, with the
+// value of the MESSAGE frame's "ack" header, and STOMP 1.1 frames have
+// "message-id" and "subscription" headers, which name the message and
+// the subscription that it was sent to. An "ack" header is accepted in
+// place of "id" or "message-id", as earlier versions of the server
+// expected it.
+func (c *Conn) ackedMessageId(f *frame.Frame) (uint64, error) {
+	name := frame.Id
+	subscription, ok := f.Header.Contains(frame.Subscription)
+	if c.version == stomp.V11 {
+		if !ok {
+			return 0, missingHeader(frame.Subscription)
+		}
+		name = frame.MessageId
+	}
+	msgId, ok := f.Header.Contains(name)
+	if !ok {
+		if msgId, ok = f.Header.Contains(frame.Ack); !ok {
+			return 0, missingHeader(name)
+		}
+	}
+	seq, err := c.parseMessageId(msgId)
+	if err != nil || c.version != stomp.V11 {
+		return seq, err
+	}
+
+	// a message that is waiting for acknowledgement must have been
+	// sent to the subscription
+	for _, sub := range c.subList.byMsgId[seq] {
+		if sub.id != subscription {
+			return 0, subscriptionMismatch
+		}
+	}
+	return seq, nil
+}
+
+// Returns the sequence number of a message-id allocated by
+// allocateMessageId.
+func (c *Conn) parseMessageId(msgId string) (uint64, error) {
+	if !strings.HasPrefix(msgId, c.msgIdPrefix) {
+		return 0, invalidHeaderValue
+	}
+	seq, err := strconv.ParseUint(msgId[len(c.msgIdPrefix):], 10, 64)
+	if err != nil {
+		return 0, invalidHeaderValue
+	}
+	return seq, nil
+}
+
 // State function for expecting connect frame.
 func connecting(c *Conn, f *frame.Frame) error {
 	switch f.Command {
@@ -434,6 +947,10 @@ func connecting(c *Conn, f *frame.Frame) error {
 
 // State function for after connect frame received.
 func connected(c *Conn, f *frame.Frame) error {
+	if !c.config.Permitted(f.Command) {
+		return commandNotPermitted(f.Command)
+	}
+
 	switch f.Command {
 	case frame.CONNECT, frame.STOMP:
 		return unexpectedCommand
@@ -477,58 +994,70 @@ func (c *Conn) handleConnect(f *frame.Frame) error {
 	passcode, _ := f.Header.Contains(frame.Passcode)
 	if !c.config.Authenticate(login, passcode) {
 		// sleep to slow down a rogue client a little bit
-		c.log.Error("authentication failed")
+		c.log.Errorf("[%s] authentication failed", c.requestId)
 		time.Sleep(time.Second)
 		return authenticationFailed
 	}
 
 	c.version, err = determineVersion(f)
 	if err != nil {
-		c.log.Error("protocol version negotiation failed")
+		c.log.Errorf("[%s] protocol version negotiation failed", c.requestId)
 		return err
 	}
-	c.validator = stomp.NewValidator(c.version)
+	c.writer.SetVersion(string(c.version))
+	c.validator = stomp.NewValidatorMode(c.version, c.config.Validation())
+	if login != "" {
+		c.login = login
+		c.log = stomp.WithFields(c.log, stomp.Field{Key: stomp.LoginField, Value: login})
+	}
 
 	if c.version == stomp.V10 {
 		// don't want to handle V1.0 at the moment
 		// TODO: get working for V1.0
-		c.log.Errorf("unsupported version %s", c.version)
+		c.log.Errorf("[%s] unsupported version %s", c.requestId, c.version)
 		return unsupportedVersion
 	}
 
 	cx, cy, err := getHeartBeat(f)
 	if err != nil {
-		c.log.Error("invalid heart-beat")
+		c.log.Errorf("[%s] invalid heart-beat", c.requestId)
 		return err
 	}
 
 	// Minimum value as per server config. If the client
 	// has requested shorter periods than this value, the
-	// server will insist on the longer time period.
-	min := asMilliseconds(c.config.HeartBeat(), maxHeartBeat)
-
-	// apply a minimum heartbeat
-	if cx > 0 && cx < min {
-		cx = min
-	}
-	if cy > 0 && cy < min {
-		cy = min
+	// server will insist on the longer time period. The
+	// server can send and receive heart-beats as often as
+	// every millisecond otherwise.
+	min := time.Duration(asMilliseconds(c.config.HeartBeat(), maxHeartBeat)) * time.Millisecond
+	if min < time.Millisecond {
+		min = time.Millisecond
 	}
+	readTimeout, writeTimeout := frame.NegotiateHeartBeat(
+		time.Duration(cx)*time.Millisecond, time.Duration(cy)*time.Millisecond, min, min)
 
-	// the read timeout has already been processed in the readLoop
-	// go-routine
-	c.writeTimeout = time.Duration(cy) * time.Millisecond
+	// the read loop applies the read timeout
+	c.timeoutChannel <- readTimeout
+	c.writeTimeout = writeTimeout
+	c.readHeartBeat = readTimeout
 
+	// the negotiated intervals are offered to the client, so that it
+	// negotiates the same ones
 	response := frame.New(frame.CONNECTED,
 		frame.Version, string(c.version),
-		frame.Server, "stompd/x.y.z", // TODO: get version
-		frame.HeartBeat, fmt.Sprintf("%d,%d", cy, cx))
+		frame.Session, c.id,
+		frame.Server, c.config.ServerName(),
+		frame.HeartBeat, fmt.Sprintf("%d,%d", writeTimeout/time.Millisecond, readTimeout/time.Millisecond))
+	if capabilities := c.capabilities.String(); capabilities != "" {
+		response.Header.Add(frame.Capabilities, capabilities)
+	}
 
 	c.sendImmediately(response)
 	c.stateFunc = connected
+	c.connectedAt = time.Now()
 
 	// tell the upper layer we are connected
-	c.requestChannel <- Request{Op: ConnectedOp, Conn: c}
+	c.request(Request{Op: ConnectedOp, Conn: c})
 
 	return nil
 }
@@ -544,22 +1073,107 @@ func (c *Conn) sendReceiptImmediately(f *frame.Frame) error {
 		// When the frame is processed upon transaction commit, it
 		// will not have a receipt header anymore.
 		f.Header.Del(frame.Receipt)
-		return c.sendImmediately(frame.New(frame.RECEIPT,
-			frame.ReceiptId, receipt))
+		rf := frame.Acquire(frame.RECEIPT, frame.ReceiptId, receipt)
+		if err := c.sendImmediately(rf); err != nil {
+			return err
+		}
+		rf.Release()
 	}
 	return nil
 }
 
+// Removes the receipt header from the frame, and returns its value.
+// Returns an empty string if the frame has no receipt header.
+func removeReceipt(f *frame.Frame) string {
+	receipt, _ := f.Header.Contains(frame.Receipt)
+	f.Header.Del(frame.Receipt)
+	return receipt
+}
+
 func (c *Conn) handleDisconnect(f *frame.Frame) error {
 	// As soon as we receive a DISCONNECT frame from a client, we do
 	// not want to send any more frames to that client, with the exception
-	// of a RECEIPT frame if the client has requested one.
-	// Ignore the error condition if we cannot send a RECEIPT frame,
-	// as the connection is about to close anyway.
-	_ = c.sendReceiptImmediately(f)
+	// of RECEIPT frames. The process loop sends the receipts of earlier
+	// frames, then the receipt of the DISCONNECT frame if the client has
+	// requested one, and closes the connection once this returns.
+	c.disconnectReceipt = removeReceipt(f)
+	return nil
+}
+
+// Sends the receipts passed to SendReceipt by the upper layer.
+func (c *Conn) sendReceipts() error {
+	c.receiptMutex.Lock()
+	receipts := c.receipts
+	c.receipts = nil
+	c.receiptMutex.Unlock()
+	for _, receipt := range receipts {
+		f := frame.Acquire(frame.RECEIPT, frame.ReceiptId, receipt)
+		if err := c.sendImmediately(f); err != nil {
+			return err
+		}
+		f.Release()
+		c.pendingReceipts--
+	}
+	return nil
+}
+
+// Waits until the upper layer has sent the receipts of the frames
+// received before a DISCONNECT frame, so that the receipt of the
+// DISCONNECT frame, which tells the client that every earlier frame
+// has been processed, is sent last. Gives up after
+// pendingReceiptsTimeout.
+func (c *Conn) awaitReceipts() error {
+	if c.pendingReceipts <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(pendingReceiptsTimeout)
+	defer timer.Stop()
+	for c.pendingReceipts > 0 {
+		select {
+		case <-c.receiptReady:
+			if err := c.sendReceipts(); err != nil {
+				return err
+			}
+		case <-timer.C:
+			c.log.Warningf("%d receipts not sent before disconnecting", c.pendingReceipts)
+			return nil
+		}
+	}
 	return nil
 }
 
+// Closes the connection after a DISCONNECT frame has been handled.
+// Any receipt is flushed, and if the network connection can be closed
+// for writing only, the server does so and then discards frames from
+// the client until the client closes the connection, or until
+// disconnectLinger has passed, so that the receipt is not lost if the
+// client sends anything more.
+func (c *Conn) closeAfterDisconnect() {
+	if c.writer.Flush() != nil {
+		return
+	}
+	var conn net.Conn = c.rw
+	if cc, ok := conn.(*countingConn); ok {
+		conn = cc.Conn
+	}
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok || cw.CloseWrite() != nil {
+		return
+	}
+	timer := time.NewTimer(disconnectLinger)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-c.readChannel:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			return
+		}
+	}
+}
+
 func (c *Conn) handleBegin(f *frame.Frame) error {
 	// the frame should already have been validated for the
 	// transaction header, but we check again here.
@@ -579,11 +1193,12 @@ func (c *Conn) handleCommit(f *frame.Frame) error {
 	// the frame should already have been validated for the
 	// transaction header, but we check again here.
 	if transaction, ok := f.Header.Contains(frame.Transaction); ok {
-		// Send a receipt and remove the header
-		err := c.sendReceiptImmediately(f)
-		if err != nil {
-			return err
-		}
+		// the requests for the transaction are bracketed, so that the
+		// upper layer can make them durable as a single unit, and the
+		// upper layer sends the receipt once they are durable
+		receipt := removeReceipt(f)
+		c.request(Request{Op: CommitBeginOp})
+		defer c.request(Request{Op: CommitEndOp, Conn: c, Receipt: receipt})
 		return c.txStore.Commit(transaction, func(f *frame.Frame) error {
 			// Call the state function (again) for each frame in the
 			// transaction. This time each frame is stripped of its transaction
@@ -630,11 +1245,15 @@ func (c *Conn) handleSubscribe(f *frame.Frame) error {
 	}
 
 	sub = newSubscription(c, dest, id, ack)
+	if err := sub.setReplay(f.Header); err != nil {
+		return err
+	}
+	sub.fwdBy = f.Header.Get(ForwardedHeader)
 	c.subs[id] = sub
 
 	// send information about new subscription to upper layer
-	c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
-	return nil
+	c.request(Request{Op: SubscribeOp, Sub: sub})
+	return c.sendReceiptImmediately(f)
 }
 
 func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
@@ -652,22 +1271,12 @@ func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
 	delete(c.subs, id)
 
 	// tell the upper layer of the unsubscribe
-	c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
-	return nil
+	c.request(Request{Op: UnsubscribeOp, Sub: sub})
+	return c.sendReceiptImmediately(f)
 }
 
 func (c *Conn) handleAck(f *frame.Frame) error {
-	var err error
-	var msgId string
-
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
-		msgId = ack
-	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
-		return missingHeader(frame.MessageId)
-	}
-
-	// expecting message id to be a uint64
-	msgId64, err := strconv.ParseUint(msgId, 10, 64)
+	msgId64, err := c.ackedMessageId(f)
 	if err != nil {
 		return err
 	}
@@ -687,12 +1296,18 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 	} else {
 		// handle any subscriptions that are acknowledged by this msg
 		c.subList.Ack(msgId64, func(s *Subscription) {
+			c.metrics.Acked(time.Since(s.sent))
+			c.startAckSpan("ack", f, s.frame).End(nil)
+
+			// let the upper layer know that the frame has been delivered
+			c.request(Request{Op: AckOp, Sub: s, Frame: s.frame})
+
 			// remove frame from the subscription, it has been delivered
 			s.frame = nil
 
 			// let the upper layer know that this subscription
 			// is ready for another frame
-			c.requestChannel <- Request{Op: SubscribeOp, Sub: s}
+			c.request(Request{Op: SubscribeOp, Sub: s})
 		})
 	}
 
@@ -700,17 +1315,7 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 }
 
 func (c *Conn) handleNack(f *frame.Frame) error {
-	var err error
-	var msgId string
-
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
-		msgId = ack
-	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
-		return missingHeader(frame.MessageId)
-	}
-
-	// expecting message id to be a uint64
-	msgId64, err := strconv.ParseUint(msgId, 10, 64)
+	msgId64, err := c.ackedMessageId(f)
 	if err != nil {
 		return err
 	}
@@ -730,15 +1335,17 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 	} else {
 		// handle any subscriptions that are acknowledged by this msg
 		c.subList.Nack(msgId64, func(s *Subscription) {
+			c.startAckSpan("nack", f, s.frame).End(nil)
+
 			// send frame back to upper layer for requeue
-			c.requestChannel <- Request{Op: RequeueOp, Frame: s.frame}
+			c.request(Request{Op: RequeueOp, Frame: s.frame})
 
 			// remove frame from the subscription, it has been requeued
 			s.frame = nil
 
 			// let the upper layer know that this subscription
 			// is ready for another frame
-			c.requestChannel <- Request{Op: SubscribeOp, Sub: s}
+			c.request(Request{Op: SubscribeOp, Sub: s})
 		})
 	}
 	return nil
@@ -748,24 +1355,23 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 // this method is called after a SEND message is received,
 // but also after a transaction commit.
 func (c *Conn) handleSend(f *frame.Frame) error {
-	// Send a receipt and remove the header
-	err := c.sendReceiptImmediately(f)
-	if err != nil {
-		return err
-	}
-
 	if tx, ok := f.Header.Contains(frame.Transaction); ok {
-		// the transaction header is removed from the frame
-		err = c.txStore.Add(tx, f)
+		// Send a receipt and remove the header
+		err := c.sendReceiptImmediately(f)
 		if err != nil {
 			return err
 		}
-	} else {
-		// not in a transaction
-		// change from SEND to MESSAGE
-		f.Command = frame.MESSAGE
-		c.requestChannel <- Request{Op: EnqueueOp, Frame: f}
+
+		// the transaction header is removed from the frame
+		return c.txStore.Add(tx, f)
 	}
 
+	// not in a transaction, so the upper layer sends the
+	// receipt once the message has been stored
+	receipt := removeReceipt(f)
+
+	// change from SEND to MESSAGE
+	f.Command = frame.MESSAGE
+	c.request(Request{Op: EnqueueOp, Frame: f, Conn: c, Receipt: receipt, Time: time.Now()})
 	return nil
 }
@@ -0,0 +1,992 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/metrics"
+	. "gopkg.in/check.v1"
+)
+
+type ConnSuite struct{}
+
+var _ = Suite(&ConnSuite{})
+
+// Config used for testing connections.
+type testConfig struct {
+	heartBeat     time.Duration
+	interceptors  []Interceptor
+	pendingWrites int
+	graceFactor   float64
+	memory        *MemoryMeter
+	validation    frame.ValidationMode
+	faults        FaultInjector
+	capabilities  stomp.Capabilities
+}
+
+func (c *testConfig) Authenticate(login, passcode string) bool { return true }
+func (c *testConfig) HeartBeat() time.Duration                 { return c.heartBeat }
+func (c *testConfig) Permitted(command string) bool            { return true }
+func (c *testConfig) Logger() stomp.Logger                     { return nopLogger{} }
+func (c *testConfig) Metrics() *metrics.Metrics                { return nil }
+func (c *testConfig) Tracer() stomp.Tracer                     { return nil }
+func (c *testConfig) Interceptors() []Interceptor               { return c.interceptors }
+func (c *testConfig) MaxPendingWrites() int                     { return c.pendingWrites }
+func (c *testConfig) MaxPendingReads() int                      { return 0 }
+func (c *testConfig) HeartBeatGracePeriodMultiplier() float64   { return c.graceFactor }
+func (c *testConfig) Validation() frame.ValidationMode         { return c.validation }
+func (c *testConfig) Memory() *MemoryMeter                      { return c.memory }
+func (c *testConfig) Faults() FaultInjector                     { return c.faults }
+func (c *testConfig) ServerName() string                        { return "test/devel" }
+func (c *testConfig) Capabilities() stomp.Capabilities          { return c.capabilities }
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, value ...interface{})   {}
+func (nopLogger) Infof(format string, value ...interface{})    {}
+func (nopLogger) Warningf(format string, value ...interface{}) {}
+func (nopLogger) Errorf(format string, value ...interface{})   {}
+func (nopLogger) Debug(message string)                         {}
+func (nopLogger) Info(message string)                          {}
+func (nopLogger) Warning(message string)                       {}
+func (nopLogger) Error(message string)                         {}
+
+// A fake upper layer that behaves like a single queue. It processes
+// requests from one connection until the connection disconnects.
+type fakeQueue struct {
+	ch       chan Request
+	pending  []*frame.Frame // frames waiting for a subscription
+	acked    []*frame.Frame // frames acknowledged by the client
+	requeued []*frame.Frame // frames requeued by the connection
+	done     chan struct{}
+}
+
+func newFakeQueue(count int) *fakeQueue {
+	q := &fakeQueue{
+		ch:   make(chan Request, 4),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < count; i++ {
+		q.pending = append(q.pending, frame.New(frame.MESSAGE,
+			frame.Destination, "/queue/test",
+			"seq", strconv.Itoa(i)))
+	}
+	go q.run()
+	return q
+}
+
+func (q *fakeQueue) run() {
+	defer close(q.done)
+	for r := range q.ch {
+		switch r.Op {
+		case SubscribeOp:
+			if len(q.pending) > 0 {
+				if err := r.Sub.SendQueueFrame(q.pending[0]); err == nil {
+					q.pending = q.pending[1:]
+				}
+			}
+		case AckOp:
+			q.acked = append(q.acked, r.Frame)
+		case RequeueOp:
+			q.requeued = append(q.requeued, r.Frame)
+		case DisconnectedOp:
+			return
+		}
+	}
+}
+
+// Client side of a connection, driven by the test.
+type testClient struct {
+	rw       net.Conn
+	writer   *frame.Writer
+	messages chan *frame.Frame
+}
+
+func newTestClient(rw net.Conn) *testClient {
+	tc := &testClient{
+		rw:       rw,
+		writer:   frame.NewWriter(rw),
+		messages: make(chan *frame.Frame, 100),
+	}
+	go func() {
+		reader := frame.NewReader(rw)
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				close(tc.messages)
+				return
+			}
+			if f != nil && f.Command == frame.MESSAGE {
+				tc.messages <- f
+			}
+		}
+	}()
+	return tc
+}
+
+func (tc *testClient) write(f *frame.Frame) {
+	// errors are expected if the server has closed the connection
+	_ = tc.writer.Write(f)
+}
+
+func (tc *testClient) ack(tx string) {
+	select {
+	case f, ok := <-tc.messages:
+		if !ok {
+			return
+		}
+		ack := frame.New(frame.ACK,
+			frame.Subscription, f.Header.Get(frame.Subscription),
+			frame.MessageId, f.Header.Get(frame.MessageId))
+		if tx != "" {
+			ack.Header.Add(frame.Transaction, tx)
+		}
+		tc.write(ack)
+	case <-time.After(time.Second):
+	}
+}
+
+func (s *ConnSuite) TestKillConnectionInEveryState(c *C) {
+	script := []func(tc *testClient){
+		func(tc *testClient) {
+			tc.write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+		},
+		func(tc *testClient) {
+			tc.write(frame.New(frame.SUBSCRIBE, frame.Id, "1",
+				frame.Destination, "/queue/test", frame.Ack, frame.AckClientIndividual))
+		},
+		func(tc *testClient) {
+			tc.write(frame.New(frame.SUBSCRIBE, frame.Id, "2",
+				frame.Destination, "/queue/test", frame.Ack, frame.AckClient))
+		},
+		func(tc *testClient) { tc.ack("") },
+		func(tc *testClient) {
+			tc.write(frame.New(frame.BEGIN, frame.Transaction, "tx1"))
+		},
+		func(tc *testClient) { tc.ack("tx1") },
+		func(tc *testClient) {
+			tc.write(frame.New(frame.SUBSCRIBE, frame.Id, "3",
+				frame.Destination, "/queue/test", frame.Ack, frame.AckAuto))
+		},
+		func(tc *testClient) {
+			tc.write(frame.New(frame.COMMIT, frame.Transaction, "tx1"))
+		},
+		func(tc *testClient) { tc.ack("") },
+		func(tc *testClient) {
+			tc.write(frame.New(frame.UNSUBSCRIBE, frame.Id, "2"))
+		},
+		func(tc *testClient) {
+			// invalid frame: server sends ERROR and closes
+			tc.write(frame.New(frame.UNSUBSCRIBE, frame.Id, "unknown"))
+		},
+	}
+
+	for kill := 0; kill <= len(script); kill++ {
+		const count = 20
+		q := newFakeQueue(count)
+		clientSide, serverSide := net.Pipe()
+		conn := NewConn(&testConfig{}, serverSide, q.ch)
+		tc := newTestClient(clientSide)
+
+		for _, step := range script[:kill] {
+			step(tc)
+		}
+		clientSide.Close()
+
+		select {
+		case <-q.done:
+		case <-time.After(5 * time.Second):
+			c.Fatalf("kill=%d: connection did not disconnect", kill)
+		}
+
+		// the read loop must terminate
+		select {
+		case <-conn.done:
+		default:
+			c.Fatalf("kill=%d: done channel not closed", kill)
+		}
+		func() {
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case _, ok := <-conn.readChannel:
+					if !ok {
+						return
+					}
+				case <-timeout:
+					c.Fatalf("kill=%d: read loop did not terminate", kill)
+				}
+			}
+		}()
+
+		// every frame is accounted for exactly once
+		seen := make(map[string]bool)
+		for _, list := range [][]*frame.Frame{q.pending, q.acked, q.requeued} {
+			for _, f := range list {
+				seq := f.Header.Get("seq")
+				c.Assert(seen[seq], Equals, false, Commentf("kill=%d: duplicate seq %s", kill, seq))
+				seen[seq] = true
+			}
+		}
+		c.Assert(seen, HasLen, count, Commentf("kill=%d: %d acked, %d requeued, %d pending",
+			kill, len(q.acked), len(q.requeued), len(q.pending)))
+
+		// the connection no longer accepts frames
+		sub := newSubscription(conn, "/queue/test", "x", frame.AckClient)
+		c.Check(sub.SendQueueFrame(frame.New(frame.MESSAGE)), NotNil)
+	}
+}
+
+func (s *ConnSuite) TestRequeueOrder(c *C) {
+	const count = 10
+	q := newFakeQueue(count)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, q.ch)
+	tc := newTestClient(clientSide)
+
+	tc.write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	for i := 0; i < 3; i++ {
+		tc.write(frame.New(frame.SUBSCRIBE, frame.Id, fmt.Sprint(i),
+			frame.Destination, "/queue/test", frame.Ack, frame.AckClientIndividual))
+	}
+	for i := 0; i < 3; i++ {
+		<-tc.messages
+	}
+	clientSide.Close()
+	<-q.done
+
+	// newest frame is requeued first, so that the original
+	// order is restored at the head of the queue
+	c.Assert(q.requeued, HasLen, 3)
+	c.Check(q.requeued[0].Header.Get("seq"), Equals, "2")
+	c.Check(q.requeued[1].Header.Get("seq"), Equals, "1")
+	c.Check(q.requeued[2].Header.Get("seq"), Equals, "0")
+}
+
+func (s *ConnSuite) TestRequestId(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	go writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	r := <-ch
+	c.Assert(r.Op, Equals, ConnectedOp)
+	c.Check(r.Id, Not(Equals), "")
+
+	// requests to the upper layer carry the correlation id
+	go writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1", frame.Destination, "/queue/test"))
+	r = <-ch
+	c.Assert(r.Op, Equals, SubscribeOp)
+	subscribeId := r.Id
+	c.Check(subscribeId, Not(Equals), "")
+
+	// each frame has a different correlation id, which
+	// is included in the resulting ERROR frame
+	go writer.Write(frame.New(frame.UNSUBSCRIBE, frame.Id, "unknown"))
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.ERROR)
+	errorId := f.Header.Get(RequestIdHeader)
+	c.Check(errorId, Not(Equals), "")
+	c.Check(errorId, Not(Equals), subscribeId)
+
+	// requests made while disconnecting refer to the frame
+	// that caused the disconnect
+	clientSide.Close()
+	for r = range ch {
+		c.Check(r.Id, Equals, errorId)
+		if r.Op == DisconnectedOp {
+			break
+		}
+	}
+}
+
+func (s *ConnSuite) TestCommitRequests(c *C) {
+	ch := make(chan Request, 16)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, ch)
+	tc := newTestClient(clientSide)
+	defer clientSide.Close()
+
+	tc.write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+	tc.write(frame.New(frame.BEGIN, frame.Transaction, "tx1"))
+	tc.write(frame.New(frame.SEND, frame.Destination, "/queue/test", frame.Transaction, "tx1"))
+	tc.write(frame.New(frame.SEND, frame.Destination, "/queue/test", frame.Transaction, "tx1"))
+	tc.write(frame.New(frame.COMMIT, frame.Transaction, "tx1"))
+
+	// the requests for the transaction are bracketed
+	var ops []RequestOp
+	for _, expected := range []RequestOp{CommitBeginOp, EnqueueOp, EnqueueOp, CommitEndOp} {
+		select {
+		case r := <-ch:
+			ops = append(ops, r.Op)
+		case <-time.After(time.Second):
+			c.Fatalf("expected %v, got %v", expected, ops)
+		}
+	}
+	c.Check(ops, DeepEquals, []RequestOp{CommitBeginOp, EnqueueOp, EnqueueOp, CommitEndOp})
+}
+
+func (s *ConnSuite) TestReceiptsSentByUpperLayer(c *C) {
+	ch := make(chan Request, 16)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, ch)
+	defer clientSide.Close()
+	reader := frame.NewReader(clientSide)
+	writer := frame.NewWriter(clientSide)
+
+	go writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// the receipt for a SEND frame is passed to the upper layer
+	go writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/test", frame.Receipt, "send-1"))
+	r := <-ch
+	c.Assert(r.Op, Equals, EnqueueOp)
+	c.Check(r.Receipt, Equals, "send-1")
+	_, ok := r.Frame.Header.Contains(frame.Receipt)
+	c.Check(ok, Equals, false)
+	c.Assert(r.Conn, NotNil)
+
+	r.Conn.SendReceipt("send-1")
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.RECEIPT)
+	c.Check(f.Header.Get(frame.ReceiptId), Equals, "send-1")
+
+	// as is the receipt for a COMMIT frame, once the transaction is committed
+	go func() {
+		writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1"))
+		writer.Write(frame.New(frame.COMMIT, frame.Transaction, "tx1", frame.Receipt, "commit-1"))
+	}()
+	go func() {
+		// the connection cannot write to the pipe unless it is read
+		for {
+			if _, err := reader.Read(); err != nil {
+				return
+			}
+		}
+	}()
+	c.Check((<-ch).Op, Equals, CommitBeginOp)
+	r = <-ch
+	c.Check(r.Op, Equals, CommitEndOp)
+	c.Check(r.Receipt, Equals, "commit-1")
+}
+
+func (s *ConnSuite) TestSubscribeReplay(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, ch)
+	defer clientSide.Close()
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	go writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1", frame.Destination, "/topic/test",
+		stomp.FromSequenceHeader, "5", stomp.FromTimeHeader, "1500"))
+	c.Assert(err, IsNil)
+	r := <-ch
+	c.Assert(r.Op, Equals, SubscribeOp)
+	seq, t, ok := r.Sub.ReplayFrom()
+	c.Check(ok, Equals, true)
+	c.Check(seq, Equals, uint64(5))
+	c.Check(t.Equal(time.Unix(1, 500*int64(time.Millisecond))), Equals, true)
+
+	err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "2", frame.Destination, "/topic/test"))
+	c.Assert(err, IsNil)
+	r = <-ch
+	_, _, ok = r.Sub.ReplayFrom()
+	c.Check(ok, Equals, false)
+
+	err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "3", frame.Destination, "/topic/test",
+		stomp.FromSequenceHeader, "latest"))
+	c.Assert(err, IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "invalid header value")
+}
+
+func (s *ConnSuite) TestUnsubscribeReceipt(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	NewConn(&testConfig{}, serverSide, ch)
+	defer clientSide.Close()
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	go writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"))
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1", frame.Destination, "/topic/test"))
+	c.Assert(err, IsNil)
+	c.Assert((<-ch).Op, Equals, SubscribeOp)
+
+	go writer.Write(frame.New(frame.UNSUBSCRIBE, frame.Id, "1", frame.Receipt, "r1"))
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.RECEIPT)
+	c.Check(f.Header.Get(frame.ReceiptId), Equals, "r1")
+	c.Check((<-ch).Op, Equals, UnsubscribeOp)
+}
+
+func (s *ConnSuite) TestSlowQueueSubscription(c *C) {
+	ch := make(chan Request, 8)
+	clientSide, serverSide := net.Pipe()
+	conn := NewConn(&testConfig{pendingWrites: 1}, serverSide, ch)
+	defer clientSide.Close()
+	c.Check(cap(conn.writeChannel), Equals, 1)
+	c.Check(cap(conn.subChannel), Equals, 1)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	var subs []*Subscription
+	for _, id := range []string{"1", "2", "3"} {
+		err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, id,
+			frame.Destination, "/queue/test", frame.Ack, frame.AckClient))
+		c.Assert(err, IsNil)
+		r := <-ch
+		c.Assert(r.Op, Equals, SubscribeOp)
+		r.Sub.SetSlowPolicy(SlowDisconnect, 50*time.Millisecond)
+		subs = append(subs, r.Sub)
+	}
+
+	// the client reads nothing, so the first frame is being written,
+	// the second waits in the subscription channel, and the third
+	// cannot be passed to the connection; the frames are larger than
+	// the connection's write buffer, so that they are not buffered
+	for i, sub := range subs {
+		f := frame.New(frame.MESSAGE, frame.Destination, "/queue/test")
+		f.Body = make([]byte, 8192)
+		err := sub.SendQueueFrame(f)
+		if i < 2 {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, Equals, slowConsumer)
+		}
+	}
+
+	// the client is disconnected, and the frames passed to the
+	// connection are requeued
+	var requeued int
+	for r := range ch {
+		if r.Op == RequeueOp {
+			requeued++
+		}
+		if r.Op == DisconnectedOp {
+			break
+		}
+	}
+	c.Check(requeued, Equals, 2)
+}
+
+func (s *ConnSuite) TestTrySend(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	conn := NewConn(&testConfig{pendingWrites: 1}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// the client reads nothing, so one frame is being written and
+	// another waits in the write channel
+	for i := 0; err == nil; i++ {
+		c.Assert(i < 3, Equals, true)
+		err = conn.TrySend(frame.New(frame.MESSAGE, frame.Destination, "/topic/test"))
+	}
+	c.Check(err, Equals, ErrBufferFull)
+
+	clientSide.Close()
+	for r := range ch {
+		if r.Op == DisconnectedOp {
+			break
+		}
+	}
+	c.Check(conn.TrySend(frame.New(frame.MESSAGE, frame.Destination, "/topic/test")), Equals, ErrConnectionClosed)
+}
+
+func (s *ConnSuite) TestHeartBeatWrites(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	conn := NewConn(&testConfig{heartBeat: 20 * time.Millisecond}, serverSide, ch)
+	defer clientSide.Close()
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1",
+		frame.HeartBeat, "0,20")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Check(f.Header.Get(frame.HeartBeat), Equals, "20,0")
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// heart-beats are written while nothing else is, and frames
+	// written in between put off the next heart-beat
+	buf := make([]byte, 1)
+	for i := 0; i < 3; i++ {
+		clientSide.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := clientSide.Read(buf)
+		c.Assert(err, IsNil)
+		c.Check(string(buf[:n]), Equals, "\n")
+		c.Assert(conn.TrySend(frame.New(frame.MESSAGE, frame.Destination, "/topic/test")), IsNil)
+		clientSide.SetReadDeadline(time.Now().Add(time.Second))
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.MESSAGE)
+	}
+}
+
+func (s *ConnSuite) TestNegotiated(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	capabilities := stomp.Capabilities{Wildcards: true, MaxFrameSize: 16}
+	conn := NewConn(&testConfig{heartBeat: 20 * time.Millisecond, capabilities: capabilities}, serverSide, ch)
+	defer clientSide.Close()
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2",
+		frame.HeartBeat, "30,20")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Check(f.Header.Get(frame.Session), Equals, conn.Id())
+	c.Check(f.Header.Get(frame.Server), Equals, "test/devel")
+	c.Check(f.Header.Get(frame.Capabilities), Equals, "wildcards,max-frame-size=16")
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+	c.Check(conn.Negotiated(), Equals, stomp.Negotiated{
+		Version:          stomp.V12,
+		Session:          conn.Id(),
+		Server:           "test/devel",
+		Capabilities:     capabilities,
+		SendHeartBeat:    20 * time.Millisecond,
+		ReceiveHeartBeat: 30 * time.Millisecond,
+	})
+
+	// a frame whose body is larger than the maximum closes the connection
+	send := frame.New(frame.SEND, frame.Destination, "/queue/a")
+	send.Body = make([]byte, 17)
+	go writer.Write(send)
+	for {
+		clientSide.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = reader.Read(); err != nil {
+			break
+		}
+	}
+	c.Check(err, Equals, io.EOF)
+}
+
+// A network connection that counts the calls to Write.
+type writeCounter struct {
+	net.Conn
+	writes int32
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	atomic.AddInt32(&w.writes, 1)
+	return w.Conn.Write(p)
+}
+
+func (s *ConnSuite) TestBatchedWrites(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	counter := &writeCounter{Conn: serverSide}
+	conn := NewConn(&testConfig{}, counter, ch)
+	defer clientSide.Close()
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+	c.Assert(atomic.LoadInt32(&counter.writes), Equals, int32(1))
+
+	// the first frame is written on its own, and the frames sent while
+	// the client is not reading it are written together after it
+	conn.Send(frame.New(frame.MESSAGE, frame.Destination, "/topic/test"))
+	for atomic.LoadInt32(&counter.writes) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		conn.Send(frame.New(frame.MESSAGE, frame.Destination, "/topic/test"))
+	}
+	for i := 0; i < 10; i++ {
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.MESSAGE)
+	}
+	c.Check(atomic.LoadInt32(&counter.writes), Equals, int32(3))
+}
+
+func (s *ConnSuite) TestMessageIds(c *C) {
+	ch := make(chan Request, 4)
+	var conns []*Conn
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		clientSide, serverSide := net.Pipe()
+		conn := NewConn(&testConfig{}, serverSide, ch)
+		clientSide.Close()
+		conns = append(conns, conn)
+
+		for seq := uint64(1); seq <= 2; seq++ {
+			f := frame.New(frame.MESSAGE, frame.Destination, "/topic/test")
+			conn.allocateMessageId(f, nil)
+			id := f.Header.Get(frame.MessageId)
+			c.Check(ids[id], Equals, false)
+			ids[id] = true
+			parsed, err := conn.parseMessageId(id)
+			c.Check(err, IsNil)
+			c.Check(parsed, Equals, seq)
+		}
+	}
+
+	// message-ids of other connections, and values that are not
+	// message-ids, are not accepted in acknowledgements
+	for id := range ids {
+		_, err0 := conns[0].parseMessageId(id)
+		_, err1 := conns[1].parseMessageId(id)
+		c.Check((err0 == nil) != (err1 == nil), Equals, true)
+	}
+	_, err := conns[0].parseMessageId("1")
+	c.Check(err, Equals, invalidHeaderValue)
+}
+
+func (s *ConnSuite) TestHeartBeatGracePeriod(c *C) {
+	for _, graceFactor := range []float64{0, 4} {
+		ch := make(chan Request, 4)
+		clientSide, serverSide := net.Pipe()
+		NewConn(&testConfig{heartBeat: 30 * time.Millisecond, graceFactor: graceFactor}, serverSide, ch)
+		writer := frame.NewWriter(clientSide)
+		reader := frame.NewReader(clientSide)
+
+		c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1",
+			frame.HeartBeat, "30,0")), IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.CONNECTED)
+		c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+		// heart-beats sent at three times the interval are tolerated
+		// by a multiplier of four, but not by the default of two
+		for i := 0; i < 3; i++ {
+			time.Sleep(90 * time.Millisecond)
+			if writer.Write(nil) != nil {
+				break
+			}
+		}
+		if graceFactor == 0 {
+			select {
+			case r := <-ch:
+				c.Check(r.Op, Equals, DisconnectedOp)
+			case <-time.After(time.Second):
+				c.Error("connection not closed")
+			}
+		} else {
+			select {
+			case r := <-ch:
+				c.Errorf("unexpected request: %v", r.Op)
+			default:
+			}
+		}
+		clientSide.Close()
+	}
+}
+
+func (s *ConnSuite) TestFlowControl(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	memory := NewMemoryMeter(1000)
+	NewConn(&testConfig{memory: memory}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// messages are not accepted while the limit is exceeded
+	memory.Add(2000)
+	c.Assert(writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/a")), IsNil)
+	select {
+	case r := <-ch:
+		c.Fatalf("unexpected request: %v", r.Op)
+	case <-time.After(50 * time.Millisecond):
+	}
+	memory.Add(-2000)
+	r := <-ch
+	c.Check(r.Op, Equals, EnqueueOp)
+
+	// messages waiting to be written to the client are counted
+	msg := frame.New(frame.MESSAGE, frame.Destination, "/topic/a")
+	r.Conn.Send(msg)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.MESSAGE)
+	c.Check(memory.Used(), Equals, int64(0))
+
+	// as are frames of subscriptions, which are acknowledged as soon
+	// as they are sent to a client with the auto ack mode
+	c.Assert(writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1", frame.Destination, "/queue/a")), IsNil)
+	r = <-ch
+	c.Assert(r.Op, Equals, SubscribeOp)
+	c.Assert(r.Sub.SendQueueFrame(frame.New(frame.MESSAGE, frame.Destination, "/queue/a")), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.MESSAGE)
+	c.Check((<-ch).Op, Equals, AckOp)
+	c.Check((<-ch).Op, Equals, SubscribeOp)
+	c.Check(memory.Used(), Equals, int64(0))
+}
+
+func (s *ConnSuite) TestSlowFrameIsLiveness(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	NewConn(&testConfig{heartBeat: 30 * time.Millisecond}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1",
+		frame.HeartBeat, "30,0")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// a frame that takes several heart-beat intervals to arrive keeps
+	// the connection open, as its bytes show that the client is alive
+	text := "SEND\ndestination:/queue/test\n\n0123456789\x00"
+	for i := 0; i < len(text); i += 4 {
+		end := i + 4
+		if end > len(text) {
+			end = len(text)
+		}
+		_, err := clientSide.Write([]byte(text[i:end]))
+		c.Assert(err, IsNil)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case r := <-ch:
+		c.Assert(r.Op, Equals, EnqueueOp)
+		c.Check(string(r.Frame.Body), Equals, "0123456789")
+	case <-time.After(time.Second):
+		c.Fatal("frame not received")
+	}
+}
+
+func (s *ConnSuite) TestDisconnect(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	NewConn(&testConfig{}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	// the server sends the receipt and closes the connection
+	c.Assert(writer.Write(frame.New(frame.DISCONNECT, frame.Receipt, "bye")), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.RECEIPT)
+	c.Check(f.Header.Get(frame.ReceiptId), Equals, "bye")
+	c.Check((<-ch).Op, Equals, DisconnectedOp)
+	_, err = reader.Read()
+	c.Check(err, Equals, io.EOF)
+}
+
+func (s *ConnSuite) TestAckWithoutSubscription(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	NewConn(&testConfig{}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+
+	// STOMP 1.1 acknowledgements must name the subscription
+	c.Assert(writer.Write(frame.New(frame.ACK, frame.MessageId, "1")), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "missing header: subscription")
+}
+
+func (s *ConnSuite) TestAckHeaders(c *C) {
+	var pipes []net.Conn
+	defer func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}()
+
+	// connects with a protocol version, and returns the message sent
+	// to subscription "1", which requires acknowledgement
+	deliver := func(version string) (*frame.Writer, *frame.Reader, chan Request, *frame.Frame) {
+		ch := make(chan Request, 4)
+		clientSide, serverSide := net.Pipe()
+		pipes = append(pipes, clientSide)
+		NewConn(&testConfig{}, serverSide, ch)
+		writer := frame.NewWriter(clientSide)
+		reader := frame.NewReader(clientSide)
+
+		c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, version)), IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.CONNECTED)
+		c.Assert((<-ch).Op, Equals, ConnectedOp)
+		c.Assert(writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1",
+			frame.Destination, "/queue/test", frame.Ack, frame.AckClientIndividual)), IsNil)
+		r := <-ch
+		c.Assert(r.Op, Equals, SubscribeOp)
+		c.Assert(r.Sub.SendQueueFrame(frame.New(frame.MESSAGE, frame.Destination, "/queue/test")), IsNil)
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.MESSAGE)
+		return writer, reader, ch, f
+	}
+
+	// STOMP 1.1 acknowledgements name the message and its subscription
+	writer, _, ch, msg := deliver("1.1")
+	c.Assert(writer.Write(frame.New(frame.ACK,
+		frame.MessageId, msg.Header.Get(frame.MessageId), frame.Subscription, "1")), IsNil)
+	c.Check((<-ch).Op, Equals, AckOp)
+	c.Check((<-ch).Op, Equals, SubscribeOp)
+
+	// STOMP 1.2 acknowledgements have the ack header of the message as id
+	writer, _, ch, msg = deliver("1.2")
+	c.Assert(writer.Write(frame.New(frame.ACK, frame.Id, msg.Header.Get(frame.Ack))), IsNil)
+	c.Check((<-ch).Op, Equals, AckOp)
+	c.Check((<-ch).Op, Equals, SubscribeOp)
+
+	// the message is not acknowledged for another subscription
+	writer, reader, ch, msg := deliver("1.1")
+	c.Assert(writer.Write(frame.New(frame.ACK,
+		frame.MessageId, msg.Header.Get(frame.MessageId), frame.Subscription, "2")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "message not sent to subscription")
+	for r := range ch {
+		c.Check(r.Op, Not(Equals), AckOp)
+		if r.Op == DisconnectedOp {
+			break
+		}
+	}
+}
+
+func (s *ConnSuite) TestCloseAndDone(c *C) {
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	conn := NewConn(&testConfig{}, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+	c.Assert(writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, "1",
+		frame.Destination, "/queue/test", frame.Ack, frame.AckClient)), IsNil)
+	r := <-ch
+	c.Assert(r.Op, Equals, SubscribeOp)
+	c.Assert(r.Sub.SendQueueFrame(frame.New(frame.MESSAGE, frame.Destination, "/queue/test")), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.MESSAGE)
+
+	// the upper layer closes the connection, and waits until the
+	// unacknowledged message has been requeued; the requests fit in
+	// the request channel, so they need not be received while waiting
+	c.Assert(conn.Close(), IsNil)
+	select {
+	case <-conn.Done():
+	case <-time.After(time.Second):
+		c.Fatal("connection not cleaned up")
+	}
+	var ops []RequestOp
+	for len(ch) > 0 {
+		ops = append(ops, (<-ch).Op)
+	}
+	c.Check(ops, DeepEquals, []RequestOp{UnsubscribeOp, RequeueOp, DisconnectedOp})
+	c.Check(conn.Close(), IsNil)
+}
+
+func (s *ConnSuite) TestStrictValidation(c *C) {
+	for _, version := range []string{"1.1", "1.2"} {
+		ch := make(chan Request, 4)
+		clientSide, serverSide := net.Pipe()
+		NewConn(&testConfig{validation: frame.Strict}, serverSide, ch)
+		writer := frame.NewWriter(clientSide)
+		reader := frame.NewReader(clientSide)
+
+		c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, version, frame.Host, "h")), IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.CONNECTED)
+		c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+		// only STOMP 1.2 frames are validated strictly
+		f = frame.New(frame.SEND, frame.Destination, "/queue/a")
+		f.Body = []byte("hello")
+		c.Assert(writer.Write(f), IsNil)
+		if version == "1.1" {
+			c.Check((<-ch).Op, Equals, EnqueueOp)
+		} else {
+			f, err = reader.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, frame.ERROR)
+			c.Check(f.Header.Get(frame.Message), Equals, "missing header: content-type")
+		}
+		clientSide.Close()
+	}
+}
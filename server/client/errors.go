@@ -11,6 +11,7 @@ const (
 	unsupportedVersion       = errorMessage("unsupported version")
 	subscriptionExists       = errorMessage("subscription already exists")
 	subscriptionNotFound     = errorMessage("subscription not found")
+	subscriptionMismatch     = errorMessage("message not sent to subscription")
 	invalidFrameFormat       = errorMessage("invalid frame format")
 	invalidCommand           = errorMessage("invalid command")
 	unknownVersion           = errorMessage("incompatible version")
@@ -21,6 +22,20 @@ const (
 	invalidHeaderValue       = errorMessage("invalid header value")
 )
 
+// Errors returned when a frame cannot be passed to a connection.
+const (
+	ErrConnectionClosed = errorMessage("connection closed")
+	ErrBufferFull       = errorMessage("client buffer full")
+)
+
+// A DetailedError is an error with details, such as the problems found
+// in a message, which are sent to the client as plain text in the body
+// of the ERROR frame.
+type DetailedError interface {
+	error
+	Details() string
+}
+
 type errorMessage string
 
 func (e errorMessage) Error() string {
@@ -31,6 +46,10 @@ func missingHeader(name string) errorMessage {
 	return errorMessage("missing header: " + name)
 }
 
+func commandNotPermitted(command string) errorMessage {
+	return errorMessage("command not permitted: " + command)
+}
+
 func prohibitedHeader(name string) errorMessage {
 	return errorMessage("prohibited header: " + name)
 }
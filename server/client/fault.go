@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// ErrFaultInjected is the error of a connection closed by a fault
+// injected by a FaultInjector.
+var ErrFaultInjected = errors.New("connection closed by injected fault")
+
+// A Fault is what a FaultInjector does to a frame or heart-beat. The
+// zero value does nothing.
+type Fault struct {
+	// How long to wait before the frame or heart-beat is sent to the
+	// client, or processed if it was received from the client. The
+	// connection does nothing else while it waits.
+	Delay time.Duration
+
+	// If true, the frame or heart-beat is discarded, as if it had been
+	// lost by the network.
+	Drop bool
+
+	// If true, the connection is closed abruptly, without an ERROR
+	// frame, as if the network had failed.
+	Close bool
+}
+
+// A FaultInjector makes the frames and heart-beats of connections fail
+// in the ways that networks fail, so that tests can exercise the
+// reconnection and redelivery logic of clients. It must not be used
+// in production.
+//
+// The methods are called on the go-routines that read from and write
+// to the connection, so are called concurrently for different
+// connections, and should return quickly; the delay of a fault is
+// waited by the connection.
+type FaultInjector interface {
+	// Inbound is called with each frame read from the client, before
+	// it is validated, or with nil for a heart-beat.
+	Inbound(c *Conn, f *frame.Frame) Fault
+
+	// Outbound is called with each frame to be written to the client,
+	// after the interceptors, or with nil for a heart-beat.
+	Outbound(c *Conn, f *frame.Frame) Fault
+}
+
+// Applies the fault of the fault injector to a frame or heart-beat,
+// and returns true if it is to be discarded, and an error if the
+// connection has been closed.
+func (c *Conn) injectFault(inbound bool, f *frame.Frame) (bool, error) {
+	if c.faults == nil {
+		return false, nil
+	}
+	var fault Fault
+	if inbound {
+		fault = c.faults.Inbound(c, f)
+	} else {
+		fault = c.faults.Outbound(c, f)
+	}
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	if fault.Close {
+		c.log.Warning("closing connection: injected fault")
+		c.Close()
+		return true, ErrFaultInjected
+	}
+	return fault.Drop, nil
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Prefix of the ids allocated by this process. It is taken from the
+// time the process started, which distinguishes the ids from those
+// allocated by earlier processes, and kept in storage or logs.
+var IdPrefix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// The last id sequence number allocated by NewId.
+var lastId uint64
+
+// NewId allocates an id that is unique across the processes of the
+// server, consisting of IdPrefix followed by a sequence number. It is
+// used for the correlation ids of requests, and for the message ids
+// of frames that are stored in a queue.
+func NewId() string {
+	seq := atomic.AddUint64(&lastId, 1)
+	return IdPrefix + "-" + strconv.FormatUint(seq, 10)
+}
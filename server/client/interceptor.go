@@ -0,0 +1,53 @@
+package client
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// An Interceptor inspects, modifies or rejects the frames received
+// from and sent to a client, for example to add header entries, to
+// audit messages or to transform their bodies.
+//
+// The methods are called on the go-routine that processes the frames
+// of the connection, so are called concurrently for different
+// connections, and should return quickly. They must not keep the frames
+// that they are passed once they return, as frames may be released for
+// reuse, see frame.Acquire; a copy made with Clone can be kept instead.
+type Interceptor interface {
+	// Inbound is called with each frame received from the client,
+	// after it has been validated and before it is processed, and
+	// can modify the frame. If Inbound returns an error, the frame is
+	// rejected: the client is sent an ERROR frame with the error
+	// message and is disconnected.
+	Inbound(c *Conn, f *frame.Frame) error
+
+	// Outbound is called with each frame sent to the client, before
+	// it is written, and can modify the frame. If Outbound returns an
+	// error, the frame is not sent and the connection is closed, as
+	// if writing the frame had failed, so that messages waiting to be
+	// acknowledged are returned to their queues.
+	Outbound(c *Conn, f *frame.Frame) error
+}
+
+// Passes a frame received from the client to the interceptors, in
+// order, until one returns an error.
+func (c *Conn) interceptInbound(f *frame.Frame) error {
+	for _, i := range c.interceptors {
+		if err := i.Inbound(c, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Passes a frame sent to the client to the interceptors, in reverse
+// order, so that the first interceptor sees inbound frames first and
+// outbound frames last.
+func (c *Conn) interceptOutbound(f *frame.Frame) error {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		if err := c.interceptors[i].Outbound(c, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
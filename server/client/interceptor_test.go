@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"net"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type InterceptorSuite struct{}
+
+var _ = Suite(&InterceptorSuite{})
+
+// Records the order in which it is called, and rejects frames sent to
+// a forbidden destination.
+type testInterceptor struct {
+	name  string
+	calls *[]string
+}
+
+func (i testInterceptor) Inbound(c *Conn, f *frame.Frame) error {
+	*i.calls = append(*i.calls, i.name+" in "+f.Command)
+	if f.Header.Get(frame.Destination) == "/queue/forbidden" {
+		return errors.New("forbidden")
+	}
+	f.Header.Add("x-inbound", i.name)
+	return nil
+}
+
+func (i testInterceptor) Outbound(c *Conn, f *frame.Frame) error {
+	*i.calls = append(*i.calls, i.name+" out "+f.Command)
+	f.Header.Add("x-outbound", i.name)
+	return nil
+}
+
+func (s *InterceptorSuite) TestInterceptors(c *C) {
+	var calls []string
+	config := &testConfig{interceptors: []Interceptor{
+		testInterceptor{"first", &calls},
+		testInterceptor{"second", &calls},
+	}}
+	ch := make(chan Request, 4)
+	clientSide, serverSide := net.Pipe()
+	NewConn(config, serverSide, ch)
+	writer := frame.NewWriter(clientSide)
+	reader := frame.NewReader(clientSide)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+	c.Check(f.Header.GetAll("x-outbound"), DeepEquals, []string{"second", "first"})
+	c.Assert((<-ch).Op, Equals, ConnectedOp)
+
+	c.Assert(writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/test")), IsNil)
+	r := <-ch
+	c.Assert(r.Op, Equals, EnqueueOp)
+	c.Check(r.Frame.Header.GetAll("x-inbound"), DeepEquals, []string{"first", "second"})
+
+	// the rejected frame is not passed to the second interceptor
+	c.Assert(writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/forbidden")), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "forbidden")
+	for r = range ch {
+		if r.Op == DisconnectedOp {
+			break
+		}
+	}
+	c.Check(calls, DeepEquals, []string{
+		"first in CONNECT", "second in CONNECT",
+		"second out CONNECTED", "first out CONNECTED",
+		"first in SEND", "second in SEND",
+		"first in SEND",
+		"second out ERROR", "first out ERROR",
+	})
+}
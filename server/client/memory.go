@@ -0,0 +1,96 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A MemoryMeter keeps an approximate count of the bytes of the frames
+// that the server holds in memory: frames waiting to be written to
+// clients, frames sent in transactions that have not been committed,
+// and whatever the upper layer adds, such as the frames in its queues.
+// While the count exceeds the limit, connections stop reading from
+// clients that send SEND frames, so that producers are held up until
+// memory is freed by consumers. The methods of a nil *MemoryMeter do
+// nothing, and its count is always zero.
+type MemoryMeter struct {
+	used  int64 // bytes held, atomic
+	limit int64 // zero for no limit
+	mutex sync.Mutex
+	freed chan struct{} // closed once used no longer exceeds limit, nil if it does not
+}
+
+// NewMemoryMeter returns a meter that holds up producers while more
+// than limit bytes are held, or never if limit is zero or less.
+func NewMemoryMeter(limit int64) *MemoryMeter {
+	if limit < 0 {
+		limit = 0
+	}
+	return &MemoryMeter{limit: limit}
+}
+
+// Add adds n bytes to the count, or removes them if n is negative.
+func (m *MemoryMeter) Add(n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+	used := atomic.AddInt64(&m.used, n)
+	if m.limit > 0 && (used-n > m.limit) != (used > m.limit) {
+		m.update()
+	}
+}
+
+// Used returns the number of bytes held.
+func (m *MemoryMeter) Used() int64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&m.used)
+}
+
+// Limit returns the number of bytes that can be held before producers
+// are held up, or zero if there is no limit.
+func (m *MemoryMeter) Limit() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.limit
+}
+
+// Exceeded reports whether the count exceeds the limit.
+func (m *MemoryMeter) Exceeded() bool {
+	return m != nil && m.limit > 0 && atomic.LoadInt64(&m.used) > m.limit
+}
+
+// Creates or closes the channel waited on by producers after the count
+// has crossed the limit. The count is loaded again while the mutex is
+// held, so that the channel reflects the latest of concurrent changes.
+func (m *MemoryMeter) update() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Exceeded() {
+		if m.freed == nil {
+			m.freed = make(chan struct{})
+		}
+	} else if m.freed != nil {
+		close(m.freed)
+		m.freed = nil
+	}
+}
+
+// Waits until the count no longer exceeds the limit, or until done is
+// closed.
+func (m *MemoryMeter) wait(done <-chan struct{}) {
+	if !m.Exceeded() {
+		return
+	}
+	m.mutex.Lock()
+	freed := m.freed
+	m.mutex.Unlock()
+	if freed != nil {
+		select {
+		case <-freed:
+		case <-done:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type MemorySuite struct{}
+
+var _ = Suite(&MemorySuite{})
+
+func (s *MemorySuite) TestMemoryMeter(c *C) {
+	m := NewMemoryMeter(100)
+	m.Add(60)
+	c.Check(m.Used(), Equals, int64(60))
+	c.Check(m.Exceeded(), Equals, false)
+	m.wait(nil)
+
+	m.Add(60)
+	c.Check(m.Exceeded(), Equals, true)
+	waited := make(chan struct{})
+	go func() {
+		m.wait(nil)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		c.Fatal("did not wait while the limit was exceeded")
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.Add(-20)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		c.Fatal("still waiting after memory was freed")
+	}
+	c.Check(m.Used(), Equals, int64(100))
+
+	// waiting stops when the connection is done
+	m.Add(1)
+	done := make(chan struct{})
+	close(done)
+	m.wait(done)
+}
+
+func (s *MemorySuite) TestNoLimit(c *C) {
+	m := NewMemoryMeter(0)
+	m.Add(1 << 40)
+	c.Check(m.Exceeded(), Equals, false)
+	m.wait(nil)
+
+	var nilMeter *MemoryMeter
+	nilMeter.Add(10)
+	c.Check(nilMeter.Used(), Equals, int64(0))
+	c.Check(nilMeter.Exceeded(), Equals, false)
+}
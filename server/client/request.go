@@ -2,10 +2,26 @@ package client
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
+// Header added to an ERROR frame sent to the client, containing the
+// correlation id of the client frame that caused the error. The same
+// id appears in server log messages about the frame, so an error
+// reported by a client can be matched with the server logs.
+const RequestIdHeader = "x-request-id"
+
+// Header of a message that is traced by the server. A client requests
+// tracing by sending a message with this header set to any value, which
+// the server replaces with the correlation id of the SEND frame.
+const MessageTraceHeader = "x-message-trace"
+
+// Header added to a traced MESSAGE frame with the time that it was sent
+// to the client.
+const TraceDispatchedHeader = "x-trace-dispatched"
+
 // Opcode used in client requests.
 type RequestOp int
 
@@ -17,16 +33,22 @@ func (r RequestOp) String() string {
 const (
 	SubscribeOp    RequestOp = iota // subscription ready
 	UnsubscribeOp                   // subscription not ready
-	EnqueueOp                       // send a message to a queue
+	EnqueueOp                       // send a message to a queue or topic
 	RequeueOp                       // re-queue a message, not successfully sent
 	ConnectedOp                     // connection established
 	DisconnectedOp                  // connection disconnected
+	AckOp                           // message acknowledged by the client
+	CommitBeginOp                   // start of the requests for a committed transaction
+	CommitEndOp                     // end of the requests for a committed transaction
 )
 
 // Client requests received to be processed by main processing loop
 type Request struct {
-	Op    RequestOp     // opcode for request
-	Sub   *Subscription // SubscribeOp, UnsubscribeOp
-	Frame *frame.Frame  // EnqueueOp, RequeueOp
-	Conn  *Conn         // ConnectedOp, DisconnectedOp
+	Op      RequestOp     // opcode for request
+	Sub     *Subscription // SubscribeOp, UnsubscribeOp, AckOp
+	Frame   *frame.Frame  // EnqueueOp, RequeueOp, AckOp
+	Conn    *Conn         // ConnectedOp, DisconnectedOp, and requests with a receipt
+	Id      string        // correlation id of the client frame that caused the request, if any
+	Receipt string        // EnqueueOp, CommitEndOp: receipt to send to Conn once processed
+	Time    time.Time     // EnqueueOp: when the frame was received from the client
 }
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// SlowAction determines what is done when the client of a subscription
+// does not read frames as fast as they are sent, so that a frame cannot
+// be passed to its connection within the subscription's slow consumer
+// timeout.
+type SlowAction int
+
+const (
+	SlowWait        SlowAction = iota // wait for room, holding up the sender
+	SlowDrop                          // drop the frame for a topic subscription, wait for a queue subscription
+	SlowUnsubscribe                   // remove the subscription
+	SlowDisconnect                    // send an ERROR frame and disconnect
+)
+
+// Sent in the ERROR frame of a client disconnected by SlowDisconnect.
+const slowConsumer = errorMessage("slow consumer: client did not read messages in time")
+
+// SetSlowPolicy sets what is done when a frame sent to the subscription
+// cannot be passed to its connection within timeout. The frames of a
+// queue subscription are never dropped, as they are not sent to other
+// subscriptions, so SlowDrop waits instead. If a queue subscription is
+// removed or its client disconnected, the frame is returned to the
+// queue. The default action is SlowWait. Must be called by the upper
+// layer.
+func (s *Subscription) SetSlowPolicy(action SlowAction, timeout time.Duration) {
+	s.slowAction = action
+	s.slowTimeout = timeout
+}
+
+// Passes a topic frame to the write channel, applying the slow consumer
+// policy of the subscription if there is no room within its timeout.
+// Once frames are being dropped, they are dropped without waiting
+// until there is room again, so that the subscription does not hold
+// up the sender for the timeout with every frame.
+func (s *Subscription) sendSlow(f *frame.Frame) {
+	c := s.conn
+	timeout := s.slowTimeout
+	if s.dropping {
+		timeout = 0
+	}
+	if c.sendWithin(f, timeout) {
+		if s.dropping {
+			s.dropping = false
+			c.log.Infof("slow consumer: subscription %s to %s has caught up", s.id, s.dest)
+		}
+		return
+	}
+	switch s.slowAction {
+	case SlowDrop:
+		atomic.AddUint64(&c.stats.framesDropped, 1)
+		if !s.dropping {
+			s.dropping = true
+			c.log.Warningf("slow consumer: dropping messages for subscription %s to %s", s.id, s.dest)
+		}
+	case SlowUnsubscribe, SlowDisconnect:
+		s.abort()
+	}
+}
+
+// Passes a queue subscription with a frame to the subscription channel,
+// applying the slow consumer policy of the subscription if there is no
+// room within its timeout, in which case an error is returned so that
+// the frame is sent to another subscription.
+func (s *Subscription) sendQueueSlow() error {
+	c := s.conn
+	if c.sendSubscriptionWithin(s, s.slowTimeout) {
+		return nil
+	}
+	s.abort()
+	return slowConsumer
+}
+
+// Removes the subscription, or disconnects its client, as the client is
+// a slow consumer.
+func (s *Subscription) abort() {
+	c := s.conn
+	switch s.slowAction {
+	case SlowUnsubscribe:
+		s.aborted = true
+		c.log.Warningf("slow consumer: removing subscription %s to %s", s.id, s.dest)
+		// the upper layer is told now, as the connection may be
+		// blocked writing to the client for a long time, and from a
+		// separate goroutine, as this runs on the upper layer's
+		go func() {
+			c.requestChannel <- Request{Op: UnsubscribeOp, Sub: s}
+		}()
+		select {
+		case c.abortChannel <- s:
+		default:
+			// the connection is closing, or is removing other
+			// subscriptions; this one receives no more frames
+		}
+	case SlowDisconnect:
+		s.aborted = true
+		select {
+		case c.slowChannel <- struct{}{}:
+		default:
+			// already signalled
+		}
+		// a write to the client that is blocked is abandoned once
+		// the timeout has passed again
+		c.rw.SetWriteDeadline(time.Now().Add(s.slowTimeout))
+	}
+}
+
+// Passes a frame to the write channel, waiting at most timeout for
+// room, or not at all if timeout is not positive. Returns false if
+// there was no room. If the connection is closed, the frame is
+// discarded and true is returned.
+func (c *Conn) sendWithin(f *frame.Frame, timeout time.Duration) bool {
+	if err := c.TrySend(f); err != ErrBufferFull {
+		return true
+	} else if timeout <= 0 {
+		return false
+	}
+
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	held := c.hold(f)
+	select {
+	case c.writeChannel <- held:
+		return true
+	case <-c.done:
+		c.memory.Add(-held.size)
+		return true
+	case <-timer.C:
+		c.memory.Add(-held.size)
+		return false
+	}
+}
+
+// Passes a queue subscription with a frame to the subscription channel,
+// waiting at most timeout for room. Returns false if there was no room
+// or the connection is closed.
+func (c *Conn) sendSubscriptionWithin(sub *Subscription, timeout time.Duration) bool {
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	if c.closed {
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	c.holdSubscription(sub)
+	select {
+	case c.subChannel <- sub:
+		return true
+	case <-c.done:
+		c.memory.Add(-sub.size)
+		return false
+	case <-timer.C:
+		c.memory.Add(-sub.size)
+		return false
+	}
+}
+
+// Forgets a subscription that has been removed because the client is
+// a slow consumer.
+func (c *Conn) abortSubscription(sub *Subscription) {
+	if c.subs[sub.id] == sub {
+		delete(c.subs, sub.id)
+	}
+}
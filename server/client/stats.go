@@ -0,0 +1,80 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats are the counts of a client connection's activity.
+type ConnStats struct {
+	FramesRead    uint64    // Frames received from the client
+	FramesWritten uint64    // Frames sent to the client
+	BytesRead     uint64    // Bytes received from the client, including heart-beats
+	BytesWritten  uint64    // Bytes sent to the client, including heart-beats
+	PendingWrites int       // Frames waiting to be sent to the client
+	FramesDropped uint64    // Topic frames dropped because the client is a slow consumer
+	Transactions  int       // Transactions begun and not yet committed or aborted
+	LastActivity  time.Time // Time a frame was last received from or sent to the client
+}
+
+// Counters of a connection, which are updated atomically.
+type connStats struct {
+	framesRead    uint64
+	framesWritten uint64
+	bytesRead     uint64
+	bytesWritten  uint64
+	framesDropped uint64
+	transactions  int64
+	lastActivity  int64 // Unix time in nanoseconds
+}
+
+func (s *connStats) frameRead() {
+	atomic.AddUint64(&s.framesRead, 1)
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+func (s *connStats) frameWritten() {
+	atomic.AddUint64(&s.framesWritten, 1)
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+func (s *connStats) setTransactions(n int) {
+	atomic.StoreInt64(&s.transactions, int64(n))
+}
+
+// Stats returns the counts of the connection's activity. It can be
+// called from any go-routine.
+func (c *Conn) Stats() ConnStats {
+	stats := ConnStats{
+		FramesRead:    atomic.LoadUint64(&c.stats.framesRead),
+		FramesWritten: atomic.LoadUint64(&c.stats.framesWritten),
+		BytesRead:     atomic.LoadUint64(&c.stats.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&c.stats.bytesWritten),
+		PendingWrites: len(c.writeChannel) + len(c.subChannel),
+		FramesDropped: atomic.LoadUint64(&c.stats.framesDropped),
+		Transactions:  int(atomic.LoadInt64(&c.stats.transactions)),
+	}
+	if t := atomic.LoadInt64(&c.stats.lastActivity); t != 0 {
+		stats.LastActivity = time.Unix(0, t)
+	}
+	return stats
+}
+
+// A network connection that counts the bytes read and written.
+type countingConn struct {
+	net.Conn
+	stats *connStats
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.stats.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.stats.bytesWritten, uint64(n))
+	return n, err
+}
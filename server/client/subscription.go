@@ -1,17 +1,42 @@
 package client
 
 import (
+	"container/list"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
+// Header of a SEND or SUBSCRIBE frame that another server of a cluster
+// has forwarded on behalf of its client, containing the name of that
+// server.
+const ForwardedHeader = "forwarded-by"
+
 type Subscription struct {
-	conn    *Conn
-	dest    string
-	id      string            // client's subscription id
-	ack     string            // auto, client, client-individual
-	msgId   uint64            // message-id (or ack) for acknowledgement
-	subList *SubscriptionList // am I in a list
-	frame   *frame.Frame      // message allocated to subscription
+	conn     *Conn
+	dest     string
+	id       string            // client's subscription id
+	ack      string            // auto, client, client-individual
+	msgId    uint64            // message-id (or ack) for acknowledgement
+	subList  *SubscriptionList // am I in a list
+	element  *list.Element     // position in the list
+	frame    *frame.Frame      // message allocated to subscription
+	size     int64             // bytes of frame counted by the memory meter while on the subscription channel
+	replay   bool              // replay retained messages
+	fromSeq  uint64            // first sequence number to replay
+	fromTime time.Time         // earliest time of a message to replay
+	fwdBy    string            // server that forwarded the subscription, if any
+	dispatch time.Time         // when the frame was allocated to the subscription
+	sent     time.Time         // when the frame was sent to the client
+
+	// set by the upper layer, and read by the goroutine that sends
+	// topic frames to the subscription
+	slowAction  SlowAction    // applied when the client is a slow consumer
+	slowTimeout time.Duration // time allowed for a topic frame to be passed to the connection
+	dropping    bool          // frames are being dropped because the client is a slow consumer
+	aborted     bool          // removed because the client is a slow consumer
 }
 
 func newSubscription(c *Conn, dest string, id string, ack string) *Subscription {
@@ -23,6 +48,11 @@ func newSubscription(c *Conn, dest string, id string, ack string) *Subscription
 	}
 }
 
+// Conn returns the client connection of the subscription.
+func (s *Subscription) Conn() *Conn {
+	return s.conn
+}
+
 func (s *Subscription) Destination() string {
 	return s.dest
 }
@@ -35,6 +65,41 @@ func (s *Subscription) Id() string {
 	return s.id
 }
 
+// ForwardedBy returns the name of the server that forwarded the
+// subscription on behalf of its client, or an empty string if the
+// subscription was made by a client of this server.
+func (s *Subscription) ForwardedBy() string {
+	return s.fwdBy
+}
+
+// ReplayFrom returns the sequence number and the time of the first
+// retained message to replay. If ok is false, the client did not ask
+// for retained messages.
+func (s *Subscription) ReplayFrom() (seq uint64, t time.Time, ok bool) {
+	return s.fromSeq, s.fromTime, s.replay
+}
+
+// Sets where to replay retained messages from, using the "from-seq"
+// and "from-time" headers of the SUBSCRIBE frame.
+func (s *Subscription) setReplay(header *frame.Header) error {
+	if text, ok := header.Contains(stomp.FromSequenceHeader); ok {
+		seq, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return invalidHeaderValue
+		}
+		s.replay, s.fromSeq = true, seq
+	}
+	if text, ok := header.Contains(stomp.FromTimeHeader); ok {
+		ms, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return invalidHeaderValue
+		}
+		s.replay = true
+		s.fromTime = time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+	}
+	return nil
+}
+
 func (s *Subscription) IsAckedBy(msgId uint64) bool {
 	switch s.ack {
 	case frame.AckAuto:
@@ -56,24 +121,52 @@ func (s *Subscription) IsNackedBy(msgId uint64) bool {
 	return msgId == s.msgId
 }
 
-func (s *Subscription) SendQueueFrame(f *frame.Frame) {
+// Send a message frame to the client, as part of this
+// subscription. The frame requires acknowledgement before the
+// subscription is ready for another frame. Returns an error if
+// the client connection has closed, in which case the frame has
+// not been sent and the caller remains responsible for it.
+func (s *Subscription) SendQueueFrame(f *frame.Frame) error {
 	s.setSubscriptionHeader(f)
 	s.frame = f
+	s.dispatch = time.Now()
 
 	// let the connection deal with the subscription
 	// acknowledgement
-	s.conn.subChannel <- s
+	var err error
+	if s.slowAction == SlowUnsubscribe || s.slowAction == SlowDisconnect {
+		err = s.sendQueueSlow()
+	} else {
+		err = s.conn.sendSubscription(s)
+	}
+	if err != nil {
+		s.frame = nil
+	}
+	return err
 }
 
 // Send a message frame to the client, as part of this
 // subscription. Called within the queue when a message
 // frame is available.
 func (s *Subscription) SendTopicFrame(f *frame.Frame) {
+	if s.aborted {
+		return
+	}
 	s.setSubscriptionHeader(f)
 
 	// topics are handled differently, they just go
 	// straight to the client without acknowledgement
-	s.conn.writeChannel <- f
+	if s.slowAction == SlowWait {
+		s.conn.Send(f)
+	} else {
+		s.sendSlow(f)
+	}
+}
+
+// SendError sends an ERROR frame to the client of the subscription,
+// and closes its connection.
+func (s *Subscription) SendError(err error) {
+	s.conn.SendError(err)
 }
 
 func (s *Subscription) setSubscriptionHeader(f *frame.Frame) {
@@ -2,17 +2,29 @@ package client
 
 import (
 	"container/list"
+
+	"github.com/go-stomp/stomp/v3/frame"
 )
 
 // Maintains a list of subscriptions. Not thread-safe.
+//
+// The subscriptions are indexed by their id and by the message-id of
+// the frame allocated to them, so that removing a subscription and
+// acknowledging a frame do not take time proportional to the length
+// of the list. Ids are not unique across connections, so each index
+// entry holds the subscriptions with the key in list order.
 type SubscriptionList struct {
-	// TODO: implement linked list locally, adding next and prev
-	// pointers to the Subscription struct itself.
-	subs *list.List
+	subs    *list.List
+	byId    map[string][]*Subscription
+	byMsgId map[uint64][]*Subscription
 }
 
 func NewSubscriptionList() *SubscriptionList {
-	return &SubscriptionList{list.New()}
+	return &SubscriptionList{
+		subs:    list.New(),
+		byId:    make(map[string][]*Subscription),
+		byMsgId: make(map[uint64][]*Subscription),
+	}
 }
 
 // Add a subscription to the back of the list. Will panic if
@@ -23,8 +35,28 @@ func (sl *SubscriptionList) Add(sub *Subscription) {
 	if sub.subList != nil {
 		panic("subscription is already in a subscription list")
 	}
-	sl.subs.PushBack(sub)
+	sub.element = sl.subs.PushBack(sub)
 	sub.subList = sl
+	sl.byId[sub.id] = append(sl.byId[sub.id], sub)
+	sl.byMsgId[sub.msgId] = append(sl.byMsgId[sub.msgId], sub)
+}
+
+// Reports whether the subscription is in the list. This is decided by
+// the index of the list, not by the subscription, whose fields are
+// written by the connection go-routine while it is in the connection's
+// list of frames waiting for acknowledgement.
+func (sl *SubscriptionList) Contains(sub *Subscription) bool {
+	for _, s := range sl.byId[sub.id] {
+		if s == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the number of subscriptions in the list.
+func (sl *SubscriptionList) Len() int {
+	return sl.subs.Len()
 }
 
 // Gets the first subscription in the list, or nil if there
@@ -34,33 +66,25 @@ func (sl *SubscriptionList) Get() *Subscription {
 	if sl.subs.Len() == 0 {
 		return nil
 	}
-	front := sl.subs.Front()
-	sub := front.Value.(*Subscription)
-	sl.subs.Remove(front)
-	sub.subList = nil
+	sub := sl.subs.Front().Value.(*Subscription)
+	sl.remove(sub)
 	return sub
 }
 
 // Removes the subscription from the list.
 func (sl *SubscriptionList) Remove(s *Subscription) {
-	for e := sl.subs.Front(); e != nil; e = e.Next() {
-		if e.Value.(*Subscription) == s {
-			sl.subs.Remove(e)
-			s.subList = nil
-			return
-		}
+	if sl.Contains(s) {
+		sl.remove(s)
 	}
 }
 
 // Search for a subscription with the specified id and remove it.
 // Returns a pointer to the subscription if found, nil otherwise.
 func (sl *SubscriptionList) FindByIdAndRemove(id string) *Subscription {
-	for e := sl.subs.Front(); e != nil; e = e.Next() {
-		sub := e.Value.(*Subscription)
-		if sub.id == id {
-			sl.subs.Remove(e)
-			return sub
-		}
+	if subs := sl.byId[id]; len(subs) > 0 {
+		sub := subs[0]
+		sl.remove(sub)
+		return sub
 	}
 	return nil
 }
@@ -68,16 +92,25 @@ func (sl *SubscriptionList) FindByIdAndRemove(id string) *Subscription {
 // Finds all subscriptions in the subscription list that are acked by the
 // specified message-id (or ack) header. The subscription is removed from
 // the list and the callback function called for that subscription.
+//
+// The message-id acknowledges the frame allocated to it. If the frame's
+// subscription has the "client" ack mode, the message-id also
+// acknowledges earlier frames of the same subscription, which has the
+// same id and connection, but not the frames of other subscriptions.
 func (sl *SubscriptionList) Ack(msgId uint64, callback func(s *Subscription)) {
-	for e := sl.subs.Front(); e != nil; {
-		next := e.Next()
-		sub := e.Value.(*Subscription)
-		if sub.IsAckedBy(msgId) {
-			sl.subs.Remove(e)
-			callback(sub)
+	subs := sl.byMsgId[msgId]
+	acked := append([]*Subscription(nil), subs...)
+	for _, sub := range subs {
+		if sub.ack != frame.AckClient {
+			continue
+		}
+		for _, s := range sl.byId[sub.id] {
+			if s.conn == sub.conn && s.msgId < msgId {
+				acked = append(acked, s)
+			}
 		}
-		e = next
 	}
+	sl.removeAll(acked, callback)
 }
 
 // Finds all subscriptions in the subscription list that are *nacked* by the
@@ -85,15 +118,46 @@ func (sl *SubscriptionList) Ack(msgId uint64, callback func(s *Subscription)) {
 // the list and the callback function called for that subscription. Current
 // understanding that all NACKs are individual, but not sure
 func (sl *SubscriptionList) Nack(msgId uint64, callback func(s *Subscription)) {
-	for e := sl.subs.Front(); e != nil; {
-		next := e.Next()
-		sub := e.Value.(*Subscription)
-		if sub.IsNackedBy(msgId) {
-			sl.subs.Remove(e)
-			callback(sub)
+	sl.removeAll(sl.byMsgId[msgId], callback)
+}
+
+// Removes subscriptions from the list, calling the callback function
+// for each of them in turn.
+func (sl *SubscriptionList) removeAll(subs []*Subscription, callback func(s *Subscription)) {
+	// the index entry changes as subscriptions are removed
+	subs = append([]*Subscription(nil), subs...)
+	for _, sub := range subs {
+		sl.remove(sub)
+		callback(sub)
+	}
+}
+
+// Removes a subscription that is in the list from the list and its
+// indexes.
+func (sl *SubscriptionList) remove(sub *Subscription) {
+	sl.subs.Remove(sub.element)
+	sl.byId[sub.id] = removeSubscription(sl.byId[sub.id], sub)
+	if len(sl.byId[sub.id]) == 0 {
+		delete(sl.byId, sub.id)
+	}
+	sl.byMsgId[sub.msgId] = removeSubscription(sl.byMsgId[sub.msgId], sub)
+	if len(sl.byMsgId[sub.msgId]) == 0 {
+		delete(sl.byMsgId, sub.msgId)
+	}
+	sub.element = nil
+	sub.subList = nil
+}
+
+// Removes a subscription from a slice, keeping the order of the others.
+func removeSubscription(subs []*Subscription, sub *Subscription) []*Subscription {
+	for i, s := range subs {
+		if s == sub {
+			copy(subs[i:], subs[i+1:])
+			subs[len(subs)-1] = nil
+			return subs[:len(subs)-1]
 		}
-		e = next
 	}
+	return subs
 }
 
 // Invoke a callback function for every subscription in the list.
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"runtime"
+	"strconv"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -55,27 +58,31 @@ func (s *SubscriptionListSuite) TestAck(c *C) {
 	sub2 := &Subscription{dest: "/dest3", id: "2", ack: "client-individual", msgId: 102}
 	sub3 := &Subscription{dest: "/dest4", id: "3", ack: "client", msgId: 103}
 	sub4 := &Subscription{dest: "/dest4", id: "4", ack: "client", msgId: 104}
+	sub5 := &Subscription{dest: "/dest4", id: "3", ack: "client", msgId: 100}
 
 	sl := NewSubscriptionList()
 	sl.Add(sub1)
 	sl.Add(sub2)
 	sl.Add(sub3)
 	sl.Add(sub4)
+	sl.Add(sub5)
 
-	c.Check(sl.subs.Len(), Equals, 4)
+	c.Check(sl.subs.Len(), Equals, 5)
 
 	var subs []*Subscription
 	callback := func(s *Subscription) {
 		subs = append(subs, s)
 	}
 
-	// now remove the second subscription
+	// the ack acknowledges the earlier frame of the same subscription,
+	// but not the earlier frame of another subscription
 	sl.Ack(103, callback)
 
 	c.Assert(len(subs), Equals, 2)
-	c.Assert(subs[0], Equals, sub1)
-	c.Assert(subs[1], Equals, sub3)
+	c.Assert(subs[0], Equals, sub3)
+	c.Assert(subs[1], Equals, sub5)
 
+	c.Assert(sl.Get(), Equals, sub1)
 	c.Assert(sl.Get(), Equals, sub2)
 	c.Assert(sl.Get(), Equals, sub4)
 	c.Assert(sl.Get(), IsNil)
@@ -111,3 +118,85 @@ func (s *SubscriptionListSuite) TestNack(c *C) {
 	c.Assert(sl.Get(), Equals, sub4)
 	c.Assert(sl.Get(), IsNil)
 }
+
+func (s *SubscriptionListSuite) TestIndexes(c *C) {
+	// subscriptions of different connections can have the same
+	// id and message-id
+	sl := NewSubscriptionList()
+	var subs []*Subscription
+	for i := 0; i < 1000; i++ {
+		sub := &Subscription{dest: "/dest", id: strconv.Itoa(i % 500), ack: "client-individual", msgId: uint64(i % 500)}
+		sl.Add(sub)
+		subs = append(subs, sub)
+	}
+
+	var acked []*Subscription
+	sl.Ack(7, func(s *Subscription) { acked = append(acked, s) })
+	c.Check(acked, DeepEquals, []*Subscription{subs[7], subs[507]})
+	sl.Ack(7, func(s *Subscription) { c.Error("acked twice") })
+
+	c.Check(sl.FindByIdAndRemove("9"), Equals, subs[9])
+	c.Check(sl.FindByIdAndRemove("9"), Equals, subs[509])
+	c.Check(sl.FindByIdAndRemove("9"), IsNil)
+
+	sl.Remove(subs[0])
+	sl.Remove(subs[0])
+	c.Check(sl.Len(), Equals, 995)
+	var nacked []*Subscription
+	sl.Nack(0, func(s *Subscription) { nacked = append(nacked, s) })
+	c.Check(nacked, DeepEquals, []*Subscription{subs[500]})
+
+	// frames of a subscription with the client ack mode are only
+	// acknowledged by later message-ids of the same subscription
+	earlier := &Subscription{dest: "/dest", id: "x", ack: "client", msgId: 1000}
+	later := &Subscription{dest: "/dest", id: "x", ack: "client", msgId: 1001}
+	sl.Add(earlier)
+	sl.Add(later)
+	acked = nil
+	sl.Ack(5, func(s *Subscription) { acked = append(acked, s) })
+	c.Check(acked, DeepEquals, []*Subscription{subs[5], subs[505]})
+	acked = nil
+	sl.Ack(1001, func(s *Subscription) { acked = append(acked, s) })
+	c.Check(acked, DeepEquals, []*Subscription{later, earlier})
+	c.Check(sl.Get(), Equals, subs[1])
+	c.Check(sl.Len(), Equals, 991)
+}
+
+// The upper layer removes subscriptions from its lists, such as when a
+// client unsubscribes, while the connection go-routine acknowledges
+// frames of the same subscriptions in its own list. Run with -race.
+func (s *SubscriptionListSuite) TestRemoveWhileAcked(c *C) {
+	subs := make([]*Subscription, 100)
+	for i := range subs {
+		subs[i] = newSubscription(nil, "/dest", strconv.Itoa(i), "client-individual")
+	}
+	idle := NewSubscriptionList()
+	unacked := NewSubscriptionList()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := uint64(1); n <= 100; n++ {
+			for _, sub := range subs {
+				sub.msgId = n
+				unacked.Add(sub)
+			}
+			unacked.Ack(n, func(s *Subscription) {})
+			runtime.Gosched()
+		}
+	}()
+	for acking := true; acking; {
+		select {
+		case <-done:
+			acking = false
+		default:
+		}
+		for _, sub := range subs {
+			idle.Remove(sub)
+			c.Check(idle.Contains(sub), Equals, false)
+		}
+		runtime.Gosched()
+	}
+	c.Check(unacked.Len(), Equals, 0)
+	c.Check(idle.Len(), Equals, 0)
+}
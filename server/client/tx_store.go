@@ -8,13 +8,22 @@ import (
 
 type txStore struct {
 	transactions map[string]*list.List
+	memory       *MemoryMeter // counts the frames stored, nil if none are counted
 }
 
 // Initializes a new store or clears out an existing store
 func (txs *txStore) Init() {
+	for _, list := range txs.transactions {
+		txs.release(list)
+	}
 	txs.transactions = nil
 }
 
+// Returns the number of transactions in progress.
+func (txs *txStore) Len() int {
+	return len(txs.transactions)
+}
+
 func (txs *txStore) Begin(tx string) error {
 	if txs.transactions == nil {
 		txs.transactions = make(map[string]*list.List)
@@ -30,6 +39,7 @@ func (txs *txStore) Begin(tx string) error {
 
 func (txs *txStore) Abort(tx string) error {
 	if list, ok := txs.transactions[tx]; ok {
+		txs.release(list)
 		list.Init()
 		delete(txs.transactions, tx)
 		return nil
@@ -44,7 +54,9 @@ func (txs *txStore) Abort(tx string) error {
 func (txs *txStore) Commit(tx string, commitFunc func(f *frame.Frame) error) error {
 	if list, ok := txs.transactions[tx]; ok {
 		for element := list.Front(); element != nil; element = list.Front() {
-			err := commitFunc(list.Remove(element).(*frame.Frame))
+			f := list.Remove(element).(*frame.Frame)
+			txs.memory.Add(-int64(f.Size()))
+			err := commitFunc(f)
 			if err != nil {
 				return err
 			}
@@ -59,7 +71,15 @@ func (txs *txStore) Add(tx string, f *frame.Frame) error {
 	if list, ok := txs.transactions[tx]; ok {
 		f.Header.Del(frame.Transaction)
 		list.PushBack(f)
+		txs.memory.Add(int64(f.Size()))
 		return nil
 	}
 	return txUnknown
 }
+
+// Removes the frames of a transaction from the count of memory held.
+func (txs *txStore) release(list *list.List) {
+	for element := list.Front(); element != nil; element = element.Next() {
+		txs.memory.Add(-int64(element.Value.(*frame.Frame).Size()))
+	}
+}
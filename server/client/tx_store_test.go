@@ -79,3 +79,26 @@ func (s *TxStoreSuite) TestSuccessfulTx(c *C) {
 	})
 	c.Check(err, Equals, txUnknown)
 }
+
+func (s *TxStoreSuite) TestMemory(c *C) {
+	txs := txStore{memory: NewMemoryMeter(0)}
+	c.Assert(txs.Begin("tx1"), IsNil)
+	c.Assert(txs.Begin("tx2"), IsNil)
+	f1 := frame.New(frame.SEND, frame.Destination, "/queue/1")
+	f2 := frame.New(frame.SEND, frame.Destination, "/queue/2")
+	f3 := frame.New(frame.SEND, frame.Destination, "/queue/3")
+	c.Assert(txs.Add("tx1", f1), IsNil)
+	c.Assert(txs.Add("tx1", f2), IsNil)
+	c.Assert(txs.Add("tx2", f3), IsNil)
+	c.Check(txs.memory.Used(), Equals, int64(f1.Size()+f2.Size()+f3.Size()))
+
+	c.Assert(txs.Commit("tx1", func(f *frame.Frame) error { return nil }), IsNil)
+	c.Check(txs.memory.Used(), Equals, int64(f3.Size()))
+	c.Assert(txs.Abort("tx2"), IsNil)
+	c.Check(txs.memory.Used(), Equals, int64(0))
+
+	c.Assert(txs.Begin("tx3"), IsNil)
+	c.Assert(txs.Add("tx3", f1), IsNil)
+	txs.Init()
+	c.Check(txs.memory.Used(), Equals, int64(0))
+}
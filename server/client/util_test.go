@@ -3,6 +3,7 @@ package client
 import (
 	. "gopkg.in/check.v1"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -21,3 +22,10 @@ func (s *UtilSuite) TestAsMilliseconds(c *C) {
 	d = time.Duration(365) * time.Duration(24) * time.Hour
 	c.Check(asMilliseconds(d, maxHeartBeat), Equals, maxHeartBeat)
 }
+
+func (s *UtilSuite) TestNewId(c *C) {
+	id1, id2 := NewId(), NewId()
+	c.Check(id1, Not(Equals), id2)
+	c.Check(strings.HasPrefix(id1, IdPrefix+"-"), Equals, true)
+	c.Check(strings.HasPrefix(id2, IdPrefix+"-"), Equals, true)
+}
@@ -0,0 +1,677 @@
+package cluster
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/internal/log"
+)
+
+// Default timing options.
+const (
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultElectionTimeout   = 500 * time.Millisecond
+	DefaultProposeTimeout    = 5 * time.Second
+)
+
+// Maximum number of entries sent in one AppendEntries request.
+const maxAppendEntries = 256
+
+// Errors returned by a node.
+var (
+	ErrNoLeader       = errors.New("cluster: no leader")
+	ErrUnreachable    = errors.New("cluster: node unreachable")
+	ErrLeadershipLost = errors.New("cluster: leadership lost before command was committed")
+	ErrTimeout        = errors.New("cluster: timed out waiting for command to be committed")
+	ErrClosed         = errors.New("cluster: node is closed")
+	ErrNoID           = errors.New("cluster: node ID is required")
+)
+
+// Options for a node of a cluster.
+type Options struct {
+	// Identifies the node in the cluster, required. With the default
+	// transport, this is the TCP address that the node serves on.
+	ID string
+
+	// IDs of the other nodes of the cluster. Every node must be given
+	// the same set of nodes.
+	Peers []string
+
+	// Sends requests to the other nodes. If nil, a TCPTransport is used.
+	Transport Transport
+
+	// File that the node's term, vote and log are kept in. A node that
+	// is restarted must be given the same file. If empty, the state is
+	// kept in memory, and a node that restarts must not rejoin the
+	// cluster with the same ID.
+	Path string
+
+	// Interval at which the leader sends heartbeats to the other
+	// nodes. If zero, DefaultHeartbeatInterval is used.
+	HeartbeatInterval time.Duration
+
+	// Time without hearing from a leader after which a node starts an
+	// election. The actual timeout is randomly chosen between this
+	// and twice this. If zero, DefaultElectionTimeout is used.
+	ElectionTimeout time.Duration
+
+	// Maximum time to wait for a command to be committed, including
+	// the time to elect a leader. If zero, DefaultProposeTimeout is used.
+	ProposeTimeout time.Duration
+
+	Log stomp.Logger // Logger, the standard logger if nil
+}
+
+// An FSM is the state machine that a cluster replicates. Commands are
+// applied in the same order on every node, so Apply must be
+// deterministic.
+type FSM interface {
+	// Apply a committed command, returning the result for the node
+	// that proposed it. Empty commands are not applied.
+	Apply(command []byte) []byte
+}
+
+// Roles of a node.
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// An Entry is an entry of the replicated log.
+type Entry struct {
+	Term    uint64
+	Command []byte // empty for the entry that a new leader appends
+}
+
+// Waits for a proposed command to be applied.
+type waiter struct {
+	term uint64
+	done chan proposal
+}
+
+type proposal struct {
+	result []byte
+	err    error
+}
+
+// A Node is a member of a cluster that replicates a log of commands
+// with the Raft consensus algorithm, and applies committed commands
+// to its FSM. Commands can be proposed on any node, and are forwarded
+// to the leader.
+//
+// The log is not compacted, so it grows with every command, and a
+// restarted node applies the whole log again.
+type Node struct {
+	opts  Options
+	fsm   FSM
+	state *state // persistent state, nil if kept in memory
+
+	mutex            sync.Mutex
+	role             role
+	term             uint64
+	votedFor         string
+	log              []Entry // log[0] is a sentinel, so that entries are indexed from 1
+	commitIndex      uint64
+	lastApplied      uint64
+	leader           string
+	electionDeadline time.Time
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	sending          map[string]bool      // whether an AppendEntries request to a peer is in progress
+	lastContact      map[string]time.Time // when each peer last answered the leader
+	leaderSince      time.Time
+	waiters          map[uint64]*waiter
+	closed           bool
+
+	replicate chan struct{} // signals the leader to send entries straight away
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewNode creates a node, which starts taking part in the cluster
+// straight away.
+func NewNode(opts Options, fsm FSM) (*Node, error) {
+	if opts.ID == "" {
+		return nil, ErrNoID
+	}
+	if opts.Transport == nil {
+		opts.Transport = NewTCPTransport(0)
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = DefaultElectionTimeout
+	}
+	if opts.ProposeTimeout <= 0 {
+		opts.ProposeTimeout = DefaultProposeTimeout
+	}
+	if opts.Log == nil {
+		opts.Log = log.StdLogger{}
+	}
+
+	n := &Node{
+		opts:        opts,
+		fsm:         fsm,
+		log:         []Entry{{}},
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		sending:     make(map[string]bool),
+		lastContact: make(map[string]time.Time),
+		waiters:     make(map[uint64]*waiter),
+		replicate:   make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if opts.Path != "" {
+		var err error
+		n.state, err = openState(opts.Path)
+		if err != nil {
+			return nil, err
+		}
+		if n.term, n.votedFor, err = n.state.load(); err != nil {
+			n.state.close()
+			return nil, err
+		}
+		entries, err := n.state.entries()
+		if err != nil {
+			n.state.close()
+			return nil, err
+		}
+		n.log = append(n.log, entries...)
+	}
+	n.resetElectionDeadline()
+	go n.run()
+	return n, nil
+}
+
+// ID returns the node's ID.
+func (n *Node) ID() string {
+	return n.opts.ID
+}
+
+// Leader returns the ID of the node that this node believes to be the
+// leader, or an empty string if it does not know of a leader.
+func (n *Node) Leader() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.leader
+}
+
+// IsLeader reports whether the node is the leader.
+func (n *Node) IsLeader() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.role == leader
+}
+
+// Close stops the node taking part in the cluster. Commands waiting to
+// be committed fail with ErrClosed.
+func (n *Node) Close() error {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return ErrClosed
+	}
+	n.closed = true
+	n.failWaiters(0, ErrClosed)
+	n.mutex.Unlock()
+
+	close(n.stop)
+	<-n.done
+	if n.state != nil {
+		return n.state.close()
+	}
+	return nil
+}
+
+// Number of votes needed to win an election or commit an entry.
+func (n *Node) quorum() int {
+	return (len(n.opts.Peers)+1)/2 + 1
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.opts.ElectionTimeout + time.Duration(rand.Int63n(int64(n.opts.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// Sends heartbeats while the node is the leader, and starts an election
+// when it has not heard from a leader for the election timeout.
+func (n *Node) run() {
+	defer close(n.done)
+	ticker := time.NewTicker(n.opts.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		case <-n.replicate:
+		}
+
+		n.mutex.Lock()
+		switch {
+		case n.role == leader && !n.hasQuorum():
+			n.opts.Log.Warningf("cluster: %s lost contact with a quorum, stepping down", n.opts.ID)
+			n.becomeFollower(n.term)
+		case n.role == leader:
+			for _, peer := range n.opts.Peers {
+				if !n.sending[peer] {
+					n.sending[peer] = true
+					go n.sendEntries(peer)
+				}
+			}
+		case time.Now().After(n.electionDeadline):
+			n.startElection()
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// Reports whether the leader has heard from a quorum within the
+// election timeout, so that a leader cut off from the rest of the
+// cluster steps down instead of accepting commands it cannot commit.
+func (n *Node) hasQuorum() bool {
+	now := time.Now()
+	if now.Sub(n.leaderSince) < n.opts.ElectionTimeout {
+		return true
+	}
+	count := 1
+	for _, peer := range n.opts.Peers {
+		if now.Sub(n.lastContact[peer]) < n.opts.ElectionTimeout {
+			count++
+		}
+	}
+	return count >= n.quorum()
+}
+
+// Signals the leader to send entries without waiting for a heartbeat.
+func (n *Node) signalReplicate() {
+	select {
+	case n.replicate <- struct{}{}:
+	default:
+	}
+}
+
+// Saves the term and vote, which must be done before responding to
+// a request or sending one in the term.
+func (n *Node) saveState() {
+	if n.state != nil {
+		if err := n.state.save(n.term, n.votedFor); err != nil {
+			n.opts.Log.Errorf("cluster: cannot save state: %v", err)
+		}
+	}
+}
+
+// Removes the entries from index onwards.
+func (n *Node) truncate(index uint64) {
+	n.log = n.log[:index]
+	n.failWaiters(index, ErrLeadershipLost)
+	if n.state != nil {
+		if err := n.state.truncate(index); err != nil {
+			n.opts.Log.Errorf("cluster: cannot truncate log: %v", err)
+		}
+	}
+}
+
+// Appends entries to the log.
+func (n *Node) append(entries ...Entry) error {
+	if n.state != nil {
+		if err := n.state.append(n.lastIndex()+1, entries); err != nil {
+			return err
+		}
+	}
+	n.log = append(n.log, entries...)
+	return nil
+}
+
+// Fails the waiters for entries from index onwards.
+func (n *Node) failWaiters(index uint64, err error) {
+	for i, w := range n.waiters {
+		if i >= index {
+			w.done <- proposal{err: err}
+			delete(n.waiters, i)
+		}
+	}
+}
+
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.saveState()
+	}
+	if n.role == leader {
+		// the entries might still be committed by the next leader,
+		// but this node can no longer tell
+		n.failWaiters(0, ErrLeadershipLost)
+		n.leader = ""
+	}
+	n.role = follower
+	n.resetElectionDeadline()
+}
+
+func (n *Node) startElection() {
+	n.role = candidate
+	n.term++
+	n.votedFor = n.opts.ID
+	n.leader = ""
+	n.saveState()
+	n.resetElectionDeadline()
+	n.opts.Log.Infof("cluster: %s starting election for term %d", n.opts.ID, n.term)
+
+	req := &VoteRequest{
+		Term:         n.term,
+		CandidateID:  n.opts.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.log[n.lastIndex()].Term,
+	}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.opts.Peers {
+		go func(peer string) {
+			resp, err := n.opts.Transport.RequestVote(peer, req)
+			if err != nil {
+				return
+			}
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term)
+				return
+			}
+			if n.role != candidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.opts.Log.Infof("cluster: %s is the leader for term %d", n.opts.ID, n.term)
+	n.role = leader
+	n.leader = n.opts.ID
+	n.leaderSince = time.Now()
+	for _, peer := range n.opts.Peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	// entries from earlier terms are committed once an entry
+	// from this term is
+	if err := n.append(Entry{Term: n.term}); err != nil {
+		n.opts.Log.Errorf("cluster: cannot append to log: %v", err)
+	}
+	n.advanceCommitIndex()
+	n.signalReplicate()
+}
+
+// Sends the entries that a peer does not have yet, or a heartbeat if
+// it has them all.
+func (n *Node) sendEntries(peer string) {
+	n.mutex.Lock()
+	if n.role != leader || n.closed {
+		n.sending[peer] = false
+		n.mutex.Unlock()
+		return
+	}
+	next := n.nextIndex[peer]
+	end := n.lastIndex() + 1
+	if end-next > maxAppendEntries {
+		end = next + maxAppendEntries
+	}
+	req := &AppendRequest{
+		Term:         n.term,
+		LeaderID:     n.opts.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.log[next-1].Term,
+		Entries:      append([]Entry(nil), n.log[next:end]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mutex.Unlock()
+
+	resp, err := n.opts.Transport.AppendEntries(peer, req)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.sending[peer] = false
+	if err != nil {
+		return
+	}
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term)
+		return
+	}
+	if n.role != leader || n.term != req.Term {
+		return
+	}
+	n.lastContact[peer] = time.Now()
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = match + 1
+		n.advanceCommitIndex()
+	} else {
+		next := resp.ConflictIndex
+		if next == 0 || next > req.PrevLogIndex {
+			next = req.PrevLogIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peer] = next
+	}
+	if n.nextIndex[peer] <= n.lastIndex() {
+		n.signalReplicate()
+	}
+}
+
+// Commits the entries that have been replicated to a quorum.
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.log[index].Term != n.term {
+			// only entries from the current term are committed
+			// by counting replicas
+			break
+		}
+		count := 1
+		for _, peer := range n.opts.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.apply()
+			return
+		}
+	}
+}
+
+// Applies the committed entries that have not been applied yet.
+func (n *Node) apply() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		e := n.log[n.lastApplied]
+		var result []byte
+		if len(e.Command) > 0 {
+			result = n.fsm.Apply(e.Command)
+		}
+		if w, ok := n.waiters[n.lastApplied]; ok {
+			if w.term == e.Term {
+				w.done <- proposal{result: result}
+			} else {
+				w.done <- proposal{err: ErrLeadershipLost}
+			}
+			delete(n.waiters, n.lastApplied)
+		}
+	}
+}
+
+// HandleRequestVote answers a RequestVote request from a candidate.
+// It is called by transports.
+func (n *Node) HandleRequestVote(req *VoteRequest) *VoteResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if req.Term > n.term {
+		n.becomeFollower(req.Term)
+	}
+	resp := &VoteResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp
+	}
+	lastTerm := n.log[n.lastIndex()].Term
+	upToDate := req.LastLogTerm > lastTerm ||
+		(req.LastLogTerm == lastTerm && req.LastLogIndex >= n.lastIndex())
+	if upToDate && (n.votedFor == "" || n.votedFor == req.CandidateID) {
+		n.votedFor = req.CandidateID
+		n.saveState()
+		n.resetElectionDeadline()
+		resp.Granted = true
+	}
+	return resp
+}
+
+// HandleAppendEntries answers an AppendEntries request from the leader.
+// It is called by transports.
+func (n *Node) HandleAppendEntries(req *AppendRequest) *AppendResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	resp := &AppendResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp
+	}
+	if req.Term > n.term || n.role != follower {
+		n.becomeFollower(req.Term)
+	}
+	n.leader = req.LeaderID
+	n.resetElectionDeadline()
+	resp.Term = n.term
+
+	if req.PrevLogIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+	if term := n.log[req.PrevLogIndex].Term; term != req.PrevLogTerm {
+		// skip back over all the entries of the conflicting term
+		index := req.PrevLogIndex
+		for index > 1 && n.log[index-1].Term == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	index := req.PrevLogIndex
+	for i, e := range req.Entries {
+		index++
+		if index <= n.lastIndex() {
+			if n.log[index].Term == e.Term {
+				continue
+			}
+			n.truncate(index)
+		}
+		if err := n.append(req.Entries[i:]...); err != nil {
+			n.opts.Log.Errorf("cluster: cannot append to log: %v", err)
+			return resp
+		}
+		break
+	}
+
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = req.LeaderCommit
+		if last := req.PrevLogIndex + uint64(len(req.Entries)); last < n.commitIndex {
+			n.commitIndex = last
+		}
+		n.apply()
+	}
+	resp.Success = true
+	return resp
+}
+
+// HandleForward proposes a command forwarded by another node, if this
+// node is the leader. It is called by transports.
+func (n *Node) HandleForward(command []byte) ([]byte, error) {
+	return n.propose(command, false)
+}
+
+// Propose a command, and wait for it to be applied. The command is
+// forwarded to the leader if this node is not the leader. Returns the
+// result of applying the command on the leader; this node might apply
+// it a little later.
+//
+// If an error is returned, the command might still be applied, for
+// example if the leader fails after replicating it.
+func (n *Node) Propose(command []byte) ([]byte, error) {
+	deadline := time.Now().Add(n.opts.ProposeTimeout)
+	for {
+		result, err := n.propose(command, true)
+		if err != ErrNoLeader && err != ErrUnreachable {
+			return result, err
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		// wait for an election
+		select {
+		case <-time.After(n.opts.HeartbeatInterval):
+		case <-n.stop:
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (n *Node) propose(command []byte, forward bool) ([]byte, error) {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if n.role != leader {
+		leaderID := n.leader
+		n.mutex.Unlock()
+		if !forward || leaderID == "" {
+			return nil, ErrNoLeader
+		}
+		return n.opts.Transport.Forward(leaderID, command)
+	}
+
+	if err := n.append(Entry{Term: n.term, Command: command}); err != nil {
+		n.mutex.Unlock()
+		return nil, err
+	}
+	index := n.lastIndex()
+	w := &waiter{term: n.term, done: make(chan proposal, 1)}
+	n.waiters[index] = w
+	n.advanceCommitIndex()
+	n.signalReplicate()
+	n.mutex.Unlock()
+
+	select {
+	case p := <-w.done:
+		return p.result, p.err
+	case <-time.After(n.opts.ProposeTimeout):
+		n.mutex.Lock()
+		delete(n.waiters, index)
+		n.mutex.Unlock()
+		return nil, ErrTimeout
+	}
+}
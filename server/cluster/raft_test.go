@@ -0,0 +1,300 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestCluster(t *testing.T) {
+	TestingT(t)
+}
+
+type RaftSuite struct{}
+
+var _ = Suite(&RaftSuite{})
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, value ...interface{})   {}
+func (nopLogger) Infof(format string, value ...interface{})    {}
+func (nopLogger) Warningf(format string, value ...interface{}) {}
+func (nopLogger) Errorf(format string, value ...interface{})   {}
+func (nopLogger) Debug(message string)                         {}
+func (nopLogger) Info(message string)                          {}
+func (nopLogger) Warning(message string)                       {}
+func (nopLogger) Error(message string)                         {}
+
+// Delivers requests between nodes in memory. Nodes can be
+// disconnected to simulate failures.
+type memTransport struct {
+	mutex        sync.Mutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{nodes: make(map[string]*Node), disconnected: make(map[string]bool)}
+}
+
+func (t *memTransport) node(from, to string) (*Node, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n, ok := t.nodes[to]
+	if !ok || t.disconnected[from] || t.disconnected[to] {
+		return nil, ErrUnreachable
+	}
+	return n, nil
+}
+
+func (t *memTransport) setConnected(id string, connected bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.disconnected[id] = !connected
+}
+
+// Returns the transport used by a node, which knows the sender
+// of each request.
+func (t *memTransport) from(id string) Transport {
+	return &memSender{t: t, id: id}
+}
+
+type memSender struct {
+	t  *memTransport
+	id string
+}
+
+func (s *memSender) RequestVote(peer string, req *VoteRequest) (*VoteResponse, error) {
+	n, err := s.t.node(s.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleRequestVote(req), nil
+}
+
+func (s *memSender) AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error) {
+	n, err := s.t.node(s.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleAppendEntries(req), nil
+}
+
+func (s *memSender) Forward(peer string, command []byte) ([]byte, error) {
+	n, err := s.t.node(s.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleForward(command)
+}
+
+// Records the commands applied to it.
+type recorder struct {
+	mutex    sync.Mutex
+	commands []string
+}
+
+func (r *recorder) Apply(command []byte) []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands = append(r.commands, string(command))
+	return []byte(fmt.Sprintf("applied %s", command))
+}
+
+func (r *recorder) applied() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+func testOptions(id string, ids []string) Options {
+	var peers []string
+	for _, peer := range ids {
+		if peer != id {
+			peers = append(peers, peer)
+		}
+	}
+	return Options{
+		ID:                id,
+		Peers:             peers,
+		HeartbeatInterval: 5 * time.Millisecond,
+		ElectionTimeout:   25 * time.Millisecond,
+		ProposeTimeout:    2 * time.Second,
+		Log:               nopLogger{},
+	}
+}
+
+// Starts a cluster of nodes connected by a memTransport.
+func startCluster(c *C, size int) (*memTransport, []*Node, []*recorder) {
+	t := newMemTransport()
+	var ids []string
+	for i := 0; i < size; i++ {
+		ids = append(ids, fmt.Sprintf("node%d", i))
+	}
+	var nodes []*Node
+	var fsms []*recorder
+	for _, id := range ids {
+		opts := testOptions(id, ids)
+		opts.Transport = t.from(id)
+		fsm := &recorder{}
+		n, err := NewNode(opts, fsm)
+		c.Assert(err, IsNil)
+		t.mutex.Lock()
+		t.nodes[id] = n
+		t.mutex.Unlock()
+		nodes = append(nodes, n)
+		fsms = append(fsms, fsm)
+	}
+	return t, nodes, fsms
+}
+
+// Waits until one of the nodes is the leader, and returns its index.
+func waitForLeader(c *C, nodes []*Node, skip int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for i, n := range nodes {
+			if i != skip && n.IsLeader() {
+				return i
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Fatal("no leader elected")
+	return -1
+}
+
+// Waits until a recorder has applied the commands.
+func waitForCommands(c *C, fsm *recorder, commands []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(fsm.applied()) >= len(commands) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Check(fsm.applied(), DeepEquals, commands)
+}
+
+func (s *RaftSuite) TestReplicate(c *C) {
+	_, nodes, fsms := startCluster(c, 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	leader := waitForLeader(c, nodes, -1)
+
+	result, err := nodes[leader].Propose([]byte("one"))
+	c.Assert(err, IsNil)
+	c.Check(string(result), Equals, "applied one")
+
+	// proposed on a follower, and forwarded to the leader
+	follower := (leader + 1) % len(nodes)
+	result, err = nodes[follower].Propose([]byte("two"))
+	c.Assert(err, IsNil)
+	c.Check(string(result), Equals, "applied two")
+
+	for _, fsm := range fsms {
+		waitForCommands(c, fsm, []string{"one", "two"})
+	}
+}
+
+func (s *RaftSuite) TestFailover(c *C) {
+	t, nodes, fsms := startCluster(c, 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	leader := waitForLeader(c, nodes, -1)
+	_, err := nodes[leader].Propose([]byte("before"))
+	c.Assert(err, IsNil)
+
+	t.setConnected(nodes[leader].ID(), false)
+	next := waitForLeader(c, nodes, leader)
+	_, err = nodes[next].Propose([]byte("after"))
+	c.Assert(err, IsNil)
+
+	// the old leader cannot commit without a quorum
+	_, err = nodes[leader].propose([]byte("lost"), false)
+	c.Check(err, NotNil)
+
+	// once reconnected, it discards the entry that was not
+	// committed and catches up with the new leader
+	t.setConnected(nodes[leader].ID(), true)
+	for _, fsm := range fsms {
+		waitForCommands(c, fsm, []string{"before", "after"})
+	}
+}
+
+func (s *RaftSuite) TestStepDown(c *C) {
+	t, nodes, _ := startCluster(c, 3)
+	for _, n := range nodes {
+		defer n.Close()
+	}
+	leader := waitForLeader(c, nodes, -1)
+	for _, n := range nodes {
+		if n != nodes[leader] {
+			t.setConnected(n.ID(), false)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for nodes[leader].IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Check(nodes[leader].IsLeader(), Equals, false)
+	_, err := nodes[leader].propose([]byte("lost"), true)
+	c.Check(err, Equals, ErrNoLeader)
+}
+
+func (s *RaftSuite) TestRestart(c *C) {
+	dir := c.MkDir()
+	opts := testOptions("single", nil)
+	opts.Path = filepath.Join(dir, "raft.db")
+	fsm := &recorder{}
+	n, err := NewNode(opts, fsm)
+	c.Assert(err, IsNil)
+	_, err = n.Propose([]byte("kept"))
+	c.Assert(err, IsNil)
+	c.Assert(n.Close(), IsNil)
+
+	// the log is applied again once the restarted node is the leader
+	fsm = &recorder{}
+	n, err = NewNode(opts, fsm)
+	c.Assert(err, IsNil)
+	defer n.Close()
+	_, err = n.Propose([]byte("new"))
+	c.Assert(err, IsNil)
+	c.Check(fsm.applied(), DeepEquals, []string{"kept", "new"})
+}
+
+func (s *RaftSuite) TestTCPTransport(c *C) {
+	var listeners []net.Listener
+	var ids []string
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, IsNil)
+		defer l.Close()
+		listeners = append(listeners, l)
+		ids = append(ids, l.Addr().String())
+	}
+	var nodes []*Node
+	var fsms []*recorder
+	for i, id := range ids {
+		fsm := &recorder{}
+		n, err := NewNode(testOptions(id, ids), fsm)
+		c.Assert(err, IsNil)
+		defer n.Close()
+		go n.Serve(listeners[i])
+		nodes = append(nodes, n)
+		fsms = append(fsms, fsm)
+	}
+	leader := waitForLeader(c, nodes, -1)
+	result, err := nodes[(leader+1)%3].Propose([]byte("over tcp"))
+	c.Assert(err, IsNil)
+	c.Check(string(result), Equals, "applied over tcp")
+	for _, fsm := range fsms {
+		waitForCommands(c, fsm, []string{"over tcp"})
+	}
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Names of the buckets and keys of the state file.
+var (
+	metaBucket = []byte("meta")
+	logBucket  = []byte("log")
+	termKey    = []byte("term")
+	voteKey    = []byte("vote")
+)
+
+// The persistent state of a node: its current term, the candidate that
+// it voted for in the term, and its log.
+type state struct {
+	db *bolt.DB
+}
+
+func openState(path string) (*state, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, logBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &state{db: db}, nil
+}
+
+func (s *state) close() error {
+	return s.db.Close()
+}
+
+func indexKey(index uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
+
+// Returns the current term and vote.
+func (s *state) load() (term uint64, votedFor string, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if v := b.Get(termKey); v != nil {
+			term = binary.BigEndian.Uint64(v)
+		}
+		votedFor = string(b.Get(voteKey))
+		return nil
+	})
+	return term, votedFor, err
+}
+
+func (s *state) save(term uint64, votedFor string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if err := b.Put(termKey, indexKey(term)); err != nil {
+			return err
+		}
+		return b.Put(voteKey, []byte(votedFor))
+	})
+}
+
+// Returns the entries of the log, in order from index 1.
+func (s *state) entries() ([]Entry, error) {
+	var entries []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(logBucket).ForEach(func(k, v []byte) error {
+			var e Entry
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Writes entries to the log, starting at index.
+func (s *state) append(index uint64, entries []Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logBucket)
+		for i, e := range entries {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+				return err
+			}
+			if err := b.Put(indexKey(index+uint64(i)), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Removes the entries of the log from index onwards.
+func (s *state) truncate(index uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(logBucket).Cursor()
+		for k, _ := c.Seek(indexKey(index)); k != nil; k, _ = c.Seek(indexKey(index)) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,413 @@
+/*
+Package cluster provides queue storage for the STOMP server that is
+replicated across a cluster of nodes with the Raft consensus algorithm,
+so that the contents of queues survive the failure of a minority of
+the nodes.
+
+Each node of the cluster runs alongside a server that uses the node's
+Storage as its queue storage. Every change to a queue is a command that
+is committed to the replicated log by the cluster's leader, and applied
+in the same order on every node, so all nodes hold the same queues.
+Commands proposed on other nodes are forwarded to the leader. If the
+leader fails, the remaining nodes elect a new leader once the election
+timeout has passed, and carry on.
+
+As with the redisstore package, the servers share the contents of their
+queues: a message sent to a queue through one server can be received by
+a client of any of them, and each frame in a queue is delivered to one
+client. Frames that a server has sent to clients but that have not been
+acknowledged are kept with the node's ID, and are returned to the head
+of their queues with the "redelivered:true" header when the node is next
+opened with the same ID.
+
+Nodes answer each other's requests with Node.Serve:
+
+	storage, err := cluster.Open(cluster.Options{
+		ID:    "10.0.0.1:7000",
+		Peers: []string{"10.0.0.2:7000", "10.0.0.3:7000"},
+		Path:  "/var/lib/stomp/raft.db",
+	})
+	...
+	l, err := net.Listen("tcp", ":7000")
+	...
+	go storage.Node().Serve(l)
+	s := &server.Server{QueueStorage: storage}
+*/
+package cluster
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/gob"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Operations of the commands that change queues.
+const (
+	opEnqueue byte = iota + 1
+	opRequeue
+	opDequeue
+	opAck
+	opRecover
+)
+
+// A command that changes queues, which is replicated in the log.
+type command struct {
+	Op       byte
+	Instance string // ID of the node that proposed the command
+	Session  string // identifies the run of the node, for dequeue and recover
+	Queue    string
+	Id       uint64 // identifies a dequeued frame, for requeue and ack
+	Frame    []byte // encoded frame, for enqueue and requeue
+}
+
+// A frame in the replicated state.
+type item struct {
+	id   uint64
+	data []byte
+}
+
+// A frame that has been dequeued and not acknowledged.
+type unackedItem struct {
+	session string
+	queue   string
+	item    item
+}
+
+// The replicated state of the queues, which is the state machine that
+// the cluster replicates.
+type queues struct {
+	instance string // ID of this node
+
+	mutex   sync.Mutex
+	lastId  uint64
+	queues  map[string]*list.List     // of item
+	unacked map[string][]*unackedItem // by node ID
+	pending map[string]bool           // queues changed by other nodes, or by recovery
+	notify  chan struct{}             // signalled when pending changes
+}
+
+// Apply a command to the queues.
+func (q *queues) Apply(b []byte) []byte {
+	var cmd command
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&cmd); err != nil {
+		// every node fails to decode the command in the same way
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var result []byte
+	switch cmd.Op {
+	case opEnqueue:
+		q.lastId++
+		q.queue(cmd.Queue).PushBack(item{id: q.lastId, data: cmd.Frame})
+	case opRequeue:
+		if cmd.Id == 0 || !q.removeUnacked(cmd.Instance, cmd.Id) {
+			q.lastId++
+			cmd.Id = q.lastId
+		}
+		q.queue(cmd.Queue).PushFront(item{id: cmd.Id, data: cmd.Frame})
+	case opDequeue:
+		l := q.queue(cmd.Queue)
+		if e := l.Front(); e != nil {
+			it := l.Remove(e).(item)
+			q.unacked[cmd.Instance] = append(q.unacked[cmd.Instance],
+				&unackedItem{session: cmd.Session, queue: cmd.Queue, item: it})
+			result = make([]byte, 8, 8+len(it.data))
+			binary.BigEndian.PutUint64(result, it.id)
+			result = append(result, it.data...)
+		}
+	case opAck:
+		q.removeUnacked(cmd.Instance, cmd.Id)
+	case opRecover:
+		q.recover(cmd.Instance, cmd.Session)
+	}
+
+	if cmd.Instance != q.instance && (cmd.Op == opEnqueue || cmd.Op == opRequeue) {
+		q.pending[cmd.Queue] = true
+	}
+	if len(q.pending) > 0 {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return result
+}
+
+func (q *queues) queue(name string) *list.List {
+	l, ok := q.queues[name]
+	if !ok {
+		l = list.New()
+		q.queues[name] = l
+	}
+	return l
+}
+
+// Removes a frame that a node has not acknowledged. Reports whether
+// the frame was found.
+func (q *queues) removeUnacked(instance string, id uint64) bool {
+	items := q.unacked[instance]
+	for i, u := range items {
+		if u.item.id == id {
+			q.unacked[instance] = append(items[:i], items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the frames that a node dequeued in earlier sessions to the
+// head of their queues, in their original order.
+func (q *queues) recover(instance, session string) {
+	var kept []*unackedItem
+	items := q.unacked[instance]
+	for i := len(items) - 1; i >= 0; i-- {
+		u := items[i]
+		if u.session == session {
+			kept = append([]*unackedItem{u}, kept...)
+			continue
+		}
+		data := u.item.data
+		if f := new(frame.Frame); f.UnmarshalBinary(data) == nil {
+			f.Header.Set(frame.Redelivered, "true")
+			if b, err := f.MarshalBinary(); err == nil {
+				data = b
+			}
+		}
+		q.queue(u.queue).PushFront(item{id: u.item.id, data: data})
+		q.pending[u.queue] = true
+	}
+	q.unacked[instance] = kept
+}
+
+// Storage is an implementation of the queue storage interface that
+// keeps queues in the replicated state of a cluster.
+//
+// Storage is not safe for concurrent use, which matches the way the
+// server uses queue storage.
+type Storage struct {
+	node     *Node
+	session  string
+	state    *queues
+	inflight map[*frame.Frame]uint64 // ids of frames waiting for acknowledgement
+	changes  chan string
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Open the storage, creating a node of the cluster described by opts.
+// Frames that the node had not acknowledged when it was last closed
+// are recovered once the cluster has a leader.
+func Open(opts Options) (*Storage, error) {
+	s := &Storage{
+		session: strconv.FormatInt(time.Now().UnixNano(), 36),
+		state: &queues{
+			instance: opts.ID,
+			queues:   make(map[string]*list.List),
+			unacked:  make(map[string][]*unackedItem),
+			pending:  make(map[string]bool),
+			notify:   make(chan struct{}, 1),
+		},
+		inflight: make(map[*frame.Frame]uint64),
+		changes:  make(chan string, 64),
+		done:     make(chan struct{}),
+	}
+	var err error
+	if s.node, err = NewNode(opts, s.state); err != nil {
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.recover()
+	go s.sendChanges()
+	return s, nil
+}
+
+// Node returns the storage's node of the cluster.
+func (s *Storage) Node() *Node {
+	return s.node
+}
+
+// Proposes the recovery of frames dequeued in earlier sessions,
+// until it succeeds or the storage is closed.
+func (s *Storage) recover() {
+	defer s.wg.Done()
+	for {
+		_, err := s.propose(command{Op: opRecover})
+		if err == nil {
+			return
+		}
+		s.node.opts.Log.Warningf("cluster: cannot recover unacknowledged frames: %v", err)
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.node.opts.ElectionTimeout):
+		}
+	}
+}
+
+// Passes on the names of queues changed by other nodes.
+func (s *Storage) sendChanges() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.state.notify:
+		}
+		s.state.mutex.Lock()
+		pending := s.state.pending
+		s.state.pending = make(map[string]bool)
+		s.state.mutex.Unlock()
+		for queue := range pending {
+			select {
+			case s.changes <- queue:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Changes returns a channel that receives the name of a queue when
+// another node adds frames to it.
+func (s *Storage) Changes() <-chan string {
+	return s.changes
+}
+
+// Ping checks that the cluster has a leader, without which queues
+// cannot be changed.
+func (s *Storage) Ping() error {
+	if s.node.Leader() == "" {
+		return ErrNoLeader
+	}
+	return nil
+}
+
+// Close the storage and its node.
+func (s *Storage) Close() error {
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+	close(s.done)
+	err := s.node.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Storage) propose(cmd command) ([]byte, error) {
+	cmd.Instance = s.node.ID()
+	cmd.Session = s.session
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
+		return nil, err
+	}
+	return s.node.Propose(buf.Bytes())
+}
+
+// Enqueue adds a frame to the tail of the queue.
+func (s *Storage) Enqueue(queue string, f *frame.Frame) error {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = s.propose(command{Op: opEnqueue, Queue: queue, Frame: data})
+	return err
+}
+
+// Requeue adds a frame to the head of the queue. If the frame was
+// dequeued from this storage, it is no longer waiting for acknowledgement.
+func (s *Storage) Requeue(queue string, f *frame.Frame) error {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = s.propose(command{Op: opRequeue, Queue: queue, Id: s.inflight[f], Frame: data})
+	if err == nil {
+		delete(s.inflight, f)
+	}
+	return err
+}
+
+// Dequeue removes the frame at the head of the queue. The frame is kept
+// until it is acknowledged, so that it can be recovered if the node
+// stops first. Returns nil if the queue is empty.
+func (s *Storage) Dequeue(queue string) (*frame.Frame, error) {
+	result, err := s.propose(command{Op: opDequeue, Queue: queue})
+	if err != nil || len(result) < 8 {
+		return nil, err
+	}
+	f := new(frame.Frame)
+	if err := f.UnmarshalBinary(result[8:]); err != nil {
+		return nil, err
+	}
+	s.inflight[f] = binary.BigEndian.Uint64(result)
+	return f, nil
+}
+
+// Ack removes a frame dequeued from the queue once it has been
+// acknowledged. Frames not dequeued from this storage are ignored.
+func (s *Storage) Ack(queue string, f *frame.Frame) error {
+	id, ok := s.inflight[f]
+	if !ok {
+		return nil
+	}
+	if _, err := s.propose(command{Op: opAck, Queue: queue, Id: id}); err != nil {
+		return err
+	}
+	delete(s.inflight, f)
+	return nil
+}
+
+// Len returns the number of frames in the queue, not including frames
+// that are waiting for acknowledgement, as applied on this node.
+func (s *Storage) Len(queue string) int {
+	s.state.mutex.Lock()
+	defer s.state.mutex.Unlock()
+	if l, ok := s.state.queues[queue]; ok {
+		return l.Len()
+	}
+	return 0
+}
+
+// Iterate calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false. The frames are those
+// applied on this node when Iterate is called.
+func (s *Storage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	var items [][]byte
+	s.state.mutex.Lock()
+	if l, ok := s.state.queues[queue]; ok {
+		for e := l.Front(); e != nil; e = e.Next() {
+			items = append(items, e.Value.(item).data)
+		}
+	}
+	s.state.mutex.Unlock()
+
+	for _, data := range items {
+		f := new(frame.Frame)
+		if err := f.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		if !fn(f) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Start has no effect, as the storage is ready to use once opened.
+func (s *Storage) Start() {
+}
+
+// Stop closes the storage.
+func (s *Storage) Stop() {
+	_ = s.Close()
+}
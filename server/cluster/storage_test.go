@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/queue/queuetest"
+	. "gopkg.in/check.v1"
+)
+
+type StorageSuite struct{}
+
+var _ = Suite(&StorageSuite{})
+
+var (
+	_ queue.SharedStorage = (*Storage)(nil)
+	_ queue.PingStorage   = (*Storage)(nil)
+)
+
+// Opens the storage of a node of a cluster connected by a memTransport.
+func openStorage(c *C, t *memTransport, id string, ids []string, path string) *Storage {
+	opts := testOptions(id, ids)
+	opts.Transport = t.from(id)
+	opts.Path = path
+	s, err := Open(opts)
+	c.Assert(err, IsNil)
+	t.mutex.Lock()
+	t.nodes[id] = s.Node()
+	t.mutex.Unlock()
+	return s
+}
+
+func dequeue(c *C, s *Storage) *frame.Frame {
+	f, err := s.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	return f
+}
+
+func (s *StorageSuite) TestShared(c *C) {
+	t := newMemTransport()
+	ids := []string{"a", "b", "c"}
+	var storages []*Storage
+	for _, id := range ids {
+		st := openStorage(c, t, id, ids, "")
+		defer st.Close()
+		storages = append(storages, st)
+	}
+	a, b := storages[0], storages[1]
+
+	for i := 1; i <= 3; i++ {
+		c.Assert(a.Enqueue("/queue/test", queuetest.NewFrame(fmt.Sprint(i))), IsNil)
+	}
+	select {
+	case queue := <-b.Changes():
+		c.Check(queue, Equals, "/queue/test")
+	case <-time.After(5 * time.Second):
+		c.Fatal("change not received")
+	}
+
+	// each frame is dequeued once, whichever node dequeues it
+	c.Check(string(dequeue(c, b).Body), Equals, "1")
+	f := dequeue(c, a)
+	c.Check(string(f.Body), Equals, "2")
+	c.Assert(a.Requeue("/queue/test", f), IsNil)
+	c.Check(string(dequeue(c, b).Body), Equals, "2")
+
+	// each node applies the commands in turn
+	c3 := storages[2]
+	deadline := time.Now().Add(5 * time.Second)
+	for c3.Len("/queue/test") != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var bodies []string
+	err := c3.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return true
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"3"})
+}
+
+func (s *StorageSuite) TestRecover(c *C) {
+	dir := c.MkDir()
+	t := newMemTransport()
+	ids := []string{"a", "b", "c"}
+	var storages []*Storage
+	for _, id := range ids {
+		storages = append(storages, openStorage(c, t, id, ids, filepath.Join(dir, id+".db")))
+	}
+	defer storages[1].Close()
+	defer storages[2].Close()
+	a := storages[0]
+
+	for i := 1; i <= 3; i++ {
+		c.Assert(a.Enqueue("/queue/test", queuetest.NewFrame(fmt.Sprint(i))), IsNil)
+	}
+	f := dequeue(c, a)
+	c.Assert(a.Ack("/queue/test", f), IsNil)
+	dequeue(c, a)
+	dequeue(c, a)
+	c.Assert(a.Close(), IsNil)
+
+	// frames not acknowledged before the node stopped are returned
+	// to the head of the queue once it is opened again
+	a = openStorage(c, t, "a", ids, filepath.Join(dir, "a.db"))
+	defer a.Close()
+	select {
+	case queue := <-a.Changes():
+		c.Check(queue, Equals, "/queue/test")
+	case <-time.After(5 * time.Second):
+		c.Fatal("frames not recovered")
+	}
+	for _, body := range []string{"2", "3"} {
+		f := dequeue(c, storages[1])
+		c.Check(string(f.Body), Equals, body)
+		c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+	}
+}
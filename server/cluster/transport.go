@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Default timeout for requests sent by a TCPTransport.
+const DefaultRequestTimeout = time.Second
+
+// A VoteRequest is sent by a candidate to ask for a node's vote.
+type VoteRequest struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// A VoteResponse answers a VoteRequest.
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// An AppendRequest is sent by the leader to replicate entries, and as
+// a heartbeat.
+type AppendRequest struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// An AppendResponse answers an AppendRequest. If the request fails
+// because the node's log does not match the leader's, ConflictIndex
+// is the index from which the leader should send entries next.
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// Transport sends requests to other nodes, which answer them by calling
+// the HandleRequestVote, HandleAppendEntries and HandleForward methods
+// of their Node.
+type Transport interface {
+	RequestVote(peer string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error)
+
+	// Forward a command to the leader. Returns ErrNoLeader if the peer
+	// is not the leader, and ErrUnreachable if the command could not be
+	// sent to the peer.
+	Forward(peer string, command []byte) ([]byte, error)
+}
+
+// TCPTransport is a transport that sends requests to other nodes with
+// net/rpc over TCP, using node IDs as addresses. Nodes answer requests
+// with Node.Serve.
+type TCPTransport struct {
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+// NewTCPTransport creates a transport whose requests time out after
+// timeout, or DefaultRequestTimeout if timeout is zero.
+func NewTCPTransport(timeout time.Duration) *TCPTransport {
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return &TCPTransport{timeout: timeout, clients: make(map[string]*rpc.Client)}
+}
+
+func (t *TCPTransport) client(peer string) (*rpc.Client, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if c, ok := t.clients[peer]; ok {
+		return c, nil
+	}
+	conn, err := net.DialTimeout("tcp", peer, t.timeout)
+	if err != nil {
+		return nil, ErrUnreachable
+	}
+	c := rpc.NewClient(conn)
+	t.clients[peer] = c
+	return c, nil
+}
+
+// Calls a method of a peer, with a timeout. Returns ErrUnreachable if
+// the request could not be sent.
+func (t *TCPTransport) call(peer, method string, args, reply interface{}, timeout time.Duration) error {
+	c, err := t.client(peer)
+	if err != nil {
+		return err
+	}
+	call := c.Go("Node."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(timeout):
+		err = errors.New("cluster: request to " + peer + " timed out")
+	}
+	if err == rpc.ErrShutdown {
+		err = ErrUnreachable
+	}
+	if _, ok := err.(rpc.ServerError); !ok && err != nil {
+		// connect again for the next request
+		t.mutex.Lock()
+		if t.clients[peer] == c {
+			delete(t.clients, peer)
+			c.Close()
+		}
+		t.mutex.Unlock()
+	}
+	return err
+}
+
+// RequestVote sends a VoteRequest to a peer.
+func (t *TCPTransport) RequestVote(peer string, req *VoteRequest) (*VoteResponse, error) {
+	resp := &VoteResponse{}
+	return resp, t.call(peer, "RequestVote", req, resp, t.timeout)
+}
+
+// AppendEntries sends an AppendRequest to a peer.
+func (t *TCPTransport) AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error) {
+	resp := &AppendResponse{}
+	return resp, t.call(peer, "AppendEntries", req, resp, t.timeout)
+}
+
+// Forward sends a command to a peer, and waits for it to be committed.
+func (t *TCPTransport) Forward(peer string, command []byte) ([]byte, error) {
+	var result []byte
+	// the peer waits for the command to be committed
+	err := t.call(peer, "Forward", command, &result, t.timeout+DefaultProposeTimeout)
+	if err, ok := err.(rpc.ServerError); ok {
+		for _, e := range []error{ErrNoLeader, ErrLeadershipLost, ErrTimeout, ErrClosed} {
+			if string(err) == e.Error() {
+				return nil, e
+			}
+		}
+	}
+	return result, err
+}
+
+// Serve answers the requests that other nodes send with a TCPTransport
+// on the listener l, until l is closed.
+func (n *Node) Serve(l net.Listener) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", &rpcService{node: n}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// Exports the methods of a node for net/rpc.
+type rpcService struct {
+	node *Node
+}
+
+func (s *rpcService) RequestVote(req *VoteRequest, resp *VoteResponse) error {
+	*resp = *s.node.HandleRequestVote(req)
+	return nil
+}
+
+func (s *rpcService) AppendEntries(req *AppendRequest, resp *AppendResponse) error {
+	*resp = *s.node.HandleAppendEntries(req)
+	return nil
+}
+
+func (s *rpcService) Forward(command []byte, result *[]byte) error {
+	var err error
+	*result, err = s.node.HandleForward(command)
+	return err
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// CompactQueueStorage discards queued messages that exceed the
+// retention settings of the queue storage, and reclaims the disk
+// space used by messages that have been removed. Returns the number
+// of messages discarded and the number of bytes reclaimed. Has no
+// effect if the queue storage does not implement queue.CompactStorage.
+func (s *Server) CompactQueueStorage() (queue.CompactStats, error) {
+	var stats queue.CompactStats
+	err := s.call(func(proc *requestProcessor) error {
+		var err error
+		stats, err = proc.compact()
+		return err
+	})
+	return stats, err
+}
+
+func (proc *requestProcessor) compact() (queue.CompactStats, error) {
+	c, ok := proc.qstore.(queue.CompactStorage)
+	if !ok {
+		return queue.CompactStats{}, nil
+	}
+	stats, err := c.Compact()
+	if err != nil {
+		proc.log.Errorf("compacting queue storage failed: %v", err)
+	} else if stats != (queue.CompactStats{}) {
+		proc.log.Infof("compacted queue storage: discarded %d messages, reclaimed %d bytes",
+			stats.Discarded, stats.ReclaimedBytes)
+	}
+	return stats, err
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Default period that a destination can have fewer consumers than
+// DestinationPolicy.MinConsumers before an advisory is sent.
+const DefaultConsumerGracePeriod = time.Minute
+
+// subscriptionCounts keeps track of the subscriptions to each destination.
+type subscriptionCounts map[string]map[*client.Subscription]struct{}
+
+// Add a subscription, and report whether it was added. Adding a
+// subscription more than once has no effect.
+func (sc subscriptionCounts) Add(sub *client.Subscription) bool {
+	subs, ok := sc[sub.Destination()]
+	if !ok {
+		subs = make(map[*client.Subscription]struct{})
+		sc[sub.Destination()] = subs
+	}
+	if _, ok := subs[sub]; ok {
+		return false
+	}
+	subs[sub] = struct{}{}
+	return true
+}
+
+// Remove a subscription, and report whether it was removed.
+func (sc subscriptionCounts) Remove(sub *client.Subscription) bool {
+	subs := sc[sub.Destination()]
+	if _, ok := subs[sub]; !ok {
+		return false
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(sc, sub.Destination())
+	}
+	return true
+}
+
+// Count returns the number of subscriptions to a destination.
+func (sc subscriptionCounts) Count(destination string) int {
+	return len(sc[destination])
+}
+
+// A ConsumerShortage describes a destination that has had fewer
+// consumers than required by its destination policy for longer than
+// the grace period, while messages were waiting to be delivered.
+type ConsumerShortage struct {
+	Destination  string    // Destination with too few consumers
+	Consumers    int       // Current number of subscriptions
+	MinConsumers int       // Number of subscriptions required by the policy
+	Since        time.Time // Time the destination fell below MinConsumers
+	Waiting      int       // Messages waiting in a queue, or sent to a topic since
+}
+
+// consumerMonitor keeps track of destinations whose policy requires
+// a minimum number of consumers.
+type consumerMonitor struct {
+	policies []DestinationPolicy
+	states   map[string]*consumerState
+}
+
+type consumerState struct {
+	policy   *DestinationPolicy
+	below    time.Time // when consumers fell below the minimum, zero if not below
+	orphaned int       // topic messages sent while below the minimum
+	alerted  bool      // has a shortage advisory been sent
+}
+
+func newConsumerMonitor(policies []DestinationPolicy) *consumerMonitor {
+	return &consumerMonitor{
+		policies: policies,
+		states:   make(map[string]*consumerState),
+	}
+}
+
+// SetPolicies replaces the policies, and applies them to the
+// destinations being monitored.
+func (m *consumerMonitor) SetPolicies(policies []DestinationPolicy) {
+	m.policies = policies
+	for destination, st := range m.states {
+		policy := findPolicy(policies, destination)
+		if policy == nil || policy.MinConsumers <= 0 {
+			delete(m.states, destination)
+			continue
+		}
+		st.policy = policy
+	}
+}
+
+// Update records the number of consumers of a destination. Returns
+// nil if the destination does not require a minimum number of consumers.
+func (m *consumerMonitor) Update(destination string, consumers int, now time.Time) *consumerState {
+	st, ok := m.states[destination]
+	if !ok {
+		policy := findPolicy(m.policies, destination)
+		if policy == nil || policy.MinConsumers <= 0 {
+			return nil
+		}
+		st = &consumerState{policy: policy}
+		m.states[destination] = st
+	}
+	if consumers < st.policy.MinConsumers && st.below.IsZero() {
+		st.below = now
+	}
+	return st
+}
+
+// Remove stops monitoring a destination.
+func (m *consumerMonitor) Remove(destination string) {
+	delete(m.states, destination)
+}
+
+// Returns the interval at which consumer shortages need to be checked,
+// or zero if no policy requires a minimum number of consumers.
+func (m *consumerMonitor) interval() time.Duration {
+	var interval time.Duration
+	for _, policy := range m.policies {
+		if policy.MinConsumers > 0 {
+			if d := policy.gracePeriod() / 2; interval == 0 || d < interval {
+				interval = d
+			}
+		}
+	}
+	return interval
+}
+
+func (p *DestinationPolicy) gracePeriod() time.Duration {
+	if p.ConsumerGracePeriod <= 0 {
+		return DefaultConsumerGracePeriod
+	}
+	return p.ConsumerGracePeriod
+}
+
+// Records a message sent to a topic, for topics with too few consumers.
+func (proc *requestProcessor) countOrphaned(destination string) {
+	st := proc.consumers.states[destination]
+	if st != nil && proc.subs.Count(destination) < st.policy.MinConsumers {
+		st.orphaned++
+	}
+}
+
+// Returns the number of messages waiting for consumers of a destination.
+func (proc *requestProcessor) waiting(destination string, st *consumerState) int {
+	if isQueueDestination(destination) {
+		return proc.qm.Find(destination).Len()
+	}
+	return st.orphaned
+}
+
+// Sends advisories for destinations that have had too few consumers
+// for longer than the grace period while messages were waiting, and
+// for destinations whose consumers have been restored.
+func (proc *requestProcessor) checkConsumers(now time.Time) {
+	for destination, st := range proc.consumers.states {
+		count := proc.subs.Count(destination)
+		if count >= st.policy.MinConsumers {
+			if st.alerted {
+				proc.advise(ConsumersRestoredAdvisory, destination,
+					ConsumerCountHeader, strconv.Itoa(count))
+			}
+			st.below = time.Time{}
+			st.orphaned = 0
+			st.alerted = false
+			continue
+		}
+
+		if st.below.IsZero() {
+			st.below = now
+		}
+		if st.alerted || now.Sub(st.below) < st.policy.gracePeriod() {
+			continue
+		}
+		if proc.waiting(destination, st) > 0 {
+			proc.log.Warningf("stomp: %s has %d consumers, %d required",
+				destination, count, st.policy.MinConsumers)
+			proc.advise(ConsumerShortageAdvisory, destination,
+				ConsumerCountHeader, strconv.Itoa(count))
+			st.alerted = true
+		}
+	}
+}
+
+// Returns the destinations that currently have a consumer shortage.
+func (proc *requestProcessor) consumerShortages() []ConsumerShortage {
+	var shortages []ConsumerShortage
+	for destination, st := range proc.consumers.states {
+		if !st.alerted {
+			continue
+		}
+		shortages = append(shortages, ConsumerShortage{
+			Destination:  destination,
+			Consumers:    proc.subs.Count(destination),
+			MinConsumers: st.policy.MinConsumers,
+			Since:        st.below,
+			Waiting:      proc.waiting(destination, st),
+		})
+	}
+	return shortages
+}
+
+// ConsumerShortages returns the destinations that have had fewer
+// consumers than required by their destination policy for longer than
+// the grace period, while messages were waiting to be delivered.
+func (s *Server) ConsumerShortages() ([]ConsumerShortage, error) {
+	var shortages []ConsumerShortage
+	err := s.call(func(proc *requestProcessor) error {
+		shortages = proc.consumerShortages()
+		return nil
+	})
+	return shortages, err
+}
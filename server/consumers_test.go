@@ -0,0 +1,45 @@
+package server
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConsumersSuite struct{}
+
+var _ = Suite(&ConsumersSuite{})
+
+func (s *ConsumersSuite) TestMonitorUpdate(c *C) {
+	m := newConsumerMonitor([]DestinationPolicy{
+		{Pattern: "/queue/a.>", MinConsumers: 2},
+		{Pattern: "/queue/b", MirrorQueue: "/queue/c"},
+	})
+	now := time.Now()
+
+	c.Check(m.Update("/queue/b", 0, now), IsNil)
+	c.Check(m.Update("/queue/x", 0, now), IsNil)
+
+	st := m.Update("/queue/a.1", 2, now)
+	c.Assert(st, NotNil)
+	c.Check(st.below.IsZero(), Equals, true)
+
+	st = m.Update("/queue/a.1", 1, now)
+	c.Check(st.below, Equals, now)
+	st = m.Update("/queue/a.1", 0, now.Add(time.Second))
+	c.Check(st.below, Equals, now)
+
+	m.Remove("/queue/a.1")
+	c.Check(m.states, HasLen, 0)
+}
+
+func (s *ConsumersSuite) TestMonitorInterval(c *C) {
+	c.Check(newConsumerMonitor(nil).interval(), Equals, time.Duration(0))
+
+	m := newConsumerMonitor([]DestinationPolicy{
+		{Pattern: "/queue/a", MinConsumers: 1},
+		{Pattern: "/queue/b", MinConsumers: 1, ConsumerGracePeriod: 10 * time.Second},
+		{Pattern: "/queue/c", ConsumerGracePeriod: time.Second},
+	})
+	c.Check(m.interval(), Equals, 5*time.Second)
+}
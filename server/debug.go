@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// DebugHandler returns an HTTP handler that serves runtime profiles
+// and variables for diagnosing the server in production, such as
+// leaked connection go-routines or the memory used by queues:
+//
+//	GET /debug/pprof/   profiles, as served by package net/http/pprof
+//	GET /debug/vars     variables published with package expvar, and
+//	                    the variable "stomp" describing the server
+//
+// The handler serves absolute paths, so is mounted at "/debug/" of a
+// mux rather than below a prefix. Requests are authenticated as by
+// AdminHandler. Profiles reveal the program's internals, so the
+// handler should only be served to operators.
+func (s *Server) DebugHandler(auth Authenticator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", s.serveVars)
+	return adminAuth(auth, mux)
+}
+
+// The variables of the server, which are not published with package
+// expvar so that a process can run several servers.
+type debugVars struct {
+	Goroutines  int            `json:"goroutines"`
+	Connections int            `json:"connections"`
+	QueueDepths map[string]int `json:"queue_depths"`
+	Serving     bool           `json:"serving"`
+}
+
+// Serves the variables published with package expvar, with the
+// variables of the server, in the format of expvar.Handler.
+func (s *Server) serveVars(w http.ResponseWriter, r *http.Request) {
+	vars := debugVars{
+		Goroutines:  runtime.NumGoroutine(),
+		QueueDepths: make(map[string]int),
+	}
+	err := s.call(func(proc *requestProcessor) error {
+		vars.Connections = len(proc.clients)
+		for _, destination := range proc.qm.Destinations() {
+			vars.QueueDepths[destination] = proc.qm.Find(destination).Len()
+		}
+		return nil
+	})
+	vars.Serving = err == nil
+	serverVars, _ := json.Marshal(vars)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	if !first {
+		fmt.Fprintf(w, ",\n")
+	}
+	fmt.Fprintf(w, "%q: %s", "stomp", serverVars)
+	fmt.Fprintf(w, "\n}\n")
+}
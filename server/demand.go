@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Called when a subscription has been added. Subscriptions made by
+// clients of this server change the demand for their destination,
+// and subscribers to DemandAdvisory are sent the demand for
+// every destination.
+func (proc *requestProcessor) subscribed(sub *client.Subscription) {
+	if sub.Destination() == DemandAdvisory {
+		for destination, count := range proc.demand {
+			sub.SendTopicFrame(consumerCountFrame(destination, count))
+		}
+	}
+	proc.changeDemand(sub, 1)
+}
+
+// Called when a subscription has been removed.
+func (proc *requestProcessor) unsubscribed(sub *client.Subscription) {
+	proc.changeDemand(sub, -1)
+}
+
+func (proc *requestProcessor) changeDemand(sub *client.Subscription, delta int) {
+	destination := sub.Destination()
+	if sub.ForwardedBy() != "" || strings.HasPrefix(destination, AdvisoryTopicPrefix) {
+		return
+	}
+	count := proc.demand[destination] + delta
+	if count > 0 {
+		proc.demand[destination] = count
+	} else {
+		delete(proc.demand, destination)
+	}
+	proc.advise(DemandAdvisory, destination, ConsumerCountHeader, strconv.Itoa(count))
+}
+
+func consumerCountFrame(destination string, count int) *frame.Frame {
+	return frame.New(frame.MESSAGE,
+		frame.Destination, DemandAdvisory,
+		AdvisoryDestinationHeader, destination,
+		ConsumerCountHeader, strconv.Itoa(count))
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/client"
+	. "gopkg.in/check.v1"
+)
+
+type DemandSuite struct{}
+
+var _ = Suite(&DemandSuite{})
+
+func checkConsumerCount(c *C, sub *stomp.Subscription, destination, count string) {
+	msg := receive(c, sub)
+	c.Check(msg.Header.Get(AdvisoryDestinationHeader), Equals, destination)
+	c.Check(msg.Header.Get(ConsumerCountHeader), Equals, count)
+}
+
+func (s *DemandSuite) TestDemandAdvisory(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	consumer, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer consumer.Disconnect()
+	_, err = consumer.Subscribe("/queue/a", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	topic, err := consumer.Subscribe("/topic/b", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// a new subscriber is sent the current counts, in any order
+	watcher, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer watcher.Disconnect()
+	advisory, err := watcher.Subscribe(DemandAdvisory, stomp.AckAuto)
+	c.Assert(err, IsNil)
+	counts := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		msg := receive(c, advisory)
+		counts[msg.Header.Get(AdvisoryDestinationHeader)] = msg.Header.Get(ConsumerCountHeader)
+	}
+	c.Check(counts, DeepEquals, map[string]string{"/queue/a": "1", "/topic/b": "1"})
+
+	// subscriptions forwarded by other brokers are not counted
+	_, err = consumer.Subscribe("/queue/a", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(client.ForwardedHeader, "other"))
+	c.Assert(err, IsNil)
+	_, err = consumer.Subscribe("/queue/a", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	checkConsumerCount(c, advisory, "/queue/a", "2")
+	c.Assert(topic.Unsubscribe(), IsNil)
+	checkConsumerCount(c, advisory, "/topic/b", "0")
+}
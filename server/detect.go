@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server/sniff"
+)
+
+// ServeDetect accepts STOMP, MQTT and HTTP connections on the same
+// listener l, detecting the protocol of each connection from the first
+// bytes sent by the client. STOMP connections are served as by Serve,
+// MQTT connections as by ServeMQTT, and HTTP connections by the handler
+// returned by WebSocketHandler, so that browsers can use STOMP over
+// WebSocket. See package sniff for the details of the detection.
+//
+// ServeDetect returns when the server stops serving STOMP connections,
+// closing l.
+func (s *Server) ServeDetect(l net.Listener) error {
+	if s.Log == nil {
+		s.Log = log.StdLogger{}
+	}
+	defer l.Close()
+	mux := sniff.NewMux(l)
+	stompListener := mux.Listener(sniff.STOMP)
+	mqttListener := mux.Listener(sniff.MQTT)
+	httpListener := mux.Listener(sniff.HTTP)
+	go mux.Serve()
+	go s.ServeMQTT(mqttListener)
+	go (&http.Server{Handler: s.WebSocketHandler()}).Serve(httpListener)
+	return s.Serve(stompListener)
+}
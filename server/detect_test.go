@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type DetectSuite struct{}
+
+var _ = Suite(&DetectSuite{})
+
+func (s *DetectSuite) TestServeDetect(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{Authenticator: testAuthenticator{}}
+	go serv.ServeDetect(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	addr := l.Addr().String()
+
+	client, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Login("user", "secret"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/topic/greetings", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	device := dialMQTT(c, addr)
+	defer device.conn.Close()
+	c.Assert(device.connect("user", "secret"), Equals, byte(0))
+
+	// STOMP over WebSocket, with a frame that fits in a short
+	// masked WebSocket frame
+	ws, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = ws.Write([]byte("GET /stomp HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Protocol: v12.stomp\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	c.Assert(err, IsNil)
+	r := bufio.NewReader(ws)
+	response, err := http.ReadResponse(r, nil)
+	c.Assert(err, IsNil)
+	c.Assert(response.StatusCode, Equals, http.StatusSwitchingProtocols)
+	c.Check(response.Header.Get("Sec-WebSocket-Protocol"), Equals, "v12.stomp")
+
+	frames := "CONNECT\naccept-version:1.2\nlogin:user\npasscode:secret\n\n\x00" +
+		"SEND\ndestination:/topic/greetings\n\nhello\x00"
+	mask := []byte{7, 7, 7, 7}
+	b := append([]byte{0x81, 0x80 | byte(len(frames))}, mask...)
+	for i := range frames {
+		b = append(b, frames[i]^mask[i%4])
+	}
+	_, err = ws.Write(b)
+	c.Assert(err, IsNil)
+	line, err := r.ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Check(line[2:], Equals, "CONNECTED\n")
+
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, "hello")
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not received")
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// Default interval at which queue storage is written to disk for
+// destinations with DurabilityInterval.
+const DefaultSyncInterval = time.Second
+
+// Durability determines when messages stored in a queue are written
+// to disk, and so when the server sends the RECEIPT for a SEND frame.
+// It only has an effect if the queue storage implements
+// queue.SyncStorage. Durability levels are in order, from the least
+// to the most durable.
+type Durability int
+
+// Durability levels.
+const (
+	// Messages are written to disk as determined by the queue storage.
+	// The RECEIPT is sent once the message has been stored.
+	DurabilityDefault Durability = iota
+
+	// Messages are left for the operating system to write to disk.
+	// The RECEIPT is sent once the message has been stored, and the
+	// message can be lost if the operating system crashes.
+	DurabilityBuffered
+
+	// Messages are written to disk every Server.SyncInterval, and the
+	// RECEIPT is sent once the message has been written to disk.
+	DurabilityInterval
+
+	// Each message is written to disk before its RECEIPT is sent.
+	// This is the safest and slowest level.
+	DurabilityFsync
+)
+
+// Keeps track of the changes to queue storage that are not yet
+// durable, and of the receipts that are waiting for them.
+type durabilityTracker struct {
+	storage  queue.SyncStorage // nil if storage cannot be synced
+	level    Durability        // for messages stored by the current request or transaction
+	depth    int               // depth of nested transactions being committed
+	dirty    bool              // changes waiting for the next interval
+	receipts []pendingReceipt  // receipts waiting for the next interval
+	commits  []pendingReceipt  // receipts waiting for the outermost transaction
+}
+
+// A receipt to send to a client once a request is durable.
+type pendingReceipt struct {
+	conn    *client.Conn
+	receipt string
+}
+
+// Returns the durability level of a destination.
+func (proc *requestProcessor) durabilityOf(destination string) Durability {
+	if policy := findPolicy(proc.server.Policies, destination); policy != nil {
+		return policy.Durability
+	}
+	return DurabilityDefault
+}
+
+// Records that a message has been stored in a queue.
+func (proc *requestProcessor) stored(destination string) {
+	if d := proc.durabilityOf(destination); d > proc.durability.level {
+		proc.durability.level = d
+	}
+}
+
+// Called once a request has been processed, to make the messages that
+// it stored durable and to send its receipt. Changes made while a
+// transaction is being committed are made durable once the outermost
+// transaction being committed has been written.
+func (proc *requestProcessor) processed(r client.Request) {
+	dt := &proc.durability
+	if r.Receipt != "" {
+		if dt.depth > 0 || r.Op == client.CommitEndOp {
+			dt.commits = append(dt.commits, pendingReceipt{r.Conn, r.Receipt})
+		} else {
+			dt.receipts = append(dt.receipts, pendingReceipt{r.Conn, r.Receipt})
+		}
+	}
+	if dt.depth > 0 {
+		return
+	}
+	if len(dt.commits) > 0 {
+		dt.receipts = append(dt.receipts, dt.commits...)
+		dt.commits = nil
+	}
+
+	level := dt.level
+	dt.level = DurabilityDefault
+	if dt.storage == nil {
+		level = DurabilityDefault
+	}
+	switch level {
+	case DurabilityFsync:
+		proc.sync()
+	case DurabilityInterval:
+		// the receipts are sent after the next sync
+		dt.dirty = true
+	default:
+		if !dt.dirty {
+			proc.sendReceipts()
+		}
+	}
+}
+
+// Writes the queue storage to disk, if it has changed since it was
+// last written, and then sends the receipts that were waiting for it.
+func (proc *requestProcessor) sync() {
+	dt := &proc.durability
+	if dt.storage != nil {
+		if err := dt.storage.Sync(); err != nil {
+			proc.log.Errorf("writing queue storage to disk failed: %v", err)
+		}
+	}
+	dt.dirty = false
+	proc.sendReceipts()
+}
+
+func (proc *requestProcessor) sendReceipts() {
+	for _, p := range proc.durability.receipts {
+		p.conn.SendReceipt(p.receipt)
+	}
+	proc.durability.receipts = nil
+}
+
+// Returns the interval at which the queue storage is written to disk,
+// or zero if no destination has DurabilityInterval.
+func (proc *requestProcessor) syncInterval() time.Duration {
+	if proc.durability.storage == nil {
+		return 0
+	}
+	for _, policy := range proc.server.Policies {
+		if policy.Durability == DurabilityInterval {
+			if proc.server.SyncInterval > 0 {
+				return proc.server.SyncInterval
+			}
+			return DefaultSyncInterval
+		}
+	}
+	return 0
+}
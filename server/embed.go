@@ -0,0 +1,399 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/sniff"
+)
+
+// ErrStarted is returned by Start if the server has already been
+// started.
+var ErrStarted = errors.New("server already started")
+
+// Protocol identifies the protocol of the clients of a listener added
+// with Opt.Listen or Opt.Listener.
+type Protocol string
+
+// Protocols of listeners.
+const (
+	ProtocolSTOMP     Protocol = "stomp"     // served as by Serve
+	ProtocolMQTT      Protocol = "mqtt"      // served as by ServeMQTT
+	ProtocolWebSocket Protocol = "websocket" // served by WebSocketHandler
+	ProtocolDetect    Protocol = "detect"    // served as by ServeDetect
+)
+
+// A listener of a server started by Start, either opened by Start
+// or opened by the application.
+type serverListener struct {
+	protocol Protocol
+	addr     string
+	l        net.Listener
+}
+
+// State of a server started by Start.
+type startState struct {
+	listeners []net.Listener // every listener, in the order of the options
+	servers   []*http.Server
+	done      chan struct{} // closed when the server has stopped serving
+	err       error         // returned by Serve
+}
+
+// Options for creating a server with New. Options can also be applied
+// to a server that has not been started by calling them.
+var Opt struct {
+	// Listen is an option that adds a listener for clients of the
+	// protocol on the TCP network address addr, which Start opens.
+	Listen func(protocol Protocol, addr string) func(*Server) error
+
+	// Listener is an option that adds a listener for clients of the
+	// protocol opened by the application, for example a TLS listener.
+	// Start serves l, and Shutdown closes it.
+	Listener func(protocol Protocol, l net.Listener) func(*Server) error
+
+	// Authenticator is an option that sets Server.Authenticator.
+	Authenticator func(auth Authenticator) func(*Server) error
+
+	// QueueStorage is an option that sets Server.QueueStorage.
+	QueueStorage func(storage QueueStorage) func(*Server) error
+
+	// Logger is an option that sets Server.Log.
+	Logger func(log stomp.Logger) func(*Server) error
+
+	// LogLevels is an option that sets Server.LogLevels.
+	LogLevels func(levels map[string]stomp.Level) func(*Server) error
+
+	// Policies is an option that appends to Server.Policies, and fails
+	// if one of the policies is invalid.
+	Policies func(policies ...DestinationPolicy) func(*Server) error
+
+	// HeartBeat is an option that sets Server.HeartBeat.
+	HeartBeat func(heartBeat time.Duration) func(*Server) error
+
+	// DisabledFeatures is an option that sets Server.DisabledFeatures.
+	DisabledFeatures func(features Feature) func(*Server) error
+}
+
+func init() {
+	Opt.Listen = func(protocol Protocol, addr string) func(*Server) error {
+		return func(s *Server) error {
+			if err := checkProtocol(protocol); err != nil {
+				return err
+			}
+			s.listeners = append(s.listeners, serverListener{protocol: protocol, addr: addr})
+			return nil
+		}
+	}
+	Opt.Listener = func(protocol Protocol, l net.Listener) func(*Server) error {
+		return func(s *Server) error {
+			if err := checkProtocol(protocol); err != nil {
+				return err
+			}
+			s.listeners = append(s.listeners, serverListener{protocol: protocol, l: l})
+			return nil
+		}
+	}
+	Opt.Authenticator = func(auth Authenticator) func(*Server) error {
+		return func(s *Server) error {
+			s.Authenticator = auth
+			return nil
+		}
+	}
+	Opt.QueueStorage = func(storage QueueStorage) func(*Server) error {
+		return func(s *Server) error {
+			s.QueueStorage = storage
+			return nil
+		}
+	}
+	Opt.Logger = func(log stomp.Logger) func(*Server) error {
+		return func(s *Server) error {
+			s.Log = log
+			return nil
+		}
+	}
+	Opt.LogLevels = func(levels map[string]stomp.Level) func(*Server) error {
+		return func(s *Server) error {
+			s.LogLevels = levels
+			return nil
+		}
+	}
+	Opt.Policies = func(policies ...DestinationPolicy) func(*Server) error {
+		return func(s *Server) error {
+			if err := validatePolicies(policies); err != nil {
+				return err
+			}
+			s.Policies = append(s.Policies, policies...)
+			return nil
+		}
+	}
+	Opt.HeartBeat = func(heartBeat time.Duration) func(*Server) error {
+		return func(s *Server) error {
+			if heartBeat < 0 {
+				return fmt.Errorf("invalid heart-beat %v", heartBeat)
+			}
+			s.HeartBeat = heartBeat
+			return nil
+		}
+	}
+	Opt.DisabledFeatures = func(features Feature) func(*Server) error {
+		return func(s *Server) error {
+			s.DisabledFeatures = features
+			return nil
+		}
+	}
+}
+
+func checkProtocol(protocol Protocol) error {
+	switch protocol {
+	case ProtocolSTOMP, ProtocolMQTT, ProtocolWebSocket, ProtocolDetect:
+		return nil
+	}
+	return fmt.Errorf("invalid protocol %q", protocol)
+}
+
+// New returns a server configured by the options in opts, such as
+// Opt.Listen, for embedding the server in an application. The server
+// is started with Start, and stopped with Shutdown or Drain. Settings
+// without an option can be made with the fields of the server before
+// it is started.
+func New(opts ...func(*Server) error) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start opens the listeners added with Opt.Listen, and serves the
+// clients of all the server's listeners. If no listener has been
+// added, the server listens for STOMP clients on Addr, or DefaultAddr
+// if Addr is empty. Start returns once the server is ready to serve
+// clients, or with the error that prevents it from serving. STOMP
+// clients are served as by Serve, and the clients of the other
+// protocols need a STOMP listener, or a detect listener.
+//
+// Shutdown and Drain stop the server and close its listeners, and
+// Wait waits for it to stop. A server can only be started once.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	if s.started != nil || s.proc != nil {
+		s.mu.Unlock()
+		return ErrStarted
+	}
+	st := &startState{done: make(chan struct{})}
+	s.started = st
+	listeners := s.listeners
+	s.mu.Unlock()
+	if len(listeners) == 0 {
+		addr := s.Addr
+		if addr == "" {
+			addr = DefaultAddr
+		}
+		listeners = []serverListener{{protocol: ProtocolSTOMP, addr: addr}}
+	}
+
+	fail := func(err error) error {
+		for _, l := range st.listeners {
+			l.Close()
+		}
+		for _, sl := range listeners {
+			if sl.l != nil {
+				sl.l.Close()
+			}
+		}
+		st.err = err
+		close(st.done)
+		return err
+	}
+
+	var stompListeners []net.Listener
+	var serve []func()
+	for _, sl := range listeners {
+		l := sl.l
+		if l == nil {
+			var err error
+			if l, err = net.Listen("tcp", sl.addr); err != nil {
+				return fail(err)
+			}
+		}
+		st.listeners = append(st.listeners, l)
+
+		switch sl.protocol {
+		case ProtocolSTOMP:
+			stompListeners = append(stompListeners, l)
+		case ProtocolMQTT:
+			serve = append(serve, func() { go s.ServeMQTT(l) })
+		case ProtocolWebSocket:
+			hs := &http.Server{Handler: s.WebSocketHandler()}
+			st.servers = append(st.servers, hs)
+			serve = append(serve, func() { go hs.Serve(l) })
+		case ProtocolDetect:
+			mux := sniff.NewMux(l)
+			stompListeners = append(stompListeners, mux.Listener(sniff.STOMP))
+			hs := &http.Server{Handler: s.WebSocketHandler()}
+			st.servers = append(st.servers, hs)
+			serve = append(serve, func() {
+				go mux.Serve()
+				go s.ServeMQTT(mux.Listener(sniff.MQTT))
+				go hs.Serve(mux.Listener(sniff.HTTP))
+			})
+		}
+	}
+	var stompListener net.Listener
+	switch len(stompListeners) {
+	case 0:
+		return fail(errors.New("no stomp or detect listener"))
+	case 1:
+		stompListener = stompListeners[0]
+	default:
+		stompListener = newMultiListener(stompListeners)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(stompListener)
+	}()
+	for s.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		select {
+		case err := <-served:
+			return fail(err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	for _, fn := range serve {
+		fn()
+	}
+
+	go func() {
+		err := <-served
+		// the listeners of the other protocols are closed once the
+		// server has stopped serving STOMP clients, as they need it
+		for _, hs := range st.servers {
+			hs.Close()
+		}
+		for _, l := range st.listeners {
+			l.Close()
+		}
+		st.err = err
+		close(st.done)
+	}()
+	return nil
+}
+
+// Wait waits for a server started by Start to stop serving, and
+// returns the error returned by Serve, which is ErrServerClosed after
+// Shutdown or Drain. Wait returns ErrNotServing if the server has not
+// been started.
+func (s *Server) Wait() error {
+	s.mu.Lock()
+	st := s.started
+	s.mu.Unlock()
+	if st == nil {
+		return ErrNotServing
+	}
+	<-st.done
+	return st.err
+}
+
+// Addrs returns the addresses of the listeners of a server started by
+// Start, in the order in which the listeners were added, so that the
+// addresses of listeners on port 0 can be found. Addrs returns nil if
+// the server has not been started.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	st := s.started
+	s.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+	select {
+	case <-st.done:
+		return nil
+	default:
+	}
+	addrs := make([]net.Addr, len(st.listeners))
+	for i, l := range st.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// multiListener accepts the connections of several listeners, so that
+// a server can serve the STOMP clients of all of them.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	ml := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go ml.accept(l)
+	}
+	return ml
+}
+
+func (ml *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case ml.accepted <- acceptResult{conn, err}:
+		case <-ml.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Accept returns the next connection accepted by any of the listeners,
+// or the error of a listener that fails.
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-ml.accepted:
+		return r.conn, r.err
+	case <-ml.closed:
+		return nil, errors.New("accept: listeners closed")
+	}
+}
+
+// Close closes all the listeners.
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.closed)
+		for _, l := range ml.listeners {
+			if e := l.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
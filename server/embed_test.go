@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type EmbedSuite struct{}
+
+var _ = Suite(&EmbedSuite{})
+
+func (s *EmbedSuite) TestStart(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv, err := New(
+		Opt.Listen(ProtocolSTOMP, "127.0.0.1:0"),
+		Opt.Listener(ProtocolDetect, l),
+		Opt.Listen(ProtocolMQTT, "127.0.0.1:0"),
+		Opt.Authenticator(testAuthenticator{}),
+		Opt.Policies(DestinationPolicy{Pattern: "/queue/embed", Dispatch: DispatchBroadcast}),
+	)
+	c.Assert(err, IsNil)
+	c.Assert(serv.Start(), IsNil)
+	c.Check(serv.Start(), Equals, ErrStarted)
+
+	addrs := serv.Addrs()
+	c.Assert(addrs, HasLen, 3)
+	c.Check(addrs[1].String(), Equals, l.Addr().String())
+
+	// both STOMP listeners are served
+	conn1, err := stomp.Dial("tcp", addrs[0].String(), stomp.ConnOpt.Login("user", "secret"))
+	c.Assert(err, IsNil)
+	defer conn1.Disconnect()
+	conn2, err := stomp.Dial("tcp", addrs[1].String(), stomp.ConnOpt.Login("user", "secret"))
+	c.Assert(err, IsNil)
+	defer conn2.Disconnect()
+	_, err = stomp.Dial("tcp", addrs[0].String(), stomp.ConnOpt.Login("user", "wrong"))
+	c.Check(err, NotNil)
+
+	sub, err := conn1.Subscribe("/queue/embed", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(conn1.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+	c.Assert(conn2.Send("/queue/embed", "text/plain", []byte("hello"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, "hello")
+
+	c.Assert(serv.Shutdown(), IsNil)
+	c.Check(serv.Wait(), Equals, ErrServerClosed)
+	c.Check(serv.Addrs(), IsNil)
+	// the listeners are closed
+	for _, addr := range addrs {
+		_, err := net.Dial("tcp", addr.String())
+		c.Check(err, NotNil)
+	}
+}
+
+func (s *EmbedSuite) TestStartErrors(c *C) {
+	_, err := New(Opt.Listen("amqp", "127.0.0.1:0"))
+	c.Check(err, ErrorMatches, `invalid protocol "amqp"`)
+
+	serv, err := New(Opt.Listen(ProtocolMQTT, "127.0.0.1:0"))
+	c.Assert(err, IsNil)
+	c.Check(serv.Start(), ErrorMatches, "no stomp or detect listener")
+	c.Check(serv.Wait(), NotNil)
+
+	serv, err = New(Opt.Listen(ProtocolSTOMP, "127.0.0.1:-1"))
+	c.Assert(err, IsNil)
+	c.Check(serv.Start(), NotNil)
+
+	c.Check((&Server{}).Wait(), Equals, ErrNotServing)
+}
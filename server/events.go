@@ -0,0 +1,221 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// DefaultEventBufferSize is the number of events waiting to be passed
+// to the hooks of an EventHooks whose BufferSize is zero.
+const DefaultEventBufferSize = 1024
+
+// A ClientEvent describes a client that has connected or disconnected.
+type ClientEvent struct {
+	ConnectionId string    // Identifies the connection while the server runs
+	RemoteAddr   string    // Network address of the client
+	Login        string    // Login of the client, empty if none
+	Version      string    // Negotiated STOMP protocol version
+	Time         time.Time // Time of the event
+}
+
+// A SubscriptionEvent describes a subscription that has been added or
+// removed.
+type SubscriptionEvent struct {
+	ConnectionId string    // Id of the client's connection
+	Login        string    // Login of the client, empty if none
+	Id           string    // Client's id for the subscription
+	Destination  string    // Destination subscribed to
+	Ack          string    // Acknowledgement mode
+	Time         time.Time // Time of the event
+}
+
+// A SendEvent describes a message sent to a destination. Messages
+// forwarded by other servers and copied by the server, for example to
+// mirror queues, have no connection.
+type SendEvent struct {
+	ConnectionId string        // Id of the sending client's connection, empty if none
+	Login        string        // Login of the client, empty if none
+	Destination  string        // Destination the message was sent to
+	Header       *frame.Header // Copy of the header of the SEND frame
+	BodyLength   int           // Length of the message body
+	Time         time.Time     // Time of the event
+}
+
+// An AckEvent describes a message sent to a queue that has been
+// acknowledged by a client.
+type AckEvent struct {
+	ConnectionId   string    // Id of the client's connection
+	Login          string    // Login of the client, empty if none
+	SubscriptionId string    // Client's id for the subscription
+	Destination    string    // Destination the message was sent to
+	MessageId      string    // Value of the message-id header
+	Time           time.Time // Time of the event
+}
+
+// EventHooks are functions called when clients connect, subscribe,
+// send and acknowledge messages, so that programs embedding the server
+// can keep presence lists or record their own measurements. Hooks that
+// are nil are not called.
+//
+// The hooks are called asynchronously, one at a time from a single
+// go-routine, in the order that the events happened. Events wait in a
+// buffer while a hook runs; once the buffer is full, the server waits
+// for room, so hooks should return quickly.
+type EventHooks struct {
+	OnClientConnect    func(ClientEvent)
+	OnClientDisconnect func(ClientEvent)
+	OnSubscribe        func(SubscriptionEvent)
+	OnUnsubscribe      func(SubscriptionEvent)
+	OnSend             func(SendEvent)
+	OnAck              func(AckEvent)
+
+	// Maximum events waiting for their hook, DefaultEventBufferSize
+	// if zero.
+	BufferSize int
+}
+
+// dispatcher calls functions one at a time on its own go-routine, in
+// the order that they were passed to Do, so that functions provided by
+// the program do not hold up the request processor until its buffer
+// fills up.
+type dispatcher struct {
+	ch   chan func()
+	done chan struct{}
+}
+
+func newDispatcher(size int) *dispatcher {
+	d := &dispatcher{
+		ch:   make(chan func(), size),
+		done: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for fn := range d.ch {
+		fn()
+	}
+}
+
+// Do calls fn on the dispatcher's go-routine, after the functions
+// already passed to Do.
+func (d *dispatcher) Do(fn func()) {
+	d.ch <- fn
+}
+
+// Stop the dispatcher. Functions waiting in the buffer are called
+// before Stop returns.
+func (d *dispatcher) Stop() {
+	close(d.ch)
+	<-d.done
+}
+
+// events passes events to hooks on its own go-routine. The methods of
+// a nil *events do nothing.
+type events struct {
+	*dispatcher
+	hooks EventHooks
+}
+
+func newEvents(hooks EventHooks) *events {
+	size := hooks.BufferSize
+	if size <= 0 {
+		size = DefaultEventBufferSize
+	}
+	return &events{dispatcher: newDispatcher(size), hooks: hooks}
+}
+
+// Stop passing events. Events waiting in the buffer are passed to
+// their hooks before Stop returns.
+func (e *events) Stop() {
+	if e != nil {
+		e.dispatcher.Stop()
+	}
+}
+
+func (e *events) Connected(c *client.Conn) {
+	if e != nil && e.hooks.OnClientConnect != nil {
+		event, hook := clientEvent(c), e.hooks.OnClientConnect
+		e.Do(func() { hook(event) })
+	}
+}
+
+func (e *events) Disconnected(c *client.Conn) {
+	if e != nil && e.hooks.OnClientDisconnect != nil {
+		event, hook := clientEvent(c), e.hooks.OnClientDisconnect
+		e.Do(func() { hook(event) })
+	}
+}
+
+func (e *events) Subscribed(sub *client.Subscription) {
+	if e != nil && e.hooks.OnSubscribe != nil {
+		event, hook := subscriptionEvent(sub), e.hooks.OnSubscribe
+		e.Do(func() { hook(event) })
+	}
+}
+
+func (e *events) Unsubscribed(sub *client.Subscription) {
+	if e != nil && e.hooks.OnUnsubscribe != nil {
+		event, hook := subscriptionEvent(sub), e.hooks.OnUnsubscribe
+		e.Do(func() { hook(event) })
+	}
+}
+
+func (e *events) Sent(c *client.Conn, destination string, f *frame.Frame) {
+	if e != nil && e.hooks.OnSend != nil {
+		event := SendEvent{
+			Destination: destination,
+			Header:      f.Header.Clone(),
+			BodyLength:  len(f.Body),
+			Time:        time.Now(),
+		}
+		if c != nil {
+			event.ConnectionId, event.Login = c.Id(), c.Login()
+		}
+		hook := e.hooks.OnSend
+		e.Do(func() { hook(event) })
+	}
+}
+
+func (e *events) Acked(sub *client.Subscription, f *frame.Frame) {
+	if e != nil && e.hooks.OnAck != nil {
+		event := AckEvent{
+			SubscriptionId: sub.Id(),
+			Destination:    sub.Destination(),
+			MessageId:      f.Header.Get(frame.MessageId),
+			Time:           time.Now(),
+		}
+		if c := sub.Conn(); c != nil {
+			event.ConnectionId, event.Login = c.Id(), c.Login()
+		}
+		hook := e.hooks.OnAck
+		e.Do(func() { hook(event) })
+	}
+}
+
+func clientEvent(c *client.Conn) ClientEvent {
+	return ClientEvent{
+		ConnectionId: c.Id(),
+		RemoteAddr:   c.RemoteAddr().String(),
+		Login:        c.Login(),
+		Version:      string(c.Version()),
+		Time:         time.Now(),
+	}
+}
+
+func subscriptionEvent(sub *client.Subscription) SubscriptionEvent {
+	event := SubscriptionEvent{
+		Id:          sub.Id(),
+		Destination: sub.Destination(),
+		Ack:         sub.Ack(),
+		Time:        time.Now(),
+	}
+	if c := sub.Conn(); c != nil {
+		event.ConnectionId, event.Login = c.Id(), c.Login()
+	}
+	return event
+}
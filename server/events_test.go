@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type EventsSuite struct{}
+
+var _ = Suite(&EventsSuite{})
+
+func (s *EventsSuite) TestEvents(c *C) {
+	var events []string // only used by hooks until the server stops
+	serv := &Server{
+		Authenticator: testAuthenticator{},
+		Events: &EventHooks{
+			OnClientConnect: func(e ClientEvent) {
+				events = append(events, fmt.Sprintf("connect %s %s", e.Login, e.Version))
+			},
+			OnClientDisconnect: func(e ClientEvent) {
+				events = append(events, "disconnect "+e.Login)
+			},
+			OnSubscribe: func(e SubscriptionEvent) {
+				events = append(events, fmt.Sprintf("subscribe %s %s %s %s", e.Login, e.Id, e.Destination, e.Ack))
+			},
+			OnUnsubscribe: func(e SubscriptionEvent) {
+				events = append(events, fmt.Sprintf("unsubscribe %s %s", e.Id, e.Destination))
+			},
+			OnSend: func(e SendEvent) {
+				events = append(events, fmt.Sprintf("send %s %s %d %s", e.Login, e.Destination, e.BodyLength,
+					e.Header.Get("priority")))
+			},
+			OnAck: func(e AckEvent) {
+				events = append(events, fmt.Sprintf("ack %s %s %s %t", e.Login, e.SubscriptionId, e.Destination,
+					e.MessageId != ""))
+			},
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	done := make(chan error)
+	go func() { done <- serv.Serve(l) }()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("user", "secret"),
+		stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/orders", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	id := sub.Id()
+	c.Assert(conn.Send("/queue/orders", "text/plain", []byte("hello"), stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header("priority", "4")), IsNil)
+	msg := receive(c, sub)
+	c.Assert(conn.Ack(msg), IsNil)
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+	for {
+		conns, err := serv.Connections()
+		c.Assert(err, IsNil)
+		if len(conns) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// all events are passed to the hooks before Serve returns
+	c.Assert(serv.Shutdown(), IsNil)
+	<-done
+	c.Check(events, DeepEquals, []string{
+		"connect user 1.1",
+		"subscribe user " + id + " /queue/orders client-individual",
+		"send user /queue/orders 5 4",
+		"ack user " + id + " /queue/orders true",
+		"unsubscribe " + id + " /queue/orders",
+		"disconnect user",
+	})
+}
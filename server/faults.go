@@ -0,0 +1,130 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/wildcard"
+)
+
+// The command of a FaultRule that matches heart-beats.
+const FaultHeartBeat = "heart-beat"
+
+// A FaultRule injects a fault into the frames or heart-beats of
+// connections that match it.
+type FaultRule struct {
+	// If true, the rule matches frames received from clients,
+	// otherwise frames sent to clients.
+	Inbound bool
+
+	// Command of the frames that match, such as frame.MESSAGE, or
+	// FaultHeartBeat for heart-beats. If empty, every frame matches,
+	// but heart-beats do not.
+	Command string
+
+	// Pattern of the destinations of the frames that match, which may
+	// contain wildcards as DestinationPolicy.Pattern. If empty, frames
+	// match whatever their destination.
+	Destination string
+
+	// Id of the connection whose frames match, as returned by
+	// Connections. If empty, the frames of every connection match.
+	Connection string
+
+	// Number of matching frames that are passed before the fault is
+	// injected, for example to drop the second MESSAGE frame.
+	Skip int
+
+	// Number of times the fault is injected, after which the rule no
+	// longer applies. If zero, the fault is injected every time.
+	Count int
+
+	Fault client.Fault
+}
+
+// Faults injects faults into the frames and heart-beats of a server's
+// connections, according to rules that can be changed while the server
+// is serving, so that tests can exercise the reconnection and
+// redelivery logic of clients. Set Server.Faults to use it. The zero
+// value has no rules.
+//
+//	faults := &server.Faults{}
+//	srv := &server.Server{Faults: faults}
+//	...
+//	// lose the next message sent to a client
+//	faults.Add(server.FaultRule{Command: frame.MESSAGE, Count: 1, Fault: client.Fault{Drop: true}})
+type Faults struct {
+	mu    sync.Mutex
+	rules []*faultRule
+}
+
+type faultRule struct {
+	FaultRule
+	matched  int // number of frames matched
+	injected int // number of faults injected
+}
+
+// Add adds a rule. The fault of the first rule that applies to a frame
+// is injected.
+func (fs *Faults) Add(rule FaultRule) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules = append(fs.rules, &faultRule{FaultRule: rule})
+}
+
+// Clear removes every rule, so that no more faults are injected.
+func (fs *Faults) Clear() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules = nil
+}
+
+// Injected returns the number of faults injected by the rules, in the
+// order in which they were added.
+func (fs *Faults) Injected() []int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	injected := make([]int, len(fs.rules))
+	for i, rule := range fs.rules {
+		injected[i] = rule.injected
+	}
+	return injected
+}
+
+func (fs *Faults) Inbound(c *client.Conn, f *frame.Frame) client.Fault {
+	return fs.fault(true, c, f)
+}
+
+func (fs *Faults) Outbound(c *client.Conn, f *frame.Frame) client.Fault {
+	return fs.fault(false, c, f)
+}
+
+func (fs *Faults) fault(inbound bool, c *client.Conn, f *frame.Frame) client.Fault {
+	command, destination := FaultHeartBeat, ""
+	if f != nil {
+		command, destination = f.Command, f.Header.Get(frame.Destination)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, rule := range fs.rules {
+		if rule.Inbound != inbound ||
+			rule.Count > 0 && rule.injected >= rule.Count ||
+			rule.Connection != "" && rule.Connection != c.Id() {
+			continue
+		}
+		if rule.Command == "" && f == nil || rule.Command != "" && rule.Command != command {
+			continue
+		}
+		if rule.Destination != "" && !wildcard.Match(rule.Destination, destination) {
+			continue
+		}
+		rule.matched++
+		if rule.matched <= rule.Skip {
+			continue
+		}
+		rule.injected++
+		return rule.Fault
+	}
+	return client.Fault{}
+}
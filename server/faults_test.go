@@ -0,0 +1,97 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	. "gopkg.in/check.v1"
+)
+
+type FaultsSuite struct{}
+
+var _ = Suite(&FaultsSuite{})
+
+func (s *FaultsSuite) TestDropMessage(c *C) {
+	faults := &Faults{}
+	serv := &Server{Faults: faults}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	faults.Add(FaultRule{Command: frame.MESSAGE, Destination: "/queue/faults", Count: 1, Fault: client.Fault{Drop: true}})
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	_, err = conn.Subscribe("/queue/faults", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Send("/queue/faults", "text/plain", []byte("lost"), stomp.SendOpt.Receipt), IsNil)
+	for faults.Injected()[0] == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(conn.Disconnect(), IsNil)
+
+	// the lost message was not acknowledged, so is delivered again
+	conn, err = stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	sub, err := conn.Subscribe("/queue/faults", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, "lost")
+}
+
+func (s *FaultsSuite) TestCloseAndDelay(c *C) {
+	faults := &Faults{}
+	serv := &Server{Faults: faults}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	faults.Add(FaultRule{Inbound: true, Command: frame.SEND, Skip: 1, Fault: client.Fault{Close: true}})
+	faults.Add(FaultRule{Command: frame.RECEIPT, Fault: client.Fault{Delay: 50 * time.Millisecond}})
+	lost := make(chan error, 1)
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.OnConnectionLost(func(err error) {
+		lost <- err
+	}))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+
+	start := time.Now()
+	c.Assert(conn.Send("/queue/a", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+	c.Check(time.Since(start) >= 50*time.Millisecond, Equals, true)
+
+	// the second SEND frame closes the connection
+	conn.Send("/queue/a", "text/plain", nil)
+	select {
+	case err := <-lost:
+		c.Check(err, NotNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("connection not closed")
+	}
+	c.Check(faults.Injected(), DeepEquals, []int{1, 1})
+}
+
+func (s *FaultsSuite) TestDropHeartBeats(c *C) {
+	faults := &Faults{}
+	faults.Add(FaultRule{Command: FaultHeartBeat, Fault: client.Fault{Drop: true}})
+	serv := &Server{Faults: faults, HeartBeat: 20 * time.Millisecond}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	missed := make(chan int, 10)
+	conn, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.HeartBeat(20*time.Millisecond, 20*time.Millisecond),
+		stomp.ConnOpt.HeartBeatError(10*time.Millisecond),
+		stomp.ConnOpt.HeartBeatGracePeriodMultiplier(10),
+		stomp.ConnOpt.OnHeartbeatMissed(func(n int) {
+			select {
+			case missed <- n:
+			default:
+			}
+		}))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	select {
+	case <-missed:
+	case <-time.After(5 * time.Second):
+		c.Fatal("heart-beats not missed")
+	}
+}
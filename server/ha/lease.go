@@ -0,0 +1,183 @@
+package ha
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default duration of a lease acquired or renewed by a FileLease.
+const DefaultLeaseDuration = 10 * time.Second
+
+// Errors returned by leases.
+var (
+	ErrHeld = errors.New("ha: lease is held by another instance")
+	ErrLost = errors.New("ha: lease has been lost")
+)
+
+// A Lease grants one instance at a time the right to be active. Each
+// time the lease is acquired, it is given a fencing token that is
+// greater than any token given before, so that the shared storage can
+// reject changes made by an instance that has lost the lease.
+type Lease interface {
+	// Acquire the lease, returning its token and the time at which it
+	// expires unless it is renewed. Returns ErrHeld if another instance
+	// holds the lease and it has not expired.
+	Acquire() (token uint64, expires time.Time, err error)
+
+	// Renew the lease acquired with token, returning the time at which
+	// it now expires. Returns ErrLost if the lease has been acquired
+	// by another instance.
+	Renew(token uint64) (expires time.Time, err error)
+
+	// Release the lease acquired with token, so that another instance
+	// can acquire it without waiting for it to expire.
+	Release(token uint64) error
+}
+
+// FileLease is a lease kept in a file on storage shared by the
+// instances, which is locked while the lease is read and changed. The
+// file system must support flock across the hosts of the instances,
+// and their clocks must not differ by a significant part of Duration.
+type FileLease struct {
+	Path     string        // Path of the lease file, which is created if it does not exist
+	Owner    string        // Identifies the instance, the host name and process ID if empty
+	Duration time.Duration // Time until the lease expires, DefaultLeaseDuration if zero
+}
+
+// The contents of a lease file.
+type leaseRecord struct {
+	token   uint64
+	expires time.Time
+	owner   string
+}
+
+// Acquire the lease if it is not held, has expired, or is held by the
+// same owner. The token is one more than that of the previous holder.
+func (l *FileLease) Acquire() (uint64, time.Time, error) {
+	var rec leaseRecord
+	err := l.update(func(cur leaseRecord) (leaseRecord, error) {
+		if cur.owner != "" && cur.owner != l.owner() && time.Now().Before(cur.expires) {
+			return cur, ErrHeld
+		}
+		rec = leaseRecord{token: cur.token + 1, expires: time.Now().Add(l.duration()), owner: l.owner()}
+		return rec, nil
+	})
+	return rec.token, rec.expires, err
+}
+
+// Renew the lease, if it has not been acquired since token was given.
+func (l *FileLease) Renew(token uint64) (time.Time, error) {
+	var rec leaseRecord
+	err := l.update(func(cur leaseRecord) (leaseRecord, error) {
+		if cur.token != token || cur.owner != l.owner() {
+			return cur, ErrLost
+		}
+		rec = leaseRecord{token: token, expires: time.Now().Add(l.duration()), owner: l.owner()}
+		return rec, nil
+	})
+	return rec.expires, err
+}
+
+// Release the lease, keeping its token so that the next instance to
+// acquire it is given a greater token. Returns ErrLost if the lease
+// has been acquired since token was given.
+func (l *FileLease) Release(token uint64) error {
+	return l.update(func(cur leaseRecord) (leaseRecord, error) {
+		if cur.token != token || cur.owner != l.owner() {
+			return cur, ErrLost
+		}
+		return leaseRecord{token: token}, nil
+	})
+}
+
+func (l *FileLease) owner() string {
+	if l.Owner == "" {
+		host, _ := os.Hostname()
+		l.Owner = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return l.Owner
+}
+
+func (l *FileLease) duration() time.Duration {
+	if l.Duration <= 0 {
+		return DefaultLeaseDuration
+	}
+	return l.Duration
+}
+
+// Reads the lease file and writes the record returned by fn, while
+// the file is locked. Nothing is written if fn returns an error.
+func (l *FileLease) update(fn func(cur leaseRecord) (leaseRecord, error)) error {
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	cur, err := parseLease(string(b))
+	if err != nil {
+		return err
+	}
+	rec, err := fn(cur)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err = f.Truncate(0); err != nil {
+		return err
+	}
+	var expires int64
+	if !rec.expires.IsZero() {
+		expires = rec.expires.UnixNano()
+	}
+	if _, err = fmt.Fprintf(f, "%d %d %s\n", rec.token, expires, rec.owner); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Parses the contents of a lease file, which are the token, the time
+// at which the lease expires in nanoseconds since the Unix epoch, and
+// the owner. An empty file is a lease that has never been acquired.
+func parseLease(text string) (leaseRecord, error) {
+	var rec leaseRecord
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 3)
+	if len(fields) == 1 && fields[0] == "" {
+		return rec, nil
+	}
+	if len(fields) < 2 {
+		return rec, fmt.Errorf("ha: invalid lease file: %q", text)
+	}
+	var err error
+	if rec.token, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return rec, fmt.Errorf("ha: invalid lease token: %v", err)
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return rec, fmt.Errorf("ha: invalid lease expiry: %v", err)
+	}
+	if expires != 0 {
+		rec.expires = time.Unix(0, expires)
+	}
+	if len(fields) == 3 {
+		rec.owner = fields[2]
+	}
+	return rec, nil
+}
@@ -0,0 +1,65 @@
+package ha
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestHA(t *testing.T) {
+	TestingT(t)
+}
+
+type LeaseSuite struct{}
+
+var _ = Suite(&LeaseSuite{})
+
+func (s *LeaseSuite) TestAcquire(c *C) {
+	path := filepath.Join(c.MkDir(), "lease")
+	a := &FileLease{Path: path, Owner: "a", Duration: time.Minute}
+	b := &FileLease{Path: path, Owner: "b", Duration: time.Minute}
+
+	token, expires, err := a.Acquire()
+	c.Assert(err, IsNil)
+	c.Check(token, Equals, uint64(1))
+	c.Check(expires.After(time.Now()), Equals, true)
+
+	_, _, err = b.Acquire()
+	c.Check(err, Equals, ErrHeld)
+	_, err = a.Renew(token)
+	c.Check(err, IsNil)
+
+	// once released, the lease is acquired with a greater token
+	c.Assert(a.Release(token), IsNil)
+	token, _, err = b.Acquire()
+	c.Assert(err, IsNil)
+	c.Check(token, Equals, uint64(2))
+	c.Check(a.Release(1), Equals, ErrLost)
+}
+
+func (s *LeaseSuite) TestExpire(c *C) {
+	path := filepath.Join(c.MkDir(), "lease")
+	a := &FileLease{Path: path, Owner: "a", Duration: 20 * time.Millisecond}
+	b := &FileLease{Path: path, Owner: "b", Duration: time.Minute}
+
+	token, _, err := a.Acquire()
+	c.Assert(err, IsNil)
+	time.Sleep(30 * time.Millisecond)
+	next, _, err := b.Acquire()
+	c.Assert(err, IsNil)
+	c.Check(next, Equals, token+1)
+
+	// the previous holder cannot renew the lease
+	_, err = a.Renew(token)
+	c.Check(err, Equals, ErrLost)
+}
+
+func (s *LeaseSuite) TestInvalidFile(c *C) {
+	path := filepath.Join(c.MkDir(), "lease")
+	c.Assert(ioutil.WriteFile(path, []byte("not a lease\n"), 0644), IsNil)
+	_, _, err := (&FileLease{Path: path}).Acquire()
+	c.Check(err, ErrorMatches, "ha: invalid lease token: .*")
+}
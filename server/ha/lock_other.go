@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package ha
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoLocks = errors.New("ha: file locks are not supported on this platform")
+
+func lockFile(f *os.File) error {
+	return errNoLocks
+}
+
+func unlockFile(f *os.File) error {
+	return errNoLocks
+}
@@ -0,0 +1,218 @@
+/*
+Package ha runs STOMP servers as an active/passive pair, or a larger
+group, over the same persistent queue storage. Only one instance is
+active at a time: the instance that holds a lease. The others wait on
+standby, and take over once the lease is released or expires.
+
+The active instance renews its lease periodically. If the lease cannot
+be renewed before it expires, or has been acquired by another instance,
+the active instance shuts its server down and returns to standby, so
+that two instances never serve clients from the same storage. Each time
+the lease is acquired it is given a greater fencing token, which is
+passed to OpenStorage, so that storage that supports fencing can also
+reject changes from an instance that has lost the lease but has not yet
+noticed.
+
+A FileLease keeps the lease in a file on shared storage:
+
+	n := &ha.Node{
+		Server: &server.Server{},
+		Listen: func() (net.Listener, error) { return net.Listen("tcp", ":61613") },
+		Lease:  &ha.FileLease{Path: "/mnt/shared/stomp.lease"},
+		OpenStorage: func(token uint64) (server.QueueStorage, error) {
+			return boltstore.Open(boltstore.Options{Dir: "/mnt/shared/stomp"})
+		},
+	}
+	go n.Run()
+*/
+package ha
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// Default interval between attempts to acquire the lease.
+const DefaultRetryInterval = time.Second
+
+// Errors returned by Node.Run.
+var (
+	ErrIncomplete = errors.New("ha: Server, Listen and Lease are required")
+	ErrStopped    = errors.New("ha: stopped")
+)
+
+// A Node is an instance of the server that is active while it holds
+// the lease. The exported fields must not be changed once Run has been
+// called.
+type Node struct {
+	Server *server.Server               // Server to run while active, required
+	Listen func() (net.Listener, error) // Creates the listener each time the node becomes active, required
+	Lease  Lease                        // Lease that the node must hold to be active, required
+
+	// Opens the queue storage each time the node becomes active, with
+	// the token of the lease. The storage is stopped when the server
+	// shuts down. If nil, the server's QueueStorage is not changed.
+	OpenStorage func(token uint64) (server.QueueStorage, error)
+
+	RetryInterval time.Duration // Interval between attempts to acquire the lease, DefaultRetryInterval if zero
+	Log           stomp.Logger  // Logger, the standard logger if nil
+
+	mutex sync.Mutex
+	stop  chan struct{} // closed when the node is stopped
+	token uint64        // token of the lease while active
+}
+
+// Run waits to acquire the lease, then runs the server until the lease
+// is lost, and waits again, until Stop is called. Returns ErrStopped
+// once the node has stopped.
+func (n *Node) Run() error {
+	if n.Server == nil || n.Listen == nil || n.Lease == nil {
+		return ErrIncomplete
+	}
+	if n.Log == nil {
+		n.Log = log.StdLogger{}
+	}
+	if n.RetryInterval <= 0 {
+		n.RetryInterval = DefaultRetryInterval
+	}
+	stop := n.stopChannel()
+	for {
+		token, expires, err := n.Lease.Acquire()
+		if err == nil {
+			err = n.serve(token, expires, stop)
+			if err := n.Lease.Release(token); err != nil && err != ErrLost {
+				n.Log.Warningf("ha: cannot release lease: %v", err)
+			}
+			if err == ErrStopped {
+				return ErrStopped
+			}
+			n.Log.Warningf("ha: standing by: %v", err)
+		} else if err != ErrHeld {
+			n.Log.Warningf("ha: cannot acquire lease: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return ErrStopped
+		case <-time.After(n.RetryInterval):
+		}
+	}
+}
+
+// Stop the node, shutting down the server if it is active and
+// releasing the lease.
+func (n *Node) Stop() {
+	stop := n.stopChannel()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	select {
+	case <-stop:
+	default:
+		close(stop)
+	}
+}
+
+// Active reports whether the node holds the lease and is running the
+// server, and if so the token of the lease.
+func (n *Node) Active() (token uint64, ok bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.token, n.token != 0
+}
+
+func (n *Node) stopChannel() chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.stop == nil {
+		n.stop = make(chan struct{})
+	}
+	return n.stop
+}
+
+func (n *Node) setToken(token uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.token = token
+}
+
+// Runs the server while the node holds the lease acquired with token,
+// renewing the lease before it expires. Returns the reason that the
+// server was shut down.
+func (n *Node) serve(token uint64, expires time.Time, stop <-chan struct{}) error {
+	l, err := n.Listen()
+	if err != nil {
+		return err
+	}
+	if n.OpenStorage != nil {
+		storage, err := n.OpenStorage(token)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		n.Server.QueueStorage = storage
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- n.Server.Serve(l)
+	}()
+	n.setToken(token)
+	defer n.setToken(0)
+	n.Log.Infof("ha: active with lease token %d", token)
+
+	// renew the lease well before it expires, so that it can be
+	// renewed again if an attempt fails
+	ticker := time.NewTicker(time.Until(expires) / 3)
+	defer ticker.Stop()
+	expired := time.NewTimer(time.Until(expires))
+	defer expired.Stop()
+	for {
+		select {
+		case err := <-served:
+			return err
+		case <-stop:
+			n.fence(served)
+			return ErrStopped
+		case <-expired.C:
+			n.fence(served)
+			return ErrLost
+		case <-ticker.C:
+			next, err := n.Lease.Renew(token)
+			if err == ErrLost {
+				n.fence(served)
+				return ErrLost
+			}
+			if err != nil {
+				n.Log.Warningf("ha: cannot renew lease: %v", err)
+				continue
+			}
+			if !expired.Stop() {
+				<-expired.C
+			}
+			expired.Reset(time.Until(next))
+		}
+	}
+}
+
+// Shuts the server down, and waits for Serve to return.
+func (n *Node) fence(served <-chan error) {
+	n.setToken(0)
+	for {
+		if err := n.Server.Shutdown(); err != server.ErrNotServing {
+			<-served
+			return
+		}
+		// Serve has either returned or not yet started
+		select {
+		case <-served:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
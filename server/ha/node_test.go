@@ -0,0 +1,165 @@
+package ha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/boltstore"
+	. "gopkg.in/check.v1"
+)
+
+type NodeSuite struct{}
+
+var _ = Suite(&NodeSuite{})
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, value ...interface{})   {}
+func (nopLogger) Infof(format string, value ...interface{})    {}
+func (nopLogger) Warningf(format string, value ...interface{}) {}
+func (nopLogger) Errorf(format string, value ...interface{})   {}
+func (nopLogger) Debug(message string)                         {}
+func (nopLogger) Info(message string)                          {}
+func (nopLogger) Warning(message string)                       {}
+func (nopLogger) Error(message string)                         {}
+
+// Listens on a new local address each time the node becomes active,
+// and records the address.
+type listener struct {
+	mutex sync.Mutex
+	addr  string
+}
+
+func (l *listener) listen() (net.Listener, error) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	l.addr = nl.Addr().String()
+	l.mutex.Unlock()
+	return nl, nil
+}
+
+func (l *listener) dial(c *C) *stomp.Conn {
+	l.mutex.Lock()
+	addr := l.addr
+	l.mutex.Unlock()
+	conn, err := stomp.Dial("tcp", addr, stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	return conn
+}
+
+// Creates a node of a group sharing a lease file and a data directory.
+func newNode(dir, owner string) (*Node, *listener) {
+	l := &listener{}
+	return &Node{
+		Server: &server.Server{Log: nopLogger{}},
+		Listen: l.listen,
+		Lease:  &FileLease{Path: filepath.Join(dir, "lease"), Owner: owner, Duration: 100 * time.Millisecond},
+		OpenStorage: func(token uint64) (server.QueueStorage, error) {
+			return boltstore.Open(boltstore.Options{Dir: dir})
+		},
+		RetryInterval: 10 * time.Millisecond,
+		Log:           nopLogger{},
+	}, l
+}
+
+// Waits until the node is active, and returns its token.
+func waitForActive(c *C, n *Node) uint64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if token, ok := n.Active(); ok {
+			return token
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Fatal("node did not become active")
+	return 0
+}
+
+func run(n *Node) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- n.Run()
+	}()
+	return done
+}
+
+func (s *NodeSuite) TestTakeover(c *C) {
+	dir := c.MkDir()
+	n1, l1 := newNode(dir, "one")
+	n2, l2 := newNode(dir, "two")
+	done1 := run(n1)
+	token := waitForActive(c, n1)
+	done2 := run(n2)
+	defer func() {
+		n2.Stop()
+		c.Check(<-done2, Equals, ErrStopped)
+	}()
+
+	conn := l1.dial(c)
+	c.Assert(conn.Send("/queue/test", "text/plain", []byte("kept"), stomp.SendOpt.Receipt), IsNil)
+	conn.Disconnect()
+	time.Sleep(150 * time.Millisecond)
+	_, ok := n2.Active()
+	c.Check(ok, Equals, false)
+
+	// the standby node takes over with the same storage
+	n1.Stop()
+	c.Check(<-done1, Equals, ErrStopped)
+	c.Check(waitForActive(c, n2), Equals, token+1)
+
+	conn = l2.dial(c)
+	defer conn.Disconnect()
+	sub, err := conn.Subscribe("/queue/test", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, "kept")
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not received")
+	}
+}
+
+func (s *NodeSuite) TestFence(c *C) {
+	dir := c.MkDir()
+	n, l := newNode(dir, "one")
+	done := run(n)
+	defer func() {
+		n.Stop()
+		c.Check(<-done, Equals, ErrStopped)
+	}()
+	token := waitForActive(c, n)
+	conn := l.dial(c)
+	sub, err := conn.Subscribe("/queue/test", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// another instance holds the lease, so the node cannot renew it
+	// and shuts the server down
+	expires := time.Now().Add(time.Minute).UnixNano()
+	record := fmt.Sprintf("%d %d two\n", token+5, expires)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "lease"), []byte(record), 0644), IsNil)
+	select {
+	case msg, ok := <-sub.C:
+		if ok {
+			c.Check(msg.Err, NotNil)
+		}
+	case <-time.After(5 * time.Second):
+		c.Fatal("connection not closed")
+	}
+	_, ok := n.Active()
+	c.Check(ok, Equals, false)
+
+	// the node takes over again once the lease is released
+	other := &FileLease{Path: filepath.Join(dir, "lease"), Owner: "two"}
+	c.Assert(other.Release(token+5), IsNil)
+	c.Check(waitForActive(c, n), Equals, token+6)
+}
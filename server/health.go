@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// Time allowed for the server to respond to a health check.
+const healthTimeout = 5 * time.Second
+
+// Errors returned by Healthy and Ready.
+var (
+	ErrNotListening   = errors.New("listener is not accepting connections")
+	ErrUnresponsive   = errors.New("server is not processing requests")
+	ErrMemoryPressure = errors.New("heap exceeds MaxHeapBytes")
+)
+
+// Healthy returns nil if the server is serving: its listener is
+// accepting connections and it is processing requests. An error means
+// that the server will not recover by itself, and should be restarted.
+func (s *Server) Healthy() error {
+	return s.check(func(proc *requestProcessor) error { return nil })
+}
+
+// Ready returns nil if the server is healthy and can accept messages:
+// its queue storage, if it implements queue.PingStorage, is available,
+// and the Go heap is no larger than MaxHeapBytes. An error means that
+// clients should be directed to other servers until it recovers.
+func (s *Server) Ready() error {
+	err := s.check(func(proc *requestProcessor) error {
+		// storage is not safe for concurrent use, so is pinged by
+		// the processor
+		if p, ok := s.QueueStorage.(queue.PingStorage); ok {
+			if err := p.Ping(); err != nil {
+				return fmt.Errorf("queue storage: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.MaxHeapBytes > 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > s.MaxHeapBytes {
+			return ErrMemoryPressure
+		}
+	}
+	return nil
+}
+
+// Calls fn on the processor, after checking that the listener is
+// accepting connections, and returns ErrUnresponsive if the processor
+// does not respond in time.
+func (s *Server) check(fn func(proc *requestProcessor) error) error {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return ErrNotServing
+	}
+	select {
+	case <-proc.listening:
+		return ErrNotListening
+	default:
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- s.call(fn)
+	}()
+	timer := time.NewTimer(healthTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return ErrUnresponsive
+	}
+}
+
+// HealthHandler returns an HTTP handler for the probes of a container
+// orchestrator such as Kubernetes, which serves these paths relative
+// to the handler:
+//
+//	GET /healthz  liveness, see Healthy
+//	GET /readyz   readiness, see Ready
+//
+// The response is status 200 if the check passes, and status 503 with
+// the error otherwise. Requests are not authenticated, and the
+// responses reveal nothing about clients or messages.
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", probe(s.Healthy))
+	mux.Handle("/readyz", probe(s.Ready))
+	return mux
+}
+
+// Returns a handler for a probe, which responds with the result of
+// check.
+func probe(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+type HealthSuite struct{}
+
+var _ = Suite(&HealthSuite{})
+
+// Queue storage whose backend is unavailable while err is set.
+type pingStorage struct {
+	queue.Storage
+	err error
+}
+
+func (s *pingStorage) Ping() error {
+	return s.err
+}
+
+func (s *HealthSuite) TestHealth(c *C) {
+	storage := &pingStorage{Storage: queue.NewMemoryQueueStorage()}
+	serv := &Server{QueueStorage: storage}
+	c.Check(serv.Healthy(), Equals, ErrNotServing)
+	c.Check(serv.Ready(), Equals, ErrNotServing)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	done := make(chan error)
+	go func() { done <- serv.Serve(l) }()
+	for serv.Healthy() == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(serv.Healthy(), IsNil)
+	c.Check(serv.Ready(), IsNil)
+
+	h := serv.HealthHandler()
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := ioutil.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+	code, body := get("/readyz")
+	c.Check(code, Equals, http.StatusOK)
+	c.Check(body, Equals, "ok\n")
+
+	// the server is alive, but not ready while storage is unavailable
+	storage.err = errors.New("connection refused")
+	c.Check(serv.Healthy(), IsNil)
+	c.Check(serv.Ready(), ErrorMatches, "queue storage: connection refused")
+	code, _ = get("/healthz")
+	c.Check(code, Equals, http.StatusOK)
+	code, body = get("/readyz")
+	c.Check(code, Equals, http.StatusServiceUnavailable)
+	c.Check(body, Equals, "queue storage: connection refused\n")
+	storage.err = nil
+
+	serv.MaxHeapBytes = 1
+	c.Check(serv.Ready(), Equals, ErrMemoryPressure)
+	serv.MaxHeapBytes = 0
+
+	c.Assert(serv.Shutdown(), IsNil)
+	<-done
+	c.Check(serv.Healthy(), Equals, ErrNotServing)
+	code, _ = get("/healthz")
+	c.Check(code, Equals, http.StatusServiceUnavailable)
+}
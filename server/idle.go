@@ -0,0 +1,76 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// idleDestinations keeps track of the most recent activity for each
+// destination, so that destinations that have been idle for longer
+// than the configured period can be removed. Touch does nothing if
+// the receiver is nil.
+type idleDestinations struct {
+	timeout    time.Duration
+	lastActive map[string]time.Time
+}
+
+func newIdleDestinations(timeout time.Duration) *idleDestinations {
+	return &idleDestinations{
+		timeout:    timeout,
+		lastActive: make(map[string]time.Time),
+	}
+}
+
+// Touch records activity for the destination.
+func (id *idleDestinations) Touch(destination string, now time.Time) {
+	if id == nil {
+		return
+	}
+	id.lastActive[destination] = now
+}
+
+// Idle returns the destinations that have had no activity since the
+// idle timeout, and stops tracking them. The isUnused function reports
+// whether a destination has no subscriptions and no messages waiting;
+// destinations that are in use are never idle.
+func (id *idleDestinations) Idle(now time.Time, isUnused func(destination string) bool) []string {
+	var idle []string
+	for destination, lastActive := range id.lastActive {
+		if now.Sub(lastActive) < id.timeout || !isUnused(destination) {
+			continue
+		}
+		idle = append(idle, destination)
+		delete(id.lastActive, destination)
+	}
+	return idle
+}
+
+// Removes destinations that have been idle for longer than the
+// idle destination timeout, and sends an advisory for each one.
+func (proc *requestProcessor) removeIdleDestinations(now time.Time) {
+	isUnused := func(destination string) bool {
+		if proc.subs.Count(destination) > 0 {
+			return false
+		}
+		if isQueueDestination(destination) {
+			// paused queues are kept so that they stay paused
+			queue := proc.qm.Find(destination)
+			return queue.Len() == 0 && !queue.Paused()
+		}
+		// topics are kept while they retain messages for replay
+		return proc.tm.Find(destination).Retained() == 0
+	}
+
+	for _, destination := range proc.idle.Idle(now, isUnused) {
+		if isQueueDestination(destination) {
+			proc.qm.Remove(destination)
+		} else {
+			proc.tm.Remove(destination)
+		}
+		proc.consumers.Remove(destination)
+		// avoid advisories about advisory topics
+		if !strings.HasPrefix(destination, AdvisoryTopicPrefix) {
+			proc.advise(DestinationRemovedAdvisory, destination)
+		}
+	}
+}
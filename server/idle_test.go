@@ -0,0 +1,38 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type IdleSuite struct{}
+
+var _ = Suite(&IdleSuite{})
+
+func (s *IdleSuite) TestIdle(c *C) {
+	id := newIdleDestinations(time.Minute)
+	start := time.Now()
+	id.Touch("/queue/a", start)
+	id.Touch("/queue/b", start)
+	id.Touch("/topic/c", start.Add(30*time.Second))
+
+	unused := func(destination string) bool { return destination != "/queue/b" }
+
+	c.Check(id.Idle(start.Add(59*time.Second), unused), HasLen, 0)
+
+	idle := id.Idle(start.Add(time.Minute), unused)
+	c.Check(idle, DeepEquals, []string{"/queue/a"})
+
+	// idle destinations are only reported once
+	idle = id.Idle(start.Add(2*time.Minute), func(string) bool { return true })
+	sort.Strings(idle)
+	c.Check(idle, DeepEquals, []string{"/queue/b", "/topic/c"})
+	c.Check(id.lastActive, HasLen, 0)
+}
+
+func (s *IdleSuite) TestNil(c *C) {
+	var id *idleDestinations
+	id.Touch("/queue/a", time.Now())
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// A ConnectionInfo describes a connected STOMP client.
+type ConnectionInfo struct {
+	Id            string    `json:"id"`             // Identifies the connection while the server runs
+	RemoteAddr    string    `json:"remote_addr"`    // Network address of the client
+	Login         string    `json:"login"`          // Login of the client, empty if none
+	Version       string    `json:"version"`        // Negotiated STOMP protocol version
+	ConnectedAt   time.Time `json:"connected_at"`   // Time the client connected
+	Subscriptions int       `json:"subscriptions"`  // Number of subscriptions
+	FramesRead    uint64    `json:"frames_read"`    // Frames received from the client
+	FramesWritten uint64    `json:"frames_written"` // Frames sent to the client
+	BytesRead     uint64    `json:"bytes_read"`     // Bytes received from the client, including heart-beats
+	BytesWritten  uint64    `json:"bytes_written"`  // Bytes sent to the client, including heart-beats
+	PendingWrites int       `json:"pending_writes"` // Frames waiting to be sent to the client
+	FramesDropped uint64    `json:"frames_dropped"` // Topic frames dropped because the client is a slow consumer
+	Transactions  int       `json:"transactions"`   // Transactions begun and not yet committed or aborted
+	LastActivity  time.Time `json:"last_activity"`  // Time a frame was last received from or sent to the client
+}
+
+// A SubscriptionInfo describes a subscription of a connected client.
+type SubscriptionInfo struct {
+	ConnectionId string `json:"connection_id"` // Id of the client's connection
+	Id           string `json:"id"`            // Client's id for the subscription
+	Destination  string `json:"destination"`   // Destination subscribed to
+	Ack          string `json:"ack"`           // Acknowledgement mode
+}
+
+// A QueueInfo describes a queue.
+type QueueInfo struct {
+	Destination  string  `json:"destination"`
+	Depth        int     `json:"depth"`         // Messages waiting to be sent to a subscriber
+	Consumers    int     `json:"consumers"`     // Number of subscriptions
+	Paused       bool    `json:"paused"`        // Dispatch paused by PauseQueue
+	Enqueued     uint64  `json:"enqueued"`      // Messages sent to the queue
+	Dispatched   uint64  `json:"dispatched"`    // Messages dequeued and sent to subscribers
+	Acked        uint64  `json:"acked"`         // Messages acknowledged by clients
+	Expired      uint64  `json:"expired"`       // Messages that expired before they were sent to a subscriber
+	EnqueueRate  float64 `json:"enqueue_rate"`  // Messages sent to the queue per second, over the last minute
+	DispatchRate float64 `json:"dispatch_rate"` // Messages sent to subscribers per second, over the last minute
+	AckRate      float64 `json:"ack_rate"`      // Messages acknowledged per second, over the last minute
+	ExpireRate   float64 `json:"expire_rate"`   // Messages expired per second, over the last minute
+}
+
+// Connections returns a snapshot of the connected STOMP clients and
+// their activity, ordered by id. Clients that have not yet sent a
+// CONNECT frame are not included.
+func (s *Server) Connections() ([]ConnectionInfo, error) {
+	var conns []ConnectionInfo
+	err := s.call(func(proc *requestProcessor) error {
+		subs := make(map[string]int)
+		for _, info := range proc.subscriptions() {
+			subs[info.ConnectionId]++
+		}
+		for c := range proc.clients {
+			conns = append(conns, connectionInfo(c, subs[c.Id()]))
+		}
+		return nil
+	})
+	sort.Slice(conns, func(i, j int) bool {
+		return lessId(conns[i].Id, conns[j].Id)
+	})
+	return conns, err
+}
+
+// Connection returns a snapshot of a connected STOMP client, which is
+// identified by its id or its remote address as for DisconnectClient,
+// with its subscriptions ordered by id. Returns ErrNoClient if no
+// connected client matches.
+func (s *Server) Connection(client string) (ConnectionInfo, []SubscriptionInfo, error) {
+	var info ConnectionInfo
+	var subs []SubscriptionInfo
+	err := s.call(func(proc *requestProcessor) error {
+		for c := range proc.clients {
+			if c.Id() == client || c.RemoteAddr().String() == client {
+				for _, sub := range proc.subscriptions() {
+					if sub.ConnectionId == c.Id() {
+						subs = append(subs, sub)
+					}
+				}
+				info = connectionInfo(c, len(subs))
+				return nil
+			}
+		}
+		return ErrNoClient
+	})
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Id < subs[j].Id
+	})
+	return info, subs, err
+}
+
+// Returns a snapshot of a connected client with a number of
+// subscriptions.
+func connectionInfo(c *client.Conn, subscriptions int) ConnectionInfo {
+	stats := c.Stats()
+	return ConnectionInfo{
+		Id:            c.Id(),
+		RemoteAddr:    c.RemoteAddr().String(),
+		Login:         c.Login(),
+		Version:       string(c.Version()),
+		ConnectedAt:   c.ConnectedAt(),
+		Subscriptions: subscriptions,
+		FramesRead:    stats.FramesRead,
+		FramesWritten: stats.FramesWritten,
+		BytesRead:     stats.BytesRead,
+		BytesWritten:  stats.BytesWritten,
+		PendingWrites: stats.PendingWrites,
+		FramesDropped: stats.FramesDropped,
+		Transactions:  stats.Transactions,
+		LastActivity:  stats.LastActivity,
+	}
+}
+
+// Subscriptions returns the subscriptions of connected clients,
+// ordered by connection id and then subscription id. Subscriptions
+// to destinations owned by other servers of a cluster are not
+// included.
+func (s *Server) Subscriptions() ([]SubscriptionInfo, error) {
+	var subs []SubscriptionInfo
+	err := s.call(func(proc *requestProcessor) error {
+		subs = proc.subscriptions()
+		return nil
+	})
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].ConnectionId != subs[j].ConnectionId {
+			return lessId(subs[i].ConnectionId, subs[j].ConnectionId)
+		}
+		return subs[i].Id < subs[j].Id
+	})
+	return subs, err
+}
+
+// Queues returns the queues of the server with their depth, and the
+// counts and rates of their messages since they were created, ordered
+// by destination.
+func (s *Server) Queues() ([]QueueInfo, error) {
+	var queues []QueueInfo
+	err := s.call(func(proc *requestProcessor) error {
+		for _, destination := range proc.qm.Destinations() {
+			q := proc.qm.Find(destination)
+			stats := q.Stats()
+			queues = append(queues, QueueInfo{
+				Destination:  destination,
+				Depth:        q.Len(),
+				Consumers:    proc.subs.Count(destination),
+				Paused:       q.Paused(),
+				Enqueued:     stats.Enqueued,
+				Dispatched:   stats.Dispatched,
+				Acked:        stats.Acked,
+				Expired:      stats.Expired,
+				EnqueueRate:  stats.EnqueueRate,
+				DispatchRate: stats.DispatchRate,
+				AckRate:      stats.AckRate,
+				ExpireRate:   stats.ExpireRate,
+			})
+		}
+		return nil
+	})
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Destination < queues[j].Destination
+	})
+	return queues, err
+}
+
+// A MessageInfo describes a message waiting in a queue.
+type MessageInfo struct {
+	MessageId string        `json:"message_id"`
+	Header    []HeaderEntry `json:"header"`    // Header entries in order, including repeated keys
+	BodySize  int           `json:"body_size"` // Length of the body in bytes
+}
+
+// A HeaderEntry is an entry in the header of a message.
+type HeaderEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// QueueMessages returns the headers of up to limit messages, or of all
+// of them if limit is zero or less, from the head of a queue, in the
+// order that they will be sent to subscribers. The messages stay in
+// the queue.
+func (s *Server) QueueMessages(destination string, limit int) ([]MessageInfo, error) {
+	if !isQueueDestination(destination) {
+		return nil, ErrNotQueue
+	}
+	var messages []MessageInfo
+	err := s.call(func(proc *requestProcessor) error {
+		return proc.qm.Find(destination).Iterate(func(f *frame.Frame) bool {
+			info := MessageInfo{
+				MessageId: f.Header.Get(frame.MessageId),
+				Header:    make([]HeaderEntry, 0, f.Header.Len()),
+				BodySize:  len(f.Body),
+			}
+			f.Header.Range(func(key, value string) bool {
+				info.Header = append(info.Header, HeaderEntry{key, value})
+				return true
+			})
+			messages = append(messages, info)
+			return limit <= 0 || len(messages) < limit
+		})
+	})
+	return messages, err
+}
+
+// Returns the subscriptions of connected clients.
+func (proc *requestProcessor) subscriptions() []SubscriptionInfo {
+	var infos []SubscriptionInfo
+	for _, subs := range proc.subs {
+		for sub := range subs {
+			infos = append(infos, SubscriptionInfo{
+				ConnectionId: sub.Conn().Id(),
+				Id:           sub.Id(),
+				Destination:  sub.Destination(),
+				Ack:          sub.Ack(),
+			})
+		}
+	}
+	return infos
+}
+
+// Orders connection ids, which are decimal numbers, numerically.
+func lessId(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type InspectSuite struct{}
+
+var _ = Suite(&InspectSuite{})
+
+func (s *InspectSuite) TestConnectionStats(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V12))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	for _, body := range []string{"one", "two"} {
+		c.Assert(conn.Send("/queue/work", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+
+	// frames are counted after they are written, so the client can
+	// see the last RECEIPT frame before it has been counted
+	var info ConnectionInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for info.FramesWritten < 3 && time.Now().Before(deadline) {
+		conns, err := serv.Connections()
+		c.Assert(err, IsNil)
+		c.Assert(conns, HasLen, 1)
+		info = conns[0]
+	}
+	c.Check(info.Version, Equals, "1.2")
+	c.Check(info.ConnectedAt.Before(start), Equals, false)
+	// CONNECT and two SEND frames, CONNECTED and two RECEIPT frames
+	c.Check(info.FramesRead, Equals, uint64(3))
+	c.Check(info.FramesWritten, Equals, uint64(3))
+	c.Check(info.BytesRead > 0, Equals, true)
+	c.Check(info.BytesWritten > 0, Equals, true)
+	c.Check(info.PendingWrites, Equals, 0)
+	c.Check(info.LastActivity.Before(info.ConnectedAt), Equals, false)
+}
+
+func (s *InspectSuite) TestConnection(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	subs := make(map[string]string)
+	for _, destination := range []string{"/queue/work", "/topic/news"} {
+		sub, err := conn.Subscribe(destination, stomp.AckAuto)
+		c.Assert(err, IsNil)
+		subs[sub.Id()] = destination
+	}
+	tx := conn.Begin()
+	defer tx.Abort()
+	// wait for the frames to be processed
+	c.Assert(conn.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(conns[0].Transactions, Equals, 1)
+
+	for _, id := range []string{conns[0].Id, conns[0].RemoteAddr} {
+		info, infos, err := serv.Connection(id)
+		c.Assert(err, IsNil)
+		c.Check(info.Id, Equals, conns[0].Id)
+		c.Check(info.Subscriptions, Equals, 2)
+		c.Check(info.Transactions, Equals, 1)
+		c.Assert(infos, HasLen, 2)
+		for _, sub := range infos {
+			c.Check(sub.ConnectionId, Equals, info.Id)
+			c.Check(sub.Destination, Equals, subs[sub.Id])
+		}
+	}
+	_, _, err = serv.Connection("unknown")
+	c.Check(err, Equals, ErrNoClient)
+}
@@ -0,0 +1,579 @@
+/*
+Package journal provides persistent queue storage for the STOMP server,
+based on an append-only journal.
+
+Each frame added to a queue, and each acknowledgement of a frame, is
+appended to the journal. The journal is split into segment files, and
+a new segment is started once the current one reaches a size limit.
+When the journal is opened, its segments are replayed to reconstruct
+the contents of the queues. Frames that were sent to a client but not
+acknowledged are returned to their queues with the "redelivered:true"
+header.
+
+Segments are removed by compaction once every frame they contain has
+been acknowledged. Segments are removed oldest first, so that a segment
+is never removed while an older segment contains a frame that was
+acknowledged in it. Compaction also discards frames that exceed the
+retention settings of the journal, which bounds the growth of the
+journal when queues are not consumed.
+*/
+package journal
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// Returned by operations on a journal that has been closed.
+var ErrClosed = errors.New("journal is closed")
+
+// Default maximum size of a journal segment file.
+const DefaultSegmentSize = 64 << 20
+
+// File name suffix of journal segment files.
+const segmentSuffix = ".journal"
+
+// Options for opening a journal.
+type Options struct {
+	// Directory containing the segment files. It is created
+	// if it does not exist.
+	Dir string
+
+	// Size in bytes after which a new segment is started. If zero,
+	// DefaultSegmentSize is used.
+	SegmentSize int64
+
+	// If true, segment files are not synced to disk after each write.
+	// This is faster, but records can be lost if the operating
+	// system crashes, unless Sync is called. Set this if the server
+	// decides when to write to disk, based on the durability of
+	// each destination.
+	NoSync bool
+
+	// Limits on the frames kept in each queue, which are applied when
+	// the journal is compacted. Frames replayed when the journal is
+	// opened are treated as added when their segment was last written.
+	Retention queue.Retention
+}
+
+// A frame in a queue, and the id of its journal record.
+type entry struct {
+	id    uint64
+	queue string
+	frame *frame.Frame
+	added time.Time
+}
+
+// A journal segment file.
+type segment struct {
+	seq  uint64 // sequence number, which determines the file name
+	live int    // number of frames in the segment not yet acknowledged
+}
+
+// Journal is a persistent implementation of the queue storage interface.
+//
+// A Journal is not safe for concurrent use, which matches the way the
+// server uses queue storage.
+type Journal struct {
+	opts     Options
+	queues   map[string]*list.List   // frames in each queue
+	inflight map[*frame.Frame]*entry // frames waiting for acknowledgement
+	ids      map[uint64]*segment     // segment of each frame not yet acknowledged
+	segments []*segment              // in order, the last is being written
+	file     *os.File                // last segment file
+	size     int64                   // size of the last segment file
+	nextId   uint64
+	depth    int       // depth of nested batches
+	pending  []*record // records in the current batch
+	batch    []byte    // encoded records in the current batch
+}
+
+// Open the journal in the directory specified by opts, replaying
+// any existing segments.
+func Open(opts Options) (*Journal, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		opts:     opts,
+		queues:   make(map[string]*list.List),
+		inflight: make(map[*frame.Frame]*entry),
+		ids:      make(map[uint64]*segment),
+		nextId:   1,
+	}
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+	if _, err := j.Compact(); err != nil {
+		j.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Reads every segment in order to reconstruct the queues.
+func (j *Journal) replay() error {
+	seqs, err := j.segmentSeqs()
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[uint64]*list.Element)
+	for i, seq := range seqs {
+		seg := &segment{seq: seq}
+		j.segments = append(j.segments, seg)
+		size, err := j.replaySegment(seg, byId)
+		if err == nil || err == errCorruptRecord {
+			if info, statErr := os.Stat(j.segmentPath(seq)); statErr == nil {
+				j.setAdded(seg, byId, info.ModTime())
+			}
+		}
+		if err == errCorruptRecord && i == len(seqs)-1 {
+			// the last record was not completely written before
+			// a crash, so discard it
+			err = os.Truncate(j.segmentPath(seq), size)
+		}
+		if err != nil {
+			return err
+		}
+		j.size = size
+	}
+
+	if len(j.segments) == 0 {
+		return j.startSegment(1)
+	}
+	last := j.segments[len(j.segments)-1]
+	j.file, err = os.OpenFile(j.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Replays the records in a segment. Returns the size of the
+// segment up to the end of the last valid record.
+func (j *Journal) replaySegment(seg *segment, byId map[uint64]*list.Element) (int64, error) {
+	file, err := os.Open(j.segmentPath(seg.seq))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		r, n, err := readRecord(reader)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+		size += n
+		if r.typ == batchRecord {
+			for _, r := range r.records {
+				j.replayRecord(seg, r, byId)
+			}
+		} else {
+			j.replayRecord(seg, r, byId)
+		}
+	}
+}
+
+// Applies a record to the queues while replaying a segment.
+func (j *Journal) replayRecord(seg *segment, r *record, byId map[uint64]*list.Element) {
+	if r.id >= j.nextId {
+		j.nextId = r.id + 1
+	}
+
+	switch r.typ {
+	case enqueueRecord, requeueRecord:
+		l := j.find(r.queue)
+		e := &entry{id: r.id, queue: r.queue, frame: r.frame}
+		if r.typ == enqueueRecord {
+			byId[r.id] = l.PushBack(e)
+		} else {
+			byId[r.id] = l.PushFront(e)
+		}
+		j.ids[r.id] = seg
+		seg.live++
+	case ackRecord:
+		if e, ok := byId[r.id]; ok {
+			j.remove(e.Value.(*entry).queue, e)
+			delete(byId, r.id)
+		}
+		j.release(r.id)
+	case dispatchRecord:
+		// the frame was sent to a client, and the server stopped
+		// before the client acknowledged it
+		if e, ok := byId[r.id]; ok {
+			e.Value.(*entry).frame.Header.Set(frame.Redelivered, "true")
+		}
+	}
+}
+
+// Sets the time that frames in the segment were added, for frames
+// replayed from the segment that are still in a queue.
+func (j *Journal) setAdded(seg *segment, byId map[uint64]*list.Element, added time.Time) {
+	for id, e := range byId {
+		if j.ids[id] == seg {
+			e.Value.(*entry).added = added
+		}
+	}
+}
+
+// Returns the sequence numbers of the segment files, in order.
+func (j *Journal) segmentSeqs() ([]uint64, error) {
+	infos, err := ioutil.ReadDir(j.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(name, "%016x"+segmentSuffix, &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(a, b int) bool { return seqs[a] < seqs[b] })
+	return seqs, nil
+}
+
+func (j *Journal) segmentPath(seq uint64) string {
+	return filepath.Join(j.opts.Dir, fmt.Sprintf("%016x", seq)+segmentSuffix)
+}
+
+// Creates a new segment file, which becomes the segment being written.
+func (j *Journal) startSegment(seq uint64) error {
+	file, err := os.OpenFile(j.segmentPath(seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		// records in earlier segments must be on disk once Sync returns
+		if j.opts.NoSync {
+			if err = j.file.Sync(); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		j.file.Close()
+	}
+	j.file = file
+	j.size = 0
+	j.segments = append(j.segments, &segment{seq: seq})
+	return nil
+}
+
+// Appends a record to the journal. If a batch is in progress, the
+// record is written when the batch ends.
+func (j *Journal) append(r *record) error {
+	if j.file == nil {
+		return ErrClosed
+	}
+	if j.depth == 0 {
+		return j.write(r, []*record{r})
+	}
+
+	// encode straight away, as the frame can be modified
+	// once it has been sent to a client
+	b, err := r.encode()
+	if err != nil {
+		return err
+	}
+	j.batch = append(j.batch, b...)
+	j.pending = append(j.pending, r)
+	return nil
+}
+
+// Writes a record, starting a new segment if the current segment is
+// full, and then updates the segments for the records that it contains.
+func (j *Journal) write(r *record, records []*record) error {
+	if j.size >= j.opts.SegmentSize {
+		last := j.segments[len(j.segments)-1]
+		if err := j.startSegment(last.seq + 1); err != nil {
+			return err
+		}
+		if _, err := j.removeSegments(); err != nil {
+			return err
+		}
+	}
+
+	b, err := r.encode()
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(b); err != nil {
+		return err
+	}
+	j.size += int64(len(b))
+	if !j.opts.NoSync {
+		if err = j.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	seg := j.segments[len(j.segments)-1]
+	for _, r := range records {
+		switch r.typ {
+		case enqueueRecord, requeueRecord:
+			j.ids[r.id] = seg
+			seg.live++
+		case ackRecord:
+			j.release(r.id)
+		}
+	}
+	return nil
+}
+
+// BeginBatch starts a batch of changes, which are written to the
+// journal as a single record when the matching call to EndBatch is
+// made. If the server crashes first, none of the changes are replayed.
+func (j *Journal) BeginBatch() {
+	j.depth++
+}
+
+// EndBatch ends a batch of changes, and writes them to the journal
+// if it is the outermost batch.
+func (j *Journal) EndBatch() error {
+	if j.depth == 0 {
+		return nil
+	}
+	j.depth--
+	if j.depth > 0 || len(j.pending) == 0 {
+		return nil
+	}
+
+	records := j.pending
+	r := &record{typ: batchRecord, batch: j.batch}
+	j.pending, j.batch = nil, nil
+	if j.file == nil {
+		return ErrClosed
+	}
+	return j.write(r, records)
+}
+
+// Records that the frame with the id has been acknowledged.
+func (j *Journal) release(id uint64) {
+	if seg, ok := j.ids[id]; ok {
+		seg.live--
+		delete(j.ids, id)
+	}
+}
+
+// Compact discards frames that exceed the retention settings, and then
+// removes the oldest segments for as long as every frame they contain
+// has been acknowledged or discarded. The segment being written is never
+// removed. Segments are also removed whenever a new segment is started.
+func (j *Journal) Compact() (queue.CompactStats, error) {
+	var stats queue.CompactStats
+	if j.file == nil {
+		return stats, ErrClosed
+	}
+
+	j.BeginBatch()
+	now := time.Now()
+	var err error
+	for name, l := range j.queues {
+		var bytes int64
+		for e := l.Front(); e != nil; e = e.Next() {
+			bytes += int64(len(e.Value.(*entry).frame.Body))
+		}
+		for e := l.Front(); e != nil && err == nil; e = l.Front() {
+			en := e.Value.(*entry)
+			if !j.opts.Retention.Exceeded(en.added, bytes, now) {
+				break
+			}
+			// an acknowledgement removes the frame when replayed
+			if err = j.append(&record{typ: ackRecord, id: en.id}); err == nil {
+				bytes -= int64(len(en.frame.Body))
+				j.remove(name, e)
+				stats.Discarded++
+			}
+		}
+	}
+	if endErr := j.EndBatch(); err == nil {
+		err = endErr
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ReclaimedBytes, err = j.removeSegments()
+	return stats, err
+}
+
+// Removes the oldest segments that contain no frames waiting to be
+// acknowledged, and returns the total size of the removed files.
+func (j *Journal) removeSegments() (int64, error) {
+	var reclaimed int64
+	for len(j.segments) > 1 && j.segments[0].live == 0 {
+		path := j.segmentPath(j.segments[0].seq)
+		if info, err := os.Stat(path); err == nil {
+			reclaimed += info.Size()
+		}
+		if err := os.Remove(path); err != nil {
+			return reclaimed, err
+		}
+		j.segments = j.segments[1:]
+	}
+	return reclaimed, nil
+}
+
+// Sync writes the records appended to the journal to disk. This is
+// only required if the NoSync option is set. During a batch, records
+// are not written until the batch ends.
+func (j *Journal) Sync() error {
+	if j.file == nil {
+		return ErrClosed
+	}
+	return j.file.Sync()
+}
+
+// Segments returns the number of segment files in the journal.
+func (j *Journal) Segments() int {
+	return len(j.segments)
+}
+
+// Close the journal.
+func (j *Journal) Close() error {
+	if j.file == nil {
+		return ErrClosed
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *Journal) find(queue string) *list.List {
+	l, ok := j.queues[queue]
+	if !ok {
+		l = list.New()
+		j.queues[queue] = l
+	}
+	return l
+}
+
+func (j *Journal) remove(queue string, e *list.Element) {
+	l, ok := j.queues[queue]
+	if !ok {
+		return
+	}
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(j.queues, queue)
+	}
+}
+
+// Enqueue adds a frame to the tail of the queue.
+func (j *Journal) Enqueue(queue string, f *frame.Frame) error {
+	id := j.nextId
+	if err := j.append(&record{typ: enqueueRecord, id: id, queue: queue, frame: f}); err != nil {
+		return err
+	}
+	j.nextId++
+	j.find(queue).PushBack(&entry{id: id, queue: queue, frame: f, added: time.Now()})
+	return nil
+}
+
+// Requeue adds a frame to the head of the queue. If the frame was
+// dequeued from the journal, it is already recorded and is not
+// written again.
+func (j *Journal) Requeue(queue string, f *frame.Frame) error {
+	e, ok := j.inflight[f]
+	if ok {
+		delete(j.inflight, f)
+	} else {
+		e = &entry{id: j.nextId, queue: queue, frame: f, added: time.Now()}
+		if err := j.append(&record{typ: requeueRecord, id: e.id, queue: queue, frame: f}); err != nil {
+			return err
+		}
+		j.nextId++
+	}
+	j.find(queue).PushFront(e)
+	return nil
+}
+
+// Dequeue removes the frame at the head of the queue. The frame remains
+// in the journal until it is acknowledged, and is returned to the queue
+// with the "redelivered:true" header if the journal is replayed first.
+// Returns nil if the queue is empty.
+func (j *Journal) Dequeue(queue string) (*frame.Frame, error) {
+	l, ok := j.queues[queue]
+	if !ok {
+		return nil, nil
+	}
+	front := l.Front()
+	if front == nil {
+		return nil, nil
+	}
+	e := front.Value.(*entry)
+	if err := j.append(&record{typ: dispatchRecord, id: e.id}); err != nil {
+		return nil, err
+	}
+	j.remove(queue, front)
+	j.inflight[e.frame] = e
+	return e.frame, nil
+}
+
+// Ack records that a frame dequeued from the journal has been
+// acknowledged. Frames not dequeued from the journal are ignored.
+func (j *Journal) Ack(queue string, f *frame.Frame) error {
+	e, ok := j.inflight[f]
+	if !ok {
+		return nil
+	}
+	if err := j.append(&record{typ: ackRecord, id: e.id}); err != nil {
+		return err
+	}
+	delete(j.inflight, f)
+	return nil
+}
+
+// Len returns the number of frames in the queue, not including frames
+// that are waiting for acknowledgement.
+func (j *Journal) Len(queue string) int {
+	if l, ok := j.queues[queue]; ok {
+		return l.Len()
+	}
+	return 0
+}
+
+// Iterate calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false.
+func (j *Journal) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	if l, ok := j.queues[queue]; ok {
+		for e := l.Front(); e != nil; e = e.Next() {
+			if !fn(e.Value.(*entry).frame) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Start has no effect, as the journal is ready to use once opened.
+func (j *Journal) Start() {
+}
+
+// Stop closes the journal.
+func (j *Journal) Stop() {
+	_ = j.Close()
+}
@@ -0,0 +1,226 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/queue/queuetest"
+	. "gopkg.in/check.v1"
+)
+
+func TestJournal(t *testing.T) {
+	TestingT(t)
+}
+
+type JournalSuite struct{}
+
+var _ = Suite(&JournalSuite{})
+
+// Journal implements the queue storage interfaces.
+var (
+	_ queue.CompactStorage = (*Journal)(nil)
+	_ queue.SyncStorage    = (*Journal)(nil)
+)
+
+func (s *JournalSuite) TestReplay(c *C) {
+	dir := c.MkDir()
+	j, err := Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+
+	for _, body := range []string{"1", "2", "3", "4"} {
+		c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+	c.Assert(j.Requeue("/queue/test", queuetest.NewFrame("0")), IsNil)
+	c.Assert(j.Enqueue("/queue/other", queuetest.NewFrame("other")), IsNil)
+
+	// acknowledged frames are removed, unacknowledged frames are kept
+	f0, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f1, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(j.Ack("/queue/test", f0), IsNil)
+	c.Check(string(f1.Body), Equals, "1")
+	f2, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(j.Requeue("/queue/test", f2), IsNil)
+	c.Check(j.Len("/queue/test"), Equals, 3)
+	c.Assert(j.Close(), IsNil)
+	c.Check(j.Enqueue("/queue/test", queuetest.NewFrame("closed")), Equals, ErrClosed)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Check(j.Len("/queue/test"), Equals, 4)
+	var bodies []string
+	c.Assert(j.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return true
+	}), IsNil)
+	c.Check(bodies, DeepEquals, []string{"1", "2", "3", "4"})
+
+	// frames that were sent to a client might have been delivered
+	f, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+	f, err = j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+	f, err = j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "")
+
+	c.Check(queuetest.DequeueBodies(c, j, "/queue/other"), DeepEquals, []string{"other"})
+}
+
+func (s *JournalSuite) TestTruncatedRecord(c *C) {
+	dir := c.MkDir()
+	j, err := Open(Options{Dir: dir, NoSync: true})
+	c.Assert(err, IsNil)
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	// simulate a crash while writing the last record
+	path := filepath.Join(dir, "0000000000000001"+segmentSuffix)
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.Truncate(path, info.Size()-3), IsNil)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Check(queuetest.DequeueBodies(c, j, "/queue/test"), DeepEquals, []string{"1", "3"})
+}
+
+func (s *JournalSuite) TestCompact(c *C) {
+	dir := c.MkDir()
+	j, err := Open(Options{Dir: dir, SegmentSize: 1, NoSync: true})
+	c.Assert(err, IsNil)
+
+	// every record starts a new segment
+	for _, body := range []string{"1", "2", "3"} {
+		c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+	c.Check(j.Segments(), Equals, 3)
+
+	// the second segment cannot be removed before the first
+	f1, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f2, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(j.Ack("/queue/test", f2), IsNil)
+	c.Check(j.Segments(), Equals, 6)
+	c.Assert(j.Ack("/queue/test", f1), IsNil)
+	c.Check(j.Segments(), Equals, 7)
+	stats, err := j.Compact()
+	c.Assert(err, IsNil)
+	c.Check(j.Segments(), Equals, 5)
+	c.Check(stats.Discarded, Equals, 0)
+	c.Check(stats.ReclaimedBytes > 0, Equals, true)
+	c.Assert(j.Close(), IsNil)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Check(queuetest.DequeueBodies(c, j, "/queue/test"), DeepEquals, []string{"3"})
+}
+
+func (s *JournalSuite) TestRetention(c *C) {
+	dir := c.MkDir()
+	retention := queue.Retention{MaxBytes: 2}
+	j, err := Open(Options{Dir: dir, SegmentSize: 1, NoSync: true, Retention: retention})
+	c.Assert(err, IsNil)
+
+	for _, body := range []string{"1", "2", "3", "4"} {
+		c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+	c.Assert(j.Enqueue("/queue/other", queuetest.NewFrame("other")), IsNil)
+
+	// frames waiting for acknowledgement are not discarded
+	f, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	stats, err := j.Compact()
+	c.Assert(err, IsNil)
+	c.Check(stats.Discarded, Equals, 2)
+	c.Check(j.Len("/queue/test"), Equals, 2)
+	c.Check(j.Len("/queue/other"), Equals, 0)
+	c.Assert(j.Ack("/queue/test", f), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Check(queuetest.DequeueBodies(c, j, "/queue/test"), DeepEquals, []string{"3", "4"})
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("5")), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	// frames replayed from old segments are discarded by age
+	time.Sleep(20 * time.Millisecond)
+	j, err = Open(Options{Dir: dir, Retention: queue.Retention{MaxAge: 10 * time.Millisecond}})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Check(j.Len("/queue/test"), Equals, 0)
+}
+
+func (s *JournalSuite) TestBatch(c *C) {
+	dir := c.MkDir()
+	j, err := Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+
+	j.BeginBatch()
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	j.BeginBatch()
+	c.Assert(j.Enqueue("/queue/other", queuetest.NewFrame("3")), IsNil)
+	f, err := j.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(j.Ack("/queue/test", f), IsNil)
+	c.Assert(j.EndBatch(), IsNil)
+
+	// changes take effect straight away, but are not written
+	// until the outermost batch ends
+	c.Check(j.Len("/queue/test"), Equals, 1)
+	recovered, err := Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Check(recovered.Len("/queue/test"), Equals, 1)
+	c.Check(recovered.Len("/queue/other"), Equals, 0)
+	c.Assert(recovered.Close(), IsNil)
+
+	c.Assert(j.EndBatch(), IsNil)
+	recovered, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Check(queuetest.DequeueBodies(c, recovered, "/queue/test"), DeepEquals, []string{"2"})
+	c.Check(queuetest.DequeueBodies(c, recovered, "/queue/other"), DeepEquals, []string{"3"})
+	c.Assert(recovered.Close(), IsNil)
+}
+
+func (s *JournalSuite) TestTruncatedBatch(c *C) {
+	dir := c.MkDir()
+	j, err := Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	j.BeginBatch()
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(j.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	c.Assert(j.EndBatch(), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	// a batch that was not completely written is discarded
+	path := filepath.Join(dir, "0000000000000001"+segmentSuffix)
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.Truncate(path, info.Size()-3), IsNil)
+
+	j, err = Open(Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Check(j.Len("/queue/test"), Equals, 0)
+}
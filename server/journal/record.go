@@ -0,0 +1,140 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Types of journal record.
+const (
+	enqueueRecord  byte = 1 // frame added to the tail of a queue
+	requeueRecord  byte = 2 // frame added to the head of a queue
+	ackRecord      byte = 3 // frame removed from a queue
+	dispatchRecord byte = 4 // frame sent to a client
+	batchRecord    byte = 5 // records written as a single unit
+)
+
+// Size of the header preceding each record: the length and the
+// CRC-32 checksum of the record.
+const recordHeaderSize = 8
+
+// Returned when the journal contains a record that is incomplete
+// or fails its checksum, as happens if the server crashes while
+// the record is being written.
+var errCorruptRecord = errors.New("corrupt journal record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A record in the journal. Enqueue and requeue records contain
+// the queue and frame, ack and dispatch records contain only the id,
+// and batch records contain other encoded records.
+type record struct {
+	typ     byte
+	id      uint64
+	queue   string
+	frame   *frame.Frame
+	batch   []byte    // encoded records, when writing a batch record
+	records []*record // decoded records, when reading a batch record
+}
+
+// Encodes the record, including its header.
+func (r *record) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recordHeaderSize))
+	buf.WriteByte(r.typ)
+	writeUvarint(&buf, r.id)
+	if r.typ == enqueueRecord || r.typ == requeueRecord {
+		writeUvarint(&buf, uint64(len(r.queue)))
+		buf.WriteString(r.queue)
+		if err := frame.NewWriter(&buf).Write(r.frame); err != nil {
+			return nil, err
+		}
+	} else if r.typ == batchRecord {
+		buf.Write(r.batch)
+	}
+
+	b := buf.Bytes()
+	payload := b[recordHeaderSize:]
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(payload, crcTable))
+	return b, nil
+}
+
+// Reads the next record, and returns it with its size including the
+// header. Returns io.EOF at the end of the journal segment, and
+// errCorruptRecord if the record is incomplete or does not match
+// its checksum.
+func readRecord(reader *bufio.Reader) (*record, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errCorruptRecord
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+
+	r, err := decodeRecord(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, int64(recordHeaderSize + len(payload)), nil
+}
+
+// Decodes the record payload, which follows the header.
+func decodeRecord(payload []byte) (*record, error) {
+	r := &record{}
+	buf := bytes.NewReader(payload)
+	var err error
+	if r.typ, err = buf.ReadByte(); err != nil {
+		return nil, errCorruptRecord
+	}
+	if r.id, err = binary.ReadUvarint(buf); err != nil {
+		return nil, errCorruptRecord
+	}
+	if r.typ == ackRecord || r.typ == dispatchRecord {
+		return r, nil
+	}
+	if r.typ == batchRecord {
+		reader := bufio.NewReader(buf)
+		for {
+			record, _, err := readRecord(reader)
+			if err == io.EOF {
+				return r, nil
+			}
+			if err != nil {
+				return nil, errCorruptRecord
+			}
+			r.records = append(r.records, record)
+		}
+	}
+
+	n, err := binary.ReadUvarint(buf)
+	if err != nil || n > uint64(buf.Len()) {
+		return nil, errCorruptRecord
+	}
+	queue := make([]byte, n)
+	buf.Read(queue)
+	r.queue = string(queue)
+	if r.frame, err = frame.NewReader(buf).Read(); err != nil || r.frame == nil {
+		return nil, errCorruptRecord
+	}
+	return r, nil
+}
+
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, x)])
+}
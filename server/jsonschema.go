@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keywords of JSON Schema that JSONSchema does not implement. A schema
+// that uses them is rejected, rather than silently accepting messages
+// that it should reject. Other unknown keywords, such as title and
+// description, are ignored, as the specification requires.
+var unsupportedSchemaKeywords = []string{
+	"$ref", "$dynamicRef", "patternProperties", "propertyNames",
+	"dependencies", "dependentRequired", "dependentSchemas",
+	"if", "then", "else", "contains", "prefixItems", "uniqueItems",
+	"unevaluatedItems", "unevaluatedProperties", "multipleOf",
+}
+
+// A JSONSchema is a PayloadValidator that accepts bodies that are JSON
+// documents valid for a JSON Schema. It implements the keywords that
+// describe the structure of messages: type, enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems,
+// minProperties, maxProperties, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and
+// not. Schemas that refer to other schemas with $ref are not supported.
+//
+// Every problem found in a body is reported, with the JSON pointer of
+// the value at fault, such as "/items/0/price: expected number, got
+// string".
+type JSONSchema struct {
+	always     *bool // if non-nil, the schema is true or false
+	types      []string
+	enum       []interface{}
+	constant   interface{}
+	hasConst   bool
+	properties map[string]*JSONSchema
+	required   []string
+	additional *JSONSchema // for properties not in properties
+	items      *JSONSchema
+	minItems   int // -1 if unset, as for the other limits
+	maxItems   int
+	minProps   int
+	maxProps   int
+	minLength  int
+	maxLength  int
+	pattern    *regexp.Regexp
+	minimum    *float64
+	maximum    *float64
+	exclMin    *float64
+	exclMax    *float64
+	allOf      []*JSONSchema
+	anyOf      []*JSONSchema
+	oneOf      []*JSONSchema
+	not        *JSONSchema
+}
+
+// ParseJSONSchema parses a JSON Schema, see JSONSchema.
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("json schema: %v", err)
+	}
+	schema, err := compileSchema(v, "")
+	if err != nil {
+		return nil, fmt.Errorf("json schema: %v", err)
+	}
+	return schema, nil
+}
+
+func compileSchema(v interface{}, path string) (*JSONSchema, error) {
+	if b, ok := v.(bool); ok {
+		return &JSONSchema{always: &b}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema is not an object or a boolean", pointer(path))
+	}
+	for _, keyword := range unsupportedSchemaKeywords {
+		if _, ok := m[keyword]; ok {
+			return nil, fmt.Errorf("%s: keyword %q is not supported", pointer(path), keyword)
+		}
+	}
+
+	s := &JSONSchema{minItems: -1, maxItems: -1, minProps: -1, maxProps: -1, minLength: -1, maxLength: -1}
+	var err error
+	if t, ok := m["type"]; ok {
+		if s.types, err = stringList(t); err != nil {
+			return nil, fmt.Errorf("%s/type: %v", path, err)
+		}
+	}
+	if e, ok := m["enum"]; ok {
+		if s.enum, ok = e.([]interface{}); !ok {
+			return nil, fmt.Errorf("%s/enum: not an array", path)
+		}
+	}
+	s.constant, s.hasConst = m["const"]
+	if p, ok := m["properties"]; ok {
+		props, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: not an object", path)
+		}
+		s.properties = make(map[string]*JSONSchema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = compileSchema(prop, path+"/properties/"+escapePointer(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r, ok := m["required"]; ok {
+		if s.required, err = stringList(r); err != nil {
+			return nil, fmt.Errorf("%s/required: %v", path, err)
+		}
+	}
+	if a, ok := m["additionalProperties"]; ok {
+		if s.additional, err = compileSchema(a, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if i, ok := m["items"]; ok {
+		if s.items, err = compileSchema(i, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, limit := range map[string]*int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minProperties": &s.minProps, "maxProperties": &s.maxProps,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if l, ok := m[keyword]; ok {
+			n, ok := l.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s/%s: not a non-negative integer", path, keyword)
+			}
+			*limit = int(n)
+		}
+	}
+	if p, ok := m["pattern"]; ok {
+		pattern, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: not a string", path)
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %v", path, err)
+		}
+	}
+	for keyword, limit := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclMin, "exclusiveMaximum": &s.exclMax,
+	} {
+		if l, ok := m[keyword]; ok {
+			n, ok := l.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: not a number", path, keyword)
+			}
+			*limit = &n
+		}
+	}
+	for keyword, list := range map[string]*[]*JSONSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		if l, ok := m[keyword]; ok {
+			schemas, ok := l.([]interface{})
+			if !ok || len(schemas) == 0 {
+				return nil, fmt.Errorf("%s/%s: not a non-empty array", path, keyword)
+			}
+			for i, schema := range schemas {
+				compiled, err := compileSchema(schema, path+"/"+keyword+"/"+strconv.Itoa(i))
+				if err != nil {
+					return nil, err
+				}
+				*list = append(*list, compiled)
+			}
+		}
+	}
+	if n, ok := m["not"]; ok {
+		if s.not, err = compileSchema(n, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Returns a string, or an array of strings, as a list.
+func stringList(v interface{}) ([]string, error) {
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a string or an array of strings")
+	}
+	list := make([]string, len(values))
+	for i, value := range values {
+		if list[i], ok = value.(string); !ok {
+			return nil, fmt.Errorf("not a string or an array of strings")
+		}
+	}
+	return list, nil
+}
+
+// ValidatePayload accepts a body that is a JSON document valid for the
+// schema, or returns a *PayloadError listing the problems found.
+func (s *JSONSchema) ValidatePayload(destination, contentType string, body []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	err := d.Decode(&v)
+	if err == nil && d.More() {
+		err = fmt.Errorf("invalid JSON: more than one value")
+	}
+	if err != nil {
+		return &PayloadError{Destination: destination, Problems: []string{jsonProblem(err)}}
+	}
+	if problems := s.validate(v, "", nil); len(problems) > 0 {
+		return &PayloadError{Destination: destination, Problems: problems}
+	}
+	return nil
+}
+
+// Appends the problems of value v at path to problems.
+func (s *JSONSchema) validate(v interface{}, path string, problems []string) []string {
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, pointer(path)+": "+fmt.Sprintf(format, args...))
+	}
+	if s.always != nil {
+		if !*s.always {
+			problem("not allowed")
+		}
+		return problems
+	}
+
+	if len(s.types) > 0 && !s.hasType(v) {
+		problem("expected %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+		// the other keywords would only report the same problem
+		return problems
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		problem("not one of the values of the enum")
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		problem("not the constant value")
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				problem("missing property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propPath := path + "/" + escapePointer(name)
+			if prop, ok := s.properties[name]; ok {
+				problems = prop.validate(v[name], propPath, problems)
+			} else if s.additional != nil {
+				if s.additional.always != nil && !*s.additional.always {
+					problems = append(problems, pointer(propPath)+": unexpected property")
+				} else {
+					problems = s.additional.validate(v[name], propPath, problems)
+				}
+			}
+		}
+		if s.minProps >= 0 && len(v) < s.minProps {
+			problem("fewer than %d properties", s.minProps)
+		}
+		if s.maxProps >= 0 && len(v) > s.maxProps {
+			problem("more than %d properties", s.maxProps)
+		}
+	case []interface{}:
+		if s.minItems >= 0 && len(v) < s.minItems {
+			problem("fewer than %d items", s.minItems)
+		}
+		if s.maxItems >= 0 && len(v) > s.maxItems {
+			problem("more than %d items", s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				problems = s.items.validate(item, path+"/"+strconv.Itoa(i), problems)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength >= 0 && length < s.minLength {
+			problem("shorter than %d characters", s.minLength)
+		}
+		if s.maxLength >= 0 && length > s.maxLength {
+			problem("longer than %d characters", s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			problem("does not match pattern %q", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			problem("less than %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			problem("greater than %v", *s.maximum)
+		}
+		if s.exclMin != nil && v <= *s.exclMin {
+			problem("not greater than %v", *s.exclMin)
+		}
+		if s.exclMax != nil && v >= *s.exclMax {
+			problem("not less than %v", *s.exclMax)
+		}
+	}
+
+	for _, schema := range s.allOf {
+		problems = schema.validate(v, path, problems)
+	}
+	if len(s.anyOf) > 0 && countValid(s.anyOf, v) == 0 {
+		problem("not valid for any schema of anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countValid(s.oneOf, v); n != 1 {
+			problem("valid for %d schemas of oneOf, not exactly one", n)
+		}
+	}
+	if s.not != nil && len(s.not.validate(v, path, nil)) == 0 {
+		problem("valid for the schema of not")
+	}
+	return problems
+}
+
+func (s *JSONSchema) hasType(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range s.types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the number of schemas that v is valid for.
+func countValid(schemas []*JSONSchema, v interface{}) int {
+	n := 0
+	for _, schema := range schemas {
+		if len(schema.validate(v, "", nil)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the JSON Schema type of a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the JSON pointer of a path, which is "/" for the document.
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// Escapes a property name for a JSON pointer.
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
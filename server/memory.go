@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/go-stomp/stomp/v3/server/metrics"
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// Counts the bytes held by queue storage that keeps frames in memory,
+// as part of the bytes of messages held by the server.
+func (proc *requestProcessor) countQueueMemory() {
+	if m, ok := proc.qstore.(queue.MemoryStorage); ok {
+		bytes := m.MemoryBytes()
+		proc.memory.Add(bytes - proc.queueBytes)
+		proc.queueBytes = bytes
+	}
+}
+
+// Returns the metric families of the bytes of messages held in memory
+// and the limit, which are empty while the server is not serving.
+func (s *Server) memoryUsage() []metrics.Family {
+	used := metrics.Family{
+		Name: "stomp_memory_bytes",
+		Help: "Approximate bytes of messages held in queues, waiting to be written to clients and in transactions.",
+		Type: metrics.GaugeType,
+	}
+	limit := metrics.Family{
+		Name: "stomp_memory_limit_bytes",
+		Help: "Bytes of messages held above which clients sending messages are held up, zero for no limit.",
+		Type: metrics.GaugeType,
+	}
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc != nil {
+		used.Samples = []metrics.Sample{{Name: used.Name, Value: float64(proc.memory.Used())}}
+		limit.Samples = []metrics.Sample{{Name: limit.Name, Value: float64(proc.memory.Limit())}}
+	}
+	return []metrics.Family{used, limit}
+}
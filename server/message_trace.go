@@ -0,0 +1,135 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// Header entries of traced messages. A client requests tracing of a
+// message by sending it with the MessageTraceHeader set to any value,
+// and the messages sent to destinations whose policy sets
+// TraceMessages are always traced. The server sets the value of the
+// MessageTraceHeader to a trace id, which identifies the message in
+// the records passed to Server.MessageTraceSink, and adds entries with
+// the times that the message passed through the server, in RFC 3339
+// format with nanoseconds.
+const (
+	MessageTraceHeader    = client.MessageTraceHeader
+	TraceReceivedHeader   = "x-trace-received"           // received from the sending client
+	TraceEnqueuedHeader   = "x-trace-enqueued"           // added to its queue or topic
+	TraceDispatchedHeader = client.TraceDispatchedHeader // sent to the receiving client
+)
+
+// Events in the passage of a traced message through the server.
+const (
+	TraceReceived   = "received"   // received from the sending client
+	TraceEnqueued   = "enqueued"   // added to its queue or topic
+	TraceDispatched = "dispatched" // sent to a client, reported once the client acknowledges it
+	TraceAcked      = "acked"      // acknowledged by the receiving client
+	TraceRequeued   = "requeued"   // returned to its queue, after a NACK or disconnect
+	TraceExpired    = "expired"    // expired before it was sent to a client
+)
+
+// A MessageTraceRecord describes an event in the passage of a traced
+// message through the server. Messages sent to topics are not
+// acknowledged, so the times that they were sent to clients are only
+// recorded in their TraceDispatchedHeader.
+type MessageTraceRecord struct {
+	TraceId        string        // Value of the MessageTraceHeader
+	Event          string        // TraceReceived, TraceEnqueued, etc.
+	Time           time.Time     // Time of the event
+	Destination    string        // Destination of the message
+	MessageId      string        // Value of the message-id header, if the message has one yet
+	ConnectionId   string        // Connection of the sending or receiving client, if any
+	SubscriptionId string        // Client's id for the receiving subscription, if any
+	Header         *frame.Header // Copy of the header of the message
+}
+
+// MessageTraceSink is the interface for receiving the records of
+// traced messages. TraceMessage is called from a single go-routine, in
+// the order that the events were processed.
+type MessageTraceSink interface {
+	TraceMessage(record MessageTraceRecord)
+}
+
+// Buffered records waiting to be passed to a MessageTraceSink.
+const messageTraceBufferSize = 1024
+
+// Marks a message being enqueued as traced if the client or the policy
+// for its destination requests it, and records its receipt. Reports
+// whether the message is traced.
+func (proc *requestProcessor) traceReceived(r client.Request, destination string) bool {
+	h := r.Frame.Header
+	if _, ok := h.Contains(MessageTraceHeader); !ok {
+		policy := findPolicy(proc.server.Policies, destination)
+		if policy == nil || !policy.TraceMessages {
+			return false
+		}
+	}
+	id := r.Id
+	if id == "" {
+		// copied or forwarded by the server, so keep any trace id
+		id = h.Get(MessageTraceHeader)
+	}
+	h.Set(MessageTraceHeader, id)
+	received := r.Time
+	if received.IsZero() {
+		received = time.Now()
+	}
+	h.Set(TraceReceivedHeader, formatTraceTime(received))
+	proc.emitTrace(proc.traceRecord(TraceReceived, received, r.Frame, r.Conn, nil))
+	return true
+}
+
+// Returns a record of an event for a message, or nil if the message is
+// not traced or there is no sink for the records. The connection and
+// subscription are nil if there are none. The record is made before a
+// message is passed to a queue or a client, which can change its header.
+func (proc *requestProcessor) traceRecord(event string, t time.Time, f *frame.Frame, c *client.Conn, sub *client.Subscription) *MessageTraceRecord {
+	if proc.traces == nil {
+		return nil
+	}
+	id, ok := f.Header.Contains(MessageTraceHeader)
+	if !ok {
+		return nil
+	}
+	record := MessageTraceRecord{
+		TraceId:     id,
+		Event:       event,
+		Time:        t,
+		Destination: f.Header.Get(frame.Destination),
+		MessageId:   f.Header.Get(frame.MessageId),
+		Header:      f.Header.Clone(),
+	}
+	if sub != nil {
+		record.SubscriptionId = sub.Id()
+		c = sub.Conn()
+	}
+	if c != nil {
+		record.ConnectionId = c.Id()
+	}
+	return &record
+}
+
+// Passes a record to the sink, unless it is nil.
+func (proc *requestProcessor) emitTrace(record *MessageTraceRecord) {
+	if record != nil {
+		sink := proc.server.MessageTraceSink
+		proc.traces.Do(func() { sink.TraceMessage(*record) })
+	}
+}
+
+// Emits the records of a message acknowledged by a client, if the
+// message is traced.
+func (proc *requestProcessor) traceAcked(sub *client.Subscription, f *frame.Frame) {
+	if dispatched, err := time.Parse(time.RFC3339Nano, f.Header.Get(TraceDispatchedHeader)); err == nil {
+		proc.emitTrace(proc.traceRecord(TraceDispatched, dispatched, f, nil, sub))
+	}
+	proc.emitTrace(proc.traceRecord(TraceAcked, time.Now(), f, nil, sub))
+}
+
+func formatTraceTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
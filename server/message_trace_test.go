@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type MessageTraceSuite struct{}
+
+var _ = Suite(&MessageTraceSuite{})
+
+// Keeps the records passed to it, which are only read once the server
+// has stopped.
+type testTraceSink struct {
+	records []MessageTraceRecord
+}
+
+func (s *testTraceSink) TraceMessage(record MessageTraceRecord) {
+	s.records = append(s.records, record)
+}
+
+func (s *MessageTraceSuite) TestMessageTrace(c *C) {
+	sink := &testTraceSink{}
+	serv := &Server{
+		MessageTraceSink: sink,
+		Policies:         []DestinationPolicy{{Pattern: "/topic/prices", TraceMessages: true}},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	done := make(chan error)
+	go func() { done <- serv.Serve(l) }()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	orders, err := conn.Subscribe("/queue/orders", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	prices, err := conn.Subscribe("/topic/prices", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// traced at the client's request
+	c.Assert(conn.Send("/queue/orders", "text/plain", []byte("one"), stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(MessageTraceHeader, "yes")), IsNil)
+	msg := receive(c, orders)
+	traceId := msg.Header.Get(MessageTraceHeader)
+	c.Check(traceId, Not(Equals), "yes")
+	c.Check(traceId, Not(Equals), "")
+	var times []time.Time
+	for _, key := range []string{TraceReceivedHeader, TraceEnqueuedHeader, TraceDispatchedHeader} {
+		t, err := time.Parse(time.RFC3339Nano, msg.Header.Get(key))
+		c.Assert(err, IsNil, Commentf("%s", key))
+		times = append(times, t)
+	}
+	c.Check(times[0].After(times[1]), Equals, false)
+	c.Check(times[1].After(times[2]), Equals, false)
+	c.Assert(conn.Ack(msg), IsNil)
+
+	// traced by policy, and untraced
+	c.Assert(conn.Send("/topic/prices", "text/plain", []byte("two")), IsNil)
+	msg = receive(c, prices)
+	c.Check(msg.Header.Get(MessageTraceHeader), Not(Equals), "")
+	c.Check(msg.Header.Get(TraceDispatchedHeader), Not(Equals), "")
+	c.Assert(conn.Send("/queue/orders", "text/plain", []byte("three"), stomp.SendOpt.Receipt), IsNil)
+	msg = receive(c, orders)
+	_, ok := msg.Header.Contains(MessageTraceHeader)
+	c.Check(ok, Equals, false)
+	c.Assert(conn.Ack(msg), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+
+	c.Assert(serv.Shutdown(), IsNil)
+	<-done
+	var events []string
+	for _, record := range sink.records {
+		events = append(events, record.Event+" "+record.Destination)
+		if record.Destination == "/queue/orders" {
+			c.Check(record.TraceId, Equals, traceId)
+		}
+		c.Check(record.ConnectionId, Not(Equals), "")
+	}
+	c.Check(events, DeepEquals, []string{
+		"received /queue/orders",
+		"enqueued /queue/orders",
+		"dispatched /queue/orders",
+		"acked /queue/orders",
+		"received /topic/prices",
+		"enqueued /topic/prices",
+	})
+	c.Check(sink.records[2].Time.Format(time.RFC3339Nano), Equals, times[2].Format(time.RFC3339Nano))
+	c.Check(sink.records[3].SubscriptionId, Equals, orders.Id())
+	c.Check(sink.records[3].MessageId, Not(Equals), "")
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-stomp/stomp/v3/server/metrics"
+)
+
+// Collector returns a collector of the server's metrics: the
+// measurements recorded in Metrics, if it is not nil, and the number
+// of messages waiting in each queue and the bytes of messages held in
+// memory while the server is serving. Programs can register it with
+// their own Prometheus registry.
+func (s *Server) Collector() metrics.Collector {
+	return metrics.CollectorFunc(func() []metrics.Family {
+		families := append(s.Metrics.Collect(), s.queueDepths())
+		return append(families, s.memoryUsage()...)
+	})
+}
+
+// MetricsHandler returns an HTTP handler that serves the metrics of
+// Collector in the Prometheus text exposition format, for a "/metrics"
+// endpoint.
+func (s *Server) MetricsHandler() http.Handler {
+	return metrics.Handler(s.Collector())
+}
+
+func (s *Server) queueDepths() metrics.Family {
+	const name = "stomp_queue_depth"
+	family := metrics.Family{
+		Name: name,
+		Help: "Messages waiting to be sent to a subscriber, by queue.",
+		Type: metrics.GaugeType,
+	}
+	s.call(func(proc *requestProcessor) error {
+		for _, destination := range proc.qm.Destinations() {
+			family.Samples = append(family.Samples, metrics.Sample{
+				Name:   name,
+				Labels: []metrics.Label{{Name: "destination", Value: destination}},
+				Value:  float64(proc.qm.Find(destination).Len()),
+			})
+		}
+		return nil
+	})
+	sort.Slice(family.Samples, func(i, j int) bool {
+		return family.Samples[i].Labels[0].Value < family.Samples[j].Labels[0].Value
+	})
+	return family
+}
@@ -0,0 +1,290 @@
+/*
+Package metrics records measurements of the STOMP server, and exposes
+them in the Prometheus text exposition format.
+
+The measurements are returned by a Collector as metric families, which
+Handler serves over HTTP for a "/metrics" endpoint. Programs that have
+their own Prometheus registry can instead register a collector that
+converts the families returned by Collect into the registry's metrics,
+so this package does not depend on a Prometheus client library.
+*/
+package metrics
+
+import (
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Types of metric families.
+const (
+	CounterType   = "counter"
+	GaugeType     = "gauge"
+	HistogramType = "histogram"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of
+// the latency histograms.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Family is a set of samples of a metric.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string // CounterType, GaugeType or HistogramType
+	Samples []Sample
+}
+
+// A Sample is a value of a metric. The samples of a histogram have
+// the name of the family with the suffix "_bucket", "_sum" or "_count".
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// A Label distinguishes the samples of a family.
+type Label struct {
+	Name, Value string
+}
+
+// A Collector returns metric families when metrics are scraped.
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc is a function that can be used as a Collector.
+type CollectorFunc func() []Family
+
+// Collect calls f.
+func (f CollectorFunc) Collect() []Family {
+	return f()
+}
+
+// Commands that are counted separately. Frames with other commands are
+// counted as "OTHER", so that clients cannot create arbitrary samples.
+var commands = map[string]bool{
+	frame.CONNECT: true, frame.STOMP: true, frame.CONNECTED: true,
+	frame.SEND: true, frame.SUBSCRIBE: true, frame.UNSUBSCRIBE: true,
+	frame.ACK: true, frame.NACK: true, frame.BEGIN: true,
+	frame.COMMIT: true, frame.ABORT: true, frame.DISCONNECT: true,
+	frame.MESSAGE: true, frame.RECEIPT: true, frame.ERROR: true,
+}
+
+// Metrics records measurements of a server. The methods of a nil
+// *Metrics do nothing, so that measurements need not be checked for.
+type Metrics struct {
+	accepted  uint64 // connections accepted
+	active    int64  // connections not yet closed
+	bytesIn   uint64
+	bytesOut  uint64
+	timeouts  uint64 // heart-beat timeouts
+	rejected  uint64 // payloads rejected by validators
+	framesIn  frameCounts
+	framesOut frameCounts
+	dispatch  histogram
+	ack       histogram
+}
+
+// New returns metrics with no measurements.
+func New() *Metrics {
+	return &Metrics{
+		dispatch: histogram{buckets: DefaultBuckets, counts: make([]uint64, len(DefaultBuckets))},
+		ack:      histogram{buckets: DefaultBuckets, counts: make([]uint64, len(DefaultBuckets))},
+	}
+}
+
+// Conn returns a connection that counts the bytes read from and
+// written to c, and that is counted as an active connection until it
+// is closed.
+func (m *Metrics) Conn(c net.Conn) net.Conn {
+	if m == nil {
+		return c
+	}
+	atomic.AddUint64(&m.accepted, 1)
+	atomic.AddInt64(&m.active, 1)
+	return &countingConn{Conn: c, m: m}
+}
+
+// FrameReceived counts a frame received from a client.
+func (m *Metrics) FrameReceived(command string) {
+	if m != nil {
+		m.framesIn.add(command)
+	}
+}
+
+// FrameSent counts a frame sent to a client.
+func (m *Metrics) FrameSent(command string) {
+	if m != nil {
+		m.framesOut.add(command)
+	}
+}
+
+// HeartBeatTimeout counts a connection closed because the client
+// sent nothing within its heart-beat interval.
+func (m *Metrics) HeartBeatTimeout() {
+	if m != nil {
+		atomic.AddUint64(&m.timeouts, 1)
+	}
+}
+
+// PayloadRejected counts a message rejected because its body is not
+// valid for its destination.
+func (m *Metrics) PayloadRejected() {
+	if m != nil {
+		atomic.AddUint64(&m.rejected, 1)
+	}
+}
+
+// Dispatched records the time between a message being dispatched to a
+// subscription and it being sent to the client.
+func (m *Metrics) Dispatched(latency time.Duration) {
+	if m != nil {
+		m.dispatch.observe(latency.Seconds())
+	}
+}
+
+// Acked records the time between a message being sent to a client and
+// the client acknowledging it.
+func (m *Metrics) Acked(latency time.Duration) {
+	if m != nil {
+		m.ack.observe(latency.Seconds())
+	}
+}
+
+// Collect returns the measurements as metric families.
+func (m *Metrics) Collect() []Family {
+	if m == nil {
+		return nil
+	}
+	return []Family{
+		counter("stomp_connections_accepted_total", "Connections accepted.", atomic.LoadUint64(&m.accepted)),
+		{
+			Name:    "stomp_connections_active",
+			Help:    "Connections that have not been closed.",
+			Type:    GaugeType,
+			Samples: []Sample{{Name: "stomp_connections_active", Value: float64(atomic.LoadInt64(&m.active))}},
+		},
+		m.framesIn.family("stomp_frames_received_total", "Frames received from clients, by command."),
+		m.framesOut.family("stomp_frames_sent_total", "Frames sent to clients, by command."),
+		counter("stomp_received_bytes_total", "Bytes received from clients.", atomic.LoadUint64(&m.bytesIn)),
+		counter("stomp_sent_bytes_total", "Bytes sent to clients.", atomic.LoadUint64(&m.bytesOut)),
+		counter("stomp_heartbeat_timeouts_total", "Connections closed because the client missed heart-beats.",
+			atomic.LoadUint64(&m.timeouts)),
+		counter("stomp_payloads_rejected_total", "Messages rejected because their body is not valid for their destination.",
+			atomic.LoadUint64(&m.rejected)),
+		m.dispatch.family("stomp_dispatch_latency_seconds",
+			"Time between a message being dispatched to a subscription and it being sent to the client."),
+		m.ack.family("stomp_ack_latency_seconds",
+			"Time between a message being sent to a client and the client acknowledging it."),
+	}
+}
+
+func counter(name, help string, value uint64) Family {
+	return Family{
+		Name:    name,
+		Help:    help,
+		Type:    CounterType,
+		Samples: []Sample{{Name: name, Value: float64(value)}},
+	}
+}
+
+// Counts of frames by command.
+type frameCounts struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+func (fc *frameCounts) add(command string) {
+	if !commands[command] {
+		command = "OTHER"
+	}
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if fc.counts == nil {
+		fc.counts = make(map[string]uint64)
+	}
+	fc.counts[command]++
+}
+
+func (fc *frameCounts) family(name, help string) Family {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	family := Family{Name: name, Help: help, Type: CounterType}
+	for command, count := range fc.counts {
+		family.Samples = append(family.Samples,
+			Sample{Name: name, Labels: []Label{{"command", command}}, Value: float64(count)})
+	}
+	sort.Slice(family.Samples, func(i, j int) bool {
+		return family.Samples[i].Labels[0].Value < family.Samples[j].Labels[0].Value
+	})
+	return family
+}
+
+// A histogram of observations, with cumulative buckets.
+type histogram struct {
+	mutex   sync.Mutex
+	buckets []float64 // upper bounds
+	counts  []uint64  // observations in each bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) family(name, help string) Family {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	family := Family{Name: name, Help: help, Type: HistogramType}
+	cumulative := uint64(0)
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		family.Samples = append(family.Samples, Sample{
+			Name:   name + "_bucket",
+			Labels: []Label{{"le", formatFloat(bound)}},
+			Value:  float64(cumulative),
+		})
+	}
+	family.Samples = append(family.Samples,
+		Sample{Name: name + "_bucket", Labels: []Label{{"le", formatFloat(math.Inf(1))}}, Value: float64(h.count)},
+		Sample{Name: name + "_sum", Value: h.sum},
+		Sample{Name: name + "_count", Value: float64(h.count)})
+	return family
+}
+
+// A connection that counts bytes, and the connection when it closes.
+type countingConn struct {
+	net.Conn
+	m    *Metrics
+	once sync.Once
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.m.bytesIn, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.m.bytesOut, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.m.active, -1) })
+	return c.Conn.Close()
+}
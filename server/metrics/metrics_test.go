@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestMetrics(t *testing.T) {
+	TestingT(t)
+}
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+// Returns a family by name.
+func find(families []Family, name string) Family {
+	for _, family := range families {
+		if family.Name == name {
+			return family
+		}
+	}
+	return Family{}
+}
+
+func (s *MetricsSuite) TestNil(c *C) {
+	var m *Metrics
+	m.FrameReceived("SEND")
+	m.Acked(time.Second)
+	c.Check(m.Collect(), IsNil)
+	conn, _ := net.Pipe()
+	c.Check(m.Conn(conn), Equals, conn)
+}
+
+func (s *MetricsSuite) TestCollect(c *C) {
+	m := New()
+	m.FrameReceived("SEND")
+	m.FrameReceived("SEND")
+	m.FrameReceived("BOGUS")
+	m.HeartBeatTimeout()
+	m.PayloadRejected()
+	m.Acked(2 * time.Millisecond)
+	m.Acked(time.Minute)
+
+	local, remote := net.Pipe()
+	conn := m.Conn(local)
+	go remote.Write([]byte("hello"))
+	_, err := conn.Read(make([]byte, 5))
+	c.Assert(err, IsNil)
+	c.Check(find(m.Collect(), "stomp_connections_active").Samples[0].Value, Equals, 1.0)
+	conn.Close()
+	conn.Close()
+	remote.Close()
+
+	families := m.Collect()
+	c.Check(find(families, "stomp_connections_accepted_total").Samples[0].Value, Equals, 1.0)
+	c.Check(find(families, "stomp_connections_active").Samples[0].Value, Equals, 0.0)
+	c.Check(find(families, "stomp_received_bytes_total").Samples[0].Value, Equals, 5.0)
+	c.Check(find(families, "stomp_heartbeat_timeouts_total").Samples[0].Value, Equals, 1.0)
+	c.Check(find(families, "stomp_payloads_rejected_total").Samples[0].Value, Equals, 1.0)
+	c.Check(find(families, "stomp_frames_received_total").Samples, DeepEquals, []Sample{
+		{Name: "stomp_frames_received_total", Labels: []Label{{"command", "OTHER"}}, Value: 1},
+		{Name: "stomp_frames_received_total", Labels: []Label{{"command", "SEND"}}, Value: 2},
+	})
+
+	// buckets are cumulative, and the observation above the largest
+	// bucket is only counted by the +Inf bucket
+	samples := find(families, "stomp_ack_latency_seconds").Samples
+	c.Assert(samples, HasLen, len(DefaultBuckets)+3)
+	c.Check(samples[1], DeepEquals, Sample{Name: "stomp_ack_latency_seconds_bucket", Labels: []Label{{"le", "0.001"}}, Value: 0})
+	c.Check(samples[2].Value, Equals, 1.0)
+	c.Check(samples[len(DefaultBuckets)-1].Value, Equals, 1.0)
+	c.Check(samples[len(DefaultBuckets)], DeepEquals, Sample{Name: "stomp_ack_latency_seconds_bucket", Labels: []Label{{"le", "+Inf"}}, Value: 2})
+	c.Check(samples[len(DefaultBuckets)+1].Value, Equals, 60.002)
+	c.Check(samples[len(DefaultBuckets)+2].Value, Equals, 2.0)
+}
+
+func (s *MetricsSuite) TestWriteText(c *C) {
+	var buf bytes.Buffer
+	err := WriteText(&buf, []Family{
+		{
+			Name:    "queue_depth",
+			Help:    "Depth\nof queues",
+			Type:    GaugeType,
+			Samples: []Sample{{Name: "queue_depth", Labels: []Label{{"destination", `/queue/"a"`}, {"node", "x"}}, Value: 3}},
+		},
+		{
+			Name:    "accepted_total",
+			Type:    CounterType,
+			Samples: []Sample{{Name: "accepted_total", Value: 1.5}},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, `# HELP queue_depth Depth\nof queues
+# TYPE queue_depth gauge
+queue_depth{destination="/queue/\"a\"",node="x"} 3
+# TYPE accepted_total counter
+accepted_total 1.5
+`)
+}
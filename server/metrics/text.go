@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an HTTP handler that serves the metric families of
+// the collectors in the Prometheus text exposition format.
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var families []Family
+		for _, c := range collectors {
+			families = append(families, c.Collect()...)
+		}
+		w.Header().Set("Content-Type", ContentType)
+		WriteText(w, families)
+	})
+}
+
+// WriteText writes metric families in the Prometheus text exposition
+// format.
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		if family.Help != "" {
+			bw.WriteString("# HELP " + family.Name + " " + escapeHelp(family.Help) + "\n")
+		}
+		bw.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, sample := range family.Samples {
+			bw.WriteString(sample.Name)
+			if len(sample.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + `="` + escapeLabel(label.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatFloat(sample.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
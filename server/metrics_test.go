@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/metrics"
+	. "gopkg.in/check.v1"
+)
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) TestMetricsHandler(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{Metrics: metrics.New()}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	for _, body := range []string{"one", "two"} {
+		c.Assert(conn.Send("/queue/work", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+	sub, err := conn.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Assert(conn.Ack(msg), IsNil)
+	c.Assert(conn.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+
+	// measurements are recorded after frames are written, so the
+	// client can see a frame before it has been counted
+	var text string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		serv.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		c.Check(w.Header().Get("Content-Type"), Equals, metrics.ContentType)
+		text = w.Body.String()
+		if strings.Contains(text, "stomp_dispatch_latency_seconds_count 2\n") || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, line := range []string{
+		"stomp_connections_active 1",
+		`stomp_frames_received_total{command="SEND"} 3`,
+		`stomp_frames_received_total{command="ACK"} 1`,
+		`stomp_frames_sent_total{command="RECEIPT"} 3`,
+		`stomp_dispatch_latency_seconds_count 2`,
+		`stomp_ack_latency_seconds_count 1`,
+		`stomp_queue_depth{destination="/queue/work"} 0`,
+	} {
+		c.Check(strings.Contains(text, line+"\n"), Equals, true, Commentf("%s not in\n%s", line, text))
+	}
+}
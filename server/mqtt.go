@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"net"
+
+	"github.com/go-stomp/stomp/v3/server/mqtt"
+)
+
+// ServeMQTT accepts MQTT 3.1.1 connections on the listener l, so that
+// MQTT clients can publish and subscribe to the server's topics. MQTT
+// topic names map onto topic destinations with the mqtt.TopicPrefix
+// prefix, and the MQTT wildcards "+" and "#" map onto the wildcards
+// "*" and ">". See package mqtt for the details of the translation.
+//
+// MQTT clients are authenticated, and their destinations permitted,
+// as for STOMP clients. The server must be serving STOMP connections
+// with Serve; MQTT connections accepted when it is not are closed.
+// ServeMQTT returns when l fails to accept a connection, which is the
+// case once l has been closed.
+func (s *Server) ServeMQTT(l net.Listener) error {
+	logger := s.logger(MQTTComponent)
+	handler := &mqtt.Handler{Dial: s.dialPipe, Log: logger}
+	for {
+		rw, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := handler.ServeConn(rw); err != nil {
+				logger.Infof("mqtt: connection from %s closed: %v", rw.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Opens an in-memory connection to the server, which serves it like
+// a connection accepted by its listener.
+func (s *Server) dialPipe() (io.ReadWriteCloser, error) {
+	local, remote := net.Pipe()
+	err := s.call(func(proc *requestProcessor) error {
+		proc.accept(newConfig(proc), remote)
+		return nil
+	})
+	if err != nil {
+		local.Close()
+		remote.Close()
+		return nil, err
+	}
+	return local, nil
+}
@@ -0,0 +1,394 @@
+/*
+Package mqtt translates between MQTT 3.1.1 and STOMP, so that MQTT
+clients such as IoT devices can publish and subscribe to the topics of
+a STOMP server alongside STOMP clients.
+
+Each MQTT connection is served over its own STOMP connection to the
+server. An MQTT PUBLISH becomes a STOMP SEND to the topic destination
+for the MQTT topic name, and an MQTT SUBSCRIBE becomes a STOMP SUBSCRIBE
+to the topic destination for each topic filter. The MQTT user name and
+password are passed to the server as the STOMP login and passcode.
+
+Sessions are not kept between connections, and retained messages are
+not supported: the retain flag of a PUBLISH is ignored. Messages are
+delivered to MQTT subscribers at QoS 0, which matches the delivery of
+STOMP topics. Messages published at QoS 1 and 2 are acknowledged once
+the server has received them.
+*/
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// TopicPrefix is the prefix of the STOMP destinations that correspond
+// to MQTT topic names.
+const TopicPrefix = "/topic/"
+
+// DefaultMaxPacketSize is the largest packet accepted from an MQTT client.
+const DefaultMaxPacketSize = 1024 * 1024
+
+var (
+	errNotConnect    = errors.New("mqtt: first packet is not CONNECT")
+	errInvalidTopic  = errors.New("mqtt: invalid topic name")
+	errUnexpected    = errors.New("mqtt: unexpected packet")
+	errNotAuthorized = errors.New("mqtt: connection not authorized")
+)
+
+// Destination returns the STOMP destination for an MQTT topic name or
+// topic filter. The MQTT wildcards "+" and "#" become the wildcards "*"
+// and ">" of the server.
+func Destination(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		switch level {
+		case "+":
+			levels[i] = "*"
+		case "#":
+			levels[i] = ">"
+		}
+	}
+	return TopicPrefix + strings.Join(levels, "/")
+}
+
+// Topic returns the MQTT topic name for a STOMP destination, which is
+// the destination without TopicPrefix. Other destinations are returned
+// without their leading "/".
+func Topic(destination string) string {
+	if strings.HasPrefix(destination, TopicPrefix) {
+		return destination[len(TopicPrefix):]
+	}
+	return strings.TrimPrefix(destination, "/")
+}
+
+// Returns the STOMP destinations to subscribe to for an MQTT topic
+// filter, or nil if the filter is invalid. In MQTT "a/#" also matches
+// "a", whereas ">" matches at least one level, so the filter needs a
+// second subscription.
+func filterDestinations(filter string) []string {
+	if filter == "" {
+		return nil
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return nil
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return nil
+		}
+	}
+	destinations := []string{Destination(filter)}
+	if len(levels) > 1 && levels[len(levels)-1] == "#" {
+		destinations = append(destinations, Destination(strings.Join(levels[:len(levels)-1], "/")))
+	}
+	return destinations
+}
+
+// A Handler serves MQTT connections.
+type Handler struct {
+	// Dial opens a connection to the STOMP server, required.
+	Dial func() (io.ReadWriteCloser, error)
+
+	// Largest packet accepted from a client, DefaultMaxPacketSize if zero.
+	MaxPacketSize int
+
+	Log stomp.Logger // Logger, required
+}
+
+// A will message, published if the client disconnects
+// without sending DISCONNECT.
+type will struct {
+	topic   string
+	message []byte
+}
+
+// The state of a single MQTT connection.
+type session struct {
+	handler *Handler
+	rw      net.Conn
+	reader  *bufio.Reader
+	conn    *stomp.Conn
+	will    *will
+
+	writeMutex sync.Mutex
+	writer     *bufio.Writer
+
+	// STOMP subscriptions by MQTT topic filter
+	subs map[string][]*stomp.Subscription
+}
+
+// ServeConn serves an MQTT connection until the client disconnects or
+// the connection fails. The connection is closed before returning.
+// Returns nil if the client sent DISCONNECT.
+func (h *Handler) ServeConn(rw net.Conn) error {
+	defer rw.Close()
+	s := &session{
+		handler: h,
+		rw:      rw,
+		reader:  bufio.NewReader(rw),
+		writer:  bufio.NewWriter(rw),
+		subs:    make(map[string][]*stomp.Subscription),
+	}
+	keepAlive, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	err = s.serve(keepAlive)
+	if err != nil && s.will != nil {
+		if err := s.conn.Send(Destination(s.will.topic), "", s.will.message); err != nil {
+			h.Log.Warningf("mqtt: will message not sent: %v", err)
+		}
+	}
+	s.conn.MustDisconnect()
+	return err
+}
+
+func (h *Handler) maxPacketSize() int {
+	if h.MaxPacketSize == 0 {
+		return DefaultMaxPacketSize
+	}
+	return h.MaxPacketSize
+}
+
+// Reads the CONNECT packet and connects to the STOMP server.
+// Returns the keep alive interval requested by the client.
+func (s *session) connect() (time.Duration, error) {
+	p, err := readPacket(s.reader, s.handler.maxPacketSize())
+	if err != nil {
+		return 0, err
+	}
+	if p.kind != typeConnect {
+		return 0, errNotConnect
+	}
+	d := &decoder{b: p.body}
+	protocol := d.string()
+	level := d.byte()
+	flags := d.byte()
+	keepAlive := time.Duration(d.uint16()) * time.Second
+	d.string() // client identifier, not needed as sessions are not kept
+	if flags&0x04 != 0 {
+		s.will = &will{topic: d.string(), message: d.bytes()}
+	}
+	var login, passcode string
+	if flags&0x80 != 0 {
+		login = d.string()
+	}
+	if flags&0x40 != 0 {
+		passcode = d.string()
+	}
+	if d.err != nil || flags&0x01 != 0 || protocol != "MQTT" {
+		return 0, errMalformed
+	}
+	if level != 4 {
+		s.write(typeConnack, 0, []byte{0, connectBadProtocolVersion})
+		return 0, errMalformed
+	}
+	if s.will != nil && !validTopic(s.will.topic) {
+		return 0, errInvalidTopic
+	}
+
+	rw, err := s.handler.Dial()
+	if err != nil {
+		return 0, err
+	}
+	// closing the MQTT connection when the STOMP connection fails
+	// ends the session even if the client is idle
+	watched := &watchedConn{ReadWriteCloser: rw, fail: func() { s.rw.Close() }}
+	s.conn, err = stomp.Connect(watched,
+		stomp.ConnOpt.Login(login, passcode),
+		stomp.ConnOpt.HeartBeat(0, 0),
+		stomp.ConnOpt.Logger(s.handler.Log))
+	if err != nil {
+		rw.Close()
+		s.write(typeConnack, 0, []byte{0, connectNotAuthorized})
+		return 0, errNotAuthorized
+	}
+	if err := s.write(typeConnack, 0, []byte{0, connectAccepted}); err != nil {
+		s.conn.MustDisconnect()
+		return 0, err
+	}
+	return keepAlive, nil
+}
+
+// Processes packets until the client disconnects.
+func (s *session) serve(keepAlive time.Duration) error {
+	for {
+		if keepAlive > 0 {
+			s.rw.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		p, err := readPacket(s.reader, s.handler.maxPacketSize())
+		if err != nil {
+			return err
+		}
+		switch p.kind {
+		case typePublish:
+			err = s.publish(p)
+		case typePubrel:
+			// the message was sent when PUBLISH was received
+			if len(p.body) != 2 {
+				return errMalformed
+			}
+			err = s.write(typePubcomp, 0, p.body)
+		case typeSubscribe:
+			err = s.subscribe(p)
+		case typeUnsubscribe:
+			err = s.unsubscribe(p)
+		case typePingreq:
+			err = s.write(typePingresp, 0, nil)
+		case typeDisconnect:
+			s.will = nil
+			return nil
+		default:
+			err = errUnexpected
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Reports whether a topic name can be published to.
+func validTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#")
+}
+
+func (s *session) publish(p *packet) error {
+	qos := (p.flags >> 1) & 0x03
+	d := &decoder{b: p.body}
+	topic := d.string()
+	var id uint16
+	if qos > 0 {
+		id = d.uint16()
+	}
+	payload := d.rest()
+	if d.err != nil || qos > 2 {
+		return errMalformed
+	}
+	if !validTopic(topic) {
+		return errInvalidTopic
+	}
+
+	if qos == 0 {
+		return s.conn.Send(Destination(topic), "", payload)
+	}
+	if err := s.conn.Send(Destination(topic), "", payload, stomp.SendOpt.Receipt); err != nil {
+		return err
+	}
+	e := &encoder{}
+	e.uint16(id)
+	if qos == 1 {
+		return s.write(typePuback, 0, e.b)
+	}
+	return s.write(typePubrec, 0, e.b)
+}
+
+func (s *session) subscribe(p *packet) error {
+	d := &decoder{b: p.body}
+	e := &encoder{}
+	e.uint16(d.uint16())
+	for len(d.b) > 0 && d.err == nil {
+		filter := d.string()
+		d.byte() // requested QoS, messages are always delivered at QoS 0
+		if d.err != nil {
+			break
+		}
+		e.byte(s.subscribeFilter(filter))
+	}
+	if d.err != nil || len(e.b) == 2 {
+		return errMalformed
+	}
+	return s.write(typeSuback, 0, e.b)
+}
+
+// Subscribes to the destinations for a topic filter, replacing any
+// existing subscription for the filter. Returns the SUBACK return code.
+func (s *session) subscribeFilter(filter string) byte {
+	destinations := filterDestinations(filter)
+	if destinations == nil {
+		return subscribeFailure
+	}
+	s.unsubscribeFilter(filter)
+	var subs []*stomp.Subscription
+	for _, destination := range destinations {
+		sub, err := s.conn.Subscribe(destination, stomp.AckAuto)
+		if err != nil {
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			s.handler.Log.Warningf("mqtt: cannot subscribe to %s: %v", filter, err)
+			return subscribeFailure
+		}
+		subs = append(subs, sub)
+		go s.deliver(sub)
+	}
+	s.subs[filter] = subs
+	return 0
+}
+
+func (s *session) unsubscribe(p *packet) error {
+	d := &decoder{b: p.body}
+	e := &encoder{}
+	e.uint16(d.uint16())
+	for len(d.b) > 0 && d.err == nil {
+		s.unsubscribeFilter(d.string())
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return s.write(typeUnsuback, 0, e.b)
+}
+
+func (s *session) unsubscribeFilter(filter string) {
+	for _, sub := range s.subs[filter] {
+		sub.Unsubscribe()
+	}
+	delete(s.subs, filter)
+}
+
+// Publishes the messages received by a subscription to the client.
+func (s *session) deliver(sub *stomp.Subscription) {
+	for msg := range sub.C {
+		if msg.Err != nil {
+			s.rw.Close()
+			return
+		}
+		e := &encoder{}
+		e.string(Topic(msg.Destination))
+		e.b = append(e.b, msg.Body...)
+		if err := s.write(typePublish, 0, e.b); err != nil {
+			s.rw.Close()
+			return
+		}
+	}
+}
+
+// Writes a packet, from any go-routine.
+func (s *session) write(kind, flags byte, body []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return writePacket(s.writer, kind, flags, body)
+}
+
+// A connection that calls fail when a read fails.
+type watchedConn struct {
+	io.ReadWriteCloser
+	fail func()
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if err != nil {
+		c.fail()
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Types of MQTT control packets.
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typePubrec      = 5
+	typePubrel      = 6
+	typePubcomp     = 7
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// Return codes of a CONNACK packet.
+const (
+	connectAccepted           = 0
+	connectBadProtocolVersion = 1
+	connectNotAuthorized      = 5
+)
+
+// Return code for a topic filter in a SUBACK packet
+// that the server did not subscribe to.
+const subscribeFailure = 0x80
+
+// Largest remaining length that can be encoded.
+const maxRemainingLength = 268435455
+
+var (
+	errMalformed     = errors.New("mqtt: malformed packet")
+	errPacketTooLong = errors.New("mqtt: packet too long")
+)
+
+// A packet is an MQTT control packet, with its fixed header
+// decoded and its remaining bytes still to be decoded.
+type packet struct {
+	kind  byte // packet type
+	flags byte // low four bits of the fixed header
+	body  []byte
+}
+
+// Reads a packet. Packets longer than maxLength are rejected.
+func readPacket(r *bufio.Reader, maxLength int) (*packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformed
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if maxLength > 0 && length > maxLength {
+		return nil, errPacketTooLong
+	}
+	p := &packet{kind: b >> 4, flags: b & 0x0f, body: make([]byte, length)}
+	if _, err = io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Writes a packet, with the fixed header.
+func writePacket(w *bufio.Writer, kind, flags byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return errPacketTooLong
+	}
+	w.WriteByte(kind<<4 | flags)
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		w.WriteByte(digit)
+		if length == 0 {
+			break
+		}
+	}
+	w.Write(body)
+	return w.Flush()
+}
+
+// Decodes the variable header and payload of a packet.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// Returns the bytes that have not been decoded.
+func (d *decoder) rest() []byte {
+	v := d.b
+	d.b = nil
+	return v
+}
+
+// Encodes the variable header and payload of a packet.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(v byte) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *encoder) string(v string) {
+	e.uint16(uint16(len(v)))
+	e.b = append(e.b, v...)
+}
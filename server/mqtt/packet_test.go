@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestMQTT(t *testing.T) {
+	TestingT(t)
+}
+
+type PacketSuite struct{}
+
+var _ = Suite(&PacketSuite{})
+
+func (s *PacketSuite) TestRoundTrip(c *C) {
+	for _, size := range []int{0, 1, 127, 128, 16383, 16384, 2097152} {
+		var buf bytes.Buffer
+		body := bytes.Repeat([]byte{'x'}, size)
+		err := writePacket(bufio.NewWriter(&buf), typePublish, 0x3, body)
+		c.Assert(err, IsNil)
+
+		p, err := readPacket(bufio.NewReader(&buf), 0)
+		c.Assert(err, IsNil)
+		c.Check(p.kind, Equals, byte(typePublish))
+		c.Check(p.flags, Equals, byte(0x3))
+		c.Check(p.body, DeepEquals, body)
+		c.Check(buf.Len(), Equals, 0)
+	}
+}
+
+func (s *PacketSuite) TestReadErrors(c *C) {
+	// remaining length longer than four bytes
+	_, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01})), 0)
+	c.Check(err, Equals, errMalformed)
+
+	_, err = readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 1, 2, 3, 4, 5})), 4)
+	c.Check(err, Equals, errPacketTooLong)
+}
+
+func (s *PacketSuite) TestDecoder(c *C) {
+	e := &encoder{}
+	e.string("topic")
+	e.uint16(42)
+	e.byte(1)
+	e.b = append(e.b, "payload"...)
+
+	d := &decoder{b: e.b}
+	c.Check(d.string(), Equals, "topic")
+	c.Check(d.uint16(), Equals, uint16(42))
+	c.Check(d.byte(), Equals, byte(1))
+	c.Check(string(d.rest()), Equals, "payload")
+	c.Check(d.err, IsNil)
+
+	d.byte()
+	c.Check(d.err, Equals, errMalformed)
+}
+
+func (s *PacketSuite) TestDestinations(c *C) {
+	c.Check(Destination("sensors/kitchen/temp"), Equals, "/topic/sensors/kitchen/temp")
+	c.Check(Destination("sensors/+/temp"), Equals, "/topic/sensors/*/temp")
+	c.Check(Destination("sensors/#"), Equals, "/topic/sensors/>")
+	c.Check(Topic("/topic/sensors/kitchen/temp"), Equals, "sensors/kitchen/temp")
+	c.Check(Topic("/news"), Equals, "news")
+
+	c.Check(filterDestinations("a/+/c"), DeepEquals, []string{"/topic/a/*/c"})
+	c.Check(filterDestinations("#"), DeepEquals, []string{"/topic/>"})
+	c.Check(filterDestinations("a/#"), DeepEquals, []string{"/topic/a/>", "/topic/a"})
+	c.Check(filterDestinations("a/#/c"), IsNil)
+	c.Check(filterDestinations("a+/c"), IsNil)
+	c.Check(filterDestinations(""), IsNil)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type MQTTSuite struct{}
+
+var _ = Suite(&MQTTSuite{})
+
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(login, passcode string) bool {
+	return login == "user" && passcode == "secret"
+}
+
+// A minimal MQTT client, for packets shorter than 128 bytes.
+type mqttClient struct {
+	c    *C
+	conn net.Conn
+}
+
+func dialMQTT(c *C, addr string) *mqttClient {
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	return &mqttClient{c: c, conn: conn}
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func (m *mqttClient) write(header byte, parts ...[]byte) {
+	var body []byte
+	for _, part := range parts {
+		body = append(body, part...)
+	}
+	_, err := m.conn.Write(append([]byte{header, byte(len(body))}, body...))
+	m.c.Assert(err, IsNil)
+}
+
+// Reads a packet, returning its first byte and its body.
+func (m *mqttClient) read() (byte, []byte) {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 2)
+	_, err := io.ReadFull(m.conn, header)
+	m.c.Assert(err, IsNil)
+	body := make([]byte, header[1])
+	_, err = io.ReadFull(m.conn, body)
+	m.c.Assert(err, IsNil)
+	return header[0], body
+}
+
+func (m *mqttClient) connect(login, password string) byte {
+	m.write(0x10, mqttString("MQTT"), []byte{4, 0xc2, 0, 0},
+		mqttString("device"), mqttString(login), mqttString(password))
+	header, body := m.read()
+	m.c.Assert(header, Equals, byte(0x20))
+	m.c.Assert(body, HasLen, 2)
+	return body[1]
+}
+
+func (m *mqttClient) expectPublish(topic, payload string) {
+	header, body := m.read()
+	m.c.Check(header, Equals, byte(0x30))
+	m.c.Check(string(body), Equals, string(mqttString(topic))+payload)
+}
+
+func (s *MQTTSuite) TestMQTT(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := &Server{Authenticator: testAuthenticator{}}
+	go serv.Serve(l)
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	ml, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer ml.Close()
+	go serv.ServeMQTT(ml)
+
+	device := dialMQTT(c, ml.Addr().String())
+	c.Check(device.connect("user", "wrong"), Equals, byte(5))
+	device.conn.Close()
+
+	device = dialMQTT(c, ml.Addr().String())
+	defer device.conn.Close()
+	c.Assert(device.connect("user", "secret"), Equals, byte(0))
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("user", "secret"))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	// SUBSCRIBE with packet identifier 1
+	device.write(0x82, []byte{0, 1}, mqttString("sensors/+/temp"), []byte{1},
+		mqttString("home/#"), []byte{0})
+	header, body := device.read()
+	c.Check(header, Equals, byte(0x90))
+	c.Check(body, DeepEquals, []byte{0, 1, 0, 0})
+
+	// once a QoS 1 PUBLISH has been acknowledged, the subscriptions
+	// before it are in place
+	device.write(0x32, mqttString("sync"), []byte{0, 2})
+	header, body = device.read()
+	c.Check(header, Equals, byte(0x40))
+	c.Check(body, DeepEquals, []byte{0, 2})
+
+	for _, destination := range []string{"/topic/sensors/kitchen/temp", "/topic/home", "/topic/sensors/kitchen/humidity"} {
+		err = client.Send(destination, "text/plain", []byte(destination), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	device.expectPublish("sensors/kitchen/temp", "/topic/sensors/kitchen/temp")
+	device.expectPublish("home", "/topic/home")
+
+	sub, err := client.Subscribe("/topic/lights/>", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(client.Send("/topic/sync", "", nil, stomp.SendOpt.Receipt), IsNil)
+	device.write(0x30, mqttString("lights/hall"), []byte("on"))
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(msg.Destination, Equals, "/topic/lights/hall")
+		c.Check(string(msg.Body), Equals, "on")
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not received")
+	}
+
+	// UNSUBSCRIBE with packet identifier 3
+	device.write(0xa2, []byte{0, 3}, mqttString("home/#"))
+	header, body = device.read()
+	c.Check(header, Equals, byte(0xb0))
+	c.Check(body, DeepEquals, []byte{0, 3})
+
+	device.write(0xc0)
+	header, _ = device.read()
+	c.Check(header, Equals, byte(0xd0))
+
+	device.write(0xe0)
+	_, err = device.conn.Read(make([]byte, 1))
+	c.Check(err, Equals, io.EOF)
+}
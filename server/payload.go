@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+)
+
+// A PayloadValidator validates the bodies of the messages sent to the
+// destinations of a DestinationPolicy, so that malformed messages never
+// enter a queue or reach the subscribers of a topic.
+//
+// ValidatePayload is called on the go-routine that reads the frames of
+// the sending client, so is called concurrently for different clients.
+type PayloadValidator interface {
+	// ValidatePayload returns nil if body is a valid message for the
+	// destination, or else an error that describes why it is not,
+	// preferably a *PayloadError listing every problem found.
+	ValidatePayload(destination, contentType string, body []byte) error
+}
+
+// The PayloadValidatorFunc type is an adapter to allow the use of
+// ordinary functions as payload validators.
+type PayloadValidatorFunc func(destination, contentType string, body []byte) error
+
+// ValidatePayload calls f(destination, contentType, body).
+func (f PayloadValidatorFunc) ValidatePayload(destination, contentType string, body []byte) error {
+	return f(destination, contentType, body)
+}
+
+// A PayloadError is the error of a message rejected by a
+// PayloadValidator. The client that sent the message is sent an ERROR
+// frame whose message header entry is the error message, and whose
+// body lists the problems, one per line.
+type PayloadError struct {
+	Destination string
+	Problems    []string
+}
+
+func (e *PayloadError) Error() string {
+	message := "invalid payload for " + e.Destination
+	switch len(e.Problems) {
+	case 0:
+		return message
+	case 1:
+		return message + ": " + e.Problems[0]
+	default:
+		return fmt.Sprintf("%s: %s (and %d more)", message, e.Problems[0], len(e.Problems)-1)
+	}
+}
+
+// Details returns the problems, one per line, which are sent in the
+// body of the ERROR frame.
+func (e *PayloadError) Details() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// JSONPayload is a PayloadValidator that accepts bodies that are
+// well-formed JSON, whatever their content type.
+var JSONPayload PayloadValidator = PayloadValidatorFunc(func(destination, contentType string, body []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return &PayloadError{Destination: destination, Problems: []string{jsonProblem(err)}}
+	}
+	return nil
+})
+
+// Describes the error of a body that is not well-formed JSON.
+func jsonProblem(err error) string {
+	if err, ok := err.(*json.SyntaxError); ok {
+		return fmt.Sprintf("invalid JSON at offset %d: %v", err.Offset, err)
+	}
+	return "invalid JSON: " + err.Error()
+}
+
+// payloadValidation is an interceptor of every connection, which
+// validates the bodies of SEND frames with the validators of the
+// destination policies.
+type payloadValidation struct {
+	server *Server
+}
+
+func (v *payloadValidation) Inbound(c *client.Conn, f *frame.Frame) error {
+	if f.Command != frame.SEND {
+		return nil
+	}
+	destination := f.Header.Get(frame.Destination)
+	policy := findPolicy(v.server.policies(), destination)
+	if policy == nil || policy.Validator == nil {
+		return nil
+	}
+	err := policy.Validator.ValidatePayload(destination, f.Header.Get(frame.ContentType), f.Body)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*PayloadError); !ok {
+		err = &PayloadError{Destination: destination, Problems: []string{err.Error()}}
+	}
+	v.server.Metrics.PayloadRejected()
+	return err
+}
+
+func (v *payloadValidation) Outbound(c *client.Conn, f *frame.Frame) error {
+	return nil
+}
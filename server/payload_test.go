@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/metrics"
+	. "gopkg.in/check.v1"
+)
+
+type PayloadSuite struct{}
+
+var _ = Suite(&PayloadSuite{})
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "string", "pattern": "^[A-Z]+-[0-9]+$"},
+		"status": {"enum": ["new", "paid"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["sku"],
+				"properties": {
+					"sku": {"type": "string", "minLength": 3},
+					"quantity": {"type": "integer", "exclusiveMinimum": 0}
+				}
+			}
+		}
+	}
+}`
+
+func (s *PayloadSuite) TestJSONSchema(c *C) {
+	schema, err := ParseJSONSchema([]byte(orderSchema))
+	c.Assert(err, IsNil)
+
+	c.Check(schema.ValidatePayload("/queue/orders", "application/json",
+		[]byte(`{"id": "ORD-1", "status": "new", "items": [{"sku": "abc", "quantity": 2}]}`)), IsNil)
+
+	err = schema.ValidatePayload("/queue/orders", "application/json",
+		[]byte(`{"id": "ord-1", "status": "lost", "items": [{"quantity": 1.5}, {"sku": "ab", "quantity": 0}], "x": 1}`))
+	c.Assert(err, FitsTypeOf, &PayloadError{})
+	c.Check(err.(*PayloadError).Problems, DeepEquals, []string{
+		`/id: does not match pattern "^[A-Z]+-[0-9]+$"`,
+		`/items/0: missing property "sku"`,
+		`/items/0/quantity: expected integer, got number`,
+		`/items/1/quantity: not greater than 0`,
+		`/items/1/sku: shorter than 3 characters`,
+		`/status: not one of the values of the enum`,
+		`/x: unexpected property`,
+	})
+	c.Check(err, ErrorMatches, `invalid payload for /queue/orders: /id: .* \(and 6 more\)`)
+
+	err = schema.ValidatePayload("/queue/orders", "application/json", []byte(`{"id": `))
+	c.Check(err, ErrorMatches, `invalid payload for /queue/orders: invalid JSON: unexpected EOF`)
+	err = schema.ValidatePayload("/queue/orders", "application/json", []byte(`{} {}`))
+	c.Check(err, ErrorMatches, `.*more than one value`)
+	err = schema.ValidatePayload("/queue/orders", "application/json", []byte(`[]`))
+	c.Check(err, ErrorMatches, `.*/: expected object, got array`)
+}
+
+func (s *PayloadSuite) TestJSONSchemaCombinators(c *C) {
+	schema, err := ParseJSONSchema([]byte(`{
+		"oneOf": [{"type": "string"}, {"type": "number", "minimum": 10}],
+		"not": {"const": "forbidden"}
+	}`))
+	c.Assert(err, IsNil)
+	c.Check(schema.ValidatePayload("/queue/a", "", []byte(`"text"`)), IsNil)
+	c.Check(schema.ValidatePayload("/queue/a", "", []byte(`12`)), IsNil)
+	c.Check(schema.ValidatePayload("/queue/a", "", []byte(`5`)), ErrorMatches, `.*valid for 0 schemas of oneOf.*`)
+	c.Check(schema.ValidatePayload("/queue/a", "", []byte(`"forbidden"`)), ErrorMatches, `.*valid for the schema of not`)
+
+	_, err = ParseJSONSchema([]byte(`{"properties": {"a": {"$ref": "#/definitions/a"}}}`))
+	c.Check(err, ErrorMatches, `json schema: /properties/a: keyword "\$ref" is not supported`)
+	_, err = ParseJSONSchema([]byte(`{"minLength": -1}`))
+	c.Check(err, ErrorMatches, `json schema: /minLength: not a non-negative integer`)
+	_, err = ParseJSONSchema([]byte(`{"type": 1}`))
+	c.Check(err, NotNil)
+}
+
+func (s *PayloadSuite) TestJSONPayload(c *C) {
+	c.Check(JSONPayload.ValidatePayload("/queue/a", "", []byte(`{"a": [1, 2]}`)), IsNil)
+	c.Check(JSONPayload.ValidatePayload("/queue/a", "", []byte(`{"a": }`)), ErrorMatches,
+		`invalid payload for /queue/a: invalid JSON at offset 7: .*`)
+}
+
+func (s *PayloadSuite) TestRejectedPayload(c *C) {
+	schema, err := ParseJSONSchema([]byte(orderSchema))
+	c.Assert(err, IsNil)
+	m := metrics.New()
+	serv := &Server{Metrics: m, Policies: []DestinationPolicy{
+		{Pattern: "/queue/orders", Validator: schema},
+		{Pattern: "/queue/checked", Validator: PayloadValidatorFunc(func(destination, contentType string, body []byte) error {
+			if contentType != "text/plain" {
+				return errors.New("expected text/plain")
+			}
+			return nil
+		})},
+	}}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	sub, err := conn.Subscribe("/queue/orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	valid := []byte(`{"id": "ORD-1", "items": [{"sku": "abc"}]}`)
+	c.Assert(conn.Send("/queue/orders", "application/json", valid, stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub).Body), Equals, string(valid))
+	c.Assert(conn.Send("/queue/other", "text/plain", []byte("not json"), stomp.SendOpt.Receipt), IsNil)
+
+	// the ERROR frame lists the problems in its body
+	rejected, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer rejected.MustDisconnect()
+	err = rejected.Send("/queue/orders", "application/json", []byte(`{"items": []}`), stomp.SendOpt.Receipt)
+	c.Assert(err, FitsTypeOf, stomp.Error{})
+	c.Check(err.(stomp.Error).Message, Equals, `invalid payload for /queue/orders: /: missing property "id" (and 1 more)`)
+	c.Check(string(err.(stomp.Error).Frame.Body), Equals, "/: missing property \"id\"\n/items: fewer than 1 items")
+	c.Check(err.(stomp.Error).Frame.Header.Get(frame.ContentType), Equals, "text/plain")
+
+	rejected, err = stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer rejected.MustDisconnect()
+	err = rejected.Send("/queue/checked", "application/json", nil, stomp.SendOpt.Receipt)
+	c.Assert(err, FitsTypeOf, stomp.Error{})
+	c.Check(string(err.(stomp.Error).Frame.Body), Equals, "expected text/plain")
+
+	// nothing invalid was queued
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("received %q", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, family := range m.Collect() {
+		if family.Name == "stomp_payloads_rejected_total" {
+			c.Check(family.Samples[0].Value, Equals, 2.0)
+		}
+	}
+}
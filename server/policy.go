@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/topic"
+	"github.com/go-stomp/stomp/v3/server/wildcard"
+)
+
+// Header added to a message that has been copied or moved to a different
+// destination by the server. The value is the destination the message was
+// originally sent to.
+const OriginalDestinationHeader = "original-destination"
+
+// Header added to a message that has been moved to an expiry destination.
+// The value is the "expires" header of the message, which is removed so
+// that the message does not expire again.
+const OriginalExpiresHeader = "original-expires"
+
+// DispatchMode determines how the messages sent to a queue are
+// distributed between the queue's subscriptions.
+type DispatchMode int
+
+// Dispatch modes.
+const (
+	// Subscriptions compete for messages, and each message is delivered
+	// to one subscription. This is the classic queue behavior.
+	DispatchRoundRobin DispatchMode = iota
+
+	// Each subscription receives a copy of every message sent while it
+	// is subscribed, and acknowledges its copy independently. Messages
+	// sent while the queue has no subscriptions are delivered to one
+	// subscription.
+	DispatchBroadcast
+)
+
+// SlowConsumerAction determines what is done when the client of a
+// subscription does not read messages as fast as they are sent.
+type SlowConsumerAction int
+
+// Slow consumer actions.
+const (
+	// The destination waits until the client's connection has room
+	// for the message, which holds up the other subscriptions of the
+	// destination.
+	SlowConsumerWait SlowConsumerAction = iota
+
+	// Messages for a topic subscription are dropped while its client's
+	// connection has no room for them. Queue messages are never
+	// dropped, and queue subscriptions wait instead.
+	SlowConsumerDrop
+
+	// The subscription is removed, and receives no more messages. The
+	// client stays connected. A queue message is sent to another
+	// subscription.
+	SlowConsumerUnsubscribe
+
+	// The client is sent an ERROR frame identifying it as a slow
+	// consumer, and is disconnected. A queue message is sent to
+	// another subscription.
+	SlowConsumerDisconnect
+)
+
+// DefaultSlowConsumerTimeout is how long a message waits for room in
+// the connection of a slow consumer if neither the server nor a policy
+// specifies a SlowConsumerTimeout.
+const DefaultSlowConsumerTimeout = time.Second
+
+// A DestinationPolicy contains settings that apply to all destinations
+// whose name matches a pattern. See package wildcard for the pattern syntax.
+type DestinationPolicy struct {
+	// Pattern for the destinations that this policy applies to.
+	Pattern string
+
+	// MirrorQueue is the name of a queue that receives a copy of every
+	// message sent to a matching topic, for example for audit or replay
+	// consumers. Ignored for queue destinations. It must start with
+	// "/queue/", and must not match Pattern. As only the first matching
+	// policy applies to a destination, a mirror policy listed before a
+	// broader policy hides the broader policy's settings from the
+	// topics that it matches, so set MirrorQueue in the policy that
+	// has the other settings for those topics.
+	MirrorQueue string
+
+	// ExpiryDestination is the name of a queue or topic that receives
+	// messages sent to a matching destination that expire before they
+	// are delivered. If empty, expired messages are discarded.
+	ExpiryDestination string
+
+	// Dispatch determines how messages sent to a matching queue are
+	// distributed between its subscriptions. Ignored for topics.
+	Dispatch DispatchMode
+
+	// MinConsumers is the number of subscriptions that a matching
+	// destination is expected to always have. If the destination has
+	// fewer subscriptions for longer than ConsumerGracePeriod while
+	// messages are waiting to be delivered, a ConsumerShortageAdvisory
+	// is sent. Wildcard subscriptions are not counted. If zero, the
+	// number of subscriptions is not monitored.
+	MinConsumers int
+
+	// ConsumerGracePeriod is how long a matching destination can have
+	// fewer than MinConsumers subscriptions before an advisory is sent.
+	// If zero, DefaultConsumerGracePeriod is used.
+	ConsumerGracePeriod time.Duration
+
+	// Durability determines when messages stored in a matching queue
+	// are written to disk, and when the RECEIPT for a message is sent.
+	// Ignored for topics, but copies of topic messages stored in mirror
+	// and virtual topic queues use the durability of those queues.
+	Durability Durability
+
+	// RetainMessages is the number of messages that a matching topic
+	// keeps, so that a subscription can replay them before receiving
+	// live messages. A subscription asks for a replay with the
+	// "from-seq" or "from-time" header of its SUBSCRIBE frame, and
+	// each retained message has a "seq" header with its sequence
+	// number. Retained messages are kept in memory and are lost when
+	// the server stops. Ignored for queues.
+	RetainMessages int
+
+	// RetainFor is how long a matching topic keeps messages for
+	// replay. If zero, messages are kept regardless of their age.
+	// A topic retains messages if either RetainMessages or
+	// RetainFor is non-zero.
+	RetainFor time.Duration
+
+	// SlowConsumer determines what is done when the client of a
+	// subscription to a matching destination has not read enough of
+	// the messages sent to it for a message to be queued for the
+	// client within SlowConsumerTimeout. If SlowConsumerWait, the
+	// server's QueueSlowConsumer or TopicSlowConsumer applies.
+	SlowConsumer SlowConsumerAction
+
+	// SlowConsumerTimeout is how long a message waits for room in the
+	// connection of a client before SlowConsumer is applied. If zero,
+	// the server's SlowConsumerTimeout is used.
+	SlowConsumerTimeout time.Duration
+
+	// TraceMessages causes all messages sent to a matching destination
+	// to be traced, as if they were sent with a MessageTraceHeader.
+	TraceMessages bool
+
+	// Validator validates the bodies of the messages sent to a
+	// matching destination. A client that sends a message that it
+	// rejects is sent an ERROR frame describing the problems, and is
+	// disconnected, as for other invalid frames. If nil, bodies are
+	// not validated.
+	Validator PayloadValidator
+}
+
+// Matches reports whether the policy applies to the destination.
+func (p *DestinationPolicy) Matches(destination string) bool {
+	return wildcard.Match(p.Pattern, destination)
+}
+
+// Returns an error describing the first invalid setting of the
+// policies, or nil if they are all valid.
+func validatePolicies(policies []DestinationPolicy) error {
+	for i := range policies {
+		p := &policies[i]
+		if p.MirrorQueue == "" {
+			continue
+		}
+		if !isQueueDestination(p.MirrorQueue) {
+			return fmt.Errorf("policy %q: mirror queue %q is not a queue", p.Pattern, p.MirrorQueue)
+		}
+		if p.Matches(p.MirrorQueue) {
+			return fmt.Errorf("policy %q: mirror queue %q is mirrored to itself", p.Pattern, p.MirrorQueue)
+		}
+	}
+	return nil
+}
+
+// Returns the queue dispatch mode for a destination.
+func dispatchMode(policies []DestinationPolicy, destination string) queue.DispatchMode {
+	policy := findPolicy(policies, destination)
+	if policy != nil && policy.Dispatch == DispatchBroadcast {
+		return queue.Broadcast
+	}
+	return queue.RoundRobin
+}
+
+// Returns the retention settings for the messages sent to a topic.
+func topicRetention(policies []DestinationPolicy, destination string) topic.Retention {
+	policy := findPolicy(policies, destination)
+	if policy == nil {
+		return topic.Retention{}
+	}
+	return topic.Retention{MaxMessages: policy.RetainMessages, MaxAge: policy.RetainFor}
+}
+
+// Sets the slow consumer action of a subscription, from the policy of
+// its destination if the policy specifies one, and otherwise from the
+// server's setting for queue or topic subscriptions.
+func (proc *requestProcessor) setSlowConsumer(sub *client.Subscription) {
+	action := proc.server.TopicSlowConsumer
+	if isQueueDestination(sub.Destination()) {
+		action = proc.server.QueueSlowConsumer
+	}
+	timeout := proc.server.SlowConsumerTimeout
+	if policy := findPolicy(proc.server.Policies, sub.Destination()); policy != nil {
+		if policy.SlowConsumer != SlowConsumerWait {
+			action = policy.SlowConsumer
+		}
+		if policy.SlowConsumerTimeout > 0 {
+			timeout = policy.SlowConsumerTimeout
+		}
+	}
+	if timeout <= 0 {
+		timeout = DefaultSlowConsumerTimeout
+	}
+	switch action {
+	case SlowConsumerDrop:
+		sub.SetSlowPolicy(client.SlowDrop, timeout)
+	case SlowConsumerUnsubscribe:
+		sub.SetSlowPolicy(client.SlowUnsubscribe, timeout)
+	case SlowConsumerDisconnect:
+		sub.SetSlowPolicy(client.SlowDisconnect, timeout)
+	}
+}
+
+// Returns the first policy in the list that matches the destination,
+// or nil if no policy matches.
+func findPolicy(policies []DestinationPolicy, destination string) *DestinationPolicy {
+	for i := range policies {
+		if policies[i].Matches(destination) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type PolicySuite struct{}
+
+var _ = Suite(&PolicySuite{})
+
+func (s *PolicySuite) TestFindPolicy(c *C) {
+	policies := []DestinationPolicy{
+		{Pattern: "/topic/audit.>", MirrorQueue: "/queue/audit"},
+		{Pattern: "/topic/>"},
+	}
+	c.Check(findPolicy(policies, "/topic/audit.orders"), Equals, &policies[0])
+	c.Check(findPolicy(policies, "/topic/other"), Equals, &policies[1])
+	c.Check(findPolicy(policies, "/queue/other"), IsNil)
+}
+
+func (s *PolicySuite) TestValidatePolicies(c *C) {
+	c.Check(validatePolicies([]DestinationPolicy{
+		{Pattern: "/topic/audit.>", MirrorQueue: "/queue/audit"},
+		{Pattern: "/topic/>"},
+	}), IsNil)
+	c.Check(validatePolicies([]DestinationPolicy{
+		{Pattern: "/topic/audit.>", MirrorQueue: "/topic/audit"},
+	}), ErrorMatches, `policy "/topic/audit.>": mirror queue "/topic/audit" is not a queue`)
+	c.Check(validatePolicies([]DestinationPolicy{
+		{Pattern: ">", MirrorQueue: "/queue/audit"},
+	}), ErrorMatches, `policy ">": mirror queue "/queue/audit" is mirrored to itself`)
+}
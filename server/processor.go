@@ -3,56 +3,254 @@ package server
 import (
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/metrics"
 	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/record"
 	"github.com/go-stomp/stomp/v3/server/topic"
 )
 
 type requestProcessor struct {
-	server *Server
-	ch     chan client.Request
-	tm     *topic.Manager
-	qm     *queue.Manager
-	stop   bool // has stop been requested
+	server    *Server
+	ch        chan client.Request
+	calls     chan func() // administrative operations
+	tm        *topic.Manager
+	qm        *queue.Manager
+	qstore    queue.Storage
+	arch      *archiver
+	events    *events     // nil unless the server has event hooks
+	traces    *dispatcher // passes message trace records to the sink, nil if there is none
+	vt        *virtualTopics
+	idle      *idleDestinations
+	subs      subscriptionCounts
+	demand    map[string]int            // number of subscriptions to each destination not forwarded by other brokers
+	shard     *sharding                 // nil unless destinations are partitioned among a cluster
+	clients   map[*client.Conn]struct{} // connected clients
+	consumers *consumerMonitor
+	listener  net.Listener
+	conns     *connections  // network connections accepted by the listener
+	active    int32         // number of client connections not yet disconnected, atomic
+	stop      bool          // has stop been requested
+	listening chan struct{} // closed when the listener stops accepting connections
+	stopped   chan struct{} // closed when the processor has stopped
+	stopErr   error         // result of stopping the processor
+
+	// have the policies changed, so that the timers need to be reset
+	timersChanged bool
+
+	durability durabilityTracker
+	memory     *client.MemoryMeter // counts the bytes of messages held in memory
+	queueBytes int64               // bytes held by queue storage, as last counted
+	log        stomp.Logger
 }
 
 func newRequestProcessor(server *Server) *requestProcessor {
 	proc := &requestProcessor{
-		server: server,
-		ch:     make(chan client.Request, 128),
-		tm:     topic.NewManager(),
+		server:    server,
+		log:       server.logger(ServerComponent),
+		ch:        make(chan client.Request, 128),
+		calls:     make(chan func()),
+		tm:        topic.NewManager(),
+		vt:        newVirtualTopics(),
+		subs:      make(subscriptionCounts),
+		demand:    make(map[string]int),
+		clients:   make(map[*client.Conn]struct{}),
+		consumers: newConsumerMonitor(server.Policies),
+		conns:     newConnections(),
+		listening: make(chan struct{}),
+		stopped:   make(chan struct{}),
+		memory:    client.NewMemoryMeter(server.MaxMemoryBytes),
 	}
 
 	if server.QueueStorage == nil {
-		proc.qm = queue.NewManager(queue.NewMemoryQueueStorage())
+		proc.qstore = queue.NewMemoryQueueStorage()
+		if server.MaxMemoryMessages > 0 {
+			proc.qstore = queue.NewPagingStorage(proc.qstore, server.PageDir, server.MaxMemoryMessages)
+		}
+	} else if server.HonorPersistentHeader {
+		proc.qstore = queue.NewPersistentStorage(server.QueueStorage)
 	} else {
-		proc.qm = queue.NewManager(server.QueueStorage)
+		proc.qstore = server.QueueStorage
+	}
+	proc.durability.storage, _ = proc.qstore.(queue.SyncStorage)
+	proc.qm = queue.NewManager(proc.qstore)
+	proc.qm.SetExpiredHandler(proc.expire)
+	proc.qm.SetDispatchMode(func(destination string) queue.DispatchMode {
+		return dispatchMode(server.Policies, destination)
+	})
+	proc.tm.SetFanoutWorkers(server.TopicFanoutWorkers)
+	proc.tm.SetRetention(func(destination string) topic.Retention {
+		return topicRetention(server.Policies, destination)
+	})
+
+	if server.Archive != nil && server.Archive.Sink != nil {
+		proc.arch = newArchiver(*server.Archive, server.logger(ArchiveComponent))
+	}
+
+	if server.Events != nil {
+		proc.events = newEvents(*server.Events)
+	}
+
+	if server.MessageTraceSink != nil {
+		proc.traces = newDispatcher(messageTraceBufferSize)
+	}
+
+	if server.Shard != nil {
+		proc.shard = newSharding(proc, server.Shard)
+	}
+
+	if server.IdleDestinationTimeout > 0 {
+		proc.idle = newIdleDestinations(server.IdleDestinationTimeout)
 	}
 
 	return proc
 }
 
+// Returns the logger of the processor, which attaches a destination
+// to entries.
+func (proc *requestProcessor) destinationLog(destination string) stomp.Logger {
+	return stomp.WithFields(proc.log, stomp.Field{Key: stomp.DestinationField, Value: destination})
+}
+
 func (proc *requestProcessor) Serve(l net.Listener) error {
+	defer close(proc.stopped)
+	// hooks are passed the remaining events, and the sink the
+	// remaining message trace records, before Serve returns
+	defer proc.events.Stop()
+	defer func() {
+		if proc.traces != nil {
+			proc.traces.Stop()
+		}
+	}()
+	defer proc.tm.Close()
+
+	if proc.server.SnapshotFile != "" {
+		if err := proc.restoreSnapshot(proc.server.SnapshotFile); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
 	go proc.Listen(l)
 
-	for {
-		r := <-proc.ch
+	var compact <-chan time.Time
+	if proc.server.CompactInterval > 0 {
+		ticker := time.NewTicker(proc.server.CompactInterval)
+		defer ticker.Stop()
+		compact = ticker.C
+	}
+
+	var changes <-chan string
+	if shared, ok := proc.qstore.(queue.SharedStorage); ok {
+		changes = shared.Changes()
+	}
+
+	// the tickers are reset when the policies change
+	var sweep, flush <-chan time.Time
+	var sweepTicker, flushTicker *time.Ticker
+	stopTimers := func() {
+		for _, t := range []*time.Ticker{sweepTicker, flushTicker} {
+			if t != nil {
+				t.Stop()
+			}
+		}
+	}
+	resetTimers := func() {
+		stopTimers()
+		sweep, flush, sweepTicker, flushTicker = nil, nil, nil, nil
+		if interval := proc.sweepInterval(); interval > 0 {
+			sweepTicker = time.NewTicker(interval)
+			sweep = sweepTicker.C
+		}
+		if interval := proc.syncInterval(); interval > 0 {
+			flushTicker = time.NewTicker(interval)
+			flush = flushTicker.C
+		}
+	}
+	resetTimers()
+	defer stopTimers()
+
+	// once stop has been requested, keep processing requests
+	// until every client connection has been cleaned up
+	for !proc.stop || atomic.LoadInt32(&proc.active) > 0 {
+		proc.countQueueMemory()
+		var r client.Request
+		select {
+		case r = <-proc.ch:
+		case now := <-sweep:
+			if proc.idle != nil {
+				proc.removeIdleDestinations(now)
+			}
+			proc.checkConsumers(now)
+			continue
+		case <-compact:
+			// errors are logged
+			_, _ = proc.compact()
+			continue
+		case <-flush:
+			if proc.durability.dirty {
+				proc.sync()
+			}
+			continue
+		case destination, ok := <-changes:
+			if !ok {
+				proc.log.Error("stopped receiving changes to shared queue storage")
+				changes = nil
+			} else if err := proc.qm.Find(destination).Dispatch(); err != nil {
+				proc.destinationLog(destination).Errorf("dispatching frames failed: %v", err)
+			}
+			continue
+		case fn := <-proc.calls:
+			fn()
+			if proc.timersChanged {
+				proc.timersChanged = false
+				resetTimers()
+			}
+			continue
+		}
+
+		if r.Op == client.EnqueueOp && proc.statistics(r.Frame) {
+			proc.processed(r)
+			continue
+		}
+
+		if proc.shard != nil && proc.shard.handle(&r) {
+			proc.processed(r)
+			continue
+		}
+
 		switch r.Op {
 		case client.SubscribeOp:
+			if proc.subs.Add(r.Sub) {
+				proc.setSlowConsumer(r.Sub)
+				proc.subscribed(r.Sub)
+				proc.events.Subscribed(r.Sub)
+			}
+			proc.touch(r.Sub.Destination())
 			if isQueueDestination(r.Sub.Destination()) {
+				proc.vt.Register(r.Sub.Destination())
 				queue := proc.qm.Find(r.Sub.Destination())
 				// todo error handling
 				queue.Subscribe(r.Sub)
 			} else {
 				topic := proc.tm.Find(r.Sub.Destination())
+				if seq, t, ok := r.Sub.ReplayFrom(); ok {
+					topic.Replay(r.Sub, seq, t)
+				}
 				topic.Subscribe(r.Sub)
 			}
 
 		case client.UnsubscribeOp:
+			if proc.subs.Remove(r.Sub) {
+				proc.unsubscribed(r.Sub)
+				proc.events.Unsubscribed(r.Sub)
+			}
+			proc.touch(r.Sub.Destination())
 			if isQueueDestination(r.Sub.Destination()) {
 				queue := proc.qm.Find(r.Sub.Destination())
 				// todo error handling
@@ -68,13 +266,38 @@ func (proc *requestProcessor) Serve(l net.Listener) error {
 				// should not happen, already checked in lower layer
 				panic("missing destination")
 			}
+			proc.touch(destination)
+			span := stomp.StartSpan(proc.server.Tracer, "enqueue", r.Frame.Header, destination)
+			proc.events.Sent(r.Conn, destination, r.Frame)
+			var enqueued *MessageTraceRecord
+			if proc.traceReceived(r, destination) {
+				now := time.Now()
+				r.Frame.Header.Set(TraceEnqueuedHeader, formatTraceTime(now))
+				enqueued = proc.traceRecord(TraceEnqueued, now, r.Frame, r.Conn, nil)
+			}
 
+			var err error
 			if isQueueDestination(destination) {
+				// the queue counts and handles expired messages
 				queue := proc.qm.Find(destination)
-				queue.Enqueue(r.Frame)
+				if err = queue.Enqueue(r.Frame); err != nil {
+					proc.destinationLog(destination).Errorf("[%s] enqueue failed: %v", r.Id, err)
+				} else {
+					proc.stored(destination)
+				}
+			} else if isExpired(r.Frame) {
+				proc.expire(r.Frame)
 			} else {
-				topic := proc.tm.Find(destination)
-				topic.Enqueue(r.Frame)
+				proc.countOrphaned(destination)
+				proc.mirror(destination, r.Frame)
+				for _, queue := range proc.vt.Queues(destination) {
+					proc.copyToQueue(queue, destination, r.Frame)
+				}
+				proc.tm.Enqueue(destination, r.Frame)
+			}
+			span.End(err)
+			if err == nil {
+				proc.emitTrace(enqueued)
 			}
 
 		case client.RequeueOp:
@@ -86,13 +309,153 @@ func (proc *requestProcessor) Serve(l net.Listener) error {
 
 			// only requeue to queues, should never happen for topics
 			if isQueueDestination(destination) {
+				proc.emitTrace(proc.traceRecord(TraceRequeued, time.Now(), r.Frame, nil, r.Sub))
+				proc.touch(destination)
 				queue := proc.qm.Find(destination)
-				queue.Requeue(r.Frame)
+				if err := queue.Requeue(r.Frame); err != nil {
+					proc.destinationLog(destination).Errorf("[%s] requeue failed: %v", r.Id, err)
+				}
+			}
+
+		case client.AckOp:
+			if isQueueDestination(r.Sub.Destination()) {
+				queue := proc.qm.Find(r.Sub.Destination())
+				if err := queue.Ack(r.Frame); err != nil {
+					proc.destinationLog(r.Sub.Destination()).Errorf("[%s] ack failed: %v", r.Id, err)
+				}
+				if proc.arch != nil {
+					proc.arch.Add(r.Frame, r.Id)
+				}
+			}
+			proc.events.Acked(r.Sub, r.Frame)
+			proc.traceAcked(r.Sub, r.Frame)
+
+		case client.CommitBeginOp:
+			proc.durability.depth++
+			if b, ok := proc.qstore.(queue.BatchStorage); ok {
+				b.BeginBatch()
+			}
+
+		case client.CommitEndOp:
+			proc.durability.depth--
+			if b, ok := proc.qstore.(queue.BatchStorage); ok {
+				if err := b.EndBatch(); err != nil {
+					proc.log.Errorf("[%s] writing transaction to queue storage failed: %v", r.Id, err)
+				}
 			}
+
+		case client.ConnectedOp:
+			proc.clients[r.Conn] = struct{}{}
+			proc.events.Connected(r.Conn)
+
+		case client.DisconnectedOp:
+			if _, ok := proc.clients[r.Conn]; ok {
+				delete(proc.clients, r.Conn)
+				proc.server.wireLog.removeConnection(r.Conn.Id())
+				proc.events.Disconnected(r.Conn)
+			}
+			atomic.AddInt32(&proc.active, -1)
 		}
+		proc.processed(r)
 	}
-	// this is no longer required for go 1.1
-	panic("not reached")
+
+	// the clients have disconnected, but the last changes to
+	// the queue storage must still be made durable
+	if proc.durability.dirty {
+		proc.sync()
+	}
+
+	if proc.shard != nil {
+		proc.shard.close()
+	}
+
+	if proc.server.SnapshotFile != "" {
+		proc.stopErr = proc.saveSnapshot(proc.server.SnapshotFile)
+	}
+
+	// the sink is passed the records of the last acknowledgements
+	if proc.arch != nil {
+		proc.arch.Stop()
+	}
+	proc.qstore.Stop()
+	return ErrServerClosed
+}
+
+// Records activity for a destination.
+func (proc *requestProcessor) touch(destination string) {
+	now := time.Now()
+	proc.idle.Touch(destination, now)
+	proc.consumers.Update(destination, proc.subs.Count(destination), now)
+}
+
+// Returns the interval between checks for idle destinations and
+// consumer shortages, or zero if neither is required.
+func (proc *requestProcessor) sweepInterval() time.Duration {
+	interval := proc.consumers.interval()
+	if proc.idle != nil {
+		// check for idle destinations twice per timeout period
+		if d := proc.idle.timeout / 2; interval == 0 || d < interval {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// Sends a copy of a message sent to a topic to the mirror queue, if the
+// destination policy for the topic specifies one.
+func (proc *requestProcessor) mirror(destination string, f *frame.Frame) {
+	policy := findPolicy(proc.server.Policies, destination)
+	if policy == nil || policy.MirrorQueue == "" {
+		return
+	}
+
+	proc.copyToQueue(policy.MirrorQueue, destination, f)
+}
+
+// Sends a copy of a message sent to destination to a queue.
+func (proc *requestProcessor) copyToQueue(queue, destination string, f *frame.Frame) {
+	cf := f.Clone()
+	cf.Header.Set(frame.Destination, queue)
+	cf.Header.Set(OriginalDestinationHeader, destination)
+	if proc.shard != nil {
+		if owner := proc.shard.owner(queue); owner != "" {
+			proc.shard.forward(owner, cf, nil, "")
+			return
+		}
+	}
+	proc.touch(queue)
+	if proc.qm.Find(queue).Enqueue(cf) == nil {
+		proc.stored(queue)
+	}
+}
+
+// Moves an expired message to the expiry destination, if the destination
+// policy for the message's destination specifies one.
+func (proc *requestProcessor) expire(f *frame.Frame) {
+	proc.emitTrace(proc.traceRecord(TraceExpired, time.Now(), f, nil, nil))
+	destination := f.Header.Get(frame.Destination)
+	policy := findPolicy(proc.server.Policies, destination)
+	if policy == nil || policy.ExpiryDestination == "" {
+		proc.destinationLog(destination).Debug("discarding expired message")
+		return
+	}
+
+	f.Header.Set(OriginalExpiresHeader, f.Header.Get(frame.Expires))
+	f.Header.Del(frame.Expires)
+	f.Header.Set(frame.Destination, policy.ExpiryDestination)
+	f.Header.Set(OriginalDestinationHeader, destination)
+	proc.touch(policy.ExpiryDestination)
+	if isQueueDestination(policy.ExpiryDestination) {
+		proc.qm.Find(policy.ExpiryDestination).Enqueue(f)
+	} else {
+		proc.tm.Enqueue(policy.ExpiryDestination, f)
+	}
+}
+
+// Reports whether the time in a message's "expires" header has passed.
+func isExpired(f *frame.Frame) bool {
+	expires, ok, err := f.Header.Expires()
+	return ok && err == nil && !time.Now().Before(expires)
 }
 
 func isQueueDestination(dest string) bool {
@@ -100,7 +463,8 @@ func isQueueDestination(dest string) bool {
 }
 
 func (proc *requestProcessor) Listen(l net.Listener) {
-	config := newConfig(proc.server)
+	defer close(proc.listening)
+	config := newConfig(proc)
 	timeout := time.Duration(0) // how long to sleep on accept failure
 	for {
 		rw, err := l.Accept()
@@ -114,27 +478,42 @@ func (proc *requestProcessor) Listen(l net.Listener) {
 				if max := 5 * time.Second; timeout > max {
 					timeout = max
 				}
-				proc.server.Log.Infof("stomp: Accept error: %v; retrying in %v", err, timeout)
+				proc.log.Infof("stomp: Accept error: %v; retrying in %v", err, timeout)
 				time.Sleep(timeout)
 				continue
 			}
 			return
 		}
 		timeout = 0
-		// TODO: need to pass Server to connection so it has access to
-		// configuration parameters.
-		_ = client.NewConn(config, rw, proc.ch)
+		proc.accept(config, rw)
 	}
 	// This is no longer required for go 1.1
 	panic("not reached")
 }
 
+// Serves a client connection, which is closed when the server
+// shuts down.
+func (proc *requestProcessor) accept(config *config, rw net.Conn) {
+	atomic.AddInt32(&proc.active, 1)
+	if dir := proc.server.RecordDir; dir != "" {
+		if recorded, err := record.Dir(dir).Record(rw); err != nil {
+			proc.log.Warningf("failed to record connection from %s: %v", rw.RemoteAddr(), err)
+		} else {
+			rw = recorded
+		}
+	}
+	// TODO: need to pass Server to connection so it has access to
+	// configuration parameters.
+	_ = client.NewConn(config, proc.conns.Add(proc.server.Metrics.Conn(rw)), proc.ch)
+}
+
 type config struct {
 	server *Server
+	memory *client.MemoryMeter
 }
 
-func newConfig(s *Server) *config {
-	return &config{server: s}
+func newConfig(proc *requestProcessor) *config {
+	return &config{server: proc.server, memory: proc.memory}
 }
 
 func (c *config) HeartBeat() time.Duration {
@@ -145,14 +524,84 @@ func (c *config) HeartBeat() time.Duration {
 }
 
 func (c *config) Authenticate(login, passcode string) bool {
-	if c.server.Authenticator != nil {
-		return c.server.Authenticator.Authenticate(login, passcode)
+	if auth := c.server.authenticator(); auth != nil {
+		return auth.Authenticate(login, passcode)
 	}
 
 	// no authentication defined
 	return true
 }
 
+func (c *config) Permitted(command string) bool {
+	var feature Feature
+	switch command {
+	case frame.BEGIN, frame.COMMIT, frame.ABORT:
+		feature = FeatureTransactions
+	case frame.NACK:
+		feature = FeatureNack
+	case frame.SUBSCRIBE, frame.UNSUBSCRIBE:
+		feature = FeatureSubscribe
+	case frame.SEND:
+		feature = FeatureSend
+	}
+	return c.server.disabledFeatures()&feature == 0
+}
+
 func (c *config) Logger() stomp.Logger {
-	return c.server.Log
+	return c.server.logger(ClientComponent)
+}
+
+func (c *config) Metrics() *metrics.Metrics {
+	return c.server.Metrics
+}
+
+func (c *config) Tracer() stomp.Tracer {
+	return c.server.Tracer
+}
+
+func (c *config) Interceptors() []client.Interceptor {
+	// wire logging sees the frames as they are received and sent, and
+	// the other interceptors only see messages with valid payloads
+	interceptors := []client.Interceptor{&c.server.wireLog, &payloadValidation{server: c.server}}
+	return append(interceptors, c.server.Interceptors...)
+}
+
+func (c *config) Faults() client.FaultInjector {
+	return c.server.Faults
+}
+
+func (c *config) ServerName() string {
+	name := c.server.Name
+	if name == "" {
+		name = DefaultName
+	}
+	return name + "/" + Version
+}
+
+func (c *config) Capabilities() stomp.Capabilities {
+	return stomp.Capabilities{
+		Wildcards:    true,
+		Persistence:  c.server.QueueStorage != nil,
+		MaxFrameSize: c.server.MaxFrameSize,
+	}
+}
+
+func (c *config) MaxPendingWrites() int {
+	return c.server.MaxPendingWrites
+}
+
+func (c *config) MaxPendingReads() int {
+	return c.server.MaxPendingReads
+}
+
+func (c *config) HeartBeatGracePeriodMultiplier() float64 {
+	return c.server.HeartBeatGracePeriodMultiplier
+}
+
+func (c *config) Validation() frame.ValidationMode {
+	return c.server.Validation
+}
+
+func (c *config) Memory() *client.MemoryMeter {
+	return c.memory
 }
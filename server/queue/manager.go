@@ -1,9 +1,15 @@
 package queue
 
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
 // Queue manager.
 type Manager struct {
-	qstore Storage // handles queue storage
-	queues map[string]*Queue
+	qstore  Storage // handles queue storage
+	queues  map[string]*Queue
+	expired func(f *frame.Frame) // handles expired frames
+	mode    func(destination string) DispatchMode
 }
 
 // Create a queue manager with the specified queue storage mechanism
@@ -12,12 +18,59 @@ func NewManager(qstore Storage) *Manager {
 	return qm
 }
 
+// SetExpiredHandler sets a function that is called with each frame that
+// expires before it can be sent to a subscription. A frame expires when
+// the time in its "expires" header has passed. If no function is set,
+// expired frames are discarded.
+func (qm *Manager) SetExpiredHandler(fn func(f *frame.Frame)) {
+	qm.expired = fn
+}
+
+// SetDispatchMode sets a function that determines the dispatch mode
+// of each queue when it is created. If no function is set, all queues
+// use RoundRobin.
+func (qm *Manager) SetDispatchMode(fn func(destination string) DispatchMode) {
+	qm.mode = fn
+}
+
+func (qm *Manager) handleExpired(f *frame.Frame) {
+	if qm.expired != nil {
+		qm.expired(f)
+	}
+}
+
 // Finds the queue for the given destination, and creates it if necessary.
 func (qm *Manager) Find(destination string) *Queue {
 	q, ok := qm.queues[destination]
 	if !ok {
-		q = newQueue(destination, qm.qstore)
+		mode := RoundRobin
+		if qm.mode != nil {
+			mode = qm.mode(destination)
+		}
+		q = newQueue(destination, qm.qstore, qm.handleExpired, mode)
 		qm.queues[destination] = q
 	}
 	return q
 }
+
+// Lookup returns the queue for the given destination, or nil if the
+// queue has not been created.
+func (qm *Manager) Lookup(destination string) *Queue {
+	return qm.queues[destination]
+}
+
+// Destinations returns the destinations of all queues.
+func (qm *Manager) Destinations() []string {
+	destinations := make([]string, 0, len(qm.queues))
+	for destination := range qm.queues {
+		destinations = append(destinations, destination)
+	}
+	return destinations
+}
+
+// Remove the queue for the given destination. Frames in queue storage
+// are not affected, and are available to the queue if it is created
+// again by Find.
+func (qm *Manager) Remove(destination string) {
+	delete(qm.queues, destination)
+}
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"github.com/go-stomp/stomp/v3/frame"
 	. "gopkg.in/check.v1"
 )
 
@@ -19,3 +20,19 @@ func (s *ManagerSuite) TestManager(c *C) {
 
 	c.Assert(mgr.Find("/queue/1"), Equals, q1)
 }
+
+func (s *ManagerSuite) TestRemove(c *C) {
+	mgr := NewManager(NewMemoryQueueStorage())
+
+	q1 := mgr.Find("/queue/1")
+	c.Assert(q1.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "/queue/1")), IsNil)
+	c.Check(q1.Len(), Equals, 1)
+	mgr.Remove("/queue/1")
+
+	// frames in storage are available to the new queue
+	q2 := mgr.Find("/queue/1")
+	c.Assert(q2 == q1, Equals, false)
+	f, err := mgr.qstore.Dequeue("/queue/1")
+	c.Assert(err, IsNil)
+	c.Check(f, NotNil)
+}
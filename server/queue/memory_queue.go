@@ -9,6 +9,7 @@ import (
 // In-memory implementation of the QueueStorage interface.
 type MemoryQueueStorage struct {
 	lists map[string]*list.List
+	bytes int64 // sum of the sizes of the frames
 }
 
 func NewMemoryQueueStorage() Storage {
@@ -23,6 +24,7 @@ func (m *MemoryQueueStorage) Enqueue(queue string, frame *frame.Frame) error {
 		m.lists[queue] = l
 	}
 	l.PushBack(frame)
+	m.bytes += int64(frame.Size())
 
 	return nil
 }
@@ -37,6 +39,7 @@ func (m *MemoryQueueStorage) Requeue(queue string, frame *frame.Frame) error {
 		m.lists[queue] = l
 	}
 	l.PushFront(frame)
+	m.bytes += int64(frame.Size())
 
 	return nil
 }
@@ -54,17 +57,60 @@ func (m *MemoryQueueStorage) Dequeue(queue string) (*frame.Frame, error) {
 		return nil, nil
 	}
 
-	return l.Remove(element).(*frame.Frame), nil
+	f := l.Remove(element).(*frame.Frame)
+	m.bytes -= int64(f.Size())
+	if l.Len() == 0 {
+		// release lists for queues that are no longer used
+		delete(m.lists, queue)
+	}
+	return f, nil
+}
+
+// Acknowledged frames have already been removed from the queue,
+// so this has no effect.
+func (m *MemoryQueueStorage) Ack(queue string, frame *frame.Frame) error {
+	return nil
+}
+
+// Returns the number of frames in the queue.
+func (m *MemoryQueueStorage) Len(queue string) int {
+	if l, ok := m.lists[queue]; ok {
+		return l.Len()
+	}
+	return 0
+}
+
+// Returns the approximate number of bytes of memory held by the
+// frames in all queues.
+func (m *MemoryQueueStorage) MemoryBytes() int64 {
+	return m.bytes
+}
+
+// Calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false.
+func (m *MemoryQueueStorage) Iterate(queue string, fn func(frame *frame.Frame) bool) error {
+	l, ok := m.lists[queue]
+	if !ok {
+		return nil
+	}
+	for e := l.Front(); e != nil; e = e.Next() {
+		if !fn(e.Value.(*frame.Frame)) {
+			break
+		}
+	}
+	return nil
 }
 
 // Called at server startup. Allows the queue storage
 // to perform any initialization.
 func (m *MemoryQueueStorage) Start() {
 	m.lists = make(map[string]*list.List)
+	m.bytes = 0
 }
 
 // Called prior to server shutdown. Allows the queue storage
 // to perform any cleanup.
 func (m *MemoryQueueStorage) Stop() {
 	m.lists = nil
+	m.bytes = 0
 }
@@ -62,3 +62,35 @@ func (s *MemoryQueueSuite) Test1(c *C) {
 	c.Check(err, IsNil)
 	c.Assert(f, IsNil)
 }
+
+func (s *MemoryQueueSuite) TestLenAndIterate(c *C) {
+	mq := NewMemoryQueueStorage()
+	mq.Start()
+	c.Check(mq.Len("/queue/test"), Equals, 0)
+	c.Check(mq.Iterate("/queue/test", func(f *frame.Frame) bool {
+		c.Error("unexpected frame")
+		return true
+	}), IsNil)
+
+	for _, id := range []string{"msg-002", "msg-003"} {
+		c.Assert(mq.Enqueue("/queue/test", frame.New(frame.MESSAGE, frame.MessageId, id)), IsNil)
+	}
+	c.Assert(mq.Requeue("/queue/test", frame.New(frame.MESSAGE, frame.MessageId, "msg-001")), IsNil)
+	c.Check(mq.Len("/queue/test"), Equals, 3)
+
+	var ids []string
+	err := mq.Iterate("/queue/test", func(f *frame.Frame) bool {
+		ids = append(ids, f.Header.Get(frame.MessageId))
+		return len(ids) < 2
+	})
+	c.Assert(err, IsNil)
+	c.Check(ids, DeepEquals, []string{"msg-001", "msg-002"})
+
+	// iterating does not remove frames
+	c.Check(mq.Len("/queue/test"), Equals, 3)
+	f, err := mq.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(frame.MessageId), Equals, "msg-001")
+	c.Check(mq.Ack("/queue/test", f), IsNil)
+	c.Check(mq.Len("/queue/test"), Equals, 2)
+}
@@ -0,0 +1,243 @@
+package queue
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// PagingStorage is a Storage that limits the number of frames kept in
+// memory for each queue. Frames at the head of a queue are kept in the
+// memory storage, up to the limit. Frames beyond the limit are written
+// to a page file on disk, and are read back into memory as the head of
+// the queue is dequeued. Frames are dequeued in the order that they
+// were enqueued, regardless of where they are kept.
+//
+// Frames requeued to the head of a queue are always kept in memory, so
+// a queue can exceed the limit by the number of frames that were sent
+// to clients and not acknowledged.
+//
+// Page files only hold frames while the server is running. They are
+// removed when the storage is stopped.
+type PagingStorage struct {
+	memory Storage
+	dir    string
+	limit  int
+	pages  map[string]*pageFile
+}
+
+// A page file holds the frames at the tail of a queue.
+type pageFile struct {
+	file     *os.File
+	sizes    []int64 // size of each frame in the file, from the head
+	readOff  int64   // offset of the first frame in the file
+	writeOff int64   // offset at which the next frame is written
+}
+
+// NewPagingStorage creates a storage that keeps up to limit frames of
+// each queue in the memory storage, and writes the rest to page files
+// in dir. If dir is empty, the default directory for temporary files
+// is used.
+func NewPagingStorage(memory Storage, dir string, limit int) *PagingStorage {
+	return &PagingStorage{
+		memory: memory,
+		dir:    dir,
+		limit:  limit,
+		pages:  make(map[string]*pageFile),
+	}
+}
+
+// Enqueue implements the Storage interface.
+func (s *PagingStorage) Enqueue(queue string, f *frame.Frame) error {
+	if _, ok := s.pages[queue]; !ok && s.memory.Len(queue) < s.limit {
+		return s.memory.Enqueue(queue, f)
+	}
+	return s.pageOut(queue, f)
+}
+
+// Requeue implements the Storage interface.
+func (s *PagingStorage) Requeue(queue string, f *frame.Frame) error {
+	return s.memory.Requeue(queue, f)
+}
+
+// Dequeue implements the Storage interface.
+func (s *PagingStorage) Dequeue(queue string) (*frame.Frame, error) {
+	f, err := s.memory.Dequeue(queue)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.pageIn(queue); err != nil {
+		return nil, err
+	}
+	if f == nil {
+		// the memory storage was empty, but there might have been
+		// frames in the page file
+		return s.memory.Dequeue(queue)
+	}
+	return f, nil
+}
+
+// Ack implements the Storage interface.
+func (s *PagingStorage) Ack(queue string, f *frame.Frame) error {
+	return s.memory.Ack(queue, f)
+}
+
+// Len implements the Storage interface.
+func (s *PagingStorage) Len(queue string) int {
+	n := s.memory.Len(queue)
+	if p, ok := s.pages[queue]; ok {
+		n += len(p.sizes)
+	}
+	return n
+}
+
+// MemoryBytes implements the MemoryStorage interface. Frames in page
+// files are not counted. Returns zero if the memory storage does not
+// implement MemoryStorage.
+func (s *PagingStorage) MemoryBytes() int64 {
+	if m, ok := s.memory.(MemoryStorage); ok {
+		return m.MemoryBytes()
+	}
+	return 0
+}
+
+// Iterate implements the Storage interface. Frames in the page file
+// are read from disk, and are not kept in memory.
+func (s *PagingStorage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	more := true
+	err := s.memory.Iterate(queue, func(f *frame.Frame) bool {
+		more = fn(f)
+		return more
+	})
+	if err != nil || !more {
+		return err
+	}
+
+	p, ok := s.pages[queue]
+	if !ok {
+		return nil
+	}
+	off := p.readOff
+	for _, size := range p.sizes {
+		f, err := p.read(off, size)
+		if err != nil {
+			return err
+		}
+		if !fn(f) {
+			break
+		}
+		off += size
+	}
+	return nil
+}
+
+// Paged returns the number of frames of the queue that are in its
+// page file rather than in memory.
+func (s *PagingStorage) Paged(queue string) int {
+	if p, ok := s.pages[queue]; ok {
+		return len(p.sizes)
+	}
+	return 0
+}
+
+// BeginBatch implements the BatchStorage interface. Has no effect
+// if the memory storage does not implement BatchStorage.
+func (s *PagingStorage) BeginBatch() {
+	if b, ok := s.memory.(BatchStorage); ok {
+		b.BeginBatch()
+	}
+}
+
+// EndBatch implements the BatchStorage interface.
+func (s *PagingStorage) EndBatch() error {
+	if b, ok := s.memory.(BatchStorage); ok {
+		return b.EndBatch()
+	}
+	return nil
+}
+
+// Start implements the Storage interface.
+func (s *PagingStorage) Start() {
+	s.pages = make(map[string]*pageFile)
+	s.memory.Start()
+}
+
+// Stop implements the Storage interface. Frames in page files
+// are discarded, and the files are removed.
+func (s *PagingStorage) Stop() {
+	for queue := range s.pages {
+		s.removePage(queue)
+	}
+	s.memory.Stop()
+}
+
+// Writes a frame to the end of the page file for the queue,
+// creating the file if necessary.
+func (s *PagingStorage) pageOut(queue string, f *frame.Frame) error {
+	var buf bytes.Buffer
+	if err := frame.NewWriter(&buf).Write(f); err != nil {
+		return err
+	}
+
+	p, ok := s.pages[queue]
+	if !ok {
+		file, err := ioutil.TempFile(s.dir, "queue-*.page")
+		if err != nil {
+			return err
+		}
+		p = &pageFile{file: file}
+		s.pages[queue] = p
+	}
+	if _, err := p.file.WriteAt(buf.Bytes(), p.writeOff); err != nil {
+		return err
+	}
+	p.writeOff += int64(buf.Len())
+	p.sizes = append(p.sizes, int64(buf.Len()))
+	return nil
+}
+
+// Moves frames from the page file for the queue to the memory
+// storage until the memory storage is full. The page file is
+// removed once it is empty.
+func (s *PagingStorage) pageIn(queue string) error {
+	p, ok := s.pages[queue]
+	if !ok {
+		return nil
+	}
+	for len(p.sizes) > 0 && s.memory.Len(queue) < s.limit {
+		f, err := p.read(p.readOff, p.sizes[0])
+		if err != nil {
+			return err
+		}
+		if err = s.memory.Enqueue(queue, f); err != nil {
+			return err
+		}
+		p.readOff += p.sizes[0]
+		p.sizes = p.sizes[1:]
+	}
+	if len(p.sizes) == 0 {
+		return s.removePage(queue)
+	}
+	return nil
+}
+
+func (s *PagingStorage) removePage(queue string) error {
+	p := s.pages[queue]
+	delete(s.pages, queue)
+	err := p.file.Close()
+	if removeErr := os.Remove(p.file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// Reads the frame of the given size at offset off.
+func (p *pageFile) read(off, size int64) (*frame.Frame, error) {
+	b := make([]byte, size)
+	if _, err := p.file.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return frame.NewReader(bytes.NewReader(b)).Read()
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"io/ioutil"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type PagingSuite struct{}
+
+var _ = Suite(&PagingSuite{})
+
+func (s *PagingSuite) TestOrder(c *C) {
+	dir := c.MkDir()
+	memory := NewMemoryQueueStorage()
+	ps := NewPagingStorage(memory, dir, 2)
+
+	for _, body := range []string{"1", "2", "3", "4", "5"} {
+		c.Assert(ps.Enqueue("/queue/test", newTestFrame(body, false)), IsNil)
+	}
+	c.Assert(ps.Enqueue("/queue/other", newTestFrame("other", false)), IsNil)
+
+	// only the head of the queue is kept in memory
+	c.Check(memory.Len("/queue/test"), Equals, 2)
+	c.Check(ps.Paged("/queue/test"), Equals, 3)
+	c.Check(ps.Paged("/queue/other"), Equals, 0)
+	c.Check(ps.Len("/queue/test"), Equals, 5)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 1)
+
+	var bodies []string
+	err = ps.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return len(bodies) < 4
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"1", "2", "3", "4"})
+
+	// frames are paged in as the head is dequeued
+	f, err := ps.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "1")
+	c.Check(f.Header.Get(frame.Destination), Equals, "/queue/test")
+	c.Check(memory.Len("/queue/test"), Equals, 2)
+	c.Check(ps.Paged("/queue/test"), Equals, 2)
+
+	// requeued frames stay in memory, and new frames go after paged frames
+	c.Assert(ps.Requeue("/queue/test", f), IsNil)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("6", false)), IsNil)
+	c.Check(memory.Len("/queue/test"), Equals, 3)
+	c.Check(ps.Paged("/queue/test"), Equals, 3)
+
+	c.Check(dequeueBodies(c, ps, "/queue/test"), DeepEquals, []string{"1", "2", "3", "4", "5", "6"})
+	c.Check(ps.Len("/queue/test"), Equals, 0)
+
+	// the page file is removed once it is empty
+	files, err = ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+}
+
+func (s *PagingSuite) TestStop(c *C) {
+	dir := c.MkDir()
+	ps := NewPagingStorage(NewMemoryQueueStorage(), dir, 1)
+	ps.Start()
+	for _, body := range []string{"1", "2", "3"} {
+		c.Assert(ps.Enqueue("/queue/test", newTestFrame(body, false)), IsNil)
+	}
+	ps.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+}
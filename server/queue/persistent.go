@@ -0,0 +1,238 @@
+package queue
+
+import (
+	"container/list"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// IsPersistent reports whether a frame has the "persistent:true" header.
+func IsPersistent(f *frame.Frame) bool {
+	return f.Header.Get(frame.Persistent) == "true"
+}
+
+// PersistentStorage is a Storage that keeps frames with the
+// "persistent:true" header in durable storage, and all other
+// frames in memory. Frames are dequeued in the order that they
+// were enqueued, regardless of where they are kept.
+//
+// Frames found in durable storage that were not added by this
+// PersistentStorage, such as frames stored before a restart, are
+// dequeued once all other frames in the queue have been dequeued.
+type PersistentStorage struct {
+	durable Storage
+	queues  map[string]*list.List // for each queue, frames kept in memory, nil for frames in durable storage
+	bytes   int64                 // sum of the sizes of the frames kept in memory
+}
+
+// NewPersistentStorage creates a storage that keeps persistent
+// frames in the durable storage.
+func NewPersistentStorage(durable Storage) *PersistentStorage {
+	return &PersistentStorage{
+		durable: durable,
+		queues:  make(map[string]*list.List),
+	}
+}
+
+func (s *PersistentStorage) find(queue string) *list.List {
+	l, ok := s.queues[queue]
+	if !ok {
+		l = list.New()
+		s.queues[queue] = l
+	}
+	return l
+}
+
+// Enqueue implements the Storage interface.
+func (s *PersistentStorage) Enqueue(queue string, f *frame.Frame) error {
+	if IsPersistent(f) {
+		if err := s.durable.Enqueue(queue, f); err != nil {
+			return err
+		}
+		s.find(queue).PushBack(nil)
+	} else {
+		s.find(queue).PushBack(f)
+		s.bytes += int64(f.Size())
+	}
+	return nil
+}
+
+// Requeue implements the Storage interface.
+func (s *PersistentStorage) Requeue(queue string, f *frame.Frame) error {
+	if IsPersistent(f) {
+		if err := s.durable.Requeue(queue, f); err != nil {
+			return err
+		}
+		s.find(queue).PushFront(nil)
+	} else {
+		s.find(queue).PushFront(f)
+		s.bytes += int64(f.Size())
+	}
+	return nil
+}
+
+// Dequeue implements the Storage interface.
+func (s *PersistentStorage) Dequeue(queue string) (*frame.Frame, error) {
+	l, ok := s.queues[queue]
+	if !ok || l.Len() == 0 {
+		// there might be frames left from a previous run
+		return s.durable.Dequeue(queue)
+	}
+
+	front := l.Front()
+	if f, _ := front.Value.(*frame.Frame); f != nil {
+		s.remove(queue, l, front)
+		s.bytes -= int64(f.Size())
+		return f, nil
+	}
+	f, err := s.durable.Dequeue(queue)
+	if err != nil {
+		return nil, err
+	}
+	s.remove(queue, l, front)
+	return f, nil
+}
+
+// Ack implements the Storage interface.
+func (s *PersistentStorage) Ack(queue string, f *frame.Frame) error {
+	if IsPersistent(f) {
+		return s.durable.Ack(queue, f)
+	}
+	return nil
+}
+
+// Len implements the Storage interface.
+func (s *PersistentStorage) Len(queue string) int {
+	n := s.durable.Len(queue)
+	if l, ok := s.queues[queue]; ok {
+		for e := l.Front(); e != nil; e = e.Next() {
+			if f, _ := e.Value.(*frame.Frame); f != nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// MemoryBytes implements the MemoryStorage interface. Frames in the
+// durable storage are not counted.
+func (s *PersistentStorage) MemoryBytes() int64 {
+	return s.bytes
+}
+
+// Iterate implements the Storage interface.
+func (s *PersistentStorage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	var durable []*frame.Frame
+	err := s.durable.Iterate(queue, func(f *frame.Frame) bool {
+		durable = append(durable, f)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if l, ok := s.queues[queue]; ok {
+		for e := l.Front(); e != nil; e = e.Next() {
+			f, _ := e.Value.(*frame.Frame)
+			if f == nil && len(durable) > 0 {
+				f, durable = durable[0], durable[1:]
+			}
+			if f != nil && !fn(f) {
+				return nil
+			}
+		}
+	}
+
+	// frames from a previous run come last
+	for _, f := range durable {
+		if !fn(f) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *PersistentStorage) remove(queue string, l *list.List, e *list.Element) {
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(s.queues, queue)
+	}
+}
+
+// BeginBatch implements the BatchStorage interface. Has no effect
+// if the durable storage does not implement BatchStorage.
+func (s *PersistentStorage) BeginBatch() {
+	if b, ok := s.durable.(BatchStorage); ok {
+		b.BeginBatch()
+	}
+}
+
+// EndBatch implements the BatchStorage interface.
+func (s *PersistentStorage) EndBatch() error {
+	if b, ok := s.durable.(BatchStorage); ok {
+		return b.EndBatch()
+	}
+	return nil
+}
+
+// Compact implements the CompactStorage interface. Has no effect
+// if the durable storage does not implement CompactStorage.
+func (s *PersistentStorage) Compact() (CompactStats, error) {
+	c, ok := s.durable.(CompactStorage)
+	if !ok {
+		return CompactStats{}, nil
+	}
+	stats, err := c.Compact()
+
+	// frames are discarded from the head of each queue
+	for queue, l := range s.queues {
+		excess := -s.durable.Len(queue)
+		for e := l.Front(); e != nil; e = e.Next() {
+			if e.Value == nil {
+				excess++
+			}
+		}
+		for e := l.Front(); e != nil && excess > 0; {
+			next := e.Next()
+			if e.Value == nil {
+				s.remove(queue, l, e)
+				excess--
+			}
+			e = next
+		}
+	}
+	return stats, err
+}
+
+// Sync implements the SyncStorage interface. Has no effect
+// if the durable storage does not implement SyncStorage.
+func (s *PersistentStorage) Sync() error {
+	if d, ok := s.durable.(SyncStorage); ok {
+		return d.Sync()
+	}
+	return nil
+}
+
+// Changes implements the SharedStorage interface. Returns nil
+// if the durable storage does not implement SharedStorage.
+func (s *PersistentStorage) Changes() <-chan string {
+	if d, ok := s.durable.(SharedStorage); ok {
+		return d.Changes()
+	}
+	return nil
+}
+
+// Start implements the Storage interface.
+func (s *PersistentStorage) Start() {
+	s.queues = make(map[string]*list.List)
+	s.bytes = 0
+	s.durable.Start()
+}
+
+// Stop implements the Storage interface. Frames kept in memory
+// are discarded.
+func (s *PersistentStorage) Stop() {
+	s.durable.Stop()
+	s.queues = nil
+	s.bytes = 0
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type PersistentSuite struct{}
+
+var _ = Suite(&PersistentSuite{})
+
+func newTestFrame(body string, persistent bool) *frame.Frame {
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/test")
+	if persistent {
+		f.Header.Add(frame.Persistent, "true")
+	}
+	f.Body = []byte(body)
+	return f
+}
+
+func dequeueBodies(c *C, s Storage, queue string) []string {
+	var bodies []string
+	for {
+		f, err := s.Dequeue(queue)
+		c.Assert(err, IsNil)
+		if f == nil {
+			return bodies
+		}
+		bodies = append(bodies, string(f.Body))
+	}
+}
+
+func (s *PersistentSuite) TestOrder(c *C) {
+	durable := NewMemoryQueueStorage()
+	ps := NewPersistentStorage(durable)
+
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("1", true)), IsNil)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("2", false)), IsNil)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("3", true)), IsNil)
+	c.Assert(ps.Requeue("/queue/test", newTestFrame("0", false)), IsNil)
+	c.Assert(ps.Requeue("/queue/test", newTestFrame("-1", true)), IsNil)
+
+	// only persistent frames are kept in durable storage
+	f, err := durable.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "-1")
+	c.Assert(durable.Requeue("/queue/test", f), IsNil)
+
+	c.Check(ps.Len("/queue/test"), Equals, 5)
+	var bodies []string
+	err = ps.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return true
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"-1", "0", "1", "2", "3"})
+
+	c.Check(dequeueBodies(c, ps, "/queue/test"), DeepEquals, []string{"-1", "0", "1", "2", "3"})
+	c.Check(ps.Len("/queue/test"), Equals, 0)
+	c.Check(ps.queues, HasLen, 0)
+}
+
+func (s *PersistentSuite) TestFramesFromPreviousRun(c *C) {
+	durable := NewMemoryQueueStorage()
+	c.Assert(durable.Enqueue("/queue/test", newTestFrame("old", true)), IsNil)
+
+	ps := NewPersistentStorage(durable)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("new", false)), IsNil)
+	c.Check(dequeueBodies(c, ps, "/queue/test"), DeepEquals, []string{"new", "old"})
+}
+
+// Discards the frame at the head of the test queue when compacted.
+type discardingStorage struct {
+	Storage
+}
+
+func (s discardingStorage) Compact() (CompactStats, error) {
+	_, err := s.Dequeue("/queue/test")
+	return CompactStats{Discarded: 1}, err
+}
+
+func (s *PersistentSuite) TestCompact(c *C) {
+	ps := NewPersistentStorage(discardingStorage{NewMemoryQueueStorage()})
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("1", true)), IsNil)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("2", false)), IsNil)
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("3", true)), IsNil)
+
+	stats, err := ps.Compact()
+	c.Assert(err, IsNil)
+	c.Check(stats.Discarded, Equals, 1)
+	c.Check(ps.Len("/queue/test"), Equals, 2)
+	c.Check(dequeueBodies(c, ps, "/queue/test"), DeepEquals, []string{"2", "3"})
+
+	// storage that cannot be compacted is left unchanged
+	ps = NewPersistentStorage(NewMemoryQueueStorage())
+	c.Assert(ps.Enqueue("/queue/test", newTestFrame("1", true)), IsNil)
+	stats, err = ps.Compact()
+	c.Assert(err, IsNil)
+	c.Check(stats, Equals, CompactStats{})
+	c.Check(ps.Len("/queue/test"), Equals, 1)
+}
@@ -4,24 +4,108 @@ Package queue provides implementations of server-side queues.
 package queue
 
 import (
+	"container/list"
+	"time"
+
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/go-stomp/stomp/v3/server/client"
 )
 
+// DispatchMode determines how the frames sent to a queue are
+// distributed between its subscriptions.
+type DispatchMode int
+
+// Dispatch modes.
+const (
+	// Subscriptions compete for frames, and each frame is sent to
+	// one subscription. This is the classic queue behavior.
+	RoundRobin DispatchMode = iota
+
+	// Each subscription receives a copy of every frame sent while
+	// it is subscribed. Copies waiting for a subscription to become
+	// ready are kept in memory, and are discarded when the subscription
+	// is removed. Frames sent while there are no subscriptions, and
+	// frames that are requeued, are stored and sent to one subscription,
+	// as for RoundRobin.
+	Broadcast
+)
+
 // Queue for storing message frames.
 type Queue struct {
 	destination string
 	qstore      Storage
 	subs        *client.SubscriptionList
+	paused      bool // is dispatch to subscriptions paused
+	onExpired   func(f *frame.Frame)
+	mode        DispatchMode
+	enqueued    meter
+	dispatched  meter
+	acked       meter
+	expired     meter
+
+	// frames waiting for each subscription, in the order that the
+	// subscriptions were added, Broadcast mode only
+	backlogs []backlog
+
+	// copies sent by broadcast, which are not in queue storage
+	copies map[*frame.Frame]bool
+}
+
+// Frames broadcast to a subscription that was not ready to receive them.
+type backlog struct {
+	sub    *client.Subscription
+	frames *list.List
 }
 
 // Create a new queue -- called from the queue manager only.
-func newQueue(destination string, qstore Storage) *Queue {
+func newQueue(destination string, qstore Storage, expired func(f *frame.Frame), mode DispatchMode) *Queue {
 	return &Queue{
 		destination: destination,
 		qstore:      qstore,
 		subs:        client.NewSubscriptionList(),
+		onExpired:   expired,
+		mode:        mode,
+		copies:      make(map[*frame.Frame]bool),
+	}
+}
+
+// Mode returns the dispatch mode of the queue.
+func (q *Queue) Mode() DispatchMode {
+	return q.mode
+}
+
+// SetMode changes the dispatch mode of the queue. When the mode
+// changes to RoundRobin, the frames waiting to be sent to Broadcast
+// subscriptions are put back in queue storage once each, to be sent
+// to one subscription.
+func (q *Queue) SetMode(mode DispatchMode) error {
+	if mode == q.mode {
+		return nil
+	}
+	q.mode = mode
+	if mode == Broadcast {
+		q.subs.ForEach(func(sub *client.Subscription, isLast bool) {
+			q.backlogs = append(q.backlogs, backlog{sub: sub, frames: list.New()})
+		})
+		return nil
+	}
+
+	// every backlog holds the frames broadcast since its subscription
+	// was last ready, so the longest one holds all the waiting frames
+	var longest *list.List
+	for _, b := range q.backlogs {
+		if longest == nil || b.frames.Len() > longest.Len() {
+			longest = b.frames
+		}
 	}
+	q.backlogs = nil
+	for longest != nil && longest.Len() > 0 {
+		f := longest.Remove(longest.Front()).(*frame.Frame)
+		if err := q.qstore.Enqueue(q.destination, f); err != nil {
+			return err
+		}
+	}
+	return q.dispatch()
 }
 
 // Add a subscription to a queue. The subscription is removed
@@ -29,58 +113,328 @@ func newQueue(destination string, qstore Storage) *Queue {
 // be re-added when the subscription decides that the message
 // has been received by the client.
 func (q *Queue) Subscribe(sub *client.Subscription) error {
-	// see if there is a frame available for this subscription
-	f, err := q.qstore.Dequeue(sub.Destination())
-	if err != nil {
-		return err
+	var frames *list.List
+	if q.mode == Broadcast {
+		if frames = q.backlog(sub); frames == nil {
+			frames = list.New()
+			q.backlogs = append(q.backlogs, backlog{sub: sub, frames: frames})
+		}
 	}
-	if f == nil {
-		// no frame available, so add to the subscription list
+
+	if q.paused {
+		// wait until dispatch is resumed
 		q.subs.Add(sub)
-	} else {
+		return nil
+	}
+
+	// frames copied for this subscription come first
+	for frames != nil && frames.Len() > 0 {
+		f := frames.Remove(frames.Front()).(*frame.Frame)
+		if q.checkExpired(f) {
+			continue
+		}
+		// if the client has gone away, the copy is
+		// no longer required
+		_ = q.sendCopy(sub, f)
+		return nil
+	}
+
+	for {
+		// see if there is a frame available for this subscription
+		f, err := q.qstore.Dequeue(sub.Destination())
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			// no frame available, so add to the subscription list
+			q.subs.Add(sub)
+			return nil
+		}
+		if q.checkExpired(f) {
+			// try the next frame
+			continue
+		}
+
 		// a frame is available, so send straight away without
 		// adding the subscription to the list
-		sub.SendQueueFrame(f)
+		if err = q.send(sub, f); err != nil {
+			// the client has gone away, put the frame back
+			return q.qstore.Requeue(q.destination, f)
+		}
+		return nil
 	}
-	return nil
 }
 
 // Unsubscribe a subscription.
 func (q *Queue) Unsubscribe(sub *client.Subscription) {
 	q.subs.Remove(sub)
+	for i, b := range q.backlogs {
+		if b.sub == sub {
+			q.backlogs = append(q.backlogs[:i], q.backlogs[i+1:]...)
+			break
+		}
+	}
 }
 
-// Send a message to the queue. If a subscription is available
-// to receive the message, it is sent to the subscription without
-// making it to the queue. Otherwise, the message is queued until
-// a message is available.
-func (q *Queue) Enqueue(f *frame.Frame) error {
-	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
-	if sub == nil {
-		// no subscription available, add to the queue
-		return q.qstore.Enqueue(q.destination, f)
-	} else {
-		// subscription is available, send it now without adding to queue
-		sub.SendQueueFrame(f)
+// Returns the backlog of a subscription, or nil if it has none.
+func (q *Queue) backlog(sub *client.Subscription) *list.List {
+	for _, b := range q.backlogs {
+		if b.sub == sub {
+			return b.frames
+		}
 	}
 	return nil
 }
 
+// Send a message to the queue. The message is given a "message-id"
+// header if it has none, is added to queue storage, and is then sent
+// to a subscription if one is available. This way
+// queue storage knows about every message sent to a subscription, and
+// persistent storage can keep the message until it is acknowledged.
+func (q *Queue) Enqueue(f *frame.Frame) error {
+	if q.checkExpired(f) {
+		return nil
+	}
+	q.enqueued.mark(time.Now())
+	if _, ok := f.Header.Contains(frame.MessageId); !ok {
+		// identifies the frame while it is stored, and is replaced
+		// when the frame is sent to a client
+		f.Header.Set(frame.MessageId, client.NewId())
+	}
+	if q.mode == Broadcast && len(q.backlogs) > 0 {
+		q.broadcast(f)
+		return nil
+	}
+	if err := q.qstore.Enqueue(q.destination, f); err != nil {
+		return err
+	}
+	return q.dispatch()
+}
+
 // Send a message to the front of the queue, probably because it
-// failed to be sent to a client. If a subscription is available
-// to receive the message, it is sent to the subscription without
-// making it to the queue. Otherwise, the message is queued until
-// a message is available.
+// failed to be sent to a client. The message is added to queue
+// storage, and is then sent to a subscription if one is available.
 func (q *Queue) Requeue(f *frame.Frame) error {
-	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
-	if sub == nil {
-		// no subscription available, add to the queue
-		return q.qstore.Requeue(q.destination, f)
-	} else {
-		// subscription is available, send it now without adding to queue
-		sub.SendQueueFrame(f)
+	if q.checkExpired(f) {
+		return nil
+	}
+	// a broadcast copy is stored from now on
+	delete(q.copies, f)
+	if err := q.qstore.Requeue(q.destination, f); err != nil {
+		return err
+	}
+	return q.dispatch()
+}
+
+// Dispatch sends frames from queue storage to subscriptions. It is
+// called when frames have been added to queue storage other than
+// through the queue, for example by another server sharing the storage.
+func (q *Queue) Dispatch() error {
+	return q.dispatch()
+}
+
+// Sends frames from queue storage to subscriptions, for as long as
+// there are both frames and subscriptions ready to receive them.
+func (q *Queue) dispatch() error {
+	for !q.paused && q.subs.Len() > 0 {
+		f, err := q.qstore.Dequeue(q.destination)
+		if err != nil || f == nil {
+			return err
+		}
+		if q.checkExpired(f) {
+			continue
+		}
+		if !q.sendToSubscription(f) {
+			// every subscription's client has gone away
+			return q.qstore.Requeue(q.destination, f)
+		}
+	}
+	return nil
+}
+
+// Pause dispatch of frames to subscriptions. Frames sent to the queue
+// while it is paused are stored until dispatch is resumed.
+func (q *Queue) Pause() {
+	q.paused = true
+}
+
+// Paused reports whether dispatch of frames to subscriptions is paused.
+func (q *Queue) Paused() bool {
+	return q.paused
+}
+
+// Resume dispatch of frames to subscriptions. Stored frames are sent
+// to any subscriptions that are ready to receive them.
+func (q *Queue) Resume() error {
+	q.paused = false
+
+	// take every ready subscription off the list, and subscribe
+	// again now that dispatch is no longer paused
+	var ready []*client.Subscription
+	for sub := q.subs.Get(); sub != nil; sub = q.subs.Get() {
+		ready = append(ready, sub)
+	}
+	for _, sub := range ready {
+		if err := q.Subscribe(sub); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// Send a copy of a frame to every subscription, in the order that the
+// subscriptions were added. Subscriptions that are not ready to receive
+// the frame have the copy added to their backlog.
+func (q *Queue) broadcast(f *frame.Frame) {
+	for i, b := range q.backlogs {
+		// the last subscription can have the frame without copying
+		cf := f
+		if i < len(q.backlogs)-1 {
+			cf = f.Clone()
+		}
+		if !q.paused && b.frames.Len() == 0 && q.subs.Contains(b.sub) {
+			q.subs.Remove(b.sub)
+			if err := q.sendCopy(b.sub, cf); err == nil {
+				continue
+			}
+		}
+		b.frames.PushBack(cf)
+	}
+}
+
+// Ack informs queue storage that a frame sent to a subscription
+// has been acknowledged by the client. Broadcast copies were never
+// added to queue storage, so their acknowledgement is not passed on.
+func (q *Queue) Ack(f *frame.Frame) error {
+	q.acked.mark(time.Now())
+	if q.copies[f] {
+		delete(q.copies, f)
+		return nil
+	}
+	return q.qstore.Ack(q.destination, f)
+}
+
+// Len returns the number of frames in queue storage waiting to be
+// sent to a subscription.
+func (q *Queue) Len() int {
+	return q.qstore.Len(q.destination)
+}
+
+// Ready returns the number of subscriptions that are ready to be sent
+// a frame. Other subscriptions are waiting for their client to
+// acknowledge a frame.
+func (q *Queue) Ready() int {
+	return q.subs.Len()
+}
+
+// Iterate calls fn for each frame in queue storage, in the order that
+// they will be sent to subscriptions, until fn returns false.
+func (q *Queue) Iterate(fn func(f *frame.Frame) bool) error {
+	return q.qstore.Iterate(q.destination, fn)
+}
+
+// Remove removes the frames in queue storage for which fn returns true,
+// and returns them in queue order. The frames that remain keep their
+// order. Every frame is dequeued from storage and the remaining frames
+// are requeued, so the cost is proportional to the length of the queue.
+// Frames in the backlogs of broadcast subscriptions are not removed.
+func (q *Queue) Remove(fn func(f *frame.Frame) bool) ([]*frame.Frame, error) {
+	var removed, kept []*frame.Frame
+	for {
+		f, err := q.qstore.Dequeue(q.destination)
+		if err != nil {
+			q.restore(kept)
+			return removed, err
+		}
+		if f == nil {
+			break
+		}
+		if !fn(f) {
+			kept = append(kept, f)
+			continue
+		}
+		if err := q.qstore.Ack(q.destination, f); err != nil {
+			kept = append(kept, f)
+			q.restore(kept)
+			return removed, err
+		}
+		removed = append(removed, f)
+	}
+	if err := q.restore(kept); err != nil {
+		return removed, err
+	}
+	return removed, q.dispatch()
+}
+
+// Returns frames dequeued by Remove to the head of queue storage, in
+// their original order.
+func (q *Queue) restore(frames []*frame.Frame) error {
+	var firstErr error
+	for i := len(frames) - 1; i >= 0; i-- {
+		if err := q.qstore.Requeue(q.destination, frames[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Reports whether a frame has expired, in which case it is passed to
+// the expired handler instead of being sent to a subscription.
+func (q *Queue) checkExpired(f *frame.Frame) bool {
+	expires, ok, err := f.Header.Expires()
+	if !ok || err != nil || time.Now().Before(expires) {
+		return false
+	}
+	q.expired.mark(time.Now())
+	if q.onExpired != nil {
+		q.onExpired(f)
+	}
+	return true
+}
+
+// Send a frame to the first subscription ready to receive it.
+// Subscriptions whose client connection has closed are skipped.
+// Returns false if no subscription accepted the frame.
+func (q *Queue) sendToSubscription(f *frame.Frame) bool {
+	for sub := q.subs.Get(); sub != nil; sub = q.subs.Get() {
+		if err := q.send(sub, f); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a frame to a subscription, counting it if it is sent.
+func (q *Queue) send(sub *client.Subscription, f *frame.Frame) error {
+	err := sub.SendQueueFrame(f)
+	if err == nil {
+		q.dispatched.mark(time.Now())
+	}
+	return err
+}
+
+// Sends a broadcast copy of a frame to a subscription, remembering the
+// copy until it is acknowledged or requeued.
+func (q *Queue) sendCopy(sub *client.Subscription, f *frame.Frame) error {
+	err := q.send(sub, f)
+	if err == nil {
+		q.copies[f] = true
+	}
+	return err
+}
+
+// Stats returns the counts and rates of the frames sent to and from
+// the queue.
+func (q *Queue) Stats() Stats {
+	now := time.Now()
+	return Stats{
+		Enqueued:     q.enqueued.count,
+		Dispatched:   q.dispatched.count,
+		Acked:        q.acked.count,
+		Expired:      q.expired.count,
+		EnqueueRate:  q.enqueued.rate(now),
+		DispatchRate: q.dispatched.rate(now),
+		AckRate:      q.acked.rate(now),
+		ExpireRate:   q.expired.rate(now),
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Package queuetest provides helpers for the tests of the queue storages,
+such as the storages of the boltstore, redisstore and sqlstore packages,
+so that their tests store and check frames in the same way.
+*/
+package queuetest
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+// NewFrame returns a MESSAGE frame sent to /queue/test with the body.
+func NewFrame(body string) *frame.Frame {
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/test")
+	f.Body = []byte(body)
+	return f
+}
+
+// DequeueBodies dequeues and acknowledges the frames of a queue until
+// it is empty, and returns their bodies in order.
+func DequeueBodies(c *C, s queue.Storage, queue string) []string {
+	var bodies []string
+	for {
+		f, err := s.Dequeue(queue)
+		c.Assert(err, IsNil)
+		if f == nil {
+			return bodies
+		}
+		c.Assert(s.Ack(queue, f), IsNil)
+		bodies = append(bodies, string(f.Body))
+	}
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"time"
+)
+
+// Stats are the counts and rates of the frames sent to and from a
+// queue since it was created.
+type Stats struct {
+	Enqueued     uint64  // frames sent to the queue
+	Dispatched   uint64  // frames dequeued and sent to subscriptions
+	Acked        uint64  // frames acknowledged by clients
+	Expired      uint64  // frames that expired before they were sent to a subscription
+	EnqueueRate  float64 // frames sent to the queue per second, over the last minute
+	DispatchRate float64 // frames sent to subscriptions per second, over the last minute
+	AckRate      float64 // frames acknowledged per second, over the last minute
+	ExpireRate   float64 // frames expired per second, over the last minute
+}
+
+// Number of seconds over which rates are measured.
+const rateSeconds = 60
+
+// Counts events in total, and in each second of the last minute.
+type meter struct {
+	count   uint64
+	buckets [rateSeconds]uint64 // events in each second, by Unix time modulo rateSeconds
+	last    int64               // Unix time of the most recent bucket
+}
+
+// Counts an event.
+func (m *meter) mark(now time.Time) {
+	sec := now.Unix()
+	m.advance(sec)
+	m.count++
+	m.buckets[m.last%rateSeconds]++
+}
+
+// Clears the buckets of the seconds after the most recent bucket, up
+// to and including sec.
+func (m *meter) advance(sec int64) {
+	if sec <= m.last {
+		return
+	}
+	n := sec - m.last
+	if n > rateSeconds {
+		n = rateSeconds
+	}
+	for i := sec - n + 1; i <= sec; i++ {
+		m.buckets[i%rateSeconds] = 0
+	}
+	m.last = sec
+}
+
+// Returns the events per second over the last minute.
+func (m *meter) rate(now time.Time) float64 {
+	m.advance(now.Unix())
+	var sum uint64
+	for _, n := range m.buckets {
+		sum += n
+	}
+	return float64(sum) / rateSeconds
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type StatsSuite struct{}
+
+var _ = Suite(&StatsSuite{})
+
+func (s *StatsSuite) TestMeter(c *C) {
+	start := time.Unix(1000, 0)
+	m := &meter{}
+	for i := 0; i < 30; i++ {
+		m.mark(start.Add(time.Duration(i) * time.Second))
+		m.mark(start.Add(time.Duration(i)*time.Second + time.Millisecond))
+	}
+	c.Check(m.count, Equals, uint64(60))
+	c.Check(m.rate(start.Add(30*time.Second)), Equals, 1.0)
+
+	// events older than a minute are not included in the rate
+	c.Check(m.rate(start.Add(74*time.Second)), Equals, 0.5)
+	c.Check(m.rate(start.Add(10*time.Minute)), Equals, 0.0)
+	c.Check(m.count, Equals, uint64(60))
+}
+
+func (s *StatsSuite) TestQueueStats(c *C) {
+	var expired []*frame.Frame
+	mgr := NewManager(NewMemoryQueueStorage())
+	mgr.SetExpiredHandler(func(f *frame.Frame) { expired = append(expired, f) })
+	q := mgr.Find("/queue/1")
+
+	c.Assert(q.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "/queue/1")), IsNil)
+	c.Assert(q.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "/queue/1", frame.Expires, "1")), IsNil)
+	c.Check(expired, HasLen, 1)
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/1")
+	c.Assert(q.Ack(f), IsNil)
+
+	stats := q.Stats()
+	c.Check(stats.Enqueued, Equals, uint64(1))
+	c.Check(stats.Expired, Equals, uint64(1))
+	c.Check(stats.Acked, Equals, uint64(1))
+	c.Check(stats.Dispatched, Equals, uint64(0))
+	c.Check(stats.EnqueueRate, Equals, 1.0/60)
+	c.Check(stats.ExpireRate, Equals, 1.0/60)
+}
@@ -1,6 +1,8 @@
 package queue
 
 import (
+	"time"
+
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
@@ -24,6 +26,21 @@ type Storage interface {
 	// Returns nil if no frame is available.
 	Dequeue(queue string) (*frame.Frame, error)
 
+	// Called when a frame sent to a subscription of the queue
+	// has been acknowledged by the client. The frame might not
+	// have been dequeued from storage, in which case it should be
+	// ignored. Allows storage that keeps dequeued frames until they
+	// are delivered to remove them.
+	Ack(queue string, frame *frame.Frame) error
+
+	// Returns the number of frames in the queue.
+	Len(queue string) int
+
+	// Calls fn for each frame in the queue, in order from the
+	// head of the queue, until fn returns false. The frames are
+	// not removed from the queue, and must not be modified.
+	Iterate(queue string, fn func(frame *frame.Frame) bool) error
+
 	// Called at server startup. Allows the queue storage
 	// to perform any initialization.
 	Start()
@@ -32,3 +49,109 @@ type Storage interface {
 	// to perform any cleanup.
 	Stop()
 }
+
+// Interface for queue storage that keeps frames in memory, so that the
+// server can count the memory that they hold.
+type MemoryStorage interface {
+	Storage
+
+	// Returns the approximate number of bytes of memory held by the
+	// frames in the queues, as given by their Size method.
+	MemoryBytes() int64
+}
+
+// Interface for queue storage that can write a group of changes
+// to persistent storage as a single unit, so that after a crash
+// either all of the changes or none of them have been applied.
+type BatchStorage interface {
+	Storage
+
+	// Starts a batch. Changes made until the matching call to EndBatch
+	// take effect straight away, but are not written to persistent
+	// storage until then. Batches can be nested, in which case the
+	// changes are written when the outermost batch ends.
+	BeginBatch()
+
+	// Ends a batch, writing its changes to persistent storage.
+	EndBatch() error
+}
+
+// Interface for queue storage that can limit the disk space that it
+// uses, by discarding frames that exceed its retention settings and
+// reclaiming the space used by frames that have been removed.
+type CompactStorage interface {
+	Storage
+
+	// Discards frames that exceed the retention settings of the
+	// storage, and reclaims disk space that is no longer used.
+	Compact() (CompactStats, error)
+}
+
+// Retention settings limit the frames kept in a queue. Once a queue
+// exceeds a limit, frames are discarded from the head of the queue
+// when the storage is compacted. Frames that have been dequeued and
+// not yet acknowledged are never discarded.
+type Retention struct {
+	// Maximum time that a frame is kept in a queue. If zero,
+	// frames are kept regardless of their age.
+	MaxAge time.Duration
+
+	// Maximum total size of the bodies of the frames in a queue.
+	// If zero, the size of a queue is not limited.
+	MaxBytes int64
+}
+
+// Exceeded reports whether a frame exceeds the retention settings,
+// given the time it was added to a queue and the total size of the
+// frame bodies from it to the tail of the queue.
+func (r Retention) Exceeded(added time.Time, bytes int64, now time.Time) bool {
+	if r.MaxAge > 0 && now.Sub(added) > r.MaxAge {
+		return true
+	}
+	return r.MaxBytes > 0 && bytes > r.MaxBytes
+}
+
+// Results of compacting queue storage.
+type CompactStats struct {
+	Discarded      int   // number of frames discarded by retention settings
+	ReclaimedBytes int64 // disk space reclaimed, in bytes
+}
+
+// Add the results of another compaction.
+func (s *CompactStats) Add(other CompactStats) {
+	s.Discarded += other.Discarded
+	s.ReclaimedBytes += other.ReclaimedBytes
+}
+
+// Interface for queue storage that can be told when to write its
+// changes to disk, so that the server can choose how durable the
+// messages in each queue are. For the choice to have an effect, the
+// storage should be configured not to write each change to disk itself.
+type SyncStorage interface {
+	Storage
+
+	// Writes all changes made so far to disk, returning once
+	// they are durable.
+	Sync() error
+}
+
+// Interface for queue storage that can check that its backend is
+// available, so that the server can report whether it is ready to
+// accept messages.
+type PingStorage interface {
+	Storage
+
+	// Returns an error if the backend of the storage cannot be used.
+	Ping() error
+}
+
+// Interface for queue storage that is shared by several servers. Frames
+// added to a queue by another server are sent to the subscriptions of
+// this server when the storage reports that the queue has changed.
+type SharedStorage interface {
+	Storage
+
+	// Returns a channel that receives the name of a queue when another
+	// server adds frames to it.
+	Changes() <-chan string
+}
@@ -20,6 +20,19 @@ type QueueStorage interface {
 	// Returns nil if no frame is available.
 	Dequeue(queue string) (*frame.Frame, error)
 
+	// Ack is called when a MESSAGE frame sent to a subscription of the
+	// queue has been acknowledged by the client. Frames that were sent
+	// without being stored are also passed to Ack, and should be ignored.
+	Ack(queue string, frame *frame.Frame) error
+
+	// Len returns the number of frames in the queue.
+	Len(queue string) int
+
+	// Iterate calls fn for each frame in the queue, in order from the
+	// head of the queue, until fn returns false. The frames are not
+	// removed from the queue.
+	Iterate(queue string, fn func(frame *frame.Frame) bool) error
+
 	// Start is called at server startup. Allows the queue storage
 	// to perform any initialization.
 	Start()
@@ -0,0 +1,192 @@
+/*
+Package record records the traffic of STOMP connections to session
+files, and replays the client side of a session against a broker, to
+reproduce protocol-level problems reported by the users of other STOMP
+clients.
+
+A session file has a JSON object on each line for the bytes received
+from or sent to the client by one read or write of the connection,
+which need not be a whole frame:
+
+	{"time":"2026-10-16T14:00:21.5Z","from":"client","text":"CONNECT\naccept-version:1.2\n\n\u0000"}
+	{"time":"2026-10-16T14:00:21.5Z","from":"server","text":"CONNECTED\nversion:1.2\n\n\u0000"}
+
+Bytes that are not valid UTF-8 are stored base64 encoded in "data"
+instead of "text". Heart-beats are recorded as they are, as a newline.
+*/
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Sides of a connection, which send the bytes of entries.
+const (
+	FromClient = "client"
+	FromServer = "server"
+)
+
+// An Entry is the bytes sent by one side of a connection in one read
+// or write.
+type Entry struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"`           // FromClient or FromServer
+	Text string    `json:"text,omitempty"` // if the bytes are valid UTF-8
+	Data []byte    `json:"data,omitempty"` // otherwise
+}
+
+// NewEntry returns an entry for the bytes in p.
+func NewEntry(t time.Time, from string, p []byte) Entry {
+	e := Entry{Time: t, From: from}
+	if utf8.Valid(p) {
+		e.Text = string(p)
+	} else {
+		e.Data = append([]byte(nil), p...)
+	}
+	return e
+}
+
+// Bytes returns the bytes of the entry.
+func (e Entry) Bytes() []byte {
+	if e.Data != nil {
+		return e.Data
+	}
+	return []byte(e.Text)
+}
+
+// A Writer writes the entries of a session file. It can be used by
+// several go-routines at the same time.
+type Writer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	c   io.Closer
+	err error // first error writing an entry
+}
+
+// NewWriter returns a writer of entries to w. If w is an io.Closer,
+// Close closes it.
+func NewWriter(w io.Writer) *Writer {
+	c, _ := w.(io.Closer)
+	return &Writer{w: bufio.NewWriter(w), c: c}
+}
+
+// Write writes an entry, and returns the first error of writing an
+// entry, after which entries are discarded.
+func (w *Writer) Write(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.w.Write(append(b, '\n')); err != nil {
+		w.err = err
+		return err
+	}
+	// flushed after every entry, so that the file is complete up to
+	// the last entry if the server stops unexpectedly
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// Close closes the writer, and returns the first error of writing
+// an entry.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.c != nil {
+		if err := w.c.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+		w.c = nil
+	}
+	return w.err
+}
+
+// ReadSession reads the entries of a session file.
+func ReadSession(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if e.From != FromClient && e.From != FromServer {
+			return nil, fmt.Errorf("line %d: invalid from %q", line, e.From)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Conn records the bytes read from and written to a connection of a
+// server. Errors of recording do not affect the connection; the
+// recording stops instead.
+type Conn struct {
+	net.Conn
+	w *Writer
+}
+
+// NewConn returns a connection that records the bytes read from and
+// written to conn, which is a connection to a client, to w. Closing
+// the connection closes w.
+func NewConn(conn net.Conn, w *Writer) *Conn {
+	return &Conn{Conn: conn, w: w}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.w.Write(NewEntry(time.Now(), FromClient, p[:n]))
+	}
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.w.Write(NewEntry(time.Now(), FromServer, p[:n]))
+	}
+	return n, err
+}
+
+// Close closes the connection and the session file.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.w.Close()
+	return err
+}
+
+// Dir records connections to session files in a directory.
+type Dir string
+
+// Record returns a connection that records conn to a new session file
+// in the directory, named after the time and the remote address.
+func (d Dir) Record(conn net.Conn) (*Conn, error) {
+	name := fmt.Sprintf("%s-%s.jsonl",
+		time.Now().UTC().Format("20060102T150405.000000000"),
+		strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(conn.RemoteAddr().String()))
+	f, err := os.OpenFile(filepath.Join(string(d), name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, NewWriter(f)), nil
+}
@@ -0,0 +1,141 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestRecord(t *testing.T) {
+	TestingT(t)
+}
+
+type RecordSuite struct{}
+
+var _ = Suite(&RecordSuite{})
+
+func (s *RecordSuite) TestEntry(c *C) {
+	now := time.Now()
+	e := NewEntry(now, FromClient, []byte("SEND\n\nbody\x00"))
+	c.Check(e.Text, Equals, "SEND\n\nbody\x00")
+	c.Check(e.Data, IsNil)
+	c.Check(string(e.Bytes()), Equals, "SEND\n\nbody\x00")
+
+	binary := []byte{0xff, 0xfe, 0}
+	e = NewEntry(now, FromServer, binary)
+	c.Check(e.Text, Equals, "")
+	c.Check(e.Bytes(), DeepEquals, binary)
+}
+
+func (s *RecordSuite) TestRecordAndReadSession(c *C) {
+	dir, err := ioutil.TempDir("", "record")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	client, server := net.Pipe()
+	conn, err := Dir(dir).Record(server)
+	c.Assert(err, IsNil)
+	go func() {
+		client.Write([]byte("CONNECT\n\n\x00"))
+		io.ReadFull(client, make([]byte, len("CONNECTED\n\n\x00")))
+		client.Write([]byte{0xff})
+		client.Close()
+	}()
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(string(buf[:n]), Equals, "CONNECT\n\n\x00")
+	_, err = conn.Write([]byte("CONNECTED\n\n\x00"))
+	c.Assert(err, IsNil)
+	_, err = conn.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Close(), IsNil)
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, 1)
+	f, err := os.Open(names[0])
+	c.Assert(err, IsNil)
+	defer f.Close()
+	entries, err := ReadSession(f)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Check(entries[0].From, Equals, FromClient)
+	c.Check(entries[0].Text, Equals, "CONNECT\n\n\x00")
+	c.Check(entries[1].From, Equals, FromServer)
+	c.Check(entries[1].Text, Equals, "CONNECTED\n\n\x00")
+	c.Check(entries[2].Data, DeepEquals, []byte{0xff})
+	c.Check(entries[1].Time.Before(entries[0].Time), Equals, false)
+}
+
+func (s *RecordSuite) TestReadSessionErrors(c *C) {
+	_, err := ReadSession(strings.NewReader(`{"from":"client","text":"a"}` + "\n{"))
+	c.Check(err, ErrorMatches, "line 2: .*")
+	_, err = ReadSession(strings.NewReader(`{"from":"broker","text":"a"}`))
+	c.Check(err, ErrorMatches, `line 1: invalid from "broker"`)
+}
+
+func (s *RecordSuite) TestReplay(c *C) {
+	start := time.Now()
+	entries := []Entry{
+		{Time: start, From: FromClient, Text: "CONNECT\n\n\x00"},
+		{Time: start, From: FromServer, Text: "CONNECTED\n\n\x00"},
+		{Time: start.Add(100 * time.Millisecond), From: FromClient, Text: "DISCONNECT\n\n\x00"},
+	}
+
+	// a broker that answers each frame, and closes the connection
+	// after DISCONNECT
+	client, server := net.Pipe()
+	received := make(chan string, 1)
+	go func() {
+		var all bytes.Buffer
+		buf := make([]byte, 100)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				break
+			}
+			all.Write(buf[:n])
+			server.Write([]byte("ok\n"))
+			if strings.HasPrefix(string(buf[:n]), "DISCONNECT") {
+				server.Close()
+				break
+			}
+		}
+		received <- all.String()
+	}()
+
+	r := &Replayer{Speed: 2}
+	begin := time.Now()
+	responses, err := r.Replay(client, entries)
+	c.Assert(err, IsNil)
+	// the entries are sent at twice the pace of the recording
+	elapsed := time.Since(begin)
+	c.Check(elapsed >= 50*time.Millisecond, Equals, true, Commentf("elapsed %v", elapsed))
+	c.Check(elapsed < DefaultLinger, Equals, true, Commentf("elapsed %v", elapsed))
+	c.Check(<-received, Equals, "CONNECT\n\n\x00DISCONNECT\n\n\x00")
+	var text string
+	for _, e := range responses {
+		c.Check(e.From, Equals, FromServer)
+		text += e.Text
+	}
+	c.Check(text, Equals, "ok\nok\n")
+}
+
+func (s *RecordSuite) TestReplayLinger(c *C) {
+	client, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	defer server.Close()
+	r := &Replayer{NoDelay: true, Linger: 10 * time.Millisecond}
+	responses, err := r.Replay(client, []Entry{{From: FromClient, Text: "CONNECT\n\n\x00"}})
+	c.Assert(err, IsNil)
+	c.Check(responses, HasLen, 0)
+}
@@ -0,0 +1,93 @@
+package record
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Default time that a Replayer waits for the broker after sending the
+// last entry.
+const DefaultLinger = time.Second
+
+// A Replayer sends the client side of a recorded session to a broker.
+type Replayer struct {
+	// Multiplies the pace of the session: 2 replays it twice as fast.
+	// If zero, the session is replayed at the pace it was recorded.
+	Speed float64
+
+	// If true, entries are sent without waiting, rather than at the
+	// pace of the session.
+	NoDelay bool
+
+	// How long to wait for the broker to close the connection after
+	// the last entry is sent. If zero, DefaultLinger is used.
+	Linger time.Duration
+}
+
+// Replay sends the entries of a session from the client to conn, in
+// order, and returns the entries received from the broker, which can
+// be compared with those of the session. Replay returns once the
+// broker closes the connection, or Linger after the last entry is
+// sent, and closes conn. The error is that of writing to conn, if
+// any.
+func (r *Replayer) Replay(conn net.Conn, entries []Entry) ([]Entry, error) {
+	var mu sync.Mutex
+	var received []Entry
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				received = append(received, NewEntry(time.Now(), FromServer, buf[:n]))
+				mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	result := func(err error) ([]Entry, error) {
+		conn.Close()
+		<-closed
+		return received, err
+	}
+
+	speed := r.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	start := time.Now()
+	for _, e := range entries {
+		if e.From != FromClient {
+			continue
+		}
+		if !r.NoDelay && len(entries) > 0 {
+			offset := time.Duration(float64(e.Time.Sub(entries[0].Time)) / speed)
+			select {
+			case <-time.After(time.Until(start.Add(offset))):
+			case <-closed:
+				// the broker closed the connection before the session ended
+				return result(nil)
+			}
+		}
+		if _, err := conn.Write(e.Bytes()); err != nil {
+			return result(err)
+		}
+	}
+
+	linger := r.Linger
+	if linger == 0 {
+		linger = DefaultLinger
+	}
+	timer := time.NewTimer(linger)
+	defer timer.Stop()
+	select {
+	case <-closed:
+	case <-timer.C:
+	}
+	return result(nil)
+}
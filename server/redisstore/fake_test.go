@@ -0,0 +1,204 @@
+package redisstore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// A fake Redis server that implements the commands used by the
+// storage, so that the tests do not need a Redis server.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	lists    map[string][]string
+	sets     map[string]map[string]bool
+	subs     map[string][]*fakeClient
+}
+
+type fakeClient struct {
+	*conn
+	mu    sync.Mutex // for writing replies
+	multi [][]string // commands queued since MULTI
+	inTx  bool
+}
+
+func newFakeRedis() (*fakeRedis, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &fakeRedis{
+		listener: l,
+		lists:    make(map[string][]string),
+		sets:     make(map[string]map[string]bool),
+		subs:     make(map[string][]*fakeClient),
+	}
+	go r.accept()
+	return r, nil
+}
+
+func (r *fakeRedis) Addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *fakeRedis) Close() {
+	r.listener.Close()
+}
+
+func (r *fakeRedis) accept() {
+	for {
+		rw, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serve(&fakeClient{conn: newConn(rw)})
+	}
+}
+
+func (r *fakeRedis) serve(c *fakeClient) {
+	defer c.Close()
+	for {
+		reply, err := c.Receive()
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]interface{})
+		args := make([]string, len(values))
+		for i, v := range values {
+			args[i] = string(toBytes(v))
+		}
+		c.reply(r.handle(c, args))
+	}
+}
+
+func (c *fakeClient) reply(reply interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeReply(c.writer, reply)
+	c.writer.Flush()
+}
+
+func writeReply(w interface {
+	WriteString(string) (int, error)
+}, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(fmt.Sprintf(":%d\r\n", v))
+	case []byte:
+		w.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+	case []interface{}:
+		w.WriteString(fmt.Sprintf("*%d\r\n", len(v)))
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	}
+}
+
+func (r *fakeRedis) handle(c *fakeClient, args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	switch {
+	case cmd == "MULTI":
+		c.inTx, c.multi = true, nil
+		return "OK"
+	case cmd == "EXEC":
+		r.mu.Lock()
+		results := make([]interface{}, len(c.multi))
+		for i, args := range c.multi {
+			results[i] = r.execute(args)
+		}
+		r.mu.Unlock()
+		c.inTx, c.multi = false, nil
+		return results
+	case c.inTx:
+		c.multi = append(c.multi, args)
+		return "QUEUED"
+	case cmd == "SUBSCRIBE":
+		r.mu.Lock()
+		r.subs[args[1]] = append(r.subs[args[1]], c)
+		r.mu.Unlock()
+		return []interface{}{[]byte("subscribe"), []byte(args[1]), 1}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.execute(args)
+}
+
+// Executes a command, with the lock held.
+func (r *fakeRedis) execute(args []string) interface{} {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT":
+		return "OK"
+	case "SADD":
+		if r.sets[key] == nil {
+			r.sets[key] = make(map[string]bool)
+		}
+		r.sets[key][args[2]] = true
+		return 1
+	case "SMEMBERS":
+		var members []interface{}
+		for m := range r.sets[key] {
+			members = append(members, []byte(m))
+		}
+		return members
+	case "RPUSH":
+		r.lists[key] = append(r.lists[key], args[2])
+		return len(r.lists[key])
+	case "LPUSH":
+		r.lists[key] = append([]string{args[2]}, r.lists[key]...)
+		return len(r.lists[key])
+	case "LMOVE":
+		l := r.lists[key]
+		if len(l) == 0 {
+			return nil
+		}
+		r.lists[key] = l[1:]
+		r.lists[args[2]] = append(r.lists[args[2]], l[0])
+		return []byte(l[0])
+	case "LREM":
+		l := r.lists[key]
+		for i, v := range l {
+			if v == args[3] {
+				r.lists[key] = append(l[:i:i], l[i+1:]...)
+				return 1
+			}
+		}
+		return 0
+	case "LLEN":
+		return len(r.lists[key])
+	case "LRANGE":
+		var start, stop int
+		fmt.Sscan(args[2], &start)
+		fmt.Sscan(args[3], &stop)
+		l := r.lists[key]
+		if stop < 0 || stop >= len(l) {
+			stop = len(l) - 1
+		}
+		values := []interface{}{}
+		for i := start; i <= stop; i++ {
+			values = append(values, []byte(l[i]))
+		}
+		return values
+	case "DEL":
+		delete(r.lists, key)
+		return 1
+	case "PUBLISH":
+		subs := r.subs[key]
+		for _, sub := range subs {
+			go sub.reply([]interface{}{[]byte("message"), []byte(key), []byte(args[2])})
+		}
+		return len(subs)
+	}
+	return Error("ERR unknown command '" + args[0] + "'")
+}
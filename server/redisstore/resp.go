@@ -0,0 +1,167 @@
+package redisstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply from the Redis server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Returned when a reply from the Redis server cannot be parsed.
+var errProtocol = errors.New("redis: invalid reply")
+
+// A connection to a Redis server, which sends commands and reads
+// replies using the Redis serialization protocol (RESP). A connection
+// is not safe for concurrent use.
+//
+// Replies are returned as a string for a simple string, an int64 for
+// an integer, a []byte for a bulk string, an []interface{} for an
+// array, and nil for a null bulk string or null array. Error replies
+// are returned as an Error.
+type conn struct {
+	rw     net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dial(network, addr string, timeout time.Duration) (*conn, error) {
+	rw, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(rw), nil
+}
+
+func newConn(rw net.Conn) *conn {
+	return &conn{
+		rw:     rw,
+		reader: bufio.NewReader(rw),
+		writer: bufio.NewWriter(rw),
+	}
+}
+
+func (c *conn) Close() error {
+	return c.rw.Close()
+}
+
+// Sends a command and returns its reply.
+func (c *conn) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Sends several commands at once, and returns their replies. Error
+// replies are returned in the slice rather than as an error.
+func (c *conn) Pipeline(cmds [][]string) ([]interface{}, error) {
+	for _, args := range cmds {
+		if err := c.send(args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := c.Receive()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Writes a command as an array of bulk strings.
+func (c *conn) send(args []string) error {
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n", len(arg))
+		c.writer.WriteString(arg)
+		if _, err := c.writer.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the next reply from the server.
+func (c *conn) Receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(c.reader, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.Receive(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, errProtocol
+}
+
+// Reads a line, without the trailing CR-LF.
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,61 @@
+package redisstore
+
+import (
+	"net"
+
+	. "gopkg.in/check.v1"
+)
+
+type RespSuite struct{}
+
+var _ = Suite(&RespSuite{})
+
+// Returns a connection that reads the replies from the
+// string, and discards the commands sent on it.
+func replyConn(replies string) *conn {
+	client, server := net.Pipe()
+	go func() {
+		go server.Write([]byte(replies))
+		buf := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return newConn(client)
+}
+
+func (s *RespSuite) TestReplies(c *C) {
+	conn := replyConn("+OK\r\n:42\r\n$5\r\nhello\r\n$-1\r\n*2\r\n$1\r\na\r\n:1\r\n*-1\r\n-ERR wrong\r\n")
+	defer conn.Close()
+
+	replies, err := conn.Pipeline([][]string{{"A"}, {"B"}, {"C"}, {"D"}, {"E"}, {"F"}, {"G"}})
+	c.Assert(err, IsNil)
+	c.Check(replies, DeepEquals, []interface{}{
+		"OK",
+		int64(42),
+		[]byte("hello"),
+		nil,
+		[]interface{}{[]byte("a"), int64(1)},
+		nil,
+		Error("ERR wrong"),
+	})
+}
+
+func (s *RespSuite) TestDoError(c *C) {
+	conn := replyConn("-ERR unknown command\r\n")
+	defer conn.Close()
+
+	_, err := conn.Do("NOPE")
+	c.Check(err, Equals, Error("ERR unknown command"))
+}
+
+func (s *RespSuite) TestInvalidReply(c *C) {
+	for _, reply := range []string{"?\r\n", ":x\r\n", "$-2\r\n", "+OK\n"} {
+		conn := replyConn(reply)
+		_, err := conn.Receive()
+		c.Check(err, Equals, errProtocol, Commentf("%q", reply))
+		conn.Close()
+	}
+}
@@ -0,0 +1,461 @@
+/*
+Package redisstore provides queue storage for the STOMP server in Redis,
+which can be shared by several servers.
+
+Servers that share the storage behind a load balancer share the contents
+of their queues: a message sent to a queue through one server can be
+received by a client of any of them. Each frame in a queue is delivered
+to one client, whichever server it is connected to. The servers tell
+each other when they add frames to a queue using Redis publish and
+subscribe, so that frames are dispatched straight away to clients
+waiting on other servers.
+
+Each server keeps the frames that it has sent to clients but that have
+not been acknowledged in a list of its own. The server's instance name
+must therefore be unique and stable across restarts: when the storage
+is opened, frames left in the list by an earlier run of the same
+instance are returned to the head of their queues with the
+"redelivered:true" header.
+
+The storage uses the LMOVE command, which requires Redis 6.2 or later.
+*/
+package redisstore
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Default values for options.
+const (
+	DefaultAddr        = "localhost:6379"
+	DefaultPrefix      = "stomp:"
+	DefaultDialTimeout = 5 * time.Second
+)
+
+// Number of frames read at a time when iterating over a queue.
+const iteratePageSize = 100
+
+// Errors returned by storage operations.
+var (
+	ErrClosed = errors.New("storage is closed")
+)
+
+// Options for opening storage.
+type Options struct {
+	// Network and address of the Redis server. If empty, "tcp"
+	// and DefaultAddr are used.
+	Network string
+	Addr    string
+
+	// Password for the AUTH command. If empty, no AUTH command is sent.
+	Password string
+
+	// Redis database number, selected with the SELECT command.
+	DB int
+
+	// Prefix for the names of the keys and channel used by the storage.
+	// If empty, DefaultPrefix is used. Servers that share queues must
+	// use the same prefix.
+	Prefix string
+
+	// Name of this server, which must be different for each server
+	// sharing the storage, and the same each time a server starts.
+	// If empty, the host name is used.
+	Instance string
+
+	// Timeout for connecting to the Redis server. If zero,
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+}
+
+// Storage is an implementation of the queue storage interface that
+// keeps each queue in a Redis list.
+//
+// Storage is not safe for concurrent use, which matches the way the
+// server uses queue storage.
+type Storage struct {
+	opts     Options
+	conn     *conn                   // for commands
+	sub      *conn                   // subscribed to notifications from other servers
+	inflight map[*frame.Frame]string // encoded frames waiting for acknowledgement
+	changes  chan string
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Open the storage on the Redis server specified by opts.
+func Open(opts Options) (*Storage, error) {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Addr == "" {
+		opts.Addr = DefaultAddr
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.Instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		opts.Instance = host
+	}
+
+	s := &Storage{
+		opts:     opts,
+		inflight: make(map[*frame.Frame]string),
+		changes:  make(chan string, 64),
+		done:     make(chan struct{}),
+	}
+	var err error
+	if s.conn, err = s.dial(); err != nil {
+		return nil, err
+	}
+	if err = s.recover(); err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+	if err = s.subscribe(); err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Connects to the Redis server, and authenticates and selects
+// the database if required.
+func (s *Storage) dial() (*conn, error) {
+	c, err := dial(s.opts.Network, s.opts.Addr, s.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Password != "" {
+		if _, err = c.Do("AUTH", s.opts.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.opts.DB != 0 {
+		if _, err = c.Do("SELECT", strconv.Itoa(s.opts.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (s *Storage) queuesKey() string {
+	return s.opts.Prefix + "queues"
+}
+
+func (s *Storage) queueKey(queue string) string {
+	return s.opts.Prefix + "queue:" + queue
+}
+
+func (s *Storage) unackedKey(queue string) string {
+	return s.opts.Prefix + "unacked:" + s.opts.Instance + ":" + queue
+}
+
+func (s *Storage) channel() string {
+	return s.opts.Prefix + "changes"
+}
+
+// Returns frames that this instance had not acknowledged before the
+// storage was closed to the head of their queues, in their original
+// order.
+func (s *Storage) recover() error {
+	reply, err := s.conn.Do("SMEMBERS", s.queuesKey())
+	if err != nil {
+		return err
+	}
+	queues, _ := reply.([]interface{})
+	for _, q := range queues {
+		queue := string(toBytes(q))
+		reply, err := s.conn.Do("LRANGE", s.unackedKey(queue), "0", "-1")
+		if err != nil {
+			return err
+		}
+		values, _ := reply.([]interface{})
+		if len(values) == 0 {
+			continue
+		}
+
+		cmds := [][]string{{"MULTI"}}
+		for i := len(values) - 1; i >= 0; i-- {
+			f := new(frame.Frame)
+			if err := f.UnmarshalBinary(toBytes(values[i])); err != nil {
+				return err
+			}
+			f.Header.Set(frame.Redelivered, "true")
+			value, err := f.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			cmds = append(cmds, []string{"LPUSH", s.queueKey(queue), string(value)})
+		}
+		cmds = append(cmds, []string{"DEL", s.unackedKey(queue)}, []string{"EXEC"})
+		if err = s.transaction(cmds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribes to notifications of frames added to queues by other
+// servers, which are passed on by a separate go-routine.
+func (s *Storage) subscribe() error {
+	sub, err := s.dial()
+	if err != nil {
+		return err
+	}
+	if _, err = sub.Do("SUBSCRIBE", s.channel()); err != nil {
+		sub.Close()
+		return err
+	}
+	s.sub = sub
+	s.wg.Add(1)
+	go s.receiveChanges()
+	return nil
+}
+
+func (s *Storage) receiveChanges() {
+	defer s.wg.Done()
+	prefix := s.opts.Instance + "\n"
+	for {
+		reply, err := s.sub.Receive()
+		if err != nil {
+			// the connection is closed when the storage is closed
+			select {
+			case <-s.done:
+			default:
+				close(s.changes)
+			}
+			return
+		}
+		message, _ := reply.([]interface{})
+		if len(message) != 3 || string(toBytes(message[0])) != "message" {
+			continue
+		}
+		payload := string(toBytes(message[2]))
+		if strings.HasPrefix(payload, prefix) {
+			// added by this server
+			continue
+		}
+		if i := strings.IndexByte(payload, '\n'); i >= 0 {
+			select {
+			case s.changes <- payload[i+1:]:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Changes returns a channel that receives the name of a queue when
+// another server adds frames to it. The channel is closed if the
+// connection used to receive notifications fails.
+func (s *Storage) Changes() <-chan string {
+	return s.changes
+}
+
+// Close the storage.
+func (s *Storage) Close() error {
+	if s.conn == nil {
+		return ErrClosed
+	}
+	close(s.done)
+	s.sub.Close()
+	s.wg.Wait()
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Ping checks that the Redis server can be reached.
+func (s *Storage) Ping() error {
+	if s.conn == nil {
+		return ErrClosed
+	}
+	_, err := s.conn.Do("PING")
+	return err
+}
+
+// Enqueue adds a frame to the tail of the queue.
+func (s *Storage) Enqueue(queue string, f *frame.Frame) error {
+	value, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.add("RPUSH", queue, string(value))
+}
+
+// Requeue adds a frame to the head of the queue. If the frame was
+// dequeued from this storage, it is no longer waiting for acknowledgement.
+func (s *Storage) Requeue(queue string, f *frame.Frame) error {
+	b, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	value := string(b)
+	old, ok := s.inflight[f]
+	if !ok {
+		return s.add("LPUSH", queue, value)
+	}
+	if s.conn == nil {
+		return ErrClosed
+	}
+	err = s.transaction([][]string{
+		{"MULTI"},
+		{"LREM", s.unackedKey(queue), "1", old},
+		{"LPUSH", s.queueKey(queue), value},
+		{"PUBLISH", s.channel(), s.opts.Instance + "\n" + queue},
+		{"EXEC"},
+	})
+	if err == nil {
+		delete(s.inflight, f)
+	}
+	return err
+}
+
+// Adds a value to the queue with the command, and notifies other servers.
+func (s *Storage) add(cmd, queue, value string) error {
+	if s.conn == nil {
+		return ErrClosed
+	}
+	return s.transaction([][]string{
+		{"MULTI"},
+		{cmd, s.queueKey(queue), value},
+		{"SADD", s.queuesKey(), queue},
+		{"PUBLISH", s.channel(), s.opts.Instance + "\n" + queue},
+		{"EXEC"},
+	})
+}
+
+// Sends the commands for a transaction, from MULTI to EXEC, and
+// returns the first error.
+func (s *Storage) transaction(cmds [][]string) error {
+	replies, err := s.conn.Pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	results, _ := replies[len(replies)-1].([]interface{})
+	for _, result := range results {
+		if err, ok := result.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dequeue removes the frame at the head of the queue. The frame is kept
+// until it is acknowledged, so that it can be recovered if the server
+// stops first. Returns nil if the queue is empty.
+func (s *Storage) Dequeue(queue string) (*frame.Frame, error) {
+	if s.conn == nil {
+		return nil, ErrClosed
+	}
+	reply, err := s.conn.Do("LMOVE", s.queueKey(queue), s.unackedKey(queue), "LEFT", "RIGHT")
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value := toBytes(reply)
+	f := new(frame.Frame)
+	if err := f.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	s.inflight[f] = string(value)
+	return f, nil
+}
+
+// Ack removes a frame dequeued from the queue once it has been
+// acknowledged. Frames not dequeued from this storage are ignored.
+func (s *Storage) Ack(queue string, f *frame.Frame) error {
+	value, ok := s.inflight[f]
+	if !ok {
+		return nil
+	}
+	if s.conn == nil {
+		return ErrClosed
+	}
+	if _, err := s.conn.Do("LREM", s.unackedKey(queue), "1", value); err != nil {
+		return err
+	}
+	delete(s.inflight, f)
+	return nil
+}
+
+// Len returns the number of frames in the queue, not including frames
+// that are waiting for acknowledgement.
+func (s *Storage) Len(queue string) int {
+	if s.conn == nil {
+		return 0
+	}
+	n, _ := s.conn.Do("LLEN", s.queueKey(queue))
+	length, _ := n.(int64)
+	return int(length)
+}
+
+// Iterate calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false. As other servers can
+// change the queue at the same time, frames might be missed or
+// repeated.
+func (s *Storage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	if s.conn == nil {
+		return ErrClosed
+	}
+	for start := 0; ; start += iteratePageSize {
+		reply, err := s.conn.Do("LRANGE", s.queueKey(queue),
+			strconv.Itoa(start), strconv.Itoa(start+iteratePageSize-1))
+		if err != nil {
+			return err
+		}
+		values, _ := reply.([]interface{})
+		for _, value := range values {
+			f := new(frame.Frame)
+			if err := f.UnmarshalBinary(toBytes(value)); err != nil {
+				return err
+			}
+			if !fn(f) {
+				return nil
+			}
+		}
+		if len(values) < iteratePageSize {
+			return nil
+		}
+	}
+}
+
+// Start has no effect, as the storage is ready to use once opened.
+func (s *Storage) Start() {
+}
+
+// Stop closes the storage.
+func (s *Storage) Stop() {
+	_ = s.Close()
+}
+
+func toBytes(reply interface{}) []byte {
+	switch v := reply.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package redisstore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/queue/queuetest"
+	. "gopkg.in/check.v1"
+)
+
+// The tests use a fake Redis server, unless this environment
+// variable contains the address of a Redis server.
+const addrEnv = "STOMP_TEST_REDIS"
+
+func TestRedisStore(t *testing.T) {
+	TestingT(t)
+}
+
+type StorageSuite struct {
+	fake   *fakeRedis
+	addr   string
+	prefix string
+}
+
+var _ = Suite(&StorageSuite{})
+
+// Storage implements the queue storage interfaces.
+var (
+	_ queue.SharedStorage = (*Storage)(nil)
+	_ queue.PingStorage   = (*Storage)(nil)
+)
+
+func (s *StorageSuite) SetUpSuite(c *C) {
+	s.addr = os.Getenv(addrEnv)
+	if s.addr == "" {
+		fake, err := newFakeRedis()
+		c.Assert(err, IsNil)
+		s.fake = fake
+		s.addr = fake.Addr()
+	}
+}
+
+func (s *StorageSuite) TearDownSuite(c *C) {
+	if s.fake != nil {
+		s.fake.Close()
+	}
+}
+
+func (s *StorageSuite) SetUpTest(c *C) {
+	s.prefix = fmt.Sprintf("stomp-test-%d:", time.Now().UnixNano())
+}
+
+func (s *StorageSuite) open(c *C, instance string) *Storage {
+	st, err := Open(Options{Addr: s.addr, Prefix: s.prefix, Instance: instance})
+	c.Assert(err, IsNil)
+	return st
+}
+
+func (s *StorageSuite) TestOrder(c *C) {
+	st := s.open(c, "a")
+	defer st.Close()
+
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("0")), IsNil)
+	c.Assert(st.Enqueue("/queue/other", queuetest.NewFrame("other")), IsNil)
+
+	c.Check(st.Len("/queue/test"), Equals, 4)
+	var bodies []string
+	err := st.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return len(bodies) < 3
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"0", "1", "2"})
+
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "0")
+	c.Check(st.Len("/queue/test"), Equals, 3)
+
+	// a frame that could not be sent goes back to the head
+	c.Assert(st.Requeue("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"0", "1", "2", "3"})
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/other"), DeepEquals, []string{"other"})
+}
+
+func (s *StorageSuite) TestReopen(c *C) {
+	st := s.open(c, "a")
+	for _, body := range []string{"1", "2", "3", "4"} {
+		c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+
+	// acknowledged frames are removed, unacknowledged frames are kept
+	f1, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f2, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(st.Ack("/queue/test", f2), IsNil)
+	c.Check(string(f1.Body), Equals, "1")
+	c.Assert(st.Close(), IsNil)
+	c.Check(st.Close(), Equals, ErrClosed)
+
+	// another instance does not recover the frame
+	other := s.open(c, "b")
+	c.Check(other.Len("/queue/test"), Equals, 2)
+	c.Assert(other.Close(), IsNil)
+
+	st = s.open(c, "a")
+	defer st.Close()
+	c.Check(st.Len("/queue/test"), Equals, 3)
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "1")
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+	c.Assert(st.Ack("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"3", "4"})
+}
+
+func (s *StorageSuite) TestShared(c *C) {
+	a := s.open(c, "a")
+	defer a.Close()
+	b := s.open(c, "b")
+	defer b.Close()
+
+	// each frame is dequeued by one of the servers
+	c.Assert(a.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(a.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	select {
+	case queue := <-b.Changes():
+		c.Check(queue, Equals, "/queue/test")
+	case <-time.After(5 * time.Second):
+		c.Fatal("change not received")
+	}
+	f, err := b.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "1")
+	c.Check(queuetest.DequeueBodies(c, a, "/queue/test"), DeepEquals, []string{"2"})
+	c.Assert(b.Ack("/queue/test", f), IsNil)
+	c.Check(b.Len("/queue/test"), Equals, 0)
+
+	// a server is not told about its own changes
+	select {
+	case queue := <-a.Changes():
+		c.Errorf("unexpected change to %s", queue)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// SetPolicies replaces the destination policies. If the server is
+// serving, the request processor makes the change, and applies it to
+// the existing destinations: queues change their dispatch mode, topics
+// start or stop retaining messages, and destinations are monitored for
+// their minimum number of consumers. Expiry destinations, mirror
+// queues, durability and message tracing are looked up for each
+// message, and so apply to the next message. The slow consumer
+// settings apply to subscriptions made afterwards. Client connections
+// are not affected. Invalid policies are rejected with an error, and
+// the policies are not changed.
+func (s *Server) SetPolicies(policies []DestinationPolicy) error {
+	if err := validatePolicies(policies); err != nil {
+		return err
+	}
+	policies = append([]DestinationPolicy(nil), policies...)
+	err := s.call(func(proc *requestProcessor) error {
+		s.mu.Lock()
+		s.Policies = policies
+		s.mu.Unlock()
+		return proc.applyPolicies()
+	})
+	if err == ErrNotServing {
+		s.mu.Lock()
+		s.Policies = policies
+		s.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// Returns the destination policies, for use outside of the request
+// processor.
+func (s *Server) policies() []DestinationPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Policies
+}
+
+// SetAuthenticator replaces the authenticator of the server, which
+// authenticates the clients that connect afterwards. Connected clients
+// are not affected. If auth is nil, clients are not authenticated.
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.mu.Lock()
+	s.Authenticator = auth
+	s.mu.Unlock()
+}
+
+func (s *Server) authenticator() Authenticator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Authenticator
+}
+
+// SetDisabledFeatures replaces the protocol features that clients are
+// not permitted to use. The change applies to the next frame of every
+// client, including clients that are already connected.
+func (s *Server) SetDisabledFeatures(features Feature) {
+	s.mu.Lock()
+	s.DisabledFeatures = features
+	s.mu.Unlock()
+}
+
+func (s *Server) disabledFeatures() Feature {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.DisabledFeatures
+}
+
+// SetLogLevels replaces the minimum log levels of the components of
+// the server. The change applies to every logger of the server,
+// including the loggers of connected clients.
+func (s *Server) SetLogLevels(levels map[string]stomp.Level) {
+	copied := make(map[string]stomp.Level, len(levels))
+	for component, level := range levels {
+		copied[component] = level
+	}
+	s.logLevels.Store(copied)
+	s.mu.Lock()
+	s.LogLevels = levels
+	s.mu.Unlock()
+}
+
+// Returns the minimum log level of a component, and whether it has one.
+func (s *Server) logLevel(component string) (stomp.Level, bool) {
+	levels, ok := s.logLevels.Load().(map[string]stomp.Level)
+	if !ok {
+		// SetLogLevels has not been called
+		levels = s.LogLevels
+	}
+	level, ok := levels[component]
+	return level, ok
+}
+
+// Applies the server's policies to the existing destinations. Called
+// by the request processor.
+func (proc *requestProcessor) applyPolicies() error {
+	policies := proc.server.Policies
+	var err error
+	for _, destination := range proc.qm.Destinations() {
+		mode := dispatchMode(policies, destination)
+		if e := proc.qm.Lookup(destination).SetMode(mode); e != nil && err == nil {
+			err = e
+		}
+	}
+	proc.tm.UpdateRetention()
+
+	proc.consumers.SetPolicies(policies)
+	now := time.Now()
+	for destination := range proc.subs {
+		proc.consumers.Update(destination, proc.subs.Count(destination), now)
+	}
+	for _, destination := range proc.qm.Destinations() {
+		proc.consumers.Update(destination, proc.subs.Count(destination), now)
+	}
+
+	// the intervals of the timers can depend on the policies
+	proc.timersChanged = true
+	return err
+}
+
+// componentLogger logs the entries of a component of the server that
+// are at least at the component's minimum level, which is looked up
+// for each entry, so that SetLogLevels applies to existing loggers.
+type componentLogger struct {
+	server    *Server
+	component string
+	log       stomp.Logger
+}
+
+func (l *componentLogger) enabled(level stomp.Level) bool {
+	min, ok := l.server.logLevel(l.component)
+	return !ok || level >= min
+}
+
+func (l *componentLogger) Debugf(format string, value ...interface{}) {
+	if l.enabled(stomp.LevelDebug) {
+		l.log.Debugf(format, value...)
+	}
+}
+
+func (l *componentLogger) Infof(format string, value ...interface{}) {
+	if l.enabled(stomp.LevelInfo) {
+		l.log.Infof(format, value...)
+	}
+}
+
+func (l *componentLogger) Warningf(format string, value ...interface{}) {
+	if l.enabled(stomp.LevelWarning) {
+		l.log.Warningf(format, value...)
+	}
+}
+
+func (l *componentLogger) Errorf(format string, value ...interface{}) {
+	if l.enabled(stomp.LevelError) {
+		l.log.Errorf(format, value...)
+	}
+}
+
+func (l *componentLogger) Debug(message string) {
+	if l.enabled(stomp.LevelDebug) {
+		l.log.Debug(message)
+	}
+}
+
+func (l *componentLogger) Info(message string) {
+	if l.enabled(stomp.LevelInfo) {
+		l.log.Info(message)
+	}
+}
+
+func (l *componentLogger) Warning(message string) {
+	if l.enabled(stomp.LevelWarning) {
+		l.log.Warning(message)
+	}
+}
+
+func (l *componentLogger) Error(message string) {
+	if l.enabled(stomp.LevelError) {
+		l.log.Error(message)
+	}
+}
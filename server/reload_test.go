@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type ReloadSuite struct{}
+
+var _ = Suite(&ReloadSuite{})
+
+func serveForReload(c *C, serv *Server) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	go serv.Serve(l)
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	return l
+}
+
+func (s *ReloadSuite) TestSetPolicies(c *C) {
+	serv := &Server{}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	sub1, err := conn.Subscribe("/queue/reload", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	sub2, err := conn.Subscribe("/queue/reload", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+
+	err = serv.SetPolicies([]DestinationPolicy{{Pattern: "/queue/reload", Dispatch: DispatchBroadcast}})
+	c.Assert(err, IsNil)
+	c.Check(serv.policies(), HasLen, 1)
+
+	// the existing queue delivers to both subscriptions
+	c.Assert(conn.Send("/queue/reload", "text/plain", []byte("both"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub1).Body), Equals, "both")
+	c.Check(string(receive(c, sub2).Body), Equals, "both")
+}
+
+func (s *ReloadSuite) TestSetInvalidPolicies(c *C) {
+	serv := &Server{}
+	serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	policies := []DestinationPolicy{{Pattern: "/topic/a", RetainFor: time.Minute}}
+	c.Assert(serv.SetPolicies(policies), IsNil)
+	err := serv.SetPolicies([]DestinationPolicy{{Pattern: "/topic/a", MirrorQueue: "audit"}})
+	c.Check(err, ErrorMatches, `policy "/topic/a": mirror queue "audit" is not a queue`)
+	c.Check(serv.policies(), DeepEquals, policies)
+}
+
+func (s *ReloadSuite) TestSetPoliciesNotServing(c *C) {
+	serv := &Server{}
+	err := serv.SetPolicies([]DestinationPolicy{{Pattern: "/topic/a", RetainFor: time.Minute}})
+	c.Assert(err, IsNil)
+	c.Check(serv.Policies, HasLen, 1)
+}
+
+func (s *ReloadSuite) TestSetAuthenticator(c *C) {
+	serv := &Server{}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("guest", "guest"))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+
+	serv.SetAuthenticator(testAuthenticator{})
+	_, err = stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("guest", "guest"))
+	c.Check(err, NotNil)
+	conn2, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.Login("user", "secret"))
+	c.Assert(err, IsNil)
+	conn2.Disconnect()
+
+	// the connected client is not affected
+	c.Check(conn.Send("/queue/a", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+}
+
+func (s *ReloadSuite) TestSetLogLevels(c *C) {
+	log := &countingLogger{}
+	serv := &Server{Log: log, LogLevels: map[string]stomp.Level{"client": stomp.LevelWarning}}
+	logger := serv.logger("client")
+	logger.Info("discarded")
+	c.Check(log.count, Equals, 0)
+
+	serv.SetLogLevels(map[string]stomp.Level{"client": stomp.LevelDebug})
+	logger.Info("logged")
+	logger.Debugf("logged %d", 2)
+	c.Check(log.count, Equals, 2)
+
+	serv.SetLogLevels(map[string]stomp.Level{"client": stomp.LevelError})
+	logger.Warning("discarded")
+	logger.Errorf("logged %d", 3)
+	c.Check(log.count, Equals, 3)
+}
+
+func (s *ReloadSuite) TestAdminReload(c *C) {
+	serv := &Server{}
+	serveForReload(c, serv)
+	defer serv.Shutdown()
+	handler := serv.AdminHandler(testAuthenticator{})
+
+	post := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/reload", nil)
+		r.SetBasicAuth("user", "secret")
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	c.Check(post(), Equals, http.StatusNotImplemented)
+
+	reloads := 0
+	serv.OnReload = func() error {
+		reloads++
+		return nil
+	}
+	c.Check(post(), Equals, http.StatusNoContent)
+	c.Check(reloads, Equals, 1)
+
+	serv.OnReload = func() error { return errors.New("invalid configuration") }
+	c.Check(post(), Equals, http.StatusInternalServerError)
+}
+
+// countingLogger counts the entries logged.
+type countingLogger struct {
+	count int
+}
+
+func (l *countingLogger) Debugf(format string, value ...interface{})   { l.count++ }
+func (l *countingLogger) Infof(format string, value ...interface{})    { l.count++ }
+func (l *countingLogger) Warningf(format string, value ...interface{}) { l.count++ }
+func (l *countingLogger) Errorf(format string, value ...interface{})   { l.count++ }
+func (l *countingLogger) Debug(message string)                         { l.count++ }
+func (l *countingLogger) Info(message string)                          { l.count++ }
+func (l *countingLogger) Warning(message string)                       { l.count++ }
+func (l *countingLogger) Error(message string)                         { l.count++ }
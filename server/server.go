@@ -5,10 +5,15 @@ package server
 
 import (
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/go-stomp/stomp/v3/internal/log"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/metrics"
 )
 
 // The STOMP server has the concept of queues and topics. A message
@@ -31,6 +36,20 @@ const (
 	DefaultHeartBeat = time.Minute
 )
 
+// Feature identifies an optional part of the STOMP protocol that can be
+// disabled for a server. Features can be combined with the bitwise OR
+// operator. Frames that make use of a disabled feature are rejected
+// with an ERROR frame.
+type Feature int
+
+// Optional protocol features.
+const (
+	FeatureTransactions Feature = 1 << iota // BEGIN, COMMIT and ABORT frames
+	FeatureNack                             // NACK frames
+	FeatureSubscribe                        // SUBSCRIBE and UNSUBSCRIBE frames
+	FeatureSend                             // SEND frames
+)
+
 // Interface for authenticating STOMP clients.
 type Authenticator interface {
 	// Authenticate based on the given login and passcode, either of which might be nil.
@@ -38,6 +57,18 @@ type Authenticator interface {
 	Authenticate(login, passcode string) bool
 }
 
+// Components of the server, whose names are attached to the entries
+// that they log in the stomp.ComponentField field, and whose minimum
+// levels can be set in Server.LogLevels.
+const (
+	ServerComponent  = "server"  // processing of requests and queue storage
+	ClientComponent  = "client"  // STOMP client connections
+	MQTTComponent    = "mqtt"    // MQTT client connections
+	ShardComponent   = "shard"   // forwarding of requests to cluster nodes
+	ArchiveComponent = "archive" // archiving of messages
+	WireComponent    = "wire"    // frames logged by wire logging
+)
+
 // A Server defines parameters for running a STOMP server.
 type Server struct {
 	Addr          string        // TCP address to listen on, DefaultAddr if empty
@@ -45,6 +76,183 @@ type Server struct {
 	QueueStorage  QueueStorage  // Implementation of queue storage. If nil, in-memory queues are used.
 	HeartBeat     time.Duration // Preferred value for heart-beat read/write timeout, if zero, then DefaultHeartBeat.
 	Log           stomp.Logger
+
+	// Minimum levels of the entries logged by components of the
+	// server, keyed by component name, such as ClientComponent.
+	// Components that have no level log entries of all levels.
+	LogLevels map[string]stomp.Level
+
+	// Protocol features that are not permitted for clients connecting
+	// to this server's listener, for example FeatureTransactions|FeatureNack
+	// for a listener facing the public internet. If zero, all features
+	// are permitted.
+	DisabledFeatures Feature
+
+	// Settings for individual destinations. The first policy whose
+	// pattern matches a destination applies to that destination.
+	Policies []DestinationPolicy
+
+	// If non-nil, messages acknowledged by clients are passed to an
+	// archive sink.
+	Archive *ArchiveConfig
+
+	// If non-zero, queues and topics that have had no subscriptions and
+	// no messages for this period are removed, and an advisory message
+	// is sent to DestinationRemovedAdvisory. If zero, destinations are
+	// never removed.
+	IdleDestinationTimeout time.Duration
+
+	// If true and QueueStorage is non-nil, only messages sent with the
+	// "persistent:true" header are kept in QueueStorage, and all other
+	// queued messages are kept in memory. If false, all queued messages
+	// are kept in QueueStorage.
+	HonorPersistentHeader bool
+
+	// If non-empty, the contents of all queues are written to this file
+	// when the server is stopped by Shutdown, and are restored from the
+	// file when the server next starts serving. This provides durability
+	// across restarts for in-memory queue storage.
+	SnapshotFile string
+
+	// If non-zero and QueueStorage is nil, at most this many messages of
+	// each queue are kept in memory. Further messages are written to a
+	// page file in PageDir, and read back as the head of the queue is
+	// consumed, so that a slow consumer cannot exhaust memory.
+	MaxMemoryMessages int
+
+	// Directory for the page files used if MaxMemoryMessages is
+	// non-zero. If empty, the default directory for temporary files
+	// is used.
+	PageDir string
+
+	// If non-zero, the queue storage is compacted at this interval,
+	// if it implements queue.CompactStorage. Compaction discards
+	// messages that exceed the retention settings of the storage,
+	// and reclaims disk space. See also CompactQueueStorage.
+	CompactInterval time.Duration
+
+	// Interval at which queue storage is written to disk for queues
+	// whose destination policy specifies DurabilityInterval. If zero,
+	// DefaultSyncInterval is used.
+	SyncInterval time.Duration
+
+	// If non-nil, destinations are partitioned among the servers of a
+	// cluster, and requests for destinations owned by other servers
+	// are forwarded to them.
+	Shard *ShardConfig
+
+	// If non-nil, measurements of the server's connections are recorded
+	// in Metrics. See Collector and MetricsHandler.
+	Metrics *metrics.Metrics
+
+	// If non-nil, spans are started for messages enqueued, dispatched
+	// to clients and acknowledged by clients, as children of the spans
+	// carried by the messages' "traceparent" header entries.
+	Tracer stomp.Tracer
+
+	// Number of frames that can wait to be written to a client before
+	// the slow consumer action of a subscription applies, and number
+	// of frames read from a client that can wait to be processed
+	// before reading from the client stops. If zero,
+	// client.DefaultMaxPendingWrites and client.DefaultMaxPendingReads
+	// are used.
+	MaxPendingWrites int
+	MaxPendingReads  int
+
+	// Multiplied by the interval of the heart-beats that a client
+	// sends to give the time that the client may be silent before its
+	// connection is closed. If zero,
+	// client.DefaultHeartBeatGracePeriodMultiplier is used.
+	HeartBeatGracePeriodMultiplier float64
+
+	// How strictly the frames received from clients are validated.
+	// The default, frame.Lenient, tolerates the quirks of common
+	// clients, and frame.Strict enforces STOMP 1.2 for clients that
+	// negotiate it. See frame.Frame.Validate.
+	Validation frame.ValidationMode
+
+	// Largest body, in bytes, of the frames that STOMP clients may
+	// send. A client that sends a larger frame is disconnected. The
+	// limit is advertised to clients in the CONNECTED frame, see
+	// stomp.Capabilities. If zero, frames are not limited.
+	MaxFrameSize int
+
+	// Name identifies the server to STOMP clients in the server header
+	// entry of the CONNECTED frame, followed by Version, as in
+	// "stompd/v3.1.0". If empty, DefaultName is used.
+	Name string
+
+	// What is done when the client of a subscription to a queue or
+	// topic does not read messages as fast as they are sent, unless
+	// the destination policy specifies otherwise. The action is taken
+	// once a message has waited SlowConsumerTimeout for room in the
+	// client's connection, or DefaultSlowConsumerTimeout if zero.
+	QueueSlowConsumer   SlowConsumerAction
+	TopicSlowConsumer   SlowConsumerAction
+	SlowConsumerTimeout time.Duration
+
+	// If non-zero, messages sent to topics are passed to their
+	// subscriptions by this many worker goroutines, so that a client
+	// that is slow to read its messages holds up only the subscriptions
+	// sharing its worker, rather than every subscription after it.
+	// Each subscription still receives messages in order, but a client
+	// may receive the RECEIPT for a SEND frame before the messages that
+	// the frame sent to the client's own subscriptions. If zero,
+	// messages are passed to one subscription after another.
+	TopicFanoutWorkers int
+
+	// If non-zero, the approximate number of bytes of messages that
+	// the server may hold in memory: in queues whose storage keeps them
+	// in memory, waiting to be written to clients, and in transactions
+	// that have not been committed. While more is held, the server
+	// stops reading from clients that send messages, until consumers
+	// have caught up. The bytes held are reported by Collector.
+	MaxMemoryBytes int64
+
+	// If non-zero, Ready reports that the server is not ready while
+	// the Go heap holds more than this many bytes, so that clients
+	// are directed to other servers before memory runs out.
+	MaxHeapBytes uint64
+
+	// If non-nil, hooks are called when clients connect and disconnect,
+	// subscribe and unsubscribe, and send and acknowledge messages.
+	Events *EventHooks
+
+	// Interceptors inspect, modify or reject the frames received from
+	// and sent to STOMP clients. Frames received are passed to the
+	// interceptors in order, and frames sent in reverse order.
+	Interceptors []client.Interceptor
+
+	// If non-nil, faults are injected into the frames and heart-beats
+	// of STOMP clients, for testing clients. See Faults.
+	Faults client.FaultInjector
+
+	// If non-empty, the bytes received from and sent to each STOMP
+	// client are recorded, with timestamps, to a session file in this
+	// directory, which can be replayed with record.Replayer to
+	// reproduce problems reported by the users of other clients.
+	RecordDir string
+
+	// If non-nil, records of the passage of traced messages through
+	// the server are passed to MessageTraceSink. See MessageTraceHeader.
+	MessageTraceSink MessageTraceSink
+
+	// Additional header entries, as pairs of names and values, of the
+	// advisory message sent to ShutdownAdvisory when Drain is called,
+	// for example to tell clients which server to connect to instead.
+	ShutdownAdvisoryHeaders []string
+
+	// OnReload, if not nil, is called by the admin API's POST /reload
+	// to reload the configuration of the server, for example with
+	// SetPolicies and SetAuthenticator.
+	OnReload func() error
+
+	mu        sync.Mutex        // protects proc, and the fields changed by the Set methods
+	proc      *requestProcessor // processes requests while serving
+	logLevels atomic.Value      // map[string]stomp.Level set by SetLogLevels
+	listeners []serverListener  // added by Opt.Listen and Opt.Listener
+	started   *startState       // set by Start
+	wireLog   wireLog           // logs frames, see SetConnectionWireLogging
 }
 
 // ListenAndServe listens on the TCP network address addr and then calls Serve.
@@ -76,6 +284,18 @@ func (s *Server) ListenAndServe() error {
 	return s.Serve(l)
 }
 
+// Returns the logger of a component of the server, which attaches
+// the name of the component to entries and discards those below the
+// component's level.
+func (s *Server) logger(component string) stomp.Logger {
+	var logger stomp.Logger = log.StdLogger{}
+	if s.Log != nil {
+		logger = s.Log
+	}
+	logger = stomp.WithFields(logger, stomp.Field{Key: stomp.ComponentField, Value: component})
+	return &componentLogger{server: s, component: component, log: logger}
+}
+
 // Serve accepts incoming connections on the Listener l, creating a new
 // service thread for each connection. The service threads read
 // requests and then process each request.
@@ -84,6 +304,19 @@ func (s *Server) Serve(l net.Listener) error {
 		s.Log = log.StdLogger{}
 	}
 
+	s.wireLog.server = s
 	proc := newRequestProcessor(s)
-	return proc.Serve(l)
+	// set before Shutdown can find the processor and close the listener
+	proc.listener = l
+	s.mu.Lock()
+	s.proc = proc
+	s.mu.Unlock()
+	err := proc.Serve(l)
+
+	s.mu.Lock()
+	if s.proc == proc {
+		s.proc = nil
+	}
+	s.mu.Unlock()
+	return err
 }
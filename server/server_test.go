@@ -3,11 +3,19 @@ package server
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/journal"
+	"github.com/go-stomp/stomp/v3/server/queue"
 	. "gopkg.in/check.v1"
 )
 
@@ -168,3 +176,1249 @@ func runReceiver(c *C, ch chan bool, count int, destination, addr string, starte
 	}
 	ch <- true
 }
+
+func (s *ServerSuite) TestDisabledFeatures(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{DisabledFeatures: FeatureTransactions | FeatureNack}
+	go serv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	reader := frame.NewReader(conn)
+	writer := frame.NewWriter(conn)
+
+	err = writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+	c.Assert(err, IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+
+	err = writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/test", frame.Receipt, "1"))
+	c.Assert(err, IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.RECEIPT)
+
+	err = writer.Write(frame.New(frame.BEGIN, frame.Transaction, "tx1", frame.Receipt, "2"))
+	c.Assert(err, IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "command not permitted: BEGIN")
+	c.Check(f.Header.Get(frame.ReceiptId), Equals, "2")
+}
+
+func (s *ServerSuite) TestDisconnectReceiptAfterSendReceipt(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{}
+	go serv.Serve(l)
+
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		c.Assert(err, IsNil)
+
+		reader := frame.NewReader(conn)
+		writer := frame.NewWriter(conn)
+
+		err = writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+		c.Assert(err, IsNil)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.CONNECTED)
+
+		err = writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/test", frame.Receipt, "send-1"))
+		c.Assert(err, IsNil)
+		err = writer.Write(frame.New(frame.DISCONNECT, frame.Receipt, "disc-1"))
+		c.Assert(err, IsNil)
+
+		for _, receipt := range []string{"send-1", "disc-1"} {
+			f, err = reader.Read()
+			c.Assert(err, IsNil)
+			c.Assert(f.Command, Equals, frame.RECEIPT)
+			c.Check(f.Header.Get(frame.ReceiptId), Equals, receipt)
+		}
+		conn.Close()
+	}
+}
+
+func (s *ServerSuite) TestTopicMirroring(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/topic/audit.>", MirrorQueue: "/queue/audit"},
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	err = client.Send("/topic/audit.orders", "text/plain", []byte("hello"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	sub, err := client.Subscribe("/queue/audit", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "hello")
+	c.Check(msg.Destination, Equals, "/queue/audit")
+	c.Check(msg.Header.Get(OriginalDestinationHeader), Equals, "/topic/audit.orders")
+}
+
+func (s *ServerSuite) TestWildcardSubscription(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	sub, err := client.Subscribe("/topic/prices.>", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	for _, destination := range []string{"/topic/prices.eu", "/topic/orders.eu", "/topic/prices.us.ny"} {
+		err = client.Send(destination, "text/plain", []byte(destination), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	for _, destination := range []string{"/topic/prices.eu", "/topic/prices.us.ny"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(msg.Destination, Equals, destination)
+		c.Check(string(msg.Body), Equals, destination)
+	}
+}
+
+func (s *ServerSuite) TestTopicFanout(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	serv := &Server{TopicFanoutWorkers: 4}
+	served := make(chan error, 1)
+	go func() { served <- serv.Serve(l) }()
+
+	var subs []*stomp.Subscription
+	for i := 0; i < 6; i++ {
+		client, err := stomp.Dial("tcp", l.Addr().String())
+		c.Assert(err, IsNil)
+		defer client.Disconnect()
+		sub, err := client.Subscribe("/topic/fanout", stomp.AckAuto)
+		c.Assert(err, IsNil)
+		subs = append(subs, sub)
+	}
+	waitFor(c, func() bool {
+		infos, _ := serv.Subscriptions()
+		return len(infos) == len(subs)
+	})
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	for i := 0; i < 10; i++ {
+		err = client.Send("/topic/fanout", "text/plain", []byte(strconv.Itoa(i)), nil)
+		c.Assert(err, IsNil)
+	}
+
+	// every subscription receives the messages in order
+	for _, sub := range subs {
+		for i := 0; i < 10; i++ {
+			msg := <-sub.C
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, strconv.Itoa(i))
+		}
+	}
+	c.Assert(serv.Shutdown(), IsNil)
+	c.Check(<-served, Equals, ErrServerClosed)
+}
+
+func (s *ServerSuite) TestIdleDestinationRemoved(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{IdleDestinationTimeout: 50 * time.Millisecond}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	advisories, err := client.Subscribe(DestinationRemovedAdvisory, stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	consumer, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	sub, err := consumer.Subscribe("/queue/idle", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = client.Send("/queue/idle", "text/plain", []byte("hello"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+
+	// the queue is not idle while it has a subscription
+	select {
+	case msg = <-advisories.C:
+		c.Fatalf("unexpected advisory for %s", msg.Header.Get(AdvisoryDestinationHeader))
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	c.Assert(consumer.Disconnect(), IsNil)
+	select {
+	case msg = <-advisories.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(msg.Header.Get(AdvisoryDestinationHeader), Equals, "/queue/idle")
+	case <-time.After(5 * time.Second):
+		c.Fatal("no advisory received")
+	}
+}
+
+func (s *ServerSuite) TestPauseQueue(c *C) {
+	serv := Server{}
+	c.Check(serv.PauseQueue("/queue/paused"), Equals, ErrNotServing)
+
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	c.Check(serv.PauseQueue("/topic/paused"), Equals, ErrNotQueue)
+	c.Assert(serv.PauseQueue("/queue/paused"), IsNil)
+	paused, err := serv.QueuePaused("/queue/paused")
+	c.Assert(err, IsNil)
+	c.Check(paused, Equals, true)
+
+	sub, err := client.Subscribe("/queue/paused", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"one", "two"} {
+		err = client.Send("/queue/paused", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message while paused: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Assert(serv.ResumeQueue("/queue/paused"), IsNil)
+	for _, body := range []string{"one", "two"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+	}
+	paused, err = serv.QueuePaused("/queue/paused")
+	c.Assert(err, IsNil)
+	c.Check(paused, Equals, false)
+}
+
+// Returns the values of the header entries of a message with a key,
+// in order.
+func headerValues(info MessageInfo, key string) []string {
+	var values []string
+	for _, entry := range info.Header {
+		if entry.Key == key {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+func (s *ServerSuite) TestQueueMessages(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	for _, body := range []string{"one", "two", "three", "four"} {
+		err = client.Send("/queue/dlq", "text/plain", []byte(body), stomp.SendOpt.Receipt,
+			stomp.SendOpt.Header(OriginalDestinationHeader, "/queue/work"),
+			stomp.SendOpt.Header("x-tag", "a"), stomp.SendOpt.Header("x-tag", "b"))
+		c.Assert(err, IsNil)
+	}
+	err = client.Send("/queue/dlq", "text/plain", []byte("stray"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	_, err = serv.QueueMessages("/topic/dlq", 0)
+	c.Check(err, Equals, ErrNotQueue)
+	messages, err := serv.QueueMessages("/queue/dlq", 2)
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 2)
+	c.Check(headerValues(messages[0], OriginalDestinationHeader), DeepEquals, []string{"/queue/work"})
+	c.Check(headerValues(messages[0], "x-tag"), DeepEquals, []string{"a", "b"})
+	c.Check(messages[0].BodySize, Equals, 3)
+	messages, err = serv.QueueMessages("/queue/dlq", 0)
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 5)
+
+	c.Assert(serv.DeleteMessage("/queue/dlq", messages[1].MessageId), IsNil)
+	c.Check(serv.DeleteMessage("/queue/dlq", messages[1].MessageId), Equals, ErrNoMessage)
+
+	moved, err := serv.MoveMessages("/queue/dlq", "/queue/other", 1)
+	c.Assert(err, IsNil)
+	c.Check(moved, Equals, 1)
+	moved, err = serv.MoveMessages("/queue/dlq", "", 0)
+	c.Assert(err, IsNil)
+	c.Check(moved, Equals, 2)
+
+	// the message without an original destination stays
+	messages, err = serv.QueueMessages("/queue/dlq", 0)
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 1)
+	c.Check(messages[0].BodySize, Equals, len("stray"))
+
+	for _, expected := range []struct {
+		destination string
+		bodies      []string
+		original    string
+	}{
+		{"/queue/other", []string{"one"}, "/queue/work"},
+		{"/queue/work", []string{"three", "four"}, ""},
+	} {
+		sub, err := client.Subscribe(expected.destination, stomp.AckAuto)
+		c.Assert(err, IsNil)
+		for _, body := range expected.bodies {
+			msg := <-sub.C
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, body)
+			c.Check(msg.Header.Get(OriginalDestinationHeader), Equals, expected.original)
+		}
+		c.Assert(sub.Unsubscribe(), IsNil)
+	}
+}
+
+func (s *ServerSuite) TestExpiryDestination(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/ttl.>", ExpiryDestination: "/queue/expired"},
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	expires := time.Now().Add(50 * time.Millisecond)
+	expiresText := strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10)
+	err = client.Send("/queue/ttl.orders", "text/plain", []byte("late"),
+		stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(frame.Expires, expiresText))
+	c.Assert(err, IsNil)
+	err = client.Send("/queue/ttl.orders", "text/plain", []byte("on time"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	time.Sleep(100 * time.Millisecond)
+
+	// the expired message is skipped
+	sub, err := client.Subscribe("/queue/ttl.orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "on time")
+
+	expired, err := client.Subscribe("/queue/expired", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = <-expired.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "late")
+	c.Check(msg.Header.Get(OriginalDestinationHeader), Equals, "/queue/ttl.orders")
+	c.Check(msg.Header.Get(OriginalExpiresHeader), Equals, expiresText)
+	_, ok := msg.Header.Contains(frame.Expires)
+	c.Check(ok, Equals, false)
+}
+
+func (s *ServerSuite) TestConsumerShortage(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/orders", MinConsumers: 1, ConsumerGracePeriod: 50 * time.Millisecond},
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	advisories, err := client.Subscribe(AdvisoryTopicPrefix+"consumer.>", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	err = client.Send("/queue/orders", "text/plain", []byte("order"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	msg := <-advisories.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Destination, Equals, ConsumerShortageAdvisory)
+	c.Check(msg.Header.Get(AdvisoryDestinationHeader), Equals, "/queue/orders")
+	c.Check(msg.Header.Get(ConsumerCountHeader), Equals, "0")
+
+	shortages, err := serv.ConsumerShortages()
+	c.Assert(err, IsNil)
+	c.Assert(shortages, HasLen, 1)
+	c.Check(shortages[0].Destination, Equals, "/queue/orders")
+	c.Check(shortages[0].MinConsumers, Equals, 1)
+	c.Check(shortages[0].Waiting, Equals, 1)
+
+	sub, err := client.Subscribe("/queue/orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = <-sub.C
+	c.Assert(msg.Err, IsNil)
+
+	msg = <-advisories.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Destination, Equals, ConsumersRestoredAdvisory)
+	c.Check(msg.Header.Get(ConsumerCountHeader), Equals, "1")
+
+	shortages, err = serv.ConsumerShortages()
+	c.Assert(err, IsNil)
+	c.Check(shortages, HasLen, 0)
+}
+
+func (s *ServerSuite) TestBroadcastQueue(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/broadcast", Dispatch: DispatchBroadcast},
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("/queue/broadcast", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	sub2, err := client.Subscribe("/queue/broadcast", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	bodies := []string{"one", "two", "three"}
+	for _, body := range bodies {
+		err = client.Send("/queue/broadcast", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// every subscription receives every message, in order
+	for _, body := range bodies {
+		msg := <-sub1.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+	}
+	for _, body := range bodies {
+		msg := <-sub2.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+		c.Assert(client.Ack(msg), IsNil)
+	}
+}
+
+func (s *ServerSuite) TestBroadcastQueueOrder(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/broadcast", Dispatch: DispatchBroadcast},
+		},
+	}
+	go serv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	reader := frame.NewReader(conn)
+	writer := frame.NewWriter(conn)
+
+	err = writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+	c.Assert(err, IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+
+	ids := []string{"1", "2", "3", "4", "5"}
+	for _, id := range ids {
+		err = writer.Write(frame.New(frame.SUBSCRIBE, frame.Id, id, frame.Destination, "/queue/broadcast"))
+		c.Assert(err, IsNil)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = writer.Write(frame.New(frame.SEND, frame.Destination, "/queue/broadcast"))
+		c.Assert(err, IsNil)
+
+		// copies are sent in the order that the subscriptions were added
+		for _, id := range ids {
+			f, err = reader.Read()
+			c.Assert(err, IsNil)
+			c.Assert(f.Command, Equals, frame.MESSAGE)
+			c.Check(f.Header.Get(frame.Subscription), Equals, id)
+		}
+	}
+}
+
+// Queue storage that records the frames passed to Ack.
+type ackRecordingStorage struct {
+	QueueStorage
+	mu    sync.Mutex
+	acked []*frame.Frame
+}
+
+func (s *ackRecordingStorage) Ack(queue string, f *frame.Frame) error {
+	s.mu.Lock()
+	s.acked = append(s.acked, f)
+	s.mu.Unlock()
+	return s.QueueStorage.Ack(queue, f)
+}
+
+func (s *ServerSuite) TestBroadcastQueueAck(c *C) {
+	storage := &ackRecordingStorage{QueueStorage: queue.NewMemoryQueueStorage()}
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := Server{
+		QueueStorage: storage,
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/broadcast", Dispatch: DispatchBroadcast},
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("/queue/broadcast", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	sub2, err := client.Subscribe("/queue/broadcast", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	err = client.Send("/queue/broadcast", "text/plain", []byte("copy"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	for _, sub := range []*stomp.Subscription{sub1, sub2} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Assert(client.Ack(msg), IsNil)
+	}
+
+	// the copies were never stored, so storage is not told of their
+	// acknowledgement
+	c.Assert(client.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+	storage.mu.Lock()
+	c.Check(storage.acked, HasLen, 0)
+	storage.mu.Unlock()
+}
+
+func (s *ServerSuite) TestRoundRobinQueue(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("/queue/roundrobin", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	sub2, err := client.Subscribe("/queue/roundrobin", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	for _, body := range []string{"one", "two"} {
+		err = client.Send("/queue/roundrobin", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// each subscription receives one message
+	msg1 := <-sub1.C
+	c.Assert(msg1.Err, IsNil)
+	msg2 := <-sub2.C
+	c.Assert(msg2.Err, IsNil)
+	c.Check(string(msg1.Body), Not(Equals), string(msg2.Body))
+}
+
+func (s *ServerSuite) TestClientAckPerSubscription(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	client1, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	sub1, err := client1.Subscribe("/queue/ack-a", stomp.AckClient)
+	c.Assert(err, IsNil)
+	sub2, err := client1.Subscribe("/queue/ack-b", stomp.AckClient)
+	c.Assert(err, IsNil)
+
+	c.Assert(client1.Send("/queue/ack-a", "text/plain", []byte("a"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(string(receive(c, sub1).Body), Equals, "a")
+	c.Assert(client1.Send("/queue/ack-b", "text/plain", []byte("b"), stomp.SendOpt.Receipt), IsNil)
+	msg := receive(c, sub2)
+	c.Check(string(msg.Body), Equals, "b")
+
+	// acknowledging the later message of the other subscription does
+	// not acknowledge the first message, which is requeued when the
+	// client disconnects
+	c.Assert(client1.Ack(msg), IsNil)
+	c.Assert(client1.Disconnect(), IsNil)
+
+	client2, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client2.Disconnect()
+	sub3, err := client2.Subscribe("/queue/ack-a", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	c.Check(string(receive(c, sub3).Body), Equals, "a")
+	sub4, err := client2.Subscribe("/queue/ack-b", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	select {
+	case msg := <-sub4.C:
+		c.Errorf("acknowledged message received again: %q", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *ServerSuite) TestAckVersions(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	// STOMP 1.1 clients acknowledge with the message-id and
+	// subscription headers, and STOMP 1.2 clients with the id header
+	for _, version := range []stomp.Version{stomp.V11, stomp.V12} {
+		destination := "/queue/ack-" + string(version)
+		client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(version))
+		c.Assert(err, IsNil)
+		c.Assert(client.Version(), Equals, version)
+		sub, err := client.Subscribe(destination, stomp.AckClientIndividual)
+		c.Assert(err, IsNil)
+		for _, body := range []string{"one", "two"} {
+			c.Assert(client.Send(destination, "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+		}
+		for _, body := range []string{"one", "two"} {
+			msg := receive(c, sub)
+			c.Check(string(msg.Body), Equals, body)
+			c.Assert(client.Ack(msg), IsNil)
+		}
+		c.Assert(client.Disconnect(), IsNil)
+	}
+}
+
+func (s *ServerSuite) TestAckInTransaction(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := &Server{}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/queue/tx-ack", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"one", "two"} {
+		c.Assert(client.Send("/queue/tx-ack", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "one")
+
+	acked := func() uint64 {
+		queues, err := serv.Queues()
+		c.Assert(err, IsNil)
+		for _, q := range queues {
+			if q.Destination == "/queue/tx-ack" {
+				return q.Acked
+			}
+		}
+		return 0
+	}
+
+	// an acknowledgement in an aborted transaction is discarded
+	tx := client.Begin()
+	c.Assert(tx.Ack(msg), IsNil)
+	c.Assert(tx.AbortWithReceipt(), IsNil)
+	c.Check(acked(), Equals, uint64(0))
+
+	// and one in a committed transaction is applied on commit
+	tx = client.Begin()
+	c.Assert(tx.Ack(msg), IsNil)
+	c.Assert(client.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt), IsNil)
+	c.Check(acked(), Equals, uint64(0))
+	c.Assert(tx.CommitWithReceipt(), IsNil)
+	c.Check(acked(), Equals, uint64(1))
+	c.Check(string(receive(c, sub).Body), Equals, "two")
+}
+
+type fakeArchiveSink struct {
+	ch chan []ArchiveRecord
+}
+
+func (s *fakeArchiveSink) Archive(records []ArchiveRecord) error {
+	s.ch <- records
+	return nil
+}
+
+func (s *ServerSuite) TestArchive(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	sink := &fakeArchiveSink{ch: make(chan []ArchiveRecord, 1)}
+	serv := Server{
+		Archive: &ArchiveConfig{
+			Sink:          sink,
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			IncludeBody:   true,
+		},
+	}
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	sub, err := client.Subscribe("/queue/archived", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	for _, body := range []string{"one", "two"} {
+		err = client.Send("/queue/archived", "text/plain", []byte(body))
+		c.Assert(err, IsNil)
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Assert(client.Ack(msg), IsNil)
+	}
+
+	records := <-sink.ch
+	c.Assert(records, HasLen, 2)
+	c.Check(records[0].Destination, Equals, "/queue/archived")
+	c.Check(string(records[0].Body), Equals, "one")
+	c.Check(string(records[1].Body), Equals, "two")
+	c.Check(records[0].MessageId, Not(Equals), "")
+	c.Check(records[0].RequestId, Not(Equals), "")
+	c.Check(records[0].RequestId, Not(Equals), records[1].RequestId)
+}
+
+func (s *ServerSuite) TestArchiveOnShutdown(c *C) {
+	sink := &fakeArchiveSink{ch: make(chan []ArchiveRecord, 1)}
+	serv := &Server{
+		Archive: &ArchiveConfig{
+			Sink:          sink,
+			FlushInterval: time.Hour,
+		},
+	}
+	l := serveForReload(c, serv)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	sub, err := client.Subscribe("/queue/archived", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	c.Assert(client.Send("/queue/archived", "text/plain", []byte("one")), IsNil)
+	msg := receive(c, sub)
+	c.Assert(client.Ack(msg), IsNil)
+	c.Assert(client.Disconnect(), IsNil)
+
+	// the record is neither in a full batch nor due to be flushed
+	select {
+	case records := <-sink.ch:
+		c.Fatalf("archived %d records before shutdown", len(records))
+	default:
+	}
+	c.Assert(serv.Shutdown(), IsNil)
+	select {
+	case records := <-sink.ch:
+		c.Assert(records, HasLen, 1)
+		c.Check(records[0].Destination, Equals, "/queue/archived")
+	default:
+		c.Fatal("records not archived on shutdown")
+	}
+}
+
+func (s *ServerSuite) TestArchiveBufferFull(c *C) {
+	sink := &fakeArchiveSink{ch: make(chan []ArchiveRecord)}
+	a := newArchiver(ArchiveConfig{Sink: sink, BatchSize: 1, IncludeBody: true}, (&Server{}).logger(ArchiveComponent))
+
+	// the sink takes no batches, so once the buffer is full the
+	// records are dropped instead of blocking Add
+	f := frame.New(frame.MESSAGE, frame.MessageId, "1", frame.Destination, "/queue/archived")
+	f.Body = []byte("one")
+	for i := 0; i < 10; i++ {
+		a.Add(f, strconv.Itoa(i))
+	}
+	c.Check(a.Dropped() >= 5, Equals, true)
+
+	// the record keeps its own copy of the frame
+	f.Header.Set(frame.MessageId, "2")
+	f.Body[0] = 'x'
+	records := <-sink.ch
+	c.Assert(records, HasLen, 1)
+	c.Check(records[0].Header.Get(frame.MessageId), Equals, "1")
+	c.Check(string(records[0].Body), Equals, "one")
+
+	go func() {
+		for range sink.ch {
+		}
+	}()
+	a.Stop()
+	close(sink.ch)
+}
+
+func (s *ServerSuite) TestVirtualTopic(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	subA, err := client.Subscribe("/queue/Consumer.A.VirtualTopic.Orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	subB, err := client.Subscribe("/queue/Consumer.B.VirtualTopic.Orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	err = client.Send("/topic/VirtualTopic.Orders", "text/plain", []byte("order-1"))
+	c.Assert(err, IsNil)
+
+	for _, sub := range []*stomp.Subscription{subA, subB} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, "order-1")
+		c.Check(msg.Destination, Equals, sub.Destination())
+		c.Check(msg.Header.Get(OriginalDestinationHeader), Equals, "/topic/VirtualTopic.Orders")
+	}
+}
+
+func (s *ServerSuite) TestSnapshot(c *C) {
+	snapshot := filepath.Join(c.MkDir(), "queues.snapshot")
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	serv := &Server{SnapshotFile: snapshot}
+	served := make(chan error, 1)
+	go func() { served <- serv.Serve(l) }()
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	for _, body := range []string{"one", "two", "three"} {
+		err = client.Send("/queue/snapshot", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// a message that has not been acknowledged is included in the snapshot
+	sub, err := client.Subscribe("/queue/snapshot", stomp.AckClient)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "one")
+
+	c.Assert(serv.Shutdown(), IsNil)
+	c.Check(<-served, Equals, ErrServerClosed)
+	c.Check(serv.Shutdown(), Equals, ErrNotServing)
+	client.MustDisconnect()
+
+	l, err = net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go (&Server{SnapshotFile: snapshot}).Serve(l)
+
+	client, err = stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	sub, err = client.Subscribe("/queue/snapshot", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"one", "two", "three"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+	}
+
+	// the snapshot is only restored once
+	_, err = os.Stat(snapshot)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ServerSuite) TestDrain(c *C) {
+	for _, ack := range []bool{true, false} {
+		snapshot := filepath.Join(c.MkDir(), "queues.snapshot")
+		l, err := net.Listen("tcp", `127.0.0.1:0`)
+		c.Assert(err, IsNil)
+		serv := &Server{
+			SnapshotFile:            snapshot,
+			ShutdownAdvisoryHeaders: []string{"reconnect-to", "other:61613"},
+		}
+		served := make(chan error, 1)
+		go func() { served <- serv.Serve(l) }()
+
+		client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+		c.Assert(err, IsNil)
+		advisories, err := client.Subscribe(ShutdownAdvisory, stomp.AckAuto)
+		c.Assert(err, IsNil)
+		sub, err := client.Subscribe("/queue/drain", stomp.AckClientIndividual)
+		c.Assert(err, IsNil)
+		for _, body := range []string{"first", "second"} {
+			err = client.Send("/queue/drain", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+			c.Assert(err, IsNil)
+		}
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, "first")
+
+		timeout := 100 * time.Millisecond
+		if ack {
+			timeout = 10 * time.Second
+		}
+		start := time.Now()
+		drained := make(chan error, 1)
+		go func() { drained <- serv.Drain(timeout) }()
+		advisory := <-advisories.C
+		c.Assert(advisory.Err, IsNil)
+		c.Check(advisory.Header.Get(DrainTimeoutHeader), Equals, strconv.Itoa(int(timeout/time.Millisecond)))
+		c.Check(advisory.Header.Get("reconnect-to"), Equals, "other:61613")
+		if ack {
+			// the queue is paused, so the second message is not
+			// sent once the first is acknowledged
+			c.Assert(client.Ack(msg), IsNil)
+		}
+		c.Assert(<-drained, IsNil)
+		c.Check(time.Since(start) < 5*time.Second, Equals, true)
+		c.Check(<-served, Equals, ErrServerClosed)
+		client.Disconnect()
+
+		// unacknowledged messages are returned to the queue
+		l, err = net.Listen("tcp", `127.0.0.1:0`)
+		c.Assert(err, IsNil)
+		serv = &Server{SnapshotFile: snapshot}
+		go serv.Serve(l)
+		for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+			time.Sleep(time.Millisecond)
+		}
+		messages, err := serv.QueueMessages("/queue/drain", 0)
+		c.Assert(err, IsNil)
+		if ack {
+			c.Check(messages, HasLen, 1)
+		} else {
+			c.Check(messages, HasLen, 2)
+		}
+		c.Assert(serv.Shutdown(), IsNil)
+	}
+}
+
+func (s *ServerSuite) TestMemoryLimit(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := &Server{MaxMemoryBytes: 4096}
+	go serv.Serve(l)
+
+	producer, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer producer.Disconnect()
+	body := make([]byte, 1024)
+	sent := make(chan error, 10)
+	go func() {
+		for i := 0; i < 10; i++ {
+			sent <- producer.Send("/queue/memory", "", body, stomp.SendOpt.Receipt)
+		}
+	}()
+
+	// the producer is held up once the queue holds too much
+	memoryBytes := func() float64 {
+		for _, family := range serv.Collector().Collect() {
+			if family.Name == "stomp_memory_bytes" && len(family.Samples) > 0 {
+				return family.Samples[0].Value
+			}
+		}
+		return 0
+	}
+	waitFor(c, func() bool { return memoryBytes() > 4096 })
+	time.Sleep(50 * time.Millisecond)
+	c.Check(len(sent) < 10, Equals, true)
+
+	consumer, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer consumer.Disconnect()
+	sub, err := consumer.Subscribe("/queue/memory", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Assert(<-sent, IsNil)
+	}
+}
+
+func (s *ServerSuite) TestInflightMessagesPersisted(c *C) {
+	dir := c.MkDir()
+	storage, err := journal.Open(journal.Options{Dir: dir})
+	c.Assert(err, IsNil)
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go (&Server{QueueStorage: storage}).Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	// the message is sent straight to the waiting subscription
+	sub, err := client.Subscribe("/queue/inflight", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	err = client.Send("/queue/inflight", "text/plain", []byte("one"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Header.Get(frame.Redelivered), Equals, "")
+
+	// if the server crashed now, the message would be redelivered
+	recovered, err := journal.Open(journal.Options{Dir: dir})
+	c.Assert(err, IsNil)
+	defer recovered.Close()
+	f, err := recovered.Dequeue("/queue/inflight")
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	c.Check(string(f.Body), Equals, "one")
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+}
+
+func (s *ServerSuite) TestCompactQueueStorage(c *C) {
+	retention := queue.Retention{MaxBytes: 3}
+	storage, err := journal.Open(journal.Options{Dir: c.MkDir(), Retention: retention})
+	c.Assert(err, IsNil)
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	serv := &Server{QueueStorage: storage}
+	_, err = serv.CompactQueueStorage()
+	c.Check(err, Equals, ErrNotServing)
+	go serv.Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	for _, body := range []string{"1", "2", "3", "4", "5"} {
+		err = client.Send("/queue/compact", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// the oldest messages are discarded, once they have all reached
+	// the queue, as receipts are sent before messages are enqueued
+	var discarded int
+	for i := 0; i < 100 && discarded < 2; i++ {
+		stats, err := serv.CompactQueueStorage()
+		c.Assert(err, IsNil)
+		discarded += stats.Discarded
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(discarded, Equals, 2)
+	sub, err := client.Subscribe("/queue/compact", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"3", "4", "5"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+	}
+}
+
+// Queue storage that counts the number of times it is synced.
+type syncCountingStorage struct {
+	QueueStorage
+	syncs int32
+}
+
+func (s *syncCountingStorage) Sync() error {
+	atomic.AddInt32(&s.syncs, 1)
+	return nil
+}
+
+func (s *ServerSuite) TestDurability(c *C) {
+	storage := &syncCountingStorage{QueueStorage: queue.NewMemoryQueueStorage()}
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go (&Server{
+		QueueStorage: storage,
+		SyncInterval: 50 * time.Millisecond,
+		Policies: []DestinationPolicy{
+			{Pattern: "/queue/fsync", Durability: DurabilityFsync},
+			{Pattern: "/queue/interval", Durability: DurabilityInterval},
+			{Pattern: "/queue/buffered", Durability: DurabilityBuffered},
+		},
+	}).Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+
+	// the receipt is sent once the storage has been synced
+	err = client.Send("/queue/fsync", "text/plain", []byte("1"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	c.Check(atomic.LoadInt32(&storage.syncs), Equals, int32(1))
+	err = client.Send("/queue/buffered", "text/plain", []byte("2"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	c.Check(atomic.LoadInt32(&storage.syncs), Equals, int32(1))
+
+	// the receipt waits for the next interval
+	start := time.Now()
+	err = client.Send("/queue/interval", "text/plain", []byte("3"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	c.Check(atomic.LoadInt32(&storage.syncs), Equals, int32(2))
+	c.Check(time.Since(start) < time.Second, Equals, true)
+
+	// changes in a transaction are synced once it is committed
+	tx := client.Begin()
+	c.Assert(tx.Send("/queue/fsync", "text/plain", []byte("4")), IsNil)
+	c.Assert(tx.Send("/queue/fsync", "text/plain", []byte("5")), IsNil)
+	c.Assert(tx.CommitWithReceipt(), IsNil)
+	c.Check(atomic.LoadInt32(&storage.syncs), Equals, int32(3))
+}
+
+// Queue storage shared with the test, which adds frames to it as
+// another server would.
+type sharedStorage struct {
+	mu      sync.Mutex
+	storage QueueStorage
+	changes chan string
+}
+
+func (s *sharedStorage) Enqueue(queue string, f *frame.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Enqueue(queue, f)
+}
+
+func (s *sharedStorage) Requeue(queue string, f *frame.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Requeue(queue, f)
+}
+
+func (s *sharedStorage) Dequeue(queue string) (*frame.Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Dequeue(queue)
+}
+
+func (s *sharedStorage) Ack(queue string, f *frame.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Ack(queue, f)
+}
+
+func (s *sharedStorage) Len(queue string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Len(queue)
+}
+
+func (s *sharedStorage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Iterate(queue, fn)
+}
+
+func (s *sharedStorage) Start() {
+	s.storage.Start()
+}
+
+func (s *sharedStorage) Stop() {
+	s.storage.Stop()
+}
+
+func (s *sharedStorage) Changes() <-chan string {
+	return s.changes
+}
+
+func (s *ServerSuite) TestSharedStorage(c *C) {
+	storage := &sharedStorage{
+		storage: queue.NewMemoryQueueStorage(),
+		changes: make(chan string),
+	}
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go (&Server{QueueStorage: storage}).Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	sub, err := client.Subscribe("/queue/shared", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	// wait until the subscription has been processed
+	err = client.Send("/queue/other", "text/plain", []byte("0"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	// frames added by another server are sent once the server is told
+	f := frame.New(frame.MESSAGE, frame.Destination, "/queue/shared")
+	f.Body = []byte("1")
+	c.Assert(storage.Enqueue("/queue/shared", f), IsNil)
+	storage.changes <- "/queue/shared"
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "1")
+}
+
+func (s *ServerSuite) TestReplayTopic(c *C) {
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go (&Server{
+		Policies: []DestinationPolicy{
+			{Pattern: "/topic/log", RetainMessages: 2},
+		},
+	}).Serve(l)
+
+	client, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Disconnect()
+	for _, body := range []string{"1", "2", "3"} {
+		err = client.Send("/topic/log", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// a cursor resumes after the last message committed
+	cursor := &stomp.Cursor{Name: "test", Store: &stomp.MemoryCursorStore{}}
+	c.Assert(cursor.Store.SaveCursor("test", 2), IsNil)
+	sub, err := client.Subscribe("/topic/log", stomp.AckAuto, stomp.SubscribeOpt.Cursor(cursor))
+	c.Assert(err, IsNil)
+	err = client.Send("/topic/log", "text/plain", []byte("4"))
+	c.Assert(err, IsNil)
+	for _, body := range []string{"3", "4"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+		c.Check(msg.Header.Get(stomp.SequenceHeader), Equals, body)
+	}
+
+	// replay everything retained
+	sub, err = client.Subscribe("/topic/log", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(stomp.FromSequenceHeader, "0"))
+	c.Assert(err, IsNil)
+	for _, body := range []string{"3", "4"} {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+	}
+}
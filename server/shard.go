@@ -0,0 +1,433 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/shard"
+)
+
+// ShardConfig partitions destinations among the servers of a cluster.
+// Each destination is owned by one server, as determined by the ring,
+// and only the owner keeps its messages and subscriptions. A server
+// forwards the SEND frames that its clients send to destinations that
+// it does not own to the owner, and subscribes to those destinations
+// at the owner on behalf of its clients, passing on the messages that
+// it receives and the client's ACK and NACK frames. So a client can
+// connect to any server of the cluster.
+//
+// Copies of messages made for mirror queues and virtual topics are sent
+// to the owners of the queues. Messages that expire are moved by the
+// owner of the destination that they were sent to.
+type ShardConfig struct {
+	Node string      // Name of this server in Ring, required
+	Ring *shard.Ring // Nodes of the cluster, whose names are their addresses unless Dial is set
+
+	// Connects to another node of the cluster. If nil, the node's name
+	// is dialled as a TCP address with STOMP 1.1. The connection must
+	// support NACK frames.
+	Dial func(node string) (*stomp.Conn, error)
+}
+
+func (sc *ShardConfig) dial(node string) (*stomp.Conn, error) {
+	if sc.Dial != nil {
+		return sc.Dial(node)
+	}
+	return stomp.Dial("tcp", node, stomp.ConnOpt.AcceptVersion(stomp.V11))
+}
+
+// Sent in an ERROR frame to a client whose request could not be
+// forwarded to the node that owns its destination.
+var errOwnerUnavailable = errors.New("cluster node owning destination is unavailable")
+
+// Headers of a SEND frame that are not copied to the forwarded frame.
+var unforwardedHeaders = map[string]bool{
+	frame.Destination:   true,
+	frame.ContentType:   true,
+	frame.ContentLength: true,
+	frame.Receipt:       true,
+	frame.MessageId:     true,
+}
+
+// Forwards the requests of clients for destinations owned by other
+// nodes. Apart from the peers, it is only used by the request
+// processor go-routine.
+type sharding struct {
+	proc     *requestProcessor
+	config   *ShardConfig
+	peers    map[string]*peer
+	relays   map[*client.Subscription]*relay
+	inflight map[*frame.Frame]relayedMessage // messages sent to clients by relays
+	log      stomp.Logger
+}
+
+// A message received from the owner of a destination, and sent to a
+// client.
+type relayedMessage struct {
+	msg  *stomp.Message
+	peer *peer
+}
+
+func newSharding(proc *requestProcessor, config *ShardConfig) *sharding {
+	return &sharding{
+		proc:     proc,
+		config:   config,
+		peers:    make(map[string]*peer),
+		relays:   make(map[*client.Subscription]*relay),
+		inflight: make(map[*frame.Frame]relayedMessage),
+		log:      proc.server.logger(ShardComponent),
+	}
+}
+
+// Returns the node that owns a destination, or an empty string if it
+// is owned by this node.
+func (sh *sharding) owner(destination string) string {
+	if owner := sh.config.Ring.Owner(destination); owner != sh.config.Node {
+		return owner
+	}
+	return ""
+}
+
+func (sh *sharding) peer(node string) *peer {
+	p, ok := sh.peers[node]
+	if !ok {
+		p = newPeer(node, sh.config, sh.log)
+		sh.peers[node] = p
+	}
+	return p
+}
+
+// Handles a request for a destination owned by another node, and
+// reports whether it has done so. The request's receipt is cleared if
+// it will be sent once the owner has processed the request.
+func (sh *sharding) handle(r *client.Request) bool {
+	switch r.Op {
+	case client.SubscribeOp:
+		if rl, ok := sh.relays[r.Sub]; ok {
+			rl.ready()
+			return true
+		}
+		if r.Sub.ForwardedBy() != "" {
+			return false
+		}
+		if owner := sh.owner(r.Sub.Destination()); owner != "" {
+			rl := &relay{sh: sh, sub: r.Sub, peer: sh.peer(owner), isReady: true}
+			sh.relays[r.Sub] = rl
+			rl.subscribe()
+			return true
+		}
+
+	case client.UnsubscribeOp:
+		if rl, ok := sh.relays[r.Sub]; ok {
+			delete(sh.relays, r.Sub)
+			rl.unsubscribe()
+			return true
+		}
+
+	case client.EnqueueOp:
+		destination := r.Frame.Header.Get(frame.Destination)
+		if _, ok := r.Frame.Header.Contains(client.ForwardedHeader); ok {
+			r.Frame.Header.Del(client.ForwardedHeader)
+			return false
+		}
+		if owner := sh.owner(destination); owner != "" {
+			sh.forward(owner, r.Frame, r.Conn, r.Receipt)
+			r.Receipt = ""
+			return true
+		}
+
+	case client.AckOp, client.RequeueOp:
+		rm, ok := sh.inflight[r.Frame]
+		if !ok {
+			return false
+		}
+		delete(sh.inflight, r.Frame)
+		if r.Op == client.AckOp {
+			rm.peer.do(func(*stomp.Conn) error {
+				// if the connection has failed, the owner
+				// delivers the message again
+				_ = rm.msg.Conn.Ack(rm.msg)
+				return nil
+			})
+		} else {
+			nack(rm.peer, rm.msg)
+		}
+		return true
+	}
+	return false
+}
+
+// Sends a frame to the node that owns its destination, and sends the
+// receipt to the client once the owner has stored it. If conn is nil,
+// the frame was not sent by a client, and failures are only logged.
+func (sh *sharding) forward(node string, f *frame.Frame, conn *client.Conn, receipt string) {
+	opts := []func(*frame.Frame) error{stomp.SendOpt.Header(client.ForwardedHeader, sh.config.Node)}
+	for i := 0; i < f.Header.Len(); i++ {
+		key, value := f.Header.GetAt(i)
+		if !unforwardedHeaders[key] {
+			opts = append(opts, stomp.SendOpt.Header(key, value))
+		}
+	}
+	if receipt != "" {
+		opts = append(opts, stomp.SendOpt.Receipt)
+	}
+	destination := f.Header.Get(frame.Destination)
+	contentType := f.Header.Get(frame.ContentType)
+	sh.peer(node).do(func(c *stomp.Conn) error {
+		err := errOwnerUnavailable
+		if c != nil {
+			err = c.Send(destination, contentType, f.Body, opts...)
+		}
+		if conn == nil {
+			if err != nil {
+				stomp.WithFields(sh.log, stomp.Field{Key: stomp.DestinationField, Value: destination}).
+					Errorf("forwarding message failed: %v", err)
+			}
+		} else if err != nil {
+			conn.SendError(errOwnerUnavailable)
+		} else {
+			conn.SendReceipt(receipt)
+		}
+		if c == nil {
+			return nil
+		}
+		return err
+	})
+}
+
+// Disconnects from the other nodes.
+func (sh *sharding) close() {
+	for _, p := range sh.peers {
+		p.close()
+	}
+}
+
+// A relay subscribes to a destination at its owner on behalf of a
+// subscription of a client of this node.
+type relay struct {
+	sh      *sharding
+	sub     *client.Subscription
+	peer    *peer
+	remote  *stomp.Subscription // nil until the owner has been subscribed to
+	isReady bool                // the client's subscription is ready for a message
+	pending *stomp.Message      // message waiting for the client's subscription to be ready
+	closed  bool                // the client has unsubscribed
+}
+
+func (rl *relay) ackMode() stomp.AckMode {
+	if isQueueDestination(rl.sub.Destination()) {
+		// the owner waits for each message to be acknowledged
+		// by the client before sending the next
+		return stomp.AckClientIndividual
+	}
+	return stomp.AckAuto
+}
+
+// Subscribes to the destination at its owner, and passes the messages
+// received to the processor.
+func (rl *relay) subscribe() {
+	proc := rl.sh.proc
+	rl.peer.do(func(c *stomp.Conn) error {
+		var remote *stomp.Subscription
+		var err error
+		if c != nil {
+			remote, err = c.Subscribe(rl.sub.Destination(), rl.ackMode(),
+				stomp.SubscribeOpt.Header(client.ForwardedHeader, rl.sh.config.Node))
+		}
+		proc.post(func() {
+			if remote == nil {
+				if !rl.closed {
+					rl.fail()
+				}
+				return
+			}
+			rl.remote = remote
+			if rl.closed {
+				rl.unsubscribe()
+			}
+		})
+		if remote == nil {
+			return err
+		}
+		go func() {
+			for msg := range remote.C {
+				msg := msg
+				proc.post(func() { rl.receive(msg) })
+			}
+		}()
+		return nil
+	})
+}
+
+// Called when a message is received from the owner.
+func (rl *relay) receive(msg *stomp.Message) {
+	if msg.Err != nil {
+		if !rl.closed {
+			rl.fail()
+		}
+		return
+	}
+	if rl.closed {
+		nack(rl.peer, msg)
+		return
+	}
+	if !isQueueDestination(rl.sub.Destination()) {
+		rl.sub.SendTopicFrame(relayedFrame(msg))
+		return
+	}
+	rl.pending = msg
+	if rl.isReady {
+		rl.ready()
+	}
+}
+
+// Called when the client's subscription is ready for a message.
+func (rl *relay) ready() {
+	rl.isReady = true
+	msg := rl.pending
+	if msg == nil {
+		return
+	}
+	rl.pending = nil
+	rl.isReady = false
+	f := relayedFrame(msg)
+	if err := rl.sub.SendQueueFrame(f); err != nil {
+		nack(rl.peer, msg)
+		return
+	}
+	rl.sh.inflight[f] = relayedMessage{msg: msg, peer: rl.peer}
+}
+
+// Unsubscribes from the owner once the client has unsubscribed. The
+// messages sent to the client and not acknowledged are requeued by
+// the client's connection.
+func (rl *relay) unsubscribe() {
+	rl.closed = true
+	if rl.pending != nil {
+		nack(rl.peer, rl.pending)
+		rl.pending = nil
+	}
+	if remote := rl.remote; remote != nil {
+		rl.remote = nil
+		rl.peer.do(func(*stomp.Conn) error {
+			_ = remote.Unsubscribe()
+			return nil
+		})
+	}
+}
+
+// Returns a message received from a peer to the owner's queue.
+func nack(p *peer, msg *stomp.Message) {
+	if msg.Subscription.AckMode() == stomp.AckAuto {
+		return
+	}
+	p.do(func(*stomp.Conn) error {
+		// if the connection has failed, the owner
+		// requeues the message anyway
+		_ = msg.Conn.Nack(msg)
+		return nil
+	})
+}
+
+// Ends the client's connection when the owner cannot be subscribed to,
+// or the subscription fails.
+func (rl *relay) fail() {
+	rl.sub.SendError(errOwnerUnavailable)
+}
+
+// Returns a frame to send to a client for a message received from the
+// owner of its destination.
+func relayedFrame(msg *stomp.Message) *frame.Frame {
+	f := frame.New(frame.MESSAGE)
+	f.Header = msg.Header.Clone()
+	f.Header.Del(frame.MessageId)
+	f.Header.Del(frame.Subscription)
+	f.Header.Del(frame.Ack)
+	f.Body = msg.Body
+	return f
+}
+
+// A peer is the connection to another node. Operations on the
+// connection are run in order on the peer's go-routine, so that the
+// request processor never waits for other nodes.
+type peer struct {
+	node   string
+	config *ShardConfig
+	log    stomp.Logger
+
+	mutex  sync.Mutex
+	ops    []func(*stomp.Conn) error
+	ready  chan struct{} // signalled when an operation is added
+	closed bool
+}
+
+func newPeer(node string, config *ShardConfig, log stomp.Logger) *peer {
+	p := &peer{node: node, config: config, log: log, ready: make(chan struct{}, 1)}
+	go p.run()
+	return p
+}
+
+// Adds an operation, which is called with the connection to the node,
+// or nil if the node cannot be connected to. If the operation returns
+// an error, the connection is closed and the node is connected to
+// again for the next operation.
+func (p *peer) do(op func(*stomp.Conn) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.ops = append(p.ops, op)
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (p *peer) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.ready)
+	}
+}
+
+func (p *peer) run() {
+	var conn *stomp.Conn
+	defer func() {
+		if conn != nil {
+			conn.MustDisconnect()
+		}
+	}()
+	for range p.ready {
+		p.mutex.Lock()
+		ops := p.ops
+		p.ops = nil
+		p.mutex.Unlock()
+		for _, op := range ops {
+			if conn == nil {
+				var err error
+				if conn, err = p.config.dial(p.node); err != nil {
+					p.log.Errorf("connecting to cluster node %s failed: %v", p.node, err)
+				}
+			}
+			if err := op(conn); err != nil && conn != nil {
+				p.log.Errorf("request to cluster node %s failed: %v", p.node, err)
+				conn.MustDisconnect()
+				conn = nil
+			}
+		}
+	}
+}
+
+// Runs fn on the request processor go-routine, unless it has stopped.
+func (proc *requestProcessor) post(fn func()) {
+	select {
+	case proc.calls <- fn:
+	case <-proc.stopped:
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Package shard partitions destinations among the servers of a cluster
+with consistent hashing.
+
+Each node is placed at a number of points on a ring of hash values, and
+a destination is owned by the node at the first point that follows the
+hash of its name. When a node is added to or removed from the ring, only
+the destinations owned by that node move, and the destinations of the
+other nodes keep their owners.
+*/
+package shard
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// Default number of points at which each node is placed on a ring.
+const DefaultReplicas = 128
+
+// A point on the ring, owned by a node.
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring assigns each destination to one of a set of nodes. A Ring does
+// not change once created, and is safe for concurrent use.
+type Ring struct {
+	points []point
+	nodes  []string
+}
+
+// NewRing creates a ring of the nodes, each of which is placed at the
+// given number of points, or DefaultReplicas if replicas is zero. The
+// more points, the more evenly destinations are spread among the nodes.
+// Every server of a cluster must use a ring of the same nodes and
+// replicas, in any order.
+func NewRing(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{nodes: append([]string(nil), nodes...)}
+	sort.Strings(r.nodes)
+	for _, node := range r.nodes {
+		for i := 0; i < replicas; i++ {
+			r.points = append(r.points, point{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// Nodes returns the nodes of the ring, in order of their names.
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Owner returns the node that owns a destination, or an empty string
+// if the ring has no nodes.
+func (r *Ring) Owner(destination string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(destination)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+func hash(s string) uint64 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
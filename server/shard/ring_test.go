@@ -0,0 +1,55 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestShard(t *testing.T) {
+	TestingT(t)
+}
+
+type RingSuite struct{}
+
+var _ = Suite(&RingSuite{})
+
+func destinations(n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("/queue/test-%d", i))
+	}
+	return names
+}
+
+func (s *RingSuite) TestOwner(c *C) {
+	c.Check(NewRing(0).Owner("/queue/test"), Equals, "")
+
+	// rings of the same nodes agree, whatever their order
+	a := NewRing(0, "a", "b", "c")
+	b := NewRing(0, "c", "a", "b")
+	c.Check(b.Nodes(), DeepEquals, []string{"a", "b", "c"})
+	counts := make(map[string]int)
+	for _, d := range destinations(3000) {
+		c.Check(a.Owner(d), Equals, b.Owner(d))
+		counts[a.Owner(d)]++
+	}
+	for _, node := range a.Nodes() {
+		c.Check(counts[node] > 700, Equals, true, Commentf("%s owns %d", node, counts[node]))
+	}
+}
+
+func (s *RingSuite) TestRemove(c *C) {
+	before := NewRing(0, "a", "b", "c")
+	after := NewRing(0, "a", "b")
+
+	// only the destinations of the removed node move
+	for _, d := range destinations(1000) {
+		if owner := before.Owner(d); owner != "c" {
+			c.Check(after.Owner(d), Equals, owner)
+		} else {
+			c.Check(after.Owner(d), Not(Equals), "c")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server/shard"
+	. "gopkg.in/check.v1"
+)
+
+type ShardSuite struct{}
+
+var _ = Suite(&ShardSuite{})
+
+// Starts a cluster of two servers, which are named by their addresses.
+func startShards(c *C) (addrs []string, shutdown func()) {
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, IsNil)
+		listeners = append(listeners, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+	ring := shard.NewRing(0, addrs...)
+	var servers []*Server
+	for i, l := range listeners {
+		serv := &Server{Shard: &ShardConfig{Node: addrs[i], Ring: ring}}
+		go serv.Serve(l)
+		for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+			time.Sleep(time.Millisecond)
+		}
+		servers = append(servers, serv)
+	}
+	return addrs, func() {
+		for _, serv := range servers {
+			serv.Shutdown()
+		}
+	}
+}
+
+// Returns a destination with the prefix that is owned by a node.
+func ownedBy(ring *shard.Ring, prefix, node string) string {
+	for i := 0; ; i++ {
+		if d := fmt.Sprintf("%s%d", prefix, i); ring.Owner(d) == node {
+			return d
+		}
+	}
+}
+
+func dialShard(c *C, addr string) *stomp.Conn {
+	conn, err := stomp.Dial("tcp", addr, stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func receive(c *C, sub *stomp.Subscription) *stomp.Message {
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		return msg
+	case <-time.After(5 * time.Second):
+		c.Fatal("message not received")
+		return nil
+	}
+}
+
+func (s *ShardSuite) TestForwardSend(c *C) {
+	addrs, shutdown := startShards(c)
+	defer shutdown()
+	queue := ownedBy(shard.NewRing(0, addrs...), "/queue/test-", addrs[1])
+
+	a := dialShard(c, addrs[0])
+	defer a.Disconnect()
+	c.Assert(a.Send(queue, "text/plain", []byte("forwarded"), stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header("custom", "kept")), IsNil)
+
+	// the owner has stored the message when the receipt is sent, and
+	// its subscriber is not relayed
+	b := dialShard(c, addrs[1])
+	defer b.Disconnect()
+	sub, err := b.Subscribe(queue, stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "forwarded")
+	c.Check(msg.Header.Get("custom"), Equals, "kept")
+	_, ok := msg.Header.Contains("forwarded-by")
+	c.Check(ok, Equals, false)
+}
+
+func (s *ShardSuite) TestRelayQueue(c *C) {
+	addrs, shutdown := startShards(c)
+	defer shutdown()
+	queue := ownedBy(shard.NewRing(0, addrs...), "/queue/test-", addrs[1])
+
+	b := dialShard(c, addrs[1])
+	defer b.Disconnect()
+	for _, body := range []string{"one", "two"} {
+		c.Assert(b.Send(queue, "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+
+	// the client of the other node receives the messages from the
+	// owner, and acknowledges them
+	a := dialShard(c, addrs[0])
+	sub, err := a.Subscribe(queue, stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	msg := receive(c, sub)
+	c.Check(string(msg.Body), Equals, "one")
+	c.Assert(a.Ack(msg), IsNil)
+	msg = receive(c, sub)
+	c.Check(string(msg.Body), Equals, "two")
+
+	// the unacknowledged message is returned to the owner's queue
+	a.Disconnect()
+	sub, err = b.Subscribe(queue, stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = receive(c, sub)
+	c.Check(string(msg.Body), Equals, "two")
+}
+
+func (s *ShardSuite) TestRelayTopic(c *C) {
+	addrs, shutdown := startShards(c)
+	defer shutdown()
+	topic := ownedBy(shard.NewRing(0, addrs...), "/topic/test-", addrs[1])
+
+	a := dialShard(c, addrs[0])
+	defer a.Disconnect()
+	sub, err := a.Subscribe(topic, stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	// messages sent before the relay has subscribed at the owner
+	// are not received
+	b := dialShard(c, addrs[1])
+	defer b.Disconnect()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.Assert(b.Send(topic, "text/plain", []byte("published")), IsNil)
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, "published")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Fatal("message not received")
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Interval at which Drain checks for acknowledgements.
+const drainPollInterval = 10 * time.Millisecond
+
+// ErrServerClosed is returned by Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+// Shutdown stops the server from accepting new connections, and closes
+// every client connection. Messages sent to clients and not acknowledged
+// are returned to their queues. If SnapshotFile is set, the contents of
+// all queues are then written to the snapshot file. Records of
+// acknowledged messages that have not been archived yet are passed to
+// the archive sink. Finally the queue storage is stopped, and Serve
+// returns ErrServerClosed. The listeners of a server started by Start
+// are closed before Shutdown returns.
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return ErrNotServing
+	}
+	err := proc.shutdown()
+	s.waitStarted()
+	return err
+}
+
+// Drain shuts the server down without losing messages, for rolling
+// restarts. The server stops accepting new connections and stops
+// dispatching messages from queues, and sends an advisory message to
+// ShutdownAdvisory, with the header entries in ShutdownAdvisoryHeaders,
+// so that clients can acknowledge the messages that they have received
+// and connect to another server. Once every message sent to a client
+// has been acknowledged or negatively acknowledged, or the timeout has
+// passed, the server shuts down as for Shutdown, and messages that
+// have not been acknowledged are returned to their queues.
+func (s *Server) Drain(timeout time.Duration) error {
+	s.mu.Lock()
+	proc := s.proc
+	s.mu.Unlock()
+	if proc == nil {
+		return ErrNotServing
+	}
+	err := proc.drain(timeout)
+	s.waitStarted()
+	return err
+}
+
+// Waits for a server started by Start to close its listeners.
+func (s *Server) waitStarted() {
+	s.mu.Lock()
+	st := s.started
+	s.mu.Unlock()
+	if st != nil {
+		<-st.done
+	}
+}
+
+func (proc *requestProcessor) drain(timeout time.Duration) error {
+	proc.listener.Close()
+	select {
+	case <-proc.listening:
+	case <-proc.stopped:
+		return ErrNotServing
+	}
+
+	deadline := time.Now().Add(timeout)
+	headers := append([]string{
+		DrainTimeoutHeader, strconv.FormatInt(int64(timeout/time.Millisecond), 10),
+	}, proc.server.ShutdownAdvisoryHeaders...)
+	for advised := false; ; advised = true {
+		result := make(chan int, 1)
+		select {
+		case proc.calls <- func() {
+			// queues created while draining are paused too
+			unacked := proc.pauseQueues()
+			if !advised {
+				proc.advise(ShutdownAdvisory, "", headers...)
+			}
+			result <- unacked
+		}:
+		case <-proc.stopped:
+			return ErrNotServing
+		}
+		unacked := <-result
+		if unacked == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return proc.shutdown()
+}
+
+// Pauses every queue, and returns the number of subscriptions to
+// queues that are waiting for their client to acknowledge a message.
+func (proc *requestProcessor) pauseQueues() int {
+	unacked := 0
+	for _, destination := range proc.qm.Destinations() {
+		q := proc.qm.Find(destination)
+		q.Pause()
+		unacked += proc.subs.Count(destination) - q.Ready()
+	}
+	return unacked
+}
+
+func (proc *requestProcessor) shutdown() error {
+	// wait for the listener to stop, so that no connections
+	// are accepted after they have all been closed
+	proc.listener.Close()
+	select {
+	case <-proc.listening:
+	case <-proc.stopped:
+		return ErrNotServing
+	}
+	proc.conns.CloseAll()
+
+	select {
+	case proc.calls <- func() { proc.stop = true }:
+	case <-proc.stopped:
+		return ErrNotServing
+	}
+	<-proc.stopped
+	return proc.stopErr
+}
+
+// connections keeps track of the network connections accepted by the
+// server, so that they can be closed when the server shuts down.
+type connections struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newConnections() *connections {
+	return &connections{conns: make(map[net.Conn]struct{})}
+}
+
+// Add a network connection. Returns a connection that is removed
+// when it is closed. If CloseAll has been called, the connection
+// is closed straight away.
+func (cs *connections) Add(rw net.Conn) net.Conn {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		rw.Close()
+	} else {
+		cs.conns[rw] = struct{}{}
+	}
+	return &trackedConn{Conn: rw, conns: cs}
+}
+
+// CloseAll closes all network connections, and any connections
+// added afterwards.
+func (cs *connections) CloseAll() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.closed = true
+	for rw := range cs.conns {
+		rw.Close()
+	}
+}
+
+func (cs *connections) remove(rw net.Conn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.conns, rw)
+}
+
+type trackedConn struct {
+	net.Conn
+	conns *connections
+}
+
+func (tc *trackedConn) Close() error {
+	tc.conns.remove(tc.Conn)
+	return tc.Conn.Close()
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type SlowConsumerSuite struct{}
+
+var _ = Suite(&SlowConsumerSuite{})
+
+// Returns a server with a policy for the topic sent to by
+// sendToSlowConsumer.
+func slowConsumerServer(action SlowConsumerAction) *Server {
+	return &Server{Policies: []DestinationPolicy{
+		{Pattern: "/topic/prices", SlowConsumer: action},
+	}}
+}
+
+// Sends messages to a topic with a subscriber that reads them and a
+// subscriber that does not, and returns the server once all messages
+// have been received by the subscriber that reads them, with the
+// address of the slow consumer.
+func (s *SlowConsumerSuite) sendToSlowConsumer(c *C, serv *Server) (*Server, string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	go serv.Serve(l)
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	slow, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	slow.(*net.TCPConn).SetReadBuffer(4096)
+	_, err = slow.Write([]byte("CONNECT\naccept-version:1.2\n\n\x00" +
+		"SUBSCRIBE\nid:0\ndestination:/topic/prices\n\n\x00"))
+	c.Assert(err, IsNil)
+
+	// the sender has its own connection, so that it is not held up
+	// by a subscription on its connection
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	sender, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/topic/prices", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		subs, err := serv.Subscriptions()
+		c.Assert(err, IsNil)
+		if len(subs) == 2 || time.Now().After(deadline) {
+			c.Assert(subs, HasLen, 2)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the subscriber that reads receives every message, however far
+	// the slow consumer falls behind
+	const count = 500
+	received := make(chan bool)
+	go func() {
+		for i := 0; i < count; i++ {
+			if msg := <-sub.C; msg == nil || msg.Err != nil {
+				received <- false
+				return
+			}
+		}
+		received <- true
+	}()
+	body := []byte(strings.Repeat("x", 16*1024))
+	for i := 0; i < count; i++ {
+		c.Assert(sender.Send("/topic/prices", "text/plain", body), IsNil)
+	}
+	select {
+	case ok := <-received:
+		c.Assert(ok, Equals, true)
+	case <-time.After(10 * time.Second):
+		c.Fatal("messages not received")
+	}
+	return serv, slow.LocalAddr().String(), func() {
+		conn.Disconnect()
+		sender.Disconnect()
+		slow.Close()
+		serv.Shutdown()
+	}
+}
+
+// Polls until cond returns true.
+func waitFor(c *C, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *SlowConsumerSuite) TestDrop(c *C) {
+	serv, addr, stop := s.sendToSlowConsumer(c, slowConsumerServer(SlowConsumerDrop))
+	defer stop()
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 3)
+	for _, info := range conns {
+		c.Check(info.FramesDropped > 0, Equals, info.RemoteAddr == addr)
+	}
+	subs, err := serv.Subscriptions()
+	c.Assert(err, IsNil)
+	c.Check(subs, HasLen, 2)
+}
+
+func (s *SlowConsumerSuite) TestServerDefault(c *C) {
+	// topic subscriptions drop messages, as the matching policy does
+	// not say otherwise
+	serv, addr, stop := s.sendToSlowConsumer(c, &Server{
+		TopicSlowConsumer:   SlowConsumerDrop,
+		QueueSlowConsumer:   SlowConsumerDisconnect,
+		SlowConsumerTimeout: 100 * time.Millisecond,
+		MaxPendingWrites:    4,
+		Policies:            []DestinationPolicy{{Pattern: "/topic/>", MinConsumers: 1}},
+	})
+	defer stop()
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 3)
+	for _, info := range conns {
+		c.Check(info.FramesDropped > 0, Equals, info.RemoteAddr == addr)
+	}
+}
+
+func (s *SlowConsumerSuite) TestUnsubscribe(c *C) {
+	serv, addr, stop := s.sendToSlowConsumer(c, slowConsumerServer(SlowConsumerUnsubscribe))
+	defer stop()
+	waitFor(c, func() bool {
+		subs, err := serv.Subscriptions()
+		c.Assert(err, IsNil)
+		return len(subs) == 1
+	})
+	subs, err := serv.Subscriptions()
+	c.Assert(err, IsNil)
+	c.Assert(subs, HasLen, 1)
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Check(conns, HasLen, 3)
+	for _, info := range conns {
+		if info.RemoteAddr == addr {
+			c.Check(info.Subscriptions, Equals, 0)
+		}
+	}
+}
+
+func (s *SlowConsumerSuite) TestDisconnect(c *C) {
+	serv, addr, stop := s.sendToSlowConsumer(c, slowConsumerServer(SlowConsumerDisconnect))
+	defer stop()
+	waitFor(c, func() bool {
+		conns, err := serv.Connections()
+		c.Assert(err, IsNil)
+		for _, info := range conns {
+			if info.RemoteAddr == addr {
+				return false
+			}
+		}
+		return len(conns) == 2
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Writes the frames in every queue to the snapshot file. The file is
+// replaced atomically, so an existing snapshot is never left partly
+// written.
+func (proc *requestProcessor) saveSnapshot(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".snapshot-")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	writer := frame.NewWriter(w)
+	for _, destination := range proc.qm.Destinations() {
+		err = proc.qm.Find(destination).Iterate(func(f *frame.Frame) bool {
+			err = writer.Write(f)
+			return err == nil
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Adds the frames in the snapshot file to their queues, then removes
+// the file so that the frames are not restored again. Has no effect
+// if the file does not exist.
+func (proc *requestProcessor) restoreSnapshot(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := frame.NewReader(bufio.NewReader(file))
+	var count int
+	for {
+		f, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			continue
+		}
+		destination := f.Header.Get(frame.Destination)
+		if err = proc.qm.Find(destination).Enqueue(f); err != nil {
+			return err
+		}
+		proc.touch(destination)
+		count++
+	}
+
+	proc.log.Infof("restored %d messages from snapshot %s", count, path)
+	return os.Remove(path)
+}
@@ -0,0 +1,189 @@
+/*
+Package sniff shares a listener between protocols, so that STOMP, MQTT
+and HTTP clients (including STOMP over WebSocket) can connect to the
+same port, which simplifies firewall configuration for mixed clients.
+
+The protocol of each connection is detected from the first bytes sent
+by the client, which are then replayed to the server of the protocol.
+All three protocols are started by the client: an MQTT connection
+starts with a CONNECT packet, whose first byte is 0x10, an HTTP
+connection starts with a request line, whose method is followed by a
+space, and a STOMP connection starts with a frame, whose command is
+followed by an end of line.
+*/
+package sniff
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Protocol of a connection.
+type Protocol int
+
+const (
+	STOMP Protocol = iota // STOMP frames, detected if no other protocol is
+	MQTT                  // MQTT packets
+	HTTP                  // HTTP requests, including WebSocket handshakes
+)
+
+// DefaultTimeout is the default time allowed for a client to send
+// enough bytes for its protocol to be detected.
+const DefaultTimeout = 10 * time.Second
+
+// Number of bytes needed to detect the protocol of a connection: the
+// longest HTTP method followed by a space.
+const detectLen = len("OPTIONS ")
+
+// ErrClosed is returned by the Accept method of a protocol's
+// listener once it has been closed.
+var ErrClosed = errors.New("sniff: listener closed")
+
+// Detect returns the protocol of a connection that started with the
+// bytes in b, which should contain at least the first 8 bytes sent by
+// the client, unless the connection sent fewer before pausing.
+func Detect(b []byte) Protocol {
+	if len(b) > 0 && b[0] == 0x10 {
+		return MQTT
+	}
+	for i, c := range b {
+		if c == ' ' && i > 0 {
+			return HTTP
+		}
+		if c < 'A' || c > 'Z' {
+			break
+		}
+	}
+	return STOMP
+}
+
+// A Mux accepts connections from a listener, and passes each to the
+// listener of its protocol. Connections of protocols that have no
+// listener are closed.
+type Mux struct {
+	// Time allowed for a client to send enough bytes for its
+	// protocol to be detected, DefaultTimeout if zero.
+	Timeout time.Duration
+
+	l         net.Listener
+	mutex     sync.Mutex
+	listeners map[Protocol]*listener
+}
+
+// NewMux returns a Mux that accepts connections from l.
+func NewMux(l net.Listener) *Mux {
+	return &Mux{l: l, listeners: make(map[Protocol]*listener)}
+}
+
+// Listener returns a listener for the connections of a protocol. It
+// must be called before Serve.
+func (m *Mux) Listener(p Protocol) net.Listener {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if l, ok := m.listeners[p]; ok {
+		return l
+	}
+	l := &listener{addr: m.l.Addr(), conns: make(chan net.Conn), closed: make(chan struct{})}
+	m.listeners[p] = l
+	return l
+}
+
+// Serve accepts connections until the listener of the Mux fails to
+// accept a connection, which is the case once it has been closed.
+// The listeners of the protocols are then closed, and the error is
+// returned.
+func (m *Mux) Serve() error {
+	defer func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		for _, l := range m.listeners {
+			l.Close()
+		}
+	}()
+	for {
+		conn, err := m.l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// Detects the protocol of a connection, and passes it to the listener
+// of the protocol.
+func (m *Mux) dispatch(conn net.Conn) {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(detectLen)
+	if len(b) == 0 {
+		conn.Close()
+		return
+	}
+	if err != nil {
+		// a client that pauses after sending fewer bytes is detected
+		// from what it has sent
+		b, _ = r.Peek(r.Buffered())
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	m.mutex.Lock()
+	l, ok := m.listeners[Detect(b)]
+	m.mutex.Unlock()
+	if !ok || !l.deliver(&peekedConn{Conn: conn, r: r}) {
+		conn.Close()
+	}
+}
+
+// A connection whose first bytes have been read into a buffer.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// The listener for the connections of a protocol.
+type listener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+// Passes a connection to Accept, returning false if the listener
+// has been closed.
+func (l *listener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,85 @@
+package sniff
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestSniff(t *testing.T) {
+	TestingT(t)
+}
+
+type SniffSuite struct{}
+
+var _ = Suite(&SniffSuite{})
+
+func (s *SniffSuite) TestDetect(c *C) {
+	for _, test := range []struct {
+		start    string
+		protocol Protocol
+	}{
+		{"CONNECT\naccept-version:1.2\n", STOMP},
+		{"STOMP\r\n", STOMP},
+		{"\nCONNECT\n", STOMP},
+		{"\x10\x16\x00\x04MQTT", MQTT},
+		{"GET /stomp HTTP/1.1\r\n", HTTP},
+		{"OPTIONS * HTTP/1.1\r\n", HTTP},
+		{"CONNECT example.com:443 HTTP/1.1\r\n", HTTP},
+		{"", STOMP},
+	} {
+		c.Check(Detect([]byte(test.start)), Equals, test.protocol, Commentf("%q", test.start))
+	}
+}
+
+func (s *SniffSuite) TestMux(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	mux := NewMux(l)
+	stomp := mux.Listener(STOMP)
+	http := mux.Listener(HTTP)
+	c.Check(http.Addr(), Equals, l.Addr())
+	done := make(chan error)
+	go func() { done <- mux.Serve() }()
+
+	// the bytes read to detect the protocol are replayed
+	for _, test := range []struct {
+		start string
+		l     net.Listener
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", http},
+		{"CONNECT\n\n\x00", stomp},
+	} {
+		client, err := net.Dial("tcp", l.Addr().String())
+		c.Assert(err, IsNil)
+		defer client.Close()
+		_, err = client.Write([]byte(test.start))
+		c.Assert(err, IsNil)
+		conn, err := test.l.Accept()
+		c.Assert(err, IsNil)
+		b := make([]byte, len(test.start))
+		_, err = io.ReadFull(conn, b)
+		c.Assert(err, IsNil)
+		c.Check(string(b), Equals, test.start)
+		conn.Close()
+	}
+
+	// connections of a protocol without a listener are closed
+	client, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer client.Close()
+	_, err = client.Write([]byte("\x10\x16\x00\x04MQTT\x04\x02\x00\x3c"))
+	c.Assert(err, IsNil)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	c.Check(err, Equals, io.EOF)
+
+	// closing the listener closes the listeners of the protocols
+	l.Close()
+	c.Check(<-done, NotNil)
+	_, err = stomp.Accept()
+	c.Check(err, Equals, ErrClosed)
+}
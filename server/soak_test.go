@@ -0,0 +1,457 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	. "gopkg.in/check.v1"
+)
+
+// The soak test runs the broker under randomized load for a long period,
+// and is only run when a duration is specified, for example:
+//
+//	go test ./server -timeout 0 -check.f SoakSuite -soak 2h -soak.report soak.txt
+var (
+	soakDuration = flag.Duration("soak", 0, "run the broker soak test for this long")
+	soakSeed     = flag.Int64("soak.seed", 0, "random seed for the soak test, current time if zero")
+	soakReport   = flag.String("soak.report", "", "file to write the soak test report to")
+)
+
+// Header containing the identifier of a message sent by the soak test.
+const soakIdHeader = "soak-id"
+
+const (
+	soakQueues    = 4
+	soakProducers = 4
+	soakConsumers = 8
+)
+
+type SoakSuite struct{}
+
+var _ = Suite(&SoakSuite{})
+
+func (s *SoakSuite) SetUpSuite(c *C) {
+	if *soakDuration <= 0 {
+		c.Skip("soak test disabled, use -soak to enable")
+	}
+}
+
+// soakStorage is a queue storage that can be restarted while the
+// server is running. Frames survive a restart by being written to
+// and read back from a buffer, as a durable storage would.
+type soakStorage struct {
+	queue.Storage
+	queues map[string]struct{}
+}
+
+func newSoakStorage() *soakStorage {
+	return &soakStorage{
+		Storage: queue.NewMemoryQueueStorage(),
+		queues:  make(map[string]struct{}),
+	}
+}
+
+func (s *soakStorage) Enqueue(queue string, f *frame.Frame) error {
+	s.queues[queue] = struct{}{}
+	return s.Storage.Enqueue(queue, f)
+}
+
+func (s *soakStorage) Requeue(queue string, f *frame.Frame) error {
+	s.queues[queue] = struct{}{}
+	return s.Storage.Requeue(queue, f)
+}
+
+// Restart the storage. Must be called on the request processor go-routine.
+func (s *soakStorage) Restart() error {
+	var buf bytes.Buffer
+	writer := frame.NewWriter(&buf)
+	counts := make(map[string]int)
+	for q := range s.queues {
+		for {
+			f, err := s.Storage.Dequeue(q)
+			if err != nil {
+				return err
+			}
+			if f == nil {
+				break
+			}
+			if err = writer.Write(f); err != nil {
+				return err
+			}
+			counts[q]++
+		}
+	}
+
+	s.Storage.Stop()
+	s.Storage.Start()
+
+	reader := frame.NewReader(&buf)
+	for q := range s.queues {
+		for i := 0; i < counts[q]; i++ {
+			f, err := reader.Read()
+			if err != nil {
+				return err
+			}
+			if err = s.Storage.Enqueue(q, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// soakLedger records what happened to every message sent by the soak test.
+type soakLedger struct {
+	mu       sync.Mutex
+	nextId   int
+	sent     map[string]bool // messages the server confirmed
+	aborted  map[string]bool // messages sent in aborted transactions
+	received map[string]int  // number of times each message was received
+
+	sends, commits, aborts, acks, nacks, kills, restarts int
+}
+
+func newSoakLedger() *soakLedger {
+	return &soakLedger{
+		sent:     make(map[string]bool),
+		aborted:  make(map[string]bool),
+		received: make(map[string]int),
+	}
+}
+
+func (l *soakLedger) allocateId() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextId++
+	return strconv.Itoa(l.nextId)
+}
+
+func (l *soakLedger) update(fn func(l *soakLedger)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(l)
+}
+
+func soakDestination(rng *rand.Rand) string {
+	return fmt.Sprintf("/queue/soak.%d", rng.Intn(soakQueues))
+}
+
+// Connects to the server, returning the underlying network connection
+// so that the connection can be killed without a DISCONNECT frame.
+func soakDial(addr string) (*stomp.Conn, net.Conn, error) {
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := stomp.Connect(netConn, stomp.ConnOpt.AcceptVersion(stomp.V11))
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	return conn, netConn, nil
+}
+
+// Sends messages, either individually or in transactions that are
+// randomly committed or aborted.
+func soakProduce(addr string, rng *rand.Rand, ledger *soakLedger, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		conn, _, err := soakDial(addr)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for i := rng.Intn(100); i >= 0; i-- {
+			if rng.Intn(4) == 0 {
+				soakSendTransaction(conn, rng, ledger)
+			} else {
+				id := ledger.allocateId()
+				err = conn.Send(soakDestination(rng), "text/plain", []byte(id),
+					stomp.SendOpt.Receipt, stomp.SendOpt.Header(soakIdHeader, id))
+				if err == nil {
+					ledger.update(func(l *soakLedger) { l.sent[id] = true; l.sends++ })
+				}
+			}
+		}
+		conn.Disconnect()
+	}
+}
+
+func soakSendTransaction(conn *stomp.Conn, rng *rand.Rand, ledger *soakLedger) {
+	tx, err := conn.BeginWithError()
+	if err != nil {
+		return
+	}
+	var ids []string
+	for i := rng.Intn(10); i >= 0; i-- {
+		id := ledger.allocateId()
+		if tx.Send(soakDestination(rng), "text/plain", []byte(id), stomp.SendOpt.Header(soakIdHeader, id)) != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	if rng.Intn(3) == 0 {
+		// the messages must never be delivered, even if the
+		// ABORT frame is not processed
+		ledger.update(func(l *soakLedger) {
+			for _, id := range ids {
+				l.aborted[id] = true
+			}
+			l.aborts++
+		})
+		tx.AbortWithReceipt()
+	} else if tx.CommitWithReceipt() == nil {
+		ledger.update(func(l *soakLedger) {
+			for _, id := range ids {
+				l.sent[id] = true
+			}
+			l.commits++
+		})
+	}
+}
+
+// Receives messages, randomly acknowledging them, requesting redelivery,
+// or killing the connection without acknowledging them.
+func soakConsume(addr string, rng *rand.Rand, ledger *soakLedger, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		conn, netConn, err := soakDial(addr)
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		sub, err := conn.Subscribe(soakDestination(rng), stomp.AckClientIndividual)
+		if err != nil {
+			conn.MustDisconnect()
+			continue
+		}
+
+		killed := false
+	receive:
+		for i := rng.Intn(100); i >= 0; i-- {
+			var msg *stomp.Message
+			select {
+			case msg = <-sub.C:
+			case <-time.After(100 * time.Millisecond):
+				continue
+			}
+			if msg == nil || msg.Err != nil {
+				break
+			}
+			id := msg.Header.Get(soakIdHeader)
+			ledger.update(func(l *soakLedger) { l.received[id]++ })
+
+			switch n := rng.Intn(100); {
+			case n < 90:
+				if conn.Ack(msg) == nil {
+					ledger.update(func(l *soakLedger) { l.acks++ })
+				}
+			case n < 97:
+				if conn.Nack(msg) == nil {
+					ledger.update(func(l *soakLedger) { l.nacks++ })
+				}
+			default:
+				netConn.Close()
+				ledger.update(func(l *soakLedger) { l.kills++ })
+				killed = true
+				break receive
+			}
+		}
+
+		if killed {
+			conn.MustDisconnect()
+		} else {
+			conn.Disconnect()
+		}
+	}
+}
+
+// Restarts the queue storage at random intervals.
+func soakRestart(serv *Server, storage *soakStorage, rng *rand.Rand, ledger *soakLedger, errs chan<- error, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(200+rng.Intn(800)) * time.Millisecond):
+		}
+		err := serv.call(func(proc *requestProcessor) error {
+			return storage.Restart()
+		})
+		if err != nil {
+			errs <- err
+			return
+		}
+		ledger.update(func(l *soakLedger) { l.restarts++ })
+	}
+}
+
+// Receives and acknowledges all remaining messages.
+func soakDrain(c *C, addr string, ledger *soakLedger) {
+	conn, _, err := soakDial(addr)
+	c.Assert(err, IsNil)
+	for i := 0; i < soakQueues; i++ {
+		sub, err := conn.Subscribe(fmt.Sprintf("/queue/soak.%d", i), stomp.AckClientIndividual)
+		c.Assert(err, IsNil)
+		for {
+			var msg *stomp.Message
+			select {
+			case msg = <-sub.C:
+			case <-time.After(time.Second):
+			}
+			if msg == nil {
+				break
+			}
+			c.Assert(msg.Err, IsNil)
+			id := msg.Header.Get(soakIdHeader)
+			ledger.update(func(l *soakLedger) { l.received[id]++ })
+			c.Assert(conn.Ack(msg), IsNil)
+		}
+	}
+	// the DISCONNECT receipt ensures that all ACK frames have been processed
+	c.Assert(conn.Disconnect(), IsNil)
+}
+
+func soakHeapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func (s *SoakSuite) TestSoak(c *C) {
+	initialSeed := *soakSeed
+	if initialSeed == 0 {
+		initialSeed = time.Now().UnixNano()
+	}
+	seed := initialSeed
+
+	l, err := net.Listen("tcp", `127.0.0.1:0`)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	storage := newSoakStorage()
+	serv := &Server{QueueStorage: storage}
+	go serv.Serve(l)
+	addr := l.Addr().String()
+
+	// wait for the server to start before measuring resource usage
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+	goroutines := runtime.NumGoroutine()
+	heap := soakHeapAlloc()
+
+	ledger := newSoakLedger()
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	start := func(fn func(rng *rand.Rand)) {
+		rng := rand.New(rand.NewSource(seed))
+		seed++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(rng)
+		}()
+	}
+	for i := 0; i < soakProducers; i++ {
+		start(func(rng *rand.Rand) { soakProduce(addr, rng, ledger, stop) })
+	}
+	for i := 0; i < soakConsumers; i++ {
+		start(func(rng *rand.Rand) { soakConsume(addr, rng, ledger, stop) })
+	}
+	start(func(rng *rand.Rand) { soakRestart(serv, storage, rng, ledger, errs, stop) })
+
+	select {
+	case <-time.After(*soakDuration):
+	case err = <-errs:
+	}
+	close(stop)
+	wg.Wait()
+	c.Assert(err, IsNil)
+	soakDrain(c, addr, ledger)
+
+	// all connections have closed, so the number of go-routines
+	// should return to what it was before the test
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	finalGoroutines := runtime.NumGoroutine()
+
+	var remaining int
+	err = serv.call(func(proc *requestProcessor) error {
+		for i := 0; i < soakQueues; i++ {
+			remaining += proc.qm.Find(fmt.Sprintf("/queue/soak.%d", i)).Len()
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	var lost, delivered, redelivered, abortedDelivered int
+	for id := range ledger.sent {
+		if ledger.received[id] == 0 {
+			lost++
+		}
+	}
+	for id, count := range ledger.received {
+		if ledger.aborted[id] {
+			abortedDelivered++
+		}
+		delivered++
+		redelivered += count - 1
+	}
+	sent := len(ledger.sent)
+
+	// release the ledger so that only the server's memory is measured
+	ledger.sent, ledger.aborted, ledger.received = nil, nil, nil
+	finalHeap := soakHeapAlloc()
+
+	var report bytes.Buffer
+	fmt.Fprintf(&report, "duration:           %v\n", *soakDuration)
+	fmt.Fprintf(&report, "seed:               %d\n", initialSeed)
+	fmt.Fprintf(&report, "messages sent:      %d (%d sends, %d commits, %d aborts)\n",
+		sent, ledger.sends, ledger.commits, ledger.aborts)
+	fmt.Fprintf(&report, "messages delivered: %d (%d redeliveries)\n", delivered, redelivered)
+	fmt.Fprintf(&report, "acks, nacks, kills: %d, %d, %d\n", ledger.acks, ledger.nacks, ledger.kills)
+	fmt.Fprintf(&report, "store restarts:     %d\n", ledger.restarts)
+	fmt.Fprintf(&report, "lost:               %d\n", lost)
+	fmt.Fprintf(&report, "aborted delivered:  %d\n", abortedDelivered)
+	fmt.Fprintf(&report, "left in queues:     %d\n", remaining)
+	fmt.Fprintf(&report, "go-routines:        %d -> %d\n", goroutines, finalGoroutines)
+	fmt.Fprintf(&report, "heap bytes:         %d -> %d\n", heap, finalHeap)
+	c.Log(report.String())
+	if *soakReport != "" {
+		c.Check(ioutil.WriteFile(*soakReport, report.Bytes(), 0644), IsNil)
+	}
+
+	c.Check(lost, Equals, 0)
+	c.Check(abortedDelivered, Equals, 0)
+	c.Check(remaining, Equals, 0)
+	c.Check(finalGoroutines <= goroutines, Equals, true)
+
+	// the server's bookkeeping for destinations and connections
+	// should not grow with the number of messages
+	c.Check(finalHeap < heap+16<<20, Equals, true)
+}
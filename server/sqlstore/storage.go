@@ -0,0 +1,389 @@
+/*
+Package sqlstore provides persistent queue storage for the STOMP server
+in a PostgreSQL database, using package database/sql.
+
+This allows a deployment to keep its messages in an existing, highly
+available database rather than on local disk. The caller opens the
+database with a PostgreSQL driver of their choice, for example:
+
+	db, err := sql.Open("postgres", "postgres://stomp@dbhost/stomp")
+	...
+	storage, err := sqlstore.Open(sqlstore.Options{DB: db})
+	...
+	server := &server.Server{QueueStorage: storage}
+
+Each frame is a row in a single table, which is created if it does not
+exist. Frames that have been sent to a client but not acknowledged when
+the server stops are returned to the head of their queue when the
+storage is next opened, with the "redelivered:true" header.
+
+Only one server should use the table at a time.
+*/
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Name of the table used if Options.Table is empty.
+const DefaultTable = "stomp_messages"
+
+// Errors returned by storage operations.
+var (
+	ErrClosed       = errors.New("storage is closed")
+	ErrInvalidTable = errors.New("invalid table name")
+)
+
+// Options for opening storage.
+type Options struct {
+	// Database containing the table. It is not closed when
+	// the storage is closed.
+	DB *sql.DB
+
+	// Name of the table, which can be qualified by a schema name.
+	// If empty, DefaultTable is used.
+	Table string
+}
+
+// Table names are included in statements, so only simple names are allowed.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Storage is an implementation of the queue storage interface that
+// keeps frames in a PostgreSQL table. Frames in a queue are ordered by
+// position. Frames added to the tail of a queue take their position
+// from a sequence, and frames added to the head of a queue are given a
+// position before the first frame in the queue.
+//
+// Storage is not safe for concurrent use, which matches the way the
+// server uses queue storage.
+type Storage struct {
+	db       *sql.DB
+	table    string
+	seq      string                 // sequence for the positions of frames added to the tail
+	inflight map[*frame.Frame]int64 // row ids of frames waiting for acknowledgement
+	batch    *sql.Tx                // transaction for the current batch
+	batchErr error                  // first error in the current batch
+	depth    int                    // depth of nested batches
+}
+
+// Executes statements, either directly on the database
+// or in a transaction.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Open the storage in the database specified by opts, creating the
+// table if it does not exist.
+func Open(opts Options) (*Storage, error) {
+	if opts.Table == "" {
+		opts.Table = DefaultTable
+	}
+	if !tableName.MatchString(opts.Table) {
+		return nil, ErrInvalidTable
+	}
+
+	s := &Storage{
+		db:       opts.DB,
+		table:    opts.Table,
+		seq:      opts.Table + "_position",
+		inflight: make(map[*frame.Frame]int64),
+	}
+	if err := s.createTable(); err != nil {
+		return nil, err
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Storage) createTable() error {
+	// positions of frames added to the head of a queue are below
+	// the start of the sequence
+	statements := []string{
+		fmt.Sprintf(`CREATE SEQUENCE IF NOT EXISTS %s START %d`, s.seq, int64(1)<<62),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			queue TEXT NOT NULL,
+			position BIGINT NOT NULL,
+			inflight BOOLEAN NOT NULL DEFAULT FALSE,
+			frame BYTEA NOT NULL
+		)`, s.table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_queue ON %s (queue, inflight, position)`,
+			indexPrefix(s.table), s.table),
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns frames that were not acknowledged before the storage was
+// closed to their queues. They keep their position, which is before
+// the frames that had not been sent to a client.
+func (s *Storage) recover() error {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT id, frame FROM %s WHERE inflight`, s.table))
+	if err != nil {
+		return err
+	}
+	values := make(map[int64][]byte)
+	for rows.Next() {
+		var id int64
+		var value []byte
+		if err = rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		values[id] = value
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for id, value := range values {
+		f := new(frame.Frame)
+		if err := f.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		f.Header.Set(frame.Redelivered, "true")
+		if value, err = f.MarshalBinary(); err != nil {
+			return err
+		}
+		_, err = s.db.Exec(fmt.Sprintf(`UPDATE %s SET inflight = FALSE, frame = $1 WHERE id = $2`, s.table),
+			value, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the storage. A batch that has not ended is rolled back.
+func (s *Storage) Close() error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	var err error
+	if s.batch != nil {
+		err = s.batch.Rollback()
+		s.batch = nil
+	}
+	s.db = nil
+	return err
+}
+
+// Ping checks that the database can be reached.
+func (s *Storage) Ping() error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	return s.db.Ping()
+}
+
+// Enqueue adds a frame to the tail of the queue.
+func (s *Storage) Enqueue(queue string, f *frame.Frame) error {
+	value, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.update(func(q querier) error {
+		_, err := q.Exec(fmt.Sprintf(`INSERT INTO %s (queue, position, frame) VALUES ($1, nextval('%s'), $2)`,
+			s.table, s.seq), queue, value)
+		return err
+	})
+}
+
+// Requeue adds a frame to the head of the queue. If the frame was
+// dequeued from this storage, it is no longer waiting for acknowledgement.
+func (s *Storage) Requeue(queue string, f *frame.Frame) error {
+	value, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	head := fmt.Sprintf(`COALESCE((SELECT MIN(position) FROM %s WHERE queue = $1 AND NOT inflight),
+		(SELECT last_value FROM %s)) - 1`, s.table, s.seq)
+	return s.update(func(q querier) error {
+		id, ok := s.inflight[f]
+		if !ok {
+			_, err := q.Exec(fmt.Sprintf(`INSERT INTO %s (queue, position, frame) VALUES ($1, %s, $2)`,
+				s.table, head), queue, value)
+			return err
+		}
+		_, err := q.Exec(fmt.Sprintf(`UPDATE %s SET inflight = FALSE, position = %s, frame = $2 WHERE id = $3`,
+			s.table, head), queue, value, id)
+		if err == nil {
+			delete(s.inflight, f)
+		}
+		return err
+	})
+}
+
+// Dequeue removes the frame at the head of the queue. The frame is kept
+// until it is acknowledged, so that it can be recovered if the storage
+// is closed first. Returns nil if the queue is empty.
+func (s *Storage) Dequeue(queue string) (*frame.Frame, error) {
+	var f *frame.Frame
+	var id int64
+	err := s.update(func(q querier) error {
+		var value []byte
+		err := q.QueryRow(fmt.Sprintf(`UPDATE %s SET inflight = TRUE WHERE id = (
+			SELECT id FROM %s WHERE queue = $1 AND NOT inflight ORDER BY position LIMIT 1
+		) RETURNING id, frame`, s.table, s.table), queue).Scan(&id, &value)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f = new(frame.Frame)
+		return f.UnmarshalBinary(value)
+	})
+	if err != nil || f == nil {
+		return nil, err
+	}
+	s.inflight[f] = id
+	return f, nil
+}
+
+// Ack removes a frame dequeued from the queue once it has been
+// acknowledged. Frames not dequeued from this storage are ignored.
+func (s *Storage) Ack(queue string, f *frame.Frame) error {
+	id, ok := s.inflight[f]
+	if !ok {
+		return nil
+	}
+	return s.update(func(q querier) error {
+		_, err := q.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, s.table), id)
+		if err == nil {
+			delete(s.inflight, f)
+		}
+		return err
+	})
+}
+
+// Len returns the number of frames in the queue, not including frames
+// that are waiting for acknowledgement.
+func (s *Storage) Len(queue string) int {
+	var n int
+	_ = s.view(func(q querier) error {
+		return q.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE queue = $1 AND NOT inflight`, s.table),
+			queue).Scan(&n)
+	})
+	return n
+}
+
+// Iterate calls fn for each frame in the queue, in order from the
+// head of the queue, until fn returns false.
+func (s *Storage) Iterate(queue string, fn func(f *frame.Frame) bool) error {
+	return s.view(func(q querier) error {
+		rows, err := q.Query(fmt.Sprintf(`SELECT frame FROM %s WHERE queue = $1 AND NOT inflight ORDER BY position`,
+			s.table), queue)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var value []byte
+			if err = rows.Scan(&value); err != nil {
+				return err
+			}
+			f := new(frame.Frame)
+			if err := f.UnmarshalBinary(value); err != nil {
+				return err
+			}
+			if !fn(f) {
+				break
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// Start has no effect, as the storage is ready to use once opened.
+func (s *Storage) Start() {
+}
+
+// Stop closes the storage.
+func (s *Storage) Stop() {
+	_ = s.Close()
+}
+
+// BeginBatch starts a batch of changes, which are made in a single
+// database transaction that is committed by the matching call to
+// EndBatch. If the server crashes first, none of the changes are kept.
+func (s *Storage) BeginBatch() {
+	s.depth++
+}
+
+// EndBatch ends a batch of changes, and commits them if it is
+// the outermost batch. If any change in the batch failed, the
+// batch is rolled back and the first error is returned.
+func (s *Storage) EndBatch() error {
+	if s.depth == 0 {
+		return nil
+	}
+	s.depth--
+	if s.depth > 0 || s.batch == nil {
+		return nil
+	}
+
+	tx, err := s.batch, s.batchErr
+	s.batch, s.batchErr = nil, nil
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) update(fn func(q querier) error) error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	if s.depth == 0 {
+		return fn(s.db)
+	}
+
+	if s.batch == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.batch = tx
+	}
+	if s.batchErr != nil {
+		return s.batchErr
+	}
+	s.batchErr = fn(s.batch)
+	return s.batchErr
+}
+
+func (s *Storage) view(fn func(q querier) error) error {
+	if s.db == nil {
+		return ErrClosed
+	}
+	if s.batch != nil {
+		// include the changes made in the batch so far
+		return fn(s.batch)
+	}
+	return fn(s.db)
+}
+
+// Returns the prefix for the names of indexes on the table. Indexes
+// are created in the schema of their table, so the schema is omitted.
+func indexPrefix(table string) string {
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		return table[i+1:]
+	}
+	return table
+}
@@ -0,0 +1,156 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+	"github.com/go-stomp/stomp/v3/server/queue/queuetest"
+	_ "github.com/lib/pq"
+	. "gopkg.in/check.v1"
+)
+
+// The tests require a PostgreSQL database, and are skipped
+// unless this environment variable contains its URL.
+const databaseEnv = "STOMP_TEST_POSTGRES"
+
+func TestSQLStore(t *testing.T) {
+	TestingT(t)
+}
+
+type StorageSuite struct {
+	db    *sql.DB
+	table string
+}
+
+var _ = Suite(&StorageSuite{})
+
+// Storage implements the queue storage interfaces.
+var (
+	_ queue.BatchStorage = (*Storage)(nil)
+	_ queue.PingStorage  = (*Storage)(nil)
+)
+
+func (s *StorageSuite) SetUpSuite(c *C) {
+	url := os.Getenv(databaseEnv)
+	if url == "" {
+		c.Skip(databaseEnv + " not set")
+	}
+	db, err := sql.Open("postgres", url)
+	c.Assert(err, IsNil)
+	s.db = db
+}
+
+func (s *StorageSuite) TearDownSuite(c *C) {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+func (s *StorageSuite) SetUpTest(c *C) {
+	s.table = fmt.Sprintf("stomp_test_%d", time.Now().UnixNano())
+}
+
+func (s *StorageSuite) TearDownTest(c *C) {
+	_, err := s.db.Exec("DROP TABLE IF EXISTS " + s.table)
+	c.Check(err, IsNil)
+	_, err = s.db.Exec("DROP SEQUENCE IF EXISTS " + s.table + "_position")
+	c.Check(err, IsNil)
+}
+
+func (s *StorageSuite) open(c *C) *Storage {
+	st, err := Open(Options{DB: s.db, Table: s.table})
+	c.Assert(err, IsNil)
+	return st
+}
+
+func (s *StorageSuite) TestInvalidTable(c *C) {
+	_, err := Open(Options{DB: s.db, Table: "messages; DROP TABLE users"})
+	c.Check(err, Equals, ErrInvalidTable)
+}
+
+func (s *StorageSuite) TestOrder(c *C) {
+	st := s.open(c)
+	defer st.Close()
+
+	// requeue to an empty queue
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("1")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(st.Requeue("/queue/test", queuetest.NewFrame("0")), IsNil)
+	c.Assert(st.Enqueue("/queue/other", queuetest.NewFrame("other")), IsNil)
+
+	c.Check(st.Len("/queue/test"), Equals, 4)
+	var bodies []string
+	err := st.Iterate("/queue/test", func(f *frame.Frame) bool {
+		bodies = append(bodies, string(f.Body))
+		return len(bodies) < 3
+	})
+	c.Assert(err, IsNil)
+	c.Check(bodies, DeepEquals, []string{"0", "1", "2"})
+
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "0")
+	c.Check(st.Len("/queue/test"), Equals, 3)
+
+	// a frame that could not be sent goes back to the head
+	c.Assert(st.Requeue("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"0", "1", "2", "3"})
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/other"), DeepEquals, []string{"other"})
+}
+
+func (s *StorageSuite) TestReopen(c *C) {
+	st := s.open(c)
+	for _, body := range []string{"1", "2", "3", "4"} {
+		c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame(body)), IsNil)
+	}
+
+	// acknowledged frames are removed, unacknowledged frames are kept
+	f1, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f2, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(st.Ack("/queue/test", f2), IsNil)
+	c.Check(string(f1.Body), Equals, "1")
+	c.Assert(st.Close(), IsNil)
+	c.Check(st.Close(), Equals, ErrClosed)
+
+	st = s.open(c)
+	defer st.Close()
+	c.Check(st.Len("/queue/test"), Equals, 3)
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Check(string(f.Body), Equals, "1")
+	c.Check(f.Header.Get(frame.Redelivered), Equals, "true")
+	c.Assert(st.Ack("/queue/test", f), IsNil)
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"3", "4"})
+}
+
+func (s *StorageSuite) TestBatch(c *C) {
+	st := s.open(c)
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("1")), IsNil)
+
+	st.BeginBatch()
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("2")), IsNil)
+	st.BeginBatch()
+	f, err := st.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(st.Ack("/queue/test", f), IsNil)
+	c.Assert(st.EndBatch(), IsNil)
+	c.Check(st.Len("/queue/test"), Equals, 1)
+	c.Assert(st.EndBatch(), IsNil)
+
+	// a batch that has not ended when the storage is closed is discarded
+	st.BeginBatch()
+	c.Assert(st.Enqueue("/queue/test", queuetest.NewFrame("3")), IsNil)
+	c.Assert(st.Close(), IsNil)
+
+	st = s.open(c)
+	defer st.Close()
+	c.Check(queuetest.DequeueBodies(c, st, "/queue/test"), DeepEquals, []string{"2"})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/queue"
+)
+
+// A client can query the statistics of a destination with STOMP alone,
+// as with the statistics plugin of ActiveMQ, by sending a message to
+// StatisticsPrefix followed by the name of the destination, with a
+// "reply-to" header. The server sends a message containing the
+// statistics in its header to the reply-to destination. The name is
+// either the name of a queue without the "/queue/" prefix, such as
+// "orders" for "/queue/orders", or a destination starting with "/",
+// such as "/topic/prices". Messages sent to statistics destinations
+// are not stored.
+//
+// The statistics are those of this server only. If destinations are
+// partitioned among a cluster, they should be queried on the server
+// that owns the destination.
+const StatisticsPrefix = QueuePrefix + "/stat.destination."
+
+// Headers in statistics messages. The number of subscriptions is in
+// the ConsumerCountHeader entry. The counts of a queue are of the
+// messages since the queue was created, and are omitted for topics.
+const (
+	// Destination that the statistics are of.
+	StatisticsDestinationHeader = "stat-destination"
+
+	// Number of messages waiting in a queue.
+	QueueSizeHeader = "size"
+
+	// Number of messages sent to a queue.
+	EnqueueCountHeader = "enqueue-count"
+
+	// Number of messages sent from a queue to subscriptions.
+	DequeueCountHeader = "dequeue-count"
+
+	// Number of messages of a queue acknowledged by clients.
+	AckCountHeader = "ack-count"
+
+	// Number of messages of a queue that expired before they were
+	// sent to a subscription.
+	ExpiredCountHeader = "expired-count"
+)
+
+// Answers a message sent to a statistics destination, and reports
+// whether the message was sent to one.
+func (proc *requestProcessor) statistics(f *frame.Frame) bool {
+	name := strings.TrimPrefix(f.Header.Get(frame.Destination), StatisticsPrefix)
+	if len(name) == len(f.Header.Get(frame.Destination)) || name == "" {
+		return false
+	}
+	replyTo := f.Header.Get(stomp.ReplyToHeader)
+	if replyTo == "" {
+		proc.destinationLog(f.Header.Get(frame.Destination)).Debug("discarding statistics request without reply-to")
+		return true
+	}
+
+	destination := name
+	if !strings.HasPrefix(name, "/") {
+		destination = QueuePrefix + "/" + name
+	}
+	reply := frame.New(frame.MESSAGE,
+		frame.Destination, replyTo,
+		StatisticsDestinationHeader, destination,
+		ConsumerCountHeader, strconv.Itoa(proc.subs.Count(destination)))
+	if id, ok := f.Header.Contains(stomp.CorrelationIdHeader); ok {
+		reply.Header.Add(stomp.CorrelationIdHeader, id)
+	}
+	if isQueueDestination(destination) {
+		size := proc.qstore.Len(destination)
+		var stats queue.Stats
+		if q := proc.qm.Lookup(destination); q != nil {
+			stats = q.Stats()
+			size = q.Len()
+		}
+		reply.Header.Add(QueueSizeHeader, strconv.Itoa(size))
+		reply.Header.Add(EnqueueCountHeader, strconv.FormatUint(stats.Enqueued, 10))
+		reply.Header.Add(DequeueCountHeader, strconv.FormatUint(stats.Dispatched, 10))
+		reply.Header.Add(AckCountHeader, strconv.FormatUint(stats.Acked, 10))
+		reply.Header.Add(ExpiredCountHeader, strconv.FormatUint(stats.Expired, 10))
+	}
+
+	if isQueueDestination(replyTo) {
+		proc.touch(replyTo)
+		if err := proc.qm.Find(replyTo).Enqueue(reply); err != nil {
+			proc.destinationLog(replyTo).Errorf("sending statistics failed: %v", err)
+		}
+	} else {
+		proc.tm.Enqueue(replyTo, reply)
+	}
+	return true
+}
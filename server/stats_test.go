@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type StatisticsSuite struct{}
+
+var _ = Suite(&StatisticsSuite{})
+
+func (s *StatisticsSuite) TestStatistics(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	serv := &Server{}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(), stomp.ConnOpt.AcceptVersion(stomp.V11))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	for _, body := range []string{"one", "two", "three"} {
+		c.Assert(conn.Send("/queue/orders", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	}
+	orders, err := conn.Subscribe("/queue/orders", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	receive(c, orders)
+	replies, err := conn.Subscribe("/queue/replies", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	_, err = conn.Subscribe("/topic/prices", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	c.Assert(conn.Send(StatisticsPrefix+"orders", "text/plain", nil, stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(stomp.ReplyToHeader, "/queue/replies"),
+		stomp.SendOpt.Header("correlation-id", "42")), IsNil)
+	msg := receive(c, replies)
+	c.Check(msg.Header.Get(StatisticsDestinationHeader), Equals, "/queue/orders")
+	c.Check(msg.Header.Get("correlation-id"), Equals, "42")
+	c.Check(msg.Header.Get(QueueSizeHeader), Equals, "2")
+	c.Check(msg.Header.Get(EnqueueCountHeader), Equals, "3")
+	c.Check(msg.Header.Get(DequeueCountHeader), Equals, "1")
+	c.Check(msg.Header.Get(AckCountHeader), Equals, "0")
+	c.Check(msg.Header.Get(ExpiredCountHeader), Equals, "0")
+	c.Check(msg.Header.Get(ConsumerCountHeader), Equals, "1")
+
+	// topics have only a consumer count
+	c.Assert(conn.Send(StatisticsPrefix+"/topic/prices", "text/plain", nil, stomp.SendOpt.Receipt,
+		stomp.SendOpt.Header(stomp.ReplyToHeader, "/queue/replies")), IsNil)
+	msg = receive(c, replies)
+	c.Check(msg.Header.Get(StatisticsDestinationHeader), Equals, "/topic/prices")
+	c.Check(msg.Header.Get(ConsumerCountHeader), Equals, "1")
+	_, ok := msg.Header.Contains(QueueSizeHeader)
+	c.Check(ok, Equals, false)
+
+	// statistics requests are not stored
+	size := -1
+	serv.call(func(proc *requestProcessor) error {
+		size = proc.qstore.Len(StatisticsPrefix + "orders")
+		return nil
+	})
+	c.Check(size, Equals, 0)
+}
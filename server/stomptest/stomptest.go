@@ -0,0 +1,159 @@
+/*
+Package stomptest runs STOMP servers for tests of programs that use the
+stomp client package.
+
+NewServer runs a server in memory. Clients connect to it over net.Pipe
+connections, so tests open no TCP ports and need not wait for a
+listener to start.
+
+	srv := stomptest.NewServer(nil)
+	defer srv.Close()
+	conn, err := srv.Dial()
+
+NewTCPServer starts a server on an ephemeral TCP port, which is shut
+down when the test ends:
+
+	srv := stomptest.NewTCPServer(t)
+	conn := srv.Dial()
+*/
+package stomptest
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// ErrClosed is returned by the Accept and Dial methods of a
+// PipeListener once it has been closed.
+var ErrClosed = errors.New("stomptest: listener closed")
+
+// A PipeListener is a net.Listener whose connections are made in
+// memory with net.Pipe. It can be passed to server.Server.Serve, and
+// its Dial method connects to the server.
+type PipeListener struct {
+	conns      chan net.Conn
+	once       sync.Once
+	closed     chan struct{}
+	acceptOnce sync.Once
+	accepting  chan struct{} // closed when Accept is first called
+}
+
+// NewPipeListener returns a PipeListener ready to accept connections.
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+		accepting: make(chan struct{}),
+	}
+}
+
+// Dial returns the client side of a new connection, once the server
+// side has been accepted.
+func (l *PipeListener) Dial() (net.Conn, error) {
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.closed:
+		local.Close()
+		remote.Close()
+		return nil, ErrClosed
+	}
+}
+
+// Accept waits for Dial to be called and returns the server side of
+// the connection.
+func (l *PipeListener) Accept() (net.Conn, error) {
+	l.acceptOnce.Do(func() { close(l.accepting) })
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Close stops the listener from accepting connections. Connections
+// already accepted are not closed.
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr returns the address of the listener, whose network and
+// address are both "pipe".
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// A Server is a STOMP server serving connections of a PipeListener.
+type Server struct {
+	*server.Server
+	Listener *PipeListener
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewServer starts serving srv on a new PipeListener. If srv is nil, a
+// server with the default settings is used.
+func NewServer(srv *server.Server) *Server {
+	if srv == nil {
+		srv = &server.Server{}
+	}
+	s := &Server{
+		Server:   srv,
+		Listener: NewPipeListener(),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		srv.Serve(s.Listener)
+	}()
+	return s
+}
+
+// Dial connects a client to the server, with the connect options opts.
+func (s *Server) Dial(opts ...func(*stomp.Conn) error) (*stomp.Conn, error) {
+	conn, err := s.Listener.Dial()
+	if err != nil {
+		return nil, err
+	}
+	c, err := stomp.Connect(conn, opts...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close shuts the server down, closing every client connection, and
+// waits for it to stop serving. Calls after the first do nothing.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		// the server can be shut down once it accepts connections,
+		// unless it failed to start
+		select {
+		case <-s.Listener.accepting:
+			s.closeErr = s.Server.Shutdown()
+			if s.closeErr == server.ErrNotServing {
+				// already shut down
+				s.closeErr = nil
+			}
+		case <-s.done:
+		}
+		s.Listener.Close()
+		<-s.done
+	})
+	return s.closeErr
+}
@@ -0,0 +1,116 @@
+package stomptest
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestStompTest(t *testing.T) {
+	TestingT(t)
+}
+
+type StompTestSuite struct{}
+
+var _ = Suite(&StompTestSuite{})
+
+func (s *StompTestSuite) TestSendReceive(c *C) {
+	srv := NewServer(nil)
+	defer srv.Close()
+
+	conn, err := srv.Dial()
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/test", stomp.AckAuto)
+	c.Assert(err, IsNil)
+
+	sender, err := srv.Dial()
+	c.Assert(err, IsNil)
+	c.Assert(sender.SendWithReceipt("/queue/test", "text/plain", []byte("hello")), IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "hello")
+
+	c.Assert(sender.Disconnect(), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+}
+
+func (s *StompTestSuite) TestClose(c *C) {
+	srv := NewServer(nil)
+	lost := make(chan error, 1)
+	_, err := srv.Dial(stomp.ConnOpt.OnConnectionLost(func(err error) {
+		lost <- err
+	}))
+	c.Assert(err, IsNil)
+
+	c.Assert(srv.Close(), IsNil)
+	c.Assert(srv.Close(), IsNil)
+
+	// the connection has been closed by the server
+	c.Check(<-lost, NotNil)
+
+	_, err = srv.Dial()
+	c.Check(err, Equals, ErrClosed)
+}
+
+func (s *StompTestSuite) TestCloseBeforeServing(c *C) {
+	for i := 0; i < 10; i++ {
+		c.Assert(NewServer(nil).Close(), IsNil)
+	}
+}
+
+func TestNewTCPServer(t *testing.T) {
+	srv := NewTCPServer(t, server.Opt.Policies(server.DestinationPolicy{
+		Pattern:  "/queue/broadcast",
+		Dispatch: server.DispatchBroadcast,
+	}))
+	if srv.Addr == "" || strings.HasSuffix(srv.Addr, ":0") {
+		t.Fatalf("address %q", srv.Addr)
+	}
+
+	consumer := srv.Dial()
+	sub1, err := consumer.Subscribe("/queue/broadcast", stomp.AckAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub2, err := consumer.Subscribe("/queue/broadcast", stomp.AckAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Send("/queue/sync", "text/plain", nil, stomp.SendOpt.Receipt); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Dial().Send("/queue/broadcast", "text/plain", []byte("hello"), stomp.SendOpt.Receipt); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []*stomp.Subscription{sub1, sub2} {
+		msg := <-sub.C
+		if msg.Err != nil || string(msg.Body) != "hello" {
+			t.Fatalf("received %v", msg)
+		}
+	}
+}
+
+func TestNewTCPServerShutdown(t *testing.T) {
+	var addr string
+	t.Run("server", func(t *testing.T) {
+		srv := NewTCPServer(t, server.Opt.Authenticator(authenticator{}))
+		addr = srv.Addr
+		srv.Dial(stomp.ConnOpt.Login("user", "secret"))
+	})
+	// the server has been shut down at the end of the subtest
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Errorf("server at %s still listening", addr)
+	}
+}
+
+type authenticator struct{}
+
+func (authenticator) Authenticate(login, passcode string) bool {
+	return login == "user" && passcode == "secret"
+}
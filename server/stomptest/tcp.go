@@ -0,0 +1,59 @@
+package stomptest
+
+import (
+	"testing"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// A TCPServer is a STOMP server listening on an ephemeral TCP port,
+// started by NewTCPServer.
+type TCPServer struct {
+	Server *server.Server
+	Addr   string // address of the first listener, such as "127.0.0.1:41234"
+
+	t testing.TB
+}
+
+// NewTCPServer starts a server configured by the options in opts, such as
+// server.Opt.Authenticator, for the test t. Unless opts add listeners,
+// the server listens for STOMP clients on an ephemeral port of the
+// loopback interface. NewTCPServer returns once the server accepts
+// CONNECT frames, and fails the test if the server cannot start. The
+// server is shut down when the test and its subtests have completed.
+func NewTCPServer(t testing.TB, opts ...func(*server.Server) error) *TCPServer {
+	t.Helper()
+	srv, err := server.New(opts...)
+	if err != nil {
+		t.Fatalf("stomptest: %v", err)
+	}
+	if srv.Addr == "" {
+		// used by Start if opts add no listeners
+		srv.Addr = "127.0.0.1:0"
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("stomptest: failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Shutdown(); err != nil && err != server.ErrNotServing {
+			t.Errorf("stomptest: failed to shut down server: %v", err)
+		}
+	})
+	return &TCPServer{Server: srv, Addr: srv.Addrs()[0].String(), t: t}
+}
+
+// Dial connects a client to the server, with the connect options opts,
+// and fails the test if it cannot connect. The client is disconnected
+// when the test has completed, before the server is shut down.
+func (s *TCPServer) Dial(opts ...func(*stomp.Conn) error) *stomp.Conn {
+	s.t.Helper()
+	conn, err := stomp.Dial("tcp", s.Addr, opts...)
+	if err != nil {
+		s.t.Fatalf("stomptest: failed to connect to %s: %v", s.Addr, err)
+	}
+	s.t.Cleanup(func() {
+		conn.Disconnect()
+	})
+	return conn
+}
@@ -0,0 +1,68 @@
+package topic
+
+import (
+	"sync"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Number of messages that can wait for each fanout worker before the
+// sender waits for room.
+const fanoutBacklog = 256
+
+// A fanout passes the messages sent to topics to their subscriptions
+// from a pool of worker goroutines, so that a subscription whose client
+// is slow to read its messages holds up only the subscriptions of its
+// own worker. Each subscription is assigned to one worker, so that it
+// receives messages in the order that they were sent.
+type fanout struct {
+	workers []chan delivery
+	next    int // worker assigned to the next subscription
+	wg      sync.WaitGroup
+}
+
+// A message to be passed to a subscription by a fanout worker.
+type delivery struct {
+	sub Subscription
+	f   *frame.Frame
+}
+
+// Starts a fanout with a number of workers.
+func newFanout(workers int) *fanout {
+	fo := &fanout{workers: make([]chan delivery, workers)}
+	for i := range fo.workers {
+		fo.workers[i] = make(chan delivery, fanoutBacklog)
+		fo.wg.Add(1)
+		go fo.run(fo.workers[i])
+	}
+	return fo
+}
+
+// Returns the worker for a new subscription, assigning workers in turn.
+func (fo *fanout) assign() int {
+	worker := fo.next
+	fo.next = (fo.next + 1) % len(fo.workers)
+	return worker
+}
+
+// Passes a message to a worker to be sent to a subscription. Waits if
+// the worker has fanoutBacklog messages waiting already.
+func (fo *fanout) send(worker int, sub Subscription, f *frame.Frame) {
+	fo.workers[worker] <- delivery{sub, f}
+}
+
+// Stops the workers once they have passed on the messages waiting for
+// them.
+func (fo *fanout) stop() {
+	for _, ch := range fo.workers {
+		close(ch)
+	}
+	fo.wg.Wait()
+}
+
+func (fo *fanout) run(ch <-chan delivery) {
+	defer fo.wg.Done()
+	for d := range ch {
+		d.sub.SendTopicFrame(d.f)
+	}
+}
@@ -0,0 +1,85 @@
+package topic
+
+import (
+	"container/list"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Retention settings for the messages retained by a topic, so that
+// new subscriptions can replay them before receiving live messages.
+// A topic retains messages if either setting is non-zero.
+type Retention struct {
+	// Maximum number of messages retained. If zero, the number
+	// of messages is not limited.
+	MaxMessages int
+
+	// Maximum time that a message is retained. If zero,
+	// messages are retained regardless of their age.
+	MaxAge time.Duration
+}
+
+// Reports whether messages are retained.
+func (r Retention) enabled() bool {
+	return r.MaxMessages > 0 || r.MaxAge > 0
+}
+
+// A log of the messages sent to a topic, in order of their sequence
+// numbers. Sequence numbers start at 1, and are not reused.
+type messageLog struct {
+	retention Retention
+	entries   *list.List // of *logEntry
+	next      uint64     // sequence number of the next message
+}
+
+type logEntry struct {
+	seq   uint64
+	added time.Time
+	frame *frame.Frame
+}
+
+func newMessageLog(retention Retention) *messageLog {
+	return &messageLog{
+		retention: retention,
+		entries:   list.New(),
+		next:      1,
+	}
+}
+
+// Adds a message to the log, setting its "seq" header to its sequence
+// number so that a subscriber can resume after it. The log keeps a
+// copy of the message, so the message can still be sent.
+func (l *messageLog) append(f *frame.Frame, now time.Time) {
+	f.Header.Set(stomp.SequenceHeader, strconv.FormatUint(l.next, 10))
+	l.entries.PushBack(&logEntry{seq: l.next, added: now, frame: f.Clone()})
+	l.next++
+	l.trim(now)
+}
+
+// Discards messages that exceed the retention settings.
+func (l *messageLog) trim(now time.Time) {
+	for e := l.entries.Front(); e != nil; e = l.entries.Front() {
+		entry := e.Value.(*logEntry)
+		tooMany := l.retention.MaxMessages > 0 && l.entries.Len() > l.retention.MaxMessages
+		tooOld := l.retention.MaxAge > 0 && now.Sub(entry.added) > l.retention.MaxAge
+		if !tooMany && !tooOld {
+			return
+		}
+		l.entries.Remove(e)
+	}
+}
+
+// Calls fn with a copy of each retained message that has a sequence
+// number of at least fromSeq and was added no earlier than fromTime.
+func (l *messageLog) replay(fromSeq uint64, fromTime time.Time, fn func(f *frame.Frame)) {
+	l.trim(time.Now())
+	for e := l.entries.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*logEntry)
+		if entry.seq >= fromSeq && !entry.added.Before(fromTime) {
+			fn(entry.frame.Clone())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package topic
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type LogSuite struct{}
+
+var _ = Suite(&LogSuite{})
+
+func newLogFrame(body string) *frame.Frame {
+	f := frame.New(frame.MESSAGE, frame.Destination, "/topic/log")
+	f.Body = []byte(body)
+	return f
+}
+
+func replayBodies(t *Topic, fromSeq uint64, fromTime time.Time) []string {
+	sub := &fakeSubscription{}
+	t.Replay(sub, fromSeq, fromTime)
+	var bodies []string
+	for _, f := range sub.Frames {
+		bodies = append(bodies, string(f.Body)+":"+f.Header.Get(stomp.SequenceHeader))
+	}
+	return bodies
+}
+
+func (s *LogSuite) TestMaxMessages(c *C) {
+	mgr := NewManager()
+	mgr.SetRetention(func(destination string) Retention {
+		return Retention{MaxMessages: 2}
+	})
+
+	// the topic is created to retain messages without subscriptions
+	for _, body := range []string{"a", "b", "c"} {
+		mgr.Enqueue("/topic/log", newLogFrame(body))
+	}
+	t := mgr.Find("/topic/log")
+	c.Check(t.Retained(), Equals, 2)
+	c.Check(replayBodies(t, 0, time.Time{}), DeepEquals, []string{"b:2", "c:3"})
+	c.Check(replayBodies(t, 3, time.Time{}), DeepEquals, []string{"c:3"})
+	c.Check(replayBodies(t, 4, time.Time{}), HasLen, 0)
+
+	// live subscribers see the sequence number too
+	sub := &fakeSubscription{}
+	t.Subscribe(sub)
+	mgr.Enqueue("/topic/log", newLogFrame("d"))
+	c.Assert(sub.Frames, HasLen, 1)
+	c.Check(sub.Frames[0].Header.Get(stomp.SequenceHeader), Equals, "4")
+
+	// wildcard topics do not retain messages
+	c.Check(mgr.Find("/topic/*").Retained(), Equals, 0)
+}
+
+func (s *LogSuite) TestMaxAge(c *C) {
+	l := newMessageLog(Retention{MaxAge: time.Minute})
+	now := time.Now()
+	l.append(newLogFrame("old"), now.Add(-2*time.Minute))
+	l.append(newLogFrame("recent"), now.Add(-30*time.Second))
+	l.append(newLogFrame("new"), now)
+
+	t := newTopic("/topic/log")
+	t.log = l
+	c.Check(t.Retained(), Equals, 2)
+	c.Check(replayBodies(t, 0, time.Time{}), DeepEquals, []string{"recent:2", "new:3"})
+	c.Check(replayBodies(t, 0, now.Add(-time.Second)), DeepEquals, []string{"new:3"})
+}
+
+func (s *LogSuite) TestNoRetention(c *C) {
+	mgr := NewManager()
+	mgr.Enqueue("/topic/log", newLogFrame("a"))
+	t := mgr.Find("/topic/log")
+	c.Check(t.Retained(), Equals, 0)
+	c.Check(replayBodies(t, 0, time.Time{}), HasLen, 0)
+}
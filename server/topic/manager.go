@@ -1,24 +1,148 @@
 package topic
 
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/wildcard"
+)
+
 // Manager is a struct responsible for finding topics. Topics are
 // not created by the package user, rather they are created on demand
 // by the topic manager.
+//
+// A topic whose destination contains wildcard segments receives the
+// messages sent to every destination that matches it. See package
+// wildcard for the pattern syntax.
 type Manager struct {
-	topics map[string]*Topic
+	topics    map[string]*Topic
+	patterns  *wildcard.Index // topics with wildcard destinations
+	retention func(destination string) Retention
+	fanout    *fanout // nil unless messages are sent by fanout workers
 }
 
 // NewManager creates a new topic manager.
 func NewManager() *Manager {
-	tm := &Manager{topics: make(map[string]*Topic)}
+	tm := &Manager{
+		topics:   make(map[string]*Topic),
+		patterns: wildcard.NewIndex(),
+	}
 	return tm
 }
 
+// SetRetention sets a function that returns the retention settings for
+// a topic when the topic is created. Topics with wildcard destinations
+// never retain messages, as messages are not sent to them directly.
+func (tm *Manager) SetRetention(fn func(destination string) Retention) {
+	tm.retention = fn
+}
+
+// UpdateRetention applies the retention settings returned by the
+// function set with SetRetention to the existing topics. Topics that
+// no longer retain messages discard the messages that they retained.
+func (tm *Manager) UpdateRetention() {
+	now := time.Now()
+	for destination, t := range tm.topics {
+		if wildcard.IsPattern(destination) {
+			continue
+		}
+		switch r := tm.retentionOf(destination); {
+		case !r.enabled():
+			t.log = nil
+		case t.log == nil:
+			t.log = newMessageLog(r)
+		default:
+			t.log.retention = r
+			t.log.trim(now)
+		}
+	}
+}
+
+// SetFanoutWorkers starts a number of worker goroutines that pass the
+// messages sent to topics to their subscriptions, instead of Enqueue
+// passing them to one subscription after another. Each subscription is
+// assigned to one worker, so a subscription that is slow to accept a
+// message holds up only the other subscriptions of its worker, and
+// every subscription receives messages in the order that they were
+// sent. Must be called before any topic is created, and the workers
+// must be stopped with Close.
+func (tm *Manager) SetFanoutWorkers(workers int) {
+	if workers > 0 {
+		tm.fanout = newFanout(workers)
+	}
+}
+
+// Close stops the fanout workers, if any, once they have passed on the
+// messages waiting for them. Messages must not be sent to topics
+// afterwards.
+func (tm *Manager) Close() {
+	if tm.fanout != nil {
+		tm.fanout.stop()
+	}
+}
+
 // Finds the topic for the given destination, and creates it if necessary.
 func (tm *Manager) Find(destination string) *Topic {
 	t, ok := tm.topics[destination]
 	if !ok {
 		t = newTopic(destination)
+		t.fanout = tm.fanout
 		tm.topics[destination] = t
+		if wildcard.IsPattern(destination) {
+			tm.patterns.Add(destination, t)
+		} else if r := tm.retentionOf(destination); r.enabled() {
+			t.log = newMessageLog(r)
+		}
 	}
 	return t
 }
+
+func (tm *Manager) retentionOf(destination string) Retention {
+	if tm.retention == nil {
+		return Retention{}
+	}
+	return tm.retention(destination)
+}
+
+// Remove the topic for the given destination.
+func (tm *Manager) Remove(destination string) {
+	t, ok := tm.topics[destination]
+	if !ok {
+		return
+	}
+	delete(tm.topics, destination)
+	if wildcard.IsPattern(destination) {
+		tm.patterns.Remove(destination, t)
+	}
+}
+
+// Enqueue sends a message to the topic for the destination, and to
+// every wildcard topic that matches the destination. Each subscription
+// receives one copy of the message. If the topic for the destination
+// retains messages, it is created if necessary and the message is
+// added to its log.
+func (tm *Manager) Enqueue(destination string, f *frame.Frame) {
+	var subs []subscriber
+	if !wildcard.IsPattern(destination) {
+		// wildcard topics are found via the index below
+		t, ok := tm.topics[destination]
+		if !ok && tm.retentionOf(destination).enabled() {
+			t, ok = tm.Find(destination), true
+		}
+		if ok {
+			t.retain(f)
+			subs = t.subscribers()
+		}
+	}
+	tm.patterns.Match(destination, func(value interface{}) {
+		matched := value.(*Topic).subscribers()
+		if len(subs) == 0 {
+			subs = matched
+		} else {
+			// the full slice expression makes append copy, rather
+			// than modify the subscriptions of a topic
+			subs = append(subs[:len(subs):len(subs)], matched...)
+		}
+	})
+	broadcast(tm.fanout, subs, f)
+}
@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"github.com/go-stomp/stomp/v3/frame"
 	. "gopkg.in/check.v1"
 )
 
@@ -19,3 +20,58 @@ func (s *ManagerSuite) TestManager(c *C) {
 
 	c.Assert(mgr.Find("topic1"), Equals, t1)
 }
+
+func (s *ManagerSuite) TestEnqueueWildcard(c *C) {
+	mgr := NewManager()
+
+	exact := &fakeSubscription{}
+	single := &fakeSubscription{}
+	rest := &fakeSubscription{}
+	other := &fakeSubscription{}
+	mgr.Find("/topic/prices.eu").Subscribe(exact)
+	mgr.Find("/topic/prices.*").Subscribe(single)
+	mgr.Find("/topic/prices.>").Subscribe(rest)
+	mgr.Find("/topic/orders.>").Subscribe(other)
+
+	f := frame.New(frame.MESSAGE, frame.Destination, "/topic/prices.eu")
+	mgr.Enqueue("/topic/prices.eu", f)
+	c.Check(exact.Frames, HasLen, 1)
+	c.Check(single.Frames, HasLen, 1)
+	c.Check(rest.Frames, HasLen, 1)
+	c.Check(other.Frames, HasLen, 0)
+
+	mgr.Enqueue("/topic/prices.eu.de", frame.New(frame.MESSAGE))
+	c.Check(exact.Frames, HasLen, 1)
+	c.Check(single.Frames, HasLen, 1)
+	c.Check(rest.Frames, HasLen, 2)
+
+	// the exact topic does not need to exist
+	mgr.Enqueue("/topic/prices.us", frame.New(frame.MESSAGE))
+	c.Check(single.Frames, HasLen, 2)
+	c.Check(rest.Frames, HasLen, 3)
+}
+
+func (s *ManagerSuite) TestEnqueueWildcardOnce(c *C) {
+	mgr := NewManager()
+
+	// a message sent to the pattern's own name is
+	// delivered once to the pattern's subscribers
+	sub := &fakeSubscription{}
+	mgr.Find("/topic/a.*").Subscribe(sub)
+	mgr.Enqueue("/topic/a.*", frame.New(frame.MESSAGE))
+	c.Check(sub.Frames, HasLen, 1)
+}
+
+func (s *ManagerSuite) TestRemove(c *C) {
+	mgr := NewManager()
+
+	sub := &fakeSubscription{}
+	t1 := mgr.Find("/topic/a.>")
+	t1.Subscribe(sub)
+	mgr.Remove("/topic/a.>")
+	mgr.Remove("/topic/unknown")
+
+	mgr.Enqueue("/topic/a.b", frame.New(frame.MESSAGE))
+	c.Check(sub.Frames, HasLen, 0)
+	c.Check(mgr.Find("/topic/a.>") == t1, Equals, false)
+}
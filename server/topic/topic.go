@@ -4,7 +4,8 @@ Package topic provides implementations of server-side topics.
 package topic
 
 import (
-	"container/list"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-stomp/stomp/v3/frame"
 )
@@ -12,31 +13,53 @@ import (
 // A Topic is used for broadcasting to subscribed clients.
 // In contrast to a queue, when a message is sent to a topic,
 // that message is transmitted to all subscribed clients.
+//
+// The subscriptions of a topic are kept in a slice that is copied
+// whenever a subscription is added or removed, and never modified, so
+// that sending a message reads the subscriptions without locking or
+// copying them. Enqueue may be called concurrently with Subscribe and
+// Unsubscribe, which must not be called concurrently with each other.
 type Topic struct {
 	destination string
-	subs        *list.List
+	subs        atomic.Value // []subscriber, replaced and never modified
+	log         *messageLog  // nil unless the topic retains messages
+	fanout      *fanout      // nil unless messages are sent by fanout workers
+}
+
+// A subscriber is a subscription to a topic, with the fanout worker
+// that sends messages to it.
+type subscriber struct {
+	sub    Subscription
+	worker int
 }
 
 // Create a new topic -- called from the topic manager only.
 func newTopic(destination string) *Topic {
-	return &Topic{
-		destination: destination,
-		subs:        list.New(),
-	}
+	t := &Topic{destination: destination}
+	t.subs.Store([]subscriber(nil))
+	return t
 }
 
 // Subscribe adds a subscription to a topic. Any message sent to the
 // topic will be transmitted to the subscription's client until
 // unsubscription occurs.
 func (t *Topic) Subscribe(sub Subscription) {
-	t.subs.PushBack(sub)
+	s := subscriber{sub: sub}
+	if t.fanout != nil {
+		s.worker = t.fanout.assign()
+	}
+	subs := t.subscribers()
+	t.subs.Store(append(subs[:len(subs):len(subs)], s))
 }
 
 // Unsubscribe causes a subscription to be removed from the topic.
 func (t *Topic) Unsubscribe(sub Subscription) {
-	for e := t.subs.Front(); e != nil; e = e.Next() {
-		if sub == e.Value.(Subscription) {
-			t.subs.Remove(e)
+	subs := t.subscribers()
+	for i, s := range subs {
+		if sub == s.sub {
+			remaining := make([]subscriber, 0, len(subs)-1)
+			remaining = append(remaining, subs[:i]...)
+			t.subs.Store(append(remaining, subs[i+1:]...))
 			return
 		}
 	}
@@ -45,29 +68,56 @@ func (t *Topic) Unsubscribe(sub Subscription) {
 // Enqueue send a message to the topic. All subscriptions receive a copy
 // of the message.
 func (t *Topic) Enqueue(f *frame.Frame) {
-	switch t.subs.Len() {
-	case 0:
-	// no subscription, so do nothing
+	t.retain(f)
+	broadcast(t.fanout, t.subscribers(), f)
+}
+
+// Replay sends the messages retained by the topic to a subscription,
+// starting from the message with sequence number fromSeq, and skipping
+// messages sent before fromTime. Has no effect if the topic does not
+// retain messages.
+func (t *Topic) Replay(sub Subscription, fromSeq uint64, fromTime time.Time) {
+	if t.log != nil {
+		t.log.replay(fromSeq, fromTime, sub.SendTopicFrame)
+	}
+}
 
-	case 1:
-		// only one subscription, so can send the frame
-		// without copying
-		sub := t.subs.Front().Value.(Subscription)
-		sub.SendTopicFrame(f)
+// Retained returns the number of messages retained by the topic.
+func (t *Topic) Retained() int {
+	if t.log == nil {
+		return 0
+	}
+	t.log.trim(time.Now())
+	return t.log.entries.Len()
+}
+
+// Adds a message to the log, if the topic retains messages.
+func (t *Topic) retain(f *frame.Frame) {
+	if t.log != nil {
+		t.log.append(f, time.Now())
+	}
+}
+
+// Returns the topic's subscriptions. The slice must not be modified.
+func (t *Topic) subscribers() []subscriber {
+	return t.subs.Load().([]subscriber)
+}
 
-	default:
-		// more than one subscription, send clone for
-		// all subscriptions except the last, which can
-		// have the frame without copying
-		for e := t.subs.Front(); e != nil; e = e.Next() {
-			sub := e.Value.(Subscription)
-			if e.Next() == nil {
-				// the last in the list, send the frame
-				// without copying
-				sub.SendTopicFrame(f)
-			} else {
-				sub.SendTopicFrame(f.Clone())
-			}
+// Sends a message to each of the subscriptions, through their fanout
+// workers if fo is not nil. All subscriptions except the last receive
+// a clone, and the last receives the frame without copying. The clones
+// are made before any subscription is passed a frame, which it may
+// modify.
+func broadcast(fo *fanout, subs []subscriber, f *frame.Frame) {
+	for i, s := range subs {
+		cf := f
+		if i < len(subs)-1 {
+			cf = f.Clone()
+		}
+		if fo == nil {
+			s.sub.SendTopicFrame(cf)
+		} else {
+			fo.send(s.worker, s.sub, cf)
 		}
 	}
 }
@@ -1,6 +1,8 @@
 package topic
 
 import (
+	"sync/atomic"
+
 	"github.com/go-stomp/stomp/v3/frame"
 	. "gopkg.in/check.v1"
 )
@@ -53,6 +55,56 @@ func (s *TopicSuite) TestTopicWithTwoSubscriptions(c *C) {
 	c.Assert(sub2.Frames[0], Equals, f)
 }
 
+func (s *TopicSuite) TestSubscriptionsCopiedOnWrite(c *C) {
+	sub1 := &fakeSubscription{}
+	sub2 := &fakeSubscription{}
+
+	topic := newTopic("destination")
+	topic.Subscribe(sub1)
+	subs := topic.subscribers()
+	topic.Subscribe(sub2)
+	topic.Unsubscribe(sub1)
+
+	// a message being sent is not affected by later changes
+	c.Assert(subs, HasLen, 1)
+	c.Check(subs[0].sub, Equals, sub1)
+	c.Assert(topic.subscribers(), HasLen, 1)
+	c.Check(topic.subscribers()[0].sub, Equals, sub2)
+}
+
+func (s *TopicSuite) TestEnqueueWhileSubscribing(c *C) {
+	topic := newTopic("destination")
+	var received uint64
+	counter := countingSubscription{&received}
+	topic.Subscribe(counter)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			topic.Enqueue(frame.New(frame.MESSAGE, frame.Destination, "destination"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		sub := &fakeSubscription{}
+		topic.Subscribe(sub)
+		topic.Unsubscribe(sub)
+	}
+	<-done
+
+	// the subscription that stayed received every message
+	c.Check(atomic.LoadUint64(&received), Equals, uint64(1000))
+}
+
+// A subscription that counts the frames sent to it.
+type countingSubscription struct {
+	count *uint64
+}
+
+func (s countingSubscription) SendTopicFrame(f *frame.Frame) {
+	atomic.AddUint64(s.count, 1)
+}
+
 type fakeSubscription struct {
 	// frames received by the subscription
 	Frames []*frame.Frame
@@ -61,3 +113,35 @@ type fakeSubscription struct {
 func (s *fakeSubscription) SendTopicFrame(f *frame.Frame) {
 	s.Frames = append(s.Frames, f)
 }
+
+// A subscription that passes the frames sent to it to a channel.
+type chanSubscription chan *frame.Frame
+
+func (ch chanSubscription) SendTopicFrame(f *frame.Frame) {
+	ch <- f
+}
+
+func (s *TopicSuite) TestFanout(c *C) {
+	mgr := NewManager()
+	mgr.SetFanoutWorkers(2)
+	topic := mgr.Find("/topic/fanout")
+
+	// the subscriptions are assigned different workers, and the
+	// blocked subscription does not hold up the other one
+	blocked := make(chanSubscription)
+	ready := make(chanSubscription, 3)
+	topic.Subscribe(blocked)
+	topic.Subscribe(ready)
+	for _, body := range []string{"one", "two", "three"} {
+		f := frame.New(frame.MESSAGE, frame.Destination, "/topic/fanout")
+		f.Body = []byte(body)
+		mgr.Enqueue("/topic/fanout", f)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		c.Check(string((<-ready).Body), Equals, body)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		c.Check(string((<-blocked).Body), Equals, body)
+	}
+	mgr.Close()
+}
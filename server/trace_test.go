@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type TraceSuite struct{}
+
+var _ = Suite(&TraceSuite{})
+
+func (s *TraceSuite) TestMessageFlow(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	tracer := &testTracer{}
+	serv := &Server{Tracer: tracer}
+	go serv.Serve(l)
+	defer serv.Shutdown()
+	for serv.call(func(*requestProcessor) error { return nil }) == ErrNotServing {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.AcceptVersion(stomp.V11), stomp.ConnOpt.Tracer(tracer))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	sub, err := conn.Subscribe("/queue/work", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Send("/queue/work", "text/plain", []byte("one"), stomp.SendOpt.Receipt), IsNil)
+	c.Assert(conn.Ack(receive(c, sub)), IsNil)
+
+	// each span is a child of the one before it
+	names := []string{"send", "enqueue", "dispatch", "receive", "ack", "ack"}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tracer.ended()) < len(names) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := tracer.ended()
+	c.Assert(spans, HasLen, len(names))
+	for i, span := range spans {
+		c.Check(span.name, Equals, names[i])
+		c.Check(span.destination, Equals, "/queue/work")
+		if i == 0 {
+			c.Check(span.parent.IsValid(), Equals, false)
+		} else {
+			c.Check(span.parent, Equals, spans[i-1].sc, Commentf("%s", span.name))
+		}
+	}
+}
+
+// A tracer that records the spans that it starts.
+type testTracer struct {
+	mutex sync.Mutex
+	spans []*testSpan
+	n     byte
+}
+
+type testSpan struct {
+	t           *testTracer
+	name        string
+	destination string
+	parent, sc  stomp.SpanContext
+	ended       bool
+}
+
+func (t *testTracer) Start(name string, parent stomp.SpanContext, attributes map[string]string) stomp.Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.n++
+	span := &testSpan{t: t, name: name, destination: attributes[stomp.MessagingDestinationAttribute], parent: parent, sc: parent}
+	if !parent.IsValid() {
+		span.sc.TraceID[0] = t.n
+	}
+	span.sc.SpanID[0] = t.n
+	t.spans = append(t.spans, span)
+	return span
+}
+
+// Returns the spans that have ended, in the order they were started.
+func (t *testTracer) ended() []testSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var spans []testSpan
+	for _, span := range t.spans {
+		if span.ended {
+			spans = append(spans, *span)
+		}
+	}
+	return spans
+}
+
+func (s *testSpan) SpanContext() stomp.SpanContext { return s.sc }
+
+func (s *testSpan) End(err error) {
+	s.t.mutex.Lock()
+	defer s.t.mutex.Unlock()
+	s.ended = true
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"runtime/debug"
+)
+
+// Path of the module that the server is part of.
+const modulePath = "github.com/go-stomp/stomp/v3"
+
+// Version is the version of the module that the server was built from,
+// such as "v3.1.0", as recorded in the build information of the program.
+// It is "devel" if the program was built from a working copy of the
+// module, or without module support.
+var Version = moduleVersion()
+
+// DefaultName is the name of the server in the server header entry of
+// CONNECTED frames, unless Server.Name is set.
+const DefaultName = "go-stomp"
+
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	version := ""
+	if info.Main.Path == modulePath {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+	if version == "" || version == "(devel)" {
+		return "devel"
+	}
+	return version
+}
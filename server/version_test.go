@@ -0,0 +1,46 @@
+package server
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type VersionSuite struct{}
+
+var _ = Suite(&VersionSuite{})
+
+func (s *VersionSuite) TestNegotiated(c *C) {
+	serv := &Server{HeartBeat: 50 * time.Millisecond, MaxFrameSize: 1 << 20}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	conn, err := stomp.Dial("tcp", l.Addr().String(),
+		stomp.ConnOpt.HeartBeat(time.Second, 100*time.Millisecond))
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	negotiated := conn.Negotiated()
+	c.Check(negotiated.Version, Equals, stomp.V12)
+	c.Check(negotiated.Server, Equals, DefaultName+"/"+Version)
+	c.Check(negotiated.Capabilities, Equals, stomp.Capabilities{Wildcards: true, MaxFrameSize: 1 << 20})
+	c.Check(negotiated.SendHeartBeat, Equals, time.Second)
+	c.Check(negotiated.ReceiveHeartBeat, Equals, 100*time.Millisecond)
+
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	c.Check(negotiated.Session, Equals, conns[0].Id)
+}
+
+func (s *VersionSuite) TestName(c *C) {
+	serv := &Server{Name: "broker"}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	c.Check(conn.Server(), Equals, "broker/"+Version)
+	c.Check(Version, Not(Equals), "")
+}
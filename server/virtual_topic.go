@@ -0,0 +1,78 @@
+package server
+
+import (
+	"strings"
+)
+
+// Virtual topics allow several groups of consumers to each receive their
+// own load-balanced copy of the messages sent to a topic. A producer sends
+// messages to a topic whose name starts with VirtualTopicPrefix, for
+// example "/topic/VirtualTopic.Orders". Each consumer group subscribes to
+// a queue named "/queue/Consumer.<group>.VirtualTopic.Orders". Every
+// message sent to the topic is copied to the queue of each consumer
+// group, and the consumers in a group compete for the messages on
+// their group's queue.
+//
+// A consumer group's queue starts receiving messages once the first
+// subscription to it has been made, and continues to receive messages
+// after all of its consumers have unsubscribed.
+const (
+	VirtualTopicPrefix         = "/topic/VirtualTopic."
+	VirtualTopicConsumerPrefix = QueuePrefix + "/Consumer."
+)
+
+// virtualTopics keeps track of the consumer group queues for each
+// virtual topic.
+type virtualTopics struct {
+	queues map[string]map[string]struct{} // virtual topic -> consumer queues
+}
+
+func newVirtualTopics() *virtualTopics {
+	return &virtualTopics{queues: make(map[string]map[string]struct{})}
+}
+
+// Register a queue destination that has been subscribed to. Has no
+// effect if the queue is not a virtual topic consumer queue.
+func (vt *virtualTopics) Register(queue string) {
+	topic, ok := virtualTopicForQueue(queue)
+	if !ok {
+		return
+	}
+	queues, ok := vt.queues[topic]
+	if !ok {
+		queues = make(map[string]struct{})
+		vt.queues[topic] = queues
+	}
+	queues[queue] = struct{}{}
+}
+
+// Queues returns the consumer group queues for a topic destination.
+// Returns nil if the destination is not a virtual topic.
+func (vt *virtualTopics) Queues(topic string) []string {
+	var queues []string
+	for queue := range vt.queues[topic] {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+// Returns the virtual topic destination for a consumer group queue
+// destination. For example, the queue "/queue/Consumer.A.VirtualTopic.X"
+// corresponds to the virtual topic "/topic/VirtualTopic.X".
+func virtualTopicForQueue(queue string) (string, bool) {
+	if !strings.HasPrefix(queue, VirtualTopicConsumerPrefix) {
+		return "", false
+	}
+	rest := queue[len(VirtualTopicConsumerPrefix):]
+
+	// the consumer group name is terminated by a dot
+	index := strings.IndexByte(rest, '.')
+	if index <= 0 {
+		return "", false
+	}
+	topic := "/topic/" + rest[index+1:]
+	if !strings.HasPrefix(topic, VirtualTopicPrefix) || len(topic) == len(VirtualTopicPrefix) {
+		return "", false
+	}
+	return topic, true
+}
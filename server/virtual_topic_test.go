@@ -0,0 +1,39 @@
+package server
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type VirtualTopicSuite struct{}
+
+var _ = Suite(&VirtualTopicSuite{})
+
+func (s *VirtualTopicSuite) TestVirtualTopicForQueue(c *C) {
+	testCases := []struct {
+		queue string
+		topic string
+		ok    bool
+	}{
+		{"/queue/Consumer.A.VirtualTopic.Orders", "/topic/VirtualTopic.Orders", true},
+		{"/queue/Consumer.B.VirtualTopic.Orders.EU", "/topic/VirtualTopic.Orders.EU", true},
+		{"/queue/Consumer..VirtualTopic.Orders", "", false},
+		{"/queue/Consumer.A.Orders", "", false},
+		{"/queue/Consumer.A.VirtualTopic.", "", false},
+		{"/queue/Orders", "", false},
+	}
+
+	for _, tc := range testCases {
+		topic, ok := virtualTopicForQueue(tc.queue)
+		c.Check(ok, Equals, tc.ok, Commentf("queue=%s", tc.queue))
+		c.Check(topic, Equals, tc.topic, Commentf("queue=%s", tc.queue))
+	}
+}
+
+func (s *VirtualTopicSuite) TestRegister(c *C) {
+	vt := newVirtualTopics()
+	vt.Register("/queue/Consumer.A.VirtualTopic.X")
+	vt.Register("/queue/Consumer.A.VirtualTopic.X")
+	vt.Register("/queue/not-virtual")
+	c.Check(vt.Queues("/topic/VirtualTopic.X"), DeepEquals, []string{"/queue/Consumer.A.VirtualTopic.X"})
+	c.Check(vt.Queues("/topic/other"), IsNil)
+}
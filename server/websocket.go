@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-stomp/stomp/v3/server/websocket"
+)
+
+// Subprotocols of STOMP over WebSocket, for each version of STOMP.
+var webSocketProtocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+// WebSocketHandler returns an HTTP handler that upgrades requests to
+// WebSocket connections carrying STOMP frames, as used by STOMP clients
+// running in web browsers. The server serves each WebSocket connection
+// like a connection accepted by its listener, so it must be serving
+// STOMP connections with Serve; requests received when it is not are
+// answered with 503 Service Unavailable.
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.call(func(*requestProcessor) error { return nil }) != nil {
+			http.Error(w, ErrNotServing.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := websocket.Upgrade(w, r, webSocketProtocols)
+		if err != nil {
+			return
+		}
+		err = s.call(func(proc *requestProcessor) error {
+			proc.accept(newConfig(proc), conn)
+			return nil
+		})
+		if err != nil {
+			conn.Close()
+		}
+	})
+}
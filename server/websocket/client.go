@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ErrBadHandshake is returned by Dialer.Dial when the server does not
+// complete the WebSocket handshake.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// A Dialer connects to WebSocket servers. The zero value dials without
+// requesting a subprotocol, using the default TLS configuration for
+// wss URLs.
+type Dialer struct {
+	// NetDial, if not nil, creates the network connection to the
+	// server. Otherwise the connection is made with a zero net.Dialer.
+	NetDial func(network, addr string) (net.Conn, error)
+
+	TLSConfig *tls.Config // Configuration for wss URLs, the default if nil
+	Protocols []string    // Subprotocols to request, in order of preference
+	Header    http.Header // Additional header entries of the handshake request
+}
+
+// Dial connects to the server at a ws or wss URL and completes the
+// WebSocket handshake. The subprotocol selected by the server, if any,
+// is returned by the Protocol method of the connection.
+func (d *Dialer) Dial(rawurl string) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("websocket: unsupported URL scheme: %q", u.Scheme)
+	}
+
+	netDial := d.NetDial
+	if netDial == nil {
+		netDial = (&net.Dialer{}).Dial
+	}
+	conn, err := netDial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, config)
+	}
+
+	c, err := d.handshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Sends the handshake request on conn and checks the response.
+func (d *Dialer) handshake(conn net.Conn, u *url.URL) (*Conn, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	request := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if request.URL.Path == "" {
+		request.URL.Path = "/"
+	}
+	for k, v := range d.Header {
+		request.Header[k] = v
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	for _, protocol := range d.Protocols {
+		request.Header.Add("Sec-WebSocket-Protocol", protocol)
+	}
+	if err := request.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, response.Status)
+	}
+	if !hasToken(response.Header, "Upgrade", "websocket") ||
+		!hasToken(response.Header, "Connection", "upgrade") ||
+		response.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		return nil, ErrBadHandshake
+	}
+	protocol := response.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != "" && !contains(d.Protocols, protocol) {
+		return nil, fmt.Errorf("%w: unexpected subprotocol %q", ErrBadHandshake, protocol)
+	}
+	return &Conn{Conn: conn, r: r, protocol: protocol, client: true}, nil
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WebSocketSuite) TestDial(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/stomp")
+		c.Check(r.URL.RawQuery, Equals, "token=abc")
+		c.Check(r.Header.Get("Origin"), Equals, "http://example.com")
+		conn, err := Upgrade(w, r, []string{"v12.stomp"})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	defer server.Close()
+
+	d := &Dialer{
+		Protocols: []string{"v12.stomp", "v11.stomp"},
+		Header:    http.Header{"Origin": {"http://example.com"}},
+	}
+	conn, err := d.Dial("ws" + strings.TrimPrefix(server.URL, "http") + "/stomp?token=abc")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Check(conn.Protocol(), Equals, "v12.stomp")
+
+	// larger than a short frame, to exercise the extended length
+	message := strings.Repeat("CONNECT\n\n\x00", 20)
+	_, err = conn.Write([]byte(message))
+	c.Assert(err, IsNil)
+	b := make([]byte, len(message))
+	_, err = io.ReadFull(conn, b)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, message)
+}
+
+func (s *WebSocketSuite) TestDialBadHandshake(c *C) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	d := &Dialer{}
+	_, err := d.Dial("ws" + strings.TrimPrefix(server.URL, "http"))
+	c.Check(errors.Is(err, ErrBadHandshake), Equals, true)
+	c.Check(err, ErrorMatches, ".*404 Not Found")
+
+	_, err = d.Dial("http://localhost/")
+	c.Check(err, ErrorMatches, "websocket: unsupported URL scheme.*")
+}
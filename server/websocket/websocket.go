@@ -0,0 +1,324 @@
+/*
+Package websocket implements the WebSocket protocol (RFC 6455), so that
+STOMP clients running in web browsers can connect to the STOMP server,
+and so that STOMP clients can connect to servers that are only reachable
+through WebSocket endpoints.
+
+A Conn carries a stream of bytes in the payloads of WebSocket messages.
+Each call to Write sends one message, which is a text message if the
+bytes are valid UTF-8 and a binary message otherwise, and Read returns
+the payloads of the messages received in turn. Ping messages are
+answered, and a close message ends the stream. Extensions such as
+compression are not supported.
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Appended to the key of a handshake to compute its accept value.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of WebSocket frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Status code of a close message sent by Close.
+const closeNormal = 1000
+
+// Errors returned by Upgrade and Conn.
+var (
+	ErrNotWebSocket = errors.New("websocket: not a WebSocket handshake")
+	ErrProtocol     = errors.New("websocket: protocol error")
+)
+
+// Upgrade completes the WebSocket handshake of an HTTP request and
+// returns the WebSocket connection. The first of the subprotocols
+// requested by the client that is in protocols is selected. If the
+// request is not a WebSocket handshake, an error response is written
+// and ErrNotWebSocket is returned.
+func Upgrade(w http.ResponseWriter, r *http.Request, protocols []string) (*Conn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+
+	protocol := ""
+	for _, p := range tokens(r.Header, "Sec-Websocket-Protocol") {
+		if contains(protocols, p) {
+			protocol = p
+			break
+		}
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{Conn: conn, r: rw.Reader, protocol: protocol}, nil
+}
+
+// AcceptKey returns the value of the Sec-WebSocket-Accept header that
+// answers a handshake with the Sec-WebSocket-Key header key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Returns the comma-separated tokens of the header entries with a key.
+func tokens(h http.Header, key string) []string {
+	var tokens []string
+	for _, value := range h[http.CanonicalHeaderKey(key)] {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// Reports whether a header entry contains a token, ignoring case.
+func hasToken(h http.Header, key, token string) bool {
+	for _, t := range tokens(h, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A Conn is one side of a WebSocket connection, returned by Upgrade on
+// the server side and by Dialer.Dial on the client side. Deadlines
+// apply to the underlying connection.
+type Conn struct {
+	net.Conn
+	r        *bufio.Reader
+	protocol string
+	client   bool // frames are masked when sent, not when received
+
+	// state of the message being read, used only by Read
+	remaining uint64  // bytes of the current frame's payload not yet read
+	mask      [4]byte // masking key of the current frame
+	maskPos   int     // position in mask of the next byte
+	eof       bool    // a close message has been received
+
+	mutex  sync.Mutex // serializes writes
+	closed bool       // a close message has been sent
+}
+
+// Protocol returns the subprotocol selected during the handshake,
+// or an empty string if none was.
+func (c *Conn) Protocol() string {
+	return c.protocol
+}
+
+// Read reads the payloads of the data messages received from the
+// peer. It returns io.EOF once the peer has sent a close message.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.maskPos]
+		c.maskPos = (c.maskPos + 1) % 4
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// Reads frame headers until the header of a data frame with a payload,
+// answering control frames.
+func (c *Conn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if header[0]&0x70 != 0 || masked == c.client {
+		// reserved bits are set, or a client did not mask the frame,
+		// or a server did
+		return ErrProtocol
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	c.mask = [4]byte{}
+	if masked {
+		if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+		if length > 125 || header[0]&0x80 == 0 {
+			return ErrProtocol
+		}
+	default:
+		return ErrProtocol
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= c.mask[i%4]
+	}
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		c.eof = true
+		if len(payload) > 2 {
+			// echo the status code, without the reason
+			payload = payload[:2]
+		}
+		return c.writeClose(payload)
+	}
+	return nil
+}
+
+// Write sends p to the peer in one message.
+func (c *Conn) Write(p []byte) (int, error) {
+	opcode := byte(opText)
+	if !utf8.Valid(p) {
+		opcode = opBinary
+	}
+	if err := c.writeFrame(opcode, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close message to the peer, unless one has already
+// been sent, and closes the underlying connection.
+func (c *Conn) Close() error {
+	var status [2]byte
+	binary.BigEndian.PutUint16(status[:], closeNormal)
+	c.writeClose(status[:])
+	return c.Conn.Close()
+}
+
+// Sends a close message, unless one has already been sent.
+func (c *Conn) writeClose(payload []byte) error {
+	c.mutex.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mutex.Unlock()
+	if closed {
+		return nil
+	}
+	return c.writeFrame(opClose, payload)
+}
+
+// Sends an unfragmented frame. Frames sent by a client are masked,
+// frames sent by a server are not.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(n>>8), byte(n))
+	default:
+		header[1] = 127
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		header = append(header, b[:]...)
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.Conn.Write(append(header, payload...))
+	return err
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestWebSocket(t *testing.T) {
+	TestingT(t)
+}
+
+type WebSocketSuite struct{}
+
+var _ = Suite(&WebSocketSuite{})
+
+// Writes a masked frame, as sent by a client.
+func writeFrame(c *C, conn net.Conn, first byte, payload string) {
+	mask := []byte{1, 2, 3, 4}
+	b := append([]byte{first, 0x80 | byte(len(payload))}, mask...)
+	for i := range payload {
+		b = append(b, payload[i]^mask[i%4])
+	}
+	_, err := conn.Write(b)
+	c.Assert(err, IsNil)
+}
+
+// Reads a frame of fewer than 126 bytes, as sent by a server.
+func readFrame(c *C, r *bufio.Reader) (byte, string) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	c.Assert(err, IsNil)
+	payload := make([]byte, header[1])
+	_, err = io.ReadFull(r, payload)
+	c.Assert(err, IsNil)
+	return header[0], string(payload)
+}
+
+func (s *WebSocketSuite) TestAcceptKey(c *C) {
+	// example from RFC 6455
+	c.Check(AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), Equals, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func (s *WebSocketSuite) TestNotWebSocket(c *C) {
+	w := httptest.NewRecorder()
+	_, err := Upgrade(w, httptest.NewRequest("GET", "/", nil), nil)
+	c.Check(err, Equals, ErrNotWebSocket)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *WebSocketSuite) TestConn(c *C) {
+	echoed := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, []string{"v12.stomp", "v11.stomp"})
+		if err != nil {
+			echoed <- err
+			return
+		}
+		defer conn.Close()
+		if conn.Protocol() != "v11.stomp" {
+			echoed <- ErrProtocol
+			return
+		}
+		b, err := ioutil.ReadAll(conn)
+		if err == nil {
+			_, err = conn.Write(b)
+		}
+		echoed <- err
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /stomp HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Protocol: v10.stomp, v11.stomp\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	c.Assert(err, IsNil)
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, nil)
+	c.Assert(err, IsNil)
+	c.Check(response.StatusCode, Equals, http.StatusSwitchingProtocols)
+	c.Check(response.Header.Get("Sec-WebSocket-Accept"), Equals, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	c.Check(response.Header.Get("Sec-WebSocket-Protocol"), Equals, "v11.stomp")
+
+	// a fragmented message, with a ping between its fragments
+	writeFrame(c, conn, opText, "SEND\n")
+	writeFrame(c, conn, 0x80|opPing, "hello")
+	opcode, payload := readFrame(c, r)
+	c.Check(opcode, Equals, byte(0x80|opPong))
+	c.Check(payload, Equals, "hello")
+	writeFrame(c, conn, 0x80|opContinuation, "\n\x00")
+	writeFrame(c, conn, 0x80|opClose, "\x03\xe8")
+	opcode, payload = readFrame(c, r)
+	c.Check(opcode, Equals, byte(0x80|opClose))
+	c.Check(payload, Equals, "\x03\xe8")
+	c.Assert(<-echoed, IsNil)
+}
@@ -0,0 +1,131 @@
+package wildcard
+
+// Index is a collection of values, each associated with a wildcard pattern.
+// It is organized as a trie keyed on pattern segments, so finding the values
+// whose patterns match a destination takes time proportional to the number
+// of segments in the destination, rather than the number of patterns.
+//
+// An Index is not safe for concurrent use.
+type Index struct {
+	root *node
+	len  int
+}
+
+type node struct {
+	children map[string]*node // keyed by segment, including Single
+	values   []interface{}    // values whose pattern ends at this node
+	rest     []interface{}    // values whose pattern continues with Rest
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{root: &node{}}
+}
+
+// Len returns the number of values in the index.
+func (x *Index) Len() int {
+	return x.len
+}
+
+// Add a value to the index for the pattern. Adding the same value more
+// than once for the same pattern results in the value being matched
+// more than once.
+func (x *Index) Add(pattern string, value interface{}) {
+	n := x.root
+	segments := Split(pattern)
+	for i, segment := range segments {
+		if segment == Rest && i == len(segments)-1 {
+			n.rest = append(n.rest, value)
+			x.len++
+			return
+		}
+		child, ok := n.children[segment]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			child = &node{}
+			n.children[segment] = child
+		}
+		n = child
+	}
+	n.values = append(n.values, value)
+	x.len++
+}
+
+// Remove a value from the index for the pattern. Returns true if
+// the value was found and removed.
+func (x *Index) Remove(pattern string, value interface{}) bool {
+	if x.root.remove(Split(pattern), value) {
+		x.len--
+		return true
+	}
+	return false
+}
+
+// Match calls fn for every value in the index whose pattern
+// matches the destination.
+func (x *Index) Match(destination string, fn func(value interface{})) {
+	x.root.match(Split(destination), fn)
+}
+
+func (n *node) match(segments []string, fn func(value interface{})) {
+	if len(segments) == 0 {
+		for _, value := range n.values {
+			fn(value)
+		}
+		return
+	}
+
+	for _, value := range n.rest {
+		fn(value)
+	}
+
+	// a destination segment of "*" is matched literally by the
+	// Single child, so avoid visiting that child twice
+	if segments[0] != Single {
+		if child, ok := n.children[segments[0]]; ok {
+			child.match(segments[1:], fn)
+		}
+	}
+	if child, ok := n.children[Single]; ok {
+		child.match(segments[1:], fn)
+	}
+}
+
+func (n *node) remove(segments []string, value interface{}) bool {
+	if len(segments) == 1 && segments[0] == Rest {
+		return removeValue(&n.rest, value)
+	}
+	if len(segments) == 0 {
+		return removeValue(&n.values, value)
+	}
+
+	child, ok := n.children[segments[0]]
+	if !ok {
+		return false
+	}
+	if !child.remove(segments[1:], value) {
+		return false
+	}
+
+	// prune nodes that are no longer required
+	if child.empty() {
+		delete(n.children, segments[0])
+	}
+	return true
+}
+
+func (n *node) empty() bool {
+	return len(n.children) == 0 && len(n.values) == 0 && len(n.rest) == 0
+}
+
+func removeValue(values *[]interface{}, value interface{}) bool {
+	for i, v := range *values {
+		if v == value {
+			*values = append((*values)[:i], (*values)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package wildcard
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+type IndexSuite struct{}
+
+var _ = Suite(&IndexSuite{})
+
+func matchAll(x *Index, destination string) []string {
+	var values []string
+	x.Match(destination, func(value interface{}) {
+		values = append(values, value.(string))
+	})
+	sort.Strings(values)
+	return values
+}
+
+// The index must agree with Match for every pattern.
+func (s *IndexSuite) TestIndexAgreesWithMatch(c *C) {
+	for _, tc := range matchTestCases {
+		x := NewIndex()
+		x.Add(tc.pattern, tc.pattern)
+		c.Check(len(matchAll(x, tc.destination)) == 1, Equals, tc.match,
+			Commentf("pattern=%s destination=%s", tc.pattern, tc.destination))
+	}
+}
+
+func (s *IndexSuite) TestAddRemove(c *C) {
+	x := NewIndex()
+	x.Add("/topic/a.*", "1")
+	x.Add("/topic/a.>", "2")
+	x.Add("/topic/a.b", "3")
+	x.Add("/topic/*.b", "4")
+	c.Check(x.Len(), Equals, 4)
+
+	c.Check(matchAll(x, "/topic/a.b"), DeepEquals, []string{"1", "2", "3", "4"})
+	c.Check(matchAll(x, "/topic/a.b.c"), DeepEquals, []string{"2"})
+	c.Check(matchAll(x, "/topic/x.b"), DeepEquals, []string{"4"})
+	c.Check(matchAll(x, "/topic/a.*"), DeepEquals, []string{"1", "2"})
+
+	c.Check(x.Remove("/topic/a.>", "2"), Equals, true)
+	c.Check(x.Remove("/topic/a.>", "2"), Equals, false)
+	c.Check(x.Remove("/topic/a.b", "1"), Equals, false)
+	c.Check(matchAll(x, "/topic/a.b.c"), IsNil)
+
+	for _, p := range []string{"/topic/a.*", "/topic/a.b", "/topic/*.b"} {
+		c.Check(x.Remove(p, matchAll(x, p)[0]), Equals, true)
+	}
+	c.Check(x.Len(), Equals, 0)
+	c.Check(x.root.empty(), Equals, true)
+}
+
+func BenchmarkIndexMatch(b *testing.B) {
+	x := NewIndex()
+	for i := 0; i < 10000; i++ {
+		x.Add(fmt.Sprintf("/topic/prices.%d.*", i), i)
+	}
+	x.Add("/topic/prices.>", -1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Match("/topic/prices.5000.EUR", func(value interface{}) {})
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Package wildcard provides matching of destination names against
+wildcard patterns.
+
+A destination name is split into segments by the '/' and '.' characters.
+In a pattern, a segment consisting of "*" matches exactly one segment of
+the destination name, and a final segment of ">" matches one or more
+remaining segments. For example, the pattern "/topic/orders.*" matches
+"/topic/orders.new" but not "/topic/orders.new.eu", whereas the pattern
+"/topic/orders.>" matches both.
+*/
+package wildcard
+
+import (
+	"strings"
+)
+
+// Wildcard segments.
+const (
+	Single = "*" // matches exactly one segment
+	Rest   = ">" // matches one or more remaining segments
+)
+
+// Split a destination name or pattern into its segments.
+func Split(destination string) []string {
+	return strings.FieldsFunc(destination, func(r rune) bool {
+		return r == '/' || r == '.'
+	})
+}
+
+// IsPattern reports whether the destination contains any
+// wildcard segments.
+func IsPattern(destination string) bool {
+	for _, segment := range Split(destination) {
+		if segment == Single || segment == Rest {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the destination matches the pattern.
+func Match(pattern, destination string) bool {
+	patternSegments := Split(pattern)
+	destSegments := Split(destination)
+
+	for i, segment := range patternSegments {
+		if segment == Rest && i == len(patternSegments)-1 {
+			return len(destSegments) > i
+		}
+		if i >= len(destSegments) {
+			return false
+		}
+		if segment != Single && segment != destSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(destSegments)
+}
@@ -0,0 +1,49 @@
+package wildcard
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func TestWildcard(t *testing.T) {
+	TestingT(t)
+}
+
+type WildcardSuite struct{}
+
+var _ = Suite(&WildcardSuite{})
+
+var matchTestCases = []struct {
+	pattern     string
+	destination string
+	match       bool
+}{
+	{"/topic/a", "/topic/a", true},
+	{"/topic/a", "/topic/b", false},
+	{"/topic/*", "/topic/a", true},
+	{"/topic/*", "/topic/a/b", false},
+	{"/topic/orders.*", "/topic/orders.new", true},
+	{"/topic/orders.*", "/topic/orders.new.eu", false},
+	{"/topic/orders.>", "/topic/orders.new.eu", true},
+	{"/topic/orders.>", "/topic/orders", false},
+	{"/topic/*.new", "/topic/orders.new", true},
+	{"/topic/>", "/queue/a", false},
+	{"/topic/a/b", "/topic/a", false},
+}
+
+func (s *WildcardSuite) TestMatch(c *C) {
+	for _, tc := range matchTestCases {
+		c.Check(Match(tc.pattern, tc.destination), Equals, tc.match,
+			Commentf("pattern=%s destination=%s", tc.pattern, tc.destination))
+	}
+}
+
+func (s *WildcardSuite) TestIsPattern(c *C) {
+	c.Check(IsPattern("/topic/a.b"), Equals, false)
+	c.Check(IsPattern("/topic/a*"), Equals, false)
+	c.Check(IsPattern("/topic/*.b"), Equals, true)
+	c.Check(IsPattern("/topic/a.>"), Equals, true)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/client"
+	"github.com/go-stomp/stomp/v3/server/wildcard"
+)
+
+// Maximum number of bytes of an encoded frame that wire logging logs,
+// so that large bodies do not flood the log.
+const wireLogMaxBytes = 4096
+
+// WireLogging describes the connections and destinations whose frames
+// are logged, see SetConnectionWireLogging.
+type WireLogging struct {
+	Connections  []string `json:"connections"`  // ids of connections
+	Destinations []string `json:"destinations"` // destination patterns
+}
+
+// wireLog is an interceptor of every connection, which logs the frames
+// received from and sent to the connections and destinations whose
+// wire logging is on.
+type wireLog struct {
+	server       *Server
+	on           int32 // non-zero if any wire logging is on
+	mu           sync.RWMutex
+	connections  map[string]struct{}
+	destinations map[string]struct{}
+}
+
+// SetConnectionWireLogging turns the logging of every frame received
+// from and sent to a client on or off. The client is identified by its
+// id, as returned by Connections, or by its remote address. Frames are
+// logged in escaped form at the info level by the WireComponent logger,
+// for diagnosing problems without a packet capture. Wire logging stops
+// when the client disconnects. Returns ErrNoClient if no connected
+// client matches.
+func (s *Server) SetConnectionWireLogging(client string, enabled bool) error {
+	info, _, err := s.Connection(client)
+	if err != nil {
+		return err
+	}
+	s.wireLog.set(&s.wireLog.connections, info.Id, enabled)
+	return nil
+}
+
+// SetDestinationWireLogging turns the logging of the frames whose
+// destination matches pattern on or off, as for
+// SetConnectionWireLogging. The pattern may contain wildcards, as for
+// DestinationPolicy.Pattern.
+func (s *Server) SetDestinationWireLogging(pattern string, enabled bool) {
+	s.wireLog.set(&s.wireLog.destinations, pattern, enabled)
+}
+
+// WireLogging returns the connections and destinations whose frames
+// are logged.
+func (s *Server) WireLogging() WireLogging {
+	w := &s.wireLog
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return WireLogging{
+		Connections:  sortedKeys(w.connections),
+		Destinations: sortedKeys(w.destinations),
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (w *wireLog) set(m *map[string]struct{}, key string, enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if enabled {
+		if *m == nil {
+			*m = make(map[string]struct{})
+		}
+		(*m)[key] = struct{}{}
+	} else {
+		delete(*m, key)
+	}
+	var on int32
+	if len(w.connections) > 0 || len(w.destinations) > 0 {
+		on = 1
+	}
+	atomic.StoreInt32(&w.on, on)
+}
+
+// Stops the logging of a connection that has disconnected.
+func (w *wireLog) removeConnection(id string) {
+	if atomic.LoadInt32(&w.on) == 0 {
+		return
+	}
+	w.set(&w.connections, id, false)
+}
+
+// Returns true if the frames of connection c with the destination are
+// logged.
+func (w *wireLog) logs(c *client.Conn, destination string) bool {
+	if atomic.LoadInt32(&w.on) == 0 {
+		return false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if _, ok := w.connections[c.Id()]; ok {
+		return true
+	}
+	if destination != "" {
+		for pattern := range w.destinations {
+			if wildcard.Match(pattern, destination) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *wireLog) Inbound(c *client.Conn, f *frame.Frame) error {
+	w.log(c, "received", f)
+	return nil
+}
+
+func (w *wireLog) Outbound(c *client.Conn, f *frame.Frame) error {
+	w.log(c, "sent", f)
+	return nil
+}
+
+func (w *wireLog) log(c *client.Conn, action string, f *frame.Frame) {
+	destination := f.Header.Get(frame.Destination)
+	if !w.logs(c, destination) {
+		return
+	}
+	var buf bytes.Buffer
+	writer := frame.NewWriter(&buf)
+	writer.SetVersion(string(c.Version()))
+	if err := writer.Write(f); err != nil {
+		return
+	}
+	wire := buf.Bytes()
+	elided := ""
+	if len(wire) > wireLogMaxBytes {
+		elided = " (" + strconv.Itoa(len(wire)-wireLogMaxBytes) + " more bytes)"
+		wire = wire[:wireLogMaxBytes]
+	}
+	fields := []stomp.Field{
+		{Key: stomp.RemoteAddrField, Value: c.RemoteAddr().String()},
+		{Key: stomp.CommandField, Value: f.Command},
+	}
+	if destination != "" {
+		fields = append(fields, stomp.Field{Key: stomp.DestinationField, Value: destination})
+	}
+	stomp.WithFields(w.server.logger(WireComponent), fields...).Infof("[%s] %s %s%s",
+		c.Id(), action, strconv.Quote(string(wire)), elided)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3"
+	. "gopkg.in/check.v1"
+)
+
+type WireLogSuite struct{}
+
+var _ = Suite(&WireLogSuite{})
+
+// messageLogger keeps the messages logged at the info level.
+type messageLogger struct {
+	nopTestLogger
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *messageLogger) Infof(format string, value ...interface{}) {
+	l.Info(fmt.Sprintf(format, value...))
+}
+
+func (l *messageLogger) Info(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, message)
+}
+
+// Returns the messages logged that contain s.
+func (l *messageLogger) containing(s string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var messages []string
+	for _, message := range l.messages {
+		if strings.Contains(message, s) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+type nopTestLogger struct{}
+
+func (nopTestLogger) Debugf(format string, value ...interface{})   {}
+func (nopTestLogger) Infof(format string, value ...interface{})    {}
+func (nopTestLogger) Warningf(format string, value ...interface{}) {}
+func (nopTestLogger) Errorf(format string, value ...interface{})   {}
+func (nopTestLogger) Debug(message string)                         {}
+func (nopTestLogger) Info(message string)                          {}
+func (nopTestLogger) Warning(message string)                       {}
+func (nopTestLogger) Error(message string)                         {}
+
+func (s *WireLogSuite) TestConnectionWireLogging(c *C) {
+	log := &messageLogger{}
+	serv := &Server{Log: log}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+
+	logged, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer logged.Disconnect()
+	conns, err := serv.Connections()
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 1)
+	id := conns[0].Id
+	other, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer other.Disconnect()
+
+	c.Assert(serv.SetConnectionWireLogging(id, true), IsNil)
+	c.Check(serv.WireLogging().Connections, DeepEquals, []string{id})
+	c.Check(serv.SetConnectionWireLogging("unknown", true), Equals, ErrNoClient)
+
+	c.Assert(logged.Send("/queue/a", "text/plain", []byte("line1\nline2"), stomp.SendOpt.Receipt), IsNil)
+	c.Assert(other.Send("/queue/b", "text/plain", []byte("other"), stomp.SendOpt.Receipt), IsNil)
+	received := log.containing("[" + id + "] received")
+	c.Assert(received, HasLen, 1)
+	c.Check(received[0], Matches, `(?s).*"SEND\\n.*destination:/queue/a\\n.*\\n\\nline1\\nline2\\x00".*`)
+	c.Check(log.containing("[" + id + "] sent \"RECEIPT"), HasLen, 1)
+	c.Check(log.containing("other"), HasLen, 0)
+
+	c.Assert(serv.SetConnectionWireLogging(id, false), IsNil)
+	c.Assert(logged.Send("/queue/a", "text/plain", []byte("off"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(log.containing("off"), HasLen, 0)
+	c.Check(serv.WireLogging().Connections, HasLen, 0)
+}
+
+func (s *WireLogSuite) TestDestinationWireLogging(c *C) {
+	log := &messageLogger{}
+	serv := &Server{Log: log}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+	handler := serv.AdminHandler(nil)
+	request := func(method, target string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+
+	c.Check(request("POST", "/wirelog?destination=/queue/orders.>"), Equals, http.StatusNoContent)
+	c.Check(request("POST", "/wirelog"), Equals, http.StatusBadRequest)
+	c.Check(request("POST", "/wirelog?connection=unknown"), Equals, http.StatusNotFound)
+	c.Check(serv.WireLogging().Destinations, DeepEquals, []string{"/queue/orders.>"})
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	c.Assert(conn.Send("/queue/orders.new", "text/plain", []byte("order"), stomp.SendOpt.Receipt), IsNil)
+	c.Assert(conn.Send("/queue/other", "text/plain", []byte("other"), stomp.SendOpt.Receipt), IsNil)
+	c.Check(log.containing("order"), HasLen, 1)
+	c.Check(log.containing("other"), HasLen, 0)
+
+	c.Check(request("DELETE", "/wirelog?destination=/queue/orders.>"), Equals, http.StatusNoContent)
+	c.Check(serv.WireLogging().Destinations, HasLen, 0)
+}
+
+func (s *WireLogSuite) TestWireLoggingLargeFrame(c *C) {
+	log := &messageLogger{}
+	serv := &Server{Log: log}
+	l := serveForReload(c, serv)
+	defer serv.Shutdown()
+	serv.SetDestinationWireLogging("/queue/large", true)
+
+	conn, err := stomp.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+	body := strings.Repeat("x", 2*wireLogMaxBytes)
+	c.Assert(conn.Send("/queue/large", "text/plain", []byte(body), stomp.SendOpt.Receipt), IsNil)
+	messages := log.containing("more bytes")
+	c.Assert(messages, HasLen, 1)
+	c.Check(len(messages[0]) < wireLogMaxBytes+200, Equals, true)
+}
@@ -0,0 +1,58 @@
+//go:build go1.21
+// +build go1.21
+
+package stomp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// NewSlogLogger returns a logger that writes to l, recording the
+// fields attached with WithFields as attributes. Warnings are logged
+// at slog.LevelWarn.
+func NewSlogLogger(l *slog.Logger) FieldLogger {
+	return &slogLogger{l: l}
+}
+
+// A FieldLogger for log/slog.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l *slogLogger) WithFields(fields ...Field) Logger {
+	args := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		args = append(args, f.Key, f.Value)
+	}
+	return &slogLogger{l: l.l.With(args...)}
+}
+
+func (l *slogLogger) logf(level slog.Level, format string, value []interface{}) {
+	// avoid formatting messages that are discarded
+	if l.l.Enabled(context.Background(), level) {
+		l.l.Log(context.Background(), level, fmt.Sprintf(format, value...))
+	}
+}
+
+func (l *slogLogger) Debugf(format string, value ...interface{}) {
+	l.logf(slog.LevelDebug, format, value)
+}
+
+func (l *slogLogger) Infof(format string, value ...interface{}) {
+	l.logf(slog.LevelInfo, format, value)
+}
+
+func (l *slogLogger) Warningf(format string, value ...interface{}) {
+	l.logf(slog.LevelWarn, format, value)
+}
+
+func (l *slogLogger) Errorf(format string, value ...interface{}) {
+	l.logf(slog.LevelError, format, value)
+}
+
+func (l *slogLogger) Debug(message string)   { l.l.Debug(message) }
+func (l *slogLogger) Info(message string)    { l.l.Info(message) }
+func (l *slogLogger) Warning(message string) { l.l.Warn(message) }
+func (l *slogLogger) Error(message string)   { l.l.Error(message) }
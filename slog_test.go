@@ -0,0 +1,32 @@
+//go:build go1.21
+// +build go1.21
+
+package stomp
+
+import (
+	"bytes"
+	"log/slog"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *LoggerSuite) TestSlogLogger(c *C) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	log := NewSlogLogger(slog.New(handler))
+	l := WithFields(log, Field{LoginField, "guest"}, Field{DestinationField, "/queue/a"})
+	l.Debugf("discarded %d", 1)
+	l.Warningf("failed: %d", 1)
+	log.Error("closed")
+	c.Check(buf.String(), Equals,
+		`level=WARN msg="failed: 1" login=guest destination=/queue/a`+"\n"+
+			`level=ERROR msg=closed`+"\n")
+}
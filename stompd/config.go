@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/boltstore"
+	"github.com/go-stomp/stomp/v3/server/redisstore"
+	"github.com/go-stomp/stomp/v3/server/sqlstore"
+)
+
+/*
+The configuration file given with -config is TOML, or JSON if its name
+ends with ".json". For example:
+
+	heart_beat = "1m"
+	log_levels = { client = "warning" }
+
+	[[listeners]]
+	addr = ":61613"
+
+	[[listeners]]
+	addr = ":61614"
+	protocol = "detect"  # stomp, mqtt, websocket or detect
+	tls = { cert_file = "server.pem", key_file = "server.key" }
+
+	[http]
+	metrics_addr = ":9100"
+
+	[auth]
+	users_file = "/etc/stompd/users"  # login:passcode lines
+	users = [{ login = "app", passcode = "secret" }]
+
+	[[destinations]]
+	pattern = "/queue/orders.>"
+	durability = "fsync"
+	expiry_destination = "/queue/expired"
+	payload_schema = "/etc/stompd/order.schema.json"
+
+	[persistence]
+	backend = "bolt"  # memory, bolt, redis or postgres
+	dir = "/var/lib/stompd"
+
+	[limits]
+	max_memory_bytes = 1073741824
+
+Durations are strings such as "30s", parsed by time.ParseDuration.
+Flags given on the command line override the settings of the file,
+and -addr replaces its listeners.
+
+On SIGHUP, or when the admin API is sent POST /reload, the file is read
+again, and the log levels, disabled features, users and destination
+policies are changed without restarting the server. The other settings
+only change when the server is restarted.
+*/
+
+// serverName identifies stompd to clients, see server.Server.Name.
+const serverName = "stompd"
+
+// config is the configuration of stompd.
+type config struct {
+	HeartBeat        duration            `json:"heart_beat"`
+	LogLevels        map[string]string   `json:"log_levels"`
+	DisabledFeatures []string            `json:"disabled_features"` // transactions, nack, subscribe or send
+	RecordDir        string              `json:"record_dir"`        // see server.Server.RecordDir
+	Listeners        []listenerConfig    `json:"listeners"`
+	HTTP             httpConfig          `json:"http"`
+	Auth             authConfig          `json:"auth"`
+	Destinations     []destinationConfig `json:"destinations"`
+	Persistence      persistenceConfig   `json:"persistence"`
+	Limits           limitsConfig        `json:"limits"`
+}
+
+// listenerConfig is a network address that the server listens on.
+type listenerConfig struct {
+	Addr     string     `json:"addr"`
+	Protocol string     `json:"protocol"` // stomp if empty
+	TLS      *tlsConfig `json:"tls"`
+}
+
+type tlsConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"` // if set, clients must present certificates signed by these CAs
+}
+
+// httpConfig holds the addresses of the HTTP endpoints, which are
+// disabled if empty.
+type httpConfig struct {
+	MetricsAddr   string `json:"metrics_addr"`
+	AdminAddr     string `json:"admin_addr"`
+	AdminLogin    string `json:"admin_login"`
+	AdminPasscode string `json:"admin_passcode"`
+	AdminDebug    bool   `json:"admin_debug"`
+	HealthAddr    string `json:"health_addr"`
+}
+
+// authConfig holds the users allowed to connect. Clients are not
+// authenticated if there are none.
+type authConfig struct {
+	UsersFile string       `json:"users_file"`
+	Users     []userConfig `json:"users"`
+}
+
+type userConfig struct {
+	Login    string `json:"login"`
+	Passcode string `json:"passcode"`
+}
+
+// destinationConfig is a server.DestinationPolicy.
+type destinationConfig struct {
+	Pattern             string   `json:"pattern"`
+	MirrorQueue         string   `json:"mirror_queue"`
+	ExpiryDestination   string   `json:"expiry_destination"`
+	Dispatch            string   `json:"dispatch"`
+	MinConsumers        int      `json:"min_consumers"`
+	ConsumerGracePeriod duration `json:"consumer_grace_period"`
+	Durability          string   `json:"durability"`
+	RetainMessages      int      `json:"retain_messages"`
+	RetainFor           duration `json:"retain_for"`
+	SlowConsumer        string   `json:"slow_consumer"`
+	SlowConsumerTimeout duration `json:"slow_consumer_timeout"`
+	TraceMessages       bool     `json:"trace_messages"`
+	PayloadSchema       string   `json:"payload_schema"` // JSON Schema file of the message bodies
+}
+
+// persistenceConfig describes where queues are stored.
+type persistenceConfig struct {
+	Backend string `json:"backend"` // memory if empty
+
+	// bolt
+	Dir  string `json:"dir"`
+	Sync string `json:"sync"` // always, periodic or never
+
+	// redis
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	Prefix   string `json:"prefix"`
+	Instance string `json:"instance"`
+
+	// postgres
+	DSN   string `json:"dsn"`
+	Table string `json:"table"`
+
+	// Interval of the periodic sync mode, and of the interval
+	// durability of destinations.
+	SyncInterval duration `json:"sync_interval"`
+
+	SnapshotFile          string   `json:"snapshot_file"`
+	PageDir               string   `json:"page_dir"`
+	MaxMemoryMessages     int      `json:"max_memory_messages"`
+	CompactInterval       duration `json:"compact_interval"`
+	HonorPersistentHeader bool     `json:"honor_persistent_header"`
+}
+
+type limitsConfig struct {
+	MaxPendingWrites               int      `json:"max_pending_writes"`
+	MaxPendingReads                int      `json:"max_pending_reads"`
+	MaxMemoryBytes                 int64    `json:"max_memory_bytes"`
+	MaxHeapBytes                   uint64   `json:"max_heap_bytes"`
+	IdleDestinationTimeout         duration `json:"idle_destination_timeout"`
+	QueueSlowConsumer              string   `json:"queue_slow_consumer"`
+	TopicSlowConsumer              string   `json:"topic_slow_consumer"`
+	SlowConsumerTimeout            duration `json:"slow_consumer_timeout"`
+	TopicFanoutWorkers             int      `json:"topic_fanout_workers"`
+	HeartBeatGracePeriodMultiplier float64  `json:"heart_beat_grace_period_multiplier"`
+	MaxFrameSize                   int      `json:"max_frame_size"`
+}
+
+// duration is a time.Duration written as a string such as "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig reads the configuration file at path.
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		tree, err := parseTOML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	cfg := &config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+var dispatchModes = map[string]server.DispatchMode{
+	"":            server.DispatchRoundRobin,
+	"round-robin": server.DispatchRoundRobin,
+	"broadcast":   server.DispatchBroadcast,
+}
+
+var durabilities = map[string]server.Durability{
+	"":         server.DurabilityDefault,
+	"default":  server.DurabilityDefault,
+	"buffered": server.DurabilityBuffered,
+	"interval": server.DurabilityInterval,
+	"fsync":    server.DurabilityFsync,
+}
+
+var slowConsumerActions = map[string]server.SlowConsumerAction{
+	"":            server.SlowConsumerWait,
+	"wait":        server.SlowConsumerWait,
+	"drop":        server.SlowConsumerDrop,
+	"unsubscribe": server.SlowConsumerUnsubscribe,
+	"disconnect":  server.SlowConsumerDisconnect,
+}
+
+var features = map[string]server.Feature{
+	"transactions": server.FeatureTransactions,
+	"nack":         server.FeatureNack,
+	"subscribe":    server.FeatureSubscribe,
+	"send":         server.FeatureSend,
+}
+
+var syncModes = map[string]boltstore.SyncMode{
+	"":         boltstore.SyncAlways,
+	"always":   boltstore.SyncAlways,
+	"periodic": boltstore.SyncPeriodic,
+	"never":    boltstore.SyncNever,
+}
+
+// newServer returns a server configured by cfg, except for its
+// listeners and HTTP endpoints.
+func (cfg *config) newServer() (*server.Server, error) {
+	queueSlowConsumer, ok := slowConsumerActions[cfg.Limits.QueueSlowConsumer]
+	if !ok {
+		return nil, fmt.Errorf("invalid queue_slow_consumer %q", cfg.Limits.QueueSlowConsumer)
+	}
+	topicSlowConsumer, ok := slowConsumerActions[cfg.Limits.TopicSlowConsumer]
+	if !ok {
+		return nil, fmt.Errorf("invalid topic_slow_consumer %q", cfg.Limits.TopicSlowConsumer)
+	}
+	r, err := cfg.reloadable()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := cfg.Persistence.open()
+	if err != nil {
+		return nil, err
+	}
+	listeners, err := cfg.listenOpts()
+	if err != nil {
+		return nil, err
+	}
+	s, err := server.New(append(listeners,
+		server.Opt.Authenticator(r.authenticator),
+		server.Opt.QueueStorage(storage),
+		server.Opt.LogLevels(r.logLevels),
+		server.Opt.Policies(r.policies...),
+		server.Opt.DisabledFeatures(r.disabledFeatures),
+		server.Opt.HeartBeat(time.Duration(cfg.HeartBeat)),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Name = serverName
+	s.RecordDir = cfg.RecordDir
+	s.IdleDestinationTimeout = time.Duration(cfg.Limits.IdleDestinationTimeout)
+	s.HonorPersistentHeader = cfg.Persistence.HonorPersistentHeader
+	s.SnapshotFile = cfg.Persistence.SnapshotFile
+	s.MaxMemoryMessages = cfg.Persistence.MaxMemoryMessages
+	s.PageDir = cfg.Persistence.PageDir
+	s.CompactInterval = time.Duration(cfg.Persistence.CompactInterval)
+	s.SyncInterval = time.Duration(cfg.Persistence.SyncInterval)
+	s.MaxPendingWrites = cfg.Limits.MaxPendingWrites
+	s.MaxPendingReads = cfg.Limits.MaxPendingReads
+	s.HeartBeatGracePeriodMultiplier = cfg.Limits.HeartBeatGracePeriodMultiplier
+	s.SlowConsumerTimeout = time.Duration(cfg.Limits.SlowConsumerTimeout)
+	s.TopicFanoutWorkers = cfg.Limits.TopicFanoutWorkers
+	s.MaxMemoryBytes = cfg.Limits.MaxMemoryBytes
+	s.MaxHeapBytes = cfg.Limits.MaxHeapBytes
+	s.MaxFrameSize = cfg.Limits.MaxFrameSize
+	s.QueueSlowConsumer = queueSlowConsumer
+	s.TopicSlowConsumer = topicSlowConsumer
+	return s, nil
+}
+
+// reloadable holds the settings that can be changed while the server
+// is running.
+type reloadable struct {
+	logLevels        map[string]stomp.Level
+	disabledFeatures server.Feature
+	authenticator    server.Authenticator
+	policies         []server.DestinationPolicy
+}
+
+func (cfg *config) reloadable() (*reloadable, error) {
+	r := &reloadable{}
+	if len(cfg.LogLevels) > 0 {
+		r.logLevels = make(map[string]stomp.Level)
+		for component, name := range cfg.LogLevels {
+			level, err := stomp.ParseLevel(name)
+			if err != nil {
+				return nil, err
+			}
+			r.logLevels[component] = level
+		}
+	}
+
+	for _, name := range cfg.DisabledFeatures {
+		feature, ok := features[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid disabled feature %q", name)
+		}
+		r.disabledFeatures |= feature
+	}
+
+	users, err := cfg.Auth.users()
+	if err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		r.authenticator = users
+	}
+
+	for _, d := range cfg.Destinations {
+		policy, err := d.policy()
+		if err != nil {
+			return nil, err
+		}
+		r.policies = append(r.policies, policy)
+	}
+	return r, nil
+}
+
+// reload reads the configuration file again, and changes the settings
+// of s that can be changed while it is running.
+func reload(s *server.Server) error {
+	cfg := &config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			return err
+		}
+	}
+	if err := cfg.applyFlags(); err != nil {
+		return err
+	}
+	r, err := cfg.reloadable()
+	if err != nil {
+		return err
+	}
+	s.SetLogLevels(r.logLevels)
+	s.SetDisabledFeatures(r.disabledFeatures)
+	s.SetAuthenticator(r.authenticator)
+	return s.SetPolicies(r.policies)
+}
+
+func (d *destinationConfig) policy() (server.DestinationPolicy, error) {
+	policy := server.DestinationPolicy{
+		Pattern:             d.Pattern,
+		MirrorQueue:         d.MirrorQueue,
+		ExpiryDestination:   d.ExpiryDestination,
+		MinConsumers:        d.MinConsumers,
+		ConsumerGracePeriod: time.Duration(d.ConsumerGracePeriod),
+		RetainMessages:      d.RetainMessages,
+		RetainFor:           time.Duration(d.RetainFor),
+		SlowConsumerTimeout: time.Duration(d.SlowConsumerTimeout),
+		TraceMessages:       d.TraceMessages,
+	}
+	if d.Pattern == "" {
+		return policy, fmt.Errorf("destination policy without a pattern")
+	}
+	var ok bool
+	if policy.Dispatch, ok = dispatchModes[d.Dispatch]; !ok {
+		return policy, fmt.Errorf("%s: invalid dispatch %q", d.Pattern, d.Dispatch)
+	}
+	if policy.Durability, ok = durabilities[d.Durability]; !ok {
+		return policy, fmt.Errorf("%s: invalid durability %q", d.Pattern, d.Durability)
+	}
+	if policy.SlowConsumer, ok = slowConsumerActions[d.SlowConsumer]; !ok {
+		return policy, fmt.Errorf("%s: invalid slow_consumer %q", d.Pattern, d.SlowConsumer)
+	}
+	if d.PayloadSchema != "" {
+		data, err := ioutil.ReadFile(d.PayloadSchema)
+		if err != nil {
+			return policy, err
+		}
+		schema, err := server.ParseJSONSchema(data)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %v", d.PayloadSchema, err)
+		}
+		policy.Validator = schema
+	}
+	return policy, nil
+}
+
+// open opens the queue storage, which is nil for in-memory queues.
+func (p *persistenceConfig) open() (server.QueueStorage, error) {
+	switch p.Backend {
+	case "", "memory":
+		return nil, nil
+	case "bolt":
+		sync, ok := syncModes[p.Sync]
+		if !ok {
+			return nil, fmt.Errorf("invalid sync %q", p.Sync)
+		}
+		return boltstore.Open(boltstore.Options{
+			Dir:          p.Dir,
+			Sync:         sync,
+			SyncInterval: time.Duration(p.SyncInterval),
+		})
+	case "redis":
+		return redisstore.Open(redisstore.Options{
+			Addr:     p.Addr,
+			Password: p.Password,
+			DB:       p.DB,
+			Prefix:   p.Prefix,
+			Instance: p.Instance,
+		})
+	case "postgres":
+		db, err := sql.Open("postgres", p.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return sqlstore.Open(sqlstore.Options{DB: db, Table: p.Table})
+	}
+	return nil, fmt.Errorf("invalid persistence backend %q", p.Backend)
+}
+
+// users returns the users of the users file and of the configuration.
+func (a *authConfig) users() (userAuthenticator, error) {
+	users := userAuthenticator{}
+	if a.UsersFile != "" {
+		f, err := os.Open(a.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.Index(line, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("%s:%d: expected login:passcode", a.UsersFile, n)
+			}
+			users[line[:i]] = line[i+1:]
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, user := range a.Users {
+		users[user.Login] = user.Passcode
+	}
+	return users, nil
+}
+
+// Authenticates clients with the passcodes of their logins.
+type userAuthenticator map[string]string
+
+func (u userAuthenticator) Authenticate(login, passcode string) bool {
+	expected, ok := u[login]
+	return ok && subtle.ConstantTimeCompare([]byte(passcode), []byte(expected)) == 1
+}
+
+func (t *tlsConfig) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", t.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+func TestParseTOML(t *testing.T) {
+	tree, err := parseTOML(`
+# comment
+title = "a \"b\"\t\u00e9" # trailing comment
+literal = 'C:\path'
+n = 1_000
+hex = 0x10
+f = 1.5e3
+yes = true
+list = [
+  1,
+  "two", # comment
+]
+point = { x = 1, "y" = -2 }
+a.b.c = 'dotted'
+
+[table.sub]
+key = "value"
+
+[[items]]
+name = "first"
+
+[[items]]
+name = "second"
+[items.nested]
+k = false
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"title":   "a \"b\"\té",
+		"literal": `C:\path`,
+		"n":       int64(1000),
+		"hex":     int64(16),
+		"f":       1500.0,
+		"yes":     true,
+		"list":    []interface{}{int64(1), "two"},
+		"point":   map[string]interface{}{"x": int64(1), "y": int64(-2)},
+		"a":       map[string]interface{}{"b": map[string]interface{}{"c": "dotted"}},
+		"table":   map[string]interface{}{"sub": map[string]interface{}{"key": "value"}},
+		"items": []interface{}{
+			map[string]interface{}{"name": "first"},
+			map[string]interface{}{"name": "second", "nested": map[string]interface{}{"k": false}},
+		},
+	}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Errorf("parsed %#v\nexpected %#v", tree, expected)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		`a = "unterminated`,
+		"a = 1\na = 2",
+		`a = 1 b = 2`,
+		`a = """multi"""`,
+		`a = 2020-01-01`,
+		`a = 012`,
+		"a = 1\n[a]",
+		`a = [1, 2`,
+		`= 1`,
+		`a = "\x"`,
+	} {
+		if _, err := parseTOML(doc); err == nil {
+			t.Errorf("%q parsed without an error", doc)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stompd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	users := filepath.Join(dir, "users")
+	if err := ioutil.WriteFile(users, []byte("# users\nu1:p:1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	schema := filepath.Join(dir, "order.schema.json")
+	if err := ioutil.WriteFile(schema, []byte(`{"type": "object", "required": ["id"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "stompd.toml")
+	err = ioutil.WriteFile(path, []byte(`
+heart_beat = "30s"
+log_levels = { client = "warning" }
+
+[[listeners]]
+addr = ":61613"
+
+[auth]
+users_file = "`+users+`"
+users = [{ login = "app", passcode = "secret" }]
+
+[[destinations]]
+pattern = "/queue/orders.>"
+dispatch = "broadcast"
+durability = "fsync"
+retain_for = "1h"
+
+[[destinations]]
+pattern = "/queue/invoices"
+payload_schema = "`+schema+`"
+
+[limits]
+topic_slow_consumer = "drop"
+max_memory_bytes = 1024
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := cfg.newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.HeartBeat != 30*time.Second || s.MaxMemoryBytes != 1024 || s.TopicSlowConsumer != server.SlowConsumerDrop {
+		t.Errorf("server settings %v %v %v", s.HeartBeat, s.MaxMemoryBytes, s.TopicSlowConsumer)
+	}
+	validator, _ := server.ParseJSONSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	expected := []server.DestinationPolicy{{
+		Pattern:    "/queue/orders.>",
+		Dispatch:   server.DispatchBroadcast,
+		Durability: server.DurabilityFsync,
+		RetainFor:  time.Hour,
+	}, {
+		Pattern:   "/queue/invoices",
+		Validator: validator,
+	}}
+	if !reflect.DeepEqual(s.Policies, expected) {
+		t.Errorf("policies %+v", s.Policies)
+	}
+	auth := s.Authenticator
+	if !auth.Authenticate("u1", "p:1") || !auth.Authenticate("app", "secret") || auth.Authenticate("app", "p:1") {
+		t.Error("users not authenticated as configured")
+	}
+
+	for _, doc := range []string{
+		`unknown = 1`,
+		`heart_beat = 30`,
+		"[[destinations]]\npattern = \"/queue/a\"\ndispatch = \"random\"",
+		"[persistence]\nbackend = \"tape\"",
+		"[[destinations]]\npattern = \"/queue/a\"\npayload_schema = \"`+schema+`.missing\"",
+	} {
+		if err := ioutil.WriteFile(path, []byte(doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if cfg, err := loadConfig(path); err == nil {
+			if _, err = cfg.newServer(); err == nil {
+				t.Errorf("%q loaded without an error", doc)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// listenOpts returns the server options for the listeners of cfg.
+// Listeners with TLS are opened here, and the others by Start.
+func (cfg *config) listenOpts() ([]func(*server.Server) error, error) {
+	var opts []func(*server.Server) error
+	var opened []net.Listener
+	fail := func(err error) ([]func(*server.Server) error, error) {
+		for _, l := range opened {
+			l.Close()
+		}
+		return nil, err
+	}
+	for _, lc := range cfg.Listeners {
+		protocol := server.Protocol(lc.Protocol)
+		if protocol == "" {
+			protocol = server.ProtocolSTOMP
+		}
+		if lc.TLS == nil {
+			opts = append(opts, server.Opt.Listen(protocol, lc.Addr))
+			continue
+		}
+		config, err := lc.TLS.config()
+		if err != nil {
+			return fail(fmt.Errorf("%s: %s", lc.Addr, err.Error()))
+		}
+		l, err := net.Listen("tcp", lc.Addr)
+		if err != nil {
+			return fail(fmt.Errorf("failed to listen: %s", err.Error()))
+		}
+		opened = append(opened, l)
+		opts = append(opts, server.Opt.Listener(protocol, tls.NewListener(l, config)))
+	}
+	return opts, nil
+}
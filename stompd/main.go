@@ -12,13 +12,17 @@ TODO: Logging options (syslog, windows event log)
 package main
 
 import (
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"log"
-	"net"
+	"net/http"
 	"os"
+	"strings"
 
+	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/server"
+	"github.com/go-stomp/stomp/v3/server/metrics"
 )
 
 // TODO: experimenting with ways to gracefully shutdown the server,
@@ -41,8 +45,18 @@ func main() {
 }
 */
 
-var listenAddr = flag.String("addr", ":61613", "Listen address")
+var configFile = flag.String("config", "", "Configuration file, TOML or JSON if its name ends with .json")
+var listenAddr = flag.String("addr", ":61613", "Listen address, which replaces the listeners of the configuration file")
+var metricsAddr = flag.String("metrics-addr", "", "Listen address for the HTTP /metrics endpoint, disabled if empty")
+var adminAddr = flag.String("admin-addr", "", "Listen address for the HTTP admin API, disabled if empty")
+var adminLogin = flag.String("admin-login", "", "Login required by the admin API, not authenticated if empty")
+var adminPasscode = flag.String("admin-passcode", "", "Passcode required by the admin API")
+var adminDebug = flag.Bool("admin-debug", false, "Serve pprof profiles and expvar variables below /debug/ of the admin API's listener")
+var healthAddr = flag.String("health-addr", "", "Listen address for the HTTP /healthz and /readyz endpoints, disabled if empty")
+var logLevels = flag.String("log-levels", "", "Minimum log levels by component, for example client=warning,mqtt=error")
+var recordDir = flag.String("record-dir", "", "Directory to record the sessions of STOMP clients to, for replaying with stomp-cli replay")
 var helpFlag = flag.Bool("help", false, "Show this help text")
+var versionFlag = flag.Bool("version", false, "Print the version and exit")
 
 func main() {
 	flag.Parse()
@@ -51,13 +65,139 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if *versionFlag {
+		fmt.Println(serverName + "/" + server.Version)
+		return
+	}
+
+	cfg := &config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			log.Fatalf("failed to load configuration: %s", err.Error())
+		}
+	}
+	if err := cfg.applyFlags(); err != nil {
+		log.Fatalf("invalid -log-levels: %s", err.Error())
+	}
 
-	l, err := net.Listen("tcp", *listenAddr)
+	s, err := cfg.newServer()
 	if err != nil {
-		log.Fatalf("failed to listen: %s", err.Error())
+		log.Fatalf("invalid configuration: %s", err.Error())
+	}
+	s.OnReload = func() error { return reload(s) }
+	reloadChannel := make(chan os.Signal, 1)
+	notifyReload(reloadChannel)
+	go func() {
+		for range reloadChannel {
+			if err := s.OnReload(); err != nil {
+				log.Printf("failed to reload configuration: %s", err.Error())
+			} else {
+				log.Println("reloaded configuration")
+			}
+		}
+	}()
+	if cfg.HTTP.MetricsAddr != "" {
+		s.Metrics = metrics.New()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		go func() {
+			log.Fatalf("failed to serve metrics: %s", http.ListenAndServe(cfg.HTTP.MetricsAddr, mux))
+		}()
+	}
+
+	if cfg.HTTP.AdminAddr != "" {
+		var auth server.Authenticator
+		if cfg.HTTP.AdminLogin != "" {
+			auth = adminAuthenticator{cfg.HTTP.AdminLogin, cfg.HTTP.AdminPasscode}
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/api/", http.StripPrefix("/api", s.AdminHandler(auth)))
+		if cfg.HTTP.AdminDebug {
+			mux.Handle("/debug/", s.DebugHandler(auth))
+		}
+		go func() {
+			log.Fatalf("failed to serve admin API: %s", http.ListenAndServe(cfg.HTTP.AdminAddr, mux))
+		}()
+	}
+
+	if cfg.HTTP.HealthAddr != "" {
+		go func() {
+			log.Fatalf("failed to serve health checks: %s", http.ListenAndServe(cfg.HTTP.HealthAddr, s.HealthHandler()))
+		}()
+	}
+
+	if err := s.Start(); err != nil {
+		log.Fatalf("failed to start: %s", err.Error())
 	}
-	defer func() { l.Close() }()
+	for i, addr := range s.Addrs() {
+		protocol := cfg.Listeners[i].Protocol
+		if protocol == "" {
+			protocol = string(server.ProtocolSTOMP)
+		}
+		log.Println("listening on", addr.Network(), addr.String(), "for", protocol)
+	}
+	s.Wait()
+}
+
+// Overrides the configuration with the flags set on the command line.
+// Without a configuration file, the server listens on -addr.
+func (cfg *config) applyFlags() error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Listeners = []listenerConfig{{Addr: *listenAddr}}
+		case "metrics-addr":
+			cfg.HTTP.MetricsAddr = *metricsAddr
+		case "admin-addr":
+			cfg.HTTP.AdminAddr = *adminAddr
+		case "admin-login":
+			cfg.HTTP.AdminLogin = *adminLogin
+		case "admin-passcode":
+			cfg.HTTP.AdminPasscode = *adminPasscode
+		case "admin-debug":
+			cfg.HTTP.AdminDebug = *adminDebug
+		case "health-addr":
+			cfg.HTTP.HealthAddr = *healthAddr
+		case "record-dir":
+			cfg.RecordDir = *recordDir
+		case "log-levels":
+			var levels map[string]string
+			if levels, err = parseLogLevels(*logLevels); err == nil {
+				cfg.LogLevels = levels
+			}
+		}
+	})
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []listenerConfig{{Addr: *listenAddr}}
+	}
+	return err
+}
+
+// Parses a comma-separated list of component=level pairs.
+func parseLogLevels(s string) (map[string]string, error) {
+	levels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("expected component=level, got %q", pair)
+		}
+		if _, err := stomp.ParseLevel(pair[i+1:]); err != nil {
+			return nil, err
+		}
+		levels[pair[:i]] = pair[i+1:]
+	}
+	return levels, nil
+}
+
+// Authenticates requests to the admin API with the login and passcode
+// of the command line.
+type adminAuthenticator struct {
+	login, passcode string
+}
 
-	log.Println("listening on", l.Addr().Network(), l.Addr().String())
-	server.Serve(l)
+func (a adminAuthenticator) Authenticate(login, passcode string) bool {
+	return subtle.ConstantTimeCompare([]byte(login), []byte(a.login)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(passcode), []byte(a.passcode)) == 1
 }
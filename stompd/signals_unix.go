@@ -9,9 +9,11 @@ import (
 // setupStopSignals sets up UNIX-specific signals for terminating
 // the program
 func setupStopSignals(signalChannel chan os.Signal) {
-	// TODO: not sure whether SIGHUP should be used here, only if not in
-	// daemon mode
-	signal.Notify(signalChannel, syscall.SIGHUP)
-
 	signal.Notify(signalChannel, syscall.SIGTERM)
 }
+
+// notifyReload sets up SIGHUP to ask for the configuration to be
+// reloaded.
+func notifyReload(signalChannel chan os.Signal) {
+	signal.Notify(signalChannel, syscall.SIGHUP)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses a TOML document into nested maps, whose values are
+// strings, int64s, float64s, bools, slices and maps. It supports the
+// parts of TOML that configuration files need: tables, arrays of
+// tables, inline tables, dotted keys, arrays, basic and literal
+// strings, integers, floats and booleans. Multi-line strings and dates
+// are not supported.
+func parseTOML(data string) (map[string]interface{}, error) {
+	p := &tomlParser{s: data, root: map[string]interface{}{}}
+	p.table = p.root
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %v", strings.Count(p.s[:p.pos], "\n")+1, err)
+	}
+	return p.root, nil
+}
+
+type tomlParser struct {
+	s     string
+	pos   int
+	root  map[string]interface{}
+	table map[string]interface{} // table of the last table header
+}
+
+func (p *tomlParser) parse() error {
+	for {
+		p.skipSpace(true)
+		if p.pos == len(p.s) {
+			return nil
+		}
+		var err error
+		if p.s[p.pos] == '[' {
+			err = p.parseHeader()
+		} else {
+			err = p.parseKeyValue(p.table)
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// skipSpace skips white space and comments, and newlines if newlines
+// is true.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case newlines && (c == '\n' || c == '\r'):
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+	if p.pos == len(p.s) || p.s[p.pos] == '\n' || strings.HasPrefix(p.s[p.pos:], "\r\n") {
+		return nil
+	}
+	return fmt.Errorf("unexpected %q at end of line", p.s[p.pos])
+}
+
+func (p *tomlParser) expect(s string) error {
+	if !strings.HasPrefix(p.s[p.pos:], s) {
+		return fmt.Errorf("expected %q", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// parseHeader parses a [table] or [[array of tables]] header.
+func (p *tomlParser) parseHeader() error {
+	array := strings.HasPrefix(p.s[p.pos:], "[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	p.skipSpace(false)
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpace(false)
+	if array {
+		err = p.expect("]]")
+	} else {
+		err = p.expect("]")
+	}
+	if err != nil {
+		return err
+	}
+
+	parent, err := p.descend(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if !array {
+		p.table, err = p.descend(parent, keys[len(keys)-1:])
+		return err
+	}
+	tables, ok := parent[last].([]interface{})
+	if _, exists := parent[last]; exists && !ok {
+		return fmt.Errorf("%s is not an array of tables", strings.Join(keys, "."))
+	}
+	p.table = map[string]interface{}{}
+	parent[last] = append(tables, p.table)
+	return nil
+}
+
+// descend returns the table at the path keys below table, creating
+// the tables that do not exist. The last table of an array of tables
+// stands for the array.
+func (p *tomlParser) descend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for i, key := range keys {
+		switch v := table[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			next, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", strings.Join(keys[:i+1], "."))
+			}
+			table = next
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// parseKeyValue parses key = value into table.
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpace(false)
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	p.skipSpace(false)
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	table, err = p.descend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := table[last]; exists {
+		return fmt.Errorf("%s is defined twice", strings.Join(keys, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+// parseKey parses a key, which may be dotted.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		var err error
+		switch {
+		case p.pos == len(p.s):
+			return nil, fmt.Errorf("expected a key")
+		case p.s[p.pos] == '"':
+			key, err = p.parseBasicString()
+		case p.s[p.pos] == '\'':
+			key, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected a key, found %q", p.s[p.pos])
+			}
+			key = p.s[start:p.pos]
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipSpace(false)
+		if p.pos == len(p.s) || p.s[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.skipSpace(false)
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos == len(p.s) {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch c := p.s[p.pos]; {
+	case strings.HasPrefix(p.s[p.pos:], `"""`) || strings.HasPrefix(p.s[p.pos:], "'''"):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case c == '"':
+		return p.parseBasicString()
+	case c == '\'':
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, fmt.Errorf("expected a value, found %q", p.s[p.pos])
+	}
+	if hasLeadingZero(word) {
+		return nil, fmt.Errorf("invalid value %q", word)
+	}
+	if i, err := strconv.ParseInt(word, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(strings.Replace(word, "_", "", -1), 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", word)
+}
+
+// hasLeadingZero returns true for numbers with leading zeros, which
+// TOML does not allow, and which strconv takes to be octal.
+func hasLeadingZero(word string) bool {
+	word = strings.TrimLeft(word, "+-")
+	return len(word) > 1 && word[0] == '0' && word[1] >= '0' && word[1] <= '9'
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos == len(p.s) || p.s[p.pos] == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos == len(p.s) {
+				return "", fmt.Errorf("unterminated string")
+			}
+			e := p.s[p.pos]
+			p.pos++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.s) {
+					return "", fmt.Errorf("invalid escape sequence")
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", fmt.Errorf("invalid escape sequence \\%c%s", e, p.s[p.pos:p.pos+n])
+				}
+				p.pos += n
+				b.WriteRune(rune(r))
+			default:
+				return "", fmt.Errorf("invalid escape sequence \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.s[p.pos:], "'\n")
+	if end < 0 || p.s[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.pos < len(p.s) && p.s[p.pos] == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipSpace(true)
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+		} else if err := p.expect("]"); err != nil {
+			return nil, err
+		} else {
+			return values, nil
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := map[string]interface{}{}
+	p.skipSpace(false)
+	if p.pos < len(p.s) && p.s[p.pos] == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		p.skipSpace(false)
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+		} else if err := p.expect("}"); err != nil {
+			return nil, err
+		} else {
+			return table, nil
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package stomp
 
 import (
+	"strconv"
+
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
@@ -16,8 +18,40 @@ var SubscribeOpt struct {
 	// Header provides the opportunity to include custom header entries
 	// in the SUBSCRIBE frame that the client sends to the server.
 	Header func(key, value string) func(*frame.Frame) error
+
+	// Cursor resumes a subscription to a replayable destination after
+	// the last message committed to the cursor, by setting the "from-seq"
+	// header entry in the SUBSCRIBE frame. If no message has been
+	// committed, the broker's default starting point applies.
+	Cursor func(cursor *Cursor) func(*frame.Frame) error
+
+	// Capacity sets the capacity of the subscription's channel C.
+	// The default capacity is 16.
+	Capacity func(capacity int) func(*frame.Frame) error
+
+	// Overflow sets what the subscription does with a message when
+	// its channel C is full. The default is OverflowBlock.
+	Overflow func(policy OverflowPolicy) func(*frame.Frame) error
+
+	// Durable makes a SUBSCRIBE frame create or resume the durable
+	// subscription with name, see Conn.SubscribeDurable. Passed to
+	// Subscription.Unsubscribe, it asks the broker to remove the
+	// durable subscription instead of just deactivating it.
+	Durable func(name string) func(*frame.Frame) error
 }
 
+// Header entries that carry subscription settings from the option
+// functions to Conn.Subscribe. They are removed before the SUBSCRIBE
+// frame is sent.
+const (
+	capacityHeader = "x-go-stomp-capacity"
+	overflowHeader = "x-go-stomp-overflow"
+)
+
+// defaultSubscriptionCapacity is the capacity of the channel of a
+// subscription if SubscribeOpt.Capacity is not used.
+const defaultSubscriptionCapacity = 16
+
 func init() {
 	SubscribeOpt.Id = func(id string) func(*frame.Frame) error {
 		return func(f *frame.Frame) error {
@@ -39,4 +73,81 @@ func init() {
 			return nil
 		}
 	}
+
+	SubscribeOpt.Cursor = func(cursor *Cursor) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			seq, ok, err := cursor.next()
+			if err != nil {
+				return err
+			}
+			if ok {
+				f.Header.Set(FromSequenceHeader, strconv.FormatUint(seq, 10))
+			}
+			return nil
+		}
+	}
+}
+
+func init() {
+	SubscribeOpt.Capacity = func(capacity int) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			if capacity < 0 {
+				return ErrInvalidCapacity
+			}
+			f.Header.Set(capacityHeader, strconv.Itoa(capacity))
+			return nil
+		}
+	}
+
+	SubscribeOpt.Overflow = func(policy OverflowPolicy) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			if policy < OverflowBlock || policy > OverflowDropOldest {
+				return ErrInvalidOverflowPolicy
+			}
+			f.Header.Set(overflowHeader, strconv.Itoa(int(policy)))
+			return nil
+		}
+	}
+
+	SubscribeOpt.Durable = func(name string) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE &&
+				f.Command != frame.UNSUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			if name == "" {
+				return ErrInvalidDurableName
+			}
+			if f.Command == frame.SUBSCRIBE {
+				f.Header.Set(frame.Id, name)
+			}
+			setDurableHeader(f.Header, name)
+			return nil
+		}
+	}
+}
+
+// subscriptionSettings removes the subscription settings from the
+// header of the SUBSCRIBE frame f and returns them.
+func subscriptionSettings(f *frame.Frame) (capacity int, overflow OverflowPolicy) {
+	capacity = defaultSubscriptionCapacity
+	if value, ok := f.Header.Contains(capacityHeader); ok {
+		capacity, _ = strconv.Atoi(value)
+		f.Header.Del(capacityHeader)
+	}
+	if value, ok := f.Header.Contains(overflowHeader); ok {
+		n, _ := strconv.Atoi(value)
+		overflow = OverflowPolicy(n)
+		f.Header.Del(overflowHeader)
+	}
+	return capacity, overflow
 }
@@ -1,6 +1,7 @@
 package stomp
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -16,10 +17,40 @@ const (
 	subStateClosed  = 2
 )
 
+// OverflowPolicy determines what a subscription does with a message
+// from the server when its channel C is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the client reads from the channel.
+	// While it waits, the client stops reading frames from the server
+	// for all subscriptions of the connection. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest discards the message that does not fit.
+	OverflowDropNewest
+
+	// OverflowDropOldest discards the oldest message in the channel
+	// to make room for the new one.
+	OverflowDropOldest
+)
+
+var overflowPolicyText = []string{"block", "drop-newest", "drop-oldest"}
+
+func (p OverflowPolicy) String() string {
+	if p >= 0 && int(p) < len(overflowPolicyText) {
+		return overflowPolicyText[p]
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
 // The Subscription type represents a client subscription to
 // a destination. The subscription is created by calling Conn.Subscribe.
 //
 // Once a client has subscribed, it can receive messages from the C channel.
+// The capacity of C and what happens when it is full are set with
+// SubscribeOpt.Capacity and SubscribeOpt.Overflow. The channel is closed
+// when the subscription ends, after which Err reports why.
 type Subscription struct {
 	C                         chan *Message
 	id                        string
@@ -32,11 +63,16 @@ type Subscription struct {
 	closeCond                 *sync.Cond
 	closeOnce                 sync.Once
 	unsubscribeReceiptTimeout time.Duration
+	overflow                  OverflowPolicy
+	dropped                   uint64
+	err                       error
+	done                      chan struct{}
 }
 
 // BUG(jpj): If the client does not read messages from the Subscription.C
 // channel quickly enough, the client will stop reading messages from the
-// server.
+// server, unless the subscription has an overflow policy other than
+// OverflowBlock.
 
 // Identification for this subscription. Unique among
 // all subscriptions for the same Client.
@@ -61,6 +97,33 @@ func (s *Subscription) Active() bool {
 	return atomic.LoadInt32(&s.state) == subStateActive
 }
 
+// Done returns a channel that is closed when the subscription ends,
+// either because it was unsubscribed or because of an error. It can be
+// used in a select statement alongside C.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that ended the subscription, such as an ERROR
+// frame from the server or the loss of the connection. It returns nil
+// while the subscription is active, and after it has been unsubscribed.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Dropped returns the number of messages discarded because the channel
+// C was full. Messages are only discarded with the OverflowDropNewest
+// and OverflowDropOldest policies. Discarded messages are not
+// acknowledged.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
 // Unsubscribes and closes the channel C.
 func (s *Subscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
 	// transition to the "closing" state
@@ -68,6 +131,11 @@ func (s *Subscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
 		return ErrCompletedSubscription
 	}
 
+	if err := s.conn.flushAck(context.Background(), s.id); err != nil &&
+		!errors.Is(err, ErrClosedUnexpectedly) {
+		return err
+	}
+
 	f := frame.New(frame.UNSUBSCRIBE, frame.Id, s.id)
 
 	for _, opt := range opts {
@@ -146,17 +214,131 @@ func (s *Subscription) Read() (*Message, error) {
 	return msg, nil
 }
 
+// Listen calls handler for each message received on the subscription,
+// until the subscription ends. The handler is called from as many
+// goroutines as the ListenConcurrency connect option specifies.
+//
+// If the acknowledgement mode of the subscription is AckClient or
+// AckClientIndividual, each message is acknowledged when handler
+// returns, and negatively acknowledged if handler panics. Because
+// AckClient acknowledges all previous messages too, a concurrency
+// greater than 1 should be used with AckClientIndividual.
+//
+// Listen returns when the subscription has ended and all calls of
+// handler have returned. The result is the value of Err. Messages
+// must not be read from the channel C while Listen is running.
+func (s *Subscription) Listen(handler func(*Message)) error {
+	n := s.conn.listenConcurrency
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for msg := range s.C {
+				if msg.Err == nil {
+					s.handle(handler, msg)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return s.Err()
+}
+
+// handle calls handler for msg, and acknowledges msg according to the
+// outcome.
+func (s *Subscription) handle(handler func(*Message), msg *Message) {
+	log := WithFields(s.conn.log, Field{DestinationField, s.destination})
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Subscription %s: %s: handler panic: %v", s.id, s.destination, r)
+			if s.ackMode.ShouldAck() {
+				if err := msg.Nack(); err != nil {
+					log.Errorf("Subscription %s: %s: nack failed: %v", s.id, s.destination, err)
+				}
+			}
+		}
+	}()
+	handler(msg)
+	if s.ackMode.ShouldAck() {
+		if err := msg.Ack(); err != nil {
+			log.Errorf("Subscription %s: %s: ack failed: %v", s.id, s.destination, err)
+		}
+	}
+}
+
 func (s *Subscription) closeChannel(msg *Message) {
 	s.closeOnce.Do(func() {
 		if msg != nil {
-			s.C <- msg
+			s.err = msg.Err
+			if s.overflow == OverflowBlock {
+				s.C <- msg
+			} else {
+				// the final error is delivered at the expense
+				// of older messages; it is also available from Err
+				s.deliverDropOldest(msg)
+			}
 		}
 		atomic.StoreInt32(&s.state, subStateClosed)
+		if s.done != nil {
+			close(s.done)
+		}
 		close(s.C)
 		s.closeCond.Broadcast()
 	})
 }
 
+// deliver sends msg to the channel C according to the overflow policy.
+func (s *Subscription) deliver(msg *Message) {
+	switch s.overflow {
+	case OverflowDropNewest:
+		select {
+		case s.C <- msg:
+		default:
+			s.drop()
+		}
+	case OverflowDropOldest:
+		s.deliverDropOldest(msg)
+	default:
+		s.C <- msg
+	}
+}
+
+// deliverDropOldest sends msg to the channel C, discarding messages
+// from the channel until there is room. If C is unbuffered, msg is
+// discarded when nobody is receiving.
+func (s *Subscription) deliverDropOldest(msg *Message) {
+	if cap(s.C) == 0 {
+		select {
+		case s.C <- msg:
+		default:
+			s.drop()
+		}
+		return
+	}
+	for {
+		select {
+		case s.C <- msg:
+			return
+		default:
+		}
+		select {
+		case <-s.C:
+			s.drop()
+		default:
+		}
+	}
+}
+
+func (s *Subscription) drop() {
+	n := atomic.AddUint64(&s.dropped, 1)
+	WithFields(s.conn.log, Field{DestinationField, s.destination}).Debugf(
+		"Subscription %s: %s: channel full, %d message(s) dropped", s.id, s.destination, n)
+}
+
 func (s *Subscription) subscriptionErrorMessage(message string) *Message {
 	return &Message{
 		Err: &Error{
@@ -180,6 +362,7 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 		if f.Command == frame.MESSAGE {
 			destination := f.Header.Get(frame.Destination)
 			contentType := f.Header.Get(frame.ContentType)
+			span := StartSpan(s.conn.tracer, "receive", f.Header, destination)
 			msg := &Message{
 				Destination:  destination,
 				ContentType:  contentType,
@@ -188,7 +371,8 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 				Header:       f.Header,
 				Body:         f.Body,
 			}
-			s.C <- msg
+			s.deliver(msg)
+			span.End(nil)
 		} else if f.Command == frame.ERROR {
 			state := atomic.LoadInt32(&s.state)
 			if state == subStateActive || state == subStateClosing {
@@ -197,7 +381,7 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 					s.id,
 					s.destination,
 					message)
-				s.conn.log.Info(text)
+				WithFields(s.conn.log, Field{DestinationField, s.destination}).Info(text)
 				contentType := f.Header.Get(frame.ContentType)
 				msg := &Message{
 					Err: &Error{
@@ -220,7 +404,7 @@ func (s *Subscription) readLoop(ch chan *frame.Frame) {
 			}
 			return
 		} else {
-			s.conn.log.Infof("Subscription %s: %s: unsupported frame type: %+v", s.id, s.destination, f)
+			WithFields(s.conn.log, Field{DestinationField, s.destination}).Infof("Subscription %s: %s: unsupported frame type: %+v", s.id, s.destination, f)
 		}
 	}
 }
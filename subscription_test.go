@@ -3,6 +3,7 @@ package stomp
 import (
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/go-stomp/stomp/v3/testutil"
+	"strconv"
 	"sync"
 	"time"
 
@@ -127,3 +128,150 @@ func sendReceiptFrame(frameId int) serverOperation {
 		return f, err
 	}
 }
+
+func (s *StompSuite) Test_subscription_overflow(c *C) {
+	tests := []struct {
+		overflow OverflowPolicy
+		bodies   []string
+		dropped  uint64
+	}{
+		// the final error is delivered at the expense of older messages
+		{OverflowDropNewest, []string{"2"}, 4},
+		{OverflowDropOldest, []string{"5"}, 4},
+	}
+	for _, test := range tests {
+		conn, rw := connectHelper(c, V12)
+		go func() {
+			f, err := rw.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, frame.SUBSCRIBE)
+			_, ok := f.Header.Contains(capacityHeader)
+			c.Check(ok, Equals, false)
+			_, ok = f.Header.Contains(overflowHeader)
+			c.Check(ok, Equals, false)
+			id := f.Header.Get(frame.Id)
+			for i := 1; i <= 5; i++ {
+				m := frame.New(frame.MESSAGE, frame.Subscription, id)
+				m.Body = []byte(strconv.Itoa(i))
+				c.Assert(rw.Write(m), IsNil)
+			}
+			c.Assert(rw.Write(frame.New(frame.ERROR, frame.Message, "failed")), IsNil)
+		}()
+
+		sub, err := conn.Subscribe("/queue/test", AckAuto,
+			SubscribeOpt.Capacity(2), SubscribeOpt.Overflow(test.overflow))
+		c.Assert(err, IsNil)
+		c.Check(cap(sub.C), Equals, 2)
+		c.Check(sub.Err(), IsNil)
+
+		select {
+		case <-sub.Done():
+		case <-time.After(5 * time.Second):
+			c.Fatal("subscription not closed")
+		}
+		c.Check(sub.Active(), Equals, false)
+		c.Check(sub.Err(), ErrorMatches, "failed")
+		c.Check(sub.Dropped(), Equals, test.dropped)
+
+		var bodies []string
+		for msg := range sub.C {
+			if msg.Err != nil {
+				c.Check(msg.Err, ErrorMatches, "failed")
+				continue
+			}
+			bodies = append(bodies, string(msg.Body))
+		}
+		c.Check(bodies, DeepEquals, test.bodies)
+	}
+}
+
+func (s *StompSuite) Test_subscription_options(c *C) {
+	f := frame.New(frame.SUBSCRIBE)
+	c.Check(SubscribeOpt.Capacity(-1)(f), Equals, ErrInvalidCapacity)
+	c.Check(SubscribeOpt.Overflow(OverflowPolicy(3))(f), Equals, ErrInvalidOverflowPolicy)
+	c.Check(SubscribeOpt.Capacity(1)(frame.New(frame.SEND)), Equals, ErrInvalidCommand)
+
+	capacity, overflow := subscriptionSettings(f)
+	c.Check(capacity, Equals, defaultSubscriptionCapacity)
+	c.Check(overflow, Equals, OverflowBlock)
+
+	c.Assert(SubscribeOpt.Capacity(0)(f), IsNil)
+	c.Assert(SubscribeOpt.Overflow(OverflowDropOldest)(f), IsNil)
+	capacity, overflow = subscriptionSettings(f)
+	c.Check(capacity, Equals, 0)
+	c.Check(overflow, Equals, OverflowDropOldest)
+	c.Check(f.Header.Len(), Equals, 0)
+	c.Check(OverflowDropOldest.String(), Equals, "drop-oldest")
+}
+
+func (s *StompSuite) Test_subscription_listen(c *C) {
+	conn, rw := connectHelper(c, V12)
+	go func() {
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		id := f.Header.Get(frame.Id)
+		for i := 1; i <= 3; i++ {
+			c.Assert(rw.Write(frame.New(frame.MESSAGE,
+				frame.Subscription, id,
+				frame.Ack, strconv.Itoa(i))), IsNil)
+		}
+
+		// the handler panics for the second message
+		for _, command := range []string{frame.ACK, frame.NACK, frame.ACK} {
+			f, err = rw.Read()
+			c.Assert(err, IsNil)
+			c.Check(f.Command, Equals, command)
+		}
+		c.Assert(rw.Write(frame.New(frame.ERROR, frame.Message, "failed")), IsNil)
+	}()
+
+	sub, err := conn.Subscribe("/queue/test", AckClientIndividual)
+	c.Assert(err, IsNil)
+	var handled []string
+	err = sub.Listen(func(msg *Message) {
+		handled = append(handled, msg.Header.Get(frame.Ack))
+		if len(handled) == 2 {
+			panic("handler failed")
+		}
+	})
+	c.Check(err, ErrorMatches, "failed")
+	c.Check(handled, DeepEquals, []string{"1", "2", "3"})
+}
+
+func (s *StompSuite) Test_subscription_listen_concurrency(c *C) {
+	conn, rw := connectHelper(c, V12)
+	conn.listenConcurrency = 3
+	go func() {
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		id := f.Header.Get(frame.Id)
+		for i := 1; i <= 3; i++ {
+			c.Assert(rw.Write(frame.New(frame.MESSAGE, frame.Subscription, id)), IsNil)
+		}
+		c.Assert(rw.Write(frame.New(frame.ERROR, frame.Message, "failed")), IsNil)
+	}()
+
+	sub, err := conn.Subscribe("/queue/test", AckAuto)
+	c.Assert(err, IsNil)
+
+	// every handler waits until all three are running
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- sub.Listen(func(msg *Message) {
+			started <- struct{}{}
+			<-release
+		})
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			c.Fatal("handlers are not concurrent")
+		}
+	}
+	close(release)
+	c.Check(<-done, ErrorMatches, "failed")
+}
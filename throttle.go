@@ -0,0 +1,115 @@
+package stomp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second on average, and bursts of
+// up to burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	// allow a burst of up to one second, and at least one event
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// refill adds the tokens accumulated since the last call.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// reserve takes n tokens and returns how long to wait until they are
+// available. Events larger than the burst are allowed, and delay the
+// events that follow them.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns n tokens taken by reserve.
+func (b *tokenBucket) cancel(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// sendLimiter limits the rate of the messages sent on a connection, see
+// ConnOpt.SendRateLimit.
+type sendLimiter struct {
+	mutex    sync.Mutex
+	messages *tokenBucket
+	bytes    *tokenBucket
+}
+
+func newSendLimiter(messagesPerSecond, bytesPerSecond float64) *sendLimiter {
+	if messagesPerSecond <= 0 && bytesPerSecond <= 0 {
+		return nil
+	}
+	return &sendLimiter{
+		messages: newTokenBucket(messagesPerSecond),
+		bytes:    newTokenBucket(bytesPerSecond),
+	}
+}
+
+// wait waits until a message with a body of size bytes can be sent, or
+// until ctx is done.
+func (l *sendLimiter) wait(ctx context.Context, size int) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	delay := l.messages.reserve(now, 1)
+	if d := l.bytes.reserve(now, float64(size)); d > delay {
+		delay = d
+	}
+	l.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// the message is not sent, so others can use its tokens
+		l.mutex.Lock()
+		l.messages.cancel(1)
+		l.bytes.cancel(float64(size))
+		l.mutex.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,60 @@
+package stomp
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_token_bucket(c *C) {
+	b := newTokenBucket(10)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		c.Check(b.reserve(now, 1), Equals, time.Duration(0))
+	}
+	c.Check(b.reserve(now, 1), Equals, 100*time.Millisecond)
+	c.Check(b.reserve(now.Add(time.Second), 1), Equals, time.Duration(0))
+
+	// larger than the burst, so the next events wait
+	b = newTokenBucket(100)
+	c.Check(b.reserve(now, 300), Equals, 2*time.Second)
+	c.Check(b.reserve(now.Add(time.Second), 100), Equals, 2*time.Second)
+
+	c.Check(newTokenBucket(0), IsNil)
+	c.Check(newTokenBucket(0.5).burst, Equals, 1.0)
+}
+
+func (s *StompSuite) Test_send_rate_limit(c *C) {
+	frames := make(chan *frame.Frame, 30)
+	conn, err := Connect(serveInterceptor(c, frames), ConnOpt.SendRateLimit(20, 0))
+	c.Assert(err, IsNil)
+	c.Check((<-frames).Command, Equals, frame.CONNECT)
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		c.Assert(conn.Send("/queue/test", "text/plain", []byte("hello")), IsNil)
+	}
+	// the burst of 20 is sent at once, then 20 per second
+	c.Check(time.Since(start) >= 90*time.Millisecond, Equals, true)
+
+	// waiting stops with the context, and gives back the tokens
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = conn.SendContext(ctx, "/queue/test", "text/plain", []byte("hello"))
+	c.Check(err, Equals, context.DeadlineExceeded)
+	c.Check(conn.sendLimiter.messages.tokens > -1.5, Equals, true)
+
+	c.Assert(conn.Disconnect(), IsNil)
+	for i := 0; i < 22; i++ {
+		c.Check((<-frames).Command, Equals, frame.SEND)
+	}
+}
+
+func (s *StompSuite) Test_send_rate_limit_invalid(c *C) {
+	frames := make(chan *frame.Frame, 10)
+	_, err := Connect(serveInterceptor(c, frames), ConnOpt.SendRateLimit(-1, 0))
+	c.Check(err, Equals, ErrInvalidSendRate)
+}
@@ -0,0 +1,110 @@
+package stomp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	. "gopkg.in/check.v1"
+)
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1
+// that can be used by both servers and clients.
+func newTestCertificate(c *C) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stomp test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serveConnect accepts one connection on l, answers the CONNECT frame,
+// if any, and waits for the connection to close.
+func serveConnect(c *C, l net.Listener) {
+	rw, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer rw.Close()
+	reader := frame.NewReader(rw)
+	writer := frame.NewWriter(rw)
+	f, err := reader.Read()
+	if err != nil {
+		// the client did not complete the handshake
+		return
+	}
+	c.Check(f.Command, Equals, frame.CONNECT)
+	c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2")), IsNil)
+	reader.Read()
+}
+
+func (s *StompSuite) TestDialTLS(c *C) {
+	cert, pool := newTestCertificate(c)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	go serveConnect(c, l)
+
+	conn, err := DialTLS(l.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, ConnOpt.DialTimeout(time.Second))
+	c.Assert(err, IsNil)
+	c.Check(conn.Version(), Equals, V12)
+	conn.MustDisconnect()
+
+	// the server certificate is not trusted by default
+	go serveConnect(c, l)
+	_, err = DialTLS(l.Addr().String(), nil)
+	c.Check(err, NotNil)
+}
+
+type countingDialer struct {
+	net.Dialer
+	count int
+}
+
+func (d *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.count++
+	return d.Dialer.Dial(network, addr)
+}
+
+func (s *StompSuite) TestDialer(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	go serveConnect(c, l)
+
+	dialer := &countingDialer{}
+	conn, err := Dial("tcp", l.Addr().String(), ConnOpt.Dialer(dialer))
+	c.Assert(err, IsNil)
+	conn.MustDisconnect()
+	c.Check(dialer.count, Equals, 1)
+}
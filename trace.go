@@ -0,0 +1,163 @@
+package stomp
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Header entries that carry the context of a trace with a message, in
+// the format of the W3C Trace Context recommendation, so that the flow
+// of a message from producer through server to consumer appears in
+// distributed traces.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// Attributes of the spans started by the client and the server, which
+// follow the OpenTelemetry semantic conventions for messaging systems.
+const (
+	MessagingSystemAttribute      = "messaging.system"
+	MessagingDestinationAttribute = "messaging.destination.name"
+)
+
+// ErrInvalidTraceParent is returned when a "traceparent" header entry
+// cannot be parsed.
+var ErrInvalidTraceParent = errors.New("invalid traceparent header")
+
+// A SpanContext identifies a span of a distributed trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte   // Trace flags, where 1 means that the trace is sampled
+	State   string // Vendor-specific trace state, as in the "tracestate" header
+}
+
+// IsValid reports whether the span context identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the span context in the format of the
+// "traceparent" header entry.
+func (sc SpanContext) TraceParent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" +
+		hex.EncodeToString(sc.SpanID[:]) + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceParent parses the value of a "traceparent" header entry.
+// Values of future versions of the format are accepted, ignoring any
+// fields that they add.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, ErrInvalidTraceParent
+	}
+	version := value[:2]
+	if version == "ff" || (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return sc, ErrInvalidTraceParent
+	}
+	var flags [1]byte
+	for _, field := range []struct {
+		text string
+		b    []byte
+	}{
+		{version, make([]byte, 1)},
+		{value[3:35], sc.TraceID[:]},
+		{value[36:52], sc.SpanID[:]},
+		{value[53:55], flags[:]},
+	} {
+		if !isLowerHex(field.text) {
+			return SpanContext{}, ErrInvalidTraceParent
+		}
+		if _, err := hex.Decode(field.b, []byte(field.text)); err != nil {
+			return SpanContext{}, ErrInvalidTraceParent
+		}
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanContextFromHeader returns the span context carried by the
+// header entries of a frame. It returns an invalid span context if
+// the frame has no valid "traceparent" header entry.
+func SpanContextFromHeader(h *frame.Header) SpanContext {
+	sc, err := ParseTraceParent(h.Get(TraceParentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.State = h.Get(TraceStateHeader)
+	return sc
+}
+
+// SetSpanContext sets the header entries of a frame that carry a span
+// context, replacing any that the frame already has.
+func SetSpanContext(h *frame.Header, sc SpanContext) {
+	h.Set(TraceParentHeader, sc.TraceParent())
+	if sc.State != "" {
+		h.Set(TraceStateHeader, sc.State)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+// A Tracer starts spans, and is provided by the program so that the
+// client and server can record spans with a tracing library such as
+// OpenTelemetry. The tracer of an OpenTelemetry program converts the
+// parent to a trace.SpanContext, starts a span, and converts the
+// context of the span back to a SpanContext.
+type Tracer interface {
+	// Start starts a span with a name and attributes, as a child of
+	// the span identified by parent, or as the root span of a new
+	// trace if parent is not valid.
+	Start(name string, parent SpanContext, attributes map[string]string) Span
+}
+
+// A Span is an operation in a trace, started by a Tracer.
+type Span interface {
+	// SpanContext returns the context that identifies the span.
+	SpanContext() SpanContext
+
+	// End ends the span, recording err if the operation failed.
+	End(err error)
+}
+
+// StartSpan starts a span with the tracer t as a child of the span
+// carried by the header entries of a frame sent to or received from
+// a destination, and replaces the span context in the frame with the
+// context of the new span, so that the next operation on the message
+// becomes its child. If t is nil, a span that does nothing is
+// returned and the frame is unchanged.
+func StartSpan(t Tracer, name string, h *frame.Header, destination string) Span {
+	if t == nil {
+		return noSpan{}
+	}
+	span := t.Start(name, SpanContextFromHeader(h), map[string]string{
+		MessagingSystemAttribute:      "stomp",
+		MessagingDestinationAttribute: destination,
+	})
+	if sc := span.SpanContext(); sc.IsValid() {
+		SetSpanContext(h, sc)
+	}
+	return span
+}
+
+// A span that is started when there is no tracer.
+type noSpan struct{}
+
+func (noSpan) SpanContext() SpanContext { return SpanContext{} }
+func (noSpan) End(err error)            {}
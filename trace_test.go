@@ -0,0 +1,94 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3/frame"
+	. "gopkg.in/check.v1"
+)
+
+type TraceSuite struct{}
+
+var _ = Suite(&TraceSuite{})
+
+func (s *TraceSuite) TestParseTraceParent(c *C) {
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c.Assert(err, IsNil)
+	c.Check(sc.TraceID[0], Equals, byte(0x4b))
+	c.Check(sc.TraceID[15], Equals, byte(0x36))
+	c.Check(sc.SpanID[0], Equals, byte(0x00))
+	c.Check(sc.SpanID[7], Equals, byte(0xb7))
+	c.Check(sc.Flags, Equals, byte(1))
+	c.Check(sc.TraceParent(), Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// fields added by future versions are ignored
+	sc, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	c.Assert(err, IsNil)
+	c.Check(sc.Flags, Equals, byte(0))
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, err := ParseTraceParent(value)
+		c.Check(err, Equals, ErrInvalidTraceParent, Commentf("%q", value))
+	}
+}
+
+func (s *TraceSuite) TestStartSpan(c *C) {
+	h := frame.NewHeader(frame.Destination, "/queue/a")
+	StartSpan(nil, "send", h, "/queue/a").End(nil)
+	_, ok := h.Contains(TraceParentHeader)
+	c.Check(ok, Equals, false)
+
+	t := &testTracer{}
+	StartSpan(t, "send", h, "/queue/a").End(nil)
+	c.Assert(t.spans, HasLen, 1)
+	send := t.spans[0]
+	c.Check(send.parent.IsValid(), Equals, false)
+	c.Check(send.attributes[MessagingSystemAttribute], Equals, "stomp")
+	c.Check(send.attributes[MessagingDestinationAttribute], Equals, "/queue/a")
+	c.Check(send.ended, Equals, true)
+	c.Check(SpanContextFromHeader(h), Equals, send.sc)
+
+	// the next span is a child of the span carried by the frame
+	h.Set(TraceStateHeader, "vendor=value")
+	StartSpan(t, "receive", h, "/queue/a")
+	c.Assert(t.spans, HasLen, 2)
+	receive := t.spans[1]
+	c.Check(receive.parent.SpanID, Equals, send.sc.SpanID)
+	c.Check(receive.parent.State, Equals, "vendor=value")
+	c.Check(receive.sc.TraceID, Equals, send.sc.TraceID)
+	c.Check(h.Get(TraceParentHeader), Equals, receive.sc.TraceParent())
+	c.Check(h.Get(TraceStateHeader), Equals, "vendor=value")
+	c.Check(receive.ended, Equals, false)
+}
+
+// A tracer that records the spans that it starts.
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name       string
+	parent, sc SpanContext
+	attributes map[string]string
+	ended      bool
+}
+
+func (t *testTracer) Start(name string, parent SpanContext, attributes map[string]string) Span {
+	span := &testSpan{name: name, parent: parent, attributes: attributes, sc: parent}
+	if !parent.IsValid() {
+		span.sc.TraceID[0] = byte(len(t.spans) + 1)
+	}
+	span.sc.SpanID[0] = byte(len(t.spans) + 1)
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (s *testSpan) SpanContext() SpanContext { return s.sc }
+func (s *testSpan) End(err error)            { s.ended = true }
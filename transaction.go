@@ -1,6 +1,8 @@
 package stomp
 
 import (
+	"context"
+
 	"github.com/go-stomp/stomp/v3/frame"
 )
 
@@ -120,8 +122,24 @@ func (tx *Transaction) Send(destination, contentType string, body []byte, opts .
 		return err
 	}
 
+	if err := tx.conn.sendLimiter.wait(context.Background(), len(body)); err != nil {
+		return err
+	}
+
 	f.Header.Set(frame.Transaction, tx.id)
-	return tx.conn.sendFrame(f)
+	span := StartSpan(tx.conn.tracer, "send", f.Header, destination)
+	err = tx.conn.sendFrame(f)
+	span.End(err)
+	return err
+}
+
+// SendWithReceipt is like Send, but requests a RECEIPT frame from the
+// server and waits for it, so that a nil error means that the server
+// has received the message. The message is still not processed until
+// the transaction is committed.
+func (tx *Transaction) SendWithReceipt(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	opts = append(opts[:len(opts):len(opts)], SendOpt.Receipt)
+	return tx.Send(destination, contentType, body, opts...)
 }
 
 // Ack sends an acknowledgement for the message to the server. The STOMP
@@ -14,6 +14,23 @@ func NewValidator(version Version) Validator {
 	return validatorNull{}
 }
 
+// NewValidatorMode returns a Validator that validates frames sent with
+// a version of the protocol by calling Frame.Validate in the specified
+// mode. The strict mode enforces STOMP 1.2, so frames of earlier
+// versions are validated leniently whatever the mode.
+func NewValidatorMode(version Version, mode frame.ValidationMode) Validator {
+	if version != V12 {
+		mode = frame.Lenient
+	}
+	return validatorMode(mode)
+}
+
+type validatorMode frame.ValidationMode
+
+func (v validatorMode) Validate(f *frame.Frame) error {
+	return f.Validate(frame.ValidationMode(v))
+}
+
 type validatorNull struct{}
 
 func (v validatorNull) Validate(f *frame.Frame) error {
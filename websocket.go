@@ -0,0 +1,63 @@
+package stomp
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/server/websocket"
+)
+
+// DialWebSocket connects to a STOMP server through a WebSocket endpoint
+// at a ws:// or wss:// URL, and performs the STOMP connect protocol
+// sequence. The STOMP subprotocols requested during the WebSocket
+// handshake follow the AcceptVersion option. The Dialer, DialTimeout,
+// Proxy and TLS options apply to the network connection, and the host name
+// in the URL is the default value of the Host option.
+//
+// stomp.Dial and Failover call DialWebSocket for addresses that are
+// ws:// or wss:// URLs.
+func DialWebSocket(rawurl string, opts ...func(*Conn) error) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	// The options are applied again by Connect; here they are only
+	// needed to set up the WebSocket connection.
+	options, err := newConnOptions(&Conn{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// TLS is set up by the WebSocket dialer for wss URLs
+	d := &websocket.Dialer{
+		NetDial:   options.netDialer().Dial,
+		TLSConfig: options.TLSConfig,
+	}
+	for _, version := range []Version{V12, V11, V10} {
+		for _, accepted := range options.AcceptVersions {
+			if accepted == string(version) {
+				d.Protocols = append(d.Protocols, webSocketProtocol(version))
+			}
+		}
+	}
+
+	c, err := d.Dial(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]func(*Conn) error{ConnOpt.Host(u.Hostname())}, opts...)
+	return Connect(c, opts...)
+}
+
+// webSocketProtocol returns the WebSocket subprotocol for a version
+// of STOMP, such as "v12.stomp".
+func webSocketProtocol(version Version) string {
+	return "v" + strings.Replace(string(version), ".", "", -1) + ".stomp"
+}
+
+// isWebSocketURL reports whether addr is a ws:// or wss:// URL.
+func isWebSocketURL(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
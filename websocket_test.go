@@ -0,0 +1,62 @@
+package stomp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server/websocket"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestWebSocketProtocol(c *C) {
+	c.Check(webSocketProtocol(V12), Equals, "v12.stomp")
+	c.Check(webSocketProtocol(V10), Equals, "v10.stomp")
+}
+
+func (s *StompSuite) TestDialWebSocket(c *C) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stomp", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, []string{"v10.stomp", "v11.stomp", "v12.stomp"})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the client prefers the latest version it accepts
+		c.Check(conn.Protocol(), Equals, "v11.stomp")
+
+		reader := frame.NewReader(conn)
+		writer := frame.NewWriter(conn)
+		f, err := reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		c.Check(f.Header.Get(frame.Host), Equals, "127.0.0.1")
+		c.Check(f.Header.Get(frame.AcceptVersion), Equals, "1.0,1.1")
+		c.Assert(writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.1")), IsNil)
+
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(string(f.Body), Equals, "hello")
+
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		c.Assert(writer.Write(frame.New(frame.RECEIPT,
+			frame.ReceiptId, f.Header.Get(frame.Receipt))), IsNil)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stomp"
+	conn, err := Dial("tcp", url, ConnOpt.AcceptVersion(V10, V11))
+	c.Assert(err, IsNil)
+	c.Check(conn.Version(), Equals, V11)
+	c.Assert(conn.Send("/queue/test", "text/plain", []byte("hello")), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+
+	_, err = DialWebSocket("ws" + strings.TrimPrefix(server.URL, "http") + "/missing")
+	c.Check(err, NotNil)
+}